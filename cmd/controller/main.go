@@ -19,19 +19,41 @@ import (
 	"fmt"
 
 	"github.com/aws/karpenter/pkg/apis"
+	"github.com/aws/karpenter/pkg/audit"
 	"github.com/aws/karpenter/pkg/cloudprovider"
 	cloudprovidermetrics "github.com/aws/karpenter/pkg/cloudprovider/metrics"
 	"github.com/aws/karpenter/pkg/cloudprovider/registry"
 	"github.com/aws/karpenter/pkg/controllers"
+	"github.com/aws/karpenter/pkg/controllers/consolidation"
 	"github.com/aws/karpenter/pkg/controllers/counter"
+	"github.com/aws/karpenter/pkg/controllers/decision"
+	"github.com/aws/karpenter/pkg/controllers/deletion"
+	"github.com/aws/karpenter/pkg/controllers/disruption"
+	"github.com/aws/karpenter/pkg/controllers/drift"
+	"github.com/aws/karpenter/pkg/controllers/health"
 	"github.com/aws/karpenter/pkg/controllers/metrics"
 	"github.com/aws/karpenter/pkg/controllers/node"
 	"github.com/aws/karpenter/pkg/controllers/provisioning"
+	"github.com/aws/karpenter/pkg/controllers/replacement"
+	"github.com/aws/karpenter/pkg/controllers/scheduledcapacity"
 	"github.com/aws/karpenter/pkg/controllers/selection"
+	"github.com/aws/karpenter/pkg/controllers/state"
+	"github.com/aws/karpenter/pkg/controllers/status"
 	"github.com/aws/karpenter/pkg/controllers/termination"
+	"github.com/aws/karpenter/pkg/debug"
+	"github.com/aws/karpenter/pkg/events"
+	karpentermetrics "github.com/aws/karpenter/pkg/metrics"
+	"github.com/aws/karpenter/pkg/tracing"
 	"github.com/aws/karpenter/pkg/utils/injection"
+	karpenterlogging "github.com/aws/karpenter/pkg/utils/logging"
 	"github.com/aws/karpenter/pkg/utils/options"
+	"github.com/aws/karpenter/pkg/utils/settings"
 	"github.com/go-logr/zapr"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/client-go/kubernetes"
@@ -46,6 +68,7 @@ import (
 	"knative.dev/pkg/signals"
 	"knative.dev/pkg/system"
 	controllerruntime "sigs.k8s.io/controller-runtime"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
 )
 
 var (
@@ -73,35 +96,98 @@ func main() {
 	ctx := LoggingContextOrDie(config, clientSet)
 	ctx = injection.WithConfig(ctx, config)
 	ctx = injection.WithOptions(ctx, opts)
+	if opts.AuditSink != "" {
+		sink, err := audit.NewSink(opts.AuditSink)
+		if err != nil {
+			panic(fmt.Sprintf("Unable to audit decisions, %s", err.Error()))
+		}
+		queue := audit.NewQueue(sink)
+		queue.Start(ctx)
+		ctx = audit.WithSink(ctx, queue)
+	}
 
-	// Set up controller runtime controller
-	cloudProvider := registry.NewCloudProvider(ctx, cloudprovider.Options{ClientSet: clientSet})
-	cloudProvider = cloudprovidermetrics.Decorate(cloudProvider)
+	// Set up controller runtime controller. The leader releases its lease when
+	// it stops, so that a standby replica takes over within the retry period
+	// rather than after the lease expires.
+	karpentermetrics.RegisterLeaderElectionMetrics(crmetrics.Registry)
 	manager := controllers.NewManagerOrDie(ctx, config, controllerruntime.Options{
-		Logger:                 zapr.NewLogger(logging.FromContext(ctx).Desugar()),
-		LeaderElection:         true,
-		LeaderElectionID:       "karpenter-leader-election",
-		Scheme:                 scheme,
-		MetricsBindAddress:     fmt.Sprintf(":%d", opts.MetricsPort),
-		HealthProbeBindAddress: fmt.Sprintf(":%d", opts.HealthProbePort),
+		Logger:                        zapr.NewLogger(logging.FromContext(ctx).Desugar()),
+		LeaderElection:                true,
+		LeaderElectionID:              "karpenter-leader-election",
+		LeaderElectionNamespace:       opts.LeaderElectionNamespace,
+		LeaderElectionReleaseOnCancel: true,
+		LeaseDuration:                 &opts.LeaderElectionLeaseDuration,
+		RenewDeadline:                 &opts.LeaderElectionRenewDeadline,
+		RetryPeriod:                   &opts.LeaderElectionRetryPeriod,
+		Scheme:                        scheme,
+		MetricsBindAddress:            fmt.Sprintf(":%d", opts.MetricsPort),
+		HealthProbeBindAddress:        fmt.Sprintf(":%d", opts.HealthProbePort),
 	})
+	if opts.ProvisioningMetrics {
+		karpentermetrics.RegisterProvisioningMetrics(crmetrics.Registry)
+	}
+	if opts.TracingEndpoint != "" {
+		defer ExportSpansOrDie(ctx, opts.TracingEndpoint, opts.TracingSampleRatio)()
+	}
+	cloudProvider := registry.NewCloudProvider(ctx, cloudprovider.Options{ClientSet: clientSet, KubeClient: manager.GetClient()})
+	cloudProviderControllers := registry.NewControllers(ctx, cloudProvider, manager.GetClient())
+	cloudProvider = cloudprovidermetrics.Decorate(cloudProvider)
 
-	provisioningController := provisioning.NewController(ctx, manager.GetClient(), clientSet.CoreV1(), cloudProvider)
+	cluster := state.NewCluster()
+	recorder := events.NewRecorder(manager.GetEventRecorderFor("karpenter"))
+	gatekeeper := disruption.NewGatekeeper(manager.GetClient())
+	provisioningController := provisioning.NewController(ctx, manager.GetClient(), clientSet.CoreV1(), cloudProvider, cluster, recorder)
+	if opts.DebugPort != 0 {
+		if err := manager.Add(debug.NewServer(fmt.Sprintf("localhost:%d", opts.DebugPort), provisioningController, cluster)); err != nil {
+			panic(fmt.Sprintf("Unable to serve debug endpoints, %s", err.Error()))
+		}
+	}
 
-	if err := manager.RegisterControllers(ctx,
+	if err := manager.RegisterControllers(ctx, append([]controllers.Controller{
 		provisioningController,
-		selection.NewController(manager.GetClient(), provisioningController),
+		selection.NewController(manager.GetClient(), provisioningController, recorder),
 		termination.NewController(ctx, manager.GetClient(), clientSet.CoreV1(), cloudProvider),
-		node.NewController(manager.GetClient()),
+		node.NewController(manager.GetClient(), gatekeeper, recorder),
 		metrics.NewController(manager.GetClient(), cloudProvider),
 		counter.NewController(manager.GetClient()),
-	).Start(ctx); err != nil {
+		status.NewController(manager.GetClient(), provisioningController),
+		consolidation.NewController(manager.GetClient(), cloudProvider, cluster, gatekeeper, recorder),
+		drift.NewController(manager.GetClient(), cloudProvider, gatekeeper, recorder),
+		replacement.NewController(manager.GetClient(), provisioningController),
+		deletion.NewController(manager.GetClient(), cloudProvider),
+		scheduledcapacity.NewController(manager.GetClient(), provisioningController, gatekeeper),
+		state.NewController(manager.GetClient(), cluster),
+		decision.NewController(manager.GetClient()),
+		health.NewController(manager.GetClient(), crmetrics.Registry, recorder),
+	}, cloudProviderControllers...)...).Start(ctx); err != nil {
 		panic(fmt.Sprintf("Unable to start manager, %s", err.Error()))
 	}
 }
 
-// LoggingContextOrDie injects a logger into the returned context. The logger is
-// configured by the ConfigMap `config-logging` and live updates the level.
+// ExportSpansOrDie exports the sampled spans of the provisioning pipeline over
+// OTLP to the collector at the endpoint, and registers the tracer provider that
+// records them globally. It returns a func that flushes the remaining spans.
+func ExportSpansOrDie(ctx context.Context, endpoint string, sampleRatio float64) func() {
+	exporter, err := otlptracegrpc.New(ctx, otlptracegrpc.WithInsecure(), otlptracegrpc.WithEndpoint(endpoint))
+	if err != nil {
+		panic(fmt.Sprintf("Unable to export spans, %s", err.Error()))
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(tracing.ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+	return func() {
+		_ = provider.Shutdown(context.Background())
+	}
+}
+
+// LoggingContextOrDie injects a logger and settings into the returned context.
+// The logger is configured by the ConfigMap `config-logging` and live updates
+// the level, and the levels of controllers set by its `loglevel.<controller>`
+// keys. Settings are loaded from the ConfigMap `karpenter-global-settings` and
+// live update too.
 func LoggingContextOrDie(config *rest.Config, clientSet *kubernetes.Clientset) context.Context {
 	ctx, startinformers := knativeinjection.EnableInjectionOrDie(signals.NewContext(), config)
 	logger, atomicLevel := sharedmain.SetupLoggerOrDie(ctx, component)
@@ -109,6 +195,10 @@ func LoggingContextOrDie(config *rest.Config, clientSet *kubernetes.Clientset) c
 	rest.SetDefaultWarningHandler(&logging.WarningHandler{Logger: logger})
 	cmw := informer.NewInformedWatcher(clientSet, system.Namespace())
 	sharedmain.WatchLoggingConfigOrDie(ctx, cmw, logger, atomicLevel, component)
+	karpenterlogging.WatchLevelsOrDie(ctx, cmw, clientSet, logger)
+	store := settings.NewStore(settings.Defaults)
+	store.Watch(ctx, cmw)
+	ctx = injection.WithSettings(ctx, store)
 	if err := cmw.Start(ctx.Done()); err != nil {
 		logger.Fatalf("Failed to watch logging configuration, %s", err.Error())
 	}
@@ -0,0 +1,266 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// kubectl-karpenter is a kubectl plugin that explains Karpenter's decisions
+// with the same library code that the controllers run. Install it by putting
+// the binary on the PATH, then run kubectl karpenter.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/aws/karpenter/pkg/apis"
+	"github.com/aws/karpenter/pkg/cli"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/cloudprovider/registry"
+	"github.com/aws/karpenter/pkg/utils/injection"
+	"github.com/aws/karpenter/pkg/utils/options"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"knative.dev/pkg/logging"
+	"knative.dev/pkg/signals"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const usage = `Explains Karpenter's decisions in the current cluster.
+
+Usage:
+  kubectl karpenter get nodes [--provisioner NAME]
+  kubectl karpenter explain-pending POD [-n NAMESPACE] [--cluster-name NAME]
+  kubectl karpenter simulate -f PODS.yaml [--cluster-name NAME]
+  kubectl karpenter drain-status NODE
+
+Every command accepts --kubeconfig and --context. Explain-pending and simulate
+accept the controller's flags and environment variables to configure the cloud
+provider.
+`
+
+var scheme = runtime.NewScheme()
+
+func init() {
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(apis.AddToScheme(scheme))
+}
+
+// kubeconfig selects the cluster that a command runs against
+type kubeconfig struct {
+	path      string
+	context   string
+	namespace string
+}
+
+func (k *kubeconfig) addFlags(fs *flag.FlagSet) {
+	fs.StringVar(&k.path, "kubeconfig", "", "Path to the kubeconfig file, defaults to $KUBECONFIG or ~/.kube/config")
+	fs.StringVar(&k.context, "context", "", "The kubeconfig context to use, defaults to the current context")
+}
+
+// clientConfig loads the kubeconfig like kubectl does
+func (k *kubeconfig) clientConfig() clientcmd.ClientConfig {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = k.path
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{
+		CurrentContext: k.context,
+		Context:        clientcmdapi.Context{Namespace: k.namespace},
+	})
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	// Only warnings and errors are logged, so that they don't interleave with
+	// the output
+	logger, err := zap.Config{
+		Level:            zap.NewAtomicLevelAt(zap.WarnLevel),
+		Encoding:         "console",
+		EncoderConfig:    zap.NewDevelopmentEncoderConfig(),
+		OutputPaths:      []string{"stderr"},
+		ErrorOutputPaths: []string{"stderr"},
+	}.Build()
+	if err != nil {
+		panic(fmt.Sprintf("Unable to create logger, %s", err.Error()))
+	}
+	ctx := logging.WithLogger(signals.NewContext(), logger.Sugar())
+
+	switch command, args := os.Args[1], os.Args[2:]; command {
+	case "get":
+		err = getNodes(ctx, args)
+	case "explain-pending":
+		err = explainPending(ctx, args)
+	case "simulate":
+		err = simulate(ctx, args)
+	case "drain-status":
+		err = drainStatus(ctx, args)
+	case "help", "-h", "--help":
+		fmt.Fprint(os.Stdout, usage)
+	default:
+		err = fmt.Errorf("unknown command %q", command)
+	}
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+func getNodes(ctx context.Context, args []string) error {
+	if len(args) == 0 || (args[0] != "nodes" && args[0] != "node" && args[0] != "no") {
+		return fmt.Errorf("usage: kubectl karpenter get nodes [--provisioner NAME]")
+	}
+	k := &kubeconfig{}
+	fs := newFlagSet("get nodes", k)
+	provisioner := fs.String("provisioner", "", "Only list the nodes of this provisioner")
+	if _, err := parse(fs, args[1:], 0); err != nil {
+		return err
+	}
+	c, _, err := k.cli()
+	if err != nil {
+		return err
+	}
+	return c.GetNodes(ctx, *provisioner)
+}
+
+func explainPending(ctx context.Context, args []string) error {
+	k := &kubeconfig{}
+	fs := newFlagSet("explain-pending", k)
+	fs.StringVar(&k.namespace, "namespace", "", "The namespace of the pod, defaults to the context's namespace")
+	fs.StringVar(&k.namespace, "n", "", "Shorthand for --namespace")
+	opts := options.Options{}
+	opts.AddFlags(fs)
+	positional, err := parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	if err := opts.Validate(); err != nil {
+		return fmt.Errorf("validating options, %w", err)
+	}
+	c, config, err := k.cli()
+	if err != nil {
+		return err
+	}
+	namespace, _, err := k.clientConfig().Namespace()
+	if err != nil {
+		return fmt.Errorf("getting namespace, %w", err)
+	}
+	// Configure the cloud provider like the controller does
+	ctx = injection.WithOptions(injection.WithConfig(ctx, config), opts)
+	cloudProvider := registry.NewCloudProvider(ctx, cloudprovider.Options{ClientSet: kubernetes.NewForConfigOrDie(config)})
+	return c.ExplainPending(ctx, cloudProvider, namespace, positional[0])
+}
+
+func simulate(ctx context.Context, args []string) error {
+	k := &kubeconfig{}
+	fs := newFlagSet("simulate", k)
+	file := fs.String("f", "", "The file of pods to simulate, or - to read them from stdin")
+	opts := options.Options{}
+	opts.AddFlags(fs)
+	if _, err := parse(fs, args, 0); err != nil {
+		return err
+	}
+	if *file == "" {
+		return fmt.Errorf("usage: kubectl karpenter simulate -f PODS.yaml")
+	}
+	if err := opts.Validate(); err != nil {
+		return fmt.Errorf("validating options, %w", err)
+	}
+	var r io.Reader = os.Stdin
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return fmt.Errorf("opening pods, %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+	pods, err := cli.ReadPods(r)
+	if err != nil {
+		return err
+	}
+	c, config, err := k.cli()
+	if err != nil {
+		return err
+	}
+	// Configure the cloud provider like the controller does
+	ctx = injection.WithOptions(injection.WithConfig(ctx, config), opts)
+	cloudProvider := registry.NewCloudProvider(ctx, cloudprovider.Options{ClientSet: kubernetes.NewForConfigOrDie(config)})
+	return c.Simulate(ctx, cloudProvider, pods)
+}
+
+func drainStatus(ctx context.Context, args []string) error {
+	k := &kubeconfig{}
+	positional, err := parse(newFlagSet("drain-status", k), args, 1)
+	if err != nil {
+		return err
+	}
+	c, _, err := k.cli()
+	if err != nil {
+		return err
+	}
+	return c.DrainStatus(ctx, positional[0])
+}
+
+// cli connects to the cluster
+func (k *kubeconfig) cli() (*cli.CLI, *rest.Config, error) {
+	config, err := k.clientConfig().ClientConfig()
+	if err != nil {
+		return nil, nil, fmt.Errorf("loading kubeconfig, %w", err)
+	}
+	config.UserAgent = "kubectl-karpenter"
+	kubeClient, err := client.New(config, client.Options{Scheme: scheme})
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating kube client, %w", err)
+	}
+	return cli.New(kubeClient, os.Stdout), config, nil
+}
+
+func newFlagSet(name string, k *kubeconfig) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	k.addFlags(fs)
+	return fs
+}
+
+// parse parses flags before and after the positional arguments, like kubectl
+// does, and returns exactly the expected number of positional arguments
+func parse(fs *flag.FlagSet, args []string, expected int) ([]string, error) {
+	positional := []string{}
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if len(positional) != expected {
+		return nil, fmt.Errorf("%s expects %d argument(s), got %d", fs.Name(), expected, len(positional))
+	}
+	return positional, nil
+}
@@ -16,12 +16,17 @@ package main
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/aws/karpenter/pkg/apis"
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1beta1"
 	"github.com/aws/karpenter/pkg/cloudprovider"
 	"github.com/aws/karpenter/pkg/cloudprovider/registry"
 	"github.com/aws/karpenter/pkg/utils/injection"
 	"github.com/aws/karpenter/pkg/utils/options"
+	"github.com/aws/karpenter/pkg/utils/settings"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/kubernetes"
 	"knative.dev/pkg/configmap"
 	"knative.dev/pkg/controller"
@@ -33,12 +38,20 @@ import (
 	"knative.dev/pkg/webhook"
 	"knative.dev/pkg/webhook/certificates"
 	"knative.dev/pkg/webhook/configmaps"
+	"knative.dev/pkg/webhook/resourcesemantics"
+	"knative.dev/pkg/webhook/resourcesemantics/conversion"
 	"knative.dev/pkg/webhook/resourcesemantics/defaulting"
 	"knative.dev/pkg/webhook/resourcesemantics/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 var (
 	opts = options.MustParse()
+	// resources are Karpenter's own resources, and the cloud provider's
+	resources = map[schema.GroupVersionKind]resourcesemantics.GenericCRD{}
+	// kubeClient reads the resources that validation depends on, e.g. the
+	// nodes of a provisioner
+	kubeClient client.Client
 )
 
 func main() {
@@ -48,15 +61,26 @@ func main() {
 		ServiceName: "karpenter-webhook",
 		SecretName:  "karpenter-webhook-cert",
 	})
+	var err error
+	if kubeClient, err = client.New(config, client.Options{}); err != nil {
+		panic(fmt.Sprintf("Unable to create kube client, %s", err.Error()))
+	}
 
 	// Register the cloud provider to attach vendor specific validation logic.
-	registry.NewCloudProvider(ctx, cloudprovider.Options{ClientSet: kubernetes.NewForConfigOrDie(config)})
+	cloudProvider := registry.NewCloudProvider(injection.WithConfig(InjectContext(ctx), config), cloudprovider.Options{ClientSet: kubernetes.NewForConfigOrDie(config)})
+	for gvk, resource := range apis.Resources {
+		resources[gvk] = resource
+	}
+	for gvk, resource := range registry.NewResources(cloudProvider) {
+		resources[gvk] = resource
+	}
 
 	// Controllers and webhook
 	sharedmain.MainWithConfig(ctx, "webhook", config,
 		certificates.NewController,
 		newCRDDefaultingWebhook,
 		newCRDValidationWebhook,
+		newCRDConversionWebhook,
 		newConfigValidationController,
 	)
 }
@@ -65,7 +89,7 @@ func newCRDDefaultingWebhook(ctx context.Context, w configmap.Watcher) *controll
 	return defaulting.NewAdmissionController(ctx,
 		"defaulting.webhook.provisioners.karpenter.sh",
 		"/default-resource",
-		apis.Resources,
+		resources,
 		InjectContext,
 		true,
 	)
@@ -75,22 +99,40 @@ func newCRDValidationWebhook(ctx context.Context, w configmap.Watcher) *controll
 	return validation.NewAdmissionController(ctx,
 		"validation.webhook.provisioners.karpenter.sh",
 		"/validate-resource",
-		apis.Resources,
+		resources,
 		InjectContext,
 		true,
 	)
 }
 
+func newCRDConversionWebhook(ctx context.Context, w configmap.Watcher) *controller.Impl {
+	return conversion.NewConversionController(ctx,
+		"/resource-conversion",
+		map[schema.GroupKind]conversion.GroupKindConversion{
+			v1beta1.SchemeGroupVersion.WithKind("Provisioner").GroupKind(): {
+				DefinitionName: "provisioners.karpenter.sh",
+				HubVersion:     v1beta1.SchemeGroupVersion.Version,
+				Zygotes: map[string]conversion.ConvertibleObject{
+					v1alpha5.SchemeGroupVersion.Version: &v1alpha5.Provisioner{},
+					v1beta1.SchemeGroupVersion.Version:  &v1beta1.Provisioner{},
+				},
+			},
+		},
+		InjectContext,
+	)
+}
+
 func newConfigValidationController(ctx context.Context, cmw configmap.Watcher) *controller.Impl {
 	return configmaps.NewAdmissionController(ctx,
 		"validation.webhook.config.karpenter.sh",
 		"/config-validation",
 		configmap.Constructors{
 			logging.ConfigMapName(): logging.NewConfigFromConfigMap,
+			settings.ConfigMapName:  settings.NewSettingsFromConfigMap,
 		},
 	)
 }
 
 func InjectContext(ctx context.Context) context.Context {
-	return injection.WithOptions(ctx, opts)
+	return injection.WithKubeClient(injection.WithOptions(ctx, opts), kubeClient)
 }
@@ -17,6 +17,7 @@ package apis
 
 import (
 	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1beta1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"knative.dev/pkg/webhook/resourcesemantics"
@@ -26,11 +27,14 @@ var (
 	// Builder includes all types within the apis package
 	Builder = runtime.NewSchemeBuilder(
 		v1alpha5.SchemeBuilder.AddToScheme,
+		v1beta1.SchemeBuilder.AddToScheme,
 	)
 	// AddToScheme may be used to add all resources defined in the project to a Scheme
 	AddToScheme = Builder.AddToScheme
 	// Resources defined in the project
 	Resources = map[schema.GroupVersionKind]resourcesemantics.GenericCRD{
-		v1alpha5.SchemeGroupVersion.WithKind("Provisioner"): &v1alpha5.Provisioner{},
+		v1alpha5.SchemeGroupVersion.WithKind("Provisioner"):       &v1alpha5.Provisioner{},
+		v1beta1.SchemeGroupVersion.WithKind("Provisioner"):        &v1beta1.Provisioner{},
+		v1alpha5.SchemeGroupVersion.WithKind("ScheduledCapacity"): &v1alpha5.ScheduledCapacity{},
 	}
 )
@@ -0,0 +1,53 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha5
+
+import (
+	"knative.dev/pkg/apis"
+)
+
+// Consolidation configures replacing the provisioner's underutilized nodes,
+// either by moving their pods to other nodes or to a single cheaper node
+type Consolidation struct {
+	// Enabled consolidates the provisioner's nodes. Consolidation also requires
+	// the ConsolidationPolicy feature gate to be enabled.
+	// +optional
+	Enabled *bool `json:"enabled,omitempty"`
+	// MaxUnavailable is the number of the provisioner's nodes that may be
+	// terminating at once, for any reason, before consolidation stops
+	// terminating more. Defaults to 1.
+	// +optional
+	MaxUnavailable *int32 `json:"maxUnavailable,omitempty"`
+}
+
+// IsEnabled returns true if the provisioner's nodes are consolidated
+func (c *Consolidation) IsEnabled() bool {
+	return c.Enabled != nil && *c.Enabled
+}
+
+// GetMaxUnavailable returns the number of nodes that may be terminating at once
+func (c *Consolidation) GetMaxUnavailable() int32 {
+	if c.MaxUnavailable == nil {
+		return 1
+	}
+	return *c.MaxUnavailable
+}
+
+func (c *Consolidation) validate() (errs *apis.FieldError) {
+	if c.MaxUnavailable != nil && *c.MaxUnavailable < 1 {
+		errs = errs.Also(apis.ErrInvalidValue("must be at least 1", "maxUnavailable"))
+	}
+	return errs
+}
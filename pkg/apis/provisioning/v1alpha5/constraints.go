@@ -17,6 +17,7 @@ package v1alpha5
 import (
 	"fmt"
 
+	"go.uber.org/multierr"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 )
@@ -26,12 +27,25 @@ type Constraints struct {
 	// Labels are layered with Requirements and applied to every node.
 	//+optional
 	Labels map[string]string `json:"labels,omitempty"`
+	// LabelTemplates are rendered for each pod and applied to the node that
+	// the pod is launched on, e.g. team: "{{ .Namespace.Labels.team }}". They
+	// are Go templates of the pod and its namespace. Pods are only launched on
+	// the same node as pods whose templates render the same labels. Labels
+	// that render empty are omitted.
+	// +optional
+	LabelTemplates map[string]string `json:"labelTemplates,omitempty"`
 	// Taints will be applied to every node launched by the Provisioner. If
 	// specified, the provisioner will not provision nodes for pods that do not
 	// have matching tolerations. Additional taints will be created that match
 	// pod tolerations on a per-node basis.
 	// +optional
 	Taints Taints `json:"taints,omitempty"`
+	// StartupTaints will be applied to every node launched by the Provisioner,
+	// and are expected to be removed by another agent once the node starts,
+	// e.g. when a CNI or CSI driver is ready. Unlike Taints, pods are not
+	// required to tolerate them.
+	// +optional
+	StartupTaints Taints `json:"startupTaints,omitempty"`
 	// Requirements are layered with Labels and applied to every node.
 	Requirements Requirements `json:"requirements,omitempty"`
 	// KubeletConfiguration are options passed to the kubelet when provisioning nodes
@@ -40,6 +54,24 @@ type Constraints struct {
 	// Provider contains fields specific to your cloudprovider.
 	// +kubebuilder:pruning:PreserveUnknownFields
 	Provider *runtime.RawExtension `json:"provider,omitempty"`
+	// ProviderRef references a cloud provider specific resource that contains
+	// the fields of Provider, e.g. an AWSNodeTemplate, so that they are
+	// configured and validated separately from the provisioner. It may not be
+	// specified with Provider.
+	// +optional
+	ProviderRef *ProviderRef `json:"providerRef,omitempty"`
+}
+
+// ProviderRef references a cloud provider specific resource by its kind and
+// name. The resource is cluster scoped.
+type ProviderRef struct {
+	// APIVersion of the referenced resource, e.g. karpenter.k8s.aws/v1alpha1.
+	// +optional
+	APIVersion string `json:"apiVersion,omitempty"`
+	// Kind of the referenced resource.
+	Kind string `json:"kind"`
+	// Name of the referenced resource.
+	Name string `json:"name"`
 }
 
 // ValidatePod returns an error if the pod's requirements are not met by the constraints
@@ -48,16 +80,68 @@ func (c *Constraints) ValidatePod(pod *v1.Pod) error {
 	if err := c.Taints.Tolerates(pod); err != nil {
 		return err
 	}
-	// The constraints do not support this requirement
-	podRequirements := PodRequirements(pod)
+	_, err := c.PodRequirements(pod)
+	return err
+}
+
+// PodRequirements returns the pod's requirements for whichever of its required
+// node affinity terms allows the most combinations of well known labels, of
+// the terms that the constraints can satisfy. Ties go to the earliest term.
+// The scheduler selects the term that is cheapest to launch if the instance
+// types are known, and the broadest term otherwise.
+func (c *Constraints) PodRequirements(pod *v1.Pod) (Requirements, error) {
+	alternatives, err := c.CompatiblePodRequirements(pod)
+	if err != nil {
+		return nil, err
+	}
+	var result Requirements
+	best := float64(-1)
+	for _, podRequirements := range alternatives {
+		if breadth := c.Requirements.With(podRequirements).breadth(); breadth > best {
+			result, best = podRequirements, breadth
+		}
+	}
+	return result, nil
+}
+
+// CompatiblePodRequirements returns the pod's requirements for each of its
+// required node affinity terms that the constraints can satisfy, in order, or
+// an error if the constraints can't satisfy any of them.
+func (c *Constraints) CompatiblePodRequirements(pod *v1.Pod) ([]Requirements, error) {
+	var alternatives []Requirements
+	var errs error
+	for _, podRequirements := range PodRequirementAlternatives(pod) {
+		if err := c.validatePodRequirements(podRequirements); err != nil {
+			errs = multierr.Append(errs, err)
+			continue
+		}
+		alternatives = append(alternatives, podRequirements)
+	}
+	if len(alternatives) == 0 {
+		return nil, errs
+	}
+	return alternatives, nil
+}
+
+// validatePodRequirements returns an error if the requirements are not met by the constraints
+func (c *Constraints) validatePodRequirements(podRequirements Requirements) error {
+	keys := []string{}
 	for _, key := range podRequirements.Keys() {
+		// Nodes won't have labels that the constraints don't constrain
+		if c.Requirements.Requirement(key) == nil && podRequirements.onlyOperator(key, v1.NodeSelectorOpDoesNotExist) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	// The constraints do not support this requirement
+	for _, key := range keys {
 		if c.Requirements.Requirement(key).Len() == 0 {
 			return fmt.Errorf("invalid nodeSelector %q, %v not in %v", key, podRequirements.Requirement(key).UnsortedList(), c.Requirements.Requirement(key).UnsortedList())
 		}
 	}
 	// The combined requirements are not compatible
 	combined := c.Requirements.With(podRequirements)
-	for _, key := range podRequirements.Keys() {
+	for _, key := range keys {
 		if combined.Requirement(key).Len() == 0 {
 			return fmt.Errorf("invalid nodeSelector %q, %v not in %v", key, podRequirements.Requirement(key).UnsortedList(), c.Requirements.Requirement(key).UnsortedList())
 		}
@@ -66,11 +150,22 @@ func (c *Constraints) ValidatePod(pod *v1.Pod) error {
 }
 
 func (c *Constraints) Tighten(pod *v1.Pod) *Constraints {
+	// Pods are validated before they are tightened
+	podRequirements, _ := c.PodRequirements(pod)
+	return c.TightenWith(pod, podRequirements)
+}
+
+// TightenWith tightens the constraints by the pod's requirements for one of
+// its required node affinity terms, and by its preferences.
+func (c *Constraints) TightenWith(pod *v1.Pod, podRequirements Requirements) *Constraints {
 	return &Constraints{
 		Labels:               c.Labels,
-		Requirements:         c.Requirements.With(PodRequirements(pod)).Consolidate().WellKnown(),
+		LabelTemplates:       c.LabelTemplates,
+		Requirements:         c.Requirements.With(podRequirements).WithPreferences(pod).Consolidate().WellKnown(),
 		Taints:               c.Taints,
+		StartupTaints:        c.StartupTaints,
 		Provider:             c.Provider,
+		ProviderRef:          c.ProviderRef,
 		KubeletConfiguration: c.KubeletConfiguration,
 	}
 }
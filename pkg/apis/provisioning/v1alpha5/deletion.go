@@ -0,0 +1,62 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha5
+
+import (
+	"knative.dev/pkg/apis"
+)
+
+// DeletionPolicy determines what happens to the provisioner's nodes when it's
+// deleted
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyDrain deletes the nodes, which cordons and drains them
+	// before their instances are terminated
+	DeletionPolicyDrain DeletionPolicy = "Drain"
+	// DeletionPolicyOrphan leaves the nodes running, no longer managed by
+	// Karpenter
+	DeletionPolicyOrphan DeletionPolicy = "Orphan"
+	// DeletionPolicyTerminate terminates the instances of the nodes without
+	// draining them
+	DeletionPolicyTerminate DeletionPolicy = "Terminate"
+)
+
+// DeletionPolicies are the deletion policies that a provisioner may use
+var DeletionPolicies = []DeletionPolicy{
+	DeletionPolicyDrain,
+	DeletionPolicyOrphan,
+	DeletionPolicyTerminate,
+}
+
+// GetDeletionPolicy returns the deletion policy, which defaults to Drain
+func (s *ProvisionerSpec) GetDeletionPolicy() DeletionPolicy {
+	if s.DeletionPolicy == "" {
+		return DeletionPolicyDrain
+	}
+	return s.DeletionPolicy
+}
+
+func (s *ProvisionerSpec) validateDeletionPolicy() (errs *apis.FieldError) {
+	if s.DeletionPolicy == "" {
+		return errs
+	}
+	for _, policy := range DeletionPolicies {
+		if s.DeletionPolicy == policy {
+			return errs
+		}
+	}
+	return errs.Also(apis.ErrInvalidValue(s.DeletionPolicy, "deletionPolicy"))
+}
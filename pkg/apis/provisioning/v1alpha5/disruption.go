@@ -0,0 +1,185 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha5
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"knative.dev/pkg/apis"
+)
+
+// Disruption limits the voluntary disruption of the provisioner's nodes, i.e.
+// their termination by expiration, emptiness, drift or consolidation. Nodes
+// that are terminated for other reasons, e.g. spot interruptions or
+// `kubectl delete node`, aren't limited, but count against the budgets while
+// they terminate.
+type Disruption struct {
+	// Budgets limit how many of the provisioner's nodes may be terminating
+	// when a node is voluntarily disrupted. The most restrictive of the active
+	// budgets applies. If none are active, disruption isn't limited.
+	// +optional
+	Budgets []Budget `json:"budgets,omitempty"`
+}
+
+// Budget limits the number of the provisioner's nodes that may be terminating
+// at once, while it's active
+type Budget struct {
+	// Nodes is the number of nodes, e.g. 5, or the percentage of the
+	// provisioner's nodes, e.g. "10%", rounded up. A budget of 0 blocks
+	// voluntary disruption while it's active.
+	// +kubebuilder:validation:XIntOrString
+	Nodes intstr.IntOrString `json:"nodes"`
+	// Schedule restricts the budget to a recurring window. If unset, the
+	// budget is always active.
+	// +optional
+	Schedule *Schedule `json:"schedule,omitempty"`
+}
+
+// Schedule is a window of time that recurs on days of the week
+type Schedule struct {
+	// Days of the week that the window starts on, e.g. Saturday. Defaults to
+	// every day.
+	// +optional
+	Days []string `json:"days,omitempty"`
+	// Start is the time of day, in UTC, that the window starts, e.g. 09:00
+	Start string `json:"start"`
+	// Duration of the window, which may extend into the following days
+	Duration metav1.Duration `json:"duration"`
+}
+
+const scheduleStartLayout = "15:04"
+
+// Allowed returns the number of the provisioner's nodes that may be
+// terminating at the time for the provisioner to voluntarily disrupt another,
+// given its current number of nodes
+func (d *Disruption) Allowed(now time.Time, nodes int) int {
+	allowed := math.MaxInt32
+	for i := range d.Budgets {
+		if !d.Budgets[i].IsActive(now) {
+			continue
+		}
+		if budget := d.Budgets[i].allowed(nodes); budget < allowed {
+			allowed = budget
+		}
+	}
+	return allowed
+}
+
+// IsActive returns true if the budget applies at the time
+func (b *Budget) IsActive(now time.Time) bool {
+	if b.Schedule == nil {
+		return true
+	}
+	return b.Schedule.contains(now)
+}
+
+func (b *Budget) allowed(nodes int) int {
+	allowed, err := intstr.GetScaledValueFromIntOrPercent(&b.Nodes, nodes, true)
+	if err != nil {
+		// Invalid budgets are rejected by validation, but block disruption
+		// rather than allowing it if they get through
+		return 0
+	}
+	return allowed
+}
+
+// contains returns true if a window that started on one of the previous days
+// hasn't ended at the time
+func (s *Schedule) contains(now time.Time) bool {
+	start, err := time.Parse(scheduleStartLayout, s.Start)
+	if err != nil {
+		return false
+	}
+	now = now.UTC()
+	for days := 0; days <= int(s.Duration.Duration/(24*time.Hour))+1; days++ {
+		day := now.AddDate(0, 0, -days)
+		if !s.startsOn(day.Weekday()) {
+			continue
+		}
+		windowStart := time.Date(day.Year(), day.Month(), day.Day(), start.Hour(), start.Minute(), 0, 0, time.UTC)
+		if !now.Before(windowStart) && now.Before(windowStart.Add(s.Duration.Duration)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Schedule) startsOn(weekday time.Weekday) bool {
+	if len(s.Days) == 0 {
+		return true
+	}
+	for _, day := range s.Days {
+		if strings.EqualFold(day, weekday.String()) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Disruption) validate() (errs *apis.FieldError) {
+	for i := range d.Budgets {
+		errs = errs.Also(d.Budgets[i].validate().ViaFieldIndex("budgets", i))
+	}
+	return errs
+}
+
+func (b *Budget) validate() (errs *apis.FieldError) {
+	switch b.Nodes.Type {
+	case intstr.Int:
+		if b.Nodes.IntVal < 0 {
+			errs = errs.Also(apis.ErrInvalidValue("must not be negative", "nodes"))
+		}
+	case intstr.String:
+		// Scaling a percentage to 100 returns the percentage
+		if percent, err := intstr.GetScaledValueFromIntOrPercent(&b.Nodes, 100, true); err != nil {
+			errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%s must be a number or a percentage", b.Nodes.StrVal), "nodes"))
+		} else if percent < 0 || percent > 100 {
+			errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%s must be between 0%% and 100%%", b.Nodes.StrVal), "nodes"))
+		}
+	}
+	if b.Schedule != nil {
+		errs = errs.Also(b.Schedule.validate().ViaField("schedule"))
+	}
+	return errs
+}
+
+func (s *Schedule) validate() (errs *apis.FieldError) {
+	if _, err := time.Parse(scheduleStartLayout, s.Start); err != nil {
+		errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%s must be a time of day, e.g. 09:00", s.Start), "start"))
+	}
+	if s.Duration.Duration <= 0 {
+		errs = errs.Also(apis.ErrInvalidValue("must be positive", "duration"))
+	}
+	for i, day := range s.Days {
+		if !isWeekday(day) {
+			errs = errs.Also(apis.ErrInvalidArrayValue(fmt.Sprintf("%s must be a day of the week, e.g. Saturday", day), "days", i))
+		}
+	}
+	return errs
+}
+
+func isWeekday(day string) bool {
+	for weekday := time.Sunday; weekday <= time.Saturday; weekday++ {
+		if strings.EqualFold(day, weekday.String()) {
+			return true
+		}
+	}
+	return false
+}
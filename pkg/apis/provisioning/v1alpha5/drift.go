@@ -0,0 +1,45 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha5
+
+import (
+	"knative.dev/pkg/apis"
+)
+
+// Drift configures replacing the provisioner's nodes that no longer match what
+// it would launch, e.g. because its spec or the image of its nodes changed.
+// Drifted nodes are only replaced if the Drift feature gate is enabled.
+type Drift struct {
+	// MaxUnavailable is the number of the provisioner's nodes that may be
+	// terminating at once, for any reason, before drifted nodes stop being
+	// replaced. Defaults to 1.
+	// +optional
+	MaxUnavailable *int32 `json:"maxUnavailable,omitempty"`
+}
+
+// GetMaxUnavailable returns the number of nodes that may be terminating at once
+func (d *Drift) GetMaxUnavailable() int32 {
+	if d.MaxUnavailable == nil {
+		return 1
+	}
+	return *d.MaxUnavailable
+}
+
+func (d *Drift) validate() (errs *apis.FieldError) {
+	if d.MaxUnavailable != nil && *d.MaxUnavailable < 1 {
+		errs = errs.Also(apis.ErrInvalidValue("must be at least 1", "maxUnavailable"))
+	}
+	return errs
+}
@@ -0,0 +1,56 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha5
+
+import (
+	"hash/fnv"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"knative.dev/pkg/apis"
+
+	"github.com/aws/karpenter/pkg/utils/ptr"
+)
+
+// MaxExpirationJitterPercent is the largest jitter of expiration, so that no
+// node expires at less than half of the TTL
+const MaxExpirationJitterPercent = 50
+
+// ExpirationTTL returns how long after its creation the node expires, or false
+// if nodes don't expire. The TTL is jittered by up to ExpirationJitterPercent,
+// by an amount that is derived from the node's UID so that it's the same every
+// time it's computed for the node.
+func (s *ProvisionerSpec) ExpirationTTL(node *v1.Node) (time.Duration, bool) {
+	if s.TTLSecondsUntilExpired == nil {
+		return 0, false
+	}
+	ttl := time.Duration(ptr.Int64Value(s.TTLSecondsUntilExpired)) * time.Second
+	percent := ptr.Int32Value(s.ExpirationJitterPercent)
+	if percent <= 0 {
+		return ttl, true
+	}
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(node.UID))
+	// Uniformly distributed in [-1, 1]
+	jitter := float64(hash.Sum64()%20001)/10000 - 1
+	return (ttl + time.Duration(jitter*float64(percent)/100*float64(ttl))).Truncate(time.Second), true
+}
+
+func (s *ProvisionerSpec) validateExpirationJitterPercent() (errs *apis.FieldError) {
+	if percent := ptr.Int32Value(s.ExpirationJitterPercent); percent < 0 || percent > MaxExpirationJitterPercent {
+		return errs.Also(apis.ErrOutOfBoundsValue(percent, 0, MaxExpirationJitterPercent, "expirationJitterPercent"))
+	}
+	return errs
+}
@@ -0,0 +1,93 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha5
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/apis"
+)
+
+// KarpenterStatusName is the name of the KarpenterStatus that the controller
+// reports its health on
+const KarpenterStatusName = "karpenter"
+
+// ControllerHealth summarizes a controller's reconciles over the health window
+type ControllerHealth struct {
+	// Name is the name of the controller.
+	Name string `json:"name"`
+	// Reconciles is the number of reconciles in the window.
+	Reconciles int64 `json:"reconciles"`
+	// Errors is the number of reconciles in the window that failed.
+	// +optional
+	Errors int64 `json:"errors,omitempty"`
+	// SlowReconciles is the number of reconciles in the window that took
+	// longer than the slow reconcile threshold.
+	// +optional
+	SlowReconciles int64 `json:"slowReconciles,omitempty"`
+	// Degraded explains how the controller breached a threshold, if it did.
+	// +optional
+	Degraded string `json:"degraded,omitempty"`
+}
+
+// KarpenterStatusStatus reports the health of Karpenter's controllers
+type KarpenterStatusStatus struct {
+	// Controllers are the controllers that reconciled in the health window,
+	// sorted by name.
+	// +optional
+	Controllers []ControllerHealth `json:"controllers,omitempty"`
+	// Conditions of Karpenter: Ready and Degraded. Karpenter is Degraded, and
+	// not Ready, while any controller's error rate or reconcile latency
+	// breaches its threshold.
+	// +optional
+	Conditions apis.Conditions `json:"conditions,omitempty"`
+}
+
+// KarpenterStatus is the Schema for the KarpenterStatuses API. It's a
+// singleton named karpenter that the controller reports its health on, so that
+// it can be checked in the cluster without external monitoring.
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:path=karpenterstatuses,scope=Cluster
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status"
+// +kubebuilder:printcolumn:name="Reason",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].reason"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+type KarpenterStatus struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Status KarpenterStatusStatus `json:"status,omitempty"`
+}
+
+// KarpenterStatusList contains a list of KarpenterStatus
+// +kubebuilder:object:root=true
+type KarpenterStatusList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []KarpenterStatus `json:"items"`
+}
+
+// StatusConditions manages Ready, which the health controller sets as the
+// inverse of Degraded
+func (k *KarpenterStatus) StatusConditions() apis.ConditionManager {
+	return apis.NewLivingConditionSet().Manage(k)
+}
+
+func (k *KarpenterStatus) GetConditions() apis.Conditions {
+	return k.Status.Conditions
+}
+
+func (k *KarpenterStatus) SetConditions(conditions apis.Conditions) {
+	k.Status.Conditions = conditions
+}
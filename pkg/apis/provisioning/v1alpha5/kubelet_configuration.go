@@ -14,6 +14,29 @@ limitations under the License.
 
 package v1alpha5
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+var (
+	// EvictionSignals are the signals that may be used for hard eviction thresholds
+	EvictionSignals = sets.NewString("memory.available", "nodefs.available", "nodefs.inodesFree", "imagefs.available", "imagefs.inodesFree", "pid.available")
+	// EvictionSignalResources maps eviction signals to the node resource that
+	// their threshold holds in reserve
+	EvictionSignalResources = map[string]v1.ResourceName{
+		"memory.available": v1.ResourceMemory,
+		"nodefs.available": v1.ResourceEphemeralStorage,
+	}
+	// ReservedResources are the resources that may be reserved for the kubelet and system daemons
+	ReservedResources = sets.NewString(string(v1.ResourceCPU), string(v1.ResourceMemory), string(v1.ResourceEphemeralStorage), "pid")
+)
+
 // KubeletConfiguration defines args to be used when configuring kubelet on provisioned nodes.
 // They are a subset of the upstream types, recognizing not all options may be supported.
 // Wherever possible, the types and names should reflect the upstream kubelet types.
@@ -22,4 +45,41 @@ type KubeletConfiguration struct {
 	// Note that not all providers may use all addresses.
 	//+optional
 	ClusterDNS []string `json:"clusterDNS,omitempty"`
+	// maxPods is the maximum number of pods that can run on a node. If not
+	// set, the cloud provider's default for the instance type is used.
+	//+optional
+	MaxPods *int32 `json:"maxPods,omitempty"`
+	// kubeReserved is a set of resources reserved for kubernetes system
+	// components, e.g. cpu=200m,memory=150Mi.
+	//+optional
+	KubeReserved v1.ResourceList `json:"kubeReserved,omitempty"`
+	// systemReserved is a set of resources reserved for non-kubernetes system
+	// components, e.g. cpu=200m,memory=150Mi.
+	//+optional
+	SystemReserved v1.ResourceList `json:"systemReserved,omitempty"`
+	// evictionHard is a map of signal names to quantities that defines hard
+	// eviction thresholds, e.g. {"memory.available": "300Mi"}. Thresholds may
+	// also be a percentage of capacity, e.g. {"nodefs.available": "10%"}.
+	//+optional
+	EvictionHard map[string]string `json:"evictionHard,omitempty"`
+}
+
+// EvictionThreshold returns the quantity reserved by a hard eviction threshold
+// on a node with the given capacity of the signal's resource.
+func EvictionThreshold(threshold string, capacity resource.Quantity) (resource.Quantity, error) {
+	if strings.HasSuffix(threshold, "%") {
+		percentage, err := strconv.ParseFloat(strings.TrimSuffix(threshold, "%"), 64)
+		if err != nil || percentage < 0 || percentage > 100 {
+			return resource.Quantity{}, fmt.Errorf("invalid percentage %s", threshold)
+		}
+		return *resource.NewQuantity(int64(float64(capacity.Value())*percentage/100), capacity.Format), nil
+	}
+	quantity, err := resource.ParseQuantity(threshold)
+	if err != nil {
+		return resource.Quantity{}, err
+	}
+	if quantity.Sign() < 0 {
+		return resource.Quantity{}, fmt.Errorf("invalid quantity %s", threshold)
+	}
+	return quantity, nil
 }
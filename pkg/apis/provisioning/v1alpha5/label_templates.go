@@ -0,0 +1,66 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha5
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// LabelTemplateData is what label templates are rendered with, e.g.
+// {{ .Pod.Labels.team }} or {{ .Namespace.Name }}
+type LabelTemplateData struct {
+	Pod       *v1.Pod
+	Namespace *v1.Namespace
+}
+
+// RenderLabelTemplates returns the labels of the label templates for the pod.
+// Labels that render empty are omitted, and an error is returned if a label
+// renders a value that isn't a valid label value.
+func (c *Constraints) RenderLabelTemplates(pod *v1.Pod, namespace *v1.Namespace) (map[string]string, error) {
+	labels := map[string]string{}
+	for key, text := range c.LabelTemplates {
+		labelTemplate, err := parseLabelTemplate(key, text)
+		if err != nil {
+			return nil, err
+		}
+		value := bytes.Buffer{}
+		if err := labelTemplate.Execute(&value, LabelTemplateData{Pod: pod, Namespace: namespace}); err != nil {
+			return nil, fmt.Errorf("rendering label template %s, %w", key, err)
+		}
+		if value.Len() == 0 {
+			continue
+		}
+		if errs := validation.IsValidLabelValue(value.String()); len(errs) != 0 {
+			return nil, fmt.Errorf("label template %s rendered invalid value %q, %v", key, value.String(), errs)
+		}
+		labels[key] = value.String()
+	}
+	return labels, nil
+}
+
+// parseLabelTemplate parses the template of a label. Missing map keys, such as
+// labels that the pod or namespace doesn't have, render empty.
+func parseLabelTemplate(key string, text string) (*template.Template, error) {
+	labelTemplate, err := template.New(key).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parsing label template %s, %w", key, err)
+	}
+	return labelTemplate, nil
+}
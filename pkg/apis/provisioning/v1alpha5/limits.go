@@ -24,6 +24,9 @@ import (
 type Limits struct {
 	// Resources contains all the allocatable resources that Karpenter supports for limiting.
 	Resources v1.ResourceList `json:"resources,omitempty"`
+	// MaxNodes is the maximum number of nodes that the provisioner may own.
+	// +optional
+	MaxNodes *int32 `json:"maxNodes,omitempty"`
 }
 
 func (l *Limits) ExceededBy(resources v1.ResourceList) error {
@@ -39,3 +42,15 @@ func (l *Limits) ExceededBy(resources v1.ResourceList) error {
 	}
 	return nil
 }
+
+// RemainingNodes returns the number of nodes that may be launched given the
+// number of nodes currently owned, or false if nodes are unlimited.
+func (l *Limits) RemainingNodes(nodes int) (int, bool) {
+	if l.MaxNodes == nil {
+		return 0, false
+	}
+	if remaining := int(*l.MaxNodes) - nodes; remaining > 0 {
+		return remaining, true
+	}
+	return 0, true
+}
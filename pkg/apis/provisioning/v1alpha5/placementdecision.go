@@ -0,0 +1,76 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha5
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PlacementDecisionSpec records why a node was launched: the pods that were
+// packed onto it, the node template that they were packed for, and the
+// instance types that were considered and chosen.
+type PlacementDecisionSpec struct {
+	// Provisioner is the name of the provisioner that launched the node.
+	Provisioner string `json:"provisioner"`
+	// Node is the name of the launched node.
+	Node string `json:"node"`
+	// Pods are the namespaced names of the pods that were bound to the node.
+	// +optional
+	Pods []string `json:"pods,omitempty"`
+	// Requirements are the provisioner's requirements, tightened to the pods'
+	// scheduling constraints.
+	// +optional
+	Requirements Requirements `json:"requirements,omitempty"`
+	// PackingStrategy is the strategy that packed the pods onto the node.
+	// +optional
+	PackingStrategy PackingStrategy `json:"packingStrategy,omitempty"`
+	// InstanceTypeOptions are the instance types that the cloud provider was
+	// asked to choose from, in order of increasing resources.
+	// +optional
+	InstanceTypeOptions []string `json:"instanceTypeOptions,omitempty"`
+	// InstanceType is the instance type that the cloud provider chose.
+	// +optional
+	InstanceType string `json:"instanceType,omitempty"`
+	// Zone is the zone that the node was launched in.
+	// +optional
+	Zone string `json:"zone,omitempty"`
+	// CapacityType is the capacity type of the node, e.g. spot or on-demand.
+	// +optional
+	CapacityType string `json:"capacityType,omitempty"`
+}
+
+// PlacementDecision is the Schema for the PlacementDecisions API. A
+// PlacementDecision is recorded for each node that a provisioner launches, and
+// is deleted once it's older than the configured time to live.
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:path=placementdecisions,scope=Namespaced
+// +kubebuilder:printcolumn:name="Node",type="string",JSONPath=".spec.node"
+// +kubebuilder:printcolumn:name="Instance Type",type="string",JSONPath=".spec.instanceType"
+// +kubebuilder:printcolumn:name="Provisioner",type="string",JSONPath=".spec.provisioner"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+type PlacementDecision struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec PlacementDecisionSpec `json:"spec,omitempty"`
+}
+
+// PlacementDecisionList contains a list of PlacementDecision
+// +kubebuilder:object:root=true
+type PlacementDecisionList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []PlacementDecision `json:"items"`
+}
@@ -0,0 +1,58 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha5
+
+import (
+	"knative.dev/pkg/apis"
+)
+
+// PreemptionPolicy determines whether nodes are launched for pending pods that
+// kube-scheduler could schedule by preempting lower priority pods
+type PreemptionPolicy string
+
+const (
+	// PreemptionPolicyLaunch launches nodes for the pods regardless, so the
+	// preempted pods may be rescheduled on them
+	PreemptionPolicyLaunch PreemptionPolicy = "Launch"
+	// PreemptionPolicyDefer waits for kube-scheduler to preempt lower priority
+	// pods for the pods, so only the preempted pods need new nodes
+	PreemptionPolicyDefer PreemptionPolicy = "Defer"
+)
+
+// PreemptionPolicies are the preemption policies that a provisioner may use
+var PreemptionPolicies = []PreemptionPolicy{
+	PreemptionPolicyLaunch,
+	PreemptionPolicyDefer,
+}
+
+// GetPreemptionPolicy returns the preemption policy, which defaults to Launch
+func (s *ProvisionerSpec) GetPreemptionPolicy() PreemptionPolicy {
+	if s.PreemptionPolicy == "" {
+		return PreemptionPolicyLaunch
+	}
+	return s.PreemptionPolicy
+}
+
+func (s *ProvisionerSpec) validatePreemptionPolicy() (errs *apis.FieldError) {
+	if s.PreemptionPolicy == "" {
+		return errs
+	}
+	for _, policy := range PreemptionPolicies {
+		if s.PreemptionPolicy == policy {
+			return errs
+		}
+	}
+	return errs.Also(apis.ErrInvalidValue(s.PreemptionPolicy, "preemptionPolicy"))
+}
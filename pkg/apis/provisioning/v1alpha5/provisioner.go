@@ -41,14 +41,49 @@ type ProvisionerSpec struct {
 	// Termination due to expiration is disabled if this field is not set.
 	// +optional
 	TTLSecondsUntilExpired *int64 `json:"ttlSecondsUntilExpired,omitempty"`
+	// ExpirationJitterPercent varies the expiration of each node by up to the
+	// percentage of TTLSecondsUntilExpired, earlier or later, so that nodes
+	// launched together don't expire together. Defaults to 0.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=50
+	// +optional
+	ExpirationJitterPercent *int32 `json:"expirationJitterPercent,omitempty"`
 	// Limits define a set of bounds for provisioning capacity.
 	Limits Limits `json:"limits,omitempty"`
+	// Provisioning configures how nodes are computed for pending pods.
+	// +optional
+	Provisioning Provisioning `json:"provisioning,omitempty"`
+	// Consolidation configures replacing underutilized nodes with cheaper
+	// capacity.
+	// +optional
+	Consolidation Consolidation `json:"consolidation,omitempty"`
+	// Drift configures replacing nodes that no longer match the provisioner.
+	// +optional
+	Drift Drift `json:"drift,omitempty"`
+	// Disruption limits the voluntary disruption of the provisioner's nodes.
+	// +optional
+	Disruption Disruption `json:"disruption,omitempty"`
+	// DeletionPolicy determines what happens to the provisioner's nodes when
+	// it's deleted: Drain deletes them, Orphan leaves them running, and
+	// Terminate terminates them without draining them. Defaults to Drain.
+	// +kubebuilder:validation:Enum=Drain;Orphan;Terminate
+	// +optional
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+	// PreemptionPolicy determines whether nodes are launched for pending pods
+	// that kube-scheduler could schedule on existing nodes by preempting lower
+	// priority pods: Launch launches nodes for them regardless, and Defer waits
+	// for the preemption, so only the preempted pods need new nodes. Defaults
+	// to Launch.
+	// +kubebuilder:validation:Enum=Launch;Defer
+	// +optional
+	PreemptionPolicy PreemptionPolicy `json:"preemptionPolicy,omitempty"`
 }
 
 // Provisioner is the Schema for the Provisioners API
 // +kubebuilder:object:root=true
 // +kubebuilder:resource:path=provisioners,scope=Cluster
 // +kubebuilder:subresource:status
+// +kubebuilder:storageversion
 type Provisioner struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
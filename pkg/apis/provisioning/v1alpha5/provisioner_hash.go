@@ -0,0 +1,61 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha5
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/mitchellh/hashstructure/v2"
+	v1 "k8s.io/api/core/v1"
+)
+
+// Hash fingerprints the constraints that are baked into the nodes that the
+// provisioner launches. TTLs, limits and provisioning settings don't change how
+// nodes are launched, so they are excluded, and may be edited without changing
+// the hash. The provider is excluded too, since a provider reference only names
+// the object that holds the provider's configuration. The cloud provider hashes
+// its resolved configuration instead, and reports nodes that drift from it. The
+// constraints are hashed as JSON, since hashstructure ignores the unexported
+// fields of quantities.
+func (s *ProvisionerSpec) Hash() (string, error) {
+	hashed := s.Constraints.DeepCopy()
+	hashed.Provider = nil
+	hashed.ProviderRef = nil
+	constraints, err := json.Marshal(hashed)
+	if err != nil {
+		return "", err
+	}
+	hash, err := hashstructure.Hash(string(constraints), hashstructure.FormatV2, nil)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprint(hash), nil
+}
+
+// HashChanged returns true if the node was launched from a different spec than
+// the provisioner's current one. Nodes that were launched before their hash
+// was recorded are assumed to be unchanged.
+func (s *ProvisionerSpec) HashChanged(node *v1.Node) (bool, error) {
+	launched, ok := node.Annotations[ProvisionerHashAnnotationKey]
+	if !ok {
+		return false, nil
+	}
+	current, err := s.Hash()
+	if err != nil {
+		return false, fmt.Errorf("hashing provisioner spec, %w", err)
+	}
+	return launched != current, nil
+}
@@ -0,0 +1,46 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha5
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/ptr"
+)
+
+// OwnerReference returns the owner reference of the nodes that the provisioner
+// launches. The provisioner's termination finalizer applies its deletion
+// policy to the nodes before it's deleted, and the owner reference garbage
+// collects any nodes that remain afterwards, e.g. if the finalizer was removed
+// by hand, unless it's deleted with --cascade=orphan.
+func (p *Provisioner) OwnerReference() metav1.OwnerReference {
+	return metav1.OwnerReference{
+		APIVersion: SchemeGroupVersion.String(),
+		Kind:       "Provisioner",
+		Name:       p.Name,
+		UID:        p.UID,
+		Controller: ptr.Bool(true),
+	}
+}
+
+// Owns returns true if the node has an owner reference to the provisioner
+func (p *Provisioner) Owns(node *v1.Node) bool {
+	for _, owner := range node.OwnerReferences {
+		if owner.UID == p.UID {
+			return true
+		}
+	}
+	return false
+}
@@ -26,18 +26,33 @@ type ProvisionerStatus struct {
 	// +kubebuilder:validation:Format="date-time"
 	LastScaleTime *apis.VolatileTime `json:"lastScaleTime,omitempty"`
 
-	// Conditions is the set of conditions required for this provisioner to scale
-	// its target, and indicates whether or not those conditions are met.
+	// Conditions of the provisioner: Ready, Validated, LimitsExceeded and
+	// Degraded. Only Validated is required for the provisioner to be Ready.
 	// +optional
 	Conditions apis.Conditions `json:"conditions,omitempty"`
 
 	// Resources is the list of resources that have been provisioned.
 	Resources v1.ResourceList `json:"resources,omitempty"`
+
+	// Nodes is the number of nodes that have been provisioned.
+	// +optional
+	Nodes int32 `json:"nodes,omitempty"`
+
+	// NodesByCapacityType is the number of nodes that have been provisioned,
+	// keyed by their capacity type, e.g. spot or on-demand.
+	// +optional
+	NodesByCapacityType map[string]int32 `json:"nodesByCapacityType,omitempty"`
+
+	// LastProvisioningTime is the creation time of the most recent node
+	// launched by the Provisioner
+	// +optional
+	// +kubebuilder:validation:Format="date-time"
+	LastProvisioningTime *apis.VolatileTime `json:"lastProvisioningTime,omitempty"`
 }
 
 func (p *Provisioner) StatusConditions() apis.ConditionManager {
 	return apis.NewLivingConditionSet(
-		Active,
+		Validated,
 	).Manage(p)
 }
 
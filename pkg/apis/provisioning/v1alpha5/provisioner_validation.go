@@ -17,35 +17,95 @@ package v1alpha5
 import (
 	"context"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/util/validation"
 	"knative.dev/pkg/apis"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	"github.com/aws/karpenter/pkg/utils/functional"
+	"github.com/aws/karpenter/pkg/utils/injection"
 	"github.com/aws/karpenter/pkg/utils/ptr"
 )
 
 var (
-	SupportedNodeSelectorOps = []string{string(v1.NodeSelectorOpIn), string(v1.NodeSelectorOpNotIn)}
+	SupportedNodeSelectorOps = []string{
+		string(v1.NodeSelectorOpIn),
+		string(v1.NodeSelectorOpNotIn),
+		string(v1.NodeSelectorOpExists),
+		string(v1.NodeSelectorOpDoesNotExist),
+		string(v1.NodeSelectorOpGt),
+		string(v1.NodeSelectorOpLt),
+	}
 )
 
 func (p *Provisioner) Validate(ctx context.Context) (errs *apis.FieldError) {
 	return errs.Also(
 		apis.ValidateObjectMetadata(p).ViaField("metadata"),
 		p.Spec.validate(ctx).ViaField("spec"),
+		p.validateUpdate(ctx),
 	)
 }
 
+// validateUpdate rejects updates whose requirements exclude the labels of
+// nodes that the provisioner launched, e.g. removing the zone that they are
+// in, since the nodes would be orphaned from the constraints they were
+// launched with. Annotating the provisioner with AllowDisruptionAnnotationKey
+// allows the update.
+func (p *Provisioner) validateUpdate(ctx context.Context) (errs *apis.FieldError) {
+	if !apis.IsInUpdate(ctx) || apis.IsInStatusUpdate(ctx) || p.Annotations[AllowDisruptionAnnotationKey] == "true" {
+		return nil
+	}
+	original, ok := apis.GetBaseline(ctx).(*Provisioner)
+	kubeClient := injection.GetKubeClient(ctx)
+	if !ok || kubeClient == nil {
+		return nil
+	}
+	nodes := &v1.NodeList{}
+	if err := kubeClient.List(ctx, nodes, client.MatchingLabels{ProvisionerNameLabelKey: p.Name}); err != nil {
+		return errs.Also(apis.ErrGeneric(fmt.Sprintf("listing nodes of provisioner, %s", err)))
+	}
+	orphaned := []string{}
+	for _, node := range nodes.Items {
+		if original.Spec.Requirements.AllowsLabels(node.Labels) && !p.Spec.Requirements.AllowsLabels(node.Labels) {
+			orphaned = append(orphaned, node.Name)
+		}
+	}
+	if len(orphaned) > 0 {
+		sort.Strings(orphaned)
+		return errs.Also(apis.ErrGeneric(fmt.Sprintf("excludes the labels of %d existing node(s), including %s, annotate the provisioner with %s=true to allow it",
+			len(orphaned), orphaned[0], AllowDisruptionAnnotationKey), "spec.requirements"))
+	}
+	return nil
+}
+
 func (s *ProvisionerSpec) validate(ctx context.Context) (errs *apis.FieldError) {
 	return errs.Also(
 		s.validateTTLSecondsUntilExpired(),
+		s.validateExpirationJitterPercent(),
 		s.validateTTLSecondsAfterEmpty(),
+		s.Limits.validate().ViaField("limits"),
+		s.Provisioning.validate().ViaField("provisioning"),
+		s.Consolidation.validate().ViaField("consolidation"),
+		s.Drift.validate().ViaField("drift"),
+		s.Disruption.validate().ViaField("disruption"),
+		s.validateDeletionPolicy(),
+		s.validatePreemptionPolicy(),
 		s.Constraints.Validate(ctx),
 	)
 }
 
+func (l *Limits) validate() (errs *apis.FieldError) {
+	if l.MaxNodes != nil && *l.MaxNodes < 0 {
+		return errs.Also(apis.ErrInvalidValue("cannot be negative", "maxNodes"))
+	}
+	return errs
+}
+
 func (s *ProvisionerSpec) validateTTLSecondsUntilExpired() (errs *apis.FieldError) {
 	if ptr.Int64Value(s.TTLSecondsUntilExpired) < 0 {
 		return errs.Also(apis.ErrInvalidValue("cannot be negative", "ttlSecondsUntilExpired"))
@@ -64,12 +124,31 @@ func (s *ProvisionerSpec) validateTTLSecondsAfterEmpty() (errs *apis.FieldError)
 func (c *Constraints) Validate(ctx context.Context) (errs *apis.FieldError) {
 	return errs.Also(
 		c.validateLabels(),
+		c.validateLabelTemplates(),
 		c.validateTaints(),
 		c.validateRequirements(),
+		c.KubeletConfiguration.validate().ViaField("kubeletConfiguration"),
+		c.validateProviderRef(),
 		ValidateHook(ctx, c),
 	)
 }
 
+func (c *Constraints) validateProviderRef() (errs *apis.FieldError) {
+	if c.ProviderRef == nil {
+		return nil
+	}
+	if c.Provider != nil {
+		errs = errs.Also(apis.ErrMultipleOneOf("provider", "providerRef"))
+	}
+	if c.ProviderRef.Kind == "" {
+		errs = errs.Also(apis.ErrMissingField("providerRef.kind"))
+	}
+	if c.ProviderRef.Name == "" {
+		errs = errs.Also(apis.ErrMissingField("providerRef.name"))
+	}
+	return errs
+}
+
 func (c *Constraints) validateLabels() (errs *apis.FieldError) {
 	for key, value := range c.Labels {
 		for _, err := range validation.IsQualifiedName(key) {
@@ -88,6 +167,32 @@ func (c *Constraints) validateLabels() (errs *apis.FieldError) {
 	return errs
 }
 
+// validateLabelTemplates rejects templates of labels that couldn't be set by
+// Labels, or that would conflict with Labels or Requirements
+func (c *Constraints) validateLabelTemplates() (errs *apis.FieldError) {
+	for key, text := range c.LabelTemplates {
+		for _, err := range validation.IsQualifiedName(key) {
+			errs = errs.Also(apis.ErrInvalidKeyName(key, "labelTemplates", err))
+		}
+		if RestrictedLabels.Has(key) || IsRestrictedLabelDomain(key) {
+			errs = errs.Also(apis.ErrInvalidKeyName(key, "labelTemplates", "label is restricted"))
+		}
+		if _, ok := WellKnownLabels[key]; ok {
+			errs = errs.Also(apis.ErrInvalidKeyName(key, "labelTemplates", "label is well known, use requirements instead"))
+		}
+		if _, ok := c.Labels[key]; ok {
+			errs = errs.Also(apis.ErrInvalidKeyName(key, "labelTemplates", "label is already set by labels"))
+		}
+		if functional.ContainsString(c.Requirements.Keys(), key) {
+			errs = errs.Also(apis.ErrInvalidKeyName(key, "labelTemplates", "label is already constrained by requirements"))
+		}
+		if _, err := parseLabelTemplate(key, text); err != nil {
+			errs = errs.Also(apis.ErrInvalidValue(err.Error(), fmt.Sprintf("labelTemplates[%s]", key)))
+		}
+	}
+	return errs
+}
+
 func IsRestrictedLabelDomain(key string) bool {
 	labelDomain := getLabelDomain(key)
 	if AllowedLabelDomains.Has(labelDomain) {
@@ -108,26 +213,42 @@ func getLabelDomain(key string) string {
 	return ""
 }
 
+// validateTaints rejects taints that the API server would reject when the
+// node is created. Taints and startup taints are both applied to the node, so
+// a key and effect may only appear once across them.
 func (c *Constraints) validateTaints() (errs *apis.FieldError) {
-	for i, taint := range c.Taints {
+	existing := Taints{{Key: NotReadyTaintKey, Effect: v1.TaintEffectNoSchedule}}
+	errs = errs.Also(validateTaints(c.Taints, &existing, "taints"))
+	errs = errs.Also(validateTaints(c.StartupTaints, &existing, "startupTaints"))
+	return errs
+}
+
+func validateTaints(taints Taints, existing *Taints, fieldName string) (errs *apis.FieldError) {
+	for i, taint := range taints {
 		// Validate Key
 		if len(taint.Key) == 0 {
-			errs = errs.Also(apis.ErrInvalidArrayValue(errs, "taints", i))
+			errs = errs.Also(apis.ErrMissingField("key").ViaFieldIndex(fieldName, i))
 		}
 		for _, err := range validation.IsQualifiedName(taint.Key) {
-			errs = errs.Also(apis.ErrInvalidArrayValue(err, "taints", i))
+			errs = errs.Also(apis.ErrInvalidValue(err, "key").ViaFieldIndex(fieldName, i))
 		}
 		// Validate Value
-		if len(taint.Value) != 0 {
-			for _, err := range validation.IsQualifiedName(taint.Value) {
-				errs = errs.Also(apis.ErrInvalidArrayValue(err, "taints", i))
-			}
+		for _, err := range validation.IsValidLabelValue(taint.Value) {
+			errs = errs.Also(apis.ErrInvalidValue(err, "value").ViaFieldIndex(fieldName, i))
 		}
 		// Validate effect
 		switch taint.Effect {
-		case v1.TaintEffectNoSchedule, v1.TaintEffectPreferNoSchedule, v1.TaintEffectNoExecute, "":
+		case v1.TaintEffectNoSchedule, v1.TaintEffectPreferNoSchedule, v1.TaintEffectNoExecute:
+		case "":
+			errs = errs.Also(apis.ErrMissingField("effect").ViaFieldIndex(fieldName, i))
 		default:
-			errs = errs.Also(apis.ErrInvalidArrayValue(taint.Effect, "effect", i))
+			errs = errs.Also(apis.ErrInvalidValue(taint.Effect, "effect").ViaFieldIndex(fieldName, i))
+		}
+		// Validate uniqueness
+		if existing.Has(taint) {
+			errs = errs.Also(apis.ErrGeneric(fmt.Sprintf("duplicate taint %s:%s", taint.Key, taint.Effect)).ViaFieldIndex(fieldName, i))
+		} else {
+			*existing = append(*existing, taint)
 		}
 	}
 	return errs
@@ -139,6 +260,25 @@ func (c *Constraints) validateRequirements() (errs *apis.FieldError) {
 			errs = errs.Also(apis.ErrInvalidArrayValue(err, "requirements", i))
 		}
 	}
+	return errs.Also(c.validateRequirementsCompatible())
+}
+
+// validateRequirementsCompatible rejects keys whose requirements can't all be
+// satisfied, e.g. In and NotIn requirements that leave no values, since no node
+// could ever be launched with them. Keys that are only excluded are allowed.
+func (c *Constraints) validateRequirementsCompatible() (errs *apis.FieldError) {
+	for _, key := range c.Requirements.Keys() {
+		if c.Requirements.hasOperator(key, v1.NodeSelectorOpDoesNotExist) && !c.Requirements.onlyOperator(key, v1.NodeSelectorOpDoesNotExist) {
+			errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%s, no value satisfies every requirement", key), "requirements"))
+			continue
+		}
+		if !c.Requirements.hasOperator(key, v1.NodeSelectorOpIn) {
+			continue
+		}
+		if c.Requirements.Requirement(key).Len() == 0 {
+			errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%s, no value satisfies every requirement", key), "requirements"))
+		}
+	}
 	return errs
 }
 
@@ -154,8 +294,48 @@ func validateRequirement(requirement v1.NodeSelectorRequirement) (errs *apis.Fie
 			errs = errs.Also(apis.ErrInvalidArrayValue(fmt.Sprintf("%s, %s", value, err), "values", i))
 		}
 	}
+	switch requirement.Operator {
+	case v1.NodeSelectorOpExists, v1.NodeSelectorOpDoesNotExist:
+		if len(requirement.Values) != 0 {
+			errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%s requires no values", requirement.Operator), "values"))
+		}
+	case v1.NodeSelectorOpGt, v1.NodeSelectorOpLt:
+		if len(requirement.Values) != 1 {
+			errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%s requires a single value", requirement.Operator), "values"))
+		}
+		for i, value := range requirement.Values {
+			if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+				errs = errs.Also(apis.ErrInvalidArrayValue(fmt.Sprintf("%s, must be an integer", value), "values", i))
+			}
+		}
+	}
 	if !functional.ContainsString(SupportedNodeSelectorOps, string(requirement.Operator)) {
 		errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%s not in %s", requirement.Operator, SupportedNodeSelectorOps), "operator"))
 	}
 	return errs
 }
+
+func (k *KubeletConfiguration) validate() (errs *apis.FieldError) {
+	if k.MaxPods != nil && *k.MaxPods < 0 {
+		errs = errs.Also(apis.ErrInvalidValue("cannot be negative", "maxPods"))
+	}
+	for fieldName, reserved := range map[string]v1.ResourceList{"kubeReserved": k.KubeReserved, "systemReserved": k.SystemReserved} {
+		for resourceName, quantity := range reserved {
+			if !ReservedResources.Has(string(resourceName)) {
+				errs = errs.Also(apis.ErrInvalidKeyName(string(resourceName), fieldName, fmt.Sprintf("expected one of %v", ReservedResources.List())))
+			}
+			if quantity.Sign() < 0 {
+				errs = errs.Also(apis.ErrInvalidValue("cannot be negative", fmt.Sprintf("%s[%s]", fieldName, resourceName)))
+			}
+		}
+	}
+	for signal, threshold := range k.EvictionHard {
+		if !EvictionSignals.Has(signal) {
+			errs = errs.Also(apis.ErrInvalidKeyName(signal, "evictionHard", fmt.Sprintf("expected one of %v", EvictionSignals.List())))
+		}
+		if _, err := EvictionThreshold(threshold, resource.Quantity{}); err != nil {
+			errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%s, %s", threshold, err), fmt.Sprintf("evictionHard[%s]", signal)))
+		}
+	}
+	return errs
+}
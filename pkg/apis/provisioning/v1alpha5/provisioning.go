@@ -0,0 +1,113 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha5
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"knative.dev/pkg/apis"
+)
+
+// PackingStrategy determines how pods are packed onto nodes and how the
+// instance type of each node is chosen.
+type PackingStrategy string
+
+const (
+	// PackingStrategyLowestCost fills one node at a time, choosing the instance
+	// type with the lowest price per packed pod.
+	PackingStrategyLowestCost PackingStrategy = "LowestCost"
+	// PackingStrategyFirstFitDecreasing places each pod, from largest to
+	// smallest, on the first node that it fits, and sizes each node to the
+	// smallest instance type that fits its pods.
+	PackingStrategyFirstFitDecreasing PackingStrategy = "FirstFitDecreasing"
+	// PackingStrategyBestFit places each pod, from largest to smallest, on the
+	// node with the least capacity remaining after it is placed, and sizes each
+	// node to the smallest instance type that fits its pods.
+	PackingStrategyBestFit PackingStrategy = "BestFit"
+	// PackingStrategyMinimizeNodes fills one node at a time, choosing the
+	// instance type that fits the most pods.
+	PackingStrategyMinimizeNodes PackingStrategy = "MinimizeNodes"
+)
+
+// PackingStrategies are the packing strategies that a provisioner may use
+var PackingStrategies = []PackingStrategy{
+	PackingStrategyLowestCost,
+	PackingStrategyFirstFitDecreasing,
+	PackingStrategyBestFit,
+	PackingStrategyMinimizeNodes,
+}
+
+// Provisioning configures how the provisioner computes nodes for pending pods
+type Provisioning struct {
+	// PackingStrategy determines how pods are packed onto nodes, and defaults
+	// to LowestCost.
+	// +kubebuilder:validation:Enum=LowestCost;FirstFitDecreasing;BestFit;MinimizeNodes
+	// +optional
+	PackingStrategy PackingStrategy `json:"packingStrategy,omitempty"`
+	// Headroom is spare capacity that is launched in addition to the capacity
+	// required by pending pods, so that pods created shortly after do not
+	// wait for new nodes.
+	// +optional
+	Headroom []Headroom `json:"headroom,omitempty"`
+}
+
+// Headroom reserves capacity in units that are shaped like pods. Each unit
+// fits on a single node.
+type Headroom struct {
+	// Replicas is the number of units to reserve, and defaults to 1.
+	// +optional
+	Replicas *int32 `json:"replicas,omitempty"`
+	// Requests are the resources reserved by each unit.
+	Requests v1.ResourceList `json:"requests"`
+}
+
+// GetReplicas returns the number of units to reserve
+func (h *Headroom) GetReplicas() int32 {
+	if h.Replicas == nil {
+		return 1
+	}
+	return *h.Replicas
+}
+
+func (p *Provisioning) validate() (errs *apis.FieldError) {
+	for i := range p.Headroom {
+		errs = errs.Also(p.Headroom[i].validate().ViaFieldIndex("headroom", i))
+	}
+	if p.PackingStrategy == "" {
+		return errs
+	}
+	for _, strategy := range PackingStrategies {
+		if p.PackingStrategy == strategy {
+			return errs
+		}
+	}
+	return errs.Also(apis.ErrInvalidValue(p.PackingStrategy, "packingStrategy"))
+}
+
+func (h *Headroom) validate() (errs *apis.FieldError) {
+	if h.Replicas != nil && *h.Replicas < 0 {
+		errs = errs.Also(apis.ErrInvalidValue("cannot be negative", "replicas"))
+	}
+	if len(h.Requests) == 0 {
+		errs = errs.Also(apis.ErrMissingField("requests"))
+	}
+	for resourceName, quantity := range h.Requests {
+		if quantity.Sign() < 0 {
+			errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%s cannot be negative", resourceName), "requests"))
+		}
+	}
+	return errs
+}
@@ -27,14 +27,24 @@ import (
 )
 
 var (
-	ArchitectureAmd64    = "amd64"
-	ArchitectureArm64    = "arm64"
-	OperatingSystemLinux = "linux"
+	ArchitectureAmd64      = "amd64"
+	ArchitectureArm64      = "arm64"
+	OperatingSystemLinux   = "linux"
+	OperatingSystemWindows = "windows"
 
 	ProvisionerNameLabelKey         = SchemeGroupVersion.Group + "/provisioner-name"
 	NotReadyTaintKey                = SchemeGroupVersion.Group + "/not-ready"
 	DoNotEvictPodAnnotationKey      = SchemeGroupVersion.Group + "/do-not-evict"
 	EmptinessTimestampAnnotationKey = SchemeGroupVersion.Group + "/emptiness-timestamp"
+	PriceAnnotationKey              = SchemeGroupVersion.Group + "/price"
+	ProvisionerHashAnnotationKey    = SchemeGroupVersion.Group + "/provisioner-hash"
+	AllowDisruptionAnnotationKey    = SchemeGroupVersion.Group + "/allow-disruption"
+	CancelableAnnotationKey         = SchemeGroupVersion.Group + "/cancelable"
+	DriftedAnnotationKey            = SchemeGroupVersion.Group + "/drifted"
+	ReplaceAnnotationKey            = SchemeGroupVersion.Group + "/replace"
+	ReplacementAnnotationKey        = SchemeGroupVersion.Group + "/replacement"
+	ReusableAnnotationKey           = SchemeGroupVersion.Group + "/reusable"
+	ScheduledCapacityAnnotationKey  = SchemeGroupVersion.Group + "/scheduled-capacity"
 	TerminationFinalizer            = SchemeGroupVersion.Group + "/termination"
 	DefaultProvisioner              = types.NamespacedName{Name: "default"}
 )
@@ -84,6 +94,12 @@ var (
 		scheme.AddKnownTypes(SchemeGroupVersion,
 			&Provisioner{},
 			&ProvisionerList{},
+			&KarpenterStatus{},
+			&KarpenterStatusList{},
+			&PlacementDecision{},
+			&PlacementDecisionList{},
+			&ScheduledCapacity{},
+			&ScheduledCapacityList{},
 		)
 		metav1.AddToGroupVersion(scheme, SchemeGroupVersion)
 		return nil
@@ -91,8 +107,17 @@ var (
 )
 
 const (
-	// Active is a condition implemented by all resources. It indicates that the
-	// controller is able to take actions: it's correctly configured, can make
-	// necessary API calls, and isn't disabled.
-	Active apis.ConditionType = "Active"
+	// Validated indicates that the provisioner's spec is valid and that the
+	// cloud provider is able to launch nodes from it. The provisioner is Ready
+	// when it is Validated.
+	Validated apis.ConditionType = "Validated"
+	// LimitsExceeded indicates that the provisioner owns as many resources or
+	// nodes as its limits allow, so that it won't launch more nodes. It doesn't
+	// affect whether the provisioner is Ready.
+	LimitsExceeded apis.ConditionType = "LimitsExceeded"
+	// Degraded indicates that the provisioner's most recent attempt to launch
+	// a node failed. It doesn't affect whether the provisioner is Ready. On the
+	// KarpenterStatus, it indicates that a controller breached its error rate
+	// or reconcile latency threshold.
+	Degraded apis.ConditionType = "Degraded"
 )
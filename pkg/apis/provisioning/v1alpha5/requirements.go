@@ -16,6 +16,7 @@ package v1alpha5
 
 import (
 	"sort"
+	"strconv"
 
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/util/sets"
@@ -55,34 +56,119 @@ func LabelRequirements(labels map[string]string) (r Requirements) {
 	return r
 }
 
-func PodRequirements(pod *v1.Pod) (r Requirements) {
+// PodRequirements returns the requirements of the pod's node selector and the
+// first of its required node affinity terms.
+func PodRequirements(pod *v1.Pod) Requirements {
+	return PodRequirementAlternatives(pod)[0]
+}
+
+// PodRequirementAlternatives returns the requirements for each of the pod's
+// required node affinity terms, combined with the pod's node selector. Terms
+// are ORed, so the pod may schedule using any one of the alternatives.
+func PodRequirementAlternatives(pod *v1.Pod) []Requirements {
+	selector := Requirements{}
 	for key, value := range pod.Spec.NodeSelector {
-		r = append(r, v1.NodeSelectorRequirement{Key: key, Operator: v1.NodeSelectorOpIn, Values: []string{value}})
+		selector = append(selector, v1.NodeSelectorRequirement{Key: key, Operator: v1.NodeSelectorOpIn, Values: []string{value}})
+	}
+	if pod.Spec.Affinity == nil ||
+		pod.Spec.Affinity.NodeAffinity == nil ||
+		pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil ||
+		len(pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms) == 0 {
+		return []Requirements{selector}
 	}
+	alternatives := []Requirements{}
+	for _, term := range pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+		alternatives = append(alternatives, Requirements{}.With(selector).With(term.MatchExpressions))
+	}
+	return alternatives
+}
+
+// WithPreferences returns the requirements tightened by the pod's preferred
+// node affinity terms. Preferences are soft, so rather than requiring any one
+// term, the terms are scored by weight and the heaviest combination of terms
+// that is compatible with the requirements is selected. Terms that cannot be
+// satisfied are ignored. An outer loop will iteratively remove the heaviest
+// terms if the selected preferences cannot be launched.
+func (r Requirements) WithPreferences(pod *v1.Pod) Requirements {
 	if pod.Spec.Affinity == nil || pod.Spec.Affinity.NodeAffinity == nil {
 		return r
 	}
-	// Select heaviest preference and treat as a requirement. An outer loop will iteratively unconstrain them if unsatisfiable.
-	if preferred := pod.Spec.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution; len(preferred) > 0 {
-		sort.Slice(preferred, func(i int, j int) bool { return preferred[i].Weight > preferred[j].Weight })
-		r = append(r, preferred[0].Preference.MatchExpressions...)
+	terms := make([]v1.PreferredSchedulingTerm, len(pod.Spec.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution))
+	copy(terms, pod.Spec.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution)
+	sort.SliceStable(terms, func(i int, j int) bool { return terms[i].Weight > terms[j].Weight })
+	remaining := int32(0)
+	for _, term := range terms {
+		remaining += term.Weight
 	}
-	// Select first requirement. An outer loop will iteratively remove OR requirements if unsatisfiable
-	if pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution != nil &&
-		len(pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms) > 0 {
-		r = append(r, pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0].MatchExpressions...)
+	best, _ := r.withPreferences(terms, remaining, 0, -1)
+	return best
+}
+
+// withPreferences searches for the combination of terms with the greatest
+// total weight that is compatible with the requirements. Terms are considered
+// in order of decreasing weight, so heavier terms win ties. Branches that
+// cannot exceed the best score found so far are pruned.
+func (r Requirements) withPreferences(terms []v1.PreferredSchedulingTerm, remaining int32, score int32, best int32) (Requirements, int32) {
+	if len(terms) == 0 || score+remaining <= best {
+		return r, score
 	}
-	return r
+	remaining -= terms[0].Weight
+	result, found := r, false
+	if candidate := r.With(terms[0].Preference.MatchExpressions); candidate.compatible(terms[0].Preference.MatchExpressions) {
+		if requirements, weight := candidate.withPreferences(terms[1:], remaining, score+terms[0].Weight, best); weight > best {
+			result, best, found = requirements, weight, true
+		}
+	}
+	if requirements, weight := r.withPreferences(terms[1:], remaining, score, best); weight > best {
+		result, best, found = requirements, weight, true
+	}
+	if !found {
+		return r, score
+	}
+	return result, best
 }
 
-// Consolidate combines In and NotIn requirements for each unique key, producing
-// an equivalent minimal representation of the requirements. This is useful as
+// compatible returns true if every key in the provided requirements has at
+// least one allowed value, or can't be enumerated.
+func (r Requirements) compatible(requirements []v1.NodeSelectorRequirement) bool {
+	for _, requirement := range requirements {
+		if values := r.Requirement(requirement.Key); values != nil && values.Len() == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// breadth returns the number of combinations of well known label values that
+// are allowed by the requirements. Unconstrained keys are ignored.
+func (r Requirements) breadth() float64 {
+	breadth := float64(1)
+	for key := range WellKnownLabels {
+		if values := r.Requirement(key); values != nil {
+			breadth *= float64(values.Len())
+		}
+	}
+	return breadth
+}
+
+// Consolidate combines the requirements for each unique key, producing an
+// equivalent minimal representation of the requirements. This is useful as
 // requirements may be appended from a variety of sources and then consolidated.
+// Keys that are only required to exist, or to be greater or less than a value,
+// can't be enumerated, so their requirements are kept as they are.
 // Caution: If a key has contains a `NotIn` operator without a corresponding
 // `In` operator, the requirement will permanently be [] after consolidation. To
 // avoid this, include the broadest `In` requirements before consolidating.
 func (r Requirements) Consolidate() (requirements Requirements) {
 	for _, key := range r.Keys() {
+		if r.Requirement(key) == nil {
+			for _, requirement := range r {
+				if requirement.Key == key {
+					requirements = append(requirements, requirement)
+				}
+			}
+			continue
+		}
 		requirements = append(requirements, v1.NodeSelectorRequirement{
 			Key:      key,
 			Operator: v1.NodeSelectorOpIn,
@@ -110,7 +196,31 @@ func (r Requirements) Keys() []string {
 	return keys.UnsortedList()
 }
 
-// Requirements for the provided key, nil if unconstrained
+// hasOperator returns true if any requirement of the key uses the operator
+func (r Requirements) hasOperator(key string, operator v1.NodeSelectorOperator) bool {
+	for _, requirement := range r {
+		if requirement.Key == key && requirement.Operator == operator {
+			return true
+		}
+	}
+	return false
+}
+
+// onlyOperator returns true if every requirement of the key uses the operator
+func (r Requirements) onlyOperator(key string, operator v1.NodeSelectorOperator) bool {
+	for _, requirement := range r {
+		if requirement.Key == key && requirement.Operator != operator {
+			return false
+		}
+	}
+	return true
+}
+
+// Requirements for the provided key, nil if unconstrained. Keys that are only
+// required to exist, or to be greater or less than a value, are unconstrained
+// since their values can't be enumerated, but they filter the values of the
+// key's other requirements. Labels that don't exist are represented by the
+// empty value.
 func (r Requirements) Requirement(key string) sets.String {
 	var result sets.String
 	// OpIn
@@ -129,5 +239,69 @@ func (r Requirements) Requirement(key string) sets.String {
 			result = result.Difference(sets.NewString(requirement.Values...))
 		}
 	}
+	// OpExists, OpDoesNotExist, OpGt and OpLt
+	for _, requirement := range r {
+		if requirement.Key != key {
+			continue
+		}
+		switch requirement.Operator {
+		case v1.NodeSelectorOpExists:
+			result.Delete("")
+		case v1.NodeSelectorOpDoesNotExist:
+			if result == nil {
+				result = sets.NewString("")
+			} else {
+				result = result.Intersection(sets.NewString(""))
+			}
+		case v1.NodeSelectorOpGt, v1.NodeSelectorOpLt:
+			for value := range result {
+				if !Allows(requirement, value) {
+					result.Delete(value)
+				}
+			}
+		}
+	}
 	return result
 }
+
+// AllowsLabels returns true if no label has a value that the requirements
+// exclude. Labels that aren't required, and requirements on labels that are
+// missing, are ignored.
+func (r Requirements) AllowsLabels(labels map[string]string) bool {
+	for _, requirement := range r {
+		if value, ok := labels[requirement.Key]; ok && !Allows(requirement, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// Allows returns true if a label with the value satisfies the requirement. Gt
+// and Lt requirements only allow integer values, as in the kube-scheduler.
+func Allows(requirement v1.NodeSelectorRequirement, value string) bool {
+	switch requirement.Operator {
+	case v1.NodeSelectorOpIn:
+		return sets.NewString(requirement.Values...).Has(value)
+	case v1.NodeSelectorOpNotIn:
+		return !sets.NewString(requirement.Values...).Has(value)
+	case v1.NodeSelectorOpExists:
+		return true
+	case v1.NodeSelectorOpGt, v1.NodeSelectorOpLt:
+		if len(requirement.Values) != 1 {
+			return false
+		}
+		bound, err := strconv.ParseInt(requirement.Values[0], 10, 64)
+		if err != nil {
+			return false
+		}
+		actual, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return false
+		}
+		if requirement.Operator == v1.NodeSelectorOpGt {
+			return actual > bound
+		}
+		return actual < bound
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha5
+
+import (
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/aws/karpenter/pkg/utils/cron"
+)
+
+// DefaultScheduledCapacityLead is how long before its windows scheduled
+// capacity is launched by default
+var DefaultScheduledCapacityLead = 10 * time.Minute
+
+// ScheduledCapacitySpec is capacity that a provisioner launches ahead of
+// windows of known demand, and releases after them.
+type ScheduledCapacitySpec struct {
+	// Provisioner is the name of the provisioner that launches the capacity.
+	Provisioner string `json:"provisioner"`
+	// Schedule is a cron expression, in UTC, of when the windows start, e.g.
+	// "0 9 * * 1-5" for 09:00 on weekdays.
+	Schedule string `json:"schedule"`
+	// Duration is how long each window lasts.
+	Duration metav1.Duration `json:"duration"`
+	// Lead is how long before each window starts that the capacity is
+	// launched, so that its nodes are ready when it starts. Defaults to 10m.
+	// +optional
+	Lead *metav1.Duration `json:"lead,omitempty"`
+	// Capacity is launched in units that are shaped like pods, in the same
+	// way as the provisioner's headroom.
+	Capacity []Headroom `json:"capacity"`
+}
+
+// ScheduledCapacityStatus records the capacity launched for the current window
+type ScheduledCapacityStatus struct {
+	// WindowStart is the start of the window that nodes were launched for.
+	// +optional
+	WindowStart *metav1.Time `json:"windowStart,omitempty"`
+	// Nodes are the names of the nodes that were launched for the window.
+	// +optional
+	Nodes []string `json:"nodes,omitempty"`
+}
+
+// ScheduledCapacity is the Schema for the ScheduledCapacities API
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:path=scheduledcapacities,scope=Cluster
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Provisioner",type="string",JSONPath=".spec.provisioner"
+// +kubebuilder:printcolumn:name="Schedule",type="string",JSONPath=".spec.schedule"
+// +kubebuilder:printcolumn:name="Window Start",type="date",JSONPath=".status.windowStart"
+type ScheduledCapacity struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ScheduledCapacitySpec   `json:"spec,omitempty"`
+	Status ScheduledCapacityStatus `json:"status,omitempty"`
+}
+
+// ScheduledCapacityList contains a list of ScheduledCapacity
+// +kubebuilder:object:root=true
+type ScheduledCapacityList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ScheduledCapacity `json:"items"`
+}
+
+// GetLead returns how long before each window the capacity is launched
+func (s *ScheduledCapacitySpec) GetLead() time.Duration {
+	if s.Lead == nil {
+		return DefaultScheduledCapacityLead
+	}
+	return s.Lead.Duration
+}
+
+// Window returns the start of the window that the capacity is launched for at
+// the time, including the lead before it, or false if there is none. The next
+// time that the window may change, when the current window ends or the next
+// one's lead starts, is also returned, or the zero time if windows never start.
+func (s *ScheduledCapacitySpec) Window(now time.Time) (start time.Time, active bool, next time.Time, err error) {
+	schedule, err := cron.Parse(s.Schedule)
+	if err != nil {
+		return time.Time{}, false, time.Time{}, err
+	}
+	now = now.UTC()
+	// The earliest window that hasn't ended yet is active if its lead started
+	start, ok := schedule.Next(now.Add(-s.Duration.Duration))
+	if !ok {
+		return time.Time{}, false, time.Time{}, nil
+	}
+	if lead := start.Add(-s.GetLead()); lead.After(now) {
+		return time.Time{}, false, lead, nil
+	}
+	return start, true, start.Add(s.Duration.Duration), nil
+}
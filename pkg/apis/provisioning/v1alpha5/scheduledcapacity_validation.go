@@ -0,0 +1,56 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha5
+
+import (
+	"context"
+
+	"knative.dev/pkg/apis"
+
+	"github.com/aws/karpenter/pkg/utils/cron"
+)
+
+// SetDefaults for the scheduled capacity
+func (c *ScheduledCapacity) SetDefaults(_ context.Context) {}
+
+// Validate the scheduled capacity
+func (c *ScheduledCapacity) Validate(_ context.Context) (errs *apis.FieldError) {
+	return errs.Also(
+		apis.ValidateObjectMetadata(c).ViaField("metadata"),
+		c.Spec.validate().ViaField("spec"),
+	)
+}
+
+func (s *ScheduledCapacitySpec) validate() (errs *apis.FieldError) {
+	if s.Provisioner == "" {
+		errs = errs.Also(apis.ErrMissingField("provisioner"))
+	}
+	if _, err := cron.Parse(s.Schedule); err != nil {
+		errs = errs.Also(apis.ErrInvalidValue(err.Error(), "schedule"))
+	}
+	if s.Duration.Duration <= 0 {
+		errs = errs.Also(apis.ErrInvalidValue("must be positive", "duration"))
+	}
+	if s.Lead != nil && s.Lead.Duration < 0 {
+		errs = errs.Also(apis.ErrInvalidValue("cannot be negative", "lead"))
+	}
+	if len(s.Capacity) == 0 {
+		errs = errs.Also(apis.ErrMissingField("capacity"))
+	}
+	for i := range s.Capacity {
+		errs = errs.Also(s.Capacity[i].validate().ViaFieldIndex("capacity", i))
+	}
+	return errs
+}
@@ -18,8 +18,10 @@ import (
 	"context"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/Pallinder/go-randomdata"
+	"github.com/aws/karpenter/pkg/utils/injection"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	. "knative.dev/pkg/logging/testing"
@@ -28,7 +30,12 @@ import (
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/apimachinery/pkg/util/sets"
+	"knative.dev/pkg/apis"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
 
 var ctx context.Context
@@ -67,6 +74,39 @@ var _ = Describe("Validation", func() {
 		Expect(provisioner.Validate(ctx)).ToNot(Succeed())
 	})
 
+	Context("ProviderRef", func() {
+		It("should allow a provider ref", func() {
+			provisioner.Spec.ProviderRef = &ProviderRef{Kind: "AWSNodeTemplate", Name: "default"}
+			Expect(provisioner.Validate(ctx)).To(Succeed())
+		})
+		It("should fail for a provider ref with a provider", func() {
+			provisioner.Spec.ProviderRef = &ProviderRef{Kind: "AWSNodeTemplate", Name: "default"}
+			provisioner.Spec.Provider = &runtime.RawExtension{Raw: []byte(`{}`)}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+		It("should fail for a provider ref without a kind or name", func() {
+			provisioner.Spec.ProviderRef = &ProviderRef{Name: "default"}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			provisioner.Spec.ProviderRef = &ProviderRef{Kind: "AWSNodeTemplate"}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+	})
+
+	Context("ExpirationJitterPercent", func() {
+		It("should allow jitter of up to half of the TTL", func() {
+			for _, percent := range []int32{0, 10, MaxExpirationJitterPercent} {
+				provisioner.Spec.ExpirationJitterPercent = ptr.Int32(percent)
+				Expect(provisioner.Validate(ctx)).To(Succeed())
+			}
+		})
+		It("should fail on jitter that is negative or more than half of the TTL", func() {
+			for _, percent := range []int32{-1, MaxExpirationJitterPercent + 1} {
+				provisioner.Spec.ExpirationJitterPercent = ptr.Int32(percent)
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			}
+		})
+	})
+
 	Context("Limits", func() {
 		It("should allow undefined limits", func() {
 			provisioner.Spec.Limits = Limits{}
@@ -76,6 +116,159 @@ var _ = Describe("Validation", func() {
 			provisioner.Spec.Limits = Limits{Resources: v1.ResourceList{}}
 			Expect(provisioner.Validate(ctx)).To(Succeed())
 		})
+		It("should allow a maximum number of nodes", func() {
+			provisioner.Spec.Limits.MaxNodes = ptr.Int32(0)
+			Expect(provisioner.Validate(ctx)).To(Succeed())
+			provisioner.Spec.Limits.MaxNodes = ptr.Int32(10)
+			Expect(provisioner.Validate(ctx)).To(Succeed())
+		})
+		It("should fail on a negative maximum number of nodes", func() {
+			provisioner.Spec.Limits.MaxNodes = ptr.Int32(-1)
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+	})
+
+	Context("Provisioning", func() {
+		It("should allow supported packing strategies", func() {
+			for _, strategy := range append(PackingStrategies, "") {
+				provisioner.Spec.Provisioning.PackingStrategy = strategy
+				Expect(provisioner.Validate(ctx)).To(Succeed())
+			}
+		})
+		It("should fail on unsupported packing strategies", func() {
+			provisioner.Spec.Provisioning.PackingStrategy = "WorstFit"
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+		It("should allow headroom", func() {
+			provisioner.Spec.Provisioning.Headroom = []Headroom{
+				{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")}},
+				{Replicas: ptr.Int32(3), Requests: v1.ResourceList{v1.ResourceMemory: resource.MustParse("1Gi")}},
+			}
+			Expect(provisioner.Validate(ctx)).To(Succeed())
+		})
+		It("should fail for headroom without requests", func() {
+			provisioner.Spec.Provisioning.Headroom = []Headroom{{Replicas: ptr.Int32(1)}}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+		It("should fail for headroom with negative replicas or requests", func() {
+			provisioner.Spec.Provisioning.Headroom = []Headroom{{Replicas: ptr.Int32(-1), Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")}}}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			provisioner.Spec.Provisioning.Headroom = []Headroom{{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("-1")}}}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+	})
+
+	Context("Consolidation", func() {
+		It("should allow consolidation", func() {
+			provisioner.Spec.Consolidation = Consolidation{Enabled: ptr.Bool(true), MaxUnavailable: ptr.Int32(2)}
+			Expect(provisioner.Validate(ctx)).To(Succeed())
+			Expect(provisioner.Spec.Consolidation.IsEnabled()).To(BeTrue())
+		})
+		It("should default to one unavailable node", func() {
+			Expect(provisioner.Spec.Consolidation.IsEnabled()).To(BeFalse())
+			Expect(provisioner.Spec.Consolidation.GetMaxUnavailable()).To(BeNumerically("==", 1))
+		})
+		It("should fail if no nodes may be unavailable", func() {
+			provisioner.Spec.Consolidation.MaxUnavailable = ptr.Int32(0)
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+	})
+
+	Context("Drift", func() {
+		It("should allow a drift budget", func() {
+			provisioner.Spec.Drift = Drift{MaxUnavailable: ptr.Int32(2)}
+			Expect(provisioner.Validate(ctx)).To(Succeed())
+			Expect(provisioner.Spec.Drift.GetMaxUnavailable()).To(BeNumerically("==", 2))
+		})
+		It("should default to one unavailable node", func() {
+			Expect(provisioner.Spec.Drift.GetMaxUnavailable()).To(BeNumerically("==", 1))
+		})
+		It("should fail if no nodes may be unavailable", func() {
+			provisioner.Spec.Drift.MaxUnavailable = ptr.Int32(0)
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+	})
+
+	Context("DeletionPolicy", func() {
+		It("should allow supported deletion policies", func() {
+			for _, policy := range append(DeletionPolicies, "") {
+				provisioner.Spec.DeletionPolicy = policy
+				Expect(provisioner.Validate(ctx)).To(Succeed())
+			}
+		})
+		It("should fail on unsupported deletion policies", func() {
+			provisioner.Spec.DeletionPolicy = "Abandon"
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+		It("should default to draining nodes", func() {
+			Expect(provisioner.Spec.GetDeletionPolicy()).To(Equal(DeletionPolicyDrain))
+		})
+	})
+
+	Context("PreemptionPolicy", func() {
+		It("should allow supported preemption policies", func() {
+			for _, policy := range append(PreemptionPolicies, "") {
+				provisioner.Spec.PreemptionPolicy = policy
+				Expect(provisioner.Validate(ctx)).To(Succeed())
+			}
+		})
+		It("should fail on unsupported preemption policies", func() {
+			provisioner.Spec.PreemptionPolicy = "Never"
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+		It("should default to launching nodes", func() {
+			Expect(provisioner.Spec.GetPreemptionPolicy()).To(Equal(PreemptionPolicyLaunch))
+		})
+	})
+
+	Context("Disruption", func() {
+		It("should allow budgets of numbers and percentages of nodes", func() {
+			provisioner.Spec.Disruption.Budgets = []Budget{{Nodes: intstr.FromInt(0)}, {Nodes: intstr.FromInt(5)}, {Nodes: intstr.FromString("10%")}}
+			Expect(provisioner.Validate(ctx)).To(Succeed())
+		})
+		It("should fail for malformed budgets", func() {
+			for _, nodes := range []intstr.IntOrString{intstr.FromInt(-1), intstr.FromString("5"), intstr.FromString("ten%"), intstr.FromString("-10%"), intstr.FromString("110%")} {
+				provisioner.Spec.Disruption.Budgets = []Budget{{Nodes: nodes}}
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed(), nodes.String())
+			}
+		})
+		It("should allow scheduled budgets", func() {
+			provisioner.Spec.Disruption.Budgets = []Budget{{
+				Nodes:    intstr.FromInt(0),
+				Schedule: &Schedule{Days: []string{"Saturday", "sunday"}, Start: "09:30", Duration: metav1.Duration{Duration: 8 * time.Hour}},
+			}}
+			Expect(provisioner.Validate(ctx)).To(Succeed())
+		})
+		It("should fail for malformed schedules", func() {
+			for _, schedule := range []Schedule{
+				{Start: "9am", Duration: metav1.Duration{Duration: time.Hour}},
+				{Start: "25:00", Duration: metav1.Duration{Duration: time.Hour}},
+				{Start: "09:00"},
+				{Days: []string{"Caturday"}, Start: "09:00", Duration: metav1.Duration{Duration: time.Hour}},
+			} {
+				provisioner.Spec.Disruption.Budgets = []Budget{{Nodes: intstr.FromInt(0), Schedule: schedule.DeepCopy()}}
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed(), schedule.Start)
+			}
+		})
+		It("should not limit disruption without active budgets", func() {
+			Expect(provisioner.Spec.Disruption.Allowed(time.Now(), 10)).To(BeNumerically(">=", 10))
+		})
+		It("should only apply scheduled budgets during their windows", func() {
+			disruption := Disruption{Budgets: []Budget{{
+				Nodes:    intstr.FromInt(0),
+				Schedule: &Schedule{Days: []string{"Friday"}, Start: "18:00", Duration: metav1.Duration{Duration: 60 * time.Hour}},
+			}}}
+			// 2022-01-07 is a Friday, and the window lasts until Monday morning
+			for now, allowed := range map[time.Time]bool{
+				time.Date(2022, time.January, 7, 17, 59, 0, 0, time.UTC): true,
+				time.Date(2022, time.January, 7, 18, 0, 0, 0, time.UTC):  false,
+				time.Date(2022, time.January, 9, 12, 0, 0, 0, time.UTC):  false,
+				time.Date(2022, time.January, 10, 5, 59, 0, 0, time.UTC): false,
+				time.Date(2022, time.January, 10, 6, 0, 0, 0, time.UTC):  true,
+			} {
+				Expect(disruption.Allowed(now, 10) > 0).To(Equal(allowed), now.String())
+			}
+		})
 	})
 
 	Context("Labels", func() {
@@ -111,6 +304,38 @@ var _ = Describe("Validation", func() {
 			Expect(provisioner.Validate(ctx)).To(Succeed())
 		})
 	})
+	Context("LabelTemplates", func() {
+		It("should allow templates of unrecognized labels", func() {
+			provisioner.Spec.LabelTemplates = map[string]string{"example.com/team": "{{ .Namespace.Labels.team }}"}
+			Expect(provisioner.Validate(ctx)).To(Succeed())
+		})
+		It("should fail for invalid label keys", func() {
+			provisioner.Spec.LabelTemplates = map[string]string{"spaces are not allowed": "{{ .Namespace.Name }}"}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+		It("should fail for malformed templates", func() {
+			provisioner.Spec.LabelTemplates = map[string]string{"example.com/team": "{{ .Namespace.Labels.team"}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+		It("should fail for restricted labels", func() {
+			for label := range RestrictedLabels {
+				provisioner.Spec.LabelTemplates = map[string]string{label: "{{ .Namespace.Name }}"}
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			}
+		})
+		It("should fail for well known labels", func() {
+			provisioner.Spec.LabelTemplates = map[string]string{v1.LabelTopologyZone: "{{ .Namespace.Name }}"}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+		It("should fail for labels that are set by labels or constrained by requirements", func() {
+			provisioner.Spec.Labels = map[string]string{"example.com/team": "a"}
+			provisioner.Spec.LabelTemplates = map[string]string{"example.com/team": "{{ .Namespace.Name }}"}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			provisioner.Spec.Labels = nil
+			provisioner.Spec.Requirements = Requirements{{Key: "example.com/team", Operator: v1.NodeSelectorOpIn, Values: []string{"a"}}}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+	})
 	Context("Taints", func() {
 		It("should succeed for valid taints", func() {
 			provisioner.Spec.Taints = []v1.Taint{
@@ -137,6 +362,82 @@ var _ = Describe("Validation", func() {
 			provisioner.Spec.Taints = []v1.Taint{{Key: "invalid-effect", Effect: "???"}}
 			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
 		})
+		It("should fail for missing taint effect", func() {
+			provisioner.Spec.Taints = []v1.Taint{{Key: "missing-effect", Value: "b"}}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+		It("should succeed for taint values that are valid label values", func() {
+			provisioner.Spec.Taints = []v1.Taint{{Key: "example.com/team", Value: "a.b-c_d", Effect: v1.TaintEffectNoSchedule}}
+			Expect(provisioner.Validate(ctx)).To(Succeed())
+		})
+		It("should fail for taint values that are not valid label values", func() {
+			provisioner.Spec.Taints = []v1.Taint{{Key: "example.com/team", Value: "example.com/team", Effect: v1.TaintEffectNoSchedule}}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+		It("should succeed for taints with the same key and different effects", func() {
+			provisioner.Spec.Taints = []v1.Taint{
+				{Key: "a", Value: "b", Effect: v1.TaintEffectNoSchedule},
+				{Key: "a", Value: "b", Effect: v1.TaintEffectNoExecute},
+			}
+			Expect(provisioner.Validate(ctx)).To(Succeed())
+		})
+		It("should fail for taints with the same key and effect", func() {
+			provisioner.Spec.Taints = []v1.Taint{
+				{Key: "a", Value: "b", Effect: v1.TaintEffectNoSchedule},
+				{Key: "a", Value: "c", Effect: v1.TaintEffectNoSchedule},
+			}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+		It("should fail for startup taints with the same key and effect as a taint", func() {
+			provisioner.Spec.Taints = []v1.Taint{{Key: "a", Value: "b", Effect: v1.TaintEffectNoSchedule}}
+			provisioner.Spec.StartupTaints = []v1.Taint{{Key: "a", Effect: v1.TaintEffectNoSchedule}}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+		It("should fail for taints that Karpenter applies itself", func() {
+			provisioner.Spec.Taints = []v1.Taint{{Key: NotReadyTaintKey, Effect: v1.TaintEffectNoSchedule}}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+		It("should succeed for valid startup taints", func() {
+			provisioner.Spec.StartupTaints = []v1.Taint{{Key: "a", Value: "b", Effect: v1.TaintEffectNoSchedule}}
+			Expect(provisioner.Validate(ctx)).To(Succeed())
+		})
+		It("should fail for invalid startup taints", func() {
+			provisioner.Spec.StartupTaints = []v1.Taint{{Key: "???"}}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+	})
+	Context("KubeletConfiguration", func() {
+		It("should succeed for a valid configuration", func() {
+			provisioner.Spec.KubeletConfiguration = KubeletConfiguration{
+				MaxPods:        ptr.Int32(20),
+				KubeReserved:   v1.ResourceList{v1.ResourceCPU: resource.MustParse("200m"), v1.ResourceMemory: resource.MustParse("150Mi")},
+				SystemReserved: v1.ResourceList{v1.ResourceEphemeralStorage: resource.MustParse("1Gi"), "pid": resource.MustParse("100")},
+				EvictionHard:   map[string]string{"memory.available": "300Mi", "nodefs.available": "10%"},
+			}
+			Expect(provisioner.Validate(ctx)).To(Succeed())
+		})
+		It("should fail for negative max pods", func() {
+			provisioner.Spec.KubeletConfiguration.MaxPods = ptr.Int32(-1)
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+		It("should fail for unsupported reserved resources", func() {
+			provisioner.Spec.KubeletConfiguration.KubeReserved = v1.ResourceList{"nvidia.com/gpu": resource.MustParse("1")}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+		It("should fail for negative reserved resources", func() {
+			provisioner.Spec.KubeletConfiguration.SystemReserved = v1.ResourceList{v1.ResourceCPU: resource.MustParse("-1")}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+		It("should fail for unknown eviction signals", func() {
+			provisioner.Spec.KubeletConfiguration.EvictionHard = map[string]string{"unknown.available": "100Mi"}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+		It("should fail for invalid eviction thresholds", func() {
+			for _, threshold := range []string{"???", "-100Mi", "110%", "-1%"} {
+				provisioner.Spec.KubeletConfiguration.EvictionHard = map[string]string{"memory.available": threshold}
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			}
+		})
 	})
 	Context("Requirements", func() {
 		It("should allow supported ops", func() {
@@ -146,12 +447,34 @@ var _ = Describe("Validation", func() {
 			}
 			Expect(provisioner.Validate(ctx)).To(Succeed())
 		})
+		It("should allow existence and numeric ops", func() {
+			provisioner.Spec.Requirements = Requirements{
+				{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpExists},
+				{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpGt, Values: []string{"1"}},
+				{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpLt, Values: []string{"10"}},
+			}
+			Expect(provisioner.Validate(ctx)).To(Succeed())
+			provisioner.Spec.Requirements = Requirements{{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpDoesNotExist}}
+			Expect(provisioner.Validate(ctx)).To(Succeed())
+		})
 		It("should fail for unsupported ops", func() {
-			for _, op := range []v1.NodeSelectorOperator{v1.NodeSelectorOpExists, v1.NodeSelectorOpDoesNotExist, v1.NodeSelectorOpGt, v1.NodeSelectorOpLt} {
+			provisioner.Spec.Requirements = Requirements{{Key: v1.LabelTopologyZone, Operator: "Unknown", Values: []string{"test"}}}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+		It("should fail for existence ops with values", func() {
+			for _, op := range []v1.NodeSelectorOperator{v1.NodeSelectorOpExists, v1.NodeSelectorOpDoesNotExist} {
 				provisioner.Spec.Requirements = Requirements{{Key: v1.LabelTopologyZone, Operator: op, Values: []string{"test"}}}
 				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
 			}
 		})
+		It("should fail for numeric ops without a single integer value", func() {
+			for _, op := range []v1.NodeSelectorOperator{v1.NodeSelectorOpGt, v1.NodeSelectorOpLt} {
+				for _, values := range [][]string{nil, {"test"}, {"1", "2"}} {
+					provisioner.Spec.Requirements = Requirements{{Key: v1.LabelTopologyZone, Operator: op, Values: values}}
+					Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+				}
+			}
+		})
 		It("should allow well known labels", func() {
 			for label := range WellKnownLabels {
 				provisioner.Spec.Requirements = Requirements{{Key: label, Operator: v1.NodeSelectorOpIn, Values: []string{"test"}}}
@@ -164,5 +487,530 @@ var _ = Describe("Validation", func() {
 				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
 			}
 		})
+		It("should fail for conflicting requirements", func() {
+			provisioner.Spec.Requirements = Requirements{
+				{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test"}},
+				{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpNotIn, Values: []string{"test"}},
+			}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			provisioner.Spec.Requirements = Requirements{
+				{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"foo"}},
+				{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"bar"}},
+			}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			provisioner.Spec.Requirements = Requirements{
+				{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpIn, Values: []string{"1", "2"}},
+				{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpGt, Values: []string{"2"}},
+			}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			provisioner.Spec.Requirements = Requirements{
+				{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpExists},
+				{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpDoesNotExist},
+			}
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+		It("should allow requirements that only exclude values", func() {
+			provisioner.Spec.Requirements = Requirements{
+				{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpNotIn, Values: []string{"foo"}},
+				{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpNotIn, Values: []string{"bar"}},
+			}
+			Expect(provisioner.Validate(ctx)).To(Succeed())
+		})
+	})
+})
+
+var _ = Describe("Requirements", func() {
+	It("should intersect In and NotIn values", func() {
+		requirements := Requirements{
+			{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-1", "test-zone-2", "test-zone-3"}},
+			{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-1", "test-zone-2"}},
+			{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpNotIn, Values: []string{"test-zone-1"}},
+		}
+		Expect(requirements.Zones().UnsortedList()).To(ConsistOf("test-zone-2"))
+	})
+	It("should filter values that aren't greater or less than the bounds", func() {
+		requirements := Requirements{
+			{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpIn, Values: []string{"1", "2", "4", "8", "large"}},
+			{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpGt, Values: []string{"1"}},
+			{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpLt, Values: []string{"8"}},
+		}
+		Expect(requirements.InstanceTypes().UnsortedList()).To(ConsistOf("2", "4"))
+	})
+	It("should not allow values for keys that must not exist", func() {
+		requirements := Requirements{
+			{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-1"}},
+			{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpDoesNotExist},
+		}
+		Expect(requirements.Zones()).ToNot(BeNil())
+		Expect(requirements.Zones().Len()).To(BeZero())
+	})
+	It("should represent labels that don't exist by the empty value", func() {
+		requirements := Requirements{
+			{Key: "example.com/gpu", Operator: v1.NodeSelectorOpIn, Values: []string{"nvidia", ""}},
+			{Key: "example.com/gpu", Operator: v1.NodeSelectorOpExists},
+		}
+		Expect(requirements.Requirement("example.com/gpu").UnsortedList()).To(ConsistOf("nvidia"))
+		requirements = Requirements{
+			{Key: "example.com/gpu", Operator: v1.NodeSelectorOpIn, Values: []string{"nvidia", ""}},
+			{Key: "example.com/gpu", Operator: v1.NodeSelectorOpDoesNotExist},
+		}
+		Expect(requirements.Requirement("example.com/gpu").UnsortedList()).To(ConsistOf(""))
+	})
+	It("should leave keys that can't be enumerated unconstrained", func() {
+		requirements := Requirements{
+			{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpExists},
+			{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpGt, Values: []string{"1"}},
+		}
+		Expect(requirements.Zones()).To(BeNil())
+		Expect(requirements.InstanceTypes()).To(BeNil())
+	})
+	It("should keep requirements that can't be enumerated when consolidating", func() {
+		requirements := Requirements{
+			{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-1", "test-zone-2"}},
+			{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpExists},
+			{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpGt, Values: []string{"1"}},
+		}
+		consolidated := requirements.Consolidate()
+		Expect(consolidated).To(HaveLen(2))
+		Expect(consolidated.Zones().UnsortedList()).To(ConsistOf("test-zone-1", "test-zone-2"))
+		Expect(consolidated).To(ContainElement(v1.NodeSelectorRequirement{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpGt, Values: []string{"1"}}))
+	})
+	It("should match values against each operator", func() {
+		Expect(Allows(v1.NodeSelectorRequirement{Operator: v1.NodeSelectorOpIn, Values: []string{"a"}}, "a")).To(BeTrue())
+		Expect(Allows(v1.NodeSelectorRequirement{Operator: v1.NodeSelectorOpNotIn, Values: []string{"a"}}, "a")).To(BeFalse())
+		Expect(Allows(v1.NodeSelectorRequirement{Operator: v1.NodeSelectorOpExists}, "a")).To(BeTrue())
+		Expect(Allows(v1.NodeSelectorRequirement{Operator: v1.NodeSelectorOpDoesNotExist}, "a")).To(BeFalse())
+		Expect(Allows(v1.NodeSelectorRequirement{Operator: v1.NodeSelectorOpGt, Values: []string{"1"}}, "2")).To(BeTrue())
+		Expect(Allows(v1.NodeSelectorRequirement{Operator: v1.NodeSelectorOpGt, Values: []string{"1"}}, "1")).To(BeFalse())
+		Expect(Allows(v1.NodeSelectorRequirement{Operator: v1.NodeSelectorOpLt, Values: []string{"1"}}, "0")).To(BeTrue())
+		Expect(Allows(v1.NodeSelectorRequirement{Operator: v1.NodeSelectorOpLt, Values: []string{"1"}}, "a")).To(BeFalse())
+	})
+})
+
+var _ = Describe("Preferences", func() {
+	var requirements Requirements
+	var pod *v1.Pod
+
+	BeforeEach(func() {
+		requirements = Requirements{
+			{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-1", "test-zone-2", "test-zone-3"}},
+			{Key: LabelCapacityType, Operator: v1.NodeSelectorOpIn, Values: []string{"spot", "on-demand"}},
+		}
+		pod = &v1.Pod{Spec: v1.PodSpec{Affinity: &v1.Affinity{NodeAffinity: &v1.NodeAffinity{}}}}
+	})
+	preferred := func(weight int32, requirements ...v1.NodeSelectorRequirement) v1.PreferredSchedulingTerm {
+		return v1.PreferredSchedulingTerm{Weight: weight, Preference: v1.NodeSelectorTerm{MatchExpressions: requirements}}
+	}
+
+	It("should not change requirements without preferences", func() {
+		Expect(requirements.WithPreferences(&v1.Pod{})).To(Equal(requirements))
+	})
+	It("should apply compatible preferences", func() {
+		pod.Spec.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution = []v1.PreferredSchedulingTerm{
+			preferred(1, v1.NodeSelectorRequirement{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-2"}}),
+			preferred(1, v1.NodeSelectorRequirement{Key: LabelCapacityType, Operator: v1.NodeSelectorOpNotIn, Values: []string{"spot"}}),
+		}
+		tightened := requirements.WithPreferences(pod)
+		Expect(tightened.Zones().UnsortedList()).To(ConsistOf("test-zone-2"))
+		Expect(tightened.CapacityTypes().UnsortedList()).To(ConsistOf("on-demand"))
+	})
+	It("should ignore preferences that cannot be satisfied", func() {
+		pod.Spec.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution = []v1.PreferredSchedulingTerm{
+			preferred(100, v1.NodeSelectorRequirement{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"unknown"}}),
+		}
+		tightened := requirements.WithPreferences(pod)
+		Expect(tightened.Zones().UnsortedList()).To(ConsistOf("test-zone-1", "test-zone-2", "test-zone-3"))
+	})
+	It("should prefer heavier terms when preferences conflict", func() {
+		pod.Spec.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution = []v1.PreferredSchedulingTerm{
+			preferred(1, v1.NodeSelectorRequirement{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-1"}}),
+			preferred(50, v1.NodeSelectorRequirement{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-2"}}),
+		}
+		tightened := requirements.WithPreferences(pod)
+		Expect(tightened.Zones().UnsortedList()).To(ConsistOf("test-zone-2"))
+	})
+	It("should select the combination of terms with the greatest total weight", func() {
+		pod.Spec.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution = []v1.PreferredSchedulingTerm{
+			preferred(50, v1.NodeSelectorRequirement{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-1"}}),
+			preferred(30, v1.NodeSelectorRequirement{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-2", "test-zone-3"}}),
+			preferred(30, v1.NodeSelectorRequirement{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpNotIn, Values: []string{"test-zone-1", "test-zone-3"}}),
+		}
+		tightened := requirements.WithPreferences(pod)
+		Expect(tightened.Zones().UnsortedList()).To(ConsistOf("test-zone-2"))
+	})
+	It("should not modify the pod's preferences", func() {
+		pod.Spec.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution = []v1.PreferredSchedulingTerm{
+			preferred(1, v1.NodeSelectorRequirement{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-1"}}),
+			preferred(50, v1.NodeSelectorRequirement{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-2"}}),
+		}
+		expected := pod.DeepCopy()
+		requirements.WithPreferences(pod)
+		Expect(pod).To(Equal(expected))
+	})
+})
+
+var _ = Describe("Pod Requirements", func() {
+	var constraints *Constraints
+	var pod *v1.Pod
+
+	BeforeEach(func() {
+		constraints = &Constraints{Requirements: Requirements{
+			{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-1", "test-zone-2", "test-zone-3"}},
+			{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpIn, Values: []string{"small", "large"}},
+		}}
+		pod = &v1.Pod{Spec: v1.PodSpec{Affinity: &v1.Affinity{NodeAffinity: &v1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{},
+		}}}}
+	})
+	required := func(requirements ...v1.NodeSelectorRequirement) v1.NodeSelectorTerm {
+		return v1.NodeSelectorTerm{MatchExpressions: requirements}
+	}
+
+	It("should combine the node selector with each term", func() {
+		pod.Spec.NodeSelector = map[string]string{v1.LabelInstanceTypeStable: "small"}
+		pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms = []v1.NodeSelectorTerm{
+			required(v1.NodeSelectorRequirement{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-1"}}),
+			required(v1.NodeSelectorRequirement{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-2"}}),
+		}
+		alternatives := PodRequirementAlternatives(pod)
+		Expect(alternatives).To(HaveLen(2))
+		for i, zone := range []string{"test-zone-1", "test-zone-2"} {
+			Expect(alternatives[i].InstanceTypes().UnsortedList()).To(ConsistOf("small"))
+			Expect(alternatives[i].Zones().UnsortedList()).To(ConsistOf(zone))
+		}
+	})
+	It("should skip terms that cannot be satisfied", func() {
+		pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms = []v1.NodeSelectorTerm{
+			required(v1.NodeSelectorRequirement{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"unknown"}}),
+			required(v1.NodeSelectorRequirement{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-2"}}),
+		}
+		Expect(constraints.ValidatePod(pod)).To(Succeed())
+		Expect(constraints.Tighten(pod).Requirements.Zones().UnsortedList()).To(ConsistOf("test-zone-2"))
+	})
+	It("should fail if no terms can be satisfied", func() {
+		pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms = []v1.NodeSelectorTerm{
+			required(v1.NodeSelectorRequirement{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"unknown"}}),
+			required(v1.NodeSelectorRequirement{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpIn, Values: []string{"unknown"}}),
+		}
+		Expect(constraints.ValidatePod(pod)).ToNot(Succeed())
+	})
+	It("should select the term that allows the most options", func() {
+		pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms = []v1.NodeSelectorTerm{
+			required(v1.NodeSelectorRequirement{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpIn, Values: []string{"small"}}),
+			required(v1.NodeSelectorRequirement{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpNotIn, Values: []string{"test-zone-1"}}),
+		}
+		tightened := constraints.Tighten(pod)
+		Expect(tightened.Requirements.InstanceTypes().UnsortedList()).To(ConsistOf("small", "large"))
+		Expect(tightened.Requirements.Zones().UnsortedList()).To(ConsistOf("test-zone-2", "test-zone-3"))
+	})
+	It("should select the earliest term if terms allow the same options", func() {
+		pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms = []v1.NodeSelectorTerm{
+			required(v1.NodeSelectorRequirement{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-3"}}),
+			required(v1.NodeSelectorRequirement{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-1"}}),
+		}
+		Expect(constraints.Tighten(pod).Requirements.Zones().UnsortedList()).To(ConsistOf("test-zone-3"))
+	})
+	It("should allow keys that must exist if the constraints allow them", func() {
+		pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms = []v1.NodeSelectorTerm{
+			required(v1.NodeSelectorRequirement{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpExists}),
+		}
+		Expect(constraints.ValidatePod(pod)).To(Succeed())
+		Expect(constraints.Tighten(pod).Requirements.Zones().UnsortedList()).To(ConsistOf("test-zone-1", "test-zone-2", "test-zone-3"))
+	})
+	It("should allow keys that must not exist if the constraints don't constrain them", func() {
+		pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms = []v1.NodeSelectorTerm{
+			required(v1.NodeSelectorRequirement{Key: "example.com/gpu", Operator: v1.NodeSelectorOpDoesNotExist}),
+		}
+		Expect(constraints.ValidatePod(pod)).To(Succeed())
+	})
+	It("should fail for keys that must not exist if the constraints constrain them", func() {
+		pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms = []v1.NodeSelectorTerm{
+			required(v1.NodeSelectorRequirement{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpDoesNotExist}),
+		}
+		Expect(constraints.ValidatePod(pod)).ToNot(Succeed())
+	})
+})
+
+var _ = Describe("Label Templates", func() {
+	var constraints *Constraints
+	var namespace *v1.Namespace
+
+	BeforeEach(func() {
+		constraints = &Constraints{LabelTemplates: map[string]string{
+			"example.com/team":      "{{ .Namespace.Labels.team }}",
+			"example.com/app":       "{{ .Pod.Labels.app }}",
+			"example.com/namespace": "{{ .Namespace.Name }}",
+		}}
+		namespace = &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test-namespace", Labels: map[string]string{"team": "team-a"}}}
+	})
+
+	It("should render labels of the pod and its namespace", func() {
+		pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: namespace.Name, Labels: map[string]string{"app": "test-app"}}}
+		Expect(constraints.RenderLabelTemplates(pod, namespace)).To(Equal(map[string]string{
+			"example.com/team":      "team-a",
+			"example.com/app":       "test-app",
+			"example.com/namespace": "test-namespace",
+		}))
+	})
+	It("should omit labels that render empty", func() {
+		pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: namespace.Name}}
+		Expect(constraints.RenderLabelTemplates(pod, namespace)).To(Equal(map[string]string{
+			"example.com/team":      "team-a",
+			"example.com/namespace": "test-namespace",
+		}))
+	})
+	It("should fail for labels that render invalid values", func() {
+		pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: namespace.Name, Labels: map[string]string{"app": "test-app"}}}
+		namespace.Labels["team"] = "/ is not allowed"
+		_, err := constraints.RenderLabelTemplates(pod, namespace)
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("Hash", func() {
+	var spec *ProvisionerSpec
+
+	BeforeEach(func() {
+		spec = &ProvisionerSpec{
+			Constraints: Constraints{
+				Labels:   map[string]string{"test-key": "test-value"},
+				Provider: &runtime.RawExtension{Raw: []byte(`{"instanceProfile":"test-instance-profile"}`)},
+				KubeletConfiguration: KubeletConfiguration{
+					SystemReserved: v1.ResourceList{v1.ResourceMemory: resource.MustParse("1Gi")},
+				},
+			},
+		}
+	})
+
+	It("should be stable", func() {
+		hash, err := spec.Hash()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(spec.DeepCopy().Hash()).To(Equal(hash))
+	})
+	It("should change when the constraints change", func() {
+		hash, err := spec.Hash()
+		Expect(err).ToNot(HaveOccurred())
+		for _, mutate := range []func(*ProvisionerSpec){
+			func(s *ProvisionerSpec) { s.Labels["test-key"] = "other-value" },
+			func(s *ProvisionerSpec) { s.Taints = Taints{{Key: "test-key", Effect: v1.TaintEffectNoSchedule}} },
+			func(s *ProvisionerSpec) {
+				s.KubeletConfiguration.SystemReserved[v1.ResourceMemory] = resource.MustParse("2Gi")
+			},
+		} {
+			mutated := spec.DeepCopy()
+			mutate(mutated)
+			Expect(mutated.Hash()).ToNot(Equal(hash))
+		}
+	})
+	It("should not change when settings that nodes aren't launched with change", func() {
+		hash, err := spec.Hash()
+		Expect(err).ToNot(HaveOccurred())
+		spec.TTLSecondsAfterEmpty = ptr.Int64(30)
+		spec.TTLSecondsUntilExpired = ptr.Int64(3600)
+		spec.Limits.Resources = v1.ResourceList{v1.ResourceCPU: resource.MustParse("10")}
+		Expect(spec.Hash()).To(Equal(hash))
+	})
+	It("should leave the provider to the cloud provider", func() {
+		hash, err := spec.Hash()
+		Expect(err).ToNot(HaveOccurred())
+		spec.Provider.Raw = []byte(`{"instanceProfile":"other-instance-profile"}`)
+		Expect(spec.Hash()).To(Equal(hash))
+		spec.Provider = nil
+		spec.ProviderRef = &ProviderRef{Kind: "test-kind", Name: "test-name"}
+		Expect(spec.Hash()).To(Equal(hash))
+	})
+	It("should detect nodes launched from another spec", func() {
+		hash, err := spec.Hash()
+		Expect(err).ToNot(HaveOccurred())
+		node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{ProvisionerHashAnnotationKey: hash}}}
+		Expect(spec.HashChanged(node)).To(BeFalse())
+		spec.Labels["test-key"] = "other-value"
+		Expect(spec.HashChanged(node)).To(BeTrue())
+	})
+	It("should not detect nodes launched before their hash was recorded", func() {
+		Expect(spec.HashChanged(&v1.Node{})).To(BeFalse())
+	})
+})
+
+var _ = Describe("Updates", func() {
+	var original *Provisioner
+	var updateCtx context.Context
+	BeforeEach(func() {
+		original = &Provisioner{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-provisioner"},
+			Spec: ProvisionerSpec{Constraints: Constraints{Requirements: Requirements{
+				{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-1", "test-zone-2"}},
+			}}},
+		}
+		kubeClient := fake.NewClientBuilder().WithObjects(
+			&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-node-1", Labels: map[string]string{ProvisionerNameLabelKey: original.Name, v1.LabelTopologyZone: "test-zone-1"}}},
+			&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-node-2", Labels: map[string]string{ProvisionerNameLabelKey: "other-provisioner", v1.LabelTopologyZone: "test-zone-2"}}},
+		).Build()
+		updateCtx = apis.WithinUpdate(injection.WithKubeClient(ctx, kubeClient), original)
+	})
+
+	It("should allow updates that don't exclude existing nodes", func() {
+		updated := original.DeepCopy()
+		updated.Spec.Requirements[0].Values = []string{"test-zone-1"}
+		Expect(updated.validateUpdate(updateCtx)).To(BeNil())
+	})
+	It("should reject updates that exclude existing nodes", func() {
+		updated := original.DeepCopy()
+		updated.Spec.Requirements[0].Values = []string{"test-zone-2"}
+		err := updated.validateUpdate(updateCtx)
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring("test-node-1"))
+	})
+	It("should allow updates that exclude existing nodes if disruption is allowed", func() {
+		updated := original.DeepCopy()
+		updated.Annotations = map[string]string{AllowDisruptionAnnotationKey: "true"}
+		updated.Spec.Requirements[0].Values = []string{"test-zone-2"}
+		Expect(updated.validateUpdate(updateCtx)).To(BeNil())
+	})
+	It("should allow updates of nodes that were already excluded", func() {
+		original.Spec.Requirements[0].Values = []string{"test-zone-2"}
+		updated := original.DeepCopy()
+		updated.Spec.Requirements = append(updated.Spec.Requirements, v1.NodeSelectorRequirement{Key: v1.LabelArchStable, Operator: v1.NodeSelectorOpIn, Values: []string{"amd64"}})
+		Expect(updated.validateUpdate(updateCtx)).To(BeNil())
+	})
+	It("should not validate creates", func() {
+		updated := original.DeepCopy()
+		updated.Spec.Requirements[0].Values = []string{"test-zone-2"}
+		Expect(updated.validateUpdate(ctx)).To(BeNil())
+	})
+})
+
+var _ = Describe("ScheduledCapacity", func() {
+	var scheduledCapacity *ScheduledCapacity
+	// Windows start at 09:00 on weekdays and last an hour. 2021-01-04 is a Monday.
+	windowStart := time.Date(2021, time.January, 4, 9, 0, 0, 0, time.UTC)
+
+	BeforeEach(func() {
+		scheduledCapacity = &ScheduledCapacity{
+			ObjectMeta: metav1.ObjectMeta{Name: strings.ToLower(randomdata.SillyName())},
+			Spec: ScheduledCapacitySpec{
+				Provisioner: "default",
+				Schedule:    "0 9 * * 1-5",
+				Duration:    metav1.Duration{Duration: time.Hour},
+				Capacity:    []Headroom{{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")}}},
+			},
+		}
+	})
+
+	Context("Validation", func() {
+		It("should succeed", func() {
+			Expect(scheduledCapacity.Validate(ctx)).To(Succeed())
+		})
+		It("should fail without a provisioner", func() {
+			scheduledCapacity.Spec.Provisioner = ""
+			Expect(scheduledCapacity.Validate(ctx)).ToNot(Succeed())
+		})
+		It("should fail for invalid schedules", func() {
+			for _, schedule := range []string{"", "0 9 * *", "60 9 * * *", "0 9 * * 8", "0 9-7 * * *", "@daily"} {
+				scheduledCapacity.Spec.Schedule = schedule
+				Expect(scheduledCapacity.Validate(ctx)).ToNot(Succeed(), schedule)
+			}
+		})
+		It("should fail for durations that aren't positive", func() {
+			scheduledCapacity.Spec.Duration = metav1.Duration{}
+			Expect(scheduledCapacity.Validate(ctx)).ToNot(Succeed())
+		})
+		It("should fail for negative leads", func() {
+			scheduledCapacity.Spec.Lead = &metav1.Duration{Duration: -time.Minute}
+			Expect(scheduledCapacity.Validate(ctx)).ToNot(Succeed())
+		})
+		It("should fail without capacity", func() {
+			scheduledCapacity.Spec.Capacity = nil
+			Expect(scheduledCapacity.Validate(ctx)).ToNot(Succeed())
+		})
+		It("should fail for invalid capacity", func() {
+			scheduledCapacity.Spec.Capacity[0].Replicas = ptr.Int32(-1)
+			Expect(scheduledCapacity.Validate(ctx)).ToNot(Succeed())
+		})
+	})
+	Context("Window", func() {
+		It("should be inactive before the lead, until the lead", func() {
+			start, active, next, err := scheduledCapacity.Spec.Window(windowStart.Add(-time.Hour))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(active).To(BeFalse())
+			Expect(start.IsZero()).To(BeTrue())
+			Expect(next).To(Equal(windowStart.Add(-DefaultScheduledCapacityLead)))
+		})
+		It("should be active during the lead, until the window ends", func() {
+			start, active, next, err := scheduledCapacity.Spec.Window(windowStart.Add(-5 * time.Minute))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(active).To(BeTrue())
+			Expect(start).To(Equal(windowStart))
+			Expect(next).To(Equal(windowStart.Add(time.Hour)))
+		})
+		It("should be active during the window", func() {
+			start, active, _, err := scheduledCapacity.Spec.Window(windowStart.Add(59 * time.Minute))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(active).To(BeTrue())
+			Expect(start).To(Equal(windowStart))
+		})
+		It("should be inactive after the window, until the next lead", func() {
+			_, active, next, err := scheduledCapacity.Spec.Window(windowStart.Add(time.Hour))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(active).To(BeFalse())
+			Expect(next).To(Equal(windowStart.Add(24*time.Hour - DefaultScheduledCapacityLead)))
+		})
+		It("should skip days that aren't scheduled", func() {
+			_, active, next, err := scheduledCapacity.Spec.Window(windowStart.Add(4*24*time.Hour + time.Hour))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(active).To(BeFalse())
+			Expect(next).To(Equal(windowStart.Add(7*24*time.Hour - DefaultScheduledCapacityLead)))
+		})
+		It("should respect the lead", func() {
+			scheduledCapacity.Spec.Lead = &metav1.Duration{Duration: time.Hour}
+			start, active, _, err := scheduledCapacity.Spec.Window(windowStart.Add(-30 * time.Minute))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(active).To(BeTrue())
+			Expect(start).To(Equal(windowStart))
+		})
+	})
+})
+
+var _ = Describe("Expiration", func() {
+	var spec *ProvisionerSpec
+
+	BeforeEach(func() {
+		spec = &ProvisionerSpec{TTLSecondsUntilExpired: ptr.Int64(1000)}
+	})
+
+	node := func() *v1.Node {
+		return &v1.Node{ObjectMeta: metav1.ObjectMeta{UID: types.UID(randomdata.Alphanumeric(36))}}
+	}
+
+	It("should not expire nodes without a TTL", func() {
+		spec.TTLSecondsUntilExpired = nil
+		_, ok := spec.ExpirationTTL(node())
+		Expect(ok).To(BeFalse())
+	})
+	It("should expire nodes at the TTL without jitter", func() {
+		ttl, ok := spec.ExpirationTTL(node())
+		Expect(ok).To(BeTrue())
+		Expect(ttl).To(Equal(1000 * time.Second))
+	})
+	It("should jitter the TTL of each node within the percentage", func() {
+		spec.ExpirationJitterPercent = ptr.Int32(10)
+		ttls := sets.NewInt64()
+		for i := 0; i < 100; i++ {
+			ttl, ok := spec.ExpirationTTL(node())
+			Expect(ok).To(BeTrue())
+			Expect(ttl).To(BeNumerically(">=", 900*time.Second))
+			Expect(ttl).To(BeNumerically("<=", 1100*time.Second))
+			ttls.Insert(int64(ttl))
+		}
+		Expect(ttls.Len()).To(BeNumerically(">", 1))
+	})
+	It("should jitter the TTL of a node the same way every time", func() {
+		spec.ExpirationJitterPercent = ptr.Int32(10)
+		n := node()
+		ttl, _ := spec.ExpirationTTL(n)
+		for i := 0; i < 10; i++ {
+			again, _ := spec.ExpirationTTL(n)
+			Expect(again).To(Equal(ttl))
+		}
 	})
 })
@@ -0,0 +1,130 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+)
+
+// ProvisionerSpec is the top level provisioner specification. It is the same
+// as v1alpha5's, except that cloud provider specific configuration may only be
+// referenced with ProviderRef, rather than inlined.
+type ProvisionerSpec struct {
+	// Labels are layered with Requirements and applied to every node.
+	//+optional
+	Labels map[string]string `json:"labels,omitempty"`
+	// LabelTemplates are rendered for each pod and applied to the node that
+	// the pod is launched on, e.g. team: "{{ .Namespace.Labels.team }}". They
+	// are Go templates of the pod and its namespace. Pods are only launched on
+	// the same node as pods whose templates render the same labels. Labels
+	// that render empty are omitted.
+	// +optional
+	LabelTemplates map[string]string `json:"labelTemplates,omitempty"`
+	// Taints will be applied to every node launched by the Provisioner. If
+	// specified, the provisioner will not provision nodes for pods that do not
+	// have matching tolerations. Additional taints will be created that match
+	// pod tolerations on a per-node basis.
+	// +optional
+	Taints v1alpha5.Taints `json:"taints,omitempty"`
+	// StartupTaints will be applied to every node launched by the Provisioner,
+	// and are expected to be removed by another agent once the node starts,
+	// e.g. when a CNI or CSI driver is ready. Unlike Taints, pods are not
+	// required to tolerate them.
+	// +optional
+	StartupTaints v1alpha5.Taints `json:"startupTaints,omitempty"`
+	// Requirements are layered with Labels and applied to every node.
+	Requirements v1alpha5.Requirements `json:"requirements,omitempty"`
+	// KubeletConfiguration are options passed to the kubelet when provisioning nodes
+	//+optional
+	KubeletConfiguration v1alpha5.KubeletConfiguration `json:"kubeletConfiguration,omitempty"`
+	// ProviderRef is a reference to a cloud provider specific resource that
+	// configures the nodes launched by this provisioner.
+	// +optional
+	ProviderRef *v1alpha5.ProviderRef `json:"providerRef,omitempty"`
+	// TTLSecondsAfterEmpty is the number of seconds the controller will wait
+	// before attempting to delete a node, measured from when the node is
+	// detected to be empty. A Node is considered to be empty when it does not
+	// have pods scheduled to it, excluding daemonsets.
+	//
+	// Termination due to underutilization is disabled if this field is not set.
+	// +optional
+	TTLSecondsAfterEmpty *int64 `json:"ttlSecondsAfterEmpty,omitempty"`
+	// TTLSecondsUntilExpired is the number of seconds the controller will wait
+	// before terminating a node, measured from when the node is created. This
+	// is useful to implement features like eventually consistent node upgrade,
+	// memory leak protection, and disruption testing.
+	//
+	// Termination due to expiration is disabled if this field is not set.
+	// +optional
+	TTLSecondsUntilExpired *int64 `json:"ttlSecondsUntilExpired,omitempty"`
+	// ExpirationJitterPercent varies the expiration of each node by up to the
+	// percentage of TTLSecondsUntilExpired, earlier or later, so that nodes
+	// launched together don't expire together. Defaults to 0.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=50
+	// +optional
+	ExpirationJitterPercent *int32 `json:"expirationJitterPercent,omitempty"`
+	// Limits define a set of bounds for provisioning capacity.
+	Limits v1alpha5.Limits `json:"limits,omitempty"`
+	// Provisioning configures how nodes are computed for pending pods.
+	// +optional
+	Provisioning v1alpha5.Provisioning `json:"provisioning,omitempty"`
+	// Consolidation configures replacing underutilized nodes with cheaper
+	// capacity.
+	// +optional
+	Consolidation v1alpha5.Consolidation `json:"consolidation,omitempty"`
+	// Drift configures replacing nodes that no longer match the provisioner.
+	// +optional
+	Drift v1alpha5.Drift `json:"drift,omitempty"`
+	// Disruption limits the voluntary disruption of the provisioner's nodes.
+	// +optional
+	Disruption v1alpha5.Disruption `json:"disruption,omitempty"`
+	// DeletionPolicy determines what happens to the provisioner's nodes when
+	// it's deleted: Drain deletes them, Orphan leaves them running, and
+	// Terminate terminates them without draining them. Defaults to Drain.
+	// +kubebuilder:validation:Enum=Drain;Orphan;Terminate
+	// +optional
+	DeletionPolicy v1alpha5.DeletionPolicy `json:"deletionPolicy,omitempty"`
+	// PreemptionPolicy determines whether nodes are launched for pending pods
+	// that kube-scheduler could schedule on existing nodes by preempting lower
+	// priority pods: Launch launches nodes for them regardless, and Defer waits
+	// for the preemption, so only the preempted pods need new nodes. Defaults
+	// to Launch.
+	// +kubebuilder:validation:Enum=Launch;Defer
+	// +optional
+	PreemptionPolicy v1alpha5.PreemptionPolicy `json:"preemptionPolicy,omitempty"`
+}
+
+// Provisioner is the Schema for the Provisioners API
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:path=provisioners,scope=Cluster
+// +kubebuilder:subresource:status
+type Provisioner struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ProvisionerSpec            `json:"spec,omitempty"`
+	Status v1alpha5.ProvisionerStatus `json:"status,omitempty"`
+}
+
+// ProvisionerList contains a list of Provisioner
+// +kubebuilder:object:root=true
+type ProvisionerList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Provisioner `json:"items"`
+}
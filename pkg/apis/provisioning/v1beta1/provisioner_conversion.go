@@ -0,0 +1,116 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"knative.dev/pkg/apis"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+)
+
+// v1beta1 is the conversion hub, so it implements conversions to and from
+// every other version. Fields that a version can't represent are preserved in
+// annotations, so that conversions round trip without loss.
+
+// ConvertTo converts the provisioner to the version of the sink
+func (p *Provisioner) ConvertTo(ctx context.Context, to apis.Convertible) error {
+	switch sink := to.(type) {
+	case *v1alpha5.Provisioner:
+		p.ObjectMeta.DeepCopyInto(&sink.ObjectMeta)
+		p.Status.DeepCopyInto(&sink.Status)
+		spec := p.Spec.DeepCopy()
+		sink.Spec = v1alpha5.ProvisionerSpec{
+			Constraints: v1alpha5.Constraints{
+				Labels:               spec.Labels,
+				LabelTemplates:       spec.LabelTemplates,
+				Taints:               spec.Taints,
+				StartupTaints:        spec.StartupTaints,
+				Requirements:         spec.Requirements,
+				KubeletConfiguration: spec.KubeletConfiguration,
+				ProviderRef:          spec.ProviderRef,
+			},
+			TTLSecondsAfterEmpty:    spec.TTLSecondsAfterEmpty,
+			TTLSecondsUntilExpired:  spec.TTLSecondsUntilExpired,
+			ExpirationJitterPercent: spec.ExpirationJitterPercent,
+			Limits:                  spec.Limits,
+			Provisioning:            spec.Provisioning,
+			Consolidation:           spec.Consolidation,
+			Drift:                   spec.Drift,
+			Disruption:              spec.Disruption,
+			DeletionPolicy:          spec.DeletionPolicy,
+			PreemptionPolicy:        spec.PreemptionPolicy,
+		}
+		if provider, ok := popAnnotation(&sink.ObjectMeta, ProviderAnnotationKey); ok {
+			sink.Spec.Provider = &runtime.RawExtension{Raw: []byte(provider)}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown version, got: %T", sink)
+	}
+}
+
+// ConvertFrom converts the provisioner from the version of the source
+func (p *Provisioner) ConvertFrom(ctx context.Context, from apis.Convertible) error {
+	switch source := from.(type) {
+	case *v1alpha5.Provisioner:
+		source.ObjectMeta.DeepCopyInto(&p.ObjectMeta)
+		source.Status.DeepCopyInto(&p.Status)
+		spec := source.Spec.DeepCopy()
+		p.Spec = ProvisionerSpec{
+			Labels:                  spec.Labels,
+			LabelTemplates:          spec.LabelTemplates,
+			Taints:                  spec.Taints,
+			StartupTaints:           spec.StartupTaints,
+			Requirements:            spec.Requirements,
+			KubeletConfiguration:    spec.KubeletConfiguration,
+			ProviderRef:             spec.ProviderRef,
+			TTLSecondsAfterEmpty:    spec.TTLSecondsAfterEmpty,
+			TTLSecondsUntilExpired:  spec.TTLSecondsUntilExpired,
+			ExpirationJitterPercent: spec.ExpirationJitterPercent,
+			Limits:                  spec.Limits,
+			Provisioning:            spec.Provisioning,
+			Consolidation:           spec.Consolidation,
+			Drift:                   spec.Drift,
+			Disruption:              spec.Disruption,
+			DeletionPolicy:          spec.DeletionPolicy,
+			PreemptionPolicy:        spec.PreemptionPolicy,
+		}
+		if spec.Provider != nil {
+			metav1.SetMetaDataAnnotation(&p.ObjectMeta, ProviderAnnotationKey, string(spec.Provider.Raw))
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown version, got: %T", source)
+	}
+}
+
+// popAnnotation removes the annotation from the object, returning its value
+// and whether it was present.
+func popAnnotation(meta *metav1.ObjectMeta, key string) (string, bool) {
+	value, ok := meta.Annotations[key]
+	if !ok {
+		return "", false
+	}
+	delete(meta.Annotations, key)
+	if len(meta.Annotations) == 0 {
+		meta.Annotations = nil
+	}
+	return value, true
+}
@@ -0,0 +1,36 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"context"
+
+	"knative.dev/pkg/logging"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+)
+
+// SetDefaults for the provisioner are the same as v1alpha5's
+func (p *Provisioner) SetDefaults(ctx context.Context) {
+	provisioner := &v1alpha5.Provisioner{}
+	if err := p.ConvertTo(ctx, provisioner); err != nil {
+		logging.FromContext(ctx).Errorf("Failed to convert provisioner, %s", err.Error())
+		return
+	}
+	provisioner.SetDefaults(ctx)
+	if err := p.ConvertFrom(ctx, provisioner); err != nil {
+		logging.FromContext(ctx).Errorf("Failed to convert provisioner, %s", err.Error())
+	}
+}
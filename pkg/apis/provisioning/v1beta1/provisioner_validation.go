@@ -0,0 +1,40 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"context"
+
+	"knative.dev/pkg/apis"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+)
+
+// Validate the provisioner with the same rules as v1alpha5
+func (p *Provisioner) Validate(ctx context.Context) (errs *apis.FieldError) {
+	provisioner := &v1alpha5.Provisioner{}
+	if err := p.ConvertTo(ctx, provisioner); err != nil {
+		return errs.Also(apis.ErrGeneric(err.Error()))
+	}
+	// Updates are validated against the original as v1alpha5 as well
+	if original, ok := apis.GetBaseline(ctx).(*Provisioner); ok && apis.IsInUpdate(ctx) && !apis.IsInStatusUpdate(ctx) {
+		baseline := &v1alpha5.Provisioner{}
+		if err := original.ConvertTo(ctx, baseline); err != nil {
+			return errs.Also(apis.ErrGeneric(err.Error()))
+		}
+		ctx = apis.WithinUpdate(ctx, baseline)
+	}
+	return errs.Also(provisioner.Validate(ctx))
+}
@@ -0,0 +1,185 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/Pallinder/go-randomdata"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "knative.dev/pkg/logging/testing"
+	"knative.dev/pkg/ptr"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"knative.dev/pkg/apis"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/utils/injection"
+)
+
+var ctx context.Context
+
+func TestAPIs(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "v1beta1")
+}
+
+var _ = Describe("Conversion", func() {
+	var v1alpha5Provisioner *v1alpha5.Provisioner
+	var v1beta1Provisioner *Provisioner
+
+	BeforeEach(func() {
+		v1alpha5Provisioner = &v1alpha5.Provisioner{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        strings.ToLower(randomdata.SillyName()),
+				Annotations: map[string]string{"foo": "bar"},
+			},
+			Spec: v1alpha5.ProvisionerSpec{
+				Constraints: v1alpha5.Constraints{
+					Labels:         map[string]string{"team": "a"},
+					LabelTemplates: map[string]string{"example.com/app": "{{ .Pod.Labels.app }}"},
+					Taints:         v1alpha5.Taints{{Key: "a", Effect: v1.TaintEffectNoSchedule}},
+					StartupTaints:  v1alpha5.Taints{{Key: "b", Effect: v1.TaintEffectNoExecute}},
+					Requirements: v1alpha5.Requirements{
+						{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-1"}},
+					},
+					KubeletConfiguration: v1alpha5.KubeletConfiguration{ClusterDNS: []string{"10.0.0.10"}},
+					Provider:             &runtime.RawExtension{Raw: []byte(`{"instanceProfile":"test-profile"}`)},
+				},
+				TTLSecondsAfterEmpty:   ptr.Int64(30),
+				TTLSecondsUntilExpired: ptr.Int64(3600),
+				Limits: v1alpha5.Limits{
+					Resources: v1.ResourceList{v1.ResourceCPU: resource.MustParse("100")},
+					MaxNodes:  ptr.Int32(10),
+				},
+			},
+			Status: v1alpha5.ProvisionerStatus{Nodes: 3},
+		}
+		v1beta1Provisioner = &Provisioner{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        strings.ToLower(randomdata.SillyName()),
+				Annotations: map[string]string{"foo": "bar"},
+			},
+			Spec: ProvisionerSpec{
+				Labels:       map[string]string{"team": "a"},
+				Taints:       v1alpha5.Taints{{Key: "a", Effect: v1.TaintEffectNoSchedule}},
+				Requirements: v1alpha5.Requirements{{Key: v1.LabelArchStable, Operator: v1.NodeSelectorOpExists}},
+				ProviderRef: &v1alpha5.ProviderRef{
+					APIVersion: "karpenter.k8s.aws/v1alpha1",
+					Kind:       "AWSNodeTemplate",
+					Name:       "default",
+				},
+				TTLSecondsAfterEmpty: ptr.Int64(30),
+				Limits:               v1alpha5.Limits{MaxNodes: ptr.Int32(10)},
+			},
+			Status: v1alpha5.ProvisionerStatus{Nodes: 3},
+		}
+	})
+
+	It("should round trip v1alpha5 through v1beta1", func() {
+		converted := &Provisioner{}
+		Expect(converted.ConvertFrom(ctx, v1alpha5Provisioner.DeepCopy())).To(Succeed())
+		result := &v1alpha5.Provisioner{}
+		Expect(converted.ConvertTo(ctx, result)).To(Succeed())
+		Expect(result).To(Equal(v1alpha5Provisioner))
+	})
+	It("should round trip v1beta1 through v1alpha5", func() {
+		converted := &v1alpha5.Provisioner{}
+		Expect(v1beta1Provisioner.DeepCopy().ConvertTo(ctx, converted)).To(Succeed())
+		result := &Provisioner{}
+		Expect(result.ConvertFrom(ctx, converted)).To(Succeed())
+		Expect(result).To(Equal(v1beta1Provisioner))
+	})
+	It("should preserve the v1alpha5 provider in an annotation", func() {
+		converted := &Provisioner{}
+		Expect(converted.ConvertFrom(ctx, v1alpha5Provisioner)).To(Succeed())
+		Expect(converted.Spec.ProviderRef).To(BeNil())
+		Expect(converted.Annotations).To(HaveKeyWithValue(ProviderAnnotationKey, `{"instanceProfile":"test-profile"}`))
+		Expect(converted.Annotations).To(HaveKeyWithValue("foo", "bar"))
+		Expect(v1alpha5Provisioner.Annotations).ToNot(HaveKey(ProviderAnnotationKey))
+	})
+	It("should convert the provider ref", func() {
+		converted := &v1alpha5.Provisioner{}
+		Expect(v1beta1Provisioner.ConvertTo(ctx, converted)).To(Succeed())
+		Expect(converted.Spec.Provider).To(BeNil())
+		Expect(converted.Spec.ProviderRef).To(Equal(v1beta1Provisioner.Spec.ProviderRef))
+		Expect(converted.Annotations).To(Equal(map[string]string{"foo": "bar"}))
+	})
+	It("should not add annotations if there is nothing to preserve", func() {
+		v1alpha5Provisioner.Annotations = nil
+		v1alpha5Provisioner.Spec.Provider = nil
+		converted := &Provisioner{}
+		Expect(converted.ConvertFrom(ctx, v1alpha5Provisioner)).To(Succeed())
+		Expect(converted.Annotations).To(BeNil())
+		result := &v1alpha5.Provisioner{}
+		Expect(converted.ConvertTo(ctx, result)).To(Succeed())
+		Expect(result.Annotations).To(BeNil())
+	})
+	It("should fail for unknown versions", func() {
+		Expect(v1beta1Provisioner.ConvertTo(ctx, &Provisioner{})).ToNot(Succeed())
+		Expect(v1beta1Provisioner.ConvertFrom(ctx, &Provisioner{})).ToNot(Succeed())
+	})
+	It("should only convert from the hub", func() {
+		Expect(v1alpha5Provisioner.ConvertTo(ctx, v1beta1Provisioner)).ToNot(Succeed())
+		Expect(v1alpha5Provisioner.ConvertFrom(ctx, v1beta1Provisioner)).ToNot(Succeed())
+	})
+})
+
+var _ = Describe("Validation", func() {
+	var provisioner *Provisioner
+
+	BeforeEach(func() {
+		provisioner = &Provisioner{
+			ObjectMeta: metav1.ObjectMeta{Name: strings.ToLower(randomdata.SillyName())},
+			Spec: ProvisionerSpec{
+				ProviderRef: &v1alpha5.ProviderRef{Kind: "AWSNodeTemplate", Name: "default"},
+			},
+		}
+	})
+
+	It("should succeed for a provider ref", func() {
+		Expect(provisioner.Validate(ctx)).To(Succeed())
+	})
+	It("should fail for a provider ref without a kind", func() {
+		provisioner.Spec.ProviderRef.Kind = ""
+		Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+	})
+	It("should fail for a provider ref without a name", func() {
+		provisioner.Spec.ProviderRef.Name = ""
+		Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+	})
+	It("should validate the spec like v1alpha5", func() {
+		provisioner.Spec.TTLSecondsAfterEmpty = ptr.Int64(-1)
+		Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+	})
+	It("should validate updates against the original", func() {
+		provisioner.Spec.Requirements = v1alpha5.Requirements{{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-1"}}}
+		original := provisioner.DeepCopy()
+		kubeClient := fake.NewClientBuilder().WithObjects(&v1.Node{ObjectMeta: metav1.ObjectMeta{
+			Name:   "test-node",
+			Labels: map[string]string{v1alpha5.ProvisionerNameLabelKey: provisioner.Name, v1.LabelTopologyZone: "test-zone-1"},
+		}}).Build()
+		provisioner.Spec.Requirements[0].Values = []string{"test-zone-2"}
+		Expect(provisioner.Validate(apis.WithinUpdate(injection.WithKubeClient(ctx, kubeClient), original))).ToNot(Succeed())
+	})
+})
@@ -0,0 +1,139 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package audit records every decision to create or delete a node to an
+// external sink, with the pods, constraints and prices that explain it, for
+// teams that must account for every change to their infrastructure.
+package audit
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	v1 "k8s.io/api/core/v1"
+	"knative.dev/pkg/logging"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Action is the change to a node that a record explains
+type Action string
+
+const (
+	// ActionCreate is the launch of a node for pending pods or headroom
+	ActionCreate Action = "Create"
+	// ActionDelete is a decision to delete a node, e.g. because it expired
+	ActionDelete Action = "Delete"
+	// ActionTerminate is the termination of a deleted node's instance, whoever
+	// deleted it
+	ActionTerminate Action = "Terminate"
+)
+
+// Record explains a change to a node
+type Record struct {
+	Time         time.Time `json:"time"`
+	Action       Action    `json:"action"`
+	Node         string    `json:"node"`
+	ProviderID   string    `json:"providerID,omitempty"`
+	Provisioner  string    `json:"provisioner,omitempty"`
+	InstanceType string    `json:"instanceType,omitempty"`
+	Zone         string    `json:"zone,omitempty"`
+	CapacityType string    `json:"capacityType,omitempty"`
+	// Price is the hourly price of the node's offering, if it's known
+	Price string `json:"price,omitempty"`
+	// Reason is why the node was deleted
+	Reason string `json:"reason,omitempty"`
+	// Pods are the namespace/name of the pods that the node was launched for,
+	// or that were running on it when it was deleted
+	Pods []string `json:"pods,omitempty"`
+	// Requirements are the constraints that the node was launched for
+	Requirements v1alpha5.Requirements `json:"requirements,omitempty"`
+	// InstanceTypeOptions are the instance types that the cloud provider chose
+	// the node's from
+	InstanceTypeOptions []string `json:"instanceTypeOptions,omitempty"`
+}
+
+// Sink stores records durably
+type Sink interface {
+	Write(context.Context, *Record) error
+}
+
+type sinkKey struct{}
+
+// WithSink injects the sink that records are written to
+func WithSink(ctx context.Context, sink Sink) context.Context {
+	return context.WithValue(ctx, sinkKey{}, sink)
+}
+
+// GetSink returns the injected sink, or nil if records aren't written
+func GetSink(ctx context.Context) Sink {
+	sink := ctx.Value(sinkKey{})
+	if sink == nil {
+		return nil
+	}
+	return sink.(Sink)
+}
+
+// Created records the launch of the node for the pods, constrained by the
+// requirements to the instance type options
+func Created(ctx context.Context, node *v1.Node, pods []*v1.Pod, requirements v1alpha5.Requirements, instanceTypeOptions []string) {
+	record := recordFor(ActionCreate, node, pods)
+	record.Requirements = requirements
+	record.InstanceTypeOptions = instanceTypeOptions
+	write(ctx, record)
+}
+
+// Deleted records the decision to delete the node for the reason, with the pods
+// that were running on it
+func Deleted(ctx context.Context, node *v1.Node, reason string, pods []*v1.Pod) {
+	record := recordFor(ActionDelete, node, pods)
+	record.Reason = reason
+	write(ctx, record)
+}
+
+// Terminated records the termination of the node's instance
+func Terminated(ctx context.Context, node *v1.Node) {
+	write(ctx, recordFor(ActionTerminate, node, nil))
+}
+
+func recordFor(action Action, node *v1.Node, pods []*v1.Pod) *Record {
+	record := &Record{
+		Time:         time.Now().UTC(),
+		Action:       action,
+		Node:         node.Name,
+		ProviderID:   node.Spec.ProviderID,
+		Provisioner:  node.Labels[v1alpha5.ProvisionerNameLabelKey],
+		InstanceType: node.Labels[v1.LabelInstanceTypeStable],
+		Zone:         node.Labels[v1.LabelTopologyZone],
+		CapacityType: node.Labels[v1alpha5.LabelCapacityType],
+		Price:        node.Annotations[v1alpha5.PriceAnnotationKey],
+	}
+	for _, pod := range pods {
+		record.Pods = append(record.Pods, client.ObjectKeyFromObject(pod).String())
+	}
+	return record
+}
+
+// write is best effort, so that an unavailable sink never fails provisioning
+// or deprovisioning, but failures are logged with the record so that it isn't
+// lost. The controller wraps its sink in a Queue, so that writes never block.
+func write(ctx context.Context, record *Record) {
+	sink := GetSink(ctx)
+	if sink == nil {
+		return
+	}
+	if err := sink.Write(ctx, record); err != nil {
+		logging.FromContext(ctx).With("record", record).Errorf("Failed to write audit record, %s", err.Error())
+	}
+}
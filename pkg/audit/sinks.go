@@ -0,0 +1,217 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/karpenter/pkg/utils/project"
+	"knative.dev/pkg/logging"
+)
+
+// writeTimeout bounds how long a remote sink may delay the decision it records
+const writeTimeout = 10 * time.Second
+
+// queueSize bounds the records that wait to be written, so that an
+// unavailable sink can't hold an unbounded number of them in memory
+const queueSize = 1000
+
+// NewSink returns the sink at the URI. A file URI appends a line of JSON per
+// record, e.g. file:///var/log/karpenter/audit.log, an S3 URI puts an object
+// per record, e.g. s3://bucket/prefix?region=us-west-2, and an HTTP(S) URI
+// POSTs each record as JSON.
+func NewSink(uri string) (Sink, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("parsing audit sink, %w", err)
+	}
+	switch u.Scheme {
+	case "file":
+		return NewFileSink(u.Path)
+	case "s3":
+		config := &aws.Config{}
+		if region := u.Query().Get("region"); region != "" {
+			config.Region = aws.String(region)
+		}
+		sess, err := session.NewSession(config)
+		if err != nil {
+			return nil, fmt.Errorf("creating session, %w", err)
+		}
+		return NewS3Sink(s3.New(sess), u.Host, strings.TrimPrefix(u.Path, "/")), nil
+	case "http", "https":
+		return NewWebhookSink(uri), nil
+	default:
+		return nil, fmt.Errorf("audit sink must be a file://, s3:// or http(s):// URI, but found \"%s\"", uri)
+	}
+}
+
+// Queue writes records to a sink in the background, so that the controllers,
+// which may hold locks while they change nodes, never wait for the sink.
+// Records that don't fit in the queue fail to write, and are logged instead.
+type Queue struct {
+	sink    Sink
+	records chan *Record
+}
+
+// NewQueue is a constructor
+func NewQueue(sink Sink) *Queue {
+	return &Queue{sink: sink, records: make(chan *Record, queueSize)}
+}
+
+// Write enqueues the record without waiting for the sink
+func (q *Queue) Write(_ context.Context, record *Record) error {
+	select {
+	case q.records <- record:
+		return nil
+	default:
+		return fmt.Errorf("audit queue is full")
+	}
+}
+
+// Start writes the queued records to the sink until the context is done. The
+// records that are still queued then are logged, so that they aren't lost.
+func (q *Queue) Start(ctx context.Context) {
+	go func() {
+		for {
+			select {
+			case record := <-q.records:
+				if err := q.sink.Write(ctx, record); err != nil {
+					logging.FromContext(ctx).With("record", record).Errorf("Failed to write audit record, %s", err.Error())
+				}
+			case <-ctx.Done():
+				for {
+					select {
+					case record := <-q.records:
+						logging.FromContext(ctx).With("record", record).Errorf("Failed to write audit record, %s", ctx.Err().Error())
+					default:
+						return
+					}
+				}
+			}
+		}
+	}()
+}
+
+// FileSink appends each record to a file as a line of JSON
+type FileSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileSink opens the file to append to, creating it if it doesn't exist
+func NewFileSink(name string) (*FileSink, error) {
+	file, err := os.OpenFile(name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("opening audit log, %w", err)
+	}
+	return &FileSink{file: file}, nil
+}
+
+func (s *FileSink) Write(_ context.Context, record *Record) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+// S3Sink puts each record as an object, keyed by the prefix, date, time,
+// action and node, so that records are listed in order
+type S3Sink struct {
+	api    s3iface.S3API
+	bucket string
+	prefix string
+}
+
+// NewS3Sink is a constructor
+func NewS3Sink(api s3iface.S3API, bucket string, prefix string) *S3Sink {
+	return &S3Sink{api: api, bucket: bucket, prefix: prefix}
+}
+
+func (s *S3Sink) Write(ctx context.Context, record *Record) error {
+	body, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, writeTimeout)
+	defer cancel()
+	if _, err := s.api.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(s.Key(record)),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	}); err != nil {
+		return fmt.Errorf("putting object, %w", err)
+	}
+	return nil
+}
+
+// Key returns the key of the record's object
+func (s *S3Sink) Key(record *Record) string {
+	return path.Join(s.prefix, record.Time.Format("2006/01/02"),
+		fmt.Sprintf("%s-%s-%s.json", record.Time.Format("150405.000000000"), record.Action, record.Node))
+}
+
+// WebhookSink POSTs each record to a URL as JSON
+type WebhookSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookSink is a constructor
+func NewWebhookSink(url string) *WebhookSink {
+	return &WebhookSink{url: url, client: &http.Client{Timeout: writeTimeout}}
+}
+
+func (s *WebhookSink) Write(ctx context.Context, record *Record) error {
+	body, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", fmt.Sprintf("karpenter/%s", project.Version))
+	response, err := s.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("posting record, %s", response.Status)
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/audit"
+	"github.com/aws/karpenter/pkg/test"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+)
+
+var ctx context.Context
+var sink *fakeSink
+var node *v1.Node
+
+func TestAudit(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Audit")
+}
+
+var _ = BeforeEach(func() {
+	sink = &fakeSink{}
+	ctx = audit.WithSink(context.Background(), sink)
+	node = test.Node(test.NodeOptions{
+		Name: "test-node",
+		Labels: map[string]string{
+			v1alpha5.ProvisionerNameLabelKey: "default",
+			v1.LabelInstanceTypeStable:       "m5.large",
+			v1alpha5.LabelCapacityType:       "spot",
+			v1.LabelTopologyZone:             "test-zone-1",
+		},
+		Annotations: map[string]string{v1alpha5.PriceAnnotationKey: "0.096"},
+		ProviderID:  "fake:///test-zone-1/i-1234",
+	})
+})
+
+var _ = Describe("Audit", func() {
+	Context("Records", func() {
+		It("should record created nodes with their pods, constraints and price", func() {
+			pod := test.Pod()
+			requirements := v1alpha5.Requirements{{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-1"}}}
+			audit.Created(ctx, node, []*v1.Pod{pod}, requirements, []string{"m5.large", "m5.xlarge"})
+			Expect(sink.records).To(HaveLen(1))
+			record := sink.records[0]
+			Expect(record.Action).To(Equal(audit.ActionCreate))
+			Expect(record.Node).To(Equal("test-node"))
+			Expect(record.ProviderID).To(Equal("fake:///test-zone-1/i-1234"))
+			Expect(record.Provisioner).To(Equal("default"))
+			Expect(record.InstanceType).To(Equal("m5.large"))
+			Expect(record.CapacityType).To(Equal("spot"))
+			Expect(record.Zone).To(Equal("test-zone-1"))
+			Expect(record.Price).To(Equal("0.096"))
+			Expect(record.Pods).To(ConsistOf(pod.Namespace + "/" + pod.Name))
+			Expect(record.Requirements).To(Equal(requirements))
+			Expect(record.InstanceTypeOptions).To(Equal([]string{"m5.large", "m5.xlarge"}))
+		})
+		It("should record deleted nodes with their reason and pods", func() {
+			pod := test.Pod()
+			audit.Deleted(ctx, node, "expired after 1h0m0s", []*v1.Pod{pod})
+			Expect(sink.records).To(HaveLen(1))
+			Expect(sink.records[0].Action).To(Equal(audit.ActionDelete))
+			Expect(sink.records[0].Reason).To(Equal("expired after 1h0m0s"))
+			Expect(sink.records[0].Pods).To(ConsistOf(pod.Namespace + "/" + pod.Name))
+		})
+		It("should record terminated nodes", func() {
+			audit.Terminated(ctx, node)
+			Expect(sink.records).To(HaveLen(1))
+			Expect(sink.records[0].Action).To(Equal(audit.ActionTerminate))
+			Expect(sink.records[0].Node).To(Equal("test-node"))
+		})
+		It("should not record without a sink", func() {
+			audit.Terminated(context.Background(), node)
+			Expect(sink.records).To(BeEmpty())
+		})
+	})
+	Context("Sinks", func() {
+		It("should reject unknown schemes", func() {
+			_, err := audit.NewSink("ftp://audit.example.com")
+			Expect(err).To(HaveOccurred())
+		})
+		It("should append records to a file", func() {
+			dir, err := ioutil.TempDir("", "audit")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+			name := filepath.Join(dir, "audit.log")
+			fileSink, err := audit.NewSink("file://" + name)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fileSink.Write(ctx, &audit.Record{Action: audit.ActionCreate, Node: "first"})).To(Succeed())
+			Expect(fileSink.Write(ctx, &audit.Record{Action: audit.ActionDelete, Node: "second"})).To(Succeed())
+			contents, err := ioutil.ReadFile(name)
+			Expect(err).ToNot(HaveOccurred())
+			lines := strings.Split(strings.TrimSpace(string(contents)), "\n")
+			Expect(lines).To(HaveLen(2))
+			record := &audit.Record{}
+			Expect(json.Unmarshal([]byte(lines[1]), record)).To(Succeed())
+			Expect(record.Action).To(Equal(audit.ActionDelete))
+			Expect(record.Node).To(Equal("second"))
+		})
+		It("should post records to a webhook", func() {
+			received := make(chan *audit.Record, 1)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				defer GinkgoRecover()
+				Expect(r.Method).To(Equal(http.MethodPost))
+				Expect(r.Header.Get("Content-Type")).To(Equal("application/json"))
+				record := &audit.Record{}
+				Expect(json.NewDecoder(r.Body).Decode(record)).To(Succeed())
+				received <- record
+			}))
+			defer server.Close()
+			webhookSink, err := audit.NewSink(server.URL)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(webhookSink.Write(ctx, &audit.Record{Action: audit.ActionCreate, Node: "test-node"})).To(Succeed())
+			Expect((<-received).Node).To(Equal("test-node"))
+		})
+		It("should fail if the webhook rejects records", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			}))
+			defer server.Close()
+			Expect(audit.NewWebhookSink(server.URL).Write(ctx, &audit.Record{})).ToNot(Succeed())
+		})
+		It("should write queued records to the sink in the background", func() {
+			written := make(channelSink, 1)
+			queue := audit.NewQueue(written)
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			queue.Start(ctx)
+			Expect(queue.Write(ctx, &audit.Record{Action: audit.ActionCreate, Node: "test-node"})).To(Succeed())
+			var record *audit.Record
+			Eventually(written).Should(Receive(&record))
+			Expect(record.Node).To(Equal("test-node"))
+		})
+		It("should fail to write records once the queue is full", func() {
+			queue := audit.NewQueue(sink)
+			var err error
+			for i := 0; i < 10000 && err == nil; i++ {
+				err = queue.Write(ctx, &audit.Record{})
+			}
+			Expect(err).To(HaveOccurred())
+			Expect(sink.records).To(BeEmpty())
+		})
+		It("should put records to S3 keyed by date", func() {
+			api := &fakeS3API{}
+			s3Sink := audit.NewS3Sink(api, "bucket", "karpenter")
+			record := &audit.Record{Time: time.Date(2022, 1, 2, 3, 4, 5, 6, time.UTC), Action: audit.ActionCreate, Node: "test-node"}
+			Expect(s3Sink.Write(ctx, record)).To(Succeed())
+			Expect(api.inputs).To(HaveLen(1))
+			Expect(aws.StringValue(api.inputs[0].Bucket)).To(Equal("bucket"))
+			Expect(aws.StringValue(api.inputs[0].Key)).To(Equal("karpenter/2022/01/02/030405.000000006-Create-test-node.json"))
+		})
+	})
+})
+
+type fakeSink struct {
+	records []*audit.Record
+}
+
+func (f *fakeSink) Write(_ context.Context, record *audit.Record) error {
+	f.records = append(f.records, record)
+	return nil
+}
+
+type channelSink chan *audit.Record
+
+func (c channelSink) Write(_ context.Context, record *audit.Record) error {
+	c <- record
+	return nil
+}
+
+type fakeS3API struct {
+	s3iface.S3API
+	mu     sync.Mutex
+	inputs []*s3.PutObjectInput
+}
+
+func (f *fakeS3API) PutObjectWithContext(_ aws.Context, input *s3.PutObjectInput, _ ...request.Option) (*s3.PutObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.inputs = append(f.inputs, input)
+	return &s3.PutObjectOutput{}, nil
+}
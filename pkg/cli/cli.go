@@ -0,0 +1,102 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package cli implements the commands of the kubectl karpenter plugin. They
+// call the same library code as the controllers, so that their answers match
+// the decisions that the controllers make.
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/controllers/provisioning"
+	"github.com/aws/karpenter/pkg/utils/functional"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// CLI runs the plugin's commands against a cluster and writes their output
+type CLI struct {
+	KubeClient client.Client
+	Out        io.Writer
+}
+
+// New is a constructor
+func New(kubeClient client.Client, out io.Writer) *CLI {
+	return &CLI{KubeClient: kubeClient, Out: out}
+}
+
+// table writes tab separated columns, aligned like kubectl's output
+func (c *CLI) table() *tabwriter.Writer {
+	return tabwriter.NewWriter(c.Out, 10, 4, 3, ' ', 0)
+}
+
+// provisioners lists the provisioners sorted by name, labelled as the
+// controller labels them
+func (c *CLI) provisioners(ctx context.Context) ([]*v1alpha5.Provisioner, error) {
+	provisionerList := &v1alpha5.ProvisionerList{}
+	if err := c.KubeClient.List(ctx, provisionerList); err != nil {
+		return nil, err
+	}
+	provisioners := []*v1alpha5.Provisioner{}
+	for i := range provisionerList.Items {
+		provisioner := &provisionerList.Items[i]
+		provisioner.Spec.Labels = functional.UnionStringMaps(provisioner.Spec.Labels, map[string]string{v1alpha5.ProvisionerNameLabelKey: provisioner.Name})
+		provisioners = append(provisioners, provisioner)
+	}
+	sort.Slice(provisioners, func(i, j int) bool { return provisioners[i].Name < provisioners[j].Name })
+	return provisioners, nil
+}
+
+// refreshedProvisioners lists the provisioners with their requirements
+// refreshed from the instance types that the cloud provider offers them, as the
+// provisioning controller refreshes them
+func (c *CLI) refreshedProvisioners(ctx context.Context, cloudProvider cloudprovider.CloudProvider) ([]*v1alpha5.Provisioner, error) {
+	provisioners, err := c.provisioners(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing provisioners, %w", err)
+	}
+	for _, provisioner := range provisioners {
+		instanceTypes, err := cloudProvider.GetInstanceTypes(ctx, provisioner)
+		if err != nil {
+			return nil, fmt.Errorf("getting instance types for provisioner %s, %w", provisioner.Name, err)
+		}
+		provisioning.Refresh(provisioner, instanceTypes)
+	}
+	return provisioners, nil
+}
+
+// age returns how long ago the time was, like kubectl's ages
+func age(t metav1.Time) string {
+	if t.IsZero() {
+		return "<unknown>"
+	}
+	return duration.HumanDuration(time.Since(t.Time))
+}
+
+// valueOrNone returns the value, or kubectl's placeholder if it's empty
+func valueOrNone(value string) string {
+	if value == "" {
+		return "<none>"
+	}
+	return value
+}
@@ -0,0 +1,137 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cli
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/controllers/termination"
+	"github.com/aws/karpenter/pkg/utils/functional"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/api/policy/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// DrainStatus prints whether the node is terminating and, for each of its
+// pods, whether the termination controller evicts it or what blocks it
+func (c *CLI) DrainStatus(ctx context.Context, name string) error {
+	node := &v1.Node{}
+	if err := c.KubeClient.Get(ctx, types.NamespacedName{Name: name}, node); err != nil {
+		return fmt.Errorf("getting node, %w", err)
+	}
+	podList := &v1.PodList{}
+	if err := c.KubeClient.List(ctx, podList, client.MatchingFields{"spec.nodeName": node.Name}); err != nil {
+		return fmt.Errorf("listing pods on node, %w", err)
+	}
+	sort.Slice(podList.Items, func(i, j int) bool {
+		return client.ObjectKeyFromObject(&podList.Items[i]).String() < client.ObjectKeyFromObject(&podList.Items[j]).String()
+	})
+	w := c.table()
+	fmt.Fprintf(w, "Node:\t%s\n", node.Name)
+	fmt.Fprintf(w, "Provisioner:\t%s\n", valueOrNone(node.Labels[v1alpha5.ProvisionerNameLabelKey]))
+	fmt.Fprintf(w, "Status:\t%s\n", drainStatus(node))
+	if len(podList.Items) == 0 {
+		fmt.Fprintln(w, "Pods:\t<none>")
+		return w.Flush()
+	}
+	fmt.Fprintln(w, "Pods:")
+	fmt.Fprintln(w, "  POD\tSTATUS")
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		status, err := c.evictionStatus(ctx, pod)
+		if err != nil {
+			return fmt.Errorf("getting eviction status of pod %s, %w", client.ObjectKeyFromObject(pod), err)
+		}
+		fmt.Fprintf(w, "  %s\t%s\n", client.ObjectKeyFromObject(pod), status)
+	}
+	return w.Flush()
+}
+
+// drainStatus summarizes the node's termination
+func drainStatus(node *v1.Node) string {
+	if node.DeletionTimestamp.IsZero() {
+		if node.Spec.Unschedulable {
+			return "Not terminating, cordoned"
+		}
+		return "Not terminating"
+	}
+	statuses := []string{fmt.Sprintf("Terminating for %s", duration.HumanDuration(time.Since(node.DeletionTimestamp.Time)))}
+	if node.Spec.Unschedulable {
+		statuses = append(statuses, "cordoned")
+	}
+	if !functional.ContainsString(node.Finalizers, v1alpha5.TerminationFinalizer) {
+		statuses = append(statuses, "not drained by Karpenter, it has no termination finalizer")
+	}
+	return strings.Join(statuses, ", ")
+}
+
+// evictionStatus returns whether the termination controller evicts the pod
+// when its node drains, in the order that the controller checks
+func (c *CLI) evictionStatus(ctx context.Context, pod *v1.Pod) (string, error) {
+	if pod.Annotations[v1alpha5.DoNotEvictPodAnnotationKey] == "true" {
+		return fmt.Sprintf("Blocks the drain, has the %s annotation", v1alpha5.DoNotEvictPodAnnotationKey), nil
+	}
+	if reason := termination.Ignored(pod); reason != "" {
+		return fmt.Sprintf("Not evicted, %s", reason), nil
+	}
+	if !pod.DeletionTimestamp.IsZero() {
+		return fmt.Sprintf("Evicted %s ago, terminating", duration.HumanDuration(time.Since(pod.DeletionTimestamp.Time))), nil
+	}
+	pdbs, err := c.disruptionBudgets(ctx, pod)
+	if err != nil {
+		return "", err
+	}
+	if len(pdbs) > 1 {
+		names := []string{}
+		for _, pdb := range pdbs {
+			names = append(names, pdb.Name)
+		}
+		return fmt.Sprintf("Eviction fails, matches more than one PodDisruptionBudget (%s)", strings.Join(names, ", ")), nil
+	}
+	if len(pdbs) == 1 && pdbs[0].Status.DisruptionsAllowed <= 0 {
+		return fmt.Sprintf("Eviction blocked by PodDisruptionBudget %s, 0 disruptions allowed", pdbs[0].Name), nil
+	}
+	return "Evictable", nil
+}
+
+// disruptionBudgets returns the pod disruption budgets that select the pod
+func (c *CLI) disruptionBudgets(ctx context.Context, pod *v1.Pod) ([]v1beta1.PodDisruptionBudget, error) {
+	pdbList := &v1beta1.PodDisruptionBudgetList{}
+	if err := c.KubeClient.List(ctx, pdbList, client.InNamespace(pod.Namespace)); err != nil {
+		return nil, fmt.Errorf("listing pod disruption budgets, %w", err)
+	}
+	pdbs := []v1beta1.PodDisruptionBudget{}
+	for _, pdb := range pdbList.Items {
+		selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+		if err != nil {
+			return nil, fmt.Errorf("parsing selector of pod disruption budget %s, %w", pdb.Name, err)
+		}
+		// Like the eviction API, an empty selector matches no pods
+		if selector.Empty() || !selector.Matches(labels.Set(pod.Labels)) {
+			continue
+		}
+		pdbs = append(pdbs, pdb)
+	}
+	return pdbs, nil
+}
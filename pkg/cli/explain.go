@@ -0,0 +1,94 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cli
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/controllers/selection"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// eventSource is the component that Karpenter records events as
+const eventSource = "karpenter"
+
+// ExplainPending prints which provisioner would launch capacity for the pod,
+// or why none would, and the events that Karpenter recorded for it
+func (c *CLI) ExplainPending(ctx context.Context, cloudProvider cloudprovider.CloudProvider, namespace string, name string) error {
+	pod := &v1.Pod{}
+	if err := c.KubeClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, pod); err != nil {
+		return fmt.Errorf("getting pod, %w", err)
+	}
+	provisioners, err := c.refreshedProvisioners(ctx, cloudProvider)
+	if err != nil {
+		return err
+	}
+	events, err := c.events(ctx, pod)
+	if err != nil {
+		return fmt.Errorf("listing events, %w", err)
+	}
+	w := c.table()
+	fmt.Fprintf(w, "Pod:\t%s\n", client.ObjectKeyFromObject(pod))
+	fmt.Fprintf(w, "Node:\t%s\n", valueOrNone(pod.Spec.NodeName))
+	provisioner, err := selection.Explain(ctx, c.KubeClient, cloudProvider.Capabilities(), pod, provisioners)
+	if err != nil {
+		fmt.Fprintf(w, "Provisioner:\t<none>\n")
+		fmt.Fprintf(w, "Reason:\t%s\n", err.Error())
+	} else {
+		fmt.Fprintf(w, "Provisioner:\t%s\n", provisioner.Name)
+		conditions := []string{}
+		for _, condition := range provisioner.Status.Conditions {
+			summary := fmt.Sprintf("%s=%s", condition.Type, condition.Status)
+			if condition.Message != "" {
+				summary += fmt.Sprintf(" (%s)", condition.Message)
+			}
+			conditions = append(conditions, summary)
+		}
+		fmt.Fprintf(w, "Conditions:\t%s\n", valueOrNone(strings.Join(conditions, ", ")))
+	}
+	if len(events) == 0 {
+		fmt.Fprintf(w, "Events:\t<none>\n")
+		return w.Flush()
+	}
+	fmt.Fprintf(w, "Events:\n")
+	fmt.Fprintf(w, "  AGE\tTYPE\tREASON\tMESSAGE\n")
+	for _, event := range events {
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", age(event.LastTimestamp), event.Type, event.Reason, event.Message)
+	}
+	return w.Flush()
+}
+
+// events lists the events that Karpenter recorded for the pod, oldest first
+func (c *CLI) events(ctx context.Context, pod *v1.Pod) ([]v1.Event, error) {
+	eventList := &v1.EventList{}
+	if err := c.KubeClient.List(ctx, eventList, client.InNamespace(pod.Namespace)); err != nil {
+		return nil, err
+	}
+	events := []v1.Event{}
+	for _, event := range eventList.Items {
+		if event.Source.Component != eventSource || event.InvolvedObject.Kind != "Pod" || event.InvolvedObject.Name != pod.Name {
+			continue
+		}
+		events = append(events, event)
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].LastTimestamp.Before(&events[j].LastTimestamp) })
+	return events, nil
+}
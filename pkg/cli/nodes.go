@@ -0,0 +1,73 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cli
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/utils/node"
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// GetNodes prints the nodes that Karpenter launched, optionally only those of
+// one provisioner
+func (c *CLI) GetNodes(ctx context.Context, provisioner string) error {
+	var selector client.ListOption = client.HasLabels{v1alpha5.ProvisionerNameLabelKey}
+	if provisioner != "" {
+		selector = client.MatchingLabels{v1alpha5.ProvisionerNameLabelKey: provisioner}
+	}
+	nodeList := &v1.NodeList{}
+	if err := c.KubeClient.List(ctx, nodeList, selector); err != nil {
+		return fmt.Errorf("listing nodes, %w", err)
+	}
+	sort.Slice(nodeList.Items, func(i, j int) bool { return nodeList.Items[i].Name < nodeList.Items[j].Name })
+	w := c.table()
+	fmt.Fprintln(w, "NAME\tPROVISIONER\tINSTANCE-TYPE\tCAPACITY-TYPE\tZONE\tPRICE\tSTATUS\tAGE")
+	for i := range nodeList.Items {
+		n := &nodeList.Items[i]
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			n.Name,
+			n.Labels[v1alpha5.ProvisionerNameLabelKey],
+			valueOrNone(n.Labels[v1.LabelInstanceTypeStable]),
+			valueOrNone(n.Labels[v1alpha5.LabelCapacityType]),
+			valueOrNone(n.Labels[v1.LabelTopologyZone]),
+			valueOrNone(n.Annotations[v1alpha5.PriceAnnotationKey]),
+			status(n),
+			age(n.CreationTimestamp),
+		)
+	}
+	return w.Flush()
+}
+
+// status summarizes the node's state like kubectl get nodes does, and whether
+// it's terminating
+func status(n *v1.Node) string {
+	statuses := []string{"NotReady"}
+	if node.IsReady(n) {
+		statuses = []string{"Ready"}
+	}
+	if n.Spec.Unschedulable {
+		statuses = append(statuses, "SchedulingDisabled")
+	}
+	if !n.DeletionTimestamp.IsZero() {
+		statuses = append(statuses, "Terminating")
+	}
+	return strings.Join(statuses, ",")
+}
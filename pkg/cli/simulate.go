@@ -0,0 +1,181 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/controllers/provisioning/binpacking"
+	"github.com/aws/karpenter/pkg/controllers/provisioning/scheduling"
+	"github.com/aws/karpenter/pkg/controllers/selection"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// maxInstanceTypes is the number of instance type options printed per node
+const maxInstanceTypes = 3
+
+// ReadPods decodes the pods of a YAML or JSON stream with one or more
+// documents. Pods without a namespace are placed in the default namespace.
+func ReadPods(r io.Reader) ([]*v1.Pod, error) {
+	decoder := yaml.NewYAMLOrJSONDecoder(r, 4096)
+	pods := []*v1.Pod{}
+	for {
+		pod := &v1.Pod{}
+		if err := decoder.Decode(pod); err != nil {
+			if err == io.EOF {
+				return pods, nil
+			}
+			return nil, fmt.Errorf("decoding pods, %w", err)
+		}
+		// Skip empty documents
+		if pod.Kind == "" && pod.Name == "" && pod.GenerateName == "" {
+			continue
+		}
+		if pod.Kind != "" && pod.Kind != "Pod" {
+			return nil, fmt.Errorf("decoding pods, unsupported kind %s", pod.Kind)
+		}
+		if pod.Name == "" {
+			pod.Name = pod.GenerateName
+		}
+		if pod.Namespace == "" {
+			pod.Namespace = v1.NamespaceDefault
+		}
+		pods = append(pods, pod)
+	}
+}
+
+// Simulate prints the nodes that the provisioners would launch for the pods if
+// they were pending, and the pods that they couldn't schedule. Nothing is
+// launched or bound, and neither the nodes in flight nor the provisioners'
+// limits are taken into account.
+func (c *CLI) Simulate(ctx context.Context, cloudProvider cloudprovider.CloudProvider, pods []*v1.Pod) error {
+	provisioners, err := c.refreshedProvisioners(ctx, cloudProvider)
+	if err != nil {
+		return err
+	}
+	// Batch the pods by provisioner, as the selection controller does
+	unschedulable := [][]string{}
+	batches := map[*v1alpha5.Provisioner][]*v1.Pod{}
+	for _, pod := range pods {
+		if err := selection.Validate(ctx, c.KubeClient, pod); err != nil {
+			unschedulable = append(unschedulable, []string{client.ObjectKeyFromObject(pod).String(), err.Error()})
+			continue
+		}
+		provisioner, err := selection.Select(pod, provisioners, cloudProvider.Capabilities())
+		if err != nil {
+			unschedulable = append(unschedulable, []string{client.ObjectKeyFromObject(pod).String(), err.Error()})
+			continue
+		}
+		batches[provisioner] = append(batches[provisioner], pod)
+	}
+	// Solve and pack each batch, as the provisioner does
+	scheduler := scheduling.NewScheduler(c.KubeClient)
+	packer := binpacking.NewPacker(c.KubeClient, cloudProvider)
+	w := c.table()
+	fmt.Fprintln(w, "PROVISIONER\tNODES\tINSTANCE-TYPES\tMIN-PRICE\tPODS-PER-NODE")
+	for _, provisioner := range provisioners {
+		if len(batches[provisioner]) == 0 {
+			continue
+		}
+		instanceTypes, err := cloudProvider.GetInstanceTypes(ctx, provisioner)
+		if err != nil {
+			return fmt.Errorf("getting instance types for provisioner %s, %w", provisioner.Name, err)
+		}
+		schedules, err := scheduler.Solve(ctx, provisioner, instanceTypes, batches[provisioner])
+		if err != nil {
+			return fmt.Errorf("solving scheduling constraints for provisioner %s, %w", provisioner.Name, err)
+		}
+		for _, schedule := range schedules {
+			packings, err := packer.Pack(ctx, provisioner, schedule.Constraints, schedule.Pods)
+			if err != nil {
+				return fmt.Errorf("binpacking pods for provisioner %s, %w", provisioner.Name, err)
+			}
+			packed := map[*v1.Pod]bool{}
+			for _, packing := range packings {
+				podsPerNode := []string{}
+				for _, nodePods := range packing.Pods {
+					podsPerNode = append(podsPerNode, strconv.Itoa(len(nodePods)))
+					for _, pod := range nodePods {
+						packed[pod] = true
+					}
+				}
+				fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\n",
+					provisioner.Name,
+					packing.NodeQuantity,
+					instanceTypeNames(packing.InstanceTypeOptions),
+					minPrice(packing.InstanceTypeOptions, schedule.Constraints),
+					strings.Join(podsPerNode, ","),
+				)
+			}
+			daemons, err := packer.GetDaemons(ctx, schedule.Constraints)
+			if err != nil {
+				return fmt.Errorf("getting schedulable daemon pods for provisioner %s, %w", provisioner.Name, err)
+			}
+			for _, pod := range schedule.Pods {
+				if packed[pod] {
+					continue
+				}
+				if err := binpacking.Unpackable(ctx, schedule.Constraints, pod, instanceTypes, daemons); err != nil {
+					unschedulable = append(unschedulable, []string{client.ObjectKeyFromObject(pod).String(), err.Error()})
+				}
+			}
+		}
+	}
+	if len(unschedulable) > 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "UNSCHEDULABLE\tREASON")
+		for _, pod := range unschedulable {
+			fmt.Fprintf(w, "%s\t%s\n", pod[0], pod[1])
+		}
+	}
+	return w.Flush()
+}
+
+// instanceTypeNames returns the names of the first instance type options, and
+// how many more there are
+func instanceTypeNames(instanceTypes []cloudprovider.InstanceType) string {
+	names := []string{}
+	for i, instanceType := range instanceTypes {
+		if i == maxInstanceTypes {
+			names = append(names, fmt.Sprintf("+%d more", len(instanceTypes)-maxInstanceTypes))
+			break
+		}
+		names = append(names, instanceType.Name())
+	}
+	return strings.Join(names, ",")
+}
+
+// minPrice returns the hourly price of the cheapest offering of the instance
+// types that's compatible with the constraints, or kubectl's placeholder if no
+// price is known
+func minPrice(instanceTypes []cloudprovider.InstanceType, constraints *v1alpha5.Constraints) string {
+	offerings := cloudprovider.Offerings{}
+	for _, instanceType := range instanceTypes {
+		offerings = append(offerings, instanceType.Offerings().Compatible(constraints.Requirements.Zones(), constraints.Requirements.CapacityTypes())...)
+	}
+	cheapest, ok := offerings.Cheapest()
+	if !ok {
+		return valueOrNone("")
+	}
+	return strconv.FormatFloat(cheapest.Price, 'f', -1, 64)
+}
@@ -0,0 +1,276 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cli_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/karpenter/pkg/apis"
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cli"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/cloudprovider/fake"
+	"github.com/aws/karpenter/pkg/test"
+	"github.com/aws/karpenter/pkg/utils/resources"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	knativeapis "knative.dev/pkg/apis"
+	. "knative.dev/pkg/logging/testing"
+	"knative.dev/pkg/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var ctx context.Context
+var kubeClient client.Client
+var out *bytes.Buffer
+var c *cli.CLI
+
+func TestCLI(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CLI")
+}
+
+var _ = BeforeEach(func() {
+	scheme := runtime.NewScheme()
+	Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+	Expect(apis.AddToScheme(scheme)).To(Succeed())
+	kubeClient = clientfake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: v1.NamespaceDefault}},
+	).Build()
+	out = &bytes.Buffer{}
+	c = cli.New(kubeClient, out)
+})
+
+// ExpectLine expects a line of the output to contain each of the fields
+func ExpectLine(fields ...string) {
+	for _, line := range strings.Split(out.String(), "\n") {
+		matches := true
+		for _, field := range fields {
+			matches = matches && strings.Contains(line, field)
+		}
+		if matches {
+			return
+		}
+	}
+	Fail(strings.Join(fields, ", ") + " not found in output\n" + out.String())
+}
+
+var _ = Describe("Get Nodes", func() {
+	BeforeEach(func() {
+		for _, node := range []*v1.Node{
+			test.Node(test.NodeOptions{
+				Name:        "default-node",
+				Labels:      map[string]string{v1alpha5.ProvisionerNameLabelKey: "default", v1.LabelInstanceTypeStable: "m5.large", v1alpha5.LabelCapacityType: "spot", v1.LabelTopologyZone: "test-zone-1"},
+				Annotations: map[string]string{v1alpha5.PriceAnnotationKey: "0.096"},
+				ReadyStatus: v1.ConditionTrue,
+			}),
+			test.Node(test.NodeOptions{Name: "other-node", Labels: map[string]string{v1alpha5.ProvisionerNameLabelKey: "other"}, ReadyStatus: v1.ConditionFalse, Unschedulable: true}),
+			test.Node(test.NodeOptions{Name: "unmanaged-node"}),
+		} {
+			Expect(kubeClient.Create(ctx, node)).To(Succeed())
+		}
+	})
+	It("should list the nodes that Karpenter launched", func() {
+		Expect(c.GetNodes(ctx, "")).To(Succeed())
+		ExpectLine("NAME", "PROVISIONER", "INSTANCE-TYPE", "PRICE", "STATUS")
+		ExpectLine("default-node", "default", "m5.large", "spot", "test-zone-1", "0.096", "Ready")
+		ExpectLine("other-node", "other", "<none>", "NotReady,SchedulingDisabled")
+		Expect(out.String()).ToNot(ContainSubstring("unmanaged-node"))
+	})
+	It("should only list the nodes of a provisioner", func() {
+		Expect(c.GetNodes(ctx, "other")).To(Succeed())
+		ExpectLine("other-node")
+		Expect(out.String()).ToNot(ContainSubstring("default-node"))
+	})
+})
+
+var _ = Describe("Explain Pending", func() {
+	var provisioner *v1alpha5.Provisioner
+	var cloudProvider *fake.CloudProvider
+	BeforeEach(func() {
+		cloudProvider = &fake.CloudProvider{}
+		provisioner = &v1alpha5.Provisioner{ObjectMeta: metav1.ObjectMeta{Name: "default"}}
+		provisioner.Status.Conditions = knativeapis.Conditions{{Type: v1alpha5.LimitsExceeded, Status: v1.ConditionTrue, Message: "cpu limit exceeded"}}
+		Expect(kubeClient.Create(ctx, provisioner)).To(Succeed())
+	})
+	It("should explain which provisioner would provision the pod", func() {
+		pod := test.UnschedulablePod(test.PodOptions{Name: "pending"})
+		Expect(kubeClient.Create(ctx, pod)).To(Succeed())
+		Expect(kubeClient.Create(ctx, &v1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: "pending.event", Namespace: pod.Namespace},
+			InvolvedObject: v1.ObjectReference{Kind: "Pod", Name: pod.Name, Namespace: pod.Namespace},
+			Source:         v1.EventSource{Component: "karpenter"},
+			LastTimestamp:  metav1.Time{Time: time.Now()},
+			Type:           v1.EventTypeWarning,
+			Reason:         "ProvisioningFailed",
+			Message:        "node limit exceeded",
+		})).To(Succeed())
+		Expect(c.ExplainPending(ctx, cloudProvider, pod.Namespace, pod.Name)).To(Succeed())
+		ExpectLine("Provisioner:", "default")
+		ExpectLine("Conditions:", "LimitsExceeded=True (cpu limit exceeded)")
+		ExpectLine("Warning", "ProvisioningFailed", "node limit exceeded")
+	})
+	It("should explain why no provisioner would provision the pod", func() {
+		pod := test.UnschedulablePod(test.PodOptions{Name: "pending", NodeSelector: map[string]string{v1alpha5.ProvisionerNameLabelKey: "missing"}})
+		Expect(kubeClient.Create(ctx, pod)).To(Succeed())
+		Expect(c.ExplainPending(ctx, cloudProvider, pod.Namespace, pod.Name)).To(Succeed())
+		ExpectLine("Provisioner:", "<none>")
+		ExpectLine("Reason:", "missing")
+		ExpectLine("Events:", "<none>")
+	})
+	It("should explain that scheduled pods are ignored", func() {
+		pod := test.Pod(test.PodOptions{Name: "scheduled", NodeName: "node"})
+		Expect(kubeClient.Create(ctx, pod)).To(Succeed())
+		Expect(c.ExplainPending(ctx, cloudProvider, pod.Namespace, pod.Name)).To(Succeed())
+		ExpectLine("Reason:", "ignored, scheduled to node node")
+	})
+	It("should explain that no instance type offers the pod's zone", func() {
+		pod := test.UnschedulablePod(test.PodOptions{Name: "pending", NodeSelector: map[string]string{v1.LabelTopologyZone: "unknown-zone"}})
+		Expect(kubeClient.Create(ctx, pod)).To(Succeed())
+		Expect(c.ExplainPending(ctx, cloudProvider, pod.Namespace, pod.Name)).To(Succeed())
+		ExpectLine("Provisioner:", "<none>")
+		ExpectLine("Reason:", "unknown-zone")
+	})
+	It("should explain that the cloud provider doesn't support the pod", func() {
+		cloudProvider.Supported = &cloudprovider.Capabilities{}
+		pod := test.UnschedulablePod(test.PodOptions{Name: "pending", ResourceRequirements: v1.ResourceRequirements{
+			Limits: v1.ResourceList{resources.NvidiaGPU: resource.MustParse("1")},
+		}})
+		Expect(kubeClient.Create(ctx, pod)).To(Succeed())
+		Expect(c.ExplainPending(ctx, cloudProvider, pod.Namespace, pod.Name)).To(Succeed())
+		ExpectLine("Provisioner:", "<none>")
+		ExpectLine("Reason:", "matched 0/1 provisioners", "is not supported by this cloud provider")
+	})
+	It("should explain that the pod is deferred to preemption", func() {
+		provisioner.Spec.PreemptionPolicy = v1alpha5.PreemptionPolicyDefer
+		Expect(kubeClient.Update(ctx, provisioner)).To(Succeed())
+		node := test.Node(test.NodeOptions{Name: "node", ReadyStatus: v1.ConditionTrue, Allocatable: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4"), v1.ResourcePods: resource.MustParse("10")}})
+		Expect(kubeClient.Create(ctx, node)).To(Succeed())
+		Expect(kubeClient.Create(ctx, test.Pod(test.PodOptions{Name: "victim", NodeName: node.Name, ResourceRequirements: v1.ResourceRequirements{
+			Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("3")},
+		}}))).To(Succeed())
+		pod := test.UnschedulablePod(test.PodOptions{Name: "pending", ResourceRequirements: v1.ResourceRequirements{
+			Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("2")},
+		}})
+		pod.Spec.Priority = ptr.Int32(100)
+		Expect(kubeClient.Create(ctx, pod)).To(Succeed())
+		Expect(c.ExplainPending(ctx, cloudProvider, pod.Namespace, pod.Name)).To(Succeed())
+		ExpectLine("Provisioner:", "<none>")
+		ExpectLine("Reason:", "deferring to preemption of lower priority pods")
+	})
+})
+
+var _ = Describe("Simulate", func() {
+	It("should read pods from multiple documents", func() {
+		pods, err := cli.ReadPods(strings.NewReader(`
+apiVersion: v1
+kind: Pod
+metadata:
+  name: first
+---
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: second
+  namespace: other
+`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pods).To(HaveLen(2))
+		Expect(client.ObjectKeyFromObject(pods[0]).String()).To(Equal("default/first"))
+		Expect(client.ObjectKeyFromObject(pods[1]).String()).To(Equal("other/second"))
+	})
+	It("should reject other kinds", func() {
+		_, err := cli.ReadPods(strings.NewReader(`{"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "deployment"}}`))
+		Expect(err).To(HaveOccurred())
+	})
+	It("should print the nodes that would be launched and the unschedulable pods", func() {
+		Expect(kubeClient.Create(ctx, &v1alpha5.Provisioner{ObjectMeta: metav1.ObjectMeta{Name: "default"}})).To(Succeed())
+		cloudProvider := &fake.CloudProvider{InstanceTypes: []cloudprovider.InstanceType{
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "small-instance-type", CPU: resource.MustParse("2"), Memory: resource.MustParse("2Gi")}),
+		}}
+		pods := []*v1.Pod{
+			test.Pod(test.PodOptions{Name: "fits", ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")}}}),
+			test.Pod(test.PodOptions{Name: "too-big", ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("100")}}}),
+			test.Pod(test.PodOptions{Name: "no-provisioner", NodeSelector: map[string]string{v1alpha5.ProvisionerNameLabelKey: "missing"}}),
+		}
+		Expect(c.Simulate(ctx, cloudProvider, pods)).To(Succeed())
+		ExpectLine("default", "1", "small-instance-type", "1")
+		ExpectLine("default/too-big", "no instance type option has enough resources")
+		ExpectLine("default/no-provisioner", "missing")
+		Expect(cloudProvider.CallsTo("Create")).To(BeEmpty())
+	})
+	It("should report pods that aren't offered in their zone with their capacity type", func() {
+		Expect(kubeClient.Create(ctx, &v1alpha5.Provisioner{ObjectMeta: metav1.ObjectMeta{Name: "default"}})).To(Succeed())
+		cloudProvider := &fake.CloudProvider{InstanceTypes: []cloudprovider.InstanceType{
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "default-instance-type", Offerings: []cloudprovider.Offering{
+				{CapacityType: "spot", Zone: "test-zone-1"},
+				{CapacityType: "on-demand", Zone: "test-zone-2"},
+			}}),
+		}}
+		pods := []*v1.Pod{test.Pod(test.PodOptions{Name: "spot-in-zone-2", NodeSelector: map[string]string{
+			v1.LabelTopologyZone:       "test-zone-2",
+			v1alpha5.LabelCapacityType: "spot",
+		}})}
+		Expect(c.Simulate(ctx, cloudProvider, pods)).To(Succeed())
+		ExpectLine("default/spot-in-zone-2", "no instance type option is offered in zones [test-zone-2] with capacity types [spot]")
+	})
+	It("should report pods whose host ports conflict with daemons", func() {
+		Expect(kubeClient.Create(ctx, &v1alpha5.Provisioner{ObjectMeta: metav1.ObjectMeta{Name: "default"}})).To(Succeed())
+		daemonSet := test.DaemonSet()
+		daemonSet.Spec.Template.Spec.Containers[0].Ports = []v1.ContainerPort{{ContainerPort: 8080, HostPort: 80}}
+		Expect(kubeClient.Create(ctx, daemonSet)).To(Succeed())
+		cloudProvider := &fake.CloudProvider{InstanceTypes: []cloudprovider.InstanceType{
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "default-instance-type"}),
+		}}
+		pod := test.Pod(test.PodOptions{Name: "host-port"})
+		pod.Spec.Containers[0].Ports = []v1.ContainerPort{{ContainerPort: 8080, HostPort: 80}}
+		Expect(c.Simulate(ctx, cloudProvider, []*v1.Pod{pod})).To(Succeed())
+		ExpectLine("default/host-port", "host ports [80] conflict with daemons")
+	})
+})
+
+var _ = Describe("Drain Status", func() {
+	It("should print why each pod is or isn't evicted", func() {
+		node := test.Node(test.NodeOptions{Name: "draining", Finalizers: []string{v1alpha5.TerminationFinalizer}, Unschedulable: true})
+		Expect(kubeClient.Create(ctx, node)).To(Succeed())
+		for _, pod := range []*v1.Pod{
+			test.Pod(test.PodOptions{Name: "evictable", NodeName: node.Name}),
+			test.Pod(test.PodOptions{Name: "do-not-evict", NodeName: node.Name, Annotations: map[string]string{v1alpha5.DoNotEvictPodAnnotationKey: "true"}}),
+			test.Pod(test.PodOptions{Name: "tolerant", NodeName: node.Name, Tolerations: []v1.Toleration{{Key: v1.TaintNodeUnschedulable, Operator: v1.TolerationOpExists}}}),
+			test.Pod(test.PodOptions{Name: "budgeted", NodeName: node.Name, Labels: map[string]string{"app": "budgeted"}}),
+		} {
+			Expect(kubeClient.Create(ctx, pod)).To(Succeed())
+		}
+		Expect(kubeClient.Create(ctx, test.PodDisruptionBudget(test.PDBOptions{Name: "budget", Labels: map[string]string{"app": "budgeted"}}))).To(Succeed())
+		Expect(c.DrainStatus(ctx, node.Name)).To(Succeed())
+		ExpectLine("Status:", "Not terminating, cordoned")
+		ExpectLine("default/evictable", "Evictable")
+		ExpectLine("default/do-not-evict", "Blocks the drain")
+		ExpectLine("default/tolerant", "Not evicted, tolerates the unschedulable taint")
+		ExpectLine("default/budgeted", "Eviction blocked by PodDisruptionBudget budget")
+	})
+})
@@ -17,13 +17,19 @@ package aws
 import (
 	"context"
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	"github.com/aws/aws-sdk-go/service/ssm"
 	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
 	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
 	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/cloudprovider/aws/apis/v1alpha1"
+	"github.com/aws/karpenter/pkg/utils/pretty"
+	"github.com/mitchellh/hashstructure/v2"
 	"github.com/patrickmn/go-cache"
 	"k8s.io/client-go/kubernetes"
 	"knative.dev/pkg/logging"
@@ -34,19 +40,24 @@ const kubernetesVersionCacheKey = "kubernetesVersion"
 type AMIProvider struct {
 	cache     *cache.Cache
 	ssm       ssmiface.SSMAPI
+	ec2api    ec2iface.EC2API
 	clientSet *kubernetes.Clientset
 }
 
-func NewAMIProvider(ssm ssmiface.SSMAPI, clientSet *kubernetes.Clientset) *AMIProvider {
+func NewAMIProvider(ssm ssmiface.SSMAPI, ec2api ec2iface.EC2API, clientSet *kubernetes.Clientset) *AMIProvider {
 	return &AMIProvider{
 		ssm:       ssm,
+		ec2api:    ec2api,
 		clientSet: clientSet,
 		cache:     cache.New(CacheTTL, CacheCleanupInterval),
 	}
 }
 
 // Get returns a set of AMIIDs and corresponding instance types. AMI may vary due to architecture, accelerator, etc
-func (p *AMIProvider) Get(ctx context.Context, instanceTypes []cloudprovider.InstanceType) (map[string][]cloudprovider.InstanceType, error) {
+func (p *AMIProvider) Get(ctx context.Context, constraints *v1alpha1.Constraints, instanceTypes []cloudprovider.InstanceType) (map[string][]cloudprovider.InstanceType, error) {
+	if constraints.AMISelector != nil {
+		return p.getSelected(ctx, constraints.AMISelector, instanceTypes)
+	}
 	version, err := p.kubeServerVersion(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("kube server version, %w", err)
@@ -54,7 +65,7 @@ func (p *AMIProvider) Get(ctx context.Context, instanceTypes []cloudprovider.Ins
 	// Separate instance types by unique queries
 	amiQueries := map[string][]cloudprovider.InstanceType{}
 	for _, instanceType := range instanceTypes {
-		query := p.getSSMQuery(instanceType, version)
+		query := p.getSSMQuery(aws.StringValue(constraints.AMIFamily), instanceType, version)
 		amiQueries[query] = append(amiQueries[query], instanceType)
 	}
 	// Separate instance types by unique AMIIDs
@@ -64,11 +75,87 @@ func (p *AMIProvider) Get(ctx context.Context, instanceTypes []cloudprovider.Ins
 		if err != nil {
 			return nil, err
 		}
-		amiIDs[amiID] = instanceTypes
+		amiIDs[amiID] = append(amiIDs[amiID], instanceTypes...)
 	}
 	return amiIDs, nil
 }
 
+// getSelected separates the instance types by the most recently created AMI
+// that matches the selector and the instance type's architecture. Instance
+// types without a matching AMI are excluded.
+func (p *AMIProvider) getSelected(ctx context.Context, selector map[string]string, instanceTypes []cloudprovider.InstanceType) (map[string][]cloudprovider.InstanceType, error) {
+	images, err := p.getImages(ctx, selector)
+	if err != nil {
+		return nil, err
+	}
+	amiIDs := map[string][]cloudprovider.InstanceType{}
+	for _, instanceType := range instanceTypes {
+		for _, image := range images {
+			if v1alpha1.AWSToKubeArchitectures[aws.StringValue(image.Architecture)] == instanceType.Architecture() {
+				amiIDs[aws.StringValue(image.ImageId)] = append(amiIDs[aws.StringValue(image.ImageId)], instanceType)
+				break
+			}
+		}
+	}
+	if len(amiIDs) == 0 {
+		return nil, fmt.Errorf("no amis matched selector %v for the architectures of the instance types", selector)
+	}
+	return amiIDs, nil
+}
+
+// getImages returns the images that match the selector, most recently created first
+func (p *AMIProvider) getImages(ctx context.Context, selector map[string]string) ([]*ec2.Image, error) {
+	filters := getAMIFilters(selector)
+	hash, err := hashstructure.Hash(filters, hashstructure.FormatV2, nil)
+	if err != nil {
+		return nil, err
+	}
+	if images, ok := p.cache.Get(fmt.Sprint(hash)); ok {
+		return images.([]*ec2.Image), nil
+	}
+	output, err := p.ec2api.DescribeImagesWithContext(ctx, &ec2.DescribeImagesInput{Filters: filters})
+	if err != nil {
+		return nil, fmt.Errorf("describing images %s, %w", pretty.Concise(filters), err)
+	}
+	if len(output.Images) == 0 {
+		return nil, fmt.Errorf("no amis matched selector %v", selector)
+	}
+	images := output.Images
+	// Creation dates are ISO 8601 formatted, so they sort lexically
+	sort.Slice(images, func(i, j int) bool {
+		return aws.StringValue(images[i].CreationDate) > aws.StringValue(images[j].CreationDate)
+	})
+	p.cache.SetDefault(fmt.Sprint(hash), images)
+	logging.FromContext(ctx).Debugf("Discovered amis: %s", prettyImages(images))
+	return images, nil
+}
+
+func getAMIFilters(selector map[string]string) []*ec2.Filter {
+	filters := []*ec2.Filter{}
+	for key, value := range selector {
+		if value == "*" {
+			filters = append(filters, &ec2.Filter{
+				Name:   aws.String("tag-key"),
+				Values: []*string{aws.String(key)},
+			})
+		} else {
+			filters = append(filters, &ec2.Filter{
+				Name:   aws.String(fmt.Sprintf("tag:%s", key)),
+				Values: []*string{aws.String(value)},
+			})
+		}
+	}
+	return filters
+}
+
+func prettyImages(images []*ec2.Image) []string {
+	names := []string{}
+	for _, image := range images {
+		names = append(names, fmt.Sprintf("%s (%s)", aws.StringValue(image.ImageId), aws.StringValue(image.Architecture)))
+	}
+	return names
+}
+
 func (p *AMIProvider) getAMIID(ctx context.Context, query string) (string, error) {
 	if id, ok := p.cache.Get(query); ok {
 		return id.(string), nil
@@ -83,14 +170,34 @@ func (p *AMIProvider) getAMIID(ctx context.Context, query string) (string, error
 	return ami, nil
 }
 
-func (p *AMIProvider) getSSMQuery(instanceType cloudprovider.InstanceType, version string) string {
-	var amiSuffix string
-	if !instanceType.NvidiaGPUs().IsZero() || !instanceType.AWSNeurons().IsZero() {
-		amiSuffix = "-gpu"
-	} else if instanceType.Architecture() == v1alpha5.ArchitectureArm64 {
-		amiSuffix = "-arm64"
+// getSSMQuery returns the ssm parameter of the AMI family's recommended AMI for the instance type
+func (p *AMIProvider) getSSMQuery(amiFamily string, instanceType cloudprovider.InstanceType, version string) string {
+	switch amiFamily {
+	case v1alpha1.AMIFamilyBottlerocket:
+		var amiSuffix string
+		if !instanceType.NvidiaGPUs().IsZero() {
+			amiSuffix = "-nvidia"
+		}
+		architecture := "x86_64"
+		if instanceType.Architecture() == v1alpha5.ArchitectureArm64 {
+			architecture = "arm64"
+		}
+		return fmt.Sprintf("/aws/service/bottlerocket/aws-k8s-%s%s/%s/latest/image_id", version, amiSuffix, architecture)
+	case v1alpha1.AMIFamilyUbuntu:
+		return fmt.Sprintf("/aws/service/canonical/ubuntu/eks/20.04/%s/stable/current/%s/hvm/ebs-gp2/ami-id", version, instanceType.Architecture())
+	case v1alpha1.AMIFamilyWindows2019:
+		return fmt.Sprintf("/aws/service/ami-windows-latest/Windows_Server-2019-English-Core-EKS_Optimized-%s/image_id", version)
+	case v1alpha1.AMIFamilyWindows2022:
+		return fmt.Sprintf("/aws/service/ami-windows-latest/Windows_Server-2022-English-Core-EKS_Optimized-%s/image_id", version)
+	default:
+		var amiSuffix string
+		if !instanceType.NvidiaGPUs().IsZero() || !instanceType.AWSNeurons().IsZero() {
+			amiSuffix = "-gpu"
+		} else if instanceType.Architecture() == v1alpha5.ArchitectureArm64 {
+			amiSuffix = "-arm64"
+		}
+		return fmt.Sprintf("/aws/service/eks/optimized-ami/%s/amazon-linux-2%s/recommended/image_id", version, amiSuffix)
 	}
-	return fmt.Sprintf("/aws/service/eks/optimized-ami/%s/amazon-linux-2%s/recommended/image_id", version, amiSuffix)
 }
 
 func (p *AMIProvider) kubeServerVersion(ctx context.Context) (string, error) {
@@ -0,0 +1,109 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/apis"
+)
+
+// AWSNodeTemplateSpec contains the same parameters as a provisioner's
+// provider, for provisioners that reference the template rather than
+// inlining them.
+type AWSNodeTemplateSpec struct {
+	AWS `json:",inline"`
+}
+
+// AWSNodeTemplateStatus contains the resources that the template's selectors
+// resolve to. They are refreshed periodically, and when the template changes.
+type AWSNodeTemplateStatus struct {
+	// Subnets that the subnet selector resolves to.
+	// +optional
+	Subnets []Subnet `json:"subnets,omitempty"`
+	// SecurityGroups that the security group selector resolves to. They
+	// aren't resolved for templates with a launch template, which defines its
+	// own security groups.
+	// +optional
+	SecurityGroups []SecurityGroup `json:"securityGroups,omitempty"`
+	// AMIs that instances are launched with, one for each architecture and
+	// accelerator of the available instance types. They aren't resolved for
+	// templates with a launch template, which defines its own AMI.
+	// +optional
+	AMIs []AMI `json:"amis,omitempty"`
+	// Conditions contains the Ready condition, which is false with the
+	// reason if the template's resources can't be resolved.
+	// +optional
+	Conditions apis.Conditions `json:"conditions,omitempty"`
+}
+
+// Subnet is a subnet that instances may be launched in.
+type Subnet struct {
+	// ID of the subnet.
+	ID string `json:"id"`
+	// Zone of the subnet.
+	Zone string `json:"zone"`
+}
+
+// SecurityGroup is a security group that instances are launched with.
+type SecurityGroup struct {
+	// ID of the security group.
+	ID string `json:"id"`
+	// Name of the security group.
+	// +optional
+	Name string `json:"name,omitempty"`
+}
+
+// AMI is an image that instances are launched with.
+type AMI struct {
+	// ID of the AMI.
+	ID string `json:"id"`
+	// Architecture of the instance types that are launched with the AMI.
+	Architecture string `json:"architecture"`
+}
+
+// AWSNodeTemplate is the Schema for the AWSNodeTemplates API. Provisioners
+// reference AWSNodeTemplates with their providerRef, so that their AWS
+// specific parameters are configured and validated separately, and shared
+// between provisioners.
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:path=awsnodetemplates,scope=Cluster
+// +kubebuilder:subresource:status
+type AWSNodeTemplate struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   AWSNodeTemplateSpec   `json:"spec,omitempty"`
+	Status AWSNodeTemplateStatus `json:"status,omitempty"`
+}
+
+// AWSNodeTemplateList contains a list of AWSNodeTemplate
+// +kubebuilder:object:root=true
+type AWSNodeTemplateList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []AWSNodeTemplate `json:"items"`
+}
+
+func (t *AWSNodeTemplate) StatusConditions() apis.ConditionManager {
+	return apis.NewLivingConditionSet().Manage(t)
+}
+
+func (t *AWSNodeTemplate) GetConditions() apis.Conditions {
+	return t.Status.Conditions
+}
+
+func (t *AWSNodeTemplate) SetConditions(conditions apis.Conditions) {
+	t.Status.Conditions = conditions
+}
@@ -0,0 +1,42 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"knative.dev/pkg/apis"
+)
+
+// Validate the template with the same rules as a provisioner's provider
+func (t *AWSNodeTemplate) Validate(ctx context.Context) (errs *apis.FieldError) {
+	return errs.Also(
+		apis.ValidateObjectMetadata(t).ViaField("metadata"),
+		t.Spec.AWS.validate(ctx).ViaField("spec"),
+	)
+}
+
+// ValidateProviderRef returns an error if the provider ref doesn't reference
+// an AWSNodeTemplate
+func ValidateProviderRef(providerRef *v1alpha5.ProviderRef) (errs *apis.FieldError) {
+	if providerRef.Kind != "AWSNodeTemplate" {
+		errs = errs.Also(apis.ErrInvalidValue(providerRef.Kind, "kind"))
+	}
+	if providerRef.APIVersion != "" && providerRef.APIVersion != SchemeGroupVersion.String() {
+		errs = errs.Also(apis.ErrInvalidValue(providerRef.APIVersion, "apiVersion"))
+	}
+	return errs
+}
@@ -19,6 +19,8 @@ import (
 	"fmt"
 
 	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/utils/functional"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -34,31 +36,235 @@ type AWS struct {
 	// TypeMeta includes version and kind of the extensions, inferred if not provided.
 	// +optional
 	metav1.TypeMeta `json:",inline"`
-	// InstanceProfile is the AWS identity that instances use.
-	// +required
-	InstanceProfile string `json:"instanceProfile"`
-	// LaunchTemplate for the node. If not specified, a launch template will be generated.
+	// InstanceProfile is the AWS identity that instances use. It is required
+	// unless a launch template is specified, which defines its own, or
+	// Karpenter is configured with a default instance profile.
+	// +optional
+	InstanceProfile string `json:"instanceProfile,omitempty"`
+	// LaunchTemplate is the name of a launch template for the node, e.g. to use
+	// a custom AMI, user data or network interfaces. If not specified, a launch
+	// template will be generated.
 	// +optional
 	LaunchTemplate *string `json:"launchTemplate,omitempty"`
-	// SubnetSelector discovers subnets by tags. A value of "" is a wildcard.
+	// LaunchTemplateVersion is the version of the launch template, e.g. 3,
+	// $Latest or $Default. Defaults to $Default.
+	// +optional
+	LaunchTemplateVersion *string `json:"launchTemplateVersion,omitempty"`
+	// AMIFamily is the family of the AMIs that instances use, one of AL2,
+	// Bottlerocket, Ubuntu, Windows2019 or Windows2022. It determines the
+	// default AMIs, the format of the user data and the operating system of
+	// the nodes. Defaults to AL2.
+	// +optional
+	AMIFamily *string `json:"amiFamily,omitempty"`
+	// AMISelector discovers AMIs by tags, instead of using the AMI family's
+	// recommended AMIs. Each instance type uses the most recently created AMI
+	// that matches its architecture. A value of "*" matches any tag value.
+	// +optional
+	AMISelector map[string]string `json:"amiSelector,omitempty"`
+	// UserData is merged with the user data that Karpenter generates to join
+	// the node to the cluster. For the AL2 and Ubuntu AMI families, it is a
+	// shell script, cloud-config or MIME multipart document, which is combined
+	// with Karpenter's bootstrap script into a MIME multipart document. For the
+	// Bottlerocket AMI family, it is TOML settings, and Karpenter's settings
+	// take precedence over any that are also specified. For the Windows AMI
+	// families, it is a PowerShell script, which runs in the same PowerShell
+	// block as Karpenter's bootstrap script.
+	// +optional
+	UserData *string `json:"userData,omitempty"`
+	// UserDataMode is whether the user data runs before (Prepend) or after
+	// (Append) Karpenter's bootstrap script. Defaults to Prepend. It does not
+	// apply to Bottlerocket, whose settings are always merged.
+	// +optional
+	UserDataMode *string `json:"userDataMode,omitempty"`
+	// ContainerRegistryMirrors maps container registries, e.g. docker.io, to
+	// the URLs of mirrors that the container runtime pulls their images from
+	// instead, e.g. in clusters without internet access. Only supported for
+	// the AL2, Bottlerocket and Ubuntu AMI families. On AL2, the mirrors are
+	// configured for containerd, but not for Docker, which instance types
+	// with GPUs or Inferentia chips use.
+	// +optional
+	ContainerRegistryMirrors map[string]string `json:"containerRegistryMirrors,omitempty"`
+	// SubnetSelector discovers subnets by tags, or by a comma separated list of
+	// Outpost ARNs or zone names with the aws-outpost-arns or aws-zones keys.
+	// Subnets on Outposts are only discovered by Outpost ARN. A value of "" is
+	// a wildcard.
 	// +optional
 	SubnetSelector map[string]string `json:"subnetSelector,omitempty"`
-	// SecurityGroups specify the names of the security groups.
+	// SecurityGroupSelector discovers security groups by tags, or by a comma
+	// separated list of IDs or group names with the aws-ids or aws-names keys.
+	// The security groups must be in the same VPC as the subnets. Launch templates
+	// define their own security groups, so this may not be specified with one.
 	// +optional
 	SecurityGroupSelector map[string]string `json:"securityGroupSelector,omitempty"`
+	// AssociatePublicIPAddress is whether instances are assigned a public IP
+	// address. If not specified, the subnet's setting is used. Set it to false
+	// to avoid public IP addresses in subnets that assign them by default.
+	// +optional
+	AssociatePublicIPAddress *bool `json:"associatePublicIPAddress,omitempty"`
+	// SpotAllocationStrategy is the strategy EC2 Fleet uses to choose the spot
+	// capacity pools that instances are launched from. One of lowest-price,
+	// capacity-optimized, capacity-optimized-prioritized or
+	// price-capacity-optimized. Defaults to capacity-optimized-prioritized,
+	// which prefers smaller instance types. Other strategies choose from all
+	// of the instance type options, which diversifies spot capacity.
+	// +optional
+	SpotAllocationStrategy *string `json:"spotAllocationStrategy,omitempty"`
 	// Tags to be applied on ec2 resources like instances and launch templates.
 	// +optional
 	Tags map[string]string `json:"tags,omitempty"`
+	// BlockDeviceMappings to be applied to provisioned nodes, e.g. to increase
+	// the size of the root volume. If not specified, the AMI's are used.
+	// +optional
+	BlockDeviceMappings []*BlockDeviceMapping `json:"blockDeviceMappings,omitempty"`
+	// KMSKeyID is the ID or ARN of the KMS key that the root volumes of
+	// provisioned nodes are encrypted with. It also encrypts the volumes of
+	// blockDeviceMappings that don't specify whether they are encrypted. If not
+	// specified, the default KMS key that Karpenter is configured with is used.
+	// +optional
+	KMSKeyID *string `json:"kmsKeyID,omitempty"`
+	// InstanceStorePolicy configures the NVMe instance store volumes of
+	// instance types that have them. RAID0 combines them into a single array
+	// that the kubelet and container runtime use instead of the root volume,
+	// so they are advertised as the node's ephemeral storage. If not
+	// specified, instance store volumes are not used. Only supported for the
+	// AL2 and Ubuntu AMI families.
+	// +optional
+	InstanceStorePolicy *string `json:"instanceStorePolicy,omitempty"`
+	// MetadataOptions configure the instance metadata service of provisioned
+	// nodes, e.g. to require IMDSv2. If not specified, EC2's defaults are used.
+	// +optional
+	MetadataOptions *MetadataOptions `json:"metadataOptions,omitempty"`
+	// PlacementGroup is the name of a placement group that instances launch
+	// into, e.g. a cluster placement group for low latency networking between
+	// nodes. Instances in a cluster placement group must be in a single zone.
+	// +optional
+	PlacementGroup *string `json:"placementGroup,omitempty"`
+	// Tenancy is whether instances run on shared hardware (default), on
+	// hardware dedicated to the account (dedicated), or on Dedicated Hosts
+	// (host). Defaults to default. Instances that aren't on shared hardware
+	// are launched as on-demand capacity.
+	// +optional
+	Tenancy *string `json:"tenancy,omitempty"`
+	// HostResourceGroupARN is the ARN of a host resource group that Dedicated
+	// Hosts are allocated from, which may only be specified with host tenancy.
+	// +optional
+	HostResourceGroupARN *string `json:"hostResourceGroupARN,omitempty"`
+	// CapacityReservationSpecification configures whether on-demand instances
+	// launch into On-Demand Capacity Reservations. If not specified, instances
+	// launch into any open capacity reservation that matches them.
+	// +optional
+	CapacityReservationSpecification *CapacityReservationSpecification `json:"capacityReservationSpecification,omitempty"`
+	// EmptyInstancePolicy is what happens to the on-demand instances of nodes
+	// that are deleted for being empty, one of Terminate, Stop or Hibernate.
+	// Stop and Hibernate are experimental, and keep the instances stopped so
+	// that they are restarted for pending pods, which is faster than launching
+	// new instances, at the cost of their EBS volumes. Hibernate also keeps
+	// their memory, if the instance type and AMI support hibernation and the
+	// root volume is encrypted and large enough to hold it. Spot instances are
+	// always terminated. Defaults to Terminate.
+	// +optional
+	EmptyInstancePolicy *string `json:"emptyInstancePolicy,omitempty"`
+	// RebalanceRecommendationPolicy is what happens to nodes whose instances
+	// receive a rebalance recommendation, one of Drain, Replace or Ignore.
+	// Drain deletes the node right away, like a spot interruption warning.
+	// Replace launches replacement nodes for its pods first, and only drains
+	// the node once they're ready. Defaults to Drain.
+	// +optional
+	RebalanceRecommendationPolicy *string `json:"rebalanceRecommendationPolicy,omitempty"`
+	// Region is the region that instances are launched in, if it isn't
+	// Karpenter's own region. Subnets, security groups, AMIs and launch
+	// templates are discovered in it.
+	// +optional
+	Region *string `json:"region,omitempty"`
+	// AssumeRoleARN is the ARN of an IAM role that Karpenter assumes to launch
+	// and terminate instances in another account. The role must trust
+	// Karpenter's own role, and grant the same permissions in its account.
+	// +optional
+	AssumeRoleARN *string `json:"assumeRoleARN,omitempty"`
+}
+
+type CapacityReservationSpecification struct {
+	// CapacityReservationPreference is open to launch into any open capacity
+	// reservation that matches the instance type and zone, or none to avoid
+	// capacity reservations. Defaults to open.
+	// +optional
+	CapacityReservationPreference *string `json:"capacityReservationPreference,omitempty"`
+	// CapacityReservationResourceGroupARN targets the capacity reservations in
+	// a resource group, including targeted capacity reservations, which are
+	// used before launching on-demand instances outside of them. It may not be
+	// specified with a capacity reservation preference.
+	// +optional
+	CapacityReservationResourceGroupARN *string `json:"capacityReservationResourceGroupARN,omitempty"`
+}
+
+type MetadataOptions struct {
+	// HTTPEndpoint enables or disables the instance metadata service, one of
+	// enabled or disabled. Defaults to enabled.
+	// +optional
+	HTTPEndpoint *string `json:"httpEndpoint,omitempty"`
+	// HTTPPutResponseHopLimit is the number of network hops that responses to
+	// metadata requests may travel, between 1 and 64. Defaults to 1. Pods
+	// that don't use host networking need a limit of at least 2 to reach the
+	// instance metadata service when session tokens are required.
+	// +optional
+	HTTPPutResponseHopLimit *int64 `json:"httpPutResponseHopLimit,omitempty"`
+	// HTTPTokens is whether requests to the instance metadata service must use
+	// a session token (IMDSv2), one of required or optional. Defaults to
+	// optional.
+	// +optional
+	HTTPTokens *string `json:"httpTokens,omitempty"`
+}
+
+type BlockDeviceMapping struct {
+	// The device name, e.g. /dev/xvda.
+	// +required
+	DeviceName *string `json:"deviceName,omitempty"`
+	// EBS contains parameters used to set up the EBS volume when the instance
+	// is launched.
+	// +required
+	EBS *BlockDevice `json:"ebs,omitempty"`
+}
+
+type BlockDevice struct {
+	// DeleteOnTermination indicates whether the volume is deleted when the
+	// instance terminates. Defaults to true.
+	// +optional
+	DeleteOnTermination *bool `json:"deleteOnTermination,omitempty"`
+	// Encrypted indicates whether the volume is encrypted.
+	// +optional
+	Encrypted *bool `json:"encrypted,omitempty"`
+	// IOPS is the number of I/O operations per second, which may only be
+	// specified for io1, io2 and gp3 volumes.
+	// +optional
+	IOPS *int64 `json:"iops,omitempty"`
+	// KMSKeyID is the ARN of the KMS key used to encrypt the volume, which may
+	// only be specified for encrypted volumes.
+	// +optional
+	KMSKeyID *string `json:"kmsKeyID,omitempty"`
+	// SnapshotID is the ID of the snapshot the volume is created from.
+	// +optional
+	SnapshotID *string `json:"snapshotID,omitempty"`
+	// Throughput in MiB/s, which may only be specified for gp3 volumes.
+	// +optional
+	Throughput *int64 `json:"throughput,omitempty"`
+	// VolumeSize is the size of the volume, e.g. 100Gi. It is rounded up to
+	// the nearest GiB, and is required unless a snapshot is specified.
+	// +optional
+	VolumeSize *resource.Quantity `json:"volumeSize,omitempty"`
+	// VolumeType of the volume, e.g. gp3. Defaults to gp2.
+	// +optional
+	VolumeType *string `json:"volumeType,omitempty"`
 }
 
+// Deserialize the provider of the constraints. Unknown fields are an error.
 func Deserialize(constraints *v1alpha5.Constraints) (*Constraints, error) {
 	if constraints.Provider == nil {
 		return nil, fmt.Errorf("invariant violated: spec.provider is not defined. Is the defaulting webhook installed?")
 	}
 	aws := &AWS{}
-	_, gvk, err := Codec.UniversalDeserializer().Decode(constraints.Provider.Raw, nil, aws)
+	_, gvk, err := StrictDecoder.Decode(constraints.Provider.Raw, nil, aws)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("decoding provider, %w", err)
 	}
 	if gvk != nil {
 		aws.SetGroupVersionKind(*gvk)
@@ -77,3 +283,12 @@ func (a *AWS) Serialize(constraints *v1alpha5.Constraints) error {
 	constraints.Provider.Raw = bytes
 	return nil
 }
+
+// OperatingSystem returns the operating system of the nodes that the AMI
+// family launches, which is linux unless the AMI family is Windows.
+func (a *AWS) OperatingSystem() string {
+	if a.AMIFamily != nil && functional.ContainsString(WindowsAMIFamilies, *a.AMIFamily) {
+		return v1alpha5.OperatingSystemWindows
+	}
+	return v1alpha5.OperatingSystemLinux
+}
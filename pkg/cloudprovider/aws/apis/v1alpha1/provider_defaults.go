@@ -26,12 +26,20 @@ import (
 
 var ClusterDiscoveryTagKeyFormat = "kubernetes.io/cluster/%s"
 
-// Default the constraints.
+// Default the constraints. The provider is not defaulted if it is referenced
+// rather than inlined, since it's an AWSNodeTemplate with its own defaults.
 func (c *Constraints) Default(ctx context.Context) {
 	c.defaultArchitecture()
 	c.defaultCapacityTypes()
-	c.defaultSubnets(injection.GetOptions(ctx).ClusterName)
-	c.defaultSecurityGroups(injection.GetOptions(ctx).ClusterName)
+	if c.AWS != nil {
+		c.AWS.Default(ctx)
+	}
+}
+
+// Default the provider
+func (a *AWS) Default(ctx context.Context) {
+	a.defaultSubnets(injection.GetOptions(ctx).ClusterName)
+	a.defaultSecurityGroups(injection.GetOptions(ctx).ClusterName)
 }
 
 func (c *Constraints) defaultCapacityTypes() {
@@ -62,16 +70,17 @@ func (c *Constraints) defaultArchitecture() {
 	})
 }
 
-func (c *Constraints) defaultSubnets(clusterName string) {
-	if c.SubnetSelector != nil {
+func (a *AWS) defaultSubnets(clusterName string) {
+	if a.SubnetSelector != nil {
 		return
 	}
-	c.SubnetSelector = map[string]string{fmt.Sprintf(ClusterDiscoveryTagKeyFormat, clusterName): "*"}
+	a.SubnetSelector = map[string]string{fmt.Sprintf(ClusterDiscoveryTagKeyFormat, clusterName): "*"}
 }
 
-func (c *Constraints) defaultSecurityGroups(clusterName string) {
-	if c.SecurityGroupSelector != nil {
+func (a *AWS) defaultSecurityGroups(clusterName string) {
+	// Launch templates define their own security groups
+	if a.SecurityGroupSelector != nil || a.LaunchTemplate != nil {
 		return
 	}
-	c.SecurityGroupSelector = map[string]string{fmt.Sprintf(ClusterDiscoveryTagKeyFormat, clusterName): "*"}
+	a.SecurityGroupSelector = map[string]string{fmt.Sprintf(ClusterDiscoveryTagKeyFormat, clusterName): "*"}
 }
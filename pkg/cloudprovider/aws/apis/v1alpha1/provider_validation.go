@@ -15,34 +15,153 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"context"
 	"fmt"
+	"net/url"
+	"strings"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/karpenter/pkg/utils/functional"
+	"github.com/aws/karpenter/pkg/utils/injection"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"knative.dev/pkg/apis"
 )
 
-func (a *AWS) Validate() (errs *apis.FieldError) {
-	return a.validate().ViaField("provider")
+var (
+	// EBS volumes are between 1GiB and 64TiB, depending on the volume type
+	minVolumeSize = resource.MustParse("1Gi")
+	maxVolumeSize = resource.MustParse("64Ti")
+	// Responses to instance metadata requests may travel between 1 and 64 hops
+	minMetadataHopLimit int64 = 1
+	maxMetadataHopLimit int64 = 64
+)
+
+func (a *AWS) Validate(ctx context.Context) (errs *apis.FieldError) {
+	return a.validate(ctx).ViaField("provider")
 }
 
-func (a *AWS) validate() (errs *apis.FieldError) {
+func (a *AWS) validate(ctx context.Context) (errs *apis.FieldError) {
 	return errs.Also(
-		a.validateInstanceProfile(),
+		a.validateInstanceProfile(injection.GetOptions(ctx).AWSDefaultInstanceProfile),
 		a.validateLaunchTemplate(),
+		a.validateAMIFamily(),
+		a.validateAMISelector(),
+		a.validateUserData(),
+		a.validateContainerRegistryMirrors(),
 		a.validateSubnets(),
 		a.validateSecurityGroups(),
+		a.validateAssociatePublicIPAddress(),
+		a.validateSpotAllocationStrategy(),
 		a.validateTags(),
+		a.validateBlockDeviceMappings(),
+		a.validateKMSKeyID(),
+		a.validateInstanceStorePolicy(),
+		a.validateEmptyInstancePolicy(),
+		a.validateRebalanceRecommendationPolicy(),
+		a.validateMetadataOptions(),
+		a.validatePlacementGroup(),
+		a.validateTenancy(),
+		a.validateCapacityReservationSpecification(),
+		a.validateRegion(),
+		a.validateAssumeRoleARN(),
 	)
 }
 
-func (a *AWS) validateInstanceProfile() (errs *apis.FieldError) {
-	if a.InstanceProfile == "" {
+// validateInstanceProfile requires an instance profile unless a launch template
+// is specified, or Karpenter is configured with a default instance profile.
+func (a *AWS) validateInstanceProfile(defaultInstanceProfile string) (errs *apis.FieldError) {
+	if a.LaunchTemplate != nil {
+		if a.InstanceProfile != "" {
+			errs = errs.Also(apis.ErrMultipleOneOf("launchTemplate", "instanceProfile"))
+		}
+		return errs
+	}
+	if a.InstanceProfile == "" && defaultInstanceProfile == "" {
 		errs = errs.Also(apis.ErrMissingField("instanceProfile"))
 	}
 	return errs
 }
 
 func (a *AWS) validateLaunchTemplate() (errs *apis.FieldError) {
-	// nothing to validate at the moment
+	if a.LaunchTemplate != nil && *a.LaunchTemplate == "" {
+		errs = errs.Also(apis.ErrInvalidValue("\"\"", "launchTemplate"))
+	}
+	if a.LaunchTemplateVersion != nil {
+		if a.LaunchTemplate == nil {
+			errs = errs.Also(apis.ErrGeneric("launchTemplateVersion may only be specified with launchTemplate", "launchTemplateVersion"))
+		}
+		if *a.LaunchTemplateVersion == "" {
+			errs = errs.Also(apis.ErrInvalidValue("\"\"", "launchTemplateVersion"))
+		}
+	}
+	return errs
+}
+
+func (a *AWS) validateAMIFamily() (errs *apis.FieldError) {
+	if a.AMIFamily == nil {
+		return errs
+	}
+	if a.LaunchTemplate != nil {
+		errs = errs.Also(apis.ErrMultipleOneOf("launchTemplate", "amiFamily"))
+	}
+	if !functional.ContainsString(SupportedAMIFamilies, *a.AMIFamily) {
+		errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%s not in %v", *a.AMIFamily, SupportedAMIFamilies), "amiFamily"))
+	}
+	return errs
+}
+
+func (a *AWS) validateAMISelector() (errs *apis.FieldError) {
+	if a.AMISelector == nil {
+		return errs
+	}
+	if a.LaunchTemplate != nil {
+		errs = errs.Also(apis.ErrMultipleOneOf("launchTemplate", "amiSelector"))
+	}
+	for key, value := range a.AMISelector {
+		if key == "" || value == "" {
+			errs = errs.Also(apis.ErrInvalidValue("\"\"", fmt.Sprintf("amiSelector['%s']", key)))
+		}
+	}
+	return errs
+}
+
+func (a *AWS) validateUserData() (errs *apis.FieldError) {
+	if a.UserData != nil && a.LaunchTemplate != nil {
+		errs = errs.Also(apis.ErrMultipleOneOf("launchTemplate", "userData"))
+	}
+	if a.UserDataMode != nil {
+		if a.UserData == nil {
+			errs = errs.Also(apis.ErrGeneric("userDataMode may only be specified with userData", "userDataMode"))
+		}
+		if !functional.ContainsString(SupportedUserDataModes, *a.UserDataMode) {
+			errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%s not in %v", *a.UserDataMode, SupportedUserDataModes), "userDataMode"))
+		}
+	}
+	return errs
+}
+
+func (a *AWS) validateContainerRegistryMirrors() (errs *apis.FieldError) {
+	if a.ContainerRegistryMirrors == nil {
+		return nil
+	}
+	if a.LaunchTemplate != nil {
+		errs = errs.Also(apis.ErrMultipleOneOf("launchTemplate", "containerRegistryMirrors"))
+	}
+	if a.AMIFamily != nil && *a.AMIFamily != AMIFamilyAL2 && *a.AMIFamily != AMIFamilyBottlerocket && *a.AMIFamily != AMIFamilyUbuntu {
+		errs = errs.Also(apis.ErrGeneric(fmt.Sprintf("containerRegistryMirrors is not supported for the %s AMI family", *a.AMIFamily), "containerRegistryMirrors"))
+	}
+	for registry, mirror := range a.ContainerRegistryMirrors {
+		if registry == "" || strings.ContainsAny(registry, "/ ") {
+			errs = errs.Also(apis.ErrInvalidKeyName(registry, "containerRegistryMirrors"))
+			continue
+		}
+		if endpoint, err := url.Parse(mirror); err != nil || !endpoint.IsAbs() || endpoint.Hostname() == "" {
+			errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%q not a valid URL", mirror), fmt.Sprintf("containerRegistryMirrors['%s']", registry)))
+		}
+	}
 	return errs
 }
 
@@ -53,19 +172,59 @@ func (a *AWS) validateSubnets() (errs *apis.FieldError) {
 	for key, value := range a.SubnetSelector {
 		if key == "" || value == "" {
 			errs = errs.Also(apis.ErrInvalidValue("\"\"", fmt.Sprintf("subnetSelector['%s']", key)))
+			continue
+		}
+		if key != SubnetOutpostARNsSelectorKey && key != SubnetZonesSelectorKey {
+			continue
+		}
+		for _, item := range strings.Split(value, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" || (key == SubnetOutpostARNsSelectorKey && !(strings.HasPrefix(item, "arn:") && strings.Contains(item, ":outpost/"))) {
+				errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%q in %q", item, value), fmt.Sprintf("subnetSelector['%s']", key)))
+			}
 		}
 	}
 	return errs
 }
 
 func (a *AWS) validateSecurityGroups() (errs *apis.FieldError) {
+	if a.LaunchTemplate != nil {
+		if a.SecurityGroupSelector != nil {
+			errs = errs.Also(apis.ErrMultipleOneOf("launchTemplate", "securityGroupSelector"))
+		}
+		return errs
+	}
 	if a.SecurityGroupSelector == nil {
 		errs = errs.Also(apis.ErrMissingField("securityGroupSelector"))
 	}
 	for key, value := range a.SecurityGroupSelector {
 		if key == "" || value == "" {
 			errs = errs.Also(apis.ErrInvalidValue("\"\"", fmt.Sprintf("securityGroupSelector['%s']", key)))
+			continue
+		}
+		if key != SecurityGroupIDsSelectorKey && key != SecurityGroupNamesSelectorKey {
+			continue
 		}
+		for _, item := range strings.Split(value, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" || (key == SecurityGroupIDsSelectorKey && !strings.HasPrefix(item, "sg-")) {
+				errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%q in %q", item, value), fmt.Sprintf("securityGroupSelector['%s']", key)))
+			}
+		}
+	}
+	return errs
+}
+
+func (a *AWS) validateAssociatePublicIPAddress() (errs *apis.FieldError) {
+	if a.AssociatePublicIPAddress != nil && a.LaunchTemplate != nil {
+		errs = errs.Also(apis.ErrMultipleOneOf("launchTemplate", "associatePublicIPAddress"))
+	}
+	return errs
+}
+
+func (a *AWS) validateSpotAllocationStrategy() (errs *apis.FieldError) {
+	if a.SpotAllocationStrategy != nil && !functional.ContainsString(SupportedSpotAllocationStrategies, *a.SpotAllocationStrategy) {
+		errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%s not in %v", *a.SpotAllocationStrategy, SupportedSpotAllocationStrategies), "spotAllocationStrategy"))
 	}
 	return errs
 }
@@ -81,3 +240,211 @@ func (a *AWS) validateTags() (errs *apis.FieldError) {
 	}
 	return errs
 }
+
+func (a *AWS) validateBlockDeviceMappings() (errs *apis.FieldError) {
+	if a.BlockDeviceMappings != nil && a.LaunchTemplate != nil {
+		errs = errs.Also(apis.ErrMultipleOneOf("launchTemplate", "blockDeviceMappings"))
+	}
+	for i, blockDeviceMapping := range a.BlockDeviceMappings {
+		errs = errs.Also(blockDeviceMapping.validate().ViaFieldIndex("blockDeviceMappings", i))
+	}
+	return errs
+}
+
+func (a *AWS) validateKMSKeyID() (errs *apis.FieldError) {
+	if a.KMSKeyID == nil {
+		return nil
+	}
+	if a.LaunchTemplate != nil {
+		errs = errs.Also(apis.ErrMultipleOneOf("launchTemplate", "kmsKeyID"))
+	}
+	if *a.KMSKeyID == "" {
+		errs = errs.Also(apis.ErrInvalidValue("\"\"", "kmsKeyID"))
+	}
+	return errs
+}
+
+func (a *AWS) validateInstanceStorePolicy() (errs *apis.FieldError) {
+	if a.InstanceStorePolicy == nil {
+		return nil
+	}
+	if a.LaunchTemplate != nil {
+		errs = errs.Also(apis.ErrMultipleOneOf("launchTemplate", "instanceStorePolicy"))
+	}
+	if !functional.ContainsString(SupportedInstanceStorePolicies, *a.InstanceStorePolicy) {
+		errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%s not in %v", *a.InstanceStorePolicy, SupportedInstanceStorePolicies), "instanceStorePolicy"))
+	}
+	if a.AMIFamily != nil && *a.AMIFamily != AMIFamilyAL2 && *a.AMIFamily != AMIFamilyUbuntu {
+		errs = errs.Also(apis.ErrGeneric(fmt.Sprintf("instanceStorePolicy is not supported for the %s AMI family", *a.AMIFamily), "instanceStorePolicy"))
+	}
+	return errs
+}
+
+// validateEmptyInstancePolicy rejects hibernation with a launch template,
+// since hibernation is configured by the launch template that instances are
+// launched with.
+func (a *AWS) validateEmptyInstancePolicy() (errs *apis.FieldError) {
+	if a.EmptyInstancePolicy == nil {
+		return nil
+	}
+	if !functional.ContainsString(SupportedEmptyInstancePolicies, *a.EmptyInstancePolicy) {
+		errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%s not in %v", *a.EmptyInstancePolicy, SupportedEmptyInstancePolicies), "emptyInstancePolicy"))
+	}
+	if *a.EmptyInstancePolicy == EmptyInstancePolicyHibernate && a.LaunchTemplate != nil {
+		errs = errs.Also(apis.ErrMultipleOneOf("launchTemplate", "emptyInstancePolicy"))
+	}
+	return errs
+}
+
+func (a *AWS) validateRebalanceRecommendationPolicy() (errs *apis.FieldError) {
+	if a.RebalanceRecommendationPolicy == nil {
+		return nil
+	}
+	if !functional.ContainsString(SupportedRebalanceRecommendationPolicies, *a.RebalanceRecommendationPolicy) {
+		errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%s not in %v", *a.RebalanceRecommendationPolicy, SupportedRebalanceRecommendationPolicies), "rebalanceRecommendationPolicy"))
+	}
+	return errs
+}
+
+func (a *AWS) validateRegion() (errs *apis.FieldError) {
+	if a.Region != nil && *a.Region == "" {
+		errs = errs.Also(apis.ErrInvalidValue(*a.Region, "region"))
+	}
+	return errs
+}
+
+// validateAssumeRoleARN requires the ARN of an IAM role
+func (a *AWS) validateAssumeRoleARN() (errs *apis.FieldError) {
+	if a.AssumeRoleARN == nil {
+		return nil
+	}
+	if parsed, err := arn.Parse(*a.AssumeRoleARN); err != nil || parsed.Service != iam.ServiceName || !strings.HasPrefix(parsed.Resource, "role/") {
+		errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%s is not the ARN of an IAM role", *a.AssumeRoleARN), "assumeRoleARN"))
+	}
+	return errs
+}
+
+func (a *AWS) validateMetadataOptions() (errs *apis.FieldError) {
+	if a.MetadataOptions == nil {
+		return nil
+	}
+	if a.LaunchTemplate != nil {
+		errs = errs.Also(apis.ErrMultipleOneOf("launchTemplate", "metadataOptions"))
+	}
+	return errs.Also(a.MetadataOptions.validate().ViaField("metadataOptions"))
+}
+
+func (m *MetadataOptions) validate() (errs *apis.FieldError) {
+	if m.HTTPEndpoint != nil && !functional.ContainsString(ec2.LaunchTemplateInstanceMetadataEndpointState_Values(), *m.HTTPEndpoint) {
+		errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%s not in %v", *m.HTTPEndpoint, ec2.LaunchTemplateInstanceMetadataEndpointState_Values()), "httpEndpoint"))
+	}
+	if m.HTTPPutResponseHopLimit != nil && (*m.HTTPPutResponseHopLimit < minMetadataHopLimit || *m.HTTPPutResponseHopLimit > maxMetadataHopLimit) {
+		errs = errs.Also(apis.ErrOutOfBoundsValue(*m.HTTPPutResponseHopLimit, minMetadataHopLimit, maxMetadataHopLimit, "httpPutResponseHopLimit"))
+	}
+	if m.HTTPTokens != nil && !functional.ContainsString(ec2.LaunchTemplateHttpTokensState_Values(), *m.HTTPTokens) {
+		errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%s not in %v", *m.HTTPTokens, ec2.LaunchTemplateHttpTokensState_Values()), "httpTokens"))
+	}
+	return errs
+}
+
+func (a *AWS) validatePlacementGroup() (errs *apis.FieldError) {
+	if a.PlacementGroup == nil {
+		return nil
+	}
+	if a.LaunchTemplate != nil {
+		errs = errs.Also(apis.ErrMultipleOneOf("launchTemplate", "placementGroup"))
+	}
+	if *a.PlacementGroup == "" {
+		errs = errs.Also(apis.ErrInvalidValue(*a.PlacementGroup, "placementGroup"))
+	}
+	return errs
+}
+
+func (a *AWS) validateTenancy() (errs *apis.FieldError) {
+	if a.Tenancy == nil && a.HostResourceGroupARN == nil {
+		return nil
+	}
+	if a.LaunchTemplate != nil {
+		if a.Tenancy != nil {
+			errs = errs.Also(apis.ErrMultipleOneOf("launchTemplate", "tenancy"))
+		}
+		if a.HostResourceGroupARN != nil {
+			errs = errs.Also(apis.ErrMultipleOneOf("launchTemplate", "hostResourceGroupARN"))
+		}
+	}
+	if a.Tenancy != nil && !functional.ContainsString(ec2.Tenancy_Values(), *a.Tenancy) {
+		errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%s not in %v", *a.Tenancy, ec2.Tenancy_Values()), "tenancy"))
+	}
+	if a.HostResourceGroupARN != nil {
+		if aws.StringValue(a.Tenancy) != ec2.TenancyHost {
+			errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("may only be specified with tenancy %s", ec2.TenancyHost), "hostResourceGroupARN"))
+		}
+		if !strings.HasPrefix(*a.HostResourceGroupARN, "arn:") {
+			errs = errs.Also(apis.ErrInvalidValue(*a.HostResourceGroupARN, "hostResourceGroupARN"))
+		}
+	}
+	return errs
+}
+
+func (a *AWS) validateCapacityReservationSpecification() (errs *apis.FieldError) {
+	if a.CapacityReservationSpecification == nil {
+		return nil
+	}
+	if a.LaunchTemplate != nil {
+		errs = errs.Also(apis.ErrMultipleOneOf("launchTemplate", "capacityReservationSpecification"))
+	}
+	return errs.Also(a.CapacityReservationSpecification.validate().ViaField("capacityReservationSpecification"))
+}
+
+func (c *CapacityReservationSpecification) validate() (errs *apis.FieldError) {
+	if c.CapacityReservationPreference != nil && c.CapacityReservationResourceGroupARN != nil {
+		errs = errs.Also(apis.ErrMultipleOneOf("capacityReservationPreference", "capacityReservationResourceGroupARN"))
+	}
+	if c.CapacityReservationPreference != nil && !functional.ContainsString(ec2.CapacityReservationPreference_Values(), *c.CapacityReservationPreference) {
+		errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%s not in %v", *c.CapacityReservationPreference, ec2.CapacityReservationPreference_Values()), "capacityReservationPreference"))
+	}
+	if c.CapacityReservationResourceGroupARN != nil && !strings.HasPrefix(*c.CapacityReservationResourceGroupARN, "arn:") {
+		errs = errs.Also(apis.ErrInvalidValue(*c.CapacityReservationResourceGroupARN, "capacityReservationResourceGroupARN"))
+	}
+	return errs
+}
+
+func (b *BlockDeviceMapping) validate() (errs *apis.FieldError) {
+	if b == nil {
+		return apis.ErrMissingField("deviceName", "ebs")
+	}
+	if b.DeviceName == nil || *b.DeviceName == "" {
+		errs = errs.Also(apis.ErrMissingField("deviceName"))
+	}
+	if b.EBS == nil {
+		return errs.Also(apis.ErrMissingField("ebs"))
+	}
+	return errs.Also(b.EBS.validate().ViaField("ebs"))
+}
+
+func (b *BlockDevice) validate() (errs *apis.FieldError) {
+	volumeType := ec2.VolumeTypeGp2
+	if b.VolumeType != nil {
+		volumeType = *b.VolumeType
+		if !functional.ContainsString(ec2.VolumeType_Values(), volumeType) {
+			errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%s not in %v", volumeType, ec2.VolumeType_Values()), "volumeType"))
+		}
+	}
+	if b.VolumeSize == nil {
+		if b.SnapshotID == nil {
+			errs = errs.Also(apis.ErrMissingField("volumeSize"))
+		}
+	} else if b.VolumeSize.Cmp(minVolumeSize) < 0 || b.VolumeSize.Cmp(maxVolumeSize) > 0 {
+		errs = errs.Also(apis.ErrOutOfBoundsValue(b.VolumeSize.String(), minVolumeSize.String(), maxVolumeSize.String(), "volumeSize"))
+	}
+	if b.IOPS != nil && !functional.ContainsString([]string{ec2.VolumeTypeIo1, ec2.VolumeTypeIo2, ec2.VolumeTypeGp3}, volumeType) {
+		errs = errs.Also(apis.ErrGeneric(fmt.Sprintf("iops may not be specified for %s volumes", volumeType), "iops"))
+	}
+	if b.Throughput != nil && volumeType != ec2.VolumeTypeGp3 {
+		errs = errs.Also(apis.ErrGeneric(fmt.Sprintf("throughput may not be specified for %s volumes", volumeType), "throughput"))
+	}
+	if b.KMSKeyID != nil && !aws.BoolValue(b.Encrypted) {
+		errs = errs.Also(apis.ErrGeneric("kmsKeyID may only be specified for encrypted volumes", "kmsKeyID"))
+	}
+	return errs
+}
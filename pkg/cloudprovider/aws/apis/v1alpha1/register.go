@@ -17,9 +17,11 @@ package v1alpha1
 import (
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/runtime/serializer/json"
 )
 
 var (
@@ -32,14 +34,134 @@ var (
 	AWSRestrictedLabelDomains = []string{
 		"k8s.aws",
 	}
+	AMIFamilyAL2          = "AL2"
+	AMIFamilyBottlerocket = "Bottlerocket"
+	AMIFamilyUbuntu       = "Ubuntu"
+	AMIFamilyWindows2019  = "Windows2019"
+	AMIFamilyWindows2022  = "Windows2022"
+	SupportedAMIFamilies  = []string{
+		AMIFamilyAL2,
+		AMIFamilyBottlerocket,
+		AMIFamilyUbuntu,
+		AMIFamilyWindows2019,
+		AMIFamilyWindows2022,
+	}
+	WindowsAMIFamilies = []string{
+		AMIFamilyWindows2019,
+		AMIFamilyWindows2022,
+	}
+	// SpotAllocationStrategyPriceCapacityOptimized is not yet defined by the
+	// version of the AWS SDK in use.
+	SpotAllocationStrategyPriceCapacityOptimized = "price-capacity-optimized"
+	SupportedSpotAllocationStrategies            = []string{
+		ec2.SpotAllocationStrategyLowestPrice,
+		ec2.SpotAllocationStrategyCapacityOptimized,
+		ec2.SpotAllocationStrategyCapacityOptimizedPrioritized,
+		SpotAllocationStrategyPriceCapacityOptimized,
+	}
+	// SecurityGroupIDsSelectorKey selects security groups by a comma separated
+	// list of IDs, and SecurityGroupNamesSelectorKey by a comma separated list
+	// of group names, rather than by tag.
+	SecurityGroupIDsSelectorKey   = "aws-ids"
+	SecurityGroupNamesSelectorKey = "aws-names"
+	UserDataModePrepend           = "Prepend"
+	UserDataModeAppend            = "Append"
+	SupportedUserDataModes        = []string{
+		UserDataModePrepend,
+		UserDataModeAppend,
+	}
+	// InstanceStorePolicyRAID0 combines the NVMe instance store volumes into a
+	// RAID0 array for the kubelet and container runtime.
+	InstanceStorePolicyRAID0       = "RAID0"
+	SupportedInstanceStorePolicies = []string{
+		InstanceStorePolicyRAID0,
+	}
+	// SubnetOutpostARNsSelectorKey selects subnets on Outposts by a comma
+	// separated list of Outpost ARNs, and SubnetZonesSelectorKey selects subnets
+	// by a comma separated list of zone names, which may be Local Zones.
+	SubnetOutpostARNsSelectorKey = "aws-outpost-arns"
+	SubnetZonesSelectorKey       = "aws-zones"
+	// EmptyInstancePolicyStop and EmptyInstancePolicyHibernate keep the
+	// instances of empty nodes stopped, so that they can be restarted for
+	// pending pods, rather than terminating them.
+	EmptyInstancePolicyTerminate   = "Terminate"
+	EmptyInstancePolicyStop        = "Stop"
+	EmptyInstancePolicyHibernate   = "Hibernate"
+	SupportedEmptyInstancePolicies = []string{
+		EmptyInstancePolicyTerminate,
+		EmptyInstancePolicyStop,
+		EmptyInstancePolicyHibernate,
+	}
+	// EmptyInstancePolicyAnnotationKey is set on nodes whose instances are
+	// stopped or hibernated, rather than terminated, once they are empty.
+	EmptyInstancePolicyAnnotationKey = "karpenter.k8s.aws/empty-instance-policy"
+	// RebalanceRecommendationPolicyReplace and
+	// RebalanceRecommendationPolicyIgnore handle rebalance recommendations more
+	// softly than spot interruption warnings, rather than draining right away.
+	RebalanceRecommendationPolicyDrain       = "Drain"
+	RebalanceRecommendationPolicyReplace     = "Replace"
+	RebalanceRecommendationPolicyIgnore      = "Ignore"
+	SupportedRebalanceRecommendationPolicies = []string{
+		RebalanceRecommendationPolicyDrain,
+		RebalanceRecommendationPolicyReplace,
+		RebalanceRecommendationPolicyIgnore,
+	}
+	// RebalanceRecommendationPolicyAnnotationKey is set on nodes whose
+	// rebalance recommendations aren't drained right away.
+	RebalanceRecommendationPolicyAnnotationKey = "karpenter.k8s.aws/rebalance-recommendation-policy"
+	// RegionAnnotationKey and AssumeRoleARNAnnotationKey are set on nodes whose
+	// instances were launched in another region or account, so that they are
+	// terminated there.
+	RegionAnnotationKey        = "karpenter.k8s.aws/region"
+	AssumeRoleARNAnnotationKey = "karpenter.k8s.aws/assume-role-arn"
+	// SpecHashAnnotationKey is a hash of the provider that the node's instance
+	// was launched with, resolved from its AWSNodeTemplate if it's referenced,
+	// so that nodes drift when it changes.
+	SpecHashAnnotationKey = "karpenter.k8s.aws/spec-hash"
+	// Instance type labels describe the attributes of instance types, so that
+	// requirements can select them without enumerating their names, e.g.
+	// karpenter.k8s.aws/instance-cpu In [4, 8]. Memory is in MiB and local
+	// NVMe storage in GB.
+	LabelInstanceCPU             = "karpenter.k8s.aws/instance-cpu"
+	LabelInstanceMemory          = "karpenter.k8s.aws/instance-memory"
+	LabelInstanceGeneration      = "karpenter.k8s.aws/instance-generation"
+	LabelInstanceHypervisor      = "karpenter.k8s.aws/instance-hypervisor"
+	LabelInstanceLocalNVMe       = "karpenter.k8s.aws/instance-local-nvme"
+	LabelInstanceGPUCount        = "karpenter.k8s.aws/instance-gpu-count"
+	LabelInstanceGPUManufacturer = "karpenter.k8s.aws/instance-gpu-manufacturer"
+	InstanceTypeLabels           = []string{
+		LabelInstanceCPU,
+		LabelInstanceMemory,
+		LabelInstanceGeneration,
+		LabelInstanceHypervisor,
+		LabelInstanceLocalNVMe,
+		LabelInstanceGPUCount,
+		LabelInstanceGPUManufacturer,
+	}
 )
 
 var (
+	// Scheme and Codec decode the provider's parameters of provisioners
 	Scheme = runtime.NewScheme()
 	Codec  = serializer.NewCodecFactory(Scheme, serializer.EnableStrict)
+	// StrictDecoder rejects unknown fields of providers, e.g. misspelled
+	// ones, rather than silently dropping them
+	StrictDecoder = json.NewSerializerWithOptions(json.DefaultMetaFactory, Scheme, Scheme, json.SerializerOptions{Strict: true})
+	// SchemeGroupVersion is the API group of AWSNodeTemplates
+	SchemeGroupVersion = schema.GroupVersion{Group: "karpenter.k8s.aws", Version: "v1alpha1"}
+	SchemeBuilder      = runtime.NewSchemeBuilder(func(scheme *runtime.Scheme) error {
+		scheme.AddKnownTypes(SchemeGroupVersion,
+			&AWSNodeTemplate{},
+			&AWSNodeTemplateList{},
+		)
+		metav1.AddToGroupVersion(scheme, SchemeGroupVersion)
+		return nil
+	})
+	AddToScheme = SchemeBuilder.AddToScheme
 )
 
 func init() {
 	Scheme.AddKnownTypes(schema.GroupVersion{Group: v1alpha5.ExtensionsGroup, Version: "v1alpha1"}, &AWS{})
 	v1alpha5.RestrictedLabelDomains = v1alpha5.RestrictedLabelDomains.Insert(AWSRestrictedLabelDomains...)
+	v1alpha5.WellKnownLabels = v1alpha5.WellKnownLabels.Insert(InstanceTypeLabels...)
 }
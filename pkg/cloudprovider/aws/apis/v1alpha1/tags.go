@@ -29,18 +29,39 @@ const (
 	ClusterTagKeyFormat = "kubernetes.io/cluster/%s"
 	// KarpenterTagKeyFormat is set on all Karpenter owned resources.
 	KarpenterTagKeyFormat = "karpenter.sh/cluster/%s"
+	// ProvisionerNameTagKey is set to the name of the provisioner that launched
+	// the resource, e.g. for cost allocation.
+	ProvisionerNameTagKey = "karpenter.sh/provisioner-name"
+	// ManagedByTagKey is set to the name of the cluster whose Karpenter
+	// launched the resource, e.g. to scope IAM policies.
+	ManagedByTagKey = "karpenter.sh/managed-by"
+	// StoppedTimestampTagKey is set to the time that the instance of an empty
+	// node was stopped, and is removed when the instance is restarted.
+	StoppedTimestampTagKey = "karpenter.k8s.aws/stopped-timestamp"
+	// SpecHashTagKey is set to the spec hash of the node whose instance was
+	// stopped, so that it's only restarted for the same provisioner spec.
+	SpecHashTagKey = "karpenter.k8s.aws/spec-hash"
 )
 
-func MergeTags(ctx context.Context, customTags map[string]string) []*ec2.Tag {
-	// We'll set some default tags, but allow them to be overridden in the merge
+// Tags returns the tags of the resources launched for the provisioner, which
+// are the default tags merged with the custom tags. Custom tags take
+// precedence.
+func Tags(ctx context.Context, customTags map[string]string) map[string]string {
+	clusterName := injection.GetOptions(ctx).ClusterName
+	provisionerName := injection.GetNamespacedName(ctx).Name
 	managedTags := map[string]string{
-		"Name": fmt.Sprintf("karpenter.sh/cluster/%s/provisioner/%s",
-			injection.GetOptions(ctx).ClusterName, injection.GetNamespacedName(ctx).Name),
-		fmt.Sprintf(ClusterTagKeyFormat, injection.GetOptions(ctx).ClusterName):   "owned",
-		fmt.Sprintf(KarpenterTagKeyFormat, injection.GetOptions(ctx).ClusterName): "owned",
+		"Name": fmt.Sprintf("karpenter.sh/cluster/%s/provisioner/%s", clusterName, provisionerName),
+		fmt.Sprintf(ClusterTagKeyFormat, clusterName):   "owned",
+		fmt.Sprintf(KarpenterTagKeyFormat, clusterName): "owned",
+		ProvisionerNameTagKey:                           provisionerName,
+		ManagedByTagKey:                                 clusterName,
 	}
+	return functional.UnionStringMaps(managedTags, customTags)
+}
+
+func MergeTags(ctx context.Context, customTags map[string]string) []*ec2.Tag {
 	ec2Tags := []*ec2.Tag{}
-	for key, value := range functional.UnionStringMaps(managedTags, customTags) {
+	for key, value := range Tags(ctx, customTags) {
 		ec2Tags = append(ec2Tags, &ec2.Tag{Key: aws.String(key), Value: aws.String(value)})
 	}
 	return ec2Tags
@@ -23,17 +23,28 @@ import (
 	"github.com/aws/aws-sdk-go/aws/endpoints"
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/ec2"
-	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
 	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
 	"github.com/aws/karpenter/pkg/cloudprovider"
 	"github.com/aws/karpenter/pkg/cloudprovider/aws/apis/v1alpha1"
+	"github.com/aws/karpenter/pkg/controllers"
+	"github.com/aws/karpenter/pkg/utils/functional"
+	"github.com/aws/karpenter/pkg/utils/injection"
 	"github.com/aws/karpenter/pkg/utils/project"
 
 	"go.uber.org/multierr"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"knative.dev/pkg/apis"
 	"knative.dev/pkg/logging"
+	"knative.dev/pkg/webhook/resourcesemantics"
+	kubeclient "sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 const (
@@ -50,42 +61,96 @@ const (
 	// AWS APIs, which can have a serious impact on performance and scalability.
 	// DO NOT CHANGE THIS VALUE WITHOUT DUE CONSIDERATION
 	CacheTTL = 60 * time.Second
+	// FailedClientTokensCacheTTL is how long the client tokens of fleets that
+	// launched no instances are remembered, so that they aren't reused.
+	FailedClientTokensCacheTTL = 1 * time.Hour
 	// CacheCleanupInterval triggers cache cleanup (lazy eviction) at this interval.
 	CacheCleanupInterval = 10 * time.Minute
+	// NodeTemplateNotFoundReason is the reason that a provisioner isn't active
+	// when the AWSNodeTemplate that it references doesn't exist.
+	NodeTemplateNotFoundReason = "NodeTemplateNotFound"
 )
 
 type CloudProvider struct {
-	instanceTypeProvider *InstanceTypeProvider
-	subnetProvider       *SubnetProvider
-	instanceProvider     *InstanceProvider
+	// providers of Karpenter's own account and region
+	*providers
+	// targets are the accounts and regions of provisioners with a region or an
+	// assumed role, which is nil if they aren't supported
+	targets *targets
+	sqsapi  sqsiface.SQSAPI
+	// kubeClient gets the AWSNodeTemplates that provisioners reference
+	kubeClient kubeclient.Client
 }
 
 func NewCloudProvider(ctx context.Context, options cloudprovider.Options) *CloudProvider {
-	sess := withUserAgent(session.Must(session.NewSession(
+	overrides, err := injection.GetOptions(ctx).AWSEndpoints()
+	if err != nil {
+		panic(fmt.Sprintf("Failed to parse AWS endpoint overrides, %s", err.Error()))
+	}
+	sess := withMetrics(withThrottling(withUserAgent(session.Must(session.NewSession(
 		request.WithRetryer(
-			&aws.Config{STSRegionalEndpoint: endpoints.RegionalSTSEndpoint},
+			&aws.Config{STSRegionalEndpoint: endpoints.RegionalSTSEndpoint, EndpointResolver: endpointResolver(overrides)},
 			client.DefaultRetryer{NumMaxRetries: client.DefaultRetryerMaxNumRetries},
 		),
-	)))
+	)))))
 	if *sess.Config.Region == "" {
 		logging.FromContext(ctx).Debug("AWS region not configured, asking EC2 Instance Metadata Service")
 		*sess.Config.Region = getRegionFromIMDS(sess)
 	}
 	logging.FromContext(ctx).Debugf("Using AWS region %s", *sess.Config.Region)
-	ec2api := ec2.New(sess)
-	subnetProvider := NewSubnetProvider(ec2api)
-	instanceTypeProvider := NewInstanceTypeProvider(ec2api, subnetProvider)
+	defaults := newProviders(sess, options.ClientSet)
 	return &CloudProvider{
-		instanceTypeProvider: instanceTypeProvider,
-		subnetProvider:       subnetProvider,
-		instanceProvider: &InstanceProvider{ec2api, instanceTypeProvider, subnetProvider,
-			NewLaunchTemplateProvider(
-				ec2api,
-				NewAMIProvider(ssm.New(sess), options.ClientSet),
-				NewSecurityGroupProvider(ec2api),
-			),
+		providers: defaults,
+		targets: &targets{
+			session:   sess,
+			clientSet: options.ClientSet,
+			providers: map[target]*providers{{}: defaults},
 		},
+		sqsapi:     sqs.New(sess),
+		kubeClient: newKubeClientOrDie(ctx, options.KubeClient),
+	}
+}
+
+// newKubeClientOrDie returns the manager's client, which reads
+// AWSNodeTemplates through its cache, or an uncached client if there's no
+// manager, e.g. in the webhook.
+func newKubeClientOrDie(ctx context.Context, managerClient kubeclient.Client) kubeclient.Client {
+	if managerClient != nil {
+		utilruntime.Must(v1alpha1.AddToScheme(managerClient.Scheme()))
+		return managerClient
+	}
+	scheme := runtime.NewScheme()
+	utilruntime.Must(v1alpha1.AddToScheme(scheme))
+	kubeClient, err := kubeclient.New(injection.GetConfig(ctx), kubeclient.Options{Scheme: scheme})
+	if err != nil {
+		panic(fmt.Sprintf("Failed to create kube client, %s", err.Error()))
 	}
+	return kubeClient
+}
+
+// deserialize the provisioner's AWS specific parameters, which are either its
+// provider, or the spec of the AWSNodeTemplate that it references
+func (c *CloudProvider) deserialize(ctx context.Context, constraints *v1alpha5.Constraints) (*v1alpha1.Constraints, error) {
+	if constraints.ProviderRef == nil {
+		return v1alpha1.Deserialize(constraints)
+	}
+	nodeTemplate := &v1alpha1.AWSNodeTemplate{}
+	if err := c.kubeClient.Get(ctx, types.NamespacedName{Name: constraints.ProviderRef.Name}, nodeTemplate); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, cloudprovider.NewConfigurationError(NodeTemplateNotFoundReason, fmt.Errorf("AWSNodeTemplate %s does not exist", constraints.ProviderRef.Name))
+		}
+		return nil, fmt.Errorf("getting AWSNodeTemplate %s, %w", constraints.ProviderRef.Name, err)
+	}
+	return &v1alpha1.Constraints{Constraints: constraints, AWS: &nodeTemplate.Spec.AWS}, nil
+}
+
+// providersFor returns the providers of the account and region that the
+// provisioner's instances are launched in.
+func (c *CloudProvider) providersFor(key target) *providers {
+	if c.targets == nil || key == (target{}) {
+		return c.providers
+	}
+	return c.targets.get(key)
 }
 
 // get the current region from EC2 IMDS
@@ -97,6 +162,26 @@ func getRegionFromIMDS(sess *session.Session) string {
 	return region
 }
 
+// endpointResolver resolves the endpoints of services that are overridden to
+// their URLs, e.g. VPC endpoints in clusters without internet access, and the
+// endpoints of other services to the defaults of the region's partition. The
+// SDK's signing region of an overridden service is kept if it knows the
+// region, since global services like IAM are signed in a single region.
+func endpointResolver(overrides map[string]string) endpoints.Resolver {
+	return endpoints.ResolverFunc(func(service, region string, opts ...func(*endpoints.Options)) (endpoints.ResolvedEndpoint, error) {
+		resolved, err := endpoints.DefaultResolver().EndpointFor(service, region, opts...)
+		url, ok := overrides[service]
+		if !ok {
+			return resolved, err
+		}
+		if err != nil {
+			return endpoints.ResolvedEndpoint{URL: url, SigningRegion: region}, nil
+		}
+		resolved.URL = url
+		return resolved, nil
+	})
+}
+
 // withUserAgent adds a karpenter specific user-agent string to AWS session
 func withUserAgent(sess *session.Session) *session.Session {
 	userAgent := fmt.Sprintf("karpenter.sh-%s", project.Version)
@@ -106,47 +191,206 @@ func withUserAgent(sess *session.Session) *session.Session {
 
 // Create a node given the constraints.
 func (c *CloudProvider) Create(ctx context.Context, constraints *v1alpha5.Constraints, instanceTypes []cloudprovider.InstanceType, quantity int, callback func(*v1.Node) error) error {
-	vendorConstraints, err := v1alpha1.Deserialize(constraints)
+	vendorConstraints, err := c.deserialize(ctx, constraints)
 	if err != nil {
 		return err
 	}
+	key := targetFor(vendorConstraints.AWS)
+	hash, err := specHash(vendorConstraints)
+	if err != nil {
+		return fmt.Errorf("hashing provisioner spec, %w", err)
+	}
 	// Create will only return an error if zero nodes could be launched.
 	// Partial fulfillment will be logged
-	nodes, err := c.instanceProvider.Create(ctx, vendorConstraints, instanceTypes, quantity)
+	nodes, err := c.providersFor(key).instanceProvider.Create(ctx, vendorConstraints, instanceTypes, quantity)
 	if err != nil {
 		return fmt.Errorf("launching instances, %w", err)
 	}
 	var errs error
 	for _, node := range nodes {
+		node.Annotations = functional.UnionStringMaps(node.Annotations, key.annotations(), map[string]string{v1alpha1.SpecHashAnnotationKey: hash})
 		errs = multierr.Append(errs, callback(node))
 	}
 	return errs
 }
 
-// GetInstanceTypes returns all available InstanceTypes despite accepting a Constraints struct (note that it does not utilize Requirements)
-func (c *CloudProvider) GetInstanceTypes(ctx context.Context, constraints *v1alpha5.Constraints) ([]cloudprovider.InstanceType, error) {
-	vendorConstraints, err := v1alpha1.Deserialize(constraints)
+// GetInstanceTypes returns the instance types that are available to the
+// provisioner's subnets, with the offerings of each instance type and their
+// prices. The provisioner's requirements are not applied, but provisioners
+// that don't constrain instance types are limited to the default instance
+// families of the settings.
+func (c *CloudProvider) GetInstanceTypes(ctx context.Context, provisioner *v1alpha5.Provisioner) ([]cloudprovider.InstanceType, error) {
+	vendorConstraints, err := c.deserialize(ctx, &provisioner.Spec.Constraints)
 	if err != nil {
 		return nil, apis.ErrGeneric(err.Error())
 	}
-	return c.instanceTypeProvider.Get(ctx, vendorConstraints.AWS)
+	instanceTypes, err := c.providersFor(targetFor(vendorConstraints.AWS)).instanceTypeProvider.Get(ctx, vendorConstraints.AWS)
+	if err != nil {
+		return nil, err
+	}
+	return withDefaultInstanceFamilies(ctx, provisioner.Spec.Requirements, instanceTypes), nil
 }
 
+// Delete terminates the node's instance, unless the node was deleted for being
+// empty and annotated as reusable, and its instance is stopped or hibernated
+// instead. The instance is found in the account and region that the node is
+// annotated with.
 func (c *CloudProvider) Delete(ctx context.Context, node *v1.Node) error {
-	return c.instanceProvider.Terminate(ctx, node)
+	instanceProvider := c.providersFor(targetForNode(node)).instanceProvider
+	if policy, ok := node.Annotations[v1alpha1.EmptyInstancePolicyAnnotationKey]; ok {
+		if node.Annotations[v1alpha5.ReusableAnnotationKey] == "true" {
+			return instanceProvider.Stop(ctx, node, policy == v1alpha1.EmptyInstancePolicyHibernate)
+		}
+	}
+	return instanceProvider.Terminate(ctx, node)
 }
 
-// Validate the provisioner
+// IsDrifted returns the reason that the node's instance no longer matches what
+// the provisioner launches, e.g. because a new AMI was released, or an empty
+// string if it hasn't drifted.
+func (c *CloudProvider) IsDrifted(ctx context.Context, node *v1.Node, provisioner *v1alpha5.Provisioner) (string, error) {
+	vendorConstraints, err := c.deserialize(ctx, &provisioner.Spec.Constraints)
+	if err != nil {
+		return "", err
+	}
+	return c.providersFor(targetForNode(node)).instanceProvider.Drifted(ctx, node, vendorConstraints)
+}
+
+// Validate the provisioner. Bottlerocket settings in the user data must parse,
+// since they are merged with the settings that Karpenter generates. Provisioners
+// with an instance profile that IAM reports doesn't exist are rejected, but
+// other errors from IAM are left to be reported when the provisioner is
+// verified, so that admission doesn't depend on IAM's availability.
 func (c *CloudProvider) Validate(ctx context.Context, constraints *v1alpha5.Constraints) *apis.FieldError {
+	// Referenced AWSNodeTemplates are validated on their own, and may be
+	// created after the provisioner
+	if constraints.ProviderRef != nil {
+		return v1alpha1.ValidateProviderRef(constraints.ProviderRef).ViaField("providerRef")
+	}
 	vendorConstraints, err := v1alpha1.Deserialize(constraints)
 	if err != nil {
-		return apis.ErrGeneric(err.Error())
+		return apis.ErrGeneric(err.Error(), "provider")
+	}
+	if errs := vendorConstraints.AWS.Validate(ctx); errs != nil {
+		return errs
+	}
+	if aws.StringValue(vendorConstraints.AMIFamily) == v1alpha1.AMIFamilyBottlerocket && vendorConstraints.UserData != nil {
+		if _, err := parseBottlerocketSettings(*vendorConstraints.UserData); err != nil {
+			return apis.ErrGeneric(fmt.Sprintf("parsing bottlerocket settings, %s", err), "userData").ViaField("provider")
+		}
+	}
+	if vendorConstraints.InstanceProfile != "" {
+		if _, err := c.providersFor(targetFor(vendorConstraints.AWS)).instanceProfileProvider.Get(ctx, vendorConstraints.InstanceProfile); isNotFound(err) {
+			errs := apis.ErrInvalidValue(vendorConstraints.InstanceProfile, "instanceProfile")
+			errs.Details = "instance profile does not exist"
+			return errs.ViaField("provider")
+		}
+	}
+	return nil
+}
+
+// Verify that the resources referenced by the provisioner exist
+func (c *CloudProvider) Verify(ctx context.Context, constraints *v1alpha5.Constraints) (errs error) {
+	vendorConstraints, err := c.deserialize(ctx, constraints)
+	if err != nil {
+		return err
+	}
+	p := c.providersFor(targetFor(vendorConstraints.AWS))
+	subnets, err := p.subnetProvider.Get(ctx, vendorConstraints.AWS)
+	if err != nil {
+		errs = multierr.Append(errs, fmt.Errorf("getting subnets, %w", err))
+	}
+	// Security groups, instance profiles, and amis are configured by the user's
+	// launch template, so only the launch template itself is verified.
+	if vendorConstraints.LaunchTemplate != nil {
+		if _, err := p.instanceProvider.launchTemplateProvider.Describe(ctx, aws.StringValue(vendorConstraints.LaunchTemplate)); err != nil {
+			errs = multierr.Append(errs, err)
+		}
+		return errs
+	}
+	if err := p.securityGroupProvider.Verify(ctx, vendorConstraints, subnets); err != nil {
+		errs = multierr.Append(errs, fmt.Errorf("getting security groups, %w", err))
+	}
+	if err := p.instanceProfileProvider.Verify(ctx, instanceProfile(ctx, vendorConstraints)); err != nil {
+		errs = multierr.Append(errs, err)
+	}
+	instanceTypes, err := p.instanceTypeProvider.Get(ctx, vendorConstraints.AWS)
+	if err != nil {
+		return multierr.Append(errs, fmt.Errorf("getting instance types, %w", err))
+	}
+	if _, err := p.amiProvider.Get(ctx, vendorConstraints, instanceTypes); err != nil {
+		errs = multierr.Append(errs, fmt.Errorf("getting amis, %w", err))
+	}
+	return errs
+}
+
+// targetProviders returns the providers of every account and region that
+// instances are launched in: Karpenter's own, those used since Karpenter
+// started, those that provisioners launch instances in, and those of the
+// instances of nodes, so that instances launched before a restart are covered.
+func (c *CloudProvider) targetProviders(kubeClient kubeclient.Client) func(context.Context) ([]*providers, error) {
+	return func(ctx context.Context) ([]*providers, error) {
+		keys := []target{{}}
+		if c.targets != nil {
+			keys = append(keys, c.targets.keys()...)
+			provisioners := &v1alpha5.ProvisionerList{}
+			if err := kubeClient.List(ctx, provisioners); err != nil {
+				return nil, fmt.Errorf("listing provisioners, %w", err)
+			}
+			for i := range provisioners.Items {
+				// Provisioners that can't be deserialized don't launch instances
+				if vendorConstraints, err := c.deserialize(ctx, &provisioners.Items[i].Spec.Constraints); err == nil {
+					keys = append(keys, targetFor(vendorConstraints.AWS))
+				}
+			}
+			nodes := &v1.NodeList{}
+			if err := kubeClient.List(ctx, nodes); err != nil {
+				return nil, fmt.Errorf("listing nodes, %w", err)
+			}
+			for i := range nodes.Items {
+				keys = append(keys, targetForNode(&nodes.Items[i]))
+			}
+		}
+		seen := map[*providers]bool{}
+		result := []*providers{}
+		for _, key := range keys {
+			if p := c.providersFor(key); !seen[p] {
+				seen[p] = true
+				result = append(result, p)
+			}
+		}
+		return result, nil
+	}
+}
+
+// Controllers returns the AWS specific controllers. Interruptions are only
+// handled while a queue is configured, by the options or by the settings.
+// Instance types are refreshed, and instances garbage collected, in every
+// account and region that instances are launched in.
+func (c *CloudProvider) Controllers(ctx context.Context, kubeClient kubeclient.Client) []controllers.Controller {
+	return []controllers.Controller{
+		NewRefreshController(c.targetProviders(kubeClient)),
+		NewGarbageCollectionController(kubeClient, c.targetProviders(kubeClient)),
+		NewNodeTemplateController(kubeClient, c.providersFor),
+		NewInterruptionController(kubeClient, c.sqsapi, c.instanceTypeProvider, injection.GetOptions(ctx).AWSInterruptionQueueName),
+	}
+}
+
+// Resources returns AWSNodeTemplates, which provisioners reference with
+// their providerRef
+func (c *CloudProvider) Resources() map[schema.GroupVersionKind]resourcesemantics.GenericCRD {
+	return map[schema.GroupVersionKind]resourcesemantics.GenericCRD{
+		v1alpha1.SchemeGroupVersion.WithKind("AWSNodeTemplate"): &v1alpha1.AWSNodeTemplate{},
 	}
-	return vendorConstraints.AWS.Validate()
 }
 
 // Default the provisioner
 func (c *CloudProvider) Default(ctx context.Context, constraints *v1alpha5.Constraints) {
+	// Referenced AWSNodeTemplates are defaulted on their own
+	if constraints.ProviderRef != nil {
+		(&v1alpha1.Constraints{Constraints: constraints}).Default(ctx)
+		return
+	}
 	vendorConstraints, err := v1alpha1.Deserialize(constraints)
 	if err != nil {
 		logging.FromContext(ctx).Errorf("Failed to deserialize provider, %s", err.Error())
@@ -158,6 +402,17 @@ func (c *CloudProvider) Default(ctx context.Context, constraints *v1alpha5.Const
 	}
 }
 
+// Capabilities of EC2, which computes max pods from the network interfaces of
+// each instance type
+func (c *CloudProvider) Capabilities() cloudprovider.Capabilities {
+	return cloudprovider.Capabilities{
+		CapacityTypes: sets.NewString(v1alpha1.CapacityTypeOnDemand, v1alpha1.CapacityTypeSpot),
+		Architectures: sets.NewString(v1alpha5.ArchitectureAmd64, v1alpha5.ArchitectureArm64),
+		Accelerators:  true,
+		MaxPodsSource: cloudprovider.MaxPodsSourceInstanceType,
+	}
+}
+
 // Name returns the CloudProvider implementation name.
 func (c *CloudProvider) Name() string {
 	return "aws"
@@ -0,0 +1,149 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/cloudprovider/aws/apis/v1alpha1"
+	"github.com/mitchellh/hashstructure/v2"
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// EC2 tags instances that were launched from a launch template with its ID
+	// and version
+	launchTemplateIDTagKey      = "aws:ec2launchtemplate:id"
+	launchTemplateVersionTagKey = "aws:ec2launchtemplate:version"
+)
+
+// specHash fingerprints the provider that the instances are launched with,
+// whether it's inline or resolved from the provisioner's AWSNodeTemplate. The
+// rest of the constraints are hashed by the provisioner. The provider is hashed
+// as JSON, since hashstructure ignores the unexported fields of quantities.
+func specHash(constraints *v1alpha1.Constraints) (string, error) {
+	spec, err := json.Marshal(constraints.AWS)
+	if err != nil {
+		return "", err
+	}
+	hash, err := hashstructure.Hash(string(spec), hashstructure.FormatV2, nil)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprint(hash), nil
+}
+
+// Drifted returns the reason that the node's instance no longer matches what
+// would be launched for the constraints, or an empty string if it doesn't.
+// The spec hash is compared first, since it doesn't call EC2, then the
+// instance's AMI, or the version of its launch template if it was launched
+// from the user's launch template. Nodes that were launched before their spec
+// hash was recorded only drift if their AMI or launch template does.
+func (p *InstanceProvider) Drifted(ctx context.Context, node *v1.Node, constraints *v1alpha1.Constraints) (string, error) {
+	if hash, ok := node.Annotations[v1alpha1.SpecHashAnnotationKey]; ok {
+		current, err := specHash(constraints)
+		if err != nil {
+			return "", fmt.Errorf("hashing provisioner spec, %w", err)
+		}
+		if hash != current {
+			return "provisioner spec changed since the node was launched", nil
+		}
+	}
+	id, err := getInstanceID(node)
+	if err != nil {
+		return "", err
+	}
+	instances, err := p.getInstances(ctx, []*string{id})
+	if err != nil {
+		return "", fmt.Errorf("getting instance, %w", err)
+	}
+	if constraints.LaunchTemplate != nil {
+		return p.launchTemplateDrifted(ctx, instances[0], constraints)
+	}
+	return p.amiDrifted(ctx, instances[0], constraints)
+}
+
+// amiDrifted compares the instance's AMI with the AMI that its instance type
+// would be launched with, which changes when a new AMI is released for the
+// AMI family or selected by the AMI selector.
+func (p *InstanceProvider) amiDrifted(ctx context.Context, instance *ec2.Instance, constraints *v1alpha1.Constraints) (string, error) {
+	if instance.ImageId == nil {
+		return "", nil
+	}
+	instanceTypes, err := p.instanceTypeProvider.Get(ctx, constraints.AWS)
+	if err != nil {
+		return "", fmt.Errorf("getting instance types, %w", err)
+	}
+	var instanceType cloudprovider.InstanceType
+	for _, it := range instanceTypes {
+		if it.Name() == aws.StringValue(instance.InstanceType) {
+			instanceType = it
+		}
+	}
+	// The instance type may no longer be offered, e.g. in the provisioner's
+	// subnets, which doesn't make its AMI any less current
+	if instanceType == nil {
+		return "", nil
+	}
+	amis, err := p.launchTemplateProvider.amiProvider.Get(ctx, constraints, []cloudprovider.InstanceType{instanceType})
+	if err != nil {
+		return "", fmt.Errorf("getting amis, %w", err)
+	}
+	if _, ok := amis[aws.StringValue(instance.ImageId)]; ok {
+		return "", nil
+	}
+	// A single instance type has a single AMI
+	for ami := range amis {
+		return fmt.Sprintf("instance has ami %s, but the provisioner launches %s", aws.StringValue(instance.ImageId), ami), nil
+	}
+	return "", nil
+}
+
+// launchTemplateDrifted compares the version of the user's launch template
+// that the instance was launched from with the version that the provisioner
+// launches, which is the default version unless one is specified.
+func (p *InstanceProvider) launchTemplateDrifted(ctx context.Context, instance *ec2.Instance, constraints *v1alpha1.Constraints) (string, error) {
+	version, ok := getTag(instance, launchTemplateVersionTagKey)
+	if !ok {
+		return "", nil
+	}
+	launchTemplate, err := p.launchTemplateProvider.Describe(ctx, aws.StringValue(constraints.LaunchTemplate))
+	if err != nil {
+		return "", err
+	}
+	if id, ok := getTag(instance, launchTemplateIDTagKey); ok && id != aws.StringValue(launchTemplate.LaunchTemplateId) {
+		return fmt.Sprintf("instance was launched from launch template %s, but the provisioner launches %s", id, aws.StringValue(launchTemplate.LaunchTemplateId)), nil
+	}
+	expected := aws.Int64Value(launchTemplate.DefaultVersionNumber)
+	switch v := aws.StringValue(constraints.LaunchTemplateVersion); v {
+	case "", "$Default":
+	case "$Latest":
+		expected = aws.Int64Value(launchTemplate.LatestVersionNumber)
+	default:
+		if expected, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return "", fmt.Errorf("parsing launch template version %s, %w", v, err)
+		}
+	}
+	if version != fmt.Sprint(expected) {
+		return fmt.Sprintf("instance was launched from version %s of launch template %s, but the provisioner launches version %d", version, aws.StringValue(constraints.LaunchTemplate), expected), nil
+	}
+	return "", nil
+}
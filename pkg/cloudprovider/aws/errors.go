@@ -16,6 +16,8 @@ import (
 	"errors"
 
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/iam"
 	"github.com/aws/karpenter/pkg/utils/functional"
 )
 
@@ -24,13 +26,22 @@ var (
 	notFoundErrorCodes = []string{
 		"InvalidInstanceID.NotFound",
 		"InvalidLaunchTemplateName.NotFoundException",
+		iam.ErrCodeNoSuchEntityException,
 	}
 )
 
+// AccessDeniedErrorCode indicates that the controller is not permitted to call
+// the API
+const AccessDeniedErrorCode = "AccessDenied"
+
 // InsufficientCapacityErrorCode indicates that EC2 is temporarily lacking capacity for this
 // instance type and availability zone combination
 const InsufficientCapacityErrorCode = "InsufficientInstanceCapacity"
 
+// InsufficientFreeAddressesErrorCode indicates that the subnet does not have
+// enough available IP addresses to launch the instance
+const InsufficientFreeAddressesErrorCode = "InsufficientFreeAddressesInSubnet"
+
 // isNotFound returns true if the err is an AWS error (even if it's
 // wrapped) and is a known to mean "not found" (as opposed to a more
 // serious or unexpected error)
@@ -41,3 +52,24 @@ func isNotFound(err error) bool {
 	}
 	return false
 }
+
+// isAccessDenied returns true if the err is an AWS error (even if it's
+// wrapped) that means the controller isn't permitted to make the request
+func isAccessDenied(err error) bool {
+	var awsError awserr.Error
+	if errors.As(err, &awsError) {
+		return awsError.Code() == AccessDeniedErrorCode
+	}
+	return false
+}
+
+// isUnreachable returns true if the err is an AWS error (even if it's wrapped)
+// that means the request couldn't be sent, e.g. because the API has no
+// endpoint that is reachable from a cluster without internet access
+func isUnreachable(err error) bool {
+	var awsError awserr.Error
+	if errors.As(err, &awsError) {
+		return awsError.Code() == request.ErrCodeRequestError
+	}
+	return false
+}
@@ -18,6 +18,7 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/Pallinder/go-randomdata"
 	"github.com/aws/aws-sdk-go/aws"
@@ -40,18 +41,29 @@ type CapacityPool struct {
 // EC2Behavior must be reset between tests otherwise tests will
 // pollute each other.
 type EC2Behavior struct {
-	DescribeInstancesOutput             *ec2.DescribeInstancesOutput
-	DescribeLaunchTemplatesOutput       *ec2.DescribeLaunchTemplatesOutput
-	DescribeSubnetsOutput               *ec2.DescribeSubnetsOutput
-	DescribeSecurityGroupsOutput        *ec2.DescribeSecurityGroupsOutput
-	DescribeInstanceTypesOutput         *ec2.DescribeInstanceTypesOutput
-	DescribeInstanceTypeOfferingsOutput *ec2.DescribeInstanceTypeOfferingsOutput
-	DescribeAvailabilityZonesOutput     *ec2.DescribeAvailabilityZonesOutput
-	CalledWithCreateFleetInput          set.Set
-	CalledWithCreateLaunchTemplateInput set.Set
-	Instances                           sync.Map
-	LaunchTemplates                     sync.Map
-	InsufficientCapacityPools           []CapacityPool
+	DescribeImagesOutput                         *ec2.DescribeImagesOutput
+	DescribeInstancesOutput                      *ec2.DescribeInstancesOutput
+	DescribeLaunchTemplatesOutput                *ec2.DescribeLaunchTemplatesOutput
+	DescribeSubnetsOutput                        *ec2.DescribeSubnetsOutput
+	DescribeSecurityGroupsOutput                 *ec2.DescribeSecurityGroupsOutput
+	DescribeInstanceTypesOutput                  *ec2.DescribeInstanceTypesOutput
+	DescribeInstanceTypeOfferingsOutput          *ec2.DescribeInstanceTypeOfferingsOutput
+	LocalZoneInstanceTypeOfferings               []*ec2.InstanceTypeOffering
+	DescribeAvailabilityZonesOutput              *ec2.DescribeAvailabilityZonesOutput
+	DescribeSpotPriceHistoryOutput               *ec2.DescribeSpotPriceHistoryOutput
+	DescribePlacementGroupsOutput                *ec2.DescribePlacementGroupsOutput
+	CalledWithCreateFleetInput                   set.Set
+	CalledWithCreateLaunchTemplateInput          set.Set
+	CalledWithDescribeImagesInput                set.Set
+	CalledWithDescribeSecurityGroupsInput        set.Set
+	CalledWithDescribeSubnetsInput               set.Set
+	CalledWithDescribeInstanceTypeOfferingsInput set.Set
+	CalledWithStopInstancesInput                 set.Set
+	Instances                                    sync.Map
+	LaunchTemplates                              sync.Map
+	Fleets                                       sync.Map
+	InsufficientCapacityPools                    []CapacityPool
+	InsufficientFreeAddressesSubnets             []string
 }
 
 type EC2API struct {
@@ -66,11 +78,18 @@ var DefaultSupportedUsageClasses = aws.StringSlice([]string{"on-demand", "spot"}
 // each other.
 func (e *EC2API) Reset() {
 	e.EC2Behavior = EC2Behavior{
-		CalledWithCreateFleetInput:          set.NewSet(),
-		CalledWithCreateLaunchTemplateInput: set.NewSet(),
-		Instances:                           sync.Map{},
-		LaunchTemplates:                     sync.Map{},
-		InsufficientCapacityPools:           []CapacityPool{},
+		CalledWithCreateFleetInput:                   set.NewSet(),
+		CalledWithCreateLaunchTemplateInput:          set.NewSet(),
+		CalledWithDescribeImagesInput:                set.NewSet(),
+		CalledWithDescribeSecurityGroupsInput:        set.NewSet(),
+		CalledWithDescribeSubnetsInput:               set.NewSet(),
+		CalledWithDescribeInstanceTypeOfferingsInput: set.NewSet(),
+		CalledWithStopInstancesInput:                 set.NewSet(),
+		Instances:                                    sync.Map{},
+		LaunchTemplates:                              sync.Map{},
+		Fleets:                                       sync.Map{},
+		InsufficientCapacityPools:                    []CapacityPool{},
+		InsufficientFreeAddressesSubnets:             []string{},
 	}
 }
 
@@ -79,9 +98,16 @@ func (e *EC2API) CreateFleetWithContext(_ context.Context, input *ec2.CreateFlee
 	if input.LaunchTemplateConfigs[0].LaunchTemplateSpecification.LaunchTemplateName == nil {
 		return nil, fmt.Errorf("missing launch template name")
 	}
+	// Requests with the same client token return the result of the original request
+	if input.ClientToken != nil {
+		if result, ok := e.Fleets.Load(aws.StringValue(input.ClientToken)); ok {
+			return result.(*ec2.CreateFleetOutput), nil
+		}
+	}
 	instances := []*ec2.Instance{}
 	instanceIds := []*string{}
 	skippedPools := []CapacityPool{}
+	var fullSubnet *string
 	var spotInstanceRequestID *string
 
 	if aws.StringValue(input.TargetCapacitySpecification.DefaultTargetCapacityType) == v1alpha1.CapacityTypeSpot {
@@ -102,19 +128,42 @@ func (e *EC2API) CreateFleetWithContext(_ context.Context, input *ec2.CreateFlee
 		if skipInstance {
 			continue
 		}
+		if functional.ContainsString(e.InsufficientFreeAddressesSubnets, aws.StringValue(input.LaunchTemplateConfigs[0].Overrides[0].SubnetId)) {
+			fullSubnet = input.LaunchTemplateConfigs[0].Overrides[0].SubnetId
+			continue
+		}
 		instances = append(instances, &ec2.Instance{
 			InstanceId:            aws.String(randomdata.SillyName()),
 			Placement:             &ec2.Placement{AvailabilityZone: input.LaunchTemplateConfigs[0].Overrides[0].AvailabilityZone},
 			PrivateDnsName:        aws.String(randomdata.IpV4Address()),
 			InstanceType:          input.LaunchTemplateConfigs[0].Overrides[0].InstanceType,
 			SpotInstanceRequestId: spotInstanceRequestID,
+			LaunchTime:            aws.Time(time.Now()),
 		})
-		e.Instances.Store(*instances[i].InstanceId, instances[i])
-		instanceIds = append(instanceIds, instances[i].InstanceId)
+		instance := instances[len(instances)-1]
+		e.Instances.Store(*instance.InstanceId, instance)
+		instanceIds = append(instanceIds, instance.InstanceId)
 	}
 
 	result := &ec2.CreateFleetOutput{
-		Instances: []*ec2.CreateFleetInstance{{InstanceIds: instanceIds}}}
+		Instances: []*ec2.CreateFleetInstance{{
+			InstanceIds: instanceIds,
+			LaunchTemplateAndOverrides: &ec2.LaunchTemplateAndOverridesResponse{
+				Overrides: &ec2.FleetLaunchTemplateOverrides{
+					InstanceType:     input.LaunchTemplateConfigs[0].Overrides[0].InstanceType,
+					AvailabilityZone: input.LaunchTemplateConfigs[0].Overrides[0].AvailabilityZone,
+					SubnetId:         input.LaunchTemplateConfigs[0].Overrides[0].SubnetId,
+				},
+			},
+		}}}
+	if fullSubnet != nil {
+		result.Errors = append(result.Errors, &ec2.CreateFleetError{
+			ErrorCode: aws.String("InsufficientFreeAddressesInSubnet"),
+			LaunchTemplateAndOverrides: &ec2.LaunchTemplateAndOverridesResponse{
+				Overrides: &ec2.FleetLaunchTemplateOverrides{SubnetId: fullSubnet},
+			},
+		})
+	}
 	if len(skippedPools) > 0 {
 		for _, pool := range skippedPools {
 			result.Errors = append(result.Errors, &ec2.CreateFleetError{
@@ -132,9 +181,86 @@ func (e *EC2API) CreateFleetWithContext(_ context.Context, input *ec2.CreateFlee
 			})
 		}
 	}
+	if input.ClientToken != nil {
+		e.Fleets.Store(aws.StringValue(input.ClientToken), result)
+	}
 	return result, nil
 }
 
+func (e *EC2API) TerminateInstancesWithContext(_ context.Context, input *ec2.TerminateInstancesInput, _ ...request.Option) (*ec2.TerminateInstancesOutput, error) {
+	output := &ec2.TerminateInstancesOutput{}
+	for _, instanceID := range input.InstanceIds {
+		if _, ok := e.Instances.LoadAndDelete(aws.StringValue(instanceID)); !ok {
+			return nil, awserr.New("InvalidInstanceID.NotFound", fmt.Sprintf("instance %s does not exist", aws.StringValue(instanceID)), nil)
+		}
+		output.TerminatingInstances = append(output.TerminatingInstances, &ec2.InstanceStateChange{InstanceId: instanceID})
+	}
+	return output, nil
+}
+
+func (e *EC2API) StopInstancesWithContext(_ context.Context, input *ec2.StopInstancesInput, _ ...request.Option) (*ec2.StopInstancesOutput, error) {
+	e.CalledWithStopInstancesInput.Add(input)
+	output := &ec2.StopInstancesOutput{}
+	for _, instanceID := range input.InstanceIds {
+		instance, ok := e.Instances.Load(aws.StringValue(instanceID))
+		if !ok {
+			return nil, awserr.New("InvalidInstanceID.NotFound", fmt.Sprintf("instance %s does not exist", aws.StringValue(instanceID)), nil)
+		}
+		instance.(*ec2.Instance).State = &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameStopped)}
+		output.StoppingInstances = append(output.StoppingInstances, &ec2.InstanceStateChange{InstanceId: instanceID})
+	}
+	return output, nil
+}
+
+func (e *EC2API) StartInstancesWithContext(_ context.Context, input *ec2.StartInstancesInput, _ ...request.Option) (*ec2.StartInstancesOutput, error) {
+	output := &ec2.StartInstancesOutput{}
+	for _, instanceID := range input.InstanceIds {
+		instance, ok := e.Instances.Load(aws.StringValue(instanceID))
+		if !ok {
+			return nil, awserr.New("InvalidInstanceID.NotFound", fmt.Sprintf("instance %s does not exist", aws.StringValue(instanceID)), nil)
+		}
+		instance.(*ec2.Instance).State = &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)}
+		output.StartingInstances = append(output.StartingInstances, &ec2.InstanceStateChange{InstanceId: instanceID})
+	}
+	return output, nil
+}
+
+func (e *EC2API) CreateTagsWithContext(_ context.Context, input *ec2.CreateTagsInput, _ ...request.Option) (*ec2.CreateTagsOutput, error) {
+	for _, resource := range input.Resources {
+		instance, ok := e.Instances.Load(aws.StringValue(resource))
+		if !ok {
+			return nil, awserr.New("InvalidInstanceID.NotFound", fmt.Sprintf("instance %s does not exist", aws.StringValue(resource)), nil)
+		}
+		for _, tag := range input.Tags {
+			instance.(*ec2.Instance).Tags = append(withoutTag(instance.(*ec2.Instance).Tags, aws.StringValue(tag.Key)), tag)
+		}
+	}
+	return &ec2.CreateTagsOutput{}, nil
+}
+
+func (e *EC2API) DeleteTagsWithContext(_ context.Context, input *ec2.DeleteTagsInput, _ ...request.Option) (*ec2.DeleteTagsOutput, error) {
+	for _, resource := range input.Resources {
+		instance, ok := e.Instances.Load(aws.StringValue(resource))
+		if !ok {
+			return nil, awserr.New("InvalidInstanceID.NotFound", fmt.Sprintf("instance %s does not exist", aws.StringValue(resource)), nil)
+		}
+		for _, tag := range input.Tags {
+			instance.(*ec2.Instance).Tags = withoutTag(instance.(*ec2.Instance).Tags, aws.StringValue(tag.Key))
+		}
+	}
+	return &ec2.DeleteTagsOutput{}, nil
+}
+
+func withoutTag(tags []*ec2.Tag, key string) []*ec2.Tag {
+	result := []*ec2.Tag{}
+	for _, tag := range tags {
+		if aws.StringValue(tag.Key) != key {
+			result = append(result, tag)
+		}
+	}
+	return result
+}
+
 func (e *EC2API) CreateLaunchTemplateWithContext(_ context.Context, input *ec2.CreateLaunchTemplateInput, _ ...request.Option) (*ec2.CreateLaunchTemplateOutput, error) {
 	e.CalledWithCreateLaunchTemplateInput.Add(input)
 	launchTemplate := &ec2.LaunchTemplate{LaunchTemplateName: input.LaunchTemplateName}
@@ -151,12 +277,39 @@ func (e *EC2API) DescribeInstancesWithContext(_ context.Context, input *ec2.Desc
 		instance, _ := e.Instances.Load(*instanceID)
 		instances = append(instances, instance.(*ec2.Instance))
 	}
+	// Instances are only filtered by placement group
+	for _, filter := range input.Filters {
+		if aws.StringValue(filter.Name) != "placement-group-name" {
+			continue
+		}
+		e.Instances.Range(func(_, value interface{}) bool {
+			instance := value.(*ec2.Instance)
+			if instance.Placement != nil && functional.ContainsString(aws.StringValueSlice(filter.Values), aws.StringValue(instance.Placement.GroupName)) {
+				instances = append(instances, instance)
+			}
+			return true
+		})
+	}
 
 	return &ec2.DescribeInstancesOutput{
 		Reservations: []*ec2.Reservation{{Instances: instances}},
 	}, nil
 }
 
+func (e *EC2API) DescribeInstancesPagesWithContext(_ context.Context, _ *ec2.DescribeInstancesInput, fn func(*ec2.DescribeInstancesOutput, bool) bool, _ ...request.Option) error {
+	if e.DescribeInstancesOutput != nil {
+		fn(e.DescribeInstancesOutput, false)
+		return nil
+	}
+	instances := []*ec2.Instance{}
+	e.Instances.Range(func(_, instance interface{}) bool {
+		instances = append(instances, instance.(*ec2.Instance))
+		return true
+	})
+	fn(&ec2.DescribeInstancesOutput{Reservations: []*ec2.Reservation{{Instances: instances}}}, false)
+	return nil
+}
+
 func (e *EC2API) DescribeLaunchTemplatesWithContext(_ context.Context, input *ec2.DescribeLaunchTemplatesInput, _ ...request.Option) (*ec2.DescribeLaunchTemplatesOutput, error) {
 	if e.DescribeLaunchTemplatesOutput != nil {
 		return e.DescribeLaunchTemplatesOutput, nil
@@ -175,31 +328,54 @@ func (e *EC2API) DescribeLaunchTemplatesWithContext(_ context.Context, input *ec
 	return output, nil
 }
 
-func (e *EC2API) DescribeSubnetsWithContext(context.Context, *ec2.DescribeSubnetsInput, ...request.Option) (*ec2.DescribeSubnetsOutput, error) {
+func (e *EC2API) DescribeImagesWithContext(_ context.Context, input *ec2.DescribeImagesInput, _ ...request.Option) (*ec2.DescribeImagesOutput, error) {
+	e.CalledWithDescribeImagesInput.Add(input)
+	if e.DescribeImagesOutput != nil {
+		return e.DescribeImagesOutput, nil
+	}
+	return &ec2.DescribeImagesOutput{Images: []*ec2.Image{
+		{ImageId: aws.String("test-ami-amd64"), Architecture: aws.String("x86_64"), CreationDate: aws.String("2021-08-31T00:00:00.000Z")},
+		{ImageId: aws.String("test-ami-arm64"), Architecture: aws.String("arm64"), CreationDate: aws.String("2021-08-31T00:00:00.000Z")},
+	}}, nil
+}
+
+func (e *EC2API) DescribeSubnetsWithContext(_ context.Context, input *ec2.DescribeSubnetsInput, _ ...request.Option) (*ec2.DescribeSubnetsOutput, error) {
+	e.CalledWithDescribeSubnetsInput.Add(input)
 	if e.DescribeSubnetsOutput != nil {
 		return e.DescribeSubnetsOutput, nil
 	}
 	return &ec2.DescribeSubnetsOutput{Subnets: []*ec2.Subnet{
-		{SubnetId: aws.String("test-subnet-1"), AvailabilityZone: aws.String("test-zone-1a"),
+		{SubnetId: aws.String("test-subnet-1"), VpcId: aws.String("test-vpc"), AvailableIpAddressCount: aws.Int64(100), AvailabilityZone: aws.String("test-zone-1a"),
 			Tags: []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("test-subnet-1")}}},
-		{SubnetId: aws.String("test-subnet-2"), AvailabilityZone: aws.String("test-zone-1b"),
+		{SubnetId: aws.String("test-subnet-2"), VpcId: aws.String("test-vpc"), AvailableIpAddressCount: aws.Int64(100), AvailabilityZone: aws.String("test-zone-1b"),
 			Tags: []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("test-subnet-2")}}},
-		{SubnetId: aws.String("test-subnet-3"), AvailabilityZone: aws.String("test-zone-1c"),
+		{SubnetId: aws.String("test-subnet-3"), VpcId: aws.String("test-vpc"), AvailableIpAddressCount: aws.Int64(100), AvailabilityZone: aws.String("test-zone-1c"),
 			Tags: []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("test-subnet-3")}, {Key: aws.String("TestTag")}}},
 	}}, nil
 }
 
-func (e *EC2API) DescribeSecurityGroupsWithContext(context.Context, *ec2.DescribeSecurityGroupsInput, ...request.Option) (*ec2.DescribeSecurityGroupsOutput, error) {
+func (e *EC2API) DescribeSecurityGroupsWithContext(_ context.Context, input *ec2.DescribeSecurityGroupsInput, _ ...request.Option) (*ec2.DescribeSecurityGroupsOutput, error) {
+	e.CalledWithDescribeSecurityGroupsInput.Add(input)
 	if e.DescribeSecurityGroupsOutput != nil {
 		return e.DescribeSecurityGroupsOutput, nil
 	}
 	return &ec2.DescribeSecurityGroupsOutput{SecurityGroups: []*ec2.SecurityGroup{
-		{GroupId: aws.String("test-security-group-1"), Tags: []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("test-security-group-1")}}},
-		{GroupId: aws.String("test-security-group-2"), Tags: []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("test-security-group-2")}}},
-		{GroupId: aws.String("test-security-group-3"), Tags: []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("test-security-group-3")}, {Key: aws.String("TestTag")}}},
+		{GroupId: aws.String("test-security-group-1"), GroupName: aws.String("test-security-group-1"), VpcId: aws.String("test-vpc"),
+			Tags: []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("test-security-group-1")}}},
+		{GroupId: aws.String("test-security-group-2"), GroupName: aws.String("test-security-group-2"), VpcId: aws.String("test-vpc"),
+			Tags: []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("test-security-group-2")}}},
+		{GroupId: aws.String("test-security-group-3"), GroupName: aws.String("test-security-group-3"), VpcId: aws.String("test-vpc"),
+			Tags: []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("test-security-group-3")}, {Key: aws.String("TestTag")}}},
 	}}, nil
 }
 
+func (e *EC2API) DescribePlacementGroupsWithContext(context.Context, *ec2.DescribePlacementGroupsInput, ...request.Option) (*ec2.DescribePlacementGroupsOutput, error) {
+	if e.DescribePlacementGroupsOutput != nil {
+		return e.DescribePlacementGroupsOutput, nil
+	}
+	return &ec2.DescribePlacementGroupsOutput{}, nil
+}
+
 func (e *EC2API) DescribeAvailabilityZonesWithContext(context.Context, *ec2.DescribeAvailabilityZonesInput, ...request.Option) (*ec2.DescribeAvailabilityZonesOutput, error) {
 	if e.DescribeAvailabilityZonesOutput != nil {
 		return e.DescribeAvailabilityZonesOutput, nil
@@ -246,6 +422,7 @@ func (e *EC2API) DescribeInstanceTypesPagesWithContext(_ context.Context, _ *ec2
 				BurstablePerformanceSupported: aws.Bool(false),
 				BareMetal:                     aws.Bool(false),
 				Hypervisor:                    aws.String("nitro"),
+				HibernationSupported:          aws.Bool(true),
 				ProcessorInfo: &ec2.ProcessorInfo{
 					SupportedArchitectures: aws.StringSlice([]string{"x86_64"}),
 				},
@@ -308,6 +485,37 @@ func (e *EC2API) DescribeInstanceTypesPagesWithContext(_ context.Context, _ *ec2
 					Ipv4AddressesPerInterface: aws.Int64(60),
 				},
 			},
+			{
+				InstanceType:                  aws.String("dl1.24xlarge"),
+				SupportedUsageClasses:         DefaultSupportedUsageClasses,
+				SupportedVirtualizationTypes:  []*string{aws.String("hvm")},
+				BurstablePerformanceSupported: aws.Bool(false),
+				BareMetal:                     aws.Bool(false),
+				Hypervisor:                    aws.String("nitro"),
+				ProcessorInfo: &ec2.ProcessorInfo{
+					SupportedArchitectures: aws.StringSlice([]string{"x86_64"}),
+				},
+				VCpuInfo: &ec2.VCpuInfo{
+					DefaultVCpus: aws.Int64(96),
+				},
+				MemoryInfo: &ec2.MemoryInfo{
+					SizeInMiB: aws.Int64(786432),
+				},
+				GpuInfo: &ec2.GpuInfo{
+					Gpus: []*ec2.GpuDeviceInfo{{
+						Manufacturer: aws.String("Habana"),
+						Count:        aws.Int64(8),
+					}},
+				},
+				NetworkInfo: &ec2.NetworkInfo{
+					MaximumNetworkInterfaces:  aws.Int64(60),
+					Ipv4AddressesPerInterface: aws.Int64(50),
+					EfaSupported:              aws.Bool(true),
+					EfaInfo: &ec2.EfaInfo{
+						MaximumEfaInterfaces: aws.Int64(4),
+					},
+				},
+			},
 			{
 				InstanceType:                  aws.String("c6g.large"),
 				SupportedUsageClasses:         DefaultSupportedUsageClasses,
@@ -386,7 +594,22 @@ func (e *EC2API) DescribeInstanceTypesPagesWithContext(_ context.Context, _ *ec2
 	return nil
 }
 
-func (e *EC2API) DescribeInstanceTypeOfferingsPagesWithContext(_ context.Context, _ *ec2.DescribeInstanceTypeOfferingsInput, fn func(*ec2.DescribeInstanceTypeOfferingsOutput, bool) bool, _ ...request.Option) error {
+func (e *EC2API) DescribeInstanceTypeOfferingsPagesWithContext(_ context.Context, input *ec2.DescribeInstanceTypeOfferingsInput, fn func(*ec2.DescribeInstanceTypeOfferingsOutput, bool) bool, _ ...request.Option) error {
+	// Offerings in Local Zones are only returned when they are requested by location
+	for _, filter := range input.Filters {
+		if aws.StringValue(filter.Name) != "location" {
+			continue
+		}
+		e.CalledWithDescribeInstanceTypeOfferingsInput.Add(input)
+		output := &ec2.DescribeInstanceTypeOfferingsOutput{}
+		for _, offering := range e.LocalZoneInstanceTypeOfferings {
+			if functional.ContainsString(aws.StringValueSlice(filter.Values), aws.StringValue(offering.Location)) {
+				output.InstanceTypeOfferings = append(output.InstanceTypeOfferings, offering)
+			}
+		}
+		fn(output, false)
+		return nil
+	}
 	if e.DescribeInstanceTypeOfferingsOutput != nil {
 		fn(e.DescribeInstanceTypeOfferingsOutput, false)
 		return nil
@@ -429,6 +652,18 @@ func (e *EC2API) DescribeInstanceTypeOfferingsPagesWithContext(_ context.Context
 				InstanceType: aws.String("p3.8xlarge"),
 				Location:     aws.String("test-zone-1b"),
 			},
+			{
+				InstanceType: aws.String("dl1.24xlarge"),
+				Location:     aws.String("test-zone-1a"),
+			},
+			{
+				InstanceType: aws.String("c6g.large"),
+				Location:     aws.String("test-zone-1a"),
+			},
+			{
+				InstanceType: aws.String("c6g.large"),
+				Location:     aws.String("test-zone-1b"),
+			},
 			{
 				InstanceType: aws.String("t3.large"),
 				Location:     aws.String("test-zone-1a"),
@@ -449,3 +684,12 @@ func (e *EC2API) DescribeInstanceTypeOfferingsPagesWithContext(_ context.Context
 	}, false)
 	return nil
 }
+
+func (e *EC2API) DescribeSpotPriceHistoryPagesWithContext(_ context.Context, _ *ec2.DescribeSpotPriceHistoryInput, fn func(*ec2.DescribeSpotPriceHistoryOutput, bool) bool, _ ...request.Option) error {
+	if e.DescribeSpotPriceHistoryOutput != nil {
+		fn(e.DescribeSpotPriceHistoryOutput, false)
+		return nil
+	}
+	fn(&ec2.DescribeSpotPriceHistoryOutput{}, false)
+	return nil
+}
@@ -0,0 +1,67 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fake
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+)
+
+type IAMAPI struct {
+	iamiface.IAMAPI
+	WantErr error
+	// AttachedRolePolicies are the policies attached to every role, which
+	// default to the policies that nodes need
+	AttachedRolePolicies []*iam.AttachedPolicy
+	// ListAttachedRolePoliciesErr is returned when listing the policies of a role
+	ListAttachedRolePoliciesErr error
+}
+
+func (a *IAMAPI) GetInstanceProfileWithContext(_ context.Context, input *iam.GetInstanceProfileInput, _ ...request.Option) (*iam.GetInstanceProfileOutput, error) {
+	if a.WantErr != nil {
+		return nil, a.WantErr
+	}
+	if aws.StringValue(input.InstanceProfileName) == "" {
+		return nil, awserr.New(iam.ErrCodeNoSuchEntityException, "instance profile not found", nil)
+	}
+	return &iam.GetInstanceProfileOutput{
+		InstanceProfile: &iam.InstanceProfile{
+			InstanceProfileName: input.InstanceProfileName,
+			Arn:                 aws.String(fmt.Sprintf("arn:aws:iam::123456789012:instance-profile/%s", aws.StringValue(input.InstanceProfileName))),
+			Roles:               []*iam.Role{{RoleName: aws.String(fmt.Sprintf("%s-role", aws.StringValue(input.InstanceProfileName)))}},
+		},
+	}, nil
+}
+
+func (a *IAMAPI) ListAttachedRolePoliciesPagesWithContext(_ context.Context, _ *iam.ListAttachedRolePoliciesInput, fn func(*iam.ListAttachedRolePoliciesOutput, bool) bool, _ ...request.Option) error {
+	if a.ListAttachedRolePoliciesErr != nil {
+		return a.ListAttachedRolePoliciesErr
+	}
+	if a.AttachedRolePolicies != nil {
+		fn(&iam.ListAttachedRolePoliciesOutput{AttachedPolicies: a.AttachedRolePolicies}, true)
+		return nil
+	}
+	fn(&iam.ListAttachedRolePoliciesOutput{AttachedPolicies: []*iam.AttachedPolicy{
+		{PolicyName: aws.String("AmazonEKSWorkerNodePolicy"), PolicyArn: aws.String("arn:aws:iam::aws:policy/AmazonEKSWorkerNodePolicy")},
+		{PolicyName: aws.String("AmazonEC2ContainerRegistryReadOnly"), PolicyArn: aws.String("arn:aws:iam::aws:policy/AmazonEC2ContainerRegistryReadOnly")},
+	}}, true)
+	return nil
+}
@@ -0,0 +1,55 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fake
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/outposts"
+	"github.com/aws/aws-sdk-go/service/outposts/outpostsiface"
+	set "github.com/deckarep/golang-set"
+)
+
+type OutpostsAPI struct {
+	outpostsiface.OutpostsAPI
+	// InstanceTypes of each Outpost, by ARN
+	InstanceTypes                          map[string][]string
+	CalledWithGetOutpostInstanceTypesInput set.Set
+	WantErr                                error
+}
+
+// Reset must be called between tests otherwise tests will pollute
+// each other.
+func (a *OutpostsAPI) Reset() {
+	a.InstanceTypes = nil
+	a.CalledWithGetOutpostInstanceTypesInput = set.NewSet()
+	a.WantErr = nil
+}
+
+func (a *OutpostsAPI) GetOutpostInstanceTypesWithContext(_ context.Context, input *outposts.GetOutpostInstanceTypesInput, _ ...request.Option) (*outposts.GetOutpostInstanceTypesOutput, error) {
+	if a.CalledWithGetOutpostInstanceTypesInput != nil {
+		a.CalledWithGetOutpostInstanceTypesInput.Add(aws.StringValue(input.OutpostId))
+	}
+	if a.WantErr != nil {
+		return nil, a.WantErr
+	}
+	output := &outposts.GetOutpostInstanceTypesOutput{OutpostId: input.OutpostId}
+	for _, instanceType := range a.InstanceTypes[aws.StringValue(input.OutpostId)] {
+		output.InstanceTypes = append(output.InstanceTypes, &outposts.InstanceTypeItem{InstanceType: aws.String(instanceType)})
+	}
+	return output, nil
+}
@@ -0,0 +1,41 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fake
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/pricing"
+	"github.com/aws/aws-sdk-go/service/pricing/pricingiface"
+)
+
+type PricingAPI struct {
+	pricingiface.PricingAPI
+	GetProductsOutput *pricing.GetProductsOutput
+	WantErr           error
+}
+
+func (a *PricingAPI) GetProductsPagesWithContext(_ context.Context, _ *pricing.GetProductsInput, fn func(*pricing.GetProductsOutput, bool) bool, _ ...request.Option) error {
+	if a.WantErr != nil {
+		return a.WantErr
+	}
+	if a.GetProductsOutput != nil {
+		fn(a.GetProductsOutput, false)
+		return nil
+	}
+	fn(&pricing.GetProductsOutput{}, false)
+	return nil
+}
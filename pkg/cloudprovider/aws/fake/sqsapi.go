@@ -0,0 +1,73 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fake
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	set "github.com/deckarep/golang-set"
+)
+
+type SQSAPI struct {
+	sqsiface.SQSAPI
+	// ReceiveMessageOutput is returned by the next call to ReceiveMessage,
+	// after which the queue is empty
+	ReceiveMessageOutput          *sqs.ReceiveMessageOutput
+	CalledWithReceiveMessageInput set.Set
+	CalledWithDeleteMessageInput  set.Set
+	WantErr                       error
+}
+
+// Reset must be called between tests otherwise tests will pollute
+// each other.
+func (a *SQSAPI) Reset() {
+	a.ReceiveMessageOutput = nil
+	a.CalledWithReceiveMessageInput = set.NewSet()
+	a.CalledWithDeleteMessageInput = set.NewSet()
+	a.WantErr = nil
+}
+
+func (a *SQSAPI) GetQueueUrlWithContext(_ context.Context, input *sqs.GetQueueUrlInput, _ ...request.Option) (*sqs.GetQueueUrlOutput, error) { //nolint:revive,stylecheck
+	if a.WantErr != nil {
+		return nil, a.WantErr
+	}
+	return &sqs.GetQueueUrlOutput{QueueUrl: aws.String(fmt.Sprintf("https://sqs.test-region.amazonaws.com/123456789012/%s", aws.StringValue(input.QueueName)))}, nil
+}
+
+func (a *SQSAPI) ReceiveMessageWithContext(_ context.Context, input *sqs.ReceiveMessageInput, _ ...request.Option) (*sqs.ReceiveMessageOutput, error) {
+	if a.WantErr != nil {
+		return nil, a.WantErr
+	}
+	a.CalledWithReceiveMessageInput.Add(aws.StringValue(input.QueueUrl))
+	if a.ReceiveMessageOutput != nil {
+		output := a.ReceiveMessageOutput
+		a.ReceiveMessageOutput = nil
+		return output, nil
+	}
+	return &sqs.ReceiveMessageOutput{}, nil
+}
+
+func (a *SQSAPI) DeleteMessageWithContext(_ context.Context, input *sqs.DeleteMessageInput, _ ...request.Option) (*sqs.DeleteMessageOutput, error) {
+	if a.WantErr != nil {
+		return nil, a.WantErr
+	}
+	a.CalledWithDeleteMessageInput.Add(aws.StringValue(input.ReceiptHandle))
+	return &sqs.DeleteMessageOutput{}, nil
+}
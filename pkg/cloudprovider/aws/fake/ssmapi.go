@@ -21,15 +21,28 @@ import (
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/ssm"
 	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+	set "github.com/deckarep/golang-set"
 )
 
 type SSMAPI struct {
 	ssmiface.SSMAPI
-	GetParameterOutput *ssm.GetParameterOutput
-	WantErr            error
+	GetParameterOutput          *ssm.GetParameterOutput
+	CalledWithGetParameterInput set.Set
+	WantErr                     error
 }
 
-func (a SSMAPI) GetParameterWithContext(context.Context, *ssm.GetParameterInput, ...request.Option) (*ssm.GetParameterOutput, error) {
+// Reset must be called between tests otherwise tests will pollute
+// each other.
+func (a *SSMAPI) Reset() {
+	a.GetParameterOutput = nil
+	a.CalledWithGetParameterInput = set.NewSet()
+	a.WantErr = nil
+}
+
+func (a *SSMAPI) GetParameterWithContext(_ context.Context, input *ssm.GetParameterInput, _ ...request.Option) (*ssm.GetParameterOutput, error) {
+	if a.CalledWithGetParameterInput != nil {
+		a.CalledWithGetParameterInput.Add(aws.StringValue(input.Name))
+	}
 	if a.WantErr != nil {
 		return nil, a.WantErr
 	}
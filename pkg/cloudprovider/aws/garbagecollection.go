@@ -0,0 +1,178 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/karpenter/pkg/cloudprovider/aws/apis/v1alpha1"
+	"github.com/aws/karpenter/pkg/utils/functional"
+	"github.com/aws/karpenter/pkg/utils/injection"
+	karpenterlogging "github.com/aws/karpenter/pkg/utils/logging"
+	"go.uber.org/multierr"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/util/workqueue"
+	"knative.dev/pkg/logging"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const (
+	garbageCollectionControllerName = "garbagecollection"
+	// GarbageCollectionInterval is the duration between searches for orphaned
+	// instances
+	GarbageCollectionInterval = 5 * time.Minute
+	// GarbageCollectionGracePeriod is the duration after an instance is launched
+	// before it may be terminated for not having a node. The node is created
+	// right after the instance is launched, but may not yet be visible in the
+	// informer cache.
+	GarbageCollectionGracePeriod = 5 * time.Minute
+	// StoppedInstanceTTL is the duration that the instances of empty nodes are
+	// kept stopped to be restarted for pending pods, after which they are
+	// terminated.
+	StoppedInstanceTTL = 24 * time.Hour
+)
+
+// GarbageCollectionController terminates instances that Karpenter launched
+// for the cluster, but that have no node. This happens if the node fails to be
+// created after a successful launch, or if the node is deleted without its
+// termination finalizer, which would otherwise leak the instance. Instances
+// that were stopped because their nodes were empty are kept until they expire.
+// Each account and region that instances are launched in is searched.
+type GarbageCollectionController struct {
+	kubeClient client.Client
+	providers  func(context.Context) ([]*providers, error)
+}
+
+// NewGarbageCollectionController constructs a controller instance
+func NewGarbageCollectionController(kubeClient client.Client, providers func(context.Context) ([]*providers, error)) *GarbageCollectionController {
+	return &GarbageCollectionController{kubeClient: kubeClient, providers: providers}
+}
+
+// Reconcile terminates orphaned instances, and requeues itself until the next
+// search
+func (c *GarbageCollectionController) Reconcile(ctx context.Context, _ reconcile.Request) (reconcile.Result, error) {
+	ctx = karpenterlogging.WithController(ctx, garbageCollectionControllerName)
+	targets, err := c.providers(ctx)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	var errs error
+	for _, p := range targets {
+		errs = multierr.Append(errs, c.terminateOrphanedInstances(ctx, p.instanceProvider.ec2api))
+	}
+	if errs != nil {
+		return reconcile.Result{}, errs
+	}
+	return reconcile.Result{RequeueAfter: GarbageCollectionInterval}, nil
+}
+
+// terminateOrphanedInstances terminates the orphaned instances of an account
+// and region
+func (c *GarbageCollectionController) terminateOrphanedInstances(ctx context.Context, ec2api ec2iface.EC2API) error {
+	orphaned, err := c.getOrphanedInstances(ctx, ec2api)
+	if err != nil {
+		return err
+	}
+	if len(orphaned) > 0 {
+		logging.FromContext(ctx).Infof("Terminating %d instances without nodes, %s", len(orphaned), strings.Join(aws.StringValueSlice(orphaned), ", "))
+		if _, err := ec2api.TerminateInstancesWithContext(ctx, &ec2.TerminateInstancesInput{InstanceIds: orphaned}); err != nil && !isNotFound(err) {
+			return fmt.Errorf("terminating instances, %w", err)
+		}
+	}
+	return nil
+}
+
+// Register the controller, which is started with a single request that
+// requeues itself. It only runs on the leader.
+func (c *GarbageCollectionController) Register(_ context.Context, m manager.Manager) error {
+	garbageCollectionController, err := controller.New(garbageCollectionControllerName, m, controller.Options{
+		Reconciler:              c,
+		MaxConcurrentReconciles: 1,
+	})
+	if err != nil {
+		return err
+	}
+	return garbageCollectionController.Watch(source.Func(func(_ context.Context, _ handler.EventHandler, queue workqueue.RateLimitingInterface, _ ...predicate.Predicate) error {
+		queue.Add(reconcile.Request{NamespacedName: types.NamespacedName{Name: garbageCollectionControllerName}})
+		return nil
+	}), &handler.Funcs{})
+}
+
+// getOrphanedInstances returns the IDs of the instances launched by Karpenter
+// for the cluster, outside of the grace period, that no node refers to. The
+// instances are listed before the nodes, so that a node created in between is
+// not missed.
+func (c *GarbageCollectionController) getOrphanedInstances(ctx context.Context, ec2api ec2iface.EC2API) ([]*string, error) {
+	instances := []*ec2.Instance{}
+	if err := ec2api.DescribeInstancesPagesWithContext(ctx, &ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("tag-key"), Values: aws.StringSlice([]string{fmt.Sprintf(v1alpha1.KarpenterTagKeyFormat, injection.GetOptions(ctx).ClusterName)})},
+			{Name: aws.String("instance-state-name"), Values: aws.StringSlice([]string{ec2.InstanceStateNamePending, ec2.InstanceStateNameRunning, ec2.InstanceStateNameStopping, ec2.InstanceStateNameStopped})},
+		},
+	}, func(output *ec2.DescribeInstancesOutput, _ bool) bool {
+		instances = append(instances, combineReservations(output.Reservations)...)
+		return true
+	}); err != nil {
+		return nil, fmt.Errorf("describing instances, %w", err)
+	}
+	nodes := &v1.NodeList{}
+	if err := c.kubeClient.List(ctx, nodes); err != nil {
+		return nil, fmt.Errorf("listing nodes, %w", err)
+	}
+	registered := sets.NewString()
+	for i := range nodes.Items {
+		if id, err := getInstanceID(&nodes.Items[i]); err == nil {
+			registered.Insert(aws.StringValue(id))
+		}
+	}
+	orphaned := []*string{}
+	for _, instance := range instances {
+		if registered.Has(aws.StringValue(instance.InstanceId)) {
+			continue
+		}
+		if time.Since(aws.TimeValue(instance.LaunchTime)) < GarbageCollectionGracePeriod {
+			continue
+		}
+		if isWarm(instance) {
+			continue
+		}
+		orphaned = append(orphaned, instance.InstanceId)
+	}
+	return orphaned, nil
+}
+
+// isWarm returns true if the instance was stopped because its node was empty,
+// and is kept to be restarted until it expires.
+func isWarm(instance *ec2.Instance) bool {
+	if instance.State == nil || !functional.ContainsString([]string{ec2.InstanceStateNameStopping, ec2.InstanceStateNameStopped}, aws.StringValue(instance.State.Name)) {
+		return false
+	}
+	stoppedAt, ok := stoppedTimestamp(instance)
+	return ok && time.Since(stoppedAt) < StoppedInstanceTTL
+}
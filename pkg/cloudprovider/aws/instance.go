@@ -17,6 +17,7 @@ package aws
 import (
 	"context"
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 
@@ -24,6 +25,8 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/mitchellh/hashstructure/v2"
+	"github.com/patrickmn/go-cache"
 	"go.uber.org/multierr"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -33,7 +36,9 @@ import (
 	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
 	"github.com/aws/karpenter/pkg/cloudprovider"
 	"github.com/aws/karpenter/pkg/cloudprovider/aws/apis/v1alpha1"
+	"github.com/aws/karpenter/pkg/utils/functional"
 	"github.com/aws/karpenter/pkg/utils/injection"
+	"github.com/aws/karpenter/pkg/utils/resources"
 )
 
 type InstanceProvider struct {
@@ -41,17 +46,28 @@ type InstanceProvider struct {
 	instanceTypeProvider   *InstanceTypeProvider
 	subnetProvider         *SubnetProvider
 	launchTemplateProvider *LaunchTemplateProvider
+	// failedClientTokens are the client tokens of fleets that launched no
+	// instances, which are not reused so that the launches are retried
+	failedClientTokens *cache.Cache
 }
 
 // Create an instance given the constraints.
-// instanceTypes should be sorted by priority for spot capacity type.
+// instanceTypes should be sorted by priority for spot capacity type, which is
+// used by the capacity-optimized-prioritized spot allocation strategy.
 // If spot is not used, the instanceTypes are not required to be sorted
 // because we are using ec2 fleet's lowest-price OD allocation strategy
 func (p *InstanceProvider) Create(ctx context.Context, constraints *v1alpha1.Constraints, instanceTypes []cloudprovider.InstanceType, quantity int) ([]*v1.Node, error) {
-	// Launch Instance
-	ids, err := p.launchInstances(ctx, constraints, instanceTypes, quantity)
-	if err != nil {
-		return nil, err
+	// Restart stopped instances before launching new ones
+	ids := p.startInstances(ctx, constraints, instanceTypes, quantity)
+	if len(ids) < quantity {
+		// Launch Instance
+		launched, err := p.launchInstances(ctx, constraints, instanceTypes, quantity-len(ids))
+		if err != nil && len(ids) == 0 {
+			return nil, err
+		} else if err != nil {
+			logging.FromContext(ctx).Errorf("Launching %d instance(s) in addition to %d started instance(s), %s", quantity-len(ids), len(ids), err.Error())
+		}
+		ids = append(ids, launched...)
 	}
 	// Get Instance with backoff retry since EC2 is eventually consistent
 	instances := []*ec2.Instance{}
@@ -75,11 +91,17 @@ func (p *InstanceProvider) Create(ctx context.Context, constraints *v1alpha1.Con
 			getCapacityType(instance),
 		)
 		// Convert Instance to Node
-		node, err := p.instanceToNode(ctx, instance, instanceTypes)
+		node, err := p.instanceToNode(ctx, instance, instanceTypes, constraints.OperatingSystem())
 		if err != nil {
 			logging.FromContext(ctx).Errorf("creating Node from an EC2 Instance: %s", err.Error())
 			continue
 		}
+		if policy := emptyInstancePolicy(constraints, instance); policy != "" {
+			node.Annotations = functional.UnionStringMaps(node.Annotations, map[string]string{v1alpha1.EmptyInstancePolicyAnnotationKey: policy})
+		}
+		if policy := aws.StringValue(constraints.RebalanceRecommendationPolicy); policy != "" && policy != v1alpha1.RebalanceRecommendationPolicyDrain {
+			node.Annotations = functional.UnionStringMaps(node.Annotations, map[string]string{v1alpha1.RebalanceRecommendationPolicyAnnotationKey: policy})
+		}
 		nodes = append(nodes, node)
 	}
 	if len(nodes) == 0 {
@@ -104,16 +126,250 @@ func (p *InstanceProvider) Terminate(ctx context.Context, node *v1.Node) error {
 	return nil
 }
 
+// Stop stops the node's instance, or hibernates it, so that it can be
+// restarted for pending pods. The instance is tagged with the time that it was
+// stopped beforehand, so that it isn't garbage collected for having no node,
+// and with the node's spec hash, so that it's only restarted if the
+// provisioner's spec hasn't changed.
+func (p *InstanceProvider) Stop(ctx context.Context, node *v1.Node, hibernate bool) error {
+	id, err := getInstanceID(node)
+	if err != nil {
+		return fmt.Errorf("getting instance ID for node %s, %w", node.Name, err)
+	}
+	tags := []*ec2.Tag{{Key: aws.String(v1alpha1.StoppedTimestampTagKey), Value: aws.String(time.Now().UTC().Format(time.RFC3339))}}
+	if hash, ok := node.Annotations[v1alpha1.SpecHashAnnotationKey]; ok {
+		tags = append(tags, &ec2.Tag{Key: aws.String(v1alpha1.SpecHashTagKey), Value: aws.String(hash)})
+	}
+	if _, err = p.ec2api.CreateTagsWithContext(ctx, &ec2.CreateTagsInput{
+		Resources: []*string{id},
+		Tags:      tags,
+	}); err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("tagging instance %s, %w", node.Name, err)
+	}
+	if _, err = p.ec2api.StopInstancesWithContext(ctx, &ec2.StopInstancesInput{
+		InstanceIds: []*string{id},
+		Hibernate:   aws.Bool(hibernate),
+	}); err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("stopping instance %s, %w", node.Name, err)
+	}
+	logging.FromContext(ctx).Infof("Stopped instance %s of empty node %s, hibernate: %t", aws.StringValue(id), node.Name, hibernate)
+	return nil
+}
+
+// startInstances restarts up to quantity of the provisioner's stopped
+// instances that the constraints allow, preferring instance types in the order
+// they are given, and returns their IDs. Stopped instances are on-demand, so
+// they are only restarted if the constraints allow on-demand capacity.
+// Instances that fail to start are left stopped, and new instances are
+// launched instead. Stopped instances that no longer match what the
+// constraints launch are terminated, since they'll never be restarted.
+func (p *InstanceProvider) startInstances(ctx context.Context, constraints *v1alpha1.Constraints, instanceTypes []cloudprovider.InstanceType, quantity int) []*string {
+	policy := aws.StringValue(constraints.EmptyInstancePolicy)
+	if (policy != v1alpha1.EmptyInstancePolicyStop && policy != v1alpha1.EmptyInstancePolicyHibernate) ||
+		!constraints.Requirements.CapacityTypes().Has(v1alpha1.CapacityTypeOnDemand) {
+		return nil
+	}
+	stopped, err := p.getStoppedInstances(ctx, constraints, instanceTypes)
+	if err != nil {
+		logging.FromContext(ctx).Errorf("Getting stopped instances, %s", err.Error())
+		return nil
+	}
+	instances := p.terminateStale(ctx, constraints, stopped)
+	if len(instances) > quantity {
+		instances = instances[:quantity]
+	}
+	ids := []*string{}
+	for _, instance := range instances {
+		ids = append(ids, instance.InstanceId)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	if _, err := p.ec2api.StartInstancesWithContext(ctx, &ec2.StartInstancesInput{InstanceIds: ids}); err != nil {
+		logging.FromContext(ctx).Errorf("Starting %d stopped instance(s), %s", len(ids), err.Error())
+		return nil
+	}
+	// Running instances are never garbage collected for being stopped, so a
+	// failure to remove the tag is only logged
+	if _, err := p.ec2api.DeleteTagsWithContext(ctx, &ec2.DeleteTagsInput{
+		Resources: ids,
+		Tags:      []*ec2.Tag{{Key: aws.String(v1alpha1.StoppedTimestampTagKey)}},
+	}); err != nil {
+		logging.FromContext(ctx).Errorf("Removing the stopped timestamp tag of started instances, %s", err.Error())
+	}
+	logging.FromContext(ctx).Infof("Started %d stopped instance(s), %s", len(ids), strings.Join(aws.StringValueSlice(ids), ", "))
+	return ids
+}
+
+// terminateStale terminates the stopped instances that were launched from a
+// different provisioner spec, AMI or launch template version than the
+// constraints launch, and returns the others. Instances that can't be compared
+// are left stopped, and aren't returned.
+func (p *InstanceProvider) terminateStale(ctx context.Context, constraints *v1alpha1.Constraints, instances []*ec2.Instance) []*ec2.Instance {
+	hash, err := specHash(constraints)
+	if err != nil {
+		logging.FromContext(ctx).Errorf("Hashing provisioner spec, %s", err.Error())
+		return nil
+	}
+	current := []*ec2.Instance{}
+	stale := []*string{}
+	for _, instance := range instances {
+		reason, err := p.stale(ctx, instance, constraints, hash)
+		if err != nil {
+			logging.FromContext(ctx).Errorf("Comparing stopped instance %s, %s", aws.StringValue(instance.InstanceId), err.Error())
+			continue
+		}
+		if reason != "" {
+			logging.FromContext(ctx).Infof("Terminating stopped instance %s, %s", aws.StringValue(instance.InstanceId), reason)
+			stale = append(stale, instance.InstanceId)
+			continue
+		}
+		current = append(current, instance)
+	}
+	if len(stale) > 0 {
+		if _, err := p.ec2api.TerminateInstancesWithContext(ctx, &ec2.TerminateInstancesInput{InstanceIds: stale}); err != nil {
+			logging.FromContext(ctx).Errorf("Terminating %d stale stopped instance(s), %s", len(stale), err.Error())
+		}
+	}
+	return current
+}
+
+// stale returns the reason that the stopped instance no longer matches what
+// the constraints launch, like a drifted node, or an empty string if it
+// matches. Instances that were stopped without a spec hash are stale.
+func (p *InstanceProvider) stale(ctx context.Context, instance *ec2.Instance, constraints *v1alpha1.Constraints, hash string) (string, error) {
+	if stopped, _ := getTag(instance, v1alpha1.SpecHashTagKey); stopped != hash {
+		return "provisioner spec changed since the instance was launched", nil
+	}
+	if constraints.LaunchTemplate != nil {
+		return p.launchTemplateDrifted(ctx, instance, constraints)
+	}
+	return p.amiDrifted(ctx, instance, constraints)
+}
+
+// getStoppedInstances returns the provisioner's stopped instances whose
+// instance types, zones and subnets the constraints allow, ordered by the
+// priority of their instance types.
+func (p *InstanceProvider) getStoppedInstances(ctx context.Context, constraints *v1alpha1.Constraints, instanceTypes []cloudprovider.InstanceType) ([]*ec2.Instance, error) {
+	subnets, err := p.subnetProvider.Get(ctx, constraints.AWS)
+	if err != nil {
+		return nil, fmt.Errorf("getting subnets, %w", err)
+	}
+	subnetIDs := sets.NewString()
+	for _, subnet := range subnets {
+		subnetIDs.Insert(aws.StringValue(subnet.SubnetId))
+	}
+	priorities := map[string]int{}
+	for i, instanceType := range instanceTypes {
+		priorities[instanceType.Name()] = i
+	}
+	provisionerName := injection.GetNamespacedName(ctx).Name
+	instances := []*ec2.Instance{}
+	if err := p.ec2api.DescribeInstancesPagesWithContext(ctx, &ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("tag-key"), Values: aws.StringSlice([]string{fmt.Sprintf(v1alpha1.KarpenterTagKeyFormat, injection.GetOptions(ctx).ClusterName)})},
+			{Name: aws.String(fmt.Sprintf("tag:%s", v1alpha1.ProvisionerNameTagKey)), Values: aws.StringSlice([]string{provisionerName})},
+			{Name: aws.String("instance-state-name"), Values: aws.StringSlice([]string{ec2.InstanceStateNameStopped})},
+		},
+	}, func(output *ec2.DescribeInstancesOutput, _ bool) bool {
+		for _, instance := range combineReservations(output.Reservations) {
+			if _, ok := priorities[aws.StringValue(instance.InstanceType)]; !ok {
+				continue
+			}
+			if instance.State == nil || aws.StringValue(instance.State.Name) != ec2.InstanceStateNameStopped {
+				continue
+			}
+			if _, ok := stoppedTimestamp(instance); !ok {
+				continue
+			}
+			if name, _ := getTag(instance, v1alpha1.ProvisionerNameTagKey); name != provisionerName {
+				continue
+			}
+			if instance.Placement == nil || !constraints.Requirements.Zones().Has(aws.StringValue(instance.Placement.AvailabilityZone)) || !subnetIDs.Has(aws.StringValue(instance.SubnetId)) {
+				continue
+			}
+			instances = append(instances, instance)
+		}
+		return true
+	}); err != nil {
+		return nil, fmt.Errorf("describing instances, %w", err)
+	}
+	sort.SliceStable(instances, func(i, j int) bool {
+		return priorities[aws.StringValue(instances[i].InstanceType)] < priorities[aws.StringValue(instances[j].InstanceType)]
+	})
+	return instances, nil
+}
+
+// emptyInstancePolicy returns the policy that is recorded on the instance's
+// node for when it is empty, which is Hibernate if the instance was launched
+// with hibernation enabled, or Stop. Nothing is recorded for instances that
+// are terminated, including all spot instances.
+func emptyInstancePolicy(constraints *v1alpha1.Constraints, instance *ec2.Instance) string {
+	policy := aws.StringValue(constraints.EmptyInstancePolicy)
+	if (policy != v1alpha1.EmptyInstancePolicyStop && policy != v1alpha1.EmptyInstancePolicyHibernate) || getCapacityType(instance) == v1alpha1.CapacityTypeSpot {
+		return ""
+	}
+	if policy == v1alpha1.EmptyInstancePolicyHibernate && instance.HibernationOptions != nil && aws.BoolValue(instance.HibernationOptions.Configured) {
+		return v1alpha1.EmptyInstancePolicyHibernate
+	}
+	return v1alpha1.EmptyInstancePolicyStop
+}
+
+// stoppedTimestamp returns the time that the instance was stopped, if it was
+// stopped because its node was empty.
+func stoppedTimestamp(instance *ec2.Instance) (time.Time, bool) {
+	value, ok := getTag(instance, v1alpha1.StoppedTimestampTagKey)
+	if !ok {
+		return time.Time{}, false
+	}
+	stoppedAt, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return stoppedAt, true
+}
+
+func getTag(instance *ec2.Instance, key string) (string, bool) {
+	for _, tag := range instance.Tags {
+		if aws.StringValue(tag.Key) == key {
+			return aws.StringValue(tag.Value), true
+		}
+	}
+	return "", false
+}
+
+// launchInstances launches spot capacity if the constraints allow it, and
+// falls back to on-demand if they also allow it and no spot capacity is
+// available in any of the requested pools, rather than waiting for the next
+// provisioning attempt.
 func (p *InstanceProvider) launchInstances(ctx context.Context, constraints *v1alpha1.Constraints, instanceTypes []cloudprovider.InstanceType, quantity int) ([]*string, error) {
 	capacityType := p.getCapacityType(constraints, instanceTypes)
+	ids, err := p.createFleet(ctx, constraints, instanceTypes, quantity, capacityType)
+	if capacityType == v1alpha1.CapacityTypeSpot && cloudprovider.IsInsufficientCapacityError(err) &&
+		constraints.Requirements.CapacityTypes().Has(v1alpha1.CapacityTypeOnDemand) {
+		logging.FromContext(ctx).Infof("Falling back to on-demand capacity, %s", err.Error())
+		return p.createFleet(ctx, constraints, instanceTypes, quantity, v1alpha1.CapacityTypeOnDemand)
+	}
+	return ids, err
+}
 
+func (p *InstanceProvider) createFleet(ctx context.Context, constraints *v1alpha1.Constraints, instanceTypes []cloudprovider.InstanceType, quantity int, capacityType string) ([]*string, error) {
 	// Get Launch Template Configs, which may differ due to GPU or Architecture requirements
 	launchTemplateConfigs, err := p.getLaunchTemplateConfigs(ctx, constraints, instanceTypes, capacityType)
 	if err != nil {
 		return nil, fmt.Errorf("getting launch template configs, %w", err)
 	}
+	if !hasOverrides(launchTemplateConfigs) {
+		return nil, cloudprovider.NewInsufficientCapacityError(fmt.Errorf("no %s offerings of %d instance type option(s) are available in subnets for zones %v", capacityType, len(instanceTypes), constraints.Requirements.Zones().List()))
+	}
 	// Create fleet
-	createFleetOutput, err := p.ec2api.CreateFleetWithContext(ctx, &ec2.CreateFleetInput{
+	createFleetInput := &ec2.CreateFleetInput{
 		Type:                  aws.String(ec2.FleetTypeInstant),
 		LaunchTemplateConfigs: launchTemplateConfigs,
 		TargetCapacitySpecification: &ec2.TargetCapacitySpecificationRequest{
@@ -127,16 +383,32 @@ func (p *InstanceProvider) launchInstances(ctx context.Context, constraints *v1a
 			},
 		},
 		// OnDemandOptions are allowed to be specified even when requesting spot
-		OnDemandOptions: &ec2.OnDemandOptionsRequest{AllocationStrategy: aws.String(ec2.FleetOnDemandAllocationStrategyLowestPrice)},
+		OnDemandOptions: onDemandOptions(constraints),
 		// SpotOptions are allowed to be specified even when requesting on-demand
-		SpotOptions: &ec2.SpotOptionsRequest{AllocationStrategy: aws.String(ec2.SpotAllocationStrategyCapacityOptimizedPrioritized)},
-	})
+		SpotOptions: &ec2.SpotOptionsRequest{AllocationStrategy: aws.String(spotAllocationStrategy(constraints))},
+	}
+	if createFleetInput.ClientToken, err = p.clientToken(ctx, createFleetInput); err != nil {
+		return nil, fmt.Errorf("computing client token, %w", err)
+	}
+	createFleetOutput, err := p.ec2api.CreateFleetWithContext(ctx, createFleetInput)
 	if err != nil {
 		return nil, fmt.Errorf("creating fleet %w", err)
 	}
 	p.updateUnavailableOfferingsCache(ctx, createFleetOutput.Errors, capacityType)
+	exhausted := p.updateSubnets(createFleetOutput)
 	instanceIds := combineFleetInstances(*createFleetOutput)
 	if len(instanceIds) == 0 {
+		if createFleetInput.ClientToken != nil {
+			p.failedClientTokens.SetDefault(aws.StringValue(createFleetInput.ClientToken), nil)
+		}
+		// Subnets without available addresses are excluded from the next attempt
+		if exhausted {
+			logging.FromContext(ctx).Debugf("Retrying in alternate subnets, %s", combineFleetErrors(createFleetOutput.Errors).Error())
+			return p.createFleet(ctx, constraints, instanceTypes, quantity, capacityType)
+		}
+		if isInsufficientCapacity(createFleetOutput.Errors) {
+			return nil, cloudprovider.NewInsufficientCapacityError(combineFleetErrors(createFleetOutput.Errors))
+		}
 		return nil, combineFleetErrors(createFleetOutput.Errors)
 	} else if len(instanceIds) != quantity {
 		logging.FromContext(ctx).Errorf("Failed to launch %d EC2 instances out of the %d EC2 instances requested: %s",
@@ -145,32 +417,133 @@ func (p *InstanceProvider) launchInstances(ctx context.Context, constraints *v1a
 	return instanceIds, nil
 }
 
+// clientToken returns a token that makes the fleet request idempotent within
+// a launch attempt, so that retried requests return the instances that were
+// already launched rather than launching more. Each attempt has its own ID, so
+// later attempts for the same pods launch new instances rather than returning
+// ones that may since have been terminated. Requests with different
+// parameters, e.g. alternate subnets or capacity types, have different tokens.
+// Requests that aren't identified by a launch have no token.
+func (p *InstanceProvider) clientToken(ctx context.Context, input *ec2.CreateFleetInput) (*string, error) {
+	launchID := injection.GetLaunchID(ctx)
+	if launchID == "" {
+		return nil, nil
+	}
+	hash, err := hashstructure.Hash(struct {
+		LaunchID string
+		Input    *ec2.CreateFleetInput
+	}{launchID, input}, hashstructure.FormatV2, &hashstructure.HashOptions{SlicesAsSets: true})
+	if err != nil {
+		return nil, err
+	}
+	// EC2 returns the result of the original request for a token, so tokens
+	// that launched no instances are skipped to retry the launch
+	for attempt := 0; ; attempt++ {
+		token := fmt.Sprintf("%d-%d", hash, attempt)
+		if _, failed := p.failedClientTokens.Get(token); !failed {
+			return aws.String(token), nil
+		}
+	}
+}
+
 func (p *InstanceProvider) getLaunchTemplateConfigs(ctx context.Context, constraints *v1alpha1.Constraints, instanceTypes []cloudprovider.InstanceType, capacityType string) ([]*ec2.FleetLaunchTemplateConfigRequest, error) {
 	// Get subnets given the constraints
 	subnets, err := p.subnetProvider.Get(ctx, constraints.AWS)
 	if err != nil {
 		return nil, fmt.Errorf("getting subnets, %w", err)
 	}
+	// Instances in a cluster placement group must be in a single zone
+	zones := constraints.Requirements.Zones()
+	cluster, zone, err := p.clusterPlacement(ctx, constraints)
+	if err != nil {
+		return nil, fmt.Errorf("getting placement group, %w", err)
+	}
+	if zone != "" {
+		zones = zones.Intersection(sets.NewString(zone))
+	}
 	var launchTemplateConfigs []*ec2.FleetLaunchTemplateConfigRequest
 	launchTemplates, err := p.launchTemplateProvider.Get(ctx, constraints, instanceTypes, map[string]string{v1alpha5.LabelCapacityType: capacityType})
 	if err != nil {
 		return nil, fmt.Errorf("getting launch templates, %w", err)
 	}
+	// Generated launch templates are only ever created with a default version
+	version := "$Default"
+	if constraints.LaunchTemplate != nil && constraints.LaunchTemplateVersion != nil {
+		version = aws.StringValue(constraints.LaunchTemplateVersion)
+	}
 	for launchTemplateName, instanceTypes := range launchTemplates {
 		launchTemplateConfigs = append(launchTemplateConfigs, &ec2.FleetLaunchTemplateConfigRequest{
-			Overrides: p.getOverrides(instanceTypes, subnets, constraints.Requirements.Zones(), capacityType),
+			Overrides: p.getOverrides(instanceTypes, subnets, zones, capacityType, spotAllocationStrategy(constraints)),
 			LaunchTemplateSpecification: &ec2.FleetLaunchTemplateSpecificationRequest{
 				LaunchTemplateName: aws.String(launchTemplateName),
-				Version:            aws.String("$Default"),
+				Version:            aws.String(version),
 			},
 		})
 	}
+	if cluster {
+		inSingleZone(launchTemplateConfigs)
+	}
 	return launchTemplateConfigs, nil
 }
 
+// clusterPlacement returns true if the constraints' placement group uses the
+// cluster strategy, and the zone of the group's existing instances, if it has
+// any, since later instances must launch into the same zone.
+func (p *InstanceProvider) clusterPlacement(ctx context.Context, constraints *v1alpha1.Constraints) (bool, string, error) {
+	if constraints.PlacementGroup == nil {
+		return false, "", nil
+	}
+	groups, err := p.ec2api.DescribePlacementGroupsWithContext(ctx, &ec2.DescribePlacementGroupsInput{GroupNames: []*string{constraints.PlacementGroup}})
+	if err != nil {
+		return false, "", fmt.Errorf("describing placement group %s, %w", aws.StringValue(constraints.PlacementGroup), err)
+	}
+	if len(groups.PlacementGroups) == 0 || aws.StringValue(groups.PlacementGroups[0].Strategy) != ec2.PlacementStrategyCluster {
+		return false, "", nil
+	}
+	instances, err := p.ec2api.DescribeInstancesWithContext(ctx, &ec2.DescribeInstancesInput{Filters: []*ec2.Filter{
+		{Name: aws.String("placement-group-name"), Values: []*string{constraints.PlacementGroup}},
+		{Name: aws.String("instance-state-name"), Values: aws.StringSlice([]string{ec2.InstanceStateNamePending, ec2.InstanceStateNameRunning, ec2.InstanceStateNameStopping, ec2.InstanceStateNameStopped})},
+	}})
+	if err != nil {
+		return false, "", fmt.Errorf("describing instances in placement group %s, %w", aws.StringValue(constraints.PlacementGroup), err)
+	}
+	for _, instance := range combineReservations(instances.Reservations) {
+		if instance.Placement != nil && aws.StringValue(instance.Placement.AvailabilityZone) != "" {
+			return true, aws.StringValue(instance.Placement.AvailabilityZone), nil
+		}
+	}
+	return true, "", nil
+}
+
+// inSingleZone removes the overrides outside of the zone with the most
+// overrides, preferring the first zone alphabetically if several have as many
+func inSingleZone(launchTemplateConfigs []*ec2.FleetLaunchTemplateConfigRequest) {
+	counts := map[string]int{}
+	for _, launchTemplateConfig := range launchTemplateConfigs {
+		for _, override := range launchTemplateConfig.Overrides {
+			counts[aws.StringValue(override.AvailabilityZone)]++
+		}
+	}
+	zone := ""
+	for _, candidate := range sets.StringKeySet(counts).List() {
+		if counts[candidate] > counts[zone] {
+			zone = candidate
+		}
+	}
+	for _, launchTemplateConfig := range launchTemplateConfigs {
+		overrides := []*ec2.FleetLaunchTemplateOverridesRequest{}
+		for _, override := range launchTemplateConfig.Overrides {
+			if aws.StringValue(override.AvailabilityZone) == zone {
+				overrides = append(overrides, override)
+			}
+		}
+		launchTemplateConfig.Overrides = overrides
+	}
+}
+
 // getOverrides creates and returns launch template overrides for the cross product of instanceTypeOptions and subnets (with subnets being constrained by
 // zones and the offerings in instanceTypeOptions)
-func (p *InstanceProvider) getOverrides(instanceTypeOptions []cloudprovider.InstanceType, subnets []*ec2.Subnet, zones sets.String, capacityType string) []*ec2.FleetLaunchTemplateOverridesRequest {
+func (p *InstanceProvider) getOverrides(instanceTypeOptions []cloudprovider.InstanceType, subnets []*ec2.Subnet, zones sets.String, capacityType string, spotAllocationStrategy string) []*ec2.FleetLaunchTemplateOverridesRequest {
 	var overrides []*ec2.FleetLaunchTemplateOverridesRequest
 	for i, instanceType := range instanceTypeOptions {
 		for _, offering := range instanceType.Offerings() {
@@ -180,8 +553,9 @@ func (p *InstanceProvider) getOverrides(instanceTypeOptions []cloudprovider.Inst
 			if !zones.Has(offering.Zone) {
 				continue
 			}
+			// Subnets are ordered by available IP addresses, so the one with the most is used
 			for _, subnet := range subnets {
-				if aws.StringValue(subnet.AvailabilityZone) != offering.Zone {
+				if aws.StringValue(subnet.AvailabilityZone) != offering.Zone || aws.Int64Value(subnet.AvailableIpAddressCount) == 0 {
 					continue
 				}
 				override := &ec2.FleetLaunchTemplateOverridesRequest{
@@ -191,10 +565,11 @@ func (p *InstanceProvider) getOverrides(instanceTypeOptions []cloudprovider.Inst
 					// CreateFleet so that we can figure out the zone rather than additional API calls to look up the subnet
 					AvailabilityZone: subnet.AvailabilityZone,
 				}
-				// Add a priority for spot requests since we are using the capacity-optimized-prioritized spot allocation strategy
+				// Add a priority for spot requests when using the capacity-optimized-prioritized spot allocation strategy
 				// to reduce the likelihood of getting an excessively large instance type.
 				// instanceTypeOptions are sorted by vcpus and memory so this prioritizes smaller instance types.
-				if capacityType == v1alpha1.CapacityTypeSpot {
+				// Other strategies choose from all of the instance types, which diversifies spot capacity.
+				if capacityType == v1alpha1.CapacityTypeSpot && spotAllocationStrategy == ec2.SpotAllocationStrategyCapacityOptimizedPrioritized {
 					override.Priority = aws.Float64(float64(i))
 				}
 				overrides = append(overrides, override)
@@ -206,6 +581,28 @@ func (p *InstanceProvider) getOverrides(instanceTypeOptions []cloudprovider.Inst
 	return overrides
 }
 
+// onDemandOptions launches on-demand instances at the lowest price, and uses
+// the capacity reservations in the provisioner's capacity reservation group
+// before launching instances outside of them.
+func onDemandOptions(constraints *v1alpha1.Constraints) *ec2.OnDemandOptionsRequest {
+	options := &ec2.OnDemandOptionsRequest{AllocationStrategy: aws.String(ec2.FleetOnDemandAllocationStrategyLowestPrice)}
+	if constraints.CapacityReservationSpecification != nil && constraints.CapacityReservationSpecification.CapacityReservationResourceGroupARN != nil {
+		options.CapacityReservationOptions = &ec2.CapacityReservationOptionsRequest{
+			UsageStrategy: aws.String(ec2.FleetCapacityReservationUsageStrategyUseCapacityReservationsFirst),
+		}
+	}
+	return options
+}
+
+// spotAllocationStrategy returns the provisioner's spot allocation strategy,
+// which defaults to capacity-optimized-prioritized.
+func spotAllocationStrategy(constraints *v1alpha1.Constraints) string {
+	if constraints.SpotAllocationStrategy == nil {
+		return ec2.SpotAllocationStrategyCapacityOptimizedPrioritized
+	}
+	return aws.StringValue(constraints.SpotAllocationStrategy)
+}
+
 func (p *InstanceProvider) getInstances(ctx context.Context, ids []*string) ([]*ec2.Instance, error) {
 	describeInstancesOutput, err := p.ec2api.DescribeInstancesWithContext(ctx, &ec2.DescribeInstancesInput{InstanceIds: ids})
 	if isNotFound(err) {
@@ -229,7 +626,7 @@ func (p *InstanceProvider) getInstances(ctx context.Context, ids []*string) ([]*
 	return instances, err
 }
 
-func (p *InstanceProvider) instanceToNode(ctx context.Context, instance *ec2.Instance, instanceTypes []cloudprovider.InstanceType) (*v1.Node, error) {
+func (p *InstanceProvider) instanceToNode(ctx context.Context, instance *ec2.Instance, instanceTypes []cloudprovider.InstanceType, operatingSystem string) (*v1.Node, error) {
 	for _, instanceType := range instanceTypes {
 		if instanceType.Name() == aws.StringValue(instance.InstanceType) {
 			nodeName := strings.ToLower(aws.StringValue(instance.PrivateDnsName))
@@ -239,30 +636,34 @@ func (p *InstanceProvider) instanceToNode(ctx context.Context, instance *ec2.Ins
 			return &v1.Node{
 				ObjectMeta: metav1.ObjectMeta{
 					Name: nodeName,
-					Labels: map[string]string{
+					Labels: functional.UnionStringMaps(instanceType.Labels(), map[string]string{
 						v1.LabelTopologyZone:       aws.StringValue(instance.Placement.AvailabilityZone),
 						v1.LabelInstanceTypeStable: aws.StringValue(instance.InstanceType),
 						v1alpha5.LabelCapacityType: getCapacityType(instance),
-					},
+						v1.LabelArchStable:         instanceType.Architecture(),
+						v1.LabelOSStable:           operatingSystem,
+					}),
 				},
 				Spec: v1.NodeSpec{
 					ProviderID: fmt.Sprintf("aws:///%s/%s", aws.StringValue(instance.Placement.AvailabilityZone), aws.StringValue(instance.InstanceId)),
 				},
 				Status: v1.NodeStatus{
-					Allocatable: v1.ResourceList{
-						v1.ResourcePods:   *instanceType.Pods(),
-						v1.ResourceCPU:    *instanceType.CPU(),
-						v1.ResourceMemory: *instanceType.Memory(),
-					},
-					Capacity: v1.ResourceList{
-						v1.ResourcePods:   *instanceType.Pods(),
-						v1.ResourceCPU:    *instanceType.CPU(),
-						v1.ResourceMemory: *instanceType.Memory(),
-					},
+					Allocatable: resources.Merge(instanceType.ExtendedResources(), v1.ResourceList{
+						v1.ResourcePods:             *instanceType.Pods(),
+						v1.ResourceCPU:              *instanceType.CPU(),
+						v1.ResourceMemory:           *instanceType.Memory(),
+						v1.ResourceEphemeralStorage: *instanceType.EphemeralStorage(),
+					}),
+					Capacity: resources.Merge(instanceType.ExtendedResources(), v1.ResourceList{
+						v1.ResourcePods:             *instanceType.Pods(),
+						v1.ResourceCPU:              *instanceType.CPU(),
+						v1.ResourceMemory:           *instanceType.Memory(),
+						v1.ResourceEphemeralStorage: *instanceType.EphemeralStorage(),
+					}),
 					NodeInfo: v1.NodeSystemInfo{
-						Architecture:    v1alpha1.AWSToKubeArchitectures[aws.StringValue(instance.Architecture)],
+						Architecture:    instanceType.Architecture(),
 						OSImage:         aws.StringValue(instance.ImageId),
-						OperatingSystem: v1alpha5.OperatingSystemLinux,
+						OperatingSystem: operatingSystem,
 					},
 				},
 			}, nil
@@ -279,16 +680,35 @@ func (p *InstanceProvider) updateUnavailableOfferingsCache(ctx context.Context,
 	}
 }
 
+// updateSubnets accounts for the IP addresses used by the launched instances,
+// and returns true if any subnets were newly found to have no available
+// addresses.
+func (p *InstanceProvider) updateSubnets(createFleetOutput *ec2.CreateFleetOutput) (exhausted bool) {
+	for _, reservation := range createFleetOutput.Instances {
+		if reservation.LaunchTemplateAndOverrides != nil && reservation.LaunchTemplateAndOverrides.Overrides != nil {
+			p.subnetProvider.Launched(aws.StringValue(reservation.LaunchTemplateAndOverrides.Overrides.SubnetId), len(reservation.InstanceIds))
+		}
+	}
+	for _, err := range createFleetOutput.Errors {
+		if InsufficientFreeAddressesErrorCode == aws.StringValue(err.ErrorCode) && err.LaunchTemplateAndOverrides != nil && err.LaunchTemplateAndOverrides.Overrides != nil {
+			exhausted = p.subnetProvider.Exhausted(aws.StringValue(err.LaunchTemplateAndOverrides.Overrides.SubnetId)) || exhausted
+		}
+	}
+	return exhausted
+}
+
 // getCapacityType selects spot if both constraints are flexible and there is an
 // available offering. The AWS Cloud Provider defaults to [ on-demand ], so spot
-// must be explicitly included in capacity type requirements.
+// must be explicitly included in capacity type requirements. Instances that
+// aren't on shared hardware are only available as on-demand capacity.
 func (p *InstanceProvider) getCapacityType(constraints *v1alpha1.Constraints, instanceTypes []cloudprovider.InstanceType) string {
+	if constraints.Tenancy != nil && aws.StringValue(constraints.Tenancy) != ec2.TenancyDefault {
+		return v1alpha1.CapacityTypeOnDemand
+	}
 	if constraints.Requirements.CapacityTypes().Has(v1alpha1.CapacityTypeSpot) {
 		for _, instanceType := range instanceTypes {
-			for _, offering := range instanceType.Offerings() {
-				if constraints.Requirements.Zones().Has(offering.Zone) && offering.CapacityType == v1alpha1.CapacityTypeSpot {
-					return v1alpha1.CapacityTypeSpot
-				}
+			if len(instanceType.Offerings().Compatible(constraints.Requirements.Zones(), sets.NewString(v1alpha1.CapacityTypeSpot))) > 0 {
+				return v1alpha1.CapacityTypeSpot
 			}
 		}
 	}
@@ -305,13 +725,34 @@ func getInstanceID(node *v1.Node) (*string, error) {
 
 func combineFleetErrors(errors []*ec2.CreateFleetError) (errs error) {
 	unique := sets.NewString()
+	codes := sets.NewString()
 	for _, err := range errors {
 		unique.Insert(fmt.Sprintf("%s: %s", aws.StringValue(err.ErrorCode), aws.StringValue(err.ErrorMessage)))
+		codes.Insert(aws.StringValue(err.ErrorCode))
 	}
 	for errorCode := range unique {
 		errs = multierr.Append(errs, fmt.Errorf(errorCode))
 	}
-	return fmt.Errorf("with fleet error(s), %w", errs)
+	return cloudprovider.NewCodedError(codes.List(), fmt.Errorf("with fleet error(s), %w", errs))
+}
+
+// isInsufficientCapacity returns true if all of the fleet errors are due to insufficient capacity
+func isInsufficientCapacity(errors []*ec2.CreateFleetError) bool {
+	for _, err := range errors {
+		if InsufficientCapacityErrorCode != aws.StringValue(err.ErrorCode) {
+			return false
+		}
+	}
+	return len(errors) > 0
+}
+
+func hasOverrides(launchTemplateConfigs []*ec2.FleetLaunchTemplateConfigRequest) bool {
+	for _, launchTemplateConfig := range launchTemplateConfigs {
+		if len(launchTemplateConfig.Overrides) > 0 {
+			return true
+		}
+	}
+	return false
 }
 
 func getCapacityType(instance *ec2.Instance) string {
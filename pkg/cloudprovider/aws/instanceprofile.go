@@ -0,0 +1,141 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/cloudprovider/aws/apis/v1alpha1"
+	"github.com/aws/karpenter/pkg/utils/injection"
+	"github.com/patrickmn/go-cache"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"knative.dev/pkg/logging"
+)
+
+const (
+	// InstanceProfileNotFoundReason is reported on the provisioner if its
+	// instance profile does not exist
+	InstanceProfileNotFoundReason = "InstanceProfileNotFound"
+	// InstanceProfileMissingPoliciesReason is reported on the provisioner if
+	// its instance profile has no role, so nodes have no policies
+	InstanceProfileMissingPoliciesReason = "InstanceProfileMissingPolicies"
+)
+
+// requiredNodePolicies are the AWS managed policies that grant nodes the
+// permissions to join the cluster and pull images. Roles may grant the same
+// permissions with custom or inline policies instead, so roles without them
+// are only warned about.
+var requiredNodePolicies = []string{
+	"AmazonEKSWorkerNodePolicy",
+	"AmazonEC2ContainerRegistryReadOnly",
+}
+
+type InstanceProfileProvider struct {
+	iamapi iamiface.IAMAPI
+	cache  *cache.Cache
+}
+
+func NewInstanceProfileProvider(iamapi iamiface.IAMAPI) *InstanceProfileProvider {
+	return &InstanceProfileProvider{
+		iamapi: iamapi,
+		cache:  cache.New(CacheTTL, CacheCleanupInterval),
+	}
+}
+
+// Get returns the instance profile with the given name, or an error if it does not exist
+func (p *InstanceProfileProvider) Get(ctx context.Context, name string) (*iam.InstanceProfile, error) {
+	if instanceProfile, ok := p.cache.Get(name); ok {
+		return instanceProfile.(*iam.InstanceProfile), nil
+	}
+	output, err := p.iamapi.GetInstanceProfileWithContext(ctx, &iam.GetInstanceProfileInput{InstanceProfileName: aws.String(name)})
+	if err != nil {
+		return nil, fmt.Errorf("getting instance profile %s, %w", name, err)
+	}
+	p.cache.SetDefault(name, output.InstanceProfile)
+	logging.FromContext(ctx).Debugf("Discovered instance profile %s", aws.StringValue(output.InstanceProfile.Arn))
+	return output.InstanceProfile, nil
+}
+
+// Verify returns a configuration error if the instance profile does not exist,
+// or if it has no role. It warns if the role is missing the managed policies
+// that nodes need to join the cluster. Policies are not checked if the
+// controller isn't permitted to list them, and nothing is checked if IAM is
+// unreachable, e.g. in clusters without internet access, since IAM has no VPC
+// endpoints.
+func (p *InstanceProfileProvider) Verify(ctx context.Context, name string) error {
+	instanceProfile, err := p.Get(ctx, name)
+	if err != nil {
+		if isNotFound(err) {
+			return cloudprovider.NewConfigurationError(InstanceProfileNotFoundReason, err)
+		}
+		if isUnreachable(err) {
+			logging.FromContext(ctx).Debugf("Skipping verification of instance profile %s, %s", name, err.Error())
+			return nil
+		}
+		return err
+	}
+	if len(instanceProfile.Roles) == 0 {
+		return cloudprovider.NewConfigurationError(InstanceProfileMissingPoliciesReason, fmt.Errorf("instance profile %s has no role", name))
+	}
+	for _, role := range instanceProfile.Roles {
+		missing, err := p.missingPolicies(ctx, aws.StringValue(role.RoleName))
+		if isAccessDenied(err) {
+			logging.FromContext(ctx).Debugf("Skipping verification of the policies of role %s, %s", aws.StringValue(role.RoleName), err.Error())
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if len(missing) != 0 {
+			logging.FromContext(ctx).Warnf("Role %s of instance profile %s is missing policies %v, nodes may fail to join the cluster unless other policies grant their permissions", aws.StringValue(role.RoleName), name, missing)
+		}
+	}
+	return nil
+}
+
+// missingPolicies returns the required node policies that aren't attached to
+// the role
+func (p *InstanceProfileProvider) missingPolicies(ctx context.Context, roleName string) ([]string, error) {
+	key := fmt.Sprintf("policies/%s", roleName)
+	if missing, ok := p.cache.Get(key); ok {
+		return missing.([]string), nil
+	}
+	attached := sets.NewString()
+	if err := p.iamapi.ListAttachedRolePoliciesPagesWithContext(ctx, &iam.ListAttachedRolePoliciesInput{RoleName: aws.String(roleName)}, func(output *iam.ListAttachedRolePoliciesOutput, _ bool) bool {
+		for _, policy := range output.AttachedPolicies {
+			attached.Insert(aws.StringValue(policy.PolicyName))
+		}
+		return true
+	}); err != nil {
+		return nil, fmt.Errorf("listing policies of role %s, %w", roleName, err)
+	}
+	missing := sets.NewString(requiredNodePolicies...).Difference(attached).List()
+	p.cache.SetDefault(key, missing)
+	return missing, nil
+}
+
+// instanceProfile returns the instance profile of the provisioner's nodes, which
+// defaults to the instance profile that Karpenter is configured with.
+func instanceProfile(ctx context.Context, constraints *v1alpha1.Constraints) string {
+	if constraints.InstanceProfile != "" {
+		return constraints.InstanceProfile
+	}
+	return injection.GetOptions(ctx).AWSDefaultInstanceProfile
+}
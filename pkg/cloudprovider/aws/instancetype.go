@@ -16,10 +16,13 @@ package aws
 
 import (
 	"fmt"
+	"regexp"
+	"strings"
 
 	"github.com/aws/amazon-vpc-resource-controller-k8s/pkg/aws/vpc"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
 	"github.com/aws/karpenter/pkg/cloudprovider"
 	"github.com/aws/karpenter/pkg/cloudprovider/aws/apis/v1alpha1"
 	"github.com/aws/karpenter/pkg/utils/resources"
@@ -28,24 +31,54 @@ import (
 	"k8s.io/apimachinery/pkg/util/sets"
 )
 
-// EC2VMAvailableMemoryFactor assumes the EC2 VM will consume <7.25% of the memory of a given machine
-const EC2VMAvailableMemoryFactor = .925
+const (
+	// EC2VMAvailableMemoryFactor assumes the EC2 VM will consume <7.25% of the memory of a given machine
+	EC2VMAvailableMemoryFactor = .925
+	// DefaultMaxPods is the kubelet's default maximum number of pods, which is
+	// used if pod density is not limited by ENIs
+	DefaultMaxPods = 110
+	// DefaultRootVolumeSize is the size of the root volume of the EKS optimized
+	// and Bottlerocket AMIs, which the kubelet uses for ephemeral storage
+	DefaultRootVolumeSize = "20Gi"
+	// EvictionNodefsAvailable is the fraction of the kubelet's filesystem that
+	// the EKS optimized AMI's hard eviction threshold keeps available
+	EvictionNodefsAvailable = .1
+)
+
+// generationPattern matches the generation of an instance type's family, e.g.
+// 5 of m5d.large
+var generationPattern = regexp.MustCompile(`^[a-z]+([0-9]+)`)
 
 type InstanceType struct {
 	ec2.InstanceTypeInfo
-	AvailableOfferings []cloudprovider.Offering
+	AvailableOfferings cloudprovider.Offerings
+	// eniLimitedPodDensity is true if the CNI assigns each pod an IP address
+	// from one of the instance's ENIs
+	eniLimitedPodDensity bool
+	// operatingSystem of the provisioner's AMI family, which differs between
+	// provisioners, so it is set on each provisioner's copy of the instance type
+	operatingSystem string
+	// instanceStorePolicy of the provisioner, which determines whether the
+	// instance store volumes are used for ephemeral storage
+	instanceStorePolicy string
+	// ephemeralVolumeSize is the size of the provisioner's volume that holds
+	// the kubelet's filesystem, or nil if its block device mappings don't size it
+	ephemeralVolumeSize *resource.Quantity
 }
 
 func (i *InstanceType) Name() string {
 	return aws.StringValue(i.InstanceType)
 }
 
-func (i *InstanceType) Offerings() []cloudprovider.Offering {
+func (i *InstanceType) Offerings() cloudprovider.Offerings {
 	return i.AvailableOfferings
 }
 
 func (i *InstanceType) OperatingSystems() sets.String {
-	return sets.NewString("linux")
+	if i.operatingSystem == "" {
+		return sets.NewString(v1alpha5.OperatingSystemLinux)
+	}
+	return sets.NewString(i.operatingSystem)
 }
 
 func (i *InstanceType) Architecture() string {
@@ -69,7 +102,37 @@ func (i *InstanceType) Memory() *resource.Quantity {
 	)
 }
 
+// EphemeralStorage returns the size of the volume that holds the kubelet's
+// filesystem, which defaults to the AMI's root volume, or the total size of
+// the NVMe instance store volumes if the provisioner's instance store policy
+// mounts them for the kubelet instead.
+func (i *InstanceType) EphemeralStorage() *resource.Quantity {
+	if i.instanceStorePolicy == v1alpha1.InstanceStorePolicyRAID0 && i.hasNVMeInstanceStore() {
+		return resource.NewScaledQuantity(aws.Int64Value(i.InstanceStorageInfo.TotalSizeInGB), resource.Giga)
+	}
+	if i.ephemeralVolumeSize != nil {
+		return i.ephemeralVolumeSize
+	}
+	return resources.Quantity(DefaultRootVolumeSize)
+}
+
+// hasNVMeInstanceStore returns true if the instance type has instance store
+// volumes that are NVMe devices
+func (i *InstanceType) hasNVMeInstanceStore() bool {
+	return i.InstanceStorageInfo != nil &&
+		aws.Int64Value(i.InstanceStorageInfo.TotalSizeInGB) > 0 &&
+		aws.StringValue(i.InstanceStorageInfo.NvmeSupport) != ec2.EphemeralNvmeSupportUnsupported
+}
+
 func (i *InstanceType) Pods() *resource.Quantity {
+	if !i.eniLimitedPodDensity {
+		return resources.Quantity(fmt.Sprint(DefaultMaxPods))
+	}
+	// Windows nodes only assign pods IP addresses from their primary ENI
+	// https://docs.aws.amazon.com/eks/latest/userguide/windows-support.html
+	if i.operatingSystem == v1alpha5.OperatingSystemWindows {
+		return resources.Quantity(fmt.Sprint(*i.NetworkInfo.Ipv4AddressesPerInterface - 1))
+	}
 	// The number of pods per node is calculated using the formula:
 	// max number of ENIs * (IPv4 Addresses per ENI -1) + 2
 	// https://github.com/awslabs/amazon-eks-ami/blob/master/files/eni-max-pods.txt#L20
@@ -85,11 +148,31 @@ func (i *InstanceType) AWSPodENI() *resource.Quantity {
 	return resources.Quantity("0")
 }
 
+// ExtendedResources returns the Elastic Fabric Adapters of instance types that
+// support them, which are advertised by the EFA device plugin. The well known
+// accelerators are advertised separately.
+func (i *InstanceType) ExtendedResources() v1.ResourceList {
+	extendedResources := v1.ResourceList{}
+	if efas := i.EFAs(); efas > 0 {
+		extendedResources[resources.AWSEFA] = *resources.Quantity(fmt.Sprint(efas))
+	}
+	return extendedResources
+}
+
+// EFAs returns the maximum number of Elastic Fabric Adapters that can be
+// attached to the instance type, or zero if it doesn't support them.
+func (i *InstanceType) EFAs() int64 {
+	if i.NetworkInfo == nil || !aws.BoolValue(i.NetworkInfo.EfaSupported) || i.NetworkInfo.EfaInfo == nil {
+		return 0
+	}
+	return aws.Int64Value(i.NetworkInfo.EfaInfo.MaximumEfaInterfaces)
+}
+
 func (i *InstanceType) NvidiaGPUs() *resource.Quantity {
 	count := int64(0)
 	if i.GpuInfo != nil {
 		for _, gpu := range i.GpuInfo.Gpus {
-			if *i.GpuInfo.Gpus[0].Manufacturer == "NVIDIA" {
+			if aws.StringValue(gpu.Manufacturer) == "NVIDIA" {
 				count += *gpu.Count
 			}
 		}
@@ -101,7 +184,7 @@ func (i *InstanceType) AMDGPUs() *resource.Quantity {
 	count := int64(0)
 	if i.GpuInfo != nil {
 		for _, gpu := range i.GpuInfo.Gpus {
-			if *i.GpuInfo.Gpus[0].Manufacturer == "AMD" {
+			if aws.StringValue(gpu.Manufacturer) == "AMD" {
 				count += *gpu.Count
 			}
 		}
@@ -119,21 +202,69 @@ func (i *InstanceType) AWSNeurons() *resource.Quantity {
 	return resources.Quantity(fmt.Sprint(count))
 }
 
+// HabanaGaudis returns the number of Gaudi accelerators, which EC2 describes
+// as GPUs, e.g. on dl1 instance types.
+func (i *InstanceType) HabanaGaudis() *resource.Quantity {
+	count := int64(0)
+	if i.GpuInfo != nil {
+		for _, gpu := range i.GpuInfo.Gpus {
+			if aws.StringValue(gpu.Manufacturer) == "Habana" {
+				count += *gpu.Count
+			}
+		}
+	}
+	return resources.Quantity(fmt.Sprint(count))
+}
+
+// Labels describe the instance type's vCPUs, memory, generation, hypervisor,
+// local NVMe storage and GPUs. The generation, hypervisor and GPU manufacturer
+// are left out if the instance type doesn't have them, e.g. bare metal
+// instance types have no hypervisor.
+func (i *InstanceType) Labels() map[string]string {
+	labels := map[string]string{
+		v1alpha1.LabelInstanceCPU:       fmt.Sprint(aws.Int64Value(i.VCpuInfo.DefaultVCpus)),
+		v1alpha1.LabelInstanceMemory:    fmt.Sprint(aws.Int64Value(i.MemoryInfo.SizeInMiB)),
+		v1alpha1.LabelInstanceLocalNVMe: "0",
+		v1alpha1.LabelInstanceGPUCount:  "0",
+	}
+	if match := generationPattern.FindStringSubmatch(i.Name()); match != nil {
+		labels[v1alpha1.LabelInstanceGeneration] = match[1]
+	}
+	if hypervisor := aws.StringValue(i.Hypervisor); hypervisor != "" {
+		labels[v1alpha1.LabelInstanceHypervisor] = hypervisor
+	}
+	if i.hasNVMeInstanceStore() {
+		labels[v1alpha1.LabelInstanceLocalNVMe] = fmt.Sprint(aws.Int64Value(i.InstanceStorageInfo.TotalSizeInGB))
+	}
+	if i.GpuInfo != nil && len(i.GpuInfo.Gpus) > 0 {
+		count := int64(0)
+		for _, gpu := range i.GpuInfo.Gpus {
+			count += aws.Int64Value(gpu.Count)
+		}
+		labels[v1alpha1.LabelInstanceGPUCount] = fmt.Sprint(count)
+		labels[v1alpha1.LabelInstanceGPUManufacturer] = strings.ToLower(aws.StringValue(i.GpuInfo.Gpus[0].Manufacturer))
+	}
+	return labels
+}
+
 // Overhead computes overhead for https://kubernetes.io/docs/tasks/administer-cluster/reserve-compute-resources/#node-allocatable
 // using calculations copied from https://github.com/bottlerocket-os/bottlerocket#kubernetes-settings
-func (i *InstanceType) Overhead() v1.ResourceList {
-	overhead := v1.ResourceList{
-		v1.ResourceCPU: *resource.NewMilliQuantity(
-			100, // system-reserved
-			resource.DecimalSI),
-		v1.ResourceMemory: resource.MustParse(fmt.Sprintf("%dMi",
-			// kube-reserved
-			((11*i.Pods().Value())+255)+
-				// system-reserved
-				100+
-				// eviction threshold https://github.com/kubernetes/kubernetes/blob/ea0764452222146c47ec826977f49d7001b0ea8c/pkg/kubelet/apis/config/v1beta1/defaults_linux.go#L23
-				100,
-		)),
+func (i *InstanceType) Overhead() cloudprovider.InstanceTypeOverhead {
+	overhead := cloudprovider.InstanceTypeOverhead{
+		KubeReserved: v1.ResourceList{
+			v1.ResourceCPU:    *resource.NewMilliQuantity(0, resource.DecimalSI),
+			v1.ResourceMemory: resource.MustParse(fmt.Sprintf("%dMi", (11*i.Pods().Value())+255)),
+		},
+		SystemReserved: v1.ResourceList{
+			v1.ResourceCPU:    *resource.NewMilliQuantity(100, resource.DecimalSI),
+			v1.ResourceMemory: resource.MustParse("100Mi"),
+		},
+		EvictionThreshold: v1.ResourceList{
+			// https://github.com/kubernetes/kubernetes/blob/ea0764452222146c47ec826977f49d7001b0ea8c/pkg/kubelet/apis/config/v1beta1/defaults_linux.go#L23
+			v1.ResourceMemory: resource.MustParse("100Mi"),
+			// https://github.com/awslabs/amazon-eks-ami/blob/master/files/kubelet-config.json
+			v1.ResourceEphemeralStorage: *resource.NewQuantity(int64(float64(i.EphemeralStorage().Value())*EvictionNodefsAvailable), resource.BinarySI),
+		},
 	}
 	// kube-reserved Computed from
 	// https://github.com/bottlerocket-os/bottlerocket/pull/1388/files#diff-bba9e4e3e46203be2b12f22e0d654ebd270f0b478dd34f40c31d7aa695620f2fR611
@@ -152,7 +283,9 @@ func (i *InstanceType) Overhead() v1.ResourceList {
 			if cpu < cpuRange.end {
 				r = float64(cpu - cpuRange.start)
 			}
-			overhead.Cpu().Add(*resource.NewMilliQuantity(int64(r*cpuRange.percentage), resource.DecimalSI))
+			reserved := overhead.KubeReserved[v1.ResourceCPU]
+			reserved.Add(*resource.NewMilliQuantity(int64(r*cpuRange.percentage), resource.DecimalSI))
+			overhead.KubeReserved[v1.ResourceCPU] = reserved
 		}
 	}
 	return overhead
@@ -17,17 +17,27 @@ package aws
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/outposts"
+	"github.com/aws/aws-sdk-go/service/outposts/outpostsiface"
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
 	"github.com/aws/karpenter/pkg/cloudprovider"
 	"github.com/aws/karpenter/pkg/cloudprovider/aws/apis/v1alpha1"
+	"github.com/aws/karpenter/pkg/metrics"
 	"github.com/aws/karpenter/pkg/utils/functional"
+	"github.com/aws/karpenter/pkg/utils/injection"
 	"github.com/patrickmn/go-cache"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/multierr"
+	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"knative.dev/pkg/logging"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
 )
 
 const (
@@ -36,11 +46,18 @@ const (
 	InstanceTypesAndZonesCacheTTL                 = 5 * time.Minute
 	InsufficientCapacityErrorCacheTTL             = 45 * time.Second
 	InsufficientCapacityErrorCacheCleanupInterval = 5 * time.Minute
+	// InstanceTypesRefreshInterval is how often instance types, their zonal
+	// offerings and prices are refreshed in the background. Cached values that
+	// expire before the next refresh are refreshed, so that provisioning
+	// doesn't wait for them to be retrieved.
+	InstanceTypesRefreshInterval = 1 * time.Minute
 )
 
 type InstanceTypeProvider struct {
-	ec2api         ec2iface.EC2API
-	subnetProvider *SubnetProvider
+	ec2api          ec2iface.EC2API
+	outpostsapi     outpostsiface.OutpostsAPI
+	subnetProvider  *SubnetProvider
+	pricingProvider *PricingProvider
 	// Has two entries: one for all the instance types and one for all zones; values cached *before* considering insufficient capacity errors
 	// from the unavailableOfferings cache
 	cache *cache.Cache
@@ -48,13 +65,24 @@ type InstanceTypeProvider struct {
 	unavailableOfferings *cache.Cache
 }
 
-func NewInstanceTypeProvider(ec2api ec2iface.EC2API, subnetProvider *SubnetProvider) *InstanceTypeProvider {
-	return &InstanceTypeProvider{
+func NewInstanceTypeProvider(ec2api ec2iface.EC2API, outpostsapi outpostsiface.OutpostsAPI, subnetProvider *SubnetProvider, pricingProvider *PricingProvider) *InstanceTypeProvider {
+	p := &InstanceTypeProvider{
 		ec2api:               ec2api,
+		outpostsapi:          outpostsapi,
 		subnetProvider:       subnetProvider,
+		pricingProvider:      pricingProvider,
 		cache:                cache.New(InstanceTypesAndZonesCacheTTL, CacheCleanupInterval),
 		unavailableOfferings: cache.New(InsufficientCapacityErrorCacheTTL, InsufficientCapacityErrorCacheCleanupInterval),
 	}
+	p.cache.OnEvicted(func(key string, _ interface{}) {
+		if key == InstanceTypesCacheKey {
+			instanceTypesCacheSizeGauge.Set(0)
+		}
+	})
+	p.unavailableOfferings.OnEvicted(func(string, interface{}) {
+		unavailableOfferingsCacheSizeGauge.Set(float64(p.unavailableOfferings.ItemCount()))
+	})
+	return p
 }
 
 // Get all instance type options (the constraints are only used for tag filtering on subnets, not for Requirements filtering)
@@ -70,43 +98,209 @@ func (p *InstanceTypeProvider) Get(ctx context.Context, provider *v1alpha1.AWS)
 		return nil, err
 	}
 	subnetZones := sets.NewString()
+	outpostARNs := sets.NewString()
 	for _, subnet := range subnets {
-		subnetZones.Insert(aws.StringValue(subnet.AvailabilityZone))
+		// Instances can't be launched into zones whose subnets have no available IP addresses
+		if aws.Int64Value(subnet.AvailableIpAddressCount) > 0 {
+			subnetZones.Insert(aws.StringValue(subnet.AvailabilityZone))
+		}
+		if subnet.OutpostArn != nil {
+			outpostARNs.Insert(aws.StringValue(subnet.OutpostArn))
+		}
 	}
 	// Get Viable EC2 Purchase offerings
-	instanceTypeZones, err := p.getInstanceTypeZones(ctx)
+	instanceTypeZones, err := p.getInstanceTypeZones(ctx, subnetZones)
 	if err != nil {
 		return nil, err
 	}
+	// Outposts are only selected by ARN, so either all of the subnets are on
+	// Outposts or none of them are
+	var outpostInstanceTypes sets.String
+	if outpostARNs.Len() > 0 {
+		if outpostInstanceTypes, err = p.getOutpostInstanceTypes(ctx, outpostARNs); err != nil {
+			return nil, err
+		}
+	}
+	prices := p.pricingProvider.Get(ctx)
 	result := []cloudprovider.InstanceType{}
 	for _, instanceType := range instanceTypes {
-		offerings := p.createOfferings(instanceType, subnetZones, instanceTypeZones[instanceType.Name()])
+		// The Windows AMIs are only built for amd64
+		if provider.OperatingSystem() == v1alpha5.OperatingSystemWindows && instanceType.Architecture() != v1alpha5.ArchitectureAmd64 {
+			continue
+		}
+		if outpostInstanceTypes != nil && !outpostInstanceTypes.Has(instanceType.Name()) {
+			continue
+		}
+		offerings := p.createOfferings(instanceType, subnetZones, instanceTypeZones[instanceType.Name()], prices)
+		// Outposts don't support spot instances
+		if outpostInstanceTypes != nil {
+			offerings = onDemandOfferings(offerings)
+		}
 		if len(offerings) > 0 {
-			instanceType.AvailableOfferings = offerings
-			result = append(result, instanceType)
+			// Cached instance types are shared by provisioners, whose offerings,
+			// operating systems and volumes differ, so each provisioner gets its
+			// own copy
+			available := *instanceType
+			available.AvailableOfferings = offerings
+			available.operatingSystem = provider.OperatingSystem()
+			available.instanceStorePolicy = aws.StringValue(provider.InstanceStorePolicy)
+			available.ephemeralVolumeSize = ephemeralVolumeSize(provider)
+			result = append(result, &available)
 		}
 	}
 	return result, nil
 }
 
-func (p *InstanceTypeProvider) createOfferings(instanceType *InstanceType, subnetZones sets.String, availableZones sets.String) []cloudprovider.Offering {
+// Refresh retrieves the instance types and their zonal offerings and prices
+// that expire before the next refresh, so that they are retrieved in the
+// background rather than when provisioning.
+func (p *InstanceTypeProvider) Refresh(ctx context.Context) (errs error) {
+	if expiresWithin(p.cache, InstanceTypesCacheKey, InstanceTypesRefreshInterval) {
+		if _, err := p.updateInstanceTypes(ctx); err != nil {
+			errs = multierr.Append(errs, err)
+		}
+	}
+	if expiresWithin(p.cache, InstanceTypeZonesCacheKey, InstanceTypesRefreshInterval) {
+		if _, err := p.updateInstanceTypeZones(ctx); err != nil {
+			errs = multierr.Append(errs, err)
+		}
+	}
+	p.pricingProvider.Refresh(ctx)
+	return errs
+}
+
+func (p *InstanceTypeProvider) createOfferings(instanceType *InstanceType, subnetZones sets.String, availableZones sets.String, prices Prices) []cloudprovider.Offering {
 	offerings := []cloudprovider.Offering{}
 	for zone := range subnetZones.Intersection(availableZones) {
 		// while usage classes should be a distinct set, there's no guarantee of that
 		for capacityType := range sets.NewString(aws.StringValueSlice(instanceType.SupportedUsageClasses)...) {
 			// exclude any offerings that have recently seen an insufficient capacity error from EC2
 			if _, isUnavailable := p.unavailableOfferings.Get(UnavailableOfferingsCacheKey(capacityType, instanceType.Name(), zone)); !isUnavailable {
-				offerings = append(offerings, cloudprovider.Offering{Zone: zone, CapacityType: capacityType})
+				offerings = append(offerings, cloudprovider.Offering{
+					Zone:         zone,
+					CapacityType: capacityType,
+					Price:        prices.Price(instanceType.Name(), zone, capacityType),
+				})
 			}
 		}
 	}
 	return offerings
 }
 
-func (p *InstanceTypeProvider) getInstanceTypeZones(ctx context.Context) (map[string]sets.String, error) {
+// onDemandOfferings returns the offerings with the on-demand capacity type
+func onDemandOfferings(offerings []cloudprovider.Offering) []cloudprovider.Offering {
+	result := []cloudprovider.Offering{}
+	for _, offering := range offerings {
+		if offering.CapacityType == v1alpha1.CapacityTypeOnDemand {
+			result = append(result, offering)
+		}
+	}
+	return result
+}
+
+// getInstanceTypeZones returns the zones that offer each instance type. Zones
+// that aren't in the region's offerings, such as Local Zones, are described
+// separately, since offerings are only returned for them when they are
+// requested by location.
+func (p *InstanceTypeProvider) getInstanceTypeZones(ctx context.Context, zones sets.String) (map[string]sets.String, error) {
+	var instanceTypeZones map[string]sets.String
 	if cached, ok := p.cache.Get(InstanceTypeZonesCacheKey); ok {
-		return cached.(map[string]sets.String), nil
+		instanceTypeZones = cached.(map[string]sets.String)
+	} else {
+		var err error
+		if instanceTypeZones, err = p.updateInstanceTypeZones(ctx); err != nil {
+			return nil, err
+		}
+	}
+	offered := sets.NewString()
+	for _, instanceTypeZones := range instanceTypeZones {
+		offered = offered.Union(instanceTypeZones)
+	}
+	missing := zones.Difference(offered)
+	if missing.Len() == 0 {
+		return instanceTypeZones, nil
+	}
+	// Merge into a copy, since the cached offerings are shared by provisioners
+	merged := map[string]sets.String{}
+	for instanceType, instanceTypeZones := range instanceTypeZones {
+		merged[instanceType] = sets.NewString(instanceTypeZones.UnsortedList()...)
+	}
+	for _, zone := range missing.List() {
+		zoneInstanceTypes, err := p.getZoneInstanceTypes(ctx, zone)
+		if err != nil {
+			return nil, err
+		}
+		for instanceType := range zoneInstanceTypes {
+			if _, ok := merged[instanceType]; !ok {
+				merged[instanceType] = sets.NewString()
+			}
+			merged[instanceType].Insert(zone)
+		}
+	}
+	return merged, nil
+}
+
+// getZoneInstanceTypes returns the instance types that are offered in the zone
+func (p *InstanceTypeProvider) getZoneInstanceTypes(ctx context.Context, zone string) (sets.String, error) {
+	key := fmt.Sprintf("%s/%s", InstanceTypeZonesCacheKey, zone)
+	if cached, ok := p.cache.Get(key); ok {
+		return cached.(sets.String), nil
+	}
+	instanceTypes := sets.NewString()
+	if err := p.ec2api.DescribeInstanceTypeOfferingsPagesWithContext(ctx, &ec2.DescribeInstanceTypeOfferingsInput{
+		LocationType: aws.String("availability-zone"),
+		Filters:      []*ec2.Filter{{Name: aws.String("location"), Values: []*string{aws.String(zone)}}},
+	}, func(output *ec2.DescribeInstanceTypeOfferingsOutput, lastPage bool) bool {
+		for _, offering := range output.InstanceTypeOfferings {
+			instanceTypes.Insert(aws.StringValue(offering.InstanceType))
+		}
+		return true
+	}); err != nil {
+		return nil, fmt.Errorf("describing instance type offerings in zone %s, %w", zone, err)
+	}
+	logging.FromContext(ctx).Debugf("Discovered %d EC2 instance types offered in zone %s", instanceTypes.Len(), zone)
+	p.cache.SetDefault(key, instanceTypes)
+	return instanceTypes, nil
+}
+
+// getOutpostInstanceTypes returns the instance types that all of the Outposts
+// have capacity for
+func (p *InstanceTypeProvider) getOutpostInstanceTypes(ctx context.Context, outpostARNs sets.String) (sets.String, error) {
+	var result sets.String
+	for _, outpostARN := range outpostARNs.List() {
+		key := fmt.Sprintf("outposts/%s", outpostARN)
+		cached, ok := p.cache.Get(key)
+		if !ok {
+			instanceTypes := sets.NewString()
+			input := &outposts.GetOutpostInstanceTypesInput{OutpostId: aws.String(outpostARN)}
+			for {
+				output, err := p.outpostsapi.GetOutpostInstanceTypesWithContext(ctx, input)
+				if err != nil {
+					return nil, fmt.Errorf("getting instance types of outpost %s, %w", outpostARN, err)
+				}
+				for _, item := range output.InstanceTypes {
+					instanceTypes.Insert(aws.StringValue(item.InstanceType))
+				}
+				if aws.StringValue(output.NextToken) == "" {
+					break
+				}
+				input.NextToken = output.NextToken
+			}
+			logging.FromContext(ctx).Debugf("Discovered %d EC2 instance types on outpost %s", instanceTypes.Len(), outpostARN)
+			p.cache.SetDefault(key, instanceTypes)
+			cached = instanceTypes
+		}
+		if result == nil {
+			result = cached.(sets.String)
+		} else {
+			result = result.Intersection(cached.(sets.String))
+		}
 	}
+	return result, nil
+}
+
+// updateInstanceTypeZones retrieves and caches the zones that offer each instance type
+func (p *InstanceTypeProvider) updateInstanceTypeZones(ctx context.Context) (map[string]sets.String, error) {
 	zones := map[string]sets.String{}
 	if err := p.ec2api.DescribeInstanceTypeOfferingsPagesWithContext(ctx, &ec2.DescribeInstanceTypeOfferingsInput{LocationType: aws.String("availability-zone")},
 		func(output *ec2.DescribeInstanceTypeOfferingsOutput, lastPage bool) bool {
@@ -130,6 +324,11 @@ func (p *InstanceTypeProvider) getInstanceTypes(ctx context.Context) (map[string
 	if cached, ok := p.cache.Get(InstanceTypesCacheKey); ok {
 		return cached.(map[string]*InstanceType), nil
 	}
+	return p.updateInstanceTypes(ctx)
+}
+
+// updateInstanceTypes retrieves and caches the instance types
+func (p *InstanceTypeProvider) updateInstanceTypes(ctx context.Context) (map[string]*InstanceType, error) {
 	instanceTypes := map[string]*InstanceType{}
 	if err := p.ec2api.DescribeInstanceTypesPagesWithContext(ctx, &ec2.DescribeInstanceTypesInput{
 		Filters: []*ec2.Filter{
@@ -141,7 +340,10 @@ func (p *InstanceTypeProvider) getInstanceTypes(ctx context.Context) (map[string
 	}, func(page *ec2.DescribeInstanceTypesOutput, lastPage bool) bool {
 		for _, instanceType := range page.InstanceTypes {
 			if p.filter(instanceType) {
-				instanceTypes[aws.StringValue(instanceType.InstanceType)] = &InstanceType{InstanceTypeInfo: *instanceType}
+				instanceTypes[aws.StringValue(instanceType.InstanceType)] = &InstanceType{
+					InstanceTypeInfo:     *instanceType,
+					eniLimitedPodDensity: injection.GetOptions(ctx).AWSENILimitedPodDensity,
+				}
 			}
 		}
 		return true
@@ -150,6 +352,7 @@ func (p *InstanceTypeProvider) getInstanceTypes(ctx context.Context) (map[string
 	}
 	logging.FromContext(ctx).Debugf("Discovered %d EC2 instance types", len(instanceTypes))
 	p.cache.SetDefault(InstanceTypesCacheKey, instanceTypes)
+	instanceTypesCacheSizeGauge.Set(float64(len(instanceTypes)))
 	return instanceTypes, nil
 }
 
@@ -165,7 +368,7 @@ func (p *InstanceTypeProvider) filter(instanceType *ec2.InstanceTypeInfo) bool {
 	return functional.HasAnyPrefix(aws.StringValue(instanceType.InstanceType),
 		"m", "c", "r", "a", // Standard
 		"t3", "t4", // Burstable
-		"p", "inf", "g", // Accelerators
+		"p", "inf", "g", "dl", // Accelerators
 	)
 }
 
@@ -180,8 +383,61 @@ func (p *InstanceTypeProvider) CacheUnavailable(ctx context.Context, instanceTyp
 		InsufficientCapacityErrorCacheTTL)
 	// even if the key is already in the cache, we still need to call Set to extend the cached entry's TTL
 	p.unavailableOfferings.SetDefault(UnavailableOfferingsCacheKey(capacityType, instanceType, zone), struct{}{})
+	unavailableOfferingsCacheSizeGauge.Set(float64(p.unavailableOfferings.ItemCount()))
+}
+
+// expiresWithin returns true if the key is not cached, or expires within the duration
+func expiresWithin(c *cache.Cache, key string, duration time.Duration) bool {
+	_, expiration, ok := c.GetWithExpiration(key)
+	return !ok || time.Until(expiration) < duration
 }
 
 func UnavailableOfferingsCacheKey(capacityType string, instanceType string, zone string) string {
 	return fmt.Sprintf("%s:%s:%s", capacityType, instanceType, zone)
 }
+
+var (
+	instanceTypesCacheSizeGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: "cloudprovider",
+			Name:      "instance_types_cache_size",
+			Help:      "Number of instance types cached from the EC2 DescribeInstanceTypes API.",
+		},
+	)
+	unavailableOfferingsCacheSizeGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: "cloudprovider",
+			Name:      "unavailable_offerings_cache_size",
+			Help:      "Number of offerings cached as unavailable due to insufficient capacity errors.",
+		},
+	)
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(instanceTypesCacheSizeGauge, unavailableOfferingsCacheSizeGauge)
+}
+
+// withDefaultInstanceFamilies filters the instance types to the default
+// instance families of the settings, unless the requirements constrain
+// instance types by name or by their attributes.
+func withDefaultInstanceFamilies(ctx context.Context, requirements v1alpha5.Requirements, instanceTypes []cloudprovider.InstanceType) []cloudprovider.InstanceType {
+	families := injection.GetSettings(ctx).DefaultInstanceFamilies
+	if families.Len() == 0 {
+		return instanceTypes
+	}
+	constrainingKeys := sets.NewString(v1alpha1.InstanceTypeLabels...).Insert(v1.LabelInstanceTypeStable)
+	for _, requirement := range requirements {
+		if constrainingKeys.Has(requirement.Key) {
+			return instanceTypes
+		}
+	}
+	result := []cloudprovider.InstanceType{}
+	for _, instanceType := range instanceTypes {
+		if families.Has(strings.SplitN(instanceType.Name(), ".", 2)[0]) {
+			result = append(result, instanceType)
+		}
+	}
+	return result
+}
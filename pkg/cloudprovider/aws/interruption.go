@@ -0,0 +1,315 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/audit"
+	"github.com/aws/karpenter/pkg/cloudprovider/aws/apis/v1alpha1"
+	"github.com/aws/karpenter/pkg/utils/featuregates"
+	"github.com/aws/karpenter/pkg/utils/functional"
+	"github.com/aws/karpenter/pkg/utils/injection"
+	karpenterlogging "github.com/aws/karpenter/pkg/utils/logging"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"knative.dev/pkg/logging"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const (
+	interruptionControllerName = "interruption"
+	// SpotInterruptionWarning is sent two minutes before EC2 reclaims a spot instance
+	SpotInterruptionWarning = "EC2 Spot Instance Interruption Warning"
+	// RebalanceRecommendation is sent when a spot instance is at an elevated
+	// risk of interruption, which may be before its interruption warning
+	RebalanceRecommendation = "EC2 Instance Rebalance Recommendation"
+	// InterruptionBatchSize is the maximum number of messages received from the
+	// queue at a time, which is limited to 10 by SQS
+	InterruptionBatchSize = 10
+	// InterruptionWaitTime is the duration that receiving messages from the
+	// queue waits for a message to arrive, which is limited to 20s by SQS
+	InterruptionWaitTime = 20 * time.Second
+	// InterruptionRetryInterval is the duration between attempts to receive
+	// messages after failing to do so
+	InterruptionRetryInterval = 10 * time.Second
+)
+
+// interruptionEvent is the part of an EventBridge event about an EC2 instance
+// that is used to find the affected node
+type interruptionEvent struct {
+	DetailType string `json:"detail-type"`
+	Detail     struct {
+		InstanceID string `json:"instance-id"`
+	} `json:"detail"`
+}
+
+// InterruptionController drains nodes ahead of their interruption. It receives
+// spot interruption warnings and rebalance recommendations from an SQS queue
+// that EventBridge forwards them to, and deletes the affected nodes so that the
+// termination controller cordons and drains them before EC2 reclaims them. The
+// evicted pods are provisioned on replacement capacity while the node drains.
+// Nodes whose rebalance recommendation policy is Replace are annotated to be
+// replaced instead, which launches their replacement capacity before draining
+// them, and rebalance recommendations of nodes whose policy is Ignore are
+// ignored.
+// The queue of the settings takes precedence over the queue that the
+// controller is constructed with, and interruptions aren't received while
+// neither is configured or the SpotInterruption feature is disabled.
+type InterruptionController struct {
+	kubeClient           client.Client
+	sqsapi               sqsiface.SQSAPI
+	instanceTypeProvider *InstanceTypeProvider
+	queueName            string
+	queueURLs            map[string]string
+	interrupted          chan event.GenericEvent
+}
+
+// NewInterruptionController constructs a controller instance
+func NewInterruptionController(kubeClient client.Client, sqsapi sqsiface.SQSAPI, instanceTypeProvider *InstanceTypeProvider, queueName string) *InterruptionController {
+	return &InterruptionController{
+		kubeClient:           kubeClient,
+		sqsapi:               sqsapi,
+		instanceTypeProvider: instanceTypeProvider,
+		queueName:            queueName,
+		queueURLs:            map[string]string{},
+		interrupted:          make(chan event.GenericEvent, InterruptionBatchSize),
+	}
+}
+
+// Reconcile deletes a node that is about to be interrupted
+func (c *InterruptionController) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	ctx = karpenterlogging.WithReconcile(ctx, interruptionControllerName, "node", req.Name)
+	node := &v1.Node{}
+	if err := c.kubeClient.Get(ctx, req.NamespacedName, node); err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+	if !node.DeletionTimestamp.IsZero() {
+		return reconcile.Result{}, nil
+	}
+	if err := c.kubeClient.Delete(ctx, node); err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, fmt.Errorf("deleting node, %w", err)
+	}
+	audit.Deleted(ctx, node, "interrupted", nil)
+	logging.FromContext(ctx).Infof("Deleted node ahead of its interruption")
+	return reconcile.Result{}, nil
+}
+
+// Register the controller, along with the runnable that receives messages from
+// the queue. Both only run on the leader.
+func (c *InterruptionController) Register(_ context.Context, m manager.Manager) error {
+	interruptionController, err := controller.New(interruptionControllerName, m, controller.Options{
+		Reconciler:              c,
+		MaxConcurrentReconciles: 10,
+	})
+	if err != nil {
+		return err
+	}
+	if err := interruptionController.Watch(&source.Channel{Source: c.interrupted}, &handler.EnqueueRequestForObject{}); err != nil {
+		return err
+	}
+	return m.Add(manager.RunnableFunc(c.poll))
+}
+
+// poll receives messages from the queue until the context is cancelled. The
+// queue is looked up before every receive, so that changes to the settings
+// take effect without restarting.
+func (c *InterruptionController) poll(ctx context.Context) error {
+	ctx = karpenterlogging.WithController(ctx, interruptionControllerName)
+	wait := func() {
+		select {
+		case <-ctx.Done():
+		case <-time.After(InterruptionRetryInterval):
+		}
+	}
+	queueName := ""
+	for ctx.Err() == nil {
+		if current := c.getQueueName(ctx); current != queueName {
+			if current == "" {
+				logging.FromContext(ctx).With("queue", queueName).Infof("Stopped receiving interruption messages")
+			} else {
+				logging.FromContext(ctx).With("queue", current).Infof("Receiving interruption messages")
+			}
+			queueName = current
+		}
+		if queueName == "" {
+			wait()
+			continue
+		}
+		if err := c.receive(ctx); err != nil && ctx.Err() == nil {
+			logging.FromContext(ctx).With("queue", queueName).Errorf("Receiving interruption messages, %s", err.Error())
+			wait()
+		}
+	}
+	return nil
+}
+
+// receive handles a batch of messages from the queue. Messages that fail to be
+// handled are left on the queue, which makes them visible again once their
+// visibility timeout expires.
+func (c *InterruptionController) receive(ctx context.Context) error {
+	queueName := c.getQueueName(ctx)
+	if queueName == "" {
+		return nil
+	}
+	queueURL, err := c.getQueueURL(ctx, queueName)
+	if err != nil {
+		return err
+	}
+	output, err := c.sqsapi.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:            aws.String(queueURL),
+		MaxNumberOfMessages: aws.Int64(InterruptionBatchSize),
+		WaitTimeSeconds:     aws.Int64(int64(InterruptionWaitTime.Seconds())),
+	})
+	if err != nil {
+		return fmt.Errorf("receiving messages, %w", err)
+	}
+	for _, message := range output.Messages {
+		if err := c.handle(ctx, message); err != nil {
+			logging.FromContext(ctx).Errorf("Handling message %s, %s", aws.StringValue(message.MessageId), err.Error())
+			continue
+		}
+		if _, err := c.sqsapi.DeleteMessageWithContext(ctx, &sqs.DeleteMessageInput{
+			QueueUrl:      aws.String(queueURL),
+			ReceiptHandle: message.ReceiptHandle,
+		}); err != nil {
+			logging.FromContext(ctx).Errorf("Deleting message %s, %s", aws.StringValue(message.MessageId), err.Error())
+		}
+	}
+	return nil
+}
+
+// handle finds the node that a message is about and enqueues it to be deleted.
+// Messages that are malformed, are not about an interruption or are not about
+// a node launched by Karpenter are ignored.
+func (c *InterruptionController) handle(ctx context.Context, message *sqs.Message) error {
+	interruption := interruptionEvent{}
+	if err := json.Unmarshal([]byte(aws.StringValue(message.Body)), &interruption); err != nil {
+		logging.FromContext(ctx).Errorf("Ignoring malformed message %s, %s", aws.StringValue(message.MessageId), err.Error())
+		return nil
+	}
+	if interruption.DetailType != SpotInterruptionWarning && interruption.DetailType != RebalanceRecommendation {
+		logging.FromContext(ctx).Debugf("Ignoring message %s with detail type %q", aws.StringValue(message.MessageId), interruption.DetailType)
+		return nil
+	}
+	node, err := c.getNode(ctx, interruption.Detail.InstanceID)
+	if err != nil {
+		return err
+	}
+	if node == nil {
+		logging.FromContext(ctx).Debugf("Ignoring %s for instance %s, which is not a node launched by Karpenter", interruption.DetailType, interruption.Detail.InstanceID)
+		return nil
+	}
+	logging.FromContext(ctx).Infof("Received %s for node %s", interruption.DetailType, node.Name)
+	if interruption.DetailType == RebalanceRecommendation {
+		switch node.Annotations[v1alpha1.RebalanceRecommendationPolicyAnnotationKey] {
+		case v1alpha1.RebalanceRecommendationPolicyIgnore:
+			return nil
+		case v1alpha1.RebalanceRecommendationPolicyReplace:
+			return c.replace(ctx, node)
+		}
+	}
+	// Avoid launching replacement capacity in the spot pool that is being reclaimed
+	if interruption.DetailType == SpotInterruptionWarning && node.Labels[v1alpha5.LabelCapacityType] == v1alpha1.CapacityTypeSpot {
+		c.instanceTypeProvider.CacheUnavailable(ctx, node.Labels[v1.LabelInstanceTypeStable], node.Labels[v1.LabelTopologyZone], v1alpha1.CapacityTypeSpot)
+	}
+	select {
+	case c.interrupted <- event.GenericEvent{Object: node}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// replace annotates the node to be replaced, so that its pods are moved to new
+// capacity before it's drained. The capacity is launched outside of the node's
+// spot pool, which is at an elevated risk of interruption.
+func (c *InterruptionController) replace(ctx context.Context, node *v1.Node) error {
+	if _, ok := node.Annotations[v1alpha5.ReplaceAnnotationKey]; ok {
+		return nil
+	}
+	if node.Labels[v1alpha5.LabelCapacityType] == v1alpha1.CapacityTypeSpot {
+		c.instanceTypeProvider.CacheUnavailable(ctx, node.Labels[v1.LabelInstanceTypeStable], node.Labels[v1.LabelTopologyZone], v1alpha1.CapacityTypeSpot)
+	}
+	stored := node.DeepCopy()
+	node.Annotations = functional.UnionStringMaps(node.Annotations, map[string]string{v1alpha5.ReplaceAnnotationKey: "rebalance recommendation"})
+	if err := c.kubeClient.Patch(ctx, node, client.MergeFrom(stored)); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("patching node, %w", err)
+	}
+	return nil
+}
+
+// getNode returns the node launched by Karpenter for the instance, or nil if
+// there is none
+func (c *InterruptionController) getNode(ctx context.Context, instanceID string) (*v1.Node, error) {
+	nodes := &v1.NodeList{}
+	if err := c.kubeClient.List(ctx, nodes, client.HasLabels{v1alpha5.ProvisionerNameLabelKey}); err != nil {
+		return nil, fmt.Errorf("listing nodes, %w", err)
+	}
+	for i := range nodes.Items {
+		if instanceID != "" && strings.HasSuffix(nodes.Items[i].Spec.ProviderID, "/"+instanceID) {
+			return &nodes.Items[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// getQueueName returns the queue of the settings, or the queue that the
+// controller was constructed with if the settings don't configure one. No
+// queue is returned while the SpotInterruption feature is disabled.
+func (c *InterruptionController) getQueueName(ctx context.Context) string {
+	if !injection.IsFeatureEnabled(ctx, featuregates.SpotInterruption) {
+		return ""
+	}
+	if queueName := injection.GetSettings(ctx).AWSInterruptionQueueName; queueName != "" {
+		return queueName
+	}
+	return c.queueName
+}
+
+func (c *InterruptionController) getQueueURL(ctx context.Context, queueName string) (string, error) {
+	if queueURL, ok := c.queueURLs[queueName]; ok {
+		return queueURL, nil
+	}
+	output, err := c.sqsapi.GetQueueUrlWithContext(ctx, &sqs.GetQueueUrlInput{QueueName: aws.String(queueName)})
+	if err != nil {
+		return "", fmt.Errorf("getting url of queue %s, %w", queueName, err)
+	}
+	c.queueURLs[queueName] = aws.StringValue(output.QueueUrl)
+	return c.queueURLs[queueName], nil
+}
@@ -31,8 +31,10 @@ import (
 	"github.com/aws/karpenter/pkg/cloudprovider/aws/apis/v1alpha1"
 	"github.com/aws/karpenter/pkg/utils/functional"
 	"github.com/aws/karpenter/pkg/utils/injection"
+	"github.com/aws/karpenter/pkg/utils/resources"
 	"github.com/mitchellh/hashstructure/v2"
 	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/client-go/transport"
 	"knative.dev/pkg/logging"
 	"knative.dev/pkg/ptr"
@@ -74,13 +76,21 @@ func launchTemplateName(options *launchTemplateOptions) string {
 // to the number of LaunchTemplates that will result from this change.
 type launchTemplateOptions struct {
 	// Edge-triggered fields that will only change on kube events.
-	ClusterName     string
-	UserData        string
-	InstanceProfile string
+	ClusterName                      string
+	UserData                         string
+	InstanceProfile                  string
+	BlockDeviceMappings              []*ec2.LaunchTemplateBlockDeviceMappingRequest
+	MetadataOptions                  *ec2.LaunchTemplateInstanceMetadataOptionsRequest
+	Placement                        *ec2.LaunchTemplatePlacementRequest
+	CapacityReservationSpecification *ec2.LaunchTemplateCapacityReservationSpecificationRequest
+	HibernationOptions               *ec2.LaunchTemplateHibernationOptionsRequest
 	// Level-triggered fields that may change out of sync.
 	SecurityGroupsIds []string
+	NetworkInterfaces []*ec2.LaunchTemplateInstanceNetworkInterfaceSpecificationRequest
 	AMIID             string
-	Tags              map[string]string
+	// Tags include the provisioner's name, so launch templates aren't shared
+	// between provisioners.
+	Tags map[string]string
 }
 
 func (p *LaunchTemplateProvider) Get(ctx context.Context, constraints *v1alpha1.Constraints, instanceTypes []cloudprovider.InstanceType, additionalLabels map[string]string) (map[string][]cloudprovider.InstanceType, error) {
@@ -94,35 +104,62 @@ func (p *LaunchTemplateProvider) Get(ctx context.Context, constraints *v1alpha1.
 		return nil, err
 	}
 	// Get constrained AMI ID
-	amis, err := p.amiProvider.Get(ctx, instanceTypes)
+	amis, err := p.amiProvider.Get(ctx, constraints, instanceTypes)
 	if err != nil {
 		return nil, err
 	}
 	// Construct launch templates
 	launchTemplates := map[string][]cloudprovider.InstanceType{}
 	for amiID, instanceTypes := range amis {
-		// Get userData for Node
-		userData, err := p.getUserData(ctx, constraints, instanceTypes, additionalLabels)
-		if err != nil {
-			return nil, err
-		}
-		// Ensure the launch template exists, or create it
-		launchTemplate, err := p.ensureLaunchTemplate(ctx, &launchTemplateOptions{
-			UserData:          userData,
-			ClusterName:       injection.GetOptions(ctx).ClusterName,
-			InstanceProfile:   constraints.InstanceProfile,
-			AMIID:             amiID,
-			SecurityGroupsIds: securityGroupsIds,
-			Tags:              constraints.Tags,
-		})
-		if err != nil {
-			return nil, err
+		for maxPods, instanceTypes := range groupByMaxPods(ctx, constraints.KubeletConfiguration, instanceTypes) {
+			// Get userData for Node
+			userData, err := p.getUserData(ctx, constraints, instanceTypes, additionalLabels, maxPods)
+			if err != nil {
+				return nil, err
+			}
+			for efas, instanceTypes := range groupByEFAs(ctx, instanceTypes) {
+				for hibernation, instanceTypes := range groupByHibernation(constraints, instanceTypes, additionalLabels[v1alpha5.LabelCapacityType]) {
+					// Ensure the launch template exists, or create it
+					launchTemplate, err := p.ensureLaunchTemplate(ctx, &launchTemplateOptions{
+						UserData:                         userData,
+						ClusterName:                      injection.GetOptions(ctx).ClusterName,
+						InstanceProfile:                  instanceProfile(ctx, constraints),
+						BlockDeviceMappings:              blockDeviceMappings(ctx, constraints),
+						MetadataOptions:                  metadataOptions(constraints.MetadataOptions),
+						Placement:                        placement(constraints),
+						CapacityReservationSpecification: capacityReservationSpecification(constraints.CapacityReservationSpecification),
+						HibernationOptions:               hibernationOptions(hibernation),
+						AMIID:                            amiID,
+						SecurityGroupsIds:                securityGroupsIds,
+						NetworkInterfaces:                networkInterfaces(efas, securityGroupsIds, constraints.AssociatePublicIPAddress),
+						Tags:                             v1alpha1.Tags(ctx, constraints.Tags),
+					})
+					if err != nil {
+						return nil, err
+					}
+					launchTemplates[aws.StringValue(launchTemplate.LaunchTemplateName)] = instanceTypes
+				}
+			}
 		}
-		launchTemplates[aws.StringValue(launchTemplate.LaunchTemplateName)] = instanceTypes
 	}
 	return launchTemplates, nil
 }
 
+// Describe returns the user specified launch template with the given name, or
+// an error if it does not exist.
+func (p *LaunchTemplateProvider) Describe(ctx context.Context, name string) (*ec2.LaunchTemplate, error) {
+	output, err := p.ec2api.DescribeLaunchTemplatesWithContext(ctx, &ec2.DescribeLaunchTemplatesInput{
+		LaunchTemplateNames: []*string{aws.String(name)},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("describing launch template %s, %w", name, err)
+	}
+	if len(output.LaunchTemplates) != 1 {
+		return nil, fmt.Errorf("expected to find one launch template, but found %d", len(output.LaunchTemplates))
+	}
+	return output.LaunchTemplates[0], nil
+}
+
 func (p *LaunchTemplateProvider) ensureLaunchTemplate(ctx context.Context, options *launchTemplateOptions) (*ec2.LaunchTemplate, error) {
 	// Ensure that multiple threads don't attempt to create the same launch template
 	p.Lock()
@@ -157,6 +194,70 @@ func (p *LaunchTemplateProvider) ensureLaunchTemplate(ctx context.Context, optio
 	return launchTemplate, nil
 }
 
+// groupByMaxPods groups the instance types by the maximum number of pods that
+// their kubelets run, which may not exceed the number of pods that the instance
+// type's networking supports. Instance types are grouped under zero if the
+// bootstrap script's ENI limited default for the instance type is used.
+func groupByMaxPods(ctx context.Context, kubeletConfiguration v1alpha5.KubeletConfiguration, instanceTypes []cloudprovider.InstanceType) map[int32][]cloudprovider.InstanceType {
+	groups := map[int32][]cloudprovider.InstanceType{}
+	for _, instanceType := range instanceTypes {
+		maxPods := int32(0)
+		if kubeletConfiguration.MaxPods != nil || !injection.GetOptions(ctx).AWSENILimitedPodDensity {
+			maxPods = int32(instanceType.Pods().Value())
+			if kubeletConfiguration.MaxPods != nil && *kubeletConfiguration.MaxPods < maxPods {
+				maxPods = *kubeletConfiguration.MaxPods
+			}
+		}
+		groups[maxPods] = append(groups[maxPods], instanceType)
+	}
+	return groups
+}
+
+// groupByEFAs groups the instance types by the number of Elastic Fabric
+// Adapters to attach to them, which is the most that each instance type
+// supports if the pods being launched request vpc.amazonaws.com/efa, or zero
+// otherwise.
+func groupByEFAs(ctx context.Context, instanceTypes []cloudprovider.InstanceType) map[int64][]cloudprovider.InstanceType {
+	requested, ok := injection.GetRequests(ctx)[resources.AWSEFA]
+	if !ok || requested.IsZero() {
+		return map[int64][]cloudprovider.InstanceType{0: instanceTypes}
+	}
+	groups := map[int64][]cloudprovider.InstanceType{}
+	for _, instanceType := range instanceTypes {
+		efas := instanceType.ExtendedResources()[resources.AWSEFA]
+		groups[efas.Value()] = append(groups[efas.Value()], instanceType)
+	}
+	return groups
+}
+
+// groupByHibernation groups the instance types by whether they support
+// hibernation, if the provisioner hibernates the on-demand instances of empty
+// nodes and on-demand instances are being launched. Otherwise, all of the
+// instance types are grouped under false.
+func groupByHibernation(constraints *v1alpha1.Constraints, instanceTypes []cloudprovider.InstanceType, capacityType string) map[bool][]cloudprovider.InstanceType {
+	if aws.StringValue(constraints.EmptyInstancePolicy) != v1alpha1.EmptyInstancePolicyHibernate || capacityType != v1alpha1.CapacityTypeOnDemand {
+		return map[bool][]cloudprovider.InstanceType{false: instanceTypes}
+	}
+	groups := map[bool][]cloudprovider.InstanceType{}
+	for _, instanceType := range instanceTypes {
+		supported := false
+		if awsInstanceType, ok := instanceType.(*InstanceType); ok {
+			supported = aws.BoolValue(awsInstanceType.HibernationSupported)
+		}
+		groups[supported] = append(groups[supported], instanceType)
+	}
+	return groups
+}
+
+// hibernationOptions enables hibernation for instance types that support it.
+// Hibernation can only be enabled when instances are launched.
+func hibernationOptions(hibernation bool) *ec2.LaunchTemplateHibernationOptionsRequest {
+	if !hibernation {
+		return nil
+	}
+	return &ec2.LaunchTemplateHibernationOptionsRequest{Configured: aws.Bool(true)}
+}
+
 // needsDocker returns true if the instance type is unable to use
 // containerd directly
 func needsDocker(is []cloudprovider.InstanceType) bool {
@@ -169,15 +270,28 @@ func needsDocker(is []cloudprovider.InstanceType) bool {
 }
 
 func (p *LaunchTemplateProvider) createLaunchTemplate(ctx context.Context, options *launchTemplateOptions) (*ec2.LaunchTemplate, error) {
+	// Security groups are attached to each network interface if any are
+	// specified, since EC2 rejects launch templates that specify both
+	var securityGroupIds []*string
+	if len(options.NetworkInterfaces) == 0 {
+		securityGroupIds = aws.StringSlice(options.SecurityGroupsIds)
+	}
 	output, err := p.ec2api.CreateLaunchTemplateWithContext(ctx, &ec2.CreateLaunchTemplateInput{
 		LaunchTemplateName: aws.String(launchTemplateName(options)),
 		LaunchTemplateData: &ec2.RequestLaunchTemplateData{
 			IamInstanceProfile: &ec2.LaunchTemplateIamInstanceProfileSpecificationRequest{
 				Name: aws.String(options.InstanceProfile),
 			},
-			SecurityGroupIds: aws.StringSlice(options.SecurityGroupsIds),
-			UserData:         aws.String(options.UserData),
-			ImageId:          aws.String(options.AMIID),
+			BlockDeviceMappings:              options.BlockDeviceMappings,
+			MetadataOptions:                  options.MetadataOptions,
+			Placement:                        options.Placement,
+			CapacityReservationSpecification: options.CapacityReservationSpecification,
+			HibernationOptions:               options.HibernationOptions,
+			SecurityGroupIds:                 securityGroupIds,
+			NetworkInterfaces:                options.NetworkInterfaces,
+			UserData:                         aws.String(options.UserData),
+			ImageId:                          aws.String(options.AMIID),
+			TagSpecifications:                launchTemplateTagSpecifications(options.Tags),
 		},
 		TagSpecifications: []*ec2.TagSpecification{{
 			ResourceType: aws.String(ec2.ResourceTypeLaunchTemplate),
@@ -191,6 +305,180 @@ func (p *LaunchTemplateProvider) createLaunchTemplate(ctx context.Context, optio
 	return output.LaunchTemplate, nil
 }
 
+// blockDeviceMappings converts the block device mappings to their launch
+// template form, which hashes by value unlike the volume size's quantity. If a
+// KMS key is configured, volumes that don't specify whether they are encrypted
+// are encrypted with it, as are the AMI family's root volumes if no mappings
+// are specified. The key is part of the launch template's hash, so a new
+// launch template is created when the key changes.
+func blockDeviceMappings(ctx context.Context, constraints *v1alpha1.Constraints) []*ec2.LaunchTemplateBlockDeviceMappingRequest {
+	kmsKeyID := kmsKeyID(ctx, constraints)
+	if len(constraints.BlockDeviceMappings) == 0 {
+		if kmsKeyID == "" {
+			return nil
+		}
+		requests := []*ec2.LaunchTemplateBlockDeviceMappingRequest{}
+		for _, deviceName := range rootDeviceNames(aws.StringValue(constraints.AMIFamily)) {
+			requests = append(requests, &ec2.LaunchTemplateBlockDeviceMappingRequest{
+				DeviceName: aws.String(deviceName),
+				Ebs:        &ec2.LaunchTemplateEbsBlockDeviceRequest{Encrypted: aws.Bool(true), KmsKeyId: aws.String(kmsKeyID)},
+			})
+		}
+		return requests
+	}
+	requests := []*ec2.LaunchTemplateBlockDeviceMappingRequest{}
+	for _, blockDeviceMapping := range constraints.BlockDeviceMappings {
+		ebs := blockDeviceMapping.EBS
+		request := &ec2.LaunchTemplateEbsBlockDeviceRequest{
+			DeleteOnTermination: ebs.DeleteOnTermination,
+			Encrypted:           ebs.Encrypted,
+			Iops:                ebs.IOPS,
+			KmsKeyId:            ebs.KMSKeyID,
+			SnapshotId:          ebs.SnapshotID,
+			Throughput:          ebs.Throughput,
+			VolumeType:          ebs.VolumeType,
+		}
+		if ebs.Encrypted == nil && kmsKeyID != "" {
+			request.Encrypted = aws.Bool(true)
+			request.KmsKeyId = aws.String(kmsKeyID)
+		}
+		if ebs.VolumeSize != nil {
+			// Round up to the nearest GiB, since EBS volumes are sized in GiB
+			request.VolumeSize = aws.Int64((ebs.VolumeSize.Value() + 1<<30 - 1) / (1 << 30))
+		}
+		requests = append(requests, &ec2.LaunchTemplateBlockDeviceMappingRequest{
+			DeviceName: blockDeviceMapping.DeviceName,
+			Ebs:        request,
+		})
+	}
+	return requests
+}
+
+// kmsKeyID returns the KMS key that the provisioner's volumes are encrypted
+// with, which defaults to the KMS key that Karpenter is configured with.
+func kmsKeyID(ctx context.Context, constraints *v1alpha1.Constraints) string {
+	if constraints.KMSKeyID != nil {
+		return *constraints.KMSKeyID
+	}
+	return injection.GetOptions(ctx).AWSDefaultKMSKeyID
+}
+
+// rootDeviceNames returns the device names of the volumes that the AMI family
+// boots from. Bottlerocket has separate volumes for the OS and for its data,
+// which includes the kubelet's filesystem.
+func rootDeviceNames(amiFamily string) []string {
+	switch amiFamily {
+	case v1alpha1.AMIFamilyBottlerocket:
+		return []string{"/dev/xvda", "/dev/xvdb"}
+	case v1alpha1.AMIFamilyUbuntu, v1alpha1.AMIFamilyWindows2019, v1alpha1.AMIFamilyWindows2022:
+		return []string{"/dev/sda1"}
+	default:
+		return []string{"/dev/xvda"}
+	}
+}
+
+// ephemeralVolumeSize returns the size of the block device mapping of the
+// volume that holds the kubelet's filesystem, which is the last of the AMI
+// family's root volumes, or nil if it isn't mapped or sized.
+func ephemeralVolumeSize(provider *v1alpha1.AWS) *resource.Quantity {
+	deviceNames := rootDeviceNames(aws.StringValue(provider.AMIFamily))
+	for _, blockDeviceMapping := range provider.BlockDeviceMappings {
+		if aws.StringValue(blockDeviceMapping.DeviceName) == deviceNames[len(deviceNames)-1] && blockDeviceMapping.EBS != nil {
+			return blockDeviceMapping.EBS.VolumeSize
+		}
+	}
+	return nil
+}
+
+// launchTemplateTagSpecifications tags the instances that are launched from
+// the launch template, along with their volumes and network interfaces.
+func launchTemplateTagSpecifications(tags map[string]string) []*ec2.LaunchTemplateTagSpecificationRequest {
+	ec2Tags := []*ec2.Tag{}
+	for key, value := range tags {
+		ec2Tags = append(ec2Tags, &ec2.Tag{Key: aws.String(key), Value: aws.String(value)})
+	}
+	tagSpecifications := []*ec2.LaunchTemplateTagSpecificationRequest{}
+	for _, resourceType := range []string{ec2.ResourceTypeInstance, ec2.ResourceTypeVolume, ec2.ResourceTypeNetworkInterface} {
+		tagSpecifications = append(tagSpecifications, &ec2.LaunchTemplateTagSpecificationRequest{
+			ResourceType: aws.String(resourceType),
+			Tags:         ec2Tags,
+		})
+	}
+	return tagSpecifications
+}
+
+// placement returns the launch template placement for the placement group
+// and tenancy
+func placement(constraints *v1alpha1.Constraints) *ec2.LaunchTemplatePlacementRequest {
+	if constraints.PlacementGroup == nil && constraints.Tenancy == nil && constraints.HostResourceGroupARN == nil {
+		return nil
+	}
+	return &ec2.LaunchTemplatePlacementRequest{
+		GroupName:            constraints.PlacementGroup,
+		Tenancy:              constraints.Tenancy,
+		HostResourceGroupArn: constraints.HostResourceGroupARN,
+	}
+}
+
+// capacityReservationSpecification converts the capacity reservation
+// specification to its launch template form
+func capacityReservationSpecification(specification *v1alpha1.CapacityReservationSpecification) *ec2.LaunchTemplateCapacityReservationSpecificationRequest {
+	if specification == nil {
+		return nil
+	}
+	if specification.CapacityReservationResourceGroupARN != nil {
+		return &ec2.LaunchTemplateCapacityReservationSpecificationRequest{
+			CapacityReservationTarget: &ec2.CapacityReservationTarget{CapacityReservationResourceGroupArn: specification.CapacityReservationResourceGroupARN},
+		}
+	}
+	return &ec2.LaunchTemplateCapacityReservationSpecificationRequest{CapacityReservationPreference: specification.CapacityReservationPreference}
+}
+
+// networkInterfaces returns an Elastic Fabric Adapter for each of the given
+// number of network cards. Only the first may be the primary network
+// interface, so the others are attached as secondary interfaces. Whether a
+// public IP address is associated is only configurable on the primary
+// network interface, so it is specified even if there are no EFAs.
+func networkInterfaces(efas int64, securityGroupsIds []string, associatePublicIPAddress *bool) []*ec2.LaunchTemplateInstanceNetworkInterfaceSpecificationRequest {
+	if efas == 0 {
+		if associatePublicIPAddress == nil {
+			return nil
+		}
+		return []*ec2.LaunchTemplateInstanceNetworkInterfaceSpecificationRequest{{
+			DeviceIndex:              aws.Int64(0),
+			AssociatePublicIpAddress: associatePublicIPAddress,
+			Groups:                   aws.StringSlice(securityGroupsIds),
+		}}
+	}
+	requests := []*ec2.LaunchTemplateInstanceNetworkInterfaceSpecificationRequest{}
+	for i := int64(0); i < efas; i++ {
+		deviceIndex := int64(1)
+		if i == 0 {
+			deviceIndex = 0
+		}
+		requests = append(requests, &ec2.LaunchTemplateInstanceNetworkInterfaceSpecificationRequest{
+			NetworkCardIndex: aws.Int64(i),
+			DeviceIndex:      aws.Int64(deviceIndex),
+			InterfaceType:    aws.String(ec2.NetworkInterfaceTypeEfa),
+			Groups:           aws.StringSlice(securityGroupsIds),
+		})
+	}
+	requests[0].AssociatePublicIpAddress = associatePublicIPAddress
+	return requests
+}
+
+// metadataOptions converts the metadata options to their launch template form
+func metadataOptions(metadataOptions *v1alpha1.MetadataOptions) *ec2.LaunchTemplateInstanceMetadataOptionsRequest {
+	if metadataOptions == nil {
+		return nil
+	}
+	return &ec2.LaunchTemplateInstanceMetadataOptionsRequest{
+		HttpEndpoint:            metadataOptions.HTTPEndpoint,
+		HttpPutResponseHopLimit: metadataOptions.HTTPPutResponseHopLimit,
+		HttpTokens:              metadataOptions.HTTPTokens,
+	}
+}
+
 func sortedTaints(ts []core.Taint) []core.Taint {
 	sorted := append(ts[:0:0], ts...) // copy to avoid touching original
 	sort.Slice(sorted, func(i, j int) bool {
@@ -220,19 +508,69 @@ func sortedKeys(m map[string]string) []string {
 	return keys
 }
 
+// instanceStoreRAID0Script combines the NVMe instance store volumes, if there
+// are any, into a RAID0 array, and mounts it over the directories of the
+// kubelet, container runtime and pod logs before they are used. Instance types
+// without instance store volumes keep using the root volume.
+const instanceStoreRAID0Script = `devices=$(find /dev/disk/by-id -name 'nvme-Amazon_EC2_NVMe_Instance_Storage_*' -exec readlink -f {} \; | sort -u)
+if [ -n "$devices" ]; then
+    if [ "$(echo "$devices" | wc -l)" -gt 1 ]; then
+        mdadm --create --force --verbose /dev/md/kubernetes --level=0 --name=kubernetes --raid-devices="$(echo "$devices" | wc -l)" $devices
+        device=/dev/md/kubernetes
+    else
+        device=$devices
+    fi
+    mkfs.xfs -f "$device"
+    mkdir -p /mnt/k8s-disks
+    mount -o defaults,noatime "$device" /mnt/k8s-disks
+    for dir in /var/lib/kubelet /var/lib/containerd /var/lib/docker /var/log/pods; do
+        mkdir -p "/mnt/k8s-disks$dir" "$dir"
+        mount --bind "/mnt/k8s-disks$dir" "$dir"
+    done
+fi
+`
+
+// containerdMirrorsScript writes the hosts configuration of each registry's
+// mirror to the directory that containerd reads it from, before containerd is
+// started by the bootstrap script. Registries are sorted so that equivalent
+// mirrors hash the same.
+func containerdMirrorsScript(mirrors map[string]string) string {
+	var script bytes.Buffer
+	for _, registry := range sortedKeys(mirrors) {
+		script.WriteString(fmt.Sprintf(`mkdir -p '/etc/containerd/certs.d/%[1]s'
+cat <<'EOF' > '/etc/containerd/certs.d/%[1]s/hosts.toml'
+[host.%[2]q]
+  capabilities = ["pull", "resolve"]
+EOF
+`, registry, mirrors[registry]))
+	}
+	return script.String()
+}
+
 // getUserData returns the exact same string for equivalent input,
 // even if elements of those inputs are in differing orders,
 // guaranteeing it won't cause spurious hash differences.
-func (p *LaunchTemplateProvider) getUserData(ctx context.Context, constraints *v1alpha1.Constraints, instanceTypes []cloudprovider.InstanceType, additionalLabels map[string]string) (string, error) {
+func (p *LaunchTemplateProvider) getUserData(ctx context.Context, constraints *v1alpha1.Constraints, instanceTypes []cloudprovider.InstanceType, additionalLabels map[string]string, maxPods int32) (string, error) {
+	if aws.StringValue(constraints.AMIFamily) == v1alpha1.AMIFamilyBottlerocket {
+		return p.getBottlerocketUserData(ctx, constraints, additionalLabels, maxPods)
+	}
+	if constraints.OperatingSystem() == v1alpha5.OperatingSystemWindows {
+		return p.getWindowsUserData(ctx, constraints, additionalLabels, maxPods)
+	}
 	var containerRuntimeArg string
 	if !needsDocker(instanceTypes) {
 		containerRuntimeArg = "--container-runtime containerd"
 	}
 
 	var userData bytes.Buffer
-	userData.WriteString(fmt.Sprintf(`#!/bin/bash -xe
+	userData.WriteString(`#!/bin/bash -xe
 exec > >(tee /var/log/user-data.log|logger -t user-data -s 2>/dev/console) 2>&1
-/etc/eks/bootstrap.sh '%s' %s \
+`)
+	if aws.StringValue(constraints.InstanceStorePolicy) == v1alpha1.InstanceStorePolicyRAID0 {
+		userData.WriteString(instanceStoreRAID0Script)
+	}
+	userData.WriteString(containerdMirrorsScript(constraints.ContainerRegistryMirrors))
+	userData.WriteString(fmt.Sprintf(`/etc/eks/bootstrap.sh '%s' %s \
     --apiserver-endpoint '%s'`,
 		injection.GetOptions(ctx).ClusterName,
 		containerRuntimeArg,
@@ -247,10 +585,7 @@ exec > >(tee /var/log/user-data.log|logger -t user-data -s 2>/dev/console) 2>&1
 			*caBundle))
 	}
 
-	nodeLabelArgs := p.getNodeLabelArgs(functional.UnionStringMaps(additionalLabels, constraints.Labels))
-	nodeTaintsArgs := p.getNodeTaintArgs(constraints)
-	kubeletExtraArgs := strings.Trim(strings.Join([]string{nodeLabelArgs, nodeTaintsArgs.String()}, " "), " ")
-
+	kubeletExtraArgs := p.getKubeletExtraArgs(constraints, additionalLabels, maxPods)
 	if len(kubeletExtraArgs) > 0 {
 		userData.WriteString(fmt.Sprintf(` \
     --kubelet-extra-args '%s'`, kubeletExtraArgs))
@@ -259,7 +594,119 @@ exec > >(tee /var/log/user-data.log|logger -t user-data -s 2>/dev/console) 2>&1
 		userData.WriteString(fmt.Sprintf(` \
     --dns-cluster-ip '%s'`, constraints.KubeletConfiguration.ClusterDNS[0]))
 	}
-	return base64.StdEncoding.EncodeToString(userData.Bytes()), nil
+	// Otherwise, the bootstrap script overrides max pods with its default for the instance type
+	if maxPods > 0 {
+		userData.WriteString(` \
+    --use-max-pods false`)
+	}
+	if constraints.UserData == nil {
+		return base64.StdEncoding.EncodeToString(userData.Bytes()), nil
+	}
+	merged, err := mergeMIME(*constraints.UserData, userData.String(), aws.StringValue(constraints.UserDataMode))
+	if err != nil {
+		return "", fmt.Errorf("merging user data, %w", err)
+	}
+	return base64.StdEncoding.EncodeToString([]byte(merged)), nil
+}
+
+// getBottlerocketUserData returns the TOML settings that Bottlerocket uses to
+// join the cluster, merged into the user's settings, if any.
+func (p *LaunchTemplateProvider) getBottlerocketUserData(ctx context.Context, constraints *v1alpha1.Constraints, additionalLabels map[string]string, maxPods int32) (string, error) {
+	settings := bottlerocketSettings{}
+	if constraints.UserData != nil {
+		var err error
+		if settings, err = parseBottlerocketSettings(*constraints.UserData); err != nil {
+			return "", fmt.Errorf("parsing user data, %w", err)
+		}
+	}
+	settings.set("settings.kubernetes", "cluster-name", injection.GetOptions(ctx).ClusterName)
+	settings.set("settings.kubernetes", "api-server", injection.GetOptions(ctx).ClusterEndpoint)
+	caBundle, err := p.GetCABundle(ctx)
+	if err != nil {
+		return "", fmt.Errorf("getting ca bundle for user data, %w", err)
+	}
+	if caBundle != nil {
+		settings.set("settings.kubernetes", "cluster-certificate", *caBundle)
+	}
+	if len(constraints.KubeletConfiguration.ClusterDNS) > 0 {
+		settings.set("settings.kubernetes", "cluster-dns-ip", constraints.KubeletConfiguration.ClusterDNS[0])
+	}
+	// Otherwise, Bottlerocket uses its default for the instance type
+	if maxPods > 0 {
+		settings.set("settings.kubernetes", "max-pods", maxPods)
+	}
+	setTable := func(name string, entries map[string]string) {
+		for key, value := range entries {
+			settings.set("settings.kubernetes."+name, key, value)
+		}
+	}
+	setTable("node-labels", functional.UnionStringMaps(additionalLabels, constraints.Labels))
+	// Bottlerocket takes a list of values and effects for each taint key, so
+	// that a key may be tainted with several effects
+	taints := map[string][]string{}
+	for _, taint := range sortedTaints(append(append([]core.Taint{}, constraints.Taints...), constraints.StartupTaints...)) {
+		taints[taint.Key] = append(taints[taint.Key], fmt.Sprintf("%s:%s", taint.Value, taint.Effect))
+	}
+	for key, values := range taints {
+		settings.set("settings.kubernetes.node-taints", key, values)
+	}
+	setTable("eviction-hard", constraints.KubeletConfiguration.EvictionHard)
+	setTable("kube-reserved", quantities(constraints.KubeletConfiguration.KubeReserved))
+	setTable("system-reserved", quantities(constraints.KubeletConfiguration.SystemReserved))
+	for _, registry := range sortedKeys(constraints.ContainerRegistryMirrors) {
+		settings.append("settings.container-registry.mirrors", map[string]interface{}{
+			"registry": registry,
+			"endpoint": []string{constraints.ContainerRegistryMirrors[registry]},
+		})
+	}
+	encoded, err := settings.String()
+	if err != nil {
+		return "", fmt.Errorf("encoding user data, %w", err)
+	}
+	return base64.StdEncoding.EncodeToString([]byte(encoded)), nil
+}
+
+// getWindowsUserData returns a PowerShell script that runs the EKS optimized
+// Windows AMI's bootstrap script, along with the user's script, if any.
+// Windows doesn't support MIME multipart user data, so both run in the same
+// PowerShell block.
+func (p *LaunchTemplateProvider) getWindowsUserData(ctx context.Context, constraints *v1alpha1.Constraints, additionalLabels map[string]string, maxPods int32) (string, error) {
+	var bootstrap bytes.Buffer
+	bootstrap.WriteString(fmt.Sprintf(`[string]$EKSBootstrapScriptFile = "$env:ProgramFiles\Amazon\EKS\Start-EKSBootstrap.ps1"
+& $EKSBootstrapScriptFile -EKSClusterName '%s' -APIServerEndpoint '%s'`,
+		injection.GetOptions(ctx).ClusterName,
+		injection.GetOptions(ctx).ClusterEndpoint))
+	caBundle, err := p.GetCABundle(ctx)
+	if err != nil {
+		return "", fmt.Errorf("getting ca bundle for user data, %w", err)
+	}
+	if caBundle != nil {
+		bootstrap.WriteString(fmt.Sprintf(" -Base64ClusterCA '%s'", *caBundle))
+	}
+	if kubeletExtraArgs := p.getKubeletExtraArgs(constraints, additionalLabels, maxPods); len(kubeletExtraArgs) > 0 {
+		bootstrap.WriteString(fmt.Sprintf(" -KubeletExtraArgs '%s'", kubeletExtraArgs))
+	}
+	if len(constraints.KubeletConfiguration.ClusterDNS) > 0 {
+		bootstrap.WriteString(fmt.Sprintf(" -DNSClusterIP '%s'", constraints.KubeletConfiguration.ClusterDNS[0]))
+	}
+	scripts := []string{bootstrap.String()}
+	if constraints.UserData != nil {
+		if aws.StringValue(constraints.UserDataMode) == v1alpha1.UserDataModeAppend {
+			scripts = append(scripts, powershellScript(*constraints.UserData))
+		} else {
+			scripts = append([]string{powershellScript(*constraints.UserData)}, scripts...)
+		}
+	}
+	return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("<powershell>\n%s\n</powershell>", strings.Join(scripts, "\n")))), nil
+}
+
+// getKubeletExtraArgs returns the kubelet flags for the node's labels, taints
+// and kubelet configuration
+func (p *LaunchTemplateProvider) getKubeletExtraArgs(constraints *v1alpha1.Constraints, additionalLabels map[string]string, maxPods int32) string {
+	nodeLabelArgs := p.getNodeLabelArgs(functional.UnionStringMaps(additionalLabels, constraints.Labels))
+	nodeTaintsArgs := p.getNodeTaintArgs(constraints)
+	kubeletConfigurationArgs := p.getKubeletConfigurationArgs(constraints.KubeletConfiguration, maxPods)
+	return strings.Trim(strings.Join([]string{nodeLabelArgs, nodeTaintsArgs.String(), kubeletConfigurationArgs}, " "), " ")
 }
 
 func (p *LaunchTemplateProvider) getNodeLabelArgs(nodeLabels map[string]string) string {
@@ -281,12 +728,13 @@ func (p *LaunchTemplateProvider) getNodeLabelArgs(nodeLabels map[string]string)
 
 func (p *LaunchTemplateProvider) getNodeTaintArgs(constraints *v1alpha1.Constraints) bytes.Buffer {
 	var nodeTaintsArgs bytes.Buffer
-	if len(constraints.Taints) > 0 {
+	taints := append(append([]core.Taint{}, constraints.Taints...), constraints.StartupTaints...)
+	if len(taints) > 0 {
 		nodeTaintsArgs.WriteString("--register-with-taints=")
 		first := true
 		// Must be in sorted order or else equivalent options won't
 		// hash the same.
-		sorted := sortedTaints(constraints.Taints)
+		sorted := sortedTaints(taints)
 		for _, taint := range sorted {
 			if !first {
 				nodeTaintsArgs.WriteString(",")
@@ -298,6 +746,44 @@ func (p *LaunchTemplateProvider) getNodeTaintArgs(constraints *v1alpha1.Constrai
 	return nodeTaintsArgs
 }
 
+func (p *LaunchTemplateProvider) getKubeletConfigurationArgs(kubeletConfiguration v1alpha5.KubeletConfiguration, maxPods int32) string {
+	args := []string{}
+	if maxPods > 0 {
+		args = append(args, fmt.Sprintf("--max-pods=%d", maxPods))
+	}
+	// Must be in sorted order or else equivalent options won't
+	// hash the same
+	for flag, reserved := range map[string]core.ResourceList{"--kube-reserved": kubeletConfiguration.KubeReserved, "--system-reserved": kubeletConfiguration.SystemReserved} {
+		if len(reserved) == 0 {
+			continue
+		}
+		args = append(args, fmt.Sprintf("%s=%s", flag, joinSorted(quantities(reserved), "=")))
+	}
+	if len(kubeletConfiguration.EvictionHard) > 0 {
+		args = append(args, fmt.Sprintf("--eviction-hard=%s", joinSorted(kubeletConfiguration.EvictionHard, "<")))
+	}
+	sort.Strings(args)
+	return strings.Join(args, " ")
+}
+
+// quantities formats the resource list's quantities by resource name
+func quantities(resourceList core.ResourceList) map[string]string {
+	quantities := map[string]string{}
+	for resourceName, quantity := range resourceList {
+		quantities[string(resourceName)] = quantity.String()
+	}
+	return quantities
+}
+
+// joinSorted formats the map as a comma separated list of key-value pairs, sorted by key
+func joinSorted(m map[string]string, separator string) string {
+	pairs := []string{}
+	for _, k := range sortedKeys(m) {
+		pairs = append(pairs, k+separator+m[k])
+	}
+	return strings.Join(pairs, ",")
+}
+
 func (p *LaunchTemplateProvider) GetCABundle(ctx context.Context) (*string, error) {
 	// Discover CA Bundle from the REST client. We could alternatively
 	// have used the simpler client-go InClusterConfig() method.
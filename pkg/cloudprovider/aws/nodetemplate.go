@@ -0,0 +1,150 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/cloudprovider/aws/apis/v1alpha1"
+	karpenterlogging "github.com/aws/karpenter/pkg/utils/logging"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"knative.dev/pkg/apis"
+	controllerruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	nodeTemplateControllerName = "nodetemplate"
+	// NodeTemplateRefreshInterval is the duration between resolutions of an
+	// AWSNodeTemplate's resources, which may change without the template
+	// changing, e.g. when a new AMI is released.
+	NodeTemplateRefreshInterval = 5 * time.Minute
+)
+
+// NodeTemplateController resolves the subnets, security groups and AMIs that
+// the selectors of AWSNodeTemplates match, and publishes them in their status,
+// so that they can be inspected without reading the controller's logs.
+type NodeTemplateController struct {
+	kubeClient   client.Client
+	providersFor func(target) *providers
+}
+
+// NewNodeTemplateController constructs a controller instance
+func NewNodeTemplateController(kubeClient client.Client, providersFor func(target) *providers) *NodeTemplateController {
+	return &NodeTemplateController{kubeClient: kubeClient, providersFor: providersFor}
+}
+
+// Reconcile resolves the template's resources, and requeues itself until the
+// next refresh
+func (c *NodeTemplateController) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	ctx = karpenterlogging.WithReconcile(ctx, nodeTemplateControllerName, "awsnodetemplate", req.Name)
+	nodeTemplate := &v1alpha1.AWSNodeTemplate{}
+	if err := c.kubeClient.Get(ctx, req.NamespacedName, nodeTemplate); err != nil {
+		return reconcile.Result{}, client.IgnoreNotFound(err)
+	}
+	persisted := nodeTemplate.DeepCopy()
+	err := c.resolve(ctx, nodeTemplate)
+	if err != nil {
+		reason := "ResolutionFailed"
+		if configurationErrorReason, ok := cloudprovider.ConfigurationErrorReason(err); ok {
+			reason = configurationErrorReason
+		}
+		nodeTemplate.StatusConditions().MarkFalse(apis.ConditionReady, reason, err.Error())
+	} else {
+		nodeTemplate.StatusConditions().MarkTrue(apis.ConditionReady)
+	}
+	if err := c.kubeClient.Status().Patch(ctx, nodeTemplate, client.MergeFrom(persisted)); err != nil {
+		return reconcile.Result{}, fmt.Errorf("patching awsnodetemplate, %w", err)
+	}
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	return reconcile.Result{RequeueAfter: NodeTemplateRefreshInterval}, nil
+}
+
+// resolve the template's resources into its status. Launch templates define
+// their own security groups and AMI, so they're only resolved without one.
+func (c *NodeTemplateController) resolve(ctx context.Context, nodeTemplate *v1alpha1.AWSNodeTemplate) error {
+	provider := &nodeTemplate.Spec.AWS
+	constraints := &v1alpha1.Constraints{Constraints: &v1alpha5.Constraints{}, AWS: provider}
+	p := c.providersFor(targetFor(provider))
+	nodeTemplate.Status.Subnets = nil
+	nodeTemplate.Status.SecurityGroups = nil
+	nodeTemplate.Status.AMIs = nil
+
+	subnets, err := p.subnetProvider.Get(ctx, provider)
+	if err != nil {
+		return fmt.Errorf("getting subnets, %w", err)
+	}
+	for _, subnet := range subnets {
+		nodeTemplate.Status.Subnets = append(nodeTemplate.Status.Subnets, v1alpha1.Subnet{
+			ID:   aws.StringValue(subnet.SubnetId),
+			Zone: aws.StringValue(subnet.AvailabilityZone),
+		})
+	}
+	sort.Slice(nodeTemplate.Status.Subnets, func(i, j int) bool { return nodeTemplate.Status.Subnets[i].ID < nodeTemplate.Status.Subnets[j].ID })
+	if provider.LaunchTemplate != nil {
+		return nil
+	}
+
+	securityGroups, err := p.securityGroupProvider.get(ctx, constraints)
+	if err != nil {
+		return fmt.Errorf("getting security groups, %w", err)
+	}
+	for _, securityGroup := range securityGroups {
+		nodeTemplate.Status.SecurityGroups = append(nodeTemplate.Status.SecurityGroups, v1alpha1.SecurityGroup{
+			ID:   aws.StringValue(securityGroup.GroupId),
+			Name: aws.StringValue(securityGroup.GroupName),
+		})
+	}
+	sort.Slice(nodeTemplate.Status.SecurityGroups, func(i, j int) bool {
+		return nodeTemplate.Status.SecurityGroups[i].ID < nodeTemplate.Status.SecurityGroups[j].ID
+	})
+
+	instanceTypes, err := p.instanceTypeProvider.Get(ctx, provider)
+	if err != nil {
+		return fmt.Errorf("getting instance types, %w", err)
+	}
+	amis, err := p.amiProvider.Get(ctx, constraints, instanceTypes)
+	if err != nil {
+		return fmt.Errorf("getting amis, %w", err)
+	}
+	for id, instanceTypes := range amis {
+		nodeTemplate.Status.AMIs = append(nodeTemplate.Status.AMIs, v1alpha1.AMI{ID: id, Architecture: instanceTypes[0].Architecture()})
+	}
+	sort.Slice(nodeTemplate.Status.AMIs, func(i, j int) bool { return nodeTemplate.Status.AMIs[i].ID < nodeTemplate.Status.AMIs[j].ID })
+	return nil
+}
+
+// Register the controller to the manager. AWSNodeTemplates are added to the
+// manager's scheme, since it only knows Karpenter's own types.
+func (c *NodeTemplateController) Register(_ context.Context, m manager.Manager) error {
+	utilruntime.Must(v1alpha1.AddToScheme(m.GetScheme()))
+	return controllerruntime.
+		NewControllerManagedBy(m).
+		Named(nodeTemplateControllerName).
+		For(&v1alpha1.AWSNodeTemplate{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: 10}).
+		Complete(c)
+}
@@ -0,0 +1,244 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/pricing"
+	"github.com/aws/aws-sdk-go/service/pricing/pricingiface"
+	"github.com/aws/karpenter/pkg/cloudprovider/aws/apis/v1alpha1"
+	"github.com/patrickmn/go-cache"
+	"knative.dev/pkg/logging"
+)
+
+const (
+	OnDemandPricesCacheKey = "on-demand"
+	SpotPricesCacheKey     = "spot"
+	// PricesCacheTTL restricts QPS to the Pricing and EC2 APIs. On-demand prices
+	// rarely change, and spot prices change gradually, so cached prices are
+	// accurate enough to compare instance types.
+	PricesCacheTTL = 1 * time.Hour
+)
+
+// PricingProvider discovers the hourly on-demand and spot prices of instance
+// types in the region. On-demand prices are retrieved from the AWS Price List
+// API and spot prices from the EC2 spot price history. If prices cannot be
+// retrieved, they are treated as unknown rather than failing provisioning.
+type PricingProvider struct {
+	ec2api     ec2iface.EC2API
+	pricingapi pricingiface.PricingAPI
+	region     string
+	cache      *cache.Cache
+}
+
+// Prices are the hourly prices of instance types in USD
+type Prices struct {
+	// OnDemand is keyed by instance type
+	OnDemand map[string]float64
+	// Spot is keyed by instance type and then by zone
+	Spot map[string]map[string]float64
+}
+
+func NewPricingProvider(ec2api ec2iface.EC2API, pricingapi pricingiface.PricingAPI, region string) *PricingProvider {
+	return &PricingProvider{
+		ec2api:     ec2api,
+		pricingapi: pricingapi,
+		region:     region,
+		cache:      cache.New(PricesCacheTTL, CacheCleanupInterval),
+	}
+}
+
+// PricingRegion returns the region of the AWS Price List API endpoint closest
+// to the region. The API is only served from us-east-1 and ap-south-1, and
+// from cn-northwest-1 for the China regions.
+func PricingRegion(region string) string {
+	if strings.HasPrefix(region, "cn-") {
+		return "cn-northwest-1"
+	}
+	if strings.HasPrefix(region, "ap-") {
+		return "ap-south-1"
+	}
+	return "us-east-1"
+}
+
+// Get the prices of all instance types in the region
+func (p *PricingProvider) Get(ctx context.Context) Prices {
+	return Prices{OnDemand: p.getOnDemandPrices(ctx), Spot: p.getSpotPrices(ctx)}
+}
+
+// Price returns the hourly price of the offering, or zero if it is unknown
+func (p Prices) Price(instanceType string, zone string, capacityType string) float64 {
+	if capacityType == v1alpha1.CapacityTypeSpot {
+		return p.Spot[instanceType][zone]
+	}
+	return p.OnDemand[instanceType]
+}
+
+// Refresh retrieves the prices that expire before the next refresh, so that
+// they are retrieved in the background rather than when provisioning
+func (p *PricingProvider) Refresh(ctx context.Context) {
+	if expiresWithin(p.cache, OnDemandPricesCacheKey, InstanceTypesRefreshInterval) {
+		p.updateOnDemandPrices(ctx)
+	}
+	if expiresWithin(p.cache, SpotPricesCacheKey, InstanceTypesRefreshInterval) {
+		p.updateSpotPrices(ctx)
+	}
+}
+
+func (p *PricingProvider) getOnDemandPrices(ctx context.Context) map[string]float64 {
+	if cached, ok := p.cache.Get(OnDemandPricesCacheKey); ok {
+		return cached.(map[string]float64)
+	}
+	return p.updateOnDemandPrices(ctx)
+}
+
+// updateOnDemandPrices retrieves and caches the on-demand prices
+func (p *PricingProvider) updateOnDemandPrices(ctx context.Context) map[string]float64 {
+	prices := map[string]float64{}
+	var errs []error
+	if err := p.pricingapi.GetProductsPagesWithContext(ctx, &pricing.GetProductsInput{
+		ServiceCode: aws.String("AmazonEC2"),
+		Filters: []*pricing.Filter{
+			termMatch("regionCode", p.region),
+			termMatch("operatingSystem", "Linux"),
+			termMatch("tenancy", "Shared"),
+			termMatch("preInstalledSw", "NA"),
+			termMatch("capacitystatus", "Used"),
+			termMatch("licenseModel", "No License required"),
+		},
+	}, func(output *pricing.GetProductsOutput, lastPage bool) bool {
+		for _, product := range output.PriceList {
+			instanceType, price, err := parseOnDemandPrice(product)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			prices[instanceType] = price
+		}
+		return true
+	}); err != nil {
+		// Prices are cached even if they are incomplete, so that failures do not retry on every provisioning loop
+		logging.FromContext(ctx).Errorf("Failed to retrieve on-demand prices, %s", err.Error())
+	}
+	if len(errs) > 0 {
+		logging.FromContext(ctx).Debugf("Ignored %d on-demand prices that could not be parsed, e.g. %s", len(errs), errs[0].Error())
+	}
+	logging.FromContext(ctx).Debugf("Discovered on-demand prices for %d instance types", len(prices))
+	p.cache.SetDefault(OnDemandPricesCacheKey, prices)
+	return prices
+}
+
+func (p *PricingProvider) getSpotPrices(ctx context.Context) map[string]map[string]float64 {
+	if cached, ok := p.cache.Get(SpotPricesCacheKey); ok {
+		return cached.(map[string]map[string]float64)
+	}
+	return p.updateSpotPrices(ctx)
+}
+
+// updateSpotPrices retrieves and caches the current spot prices
+func (p *PricingProvider) updateSpotPrices(ctx context.Context) map[string]map[string]float64 {
+	prices := map[string]map[string]float64{}
+	timestamps := map[string]time.Time{}
+	if err := p.ec2api.DescribeSpotPriceHistoryPagesWithContext(ctx, &ec2.DescribeSpotPriceHistoryInput{
+		ProductDescriptions: aws.StringSlice([]string{"Linux/UNIX"}),
+		// A start time of now returns only the current price of each instance type in each zone
+		StartTime: aws.Time(time.Now()),
+	}, func(output *ec2.DescribeSpotPriceHistoryOutput, lastPage bool) bool {
+		for _, spotPrice := range output.SpotPriceHistory {
+			price, err := strconv.ParseFloat(aws.StringValue(spotPrice.SpotPrice), 64)
+			if err != nil {
+				continue
+			}
+			instanceType := aws.StringValue(spotPrice.InstanceType)
+			zone := aws.StringValue(spotPrice.AvailabilityZone)
+			// Keep the most recent price if the history contains more than one
+			key := fmt.Sprintf("%s:%s", instanceType, zone)
+			if timestamp, ok := timestamps[key]; ok && timestamp.After(aws.TimeValue(spotPrice.Timestamp)) {
+				continue
+			}
+			timestamps[key] = aws.TimeValue(spotPrice.Timestamp)
+			if _, ok := prices[instanceType]; !ok {
+				prices[instanceType] = map[string]float64{}
+			}
+			prices[instanceType][zone] = price
+		}
+		return true
+	}); err != nil {
+		logging.FromContext(ctx).Errorf("Failed to retrieve spot prices, %s", err.Error())
+	}
+	logging.FromContext(ctx).Debugf("Discovered spot prices for %d instance types", len(prices))
+	p.cache.SetDefault(SpotPricesCacheKey, prices)
+	return prices
+}
+
+func termMatch(field string, value string) *pricing.Filter {
+	return &pricing.Filter{Field: aws.String(field), Type: aws.String(pricing.FilterTypeTermMatch), Value: aws.String(value)}
+}
+
+// priceListProduct is the subset of a product in the AWS Price List API that
+// describes the on-demand price of an instance type
+type priceListProduct struct {
+	Product struct {
+		Attributes struct {
+			InstanceType string `json:"instanceType"`
+		} `json:"attributes"`
+	} `json:"product"`
+	Terms struct {
+		OnDemand map[string]struct {
+			PriceDimensions map[string]struct {
+				PricePerUnit map[string]string `json:"pricePerUnit"`
+			} `json:"priceDimensions"`
+		} `json:"OnDemand"`
+	} `json:"terms"`
+}
+
+// parseOnDemandPrice returns the instance type and hourly price in USD of a
+// product in the AWS Price List API
+func parseOnDemandPrice(product aws.JSONValue) (string, float64, error) {
+	raw, err := json.Marshal(product)
+	if err != nil {
+		return "", 0, fmt.Errorf("encoding product, %w", err)
+	}
+	parsed := priceListProduct{}
+	if err := json.Unmarshal(raw, &parsed); err != nil {
+		return "", 0, fmt.Errorf("decoding product, %w", err)
+	}
+	if parsed.Product.Attributes.InstanceType == "" {
+		return "", 0, fmt.Errorf("product has no instance type")
+	}
+	for _, term := range parsed.Terms.OnDemand {
+		for _, dimension := range term.PriceDimensions {
+			usd, ok := dimension.PricePerUnit["USD"]
+			if !ok {
+				continue
+			}
+			price, err := strconv.ParseFloat(usd, 64)
+			if err != nil {
+				return "", 0, fmt.Errorf("parsing price of %s, %w", parsed.Product.Attributes.InstanceType, err)
+			}
+			return parsed.Product.Attributes.InstanceType, price, nil
+		}
+	}
+	return "", 0, fmt.Errorf("product %s has no on-demand price", parsed.Product.Attributes.InstanceType)
+}
@@ -0,0 +1,82 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"fmt"
+
+	karpenterlogging "github.com/aws/karpenter/pkg/utils/logging"
+	"go.uber.org/multierr"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const refreshControllerName = "refresh"
+
+// RefreshController refreshes the instance types, their zonal offerings and
+// prices in the background, so that provisioning uses cached values rather
+// than waiting for the EC2 and pricing APIs. If a refresh fails, values that
+// expire are retrieved when provisioning instead. Each account and region that
+// instances are launched in is refreshed.
+type RefreshController struct {
+	providers func(context.Context) ([]*providers, error)
+}
+
+// NewRefreshController constructs a controller instance
+func NewRefreshController(providers func(context.Context) ([]*providers, error)) *RefreshController {
+	return &RefreshController{providers: providers}
+}
+
+// Reconcile refreshes the instance types, and requeues itself until the next refresh
+func (c *RefreshController) Reconcile(ctx context.Context, _ reconcile.Request) (reconcile.Result, error) {
+	ctx = karpenterlogging.WithController(ctx, refreshControllerName)
+	targets, err := c.providers(ctx)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	var errs error
+	for _, p := range targets {
+		if err := p.instanceTypeProvider.Refresh(ctx); err != nil {
+			errs = multierr.Append(errs, fmt.Errorf("refreshing instance types, %w", err))
+		}
+	}
+	if errs != nil {
+		return reconcile.Result{}, errs
+	}
+	return reconcile.Result{RequeueAfter: InstanceTypesRefreshInterval}, nil
+}
+
+// Register the controller, which is started with a single request that
+// requeues itself. It only runs on the leader.
+func (c *RefreshController) Register(_ context.Context, m manager.Manager) error {
+	refreshController, err := controller.New(refreshControllerName, m, controller.Options{
+		Reconciler:              c,
+		MaxConcurrentReconciles: 1,
+	})
+	if err != nil {
+		return err
+	}
+	return refreshController.Watch(source.Func(func(_ context.Context, _ handler.EventHandler, queue workqueue.RateLimitingInterface, _ ...predicate.Predicate) error {
+		queue.Add(reconcile.Request{NamespacedName: types.NamespacedName{Name: refreshControllerName}})
+		return nil
+	}), &handler.Funcs{})
+}
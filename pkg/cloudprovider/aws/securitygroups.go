@@ -17,17 +17,29 @@ package aws
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/karpenter/pkg/cloudprovider"
 	"github.com/aws/karpenter/pkg/cloudprovider/aws/apis/v1alpha1"
 	"github.com/aws/karpenter/pkg/utils/injection"
 	"github.com/mitchellh/hashstructure/v2"
 	"github.com/patrickmn/go-cache"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"knative.dev/pkg/logging"
 )
 
+const (
+	// SecurityGroupsNotFoundReason is reported on the provisioner's Validated
+	// condition when the selector doesn't resolve to the security groups it lists
+	SecurityGroupsNotFoundReason = "SecurityGroupsNotFound"
+	// SecurityGroupsConflictReason is reported on the provisioner's Validated
+	// condition when the security groups and subnets are in different VPCs
+	SecurityGroupsConflictReason = "SecurityGroupsConflict"
+)
+
 type SecurityGroupProvider struct {
 	ec2api ec2iface.EC2API
 	cache  *cache.Cache
@@ -41,11 +53,48 @@ func NewSecurityGroupProvider(ec2api ec2iface.EC2API) *SecurityGroupProvider {
 }
 
 func (s *SecurityGroupProvider) Get(ctx context.Context, constraints *v1alpha1.Constraints) ([]string, error) {
+	securityGroups, err := s.get(ctx, constraints)
+	if err != nil {
+		return nil, err
+	}
+	return s.securityGroupIds(securityGroups), nil
+}
+
+// Verify returns a configuration error if the selector doesn't resolve to any
+// security groups, or if the security groups aren't all in the same VPC as the
+// subnets. Instances can only be launched with security groups in the VPC of
+// their subnet.
+func (s *SecurityGroupProvider) Verify(ctx context.Context, constraints *v1alpha1.Constraints, subnets []*ec2.Subnet) error {
+	securityGroups, err := s.get(ctx, constraints)
+	if err != nil {
+		return err
+	}
+	vpcs := map[string][]string{}
+	for _, subnet := range subnets {
+		if vpc := aws.StringValue(subnet.VpcId); vpc != "" {
+			vpcs[vpc] = append(vpcs[vpc], aws.StringValue(subnet.SubnetId))
+		}
+	}
+	for _, securityGroup := range securityGroups {
+		if vpc := aws.StringValue(securityGroup.VpcId); vpc != "" {
+			vpcs[vpc] = append(vpcs[vpc], aws.StringValue(securityGroup.GroupId))
+		}
+	}
+	if len(vpcs) > 1 {
+		return cloudprovider.NewConfigurationError(SecurityGroupsConflictReason, fmt.Errorf("security groups and subnets must be in the same vpc, found %v", vpcs))
+	}
+	return nil
+}
+
+func (s *SecurityGroupProvider) get(ctx context.Context, constraints *v1alpha1.Constraints) ([]*ec2.SecurityGroup, error) {
 	// Get SecurityGroups
 	securityGroups, err := s.getSecurityGroups(ctx, s.getFilters(constraints))
 	if err != nil {
 		return nil, err
 	}
+	if missing := s.missing(constraints, securityGroups); len(missing) != 0 {
+		return nil, cloudprovider.NewConfigurationError(SecurityGroupsNotFoundReason, fmt.Errorf("security groups %v do not exist", missing))
+	}
 	// This hack works around
 	// https://github.com/kubernetes-sigs/aws-load-balancer-controller/issues/2367
 	// The LoadBalancer Controller expects a single security group with the
@@ -53,20 +102,25 @@ func (s *SecurityGroupProvider) Get(ctx context.Context, constraints *v1alpha1.C
 	securityGroups = s.filterClusterTaggedGroups(ctx, securityGroups)
 	// Fail if no security groups found
 	if len(securityGroups) == 0 {
-		return nil, fmt.Errorf("no security groups exist given constraints")
-	}
-	// Convert to IDs
-	securityGroupIds := []string{}
-	for _, securityGroup := range securityGroups {
-		securityGroupIds = append(securityGroupIds, aws.StringValue(securityGroup.GroupId))
+		return nil, cloudprovider.NewConfigurationError(SecurityGroupsNotFoundReason, fmt.Errorf("no security groups exist given constraints"))
 	}
-	return securityGroupIds, nil
+	return securityGroups, nil
 }
 
 func (s *SecurityGroupProvider) getFilters(constraints *v1alpha1.Constraints) []*ec2.Filter {
 	filters := []*ec2.Filter{}
 	for key, value := range constraints.SecurityGroupSelector {
-		if value == "*" {
+		if key == v1alpha1.SecurityGroupIDsSelectorKey {
+			filters = append(filters, &ec2.Filter{
+				Name:   aws.String("group-id"),
+				Values: aws.StringSlice(selectorValues(value)),
+			})
+		} else if key == v1alpha1.SecurityGroupNamesSelectorKey {
+			filters = append(filters, &ec2.Filter{
+				Name:   aws.String("group-name"),
+				Values: aws.StringSlice(selectorValues(value)),
+			})
+		} else if value == "*" {
 			filters = append(filters, &ec2.Filter{
 				Name:   aws.String("tag-key"),
 				Values: []*string{aws.String(key)},
@@ -81,6 +135,35 @@ func (s *SecurityGroupProvider) getFilters(constraints *v1alpha1.Constraints) []
 	return filters
 }
 
+// missing returns the IDs and names listed by the selector that don't match any
+// of the security groups
+func (s *SecurityGroupProvider) missing(constraints *v1alpha1.Constraints, securityGroups []*ec2.SecurityGroup) []string {
+	found := sets.NewString()
+	for _, securityGroup := range securityGroups {
+		found.Insert(aws.StringValue(securityGroup.GroupId), aws.StringValue(securityGroup.GroupName))
+	}
+	missing := []string{}
+	for _, key := range []string{v1alpha1.SecurityGroupIDsSelectorKey, v1alpha1.SecurityGroupNamesSelectorKey} {
+		if value, ok := constraints.SecurityGroupSelector[key]; ok {
+			for _, item := range selectorValues(value) {
+				if !found.Has(item) {
+					missing = append(missing, item)
+				}
+			}
+		}
+	}
+	return missing
+}
+
+// selectorValues splits the comma separated list of a selector's value
+func selectorValues(value string) []string {
+	values := []string{}
+	for _, item := range strings.Split(value, ",") {
+		values = append(values, strings.TrimSpace(item))
+	}
+	return values
+}
+
 func (s *SecurityGroupProvider) getSecurityGroups(ctx context.Context, filters []*ec2.Filter) ([]*ec2.SecurityGroup, error) {
 	hash, err := hashstructure.Hash(filters, hashstructure.FormatV2, nil)
 	if err != nil {
@@ -17,6 +17,8 @@ package aws
 import (
 	"context"
 	"fmt"
+	"sort"
+	"sync"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
@@ -28,7 +30,13 @@ import (
 	"knative.dev/pkg/logging"
 )
 
+// SubnetProvider discovers subnets and tracks the IP addresses that are
+// available in them. Subnets are described again once their cache entry
+// expires, which refreshes the available IP addresses. In the meantime, the
+// addresses used by instances that are launched into them are accounted for,
+// so that launches are spread across the subnets in each zone.
 type SubnetProvider struct {
+	sync.Mutex
 	ec2api ec2iface.EC2API
 	cache  *cache.Cache
 }
@@ -40,32 +48,121 @@ func NewSubnetProvider(ec2api ec2iface.EC2API) *SubnetProvider {
 	}
 }
 
+// Get returns the subnets that match the constraints' selector, ordered by the
+// number of IP addresses that are available in them, most first.
 func (s *SubnetProvider) Get(ctx context.Context, constraints *v1alpha1.AWS) ([]*ec2.Subnet, error) {
 	filters := getFilters(constraints)
 	hash, err := hashstructure.Hash(filters, hashstructure.FormatV2, nil)
 	if err != nil {
 		return nil, err
 	}
-	if subnets, ok := s.cache.Get(fmt.Sprint(hash)); ok {
-		return subnets.([]*ec2.Subnet), nil
+	if subnets, ok := s.get(fmt.Sprint(hash)); ok {
+		return subnets, nil
 	}
 	output, err := s.ec2api.DescribeSubnetsWithContext(ctx, &ec2.DescribeSubnetsInput{Filters: filters})
 	if err != nil {
 		return nil, fmt.Errorf("describing subnets %s, %w", pretty.Concise(filters), err)
 	}
-	if len(output.Subnets) == 0 {
+	subnets := output.Subnets
+	// Outposts only offer the instance types that they have capacity for, so
+	// their subnets aren't used unless they are selected by Outpost ARN
+	if _, ok := constraints.SubnetSelector[v1alpha1.SubnetOutpostARNsSelectorKey]; !ok {
+		subnets = []*ec2.Subnet{}
+		for _, subnet := range output.Subnets {
+			if subnet.OutpostArn == nil {
+				subnets = append(subnets, subnet)
+			}
+		}
+	}
+	if len(subnets) == 0 {
 		return nil, fmt.Errorf("no subnets matched selector %v", constraints.SubnetSelector)
 	}
-	s.cache.SetDefault(fmt.Sprint(hash), output.Subnets)
-	logging.FromContext(ctx).Debugf("Discovered subnets: %s", prettySubnets(output.Subnets))
-	return output.Subnets, nil
+	s.set(fmt.Sprint(hash), subnets)
+	logging.FromContext(ctx).Debugf("Discovered subnets: %s", prettySubnets(subnets))
+	subnets, _ = s.get(fmt.Sprint(hash))
+	return subnets, nil
+}
+
+// Launched accounts for the IP addresses used by instances that were launched
+// into the subnet.
+func (s *SubnetProvider) Launched(subnetID string, instances int) {
+	s.update(subnetID, func(available int64) int64 {
+		if available < int64(instances) {
+			return 0
+		}
+		return available - int64(instances)
+	})
+}
+
+// Exhausted records that the subnet has no available IP addresses, and
+// returns false if it was already known to have none.
+func (s *SubnetProvider) Exhausted(subnetID string) (exhausted bool) {
+	s.update(subnetID, func(available int64) int64 {
+		exhausted = exhausted || available > 0
+		return 0
+	})
+	return exhausted
+}
+
+// get returns copies of the cached subnets, ordered by available IP addresses
+func (s *SubnetProvider) get(key string) ([]*ec2.Subnet, bool) {
+	s.Lock()
+	defer s.Unlock()
+	cached, ok := s.cache.Get(key)
+	if !ok {
+		return nil, false
+	}
+	subnets := []*ec2.Subnet{}
+	for _, subnet := range cached.([]*ec2.Subnet) {
+		copied := *subnet
+		subnets = append(subnets, &copied)
+	}
+	sort.SliceStable(subnets, func(i, j int) bool {
+		return aws.Int64Value(subnets[i].AvailableIpAddressCount) > aws.Int64Value(subnets[j].AvailableIpAddressCount)
+	})
+	return subnets, true
+}
+
+// set caches copies of the subnets, since their available IP addresses are updated
+func (s *SubnetProvider) set(key string, subnets []*ec2.Subnet) {
+	s.Lock()
+	defer s.Unlock()
+	cached := []*ec2.Subnet{}
+	for _, subnet := range subnets {
+		copied := *subnet
+		cached = append(cached, &copied)
+	}
+	s.cache.SetDefault(key, cached)
+}
+
+// update the available IP addresses of the subnet wherever it is cached
+func (s *SubnetProvider) update(subnetID string, available func(int64) int64) {
+	s.Lock()
+	defer s.Unlock()
+	for _, item := range s.cache.Items() {
+		for _, subnet := range item.Object.([]*ec2.Subnet) {
+			if aws.StringValue(subnet.SubnetId) == subnetID {
+				subnet.AvailableIpAddressCount = aws.Int64(available(aws.Int64Value(subnet.AvailableIpAddressCount)))
+			}
+		}
+	}
 }
 
 func getFilters(constraints *v1alpha1.AWS) []*ec2.Filter {
 	filters := []*ec2.Filter{}
 	// Filter by subnet
 	for key, value := range constraints.SubnetSelector {
-		if value == "*" {
+		if key == v1alpha1.SubnetOutpostARNsSelectorKey {
+			filters = append(filters, &ec2.Filter{
+				Name:   aws.String("outpost-arn"),
+				Values: aws.StringSlice(selectorValues(value)),
+			})
+		} else if key == v1alpha1.SubnetZonesSelectorKey {
+			filters = append(filters, &ec2.Filter{
+				Name:   aws.String("availability-zone"),
+				Values: aws.StringSlice(selectorValues(value)),
+			})
+		} else if value == "*" {
 			filters = append(filters, &ec2.Filter{
 				Name:   aws.String("tag-key"),
 				Values: []*string{aws.String(key)},
@@ -83,7 +180,7 @@ func getFilters(constraints *v1alpha1.AWS) []*ec2.Filter {
 func prettySubnets(subnets []*ec2.Subnet) []string {
 	names := []string{}
 	for _, subnet := range subnets {
-		names = append(names, fmt.Sprintf("%s (%s)", aws.StringValue(subnet.SubnetId), aws.StringValue(subnet.AvailabilityZone)))
+		names = append(names, fmt.Sprintf("%s (%s, %d available IPs)", aws.StringValue(subnet.SubnetId), aws.StringValue(subnet.AvailabilityZone), aws.Int64Value(subnet.AvailableIpAddressCount)))
 	}
 	return names
 }
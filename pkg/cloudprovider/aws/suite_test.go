@@ -18,43 +18,84 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/Pallinder/go-randomdata"
 	"github.com/aws/amazon-vpc-resource-controller-k8s/pkg/aws/vpc"
 	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
 	"github.com/aws/karpenter/pkg/cloudprovider/aws/apis/v1alpha1"
 	"github.com/aws/karpenter/pkg/cloudprovider/aws/fake"
+	"github.com/aws/karpenter/pkg/cloudprovider/conformance"
 	"github.com/aws/karpenter/pkg/cloudprovider/registry"
 	"github.com/aws/karpenter/pkg/controllers/provisioning"
 	"github.com/aws/karpenter/pkg/controllers/selection"
+	"github.com/aws/karpenter/pkg/controllers/state"
+	"github.com/aws/karpenter/pkg/controllers/status"
+	"github.com/aws/karpenter/pkg/events"
 	"github.com/aws/karpenter/pkg/test"
 	. "github.com/aws/karpenter/pkg/test/expectations"
+	"github.com/aws/karpenter/pkg/utils/featuregates"
 	"github.com/aws/karpenter/pkg/utils/injection"
 	"github.com/aws/karpenter/pkg/utils/options"
 	"github.com/aws/karpenter/pkg/utils/resources"
+	"github.com/aws/karpenter/pkg/utils/settings"
 	"github.com/patrickmn/go-cache"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/pricing"
+	"github.com/aws/aws-sdk-go/service/sqs"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+	"knative.dev/pkg/apis"
 	. "knative.dev/pkg/logging/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
 var ctx context.Context
 var env *test.Environment
 var launchTemplateCache *cache.Cache
+var amiCache *cache.Cache
+var subnetCache *cache.Cache
+var securityGroupCache *cache.Cache
+var instanceProfileCache *cache.Cache
 var unavailableOfferingsCache *cache.Cache
+var failedClientTokensCache *cache.Cache
+var instanceTypeCache *cache.Cache
+var pricingCache *cache.Cache
 var fakeEC2API *fake.EC2API
+var fakeIAMAPI *fake.IAMAPI
+var fakeSSMAPI *fake.SSMAPI
+var fakeSQSAPI *fake.SQSAPI
+var fakePricingAPI *fake.PricingAPI
+var fakeOutpostsAPI *fake.OutpostsAPI
+var cloudProvider *CloudProvider
+var nodeTemplateController *NodeTemplateController
 var provisioners *provisioning.Controller
 var selectionController *selection.Controller
+var statusController *status.Controller
+var cluster *state.Cluster
 
 func TestAPIs(t *testing.T) {
 	ctx = TestContextWithLogger(t)
@@ -65,38 +106,68 @@ func TestAPIs(t *testing.T) {
 var _ = BeforeSuite(func() {
 	env = test.NewEnvironment(ctx, func(e *test.Environment) {
 		opts := options.Options{
-			ClusterName:           "test-cluster",
-			ClusterEndpoint:       "https://test-cluster",
-			AWSNodeNameConvention: "ip-name",
+			ClusterName:                 "test-cluster",
+			ClusterEndpoint:             "https://test-cluster",
+			AWSNodeNameConvention:       "ip-name",
+			AWSENILimitedPodDensity:     true,
+			PendingPodRequeueInterval:   2 * time.Minute,
+			LeaderElectionLeaseDuration: 15 * time.Second,
+			LeaderElectionRenewDeadline: 10 * time.Second,
+			LeaderElectionRetryPeriod:   2 * time.Second,
+			HealthWindow:                5 * time.Minute,
+			HealthLatencyThreshold:      30 * time.Second,
 		}
 		Expect(opts.Validate()).To(Succeed(), "Failed to validate options")
 		ctx = injection.WithOptions(ctx, opts)
 		launchTemplateCache = cache.New(CacheTTL, CacheCleanupInterval)
+		amiCache = cache.New(CacheTTL, CacheCleanupInterval)
+		subnetCache = cache.New(CacheTTL, CacheCleanupInterval)
+		securityGroupCache = cache.New(CacheTTL, CacheCleanupInterval)
+		instanceProfileCache = cache.New(CacheTTL, CacheCleanupInterval)
 		unavailableOfferingsCache = cache.New(InsufficientCapacityErrorCacheTTL, InsufficientCapacityErrorCacheCleanupInterval)
+		failedClientTokensCache = cache.New(FailedClientTokensCacheTTL, CacheCleanupInterval)
 		fakeEC2API = &fake.EC2API{}
-		subnetProvider := NewSubnetProvider(fakeEC2API)
+		fakeIAMAPI = &fake.IAMAPI{}
+		fakeSSMAPI = &fake.SSMAPI{}
+		fakeSQSAPI = &fake.SQSAPI{}
+		fakePricingAPI = &fake.PricingAPI{}
+		fakeOutpostsAPI = &fake.OutpostsAPI{}
+		pricingCache = cache.New(PricesCacheTTL, CacheCleanupInterval)
+		instanceTypeCache = cache.New(InstanceTypesAndZonesCacheTTL, CacheCleanupInterval)
+		subnetProvider := &SubnetProvider{ec2api: fakeEC2API, cache: subnetCache}
 		instanceTypeProvider := &InstanceTypeProvider{
 			ec2api:               fakeEC2API,
+			outpostsapi:          fakeOutpostsAPI,
 			subnetProvider:       subnetProvider,
-			cache:                cache.New(InstanceTypesAndZonesCacheTTL, CacheCleanupInterval),
+			pricingProvider:      &PricingProvider{ec2api: fakeEC2API, pricingapi: fakePricingAPI, region: "test-region", cache: pricingCache},
+			cache:                instanceTypeCache,
 			unavailableOfferings: unavailableOfferingsCache,
 		}
 		clientSet := kubernetes.NewForConfigOrDie(e.Config)
-		cloudProvider := &CloudProvider{
-			subnetProvider:       subnetProvider,
-			instanceTypeProvider: instanceTypeProvider,
+		securityGroupProvider := &SecurityGroupProvider{ec2api: fakeEC2API, cache: securityGroupCache}
+		amiProvider := &AMIProvider{ssm: fakeSSMAPI, ec2api: fakeEC2API, clientSet: clientSet, cache: amiCache}
+		utilruntime.Must(v1alpha1.AddToScheme(e.Client.Scheme()))
+		cloudProvider = &CloudProvider{kubeClient: e.Client, providers: &providers{
+			subnetProvider:          subnetProvider,
+			instanceTypeProvider:    instanceTypeProvider,
+			securityGroupProvider:   securityGroupProvider,
+			amiProvider:             amiProvider,
+			instanceProfileProvider: &InstanceProfileProvider{iamapi: fakeIAMAPI, cache: instanceProfileCache},
 			instanceProvider: &InstanceProvider{
 				fakeEC2API, instanceTypeProvider, subnetProvider, &LaunchTemplateProvider{
 					ec2api:                fakeEC2API,
-					amiProvider:           NewAMIProvider(&fake.SSMAPI{}, clientSet),
-					securityGroupProvider: NewSecurityGroupProvider(fakeEC2API),
+					amiProvider:           amiProvider,
+					securityGroupProvider: securityGroupProvider,
 					cache:                 launchTemplateCache,
-				},
+				}, failedClientTokensCache,
 			},
-		}
+		}}
 		registry.RegisterOrDie(ctx, cloudProvider)
-		provisioners = provisioning.NewController(ctx, e.Client, clientSet.CoreV1(), cloudProvider)
-		selectionController = selection.NewController(e.Client, provisioners)
+		nodeTemplateController = NewNodeTemplateController(e.Client, cloudProvider.providersFor)
+		cluster = state.NewCluster()
+		provisioners = provisioning.NewController(ctx, e.Client, clientSet.CoreV1(), cloudProvider, cluster, events.NewRecorder(&record.FakeRecorder{}))
+		selectionController = selection.NewController(e.Client, provisioners, events.NewRecorder(&record.FakeRecorder{}))
+		statusController = status.NewController(e.Client, provisioners)
 	})
 
 	Expect(env.Start()).To(Succeed(), "Failed to start environment")
@@ -106,6 +177,29 @@ var _ = AfterSuite(func() {
 	Expect(env.Stop()).To(Succeed(), "Failed to stop environment")
 })
 
+var _ = Describe("Conformance", func() {
+	BeforeEach(func() {
+		fakeEC2API.Reset()
+		launchTemplateCache.Flush()
+		amiCache.Flush()
+		unavailableOfferingsCache.Flush()
+		pricingCache.Flush()
+	})
+	conformance.Describe(func() conformance.Options {
+		return conformance.Options{
+			Context:       ctx,
+			CloudProvider: cloudProvider,
+			Provisioner: func() *v1alpha5.Provisioner {
+				provisioner := ProvisionerWithProvider(&v1alpha5.Provisioner{ObjectMeta: metav1.ObjectMeta{Name: v1alpha5.DefaultProvisioner.Name}}, &v1alpha1.AWS{
+					InstanceProfile: "test-instance-profile",
+				})
+				provisioner.SetDefaults(ctx)
+				return provisioner
+			},
+		}
+	})
+})
+
 var _ = Describe("Allocation", func() {
 	var provisioner *v1alpha5.Provisioner
 	var provider *v1alpha1.AWS
@@ -117,12 +211,26 @@ var _ = Describe("Allocation", func() {
 		provisioner = ProvisionerWithProvider(&v1alpha5.Provisioner{ObjectMeta: metav1.ObjectMeta{Name: v1alpha5.DefaultProvisioner.Name}}, provider)
 		provisioner.SetDefaults(ctx)
 		fakeEC2API.Reset()
+		fakeSSMAPI.Reset()
+		fakeOutpostsAPI.Reset()
+		fakeIAMAPI.WantErr = nil
+		fakeIAMAPI.AttachedRolePolicies = nil
+		fakeIAMAPI.ListAttachedRolePoliciesErr = nil
+		fakePricingAPI.GetProductsOutput = nil
+		fakePricingAPI.WantErr = nil
 		launchTemplateCache.Flush()
+		amiCache.Flush()
+		subnetCache.Flush()
+		securityGroupCache.Flush()
+		instanceProfileCache.Flush()
 		unavailableOfferingsCache.Flush()
+		failedClientTokensCache.Flush()
+		pricingCache.Flush()
 	})
 
 	AfterEach(func() {
 		ExpectProvisioningCleanedUp(ctx, env.Client, provisioners)
+		ExpectClusterStateCleanedUp(cluster)
 	})
 
 	Context("Reconciliation", func() {
@@ -187,6 +295,38 @@ var _ = Describe("Allocation", func() {
 				}
 				Expect(nodeNames.Len()).To(Equal(2))
 			})
+			It("should launch instances for Habana Gaudi resource requests", func() {
+				nodeNames := sets.NewString()
+				for _, pod := range ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner,
+					test.UnschedulablePod(test.PodOptions{
+						ResourceRequirements: v1.ResourceRequirements{
+							Requests: v1.ResourceList{resources.HabanaGaudi: resource.MustParse("4")},
+							Limits:   v1.ResourceList{resources.HabanaGaudi: resource.MustParse("4")},
+						},
+					}),
+					// Should pack onto same instance
+					test.UnschedulablePod(test.PodOptions{
+						ResourceRequirements: v1.ResourceRequirements{
+							Requests: v1.ResourceList{resources.HabanaGaudi: resource.MustParse("4")},
+							Limits:   v1.ResourceList{resources.HabanaGaudi: resource.MustParse("4")},
+						},
+					})) {
+					node := ExpectScheduled(ctx, env.Client, pod)
+					Expect(node.Labels).To(HaveKeyWithValue(v1.LabelInstanceTypeStable, "dl1.24xlarge"))
+					nodeNames.Insert(node.Name)
+				}
+				Expect(nodeNames.Len()).To(Equal(1))
+			})
+			It("should not launch accelerated instance types for pods that don't request accelerators", func() {
+				for _, pod := range ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner,
+					test.UnschedulablePod(test.PodOptions{
+						ResourceRequirements: v1.ResourceRequirements{
+							Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("64")},
+						},
+					})) {
+					ExpectNotScheduled(ctx, env.Client, pod)
+				}
+			})
 			It("should launch instances for AWS Neuron resource requests", func() {
 				nodeNames := sets.NewString()
 				for _, pod := range ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner,
@@ -218,9 +358,128 @@ var _ = Describe("Allocation", func() {
 				Expect(nodeNames.Len()).To(Equal(2))
 			})
 		})
+		Context("Instance Types", func() {
+			AfterEach(func() {
+				instanceTypeCache.Flush()
+			})
+			It("should refresh instance types, zones and prices that aren't cached", func() {
+				instanceTypeCache.Flush()
+				pricingCache.Flush()
+				Expect(cloudProvider.instanceTypeProvider.Refresh(ctx)).To(Succeed())
+				for _, key := range []string{InstanceTypesCacheKey, InstanceTypeZonesCacheKey} {
+					_, ok := instanceTypeCache.Get(key)
+					Expect(ok).To(BeTrue())
+				}
+				for _, key := range []string{OnDemandPricesCacheKey, SpotPricesCacheKey} {
+					_, ok := pricingCache.Get(key)
+					Expect(ok).To(BeTrue())
+				}
+			})
+			It("should refresh instance types that expire before the next refresh", func() {
+				instanceTypeCache.Set(InstanceTypesCacheKey, map[string]*InstanceType{}, InstanceTypesRefreshInterval/2)
+				Expect(cloudProvider.instanceTypeProvider.Refresh(ctx)).To(Succeed())
+				cached, ok := instanceTypeCache.Get(InstanceTypesCacheKey)
+				Expect(ok).To(BeTrue())
+				Expect(cached).ToNot(BeEmpty())
+			})
+			It("should not refresh instance types that expire after the next refresh", func() {
+				instanceTypeCache.SetDefault(InstanceTypesCacheKey, map[string]*InstanceType{})
+				Expect(cloudProvider.instanceTypeProvider.Refresh(ctx)).To(Succeed())
+				cached, ok := instanceTypeCache.Get(InstanceTypesCacheKey)
+				Expect(ok).To(BeTrue())
+				Expect(cached).To(BeEmpty())
+			})
+			It("should limit provisioners that don't constrain instance types to the default instance families", func() {
+				instanceTypes, err := cloudProvider.GetInstanceTypes(injection.WithSettings(ctx, settings.NewStore(settings.Settings{
+					DefaultInstanceFamilies: sets.NewString("m5"),
+				})), provisioner)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(instanceTypes).ToNot(BeEmpty())
+				for _, instanceType := range instanceTypes {
+					Expect(instanceType.Name()).To(HavePrefix("m5."))
+				}
+			})
+			It("should not limit provisioners that constrain instance types to the default instance families", func() {
+				provisioner.Spec.Requirements = append(provisioner.Spec.Requirements, v1.NodeSelectorRequirement{
+					Key: v1alpha1.LabelInstanceCPU, Operator: v1.NodeSelectorOpGt, Values: []string{"2"},
+				})
+				instanceTypes, err := cloudProvider.GetInstanceTypes(injection.WithSettings(ctx, settings.NewStore(settings.Settings{
+					DefaultInstanceFamilies: sets.NewString("m5"),
+				})), provisioner)
+				Expect(err).ToNot(HaveOccurred())
+				names := []string{}
+				for _, instanceType := range instanceTypes {
+					names = append(names, instanceType.Name())
+				}
+				Expect(names).To(ContainElement(Not(HavePrefix("m5."))))
+			})
+			It("should requeue refreshes", func() {
+				result, err := NewRefreshController(cloudProvider.targetProviders(env.Client)).Reconcile(ctx, reconcile.Request{})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(result.RequeueAfter).To(Equal(InstanceTypesRefreshInterval))
+			})
+			It("should not share offerings between provisioners", func() {
+				zones := func(instanceTypes []cloudprovider.InstanceType) sets.String {
+					result := sets.NewString()
+					for _, instanceType := range instanceTypes {
+						for _, offering := range instanceType.Offerings() {
+							result.Insert(offering.Zone)
+						}
+					}
+					return result
+				}
+				instanceTypes, err := cloudProvider.instanceTypeProvider.Get(ctx, provider)
+				Expect(err).ToNot(HaveOccurred())
+				fakeEC2API.DescribeSubnetsOutput = &ec2.DescribeSubnetsOutput{Subnets: []*ec2.Subnet{
+					{SubnetId: aws.String("test-subnet-1"), AvailabilityZone: aws.String("test-zone-1a"), AvailableIpAddressCount: aws.Int64(100)},
+				}}
+				other, err := cloudProvider.instanceTypeProvider.Get(ctx, &v1alpha1.AWS{SubnetSelector: map[string]string{"Name": "test-subnet-1"}})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(zones(other).List()).To(ConsistOf("test-zone-1a"))
+				Expect(zones(instanceTypes).List()).To(ConsistOf("test-zone-1a", "test-zone-1b", "test-zone-1c"))
+			})
+			It("should label instance types with their attributes", func() {
+				instanceTypes, err := cloudProvider.instanceTypeProvider.Get(ctx, provider)
+				Expect(err).ToNot(HaveOccurred())
+				labels := map[string]map[string]string{}
+				for _, instanceType := range instanceTypes {
+					labels[instanceType.Name()] = instanceType.Labels()
+				}
+				Expect(labels["p3.8xlarge"]).To(Equal(map[string]string{
+					v1alpha1.LabelInstanceCPU:             "32",
+					v1alpha1.LabelInstanceMemory:          "249856",
+					v1alpha1.LabelInstanceGeneration:      "3",
+					v1alpha1.LabelInstanceHypervisor:      "nitro",
+					v1alpha1.LabelInstanceLocalNVMe:       "0",
+					v1alpha1.LabelInstanceGPUCount:        "4",
+					v1alpha1.LabelInstanceGPUManufacturer: "nvidia",
+				}))
+				Expect(labels["m5.large"]).ToNot(HaveKey(v1alpha1.LabelInstanceGPUManufacturer))
+				Expect(labels["m5.large"]).To(HaveKeyWithValue(v1alpha1.LabelInstanceGPUCount, "0"))
+			})
+			It("should launch instance types that satisfy instance attribute requirements", func() {
+				provisioner.Spec.Requirements = v1alpha5.Requirements{
+					{Key: v1alpha1.LabelInstanceCPU, Operator: v1.NodeSelectorOpIn, Values: []string{"4", "8", "16"}},
+				}
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod())[0]
+				node := ExpectScheduled(ctx, env.Client, pod)
+				Expect(node.Labels).To(HaveKeyWithValue(v1.LabelInstanceTypeStable, "m5.xlarge"))
+				Expect(node.Labels).To(HaveKeyWithValue(v1alpha1.LabelInstanceCPU, "4"))
+			})
+			It("should launch instance types that satisfy pods' numeric instance attribute requirements", func() {
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod(test.PodOptions{
+					NodeRequirements: []v1.NodeSelectorRequirement{
+						{Key: v1alpha1.LabelInstanceCPU, Operator: v1.NodeSelectorOpGt, Values: []string{"2"}},
+						{Key: v1alpha1.LabelInstanceCPU, Operator: v1.NodeSelectorOpLt, Values: []string{"8"}},
+					},
+				}))[0]
+				node := ExpectScheduled(ctx, env.Client, pod)
+				Expect(node.Labels).To(HaveKeyWithValue(v1.LabelInstanceTypeStable, "m5.xlarge"))
+			})
+		})
 		Context("Insufficient Capacity Error Cache", func() {
 			It("should launch instances of different type on second reconciliation attempt with Insufficient Capacity Error Cache fallback", func() {
-				fakeEC2API.InsufficientCapacityPools = []fake.CapacityPool{{CapacityType: v1alpha1.CapacityTypeOnDemand, InstanceType: "inf1.6xlarge", Zone: "test-zone-1a"}}
+				fakeEC2API.InsufficientCapacityPools = []fake.CapacityPool{{CapacityType: v1alpha1.CapacityTypeOnDemand, InstanceType: "inf1.2xlarge", Zone: "test-zone-1a"}}
 				pods := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner,
 					test.UnschedulablePod(test.PodOptions{
 						NodeSelector: map[string]string{v1.LabelTopologyZone: "test-zone-1a"},
@@ -237,17 +496,17 @@ var _ = Describe("Allocation", func() {
 						},
 					}),
 				)
-				// it should've tried to pack them on a single inf1.6xlarge then hit an insufficient capacity error
+				// it should've tried to pack them on two cheaper inf1.2xlarge then hit an insufficient capacity error
 				for _, pod := range pods {
 					ExpectNotScheduled(ctx, env.Client, pod)
 				}
 				nodeNames := sets.NewString()
 				for _, pod := range ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, pods...) {
 					node := ExpectScheduled(ctx, env.Client, pod)
-					Expect(node.Labels).To(HaveKeyWithValue(v1.LabelInstanceTypeStable, "inf1.2xlarge"))
+					Expect(node.Labels).To(HaveKeyWithValue(v1.LabelInstanceTypeStable, "inf1.6xlarge"))
 					nodeNames.Insert(node.Name)
 				}
-				Expect(nodeNames.Len()).To(Equal(2))
+				Expect(nodeNames.Len()).To(Equal(1))
 			})
 			It("should launch instances in a different zone on second reconciliation attempt with Insufficient Capacity Error Cache fallback", func() {
 				fakeEC2API.InsufficientCapacityPools = []fake.CapacityPool{{CapacityType: v1alpha1.CapacityTypeOnDemand, InstanceType: "p3.8xlarge", Zone: "test-zone-1a"}}
@@ -300,13 +559,28 @@ var _ = Describe("Allocation", func() {
 					{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-1a"}},
 					{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpIn, Values: []string{"m5.large"}},
 				}
-				// Spot Unavailable
+				// Spot unavailable, fallback to OD in the same attempt
 				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod())[0]
-				ExpectNotScheduled(ctx, env.Client, pod)
-				// Fallback to OD
-				pod = ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod())[0]
 				node := ExpectScheduled(ctx, env.Client, pod)
 				Expect(node.Labels).To(HaveKeyWithValue(v1alpha5.LabelCapacityType, v1alpha1.CapacityTypeOnDemand))
+				capacityTypes := []string{}
+				for input := range fakeEC2API.CalledWithCreateFleetInput.Iter() {
+					capacityTypes = append(capacityTypes, aws.StringValue(input.(*ec2.CreateFleetInput).TargetCapacitySpecification.DefaultTargetCapacityType))
+				}
+				Expect(capacityTypes).To(ConsistOf(v1alpha1.CapacityTypeSpot, v1alpha1.CapacityTypeOnDemand))
+			})
+			It("should not fallback to on-demand capacity if spot is unavailable and on-demand is not allowed", func() {
+				fakeEC2API.InsufficientCapacityPools = []fake.CapacityPool{{CapacityType: v1alpha1.CapacityTypeSpot, InstanceType: "m5.large", Zone: "test-zone-1a"}}
+				provisioner.Spec.Requirements = v1alpha5.Requirements{
+					{Key: v1alpha5.LabelCapacityType, Operator: v1.NodeSelectorOpIn, Values: []string{v1alpha1.CapacityTypeSpot}},
+					{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-1a"}},
+					{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpIn, Values: []string{"m5.large"}},
+				}
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod())[0]
+				ExpectNotScheduled(ctx, env.Client, pod)
+				Expect(fakeEC2API.CalledWithCreateFleetInput.Cardinality()).To(Equal(1))
+				input := fakeEC2API.CalledWithCreateFleetInput.Pop().(*ec2.CreateFleetInput)
+				Expect(aws.StringValue(input.TargetCapacitySpecification.DefaultTargetCapacityType)).To(Equal(v1alpha1.CapacityTypeSpot))
 			})
 		})
 		Context("CapacityType", func() {
@@ -322,6 +596,149 @@ var _ = Describe("Allocation", func() {
 				Expect(node.Labels).To(HaveKeyWithValue(v1alpha5.LabelCapacityType, v1alpha1.CapacityTypeSpot))
 			})
 		})
+		Context("Client Tokens", func() {
+			var instanceTypes []cloudprovider.InstanceType
+			BeforeEach(func() {
+				provisioner.Spec.Requirements = v1alpha5.Requirements{
+					{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-1a"}},
+					{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpIn, Values: []string{"m5.large"}},
+				}
+				var err error
+				instanceTypes, err = cloudProvider.GetInstanceTypes(ctx, provisioner)
+				Expect(err).ToNot(HaveOccurred())
+				for _, instanceType := range instanceTypes {
+					if instanceType.Name() == "m5.large" {
+						instanceTypes = []cloudprovider.InstanceType{instanceType}
+						break
+					}
+				}
+			})
+			create := func(launchID string) ([]string, error) {
+				names := []string{}
+				err := cloudProvider.Create(injection.WithLaunchID(ctx, launchID), &provisioner.Spec.Constraints, instanceTypes, 1, func(node *v1.Node) error {
+					names = append(names, node.Name)
+					return nil
+				})
+				return names, err
+			}
+			It("should launch instances with a client token", func() {
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod())[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				Expect(fakeEC2API.CalledWithCreateFleetInput.Cardinality()).To(Equal(1))
+				input := fakeEC2API.CalledWithCreateFleetInput.Pop().(*ec2.CreateFleetInput)
+				Expect(input.ClientToken).ToNot(BeNil())
+			})
+			It("should return the instances that were already launched when a launch is retried", func() {
+				launched, err := create("test-launch")
+				Expect(err).ToNot(HaveOccurred())
+				retried, err := create("test-launch")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(retried).To(Equal(launched))
+				tokens := sets.NewString()
+				for input := range fakeEC2API.CalledWithCreateFleetInput.Iter() {
+					tokens.Insert(aws.StringValue(input.(*ec2.CreateFleetInput).ClientToken))
+				}
+				Expect(tokens.Len()).To(Equal(1))
+			})
+			It("should launch instances for different launches", func() {
+				launched, err := create("test-launch")
+				Expect(err).ToNot(HaveOccurred())
+				other, err := create("other-test-launch")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(other).ToNot(Equal(launched))
+			})
+			It("should retry launches that launched no instances", func() {
+				fakeEC2API.InsufficientCapacityPools = []fake.CapacityPool{{CapacityType: v1alpha1.CapacityTypeOnDemand, InstanceType: "m5.large", Zone: "test-zone-1a"}}
+				_, err := create("test-launch")
+				Expect(cloudprovider.IsInsufficientCapacityError(err)).To(BeTrue())
+				// Capacity becomes available
+				fakeEC2API.InsufficientCapacityPools = []fake.CapacityPool{}
+				unavailableOfferingsCache.Flush()
+				launched, err := create("test-launch")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(launched).To(HaveLen(1))
+			})
+			It("should not use a client token for launches that aren't identified", func() {
+				_, err := create("")
+				Expect(err).ToNot(HaveOccurred())
+				input := fakeEC2API.CalledWithCreateFleetInput.Pop().(*ec2.CreateFleetInput)
+				Expect(input.ClientToken).To(BeNil())
+			})
+		})
+		Context("Pricing", func() {
+			BeforeEach(func() {
+				provisioner.Spec.Requirements = v1alpha5.Requirements{
+					{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpIn, Values: []string{"m5.large"}},
+					{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-1a"}},
+				}
+				fakePricingAPI.GetProductsOutput = &pricing.GetProductsOutput{PriceList: []aws.JSONValue{{
+					"product": map[string]interface{}{"attributes": map[string]interface{}{"instanceType": "m5.large"}},
+					"terms": map[string]interface{}{"OnDemand": map[string]interface{}{"term": map[string]interface{}{
+						"priceDimensions": map[string]interface{}{"dimension": map[string]interface{}{"pricePerUnit": map[string]interface{}{"USD": "0.0960000000"}}},
+					}}},
+				}}}
+				fakeEC2API.DescribeSpotPriceHistoryOutput = &ec2.DescribeSpotPriceHistoryOutput{SpotPriceHistory: []*ec2.SpotPrice{
+					{InstanceType: aws.String("m5.large"), AvailabilityZone: aws.String("test-zone-1a"), SpotPrice: aws.String("0.0350"), Timestamp: aws.Time(time.Now())},
+				}}
+			})
+			It("should annotate nodes with the on demand price", func() {
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod())[0]
+				node := ExpectScheduled(ctx, env.Client, pod)
+				Expect(node.Annotations).To(HaveKeyWithValue(v1alpha5.PriceAnnotationKey, "0.096"))
+			})
+			It("should annotate nodes with the spot price", func() {
+				provisioner.Spec.Requirements = append(provisioner.Spec.Requirements, v1.NodeSelectorRequirement{Key: v1alpha5.LabelCapacityType, Operator: v1.NodeSelectorOpIn, Values: []string{v1alpha1.CapacityTypeSpot}})
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod())[0]
+				node := ExpectScheduled(ctx, env.Client, pod)
+				Expect(node.Annotations).To(HaveKeyWithValue(v1alpha5.PriceAnnotationKey, "0.035"))
+			})
+			It("should not annotate nodes if prices are unavailable", func() {
+				fakePricingAPI.WantErr = fmt.Errorf("pricing unavailable")
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod())[0]
+				node := ExpectScheduled(ctx, env.Client, pod)
+				Expect(node.Annotations).ToNot(HaveKey(v1alpha5.PriceAnnotationKey))
+			})
+		})
+		Context("Spot Allocation Strategy", func() {
+			BeforeEach(func() {
+				provisioner.Spec.Requirements = v1alpha5.Requirements{{Key: v1alpha5.LabelCapacityType, Operator: v1.NodeSelectorOpIn, Values: []string{v1alpha1.CapacityTypeSpot}}}
+			})
+			It("should default to capacity-optimized-prioritized with prioritized instance types", func() {
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				Expect(fakeEC2API.CalledWithCreateFleetInput.Cardinality()).To(Equal(1))
+				input := fakeEC2API.CalledWithCreateFleetInput.Pop().(*ec2.CreateFleetInput)
+				Expect(*input.SpotOptions.AllocationStrategy).To(Equal(ec2.SpotAllocationStrategyCapacityOptimizedPrioritized))
+				for _, launchTemplateConfig := range input.LaunchTemplateConfigs {
+					for _, override := range launchTemplateConfig.Overrides {
+						Expect(override.Priority).ToNot(BeNil())
+					}
+				}
+			})
+			for _, strategy := range []string{
+				ec2.SpotAllocationStrategyLowestPrice,
+				ec2.SpotAllocationStrategyCapacityOptimized,
+				v1alpha1.SpotAllocationStrategyPriceCapacityOptimized,
+			} {
+				strategy := strategy
+				It(fmt.Sprintf("should use the %s spot allocation strategy without priorities", strategy), func() {
+					provider.SpotAllocationStrategy = aws.String(strategy)
+					pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+					ExpectScheduled(ctx, env.Client, pod)
+					Expect(fakeEC2API.CalledWithCreateFleetInput.Cardinality()).To(Equal(1))
+					input := fakeEC2API.CalledWithCreateFleetInput.Pop().(*ec2.CreateFleetInput)
+					Expect(*input.SpotOptions.AllocationStrategy).To(Equal(strategy))
+					instanceTypes := sets.NewString()
+					for _, launchTemplateConfig := range input.LaunchTemplateConfigs {
+						for _, override := range launchTemplateConfig.Overrides {
+							Expect(override.Priority).To(BeNil())
+							instanceTypes.Insert(aws.StringValue(override.InstanceType))
+						}
+					}
+					Expect(instanceTypes.Len()).To(BeNumerically(">", 1))
+				})
+			}
+		})
 		Context("LaunchTemplates", func() {
 			It("should use same launch template for equivalent constraints", func() {
 				t1 := v1.Toleration{
@@ -351,6 +768,7 @@ var _ = Describe("Allocation", func() {
 				ExpectScheduled(ctx, env.Client, pod1)
 				Expect(fakeEC2API.CalledWithCreateFleetInput.Cardinality()).To(Equal(1))
 				name1 := fakeEC2API.CalledWithCreateFleetInput.Pop().(*ec2.CreateFleetInput).LaunchTemplateConfigs[0].LaunchTemplateSpecification.LaunchTemplateName
+				ExpectClusterStateCleanedUp(cluster)
 
 				pod2 := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner,
 					test.UnschedulablePod(test.PodOptions{
@@ -373,8 +791,29 @@ var _ = Describe("Allocation", func() {
 				launchTemplate := input.LaunchTemplateConfigs[0].LaunchTemplateSpecification
 				Expect(*launchTemplate.Version).To(Equal("$Default"))
 			})
+			It("should use the default instance profile", func() {
+				provider.InstanceProfile = ""
+				opts := injection.GetOptions(ctx)
+				opts.AWSDefaultInstanceProfile = "test-default-instance-profile"
+				ctx := injection.WithOptions(ctx, opts)
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				Expect(aws.StringValue(input.LaunchTemplateData.IamInstanceProfile.Name)).To(Equal("test-default-instance-profile"))
+			})
+			It("should prefer the provisioner's instance profile to the default", func() {
+				opts := injection.GetOptions(ctx)
+				opts.AWSDefaultInstanceProfile = "test-default-instance-profile"
+				ctx := injection.WithOptions(ctx, opts)
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				Expect(aws.StringValue(input.LaunchTemplateData.IamInstanceProfile.Name)).To(Equal("test-instance-profile"))
+			})
 			It("should allow a launch template to be specified", func() {
 				provider.LaunchTemplate = aws.String("test-launch-template")
+				provider.InstanceProfile = ""
+				fakeEC2API.LaunchTemplates.Store("test-launch-template", &ec2.LaunchTemplate{LaunchTemplateName: aws.String("test-launch-template")})
 				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
 				ExpectScheduled(ctx, env.Client, pod)
 				Expect(fakeEC2API.CalledWithCreateFleetInput.Cardinality()).To(Equal(1))
@@ -384,6 +823,60 @@ var _ = Describe("Allocation", func() {
 				Expect(*launchTemplate.LaunchTemplateName).To(Equal("test-launch-template"))
 				Expect(*launchTemplate.Version).To(Equal("$Default"))
 			})
+			It("should allow a launch template version to be specified", func() {
+				provider.LaunchTemplate = aws.String("test-launch-template")
+				provider.LaunchTemplateVersion = aws.String("3")
+				provider.InstanceProfile = ""
+				fakeEC2API.LaunchTemplates.Store("test-launch-template", &ec2.LaunchTemplate{LaunchTemplateName: aws.String("test-launch-template")})
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				Expect(fakeEC2API.CalledWithCreateFleetInput.Cardinality()).To(Equal(1))
+				input := fakeEC2API.CalledWithCreateFleetInput.Pop().(*ec2.CreateFleetInput)
+				Expect(input.LaunchTemplateConfigs).To(HaveLen(1))
+				launchTemplate := input.LaunchTemplateConfigs[0].LaunchTemplateSpecification
+				Expect(*launchTemplate.LaunchTemplateName).To(Equal("test-launch-template"))
+				Expect(*launchTemplate.Version).To(Equal("3"))
+				Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(0))
+			})
+		})
+		Context("Tags", func() {
+			It("should tag instances, volumes and network interfaces with the default tags", func() {
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(1))
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				resourceTypes := []string{}
+				for _, tagSpecification := range input.LaunchTemplateData.TagSpecifications {
+					resourceTypes = append(resourceTypes, aws.StringValue(tagSpecification.ResourceType))
+					Expect(tagSpecification.Tags).To(ConsistOf(
+						&ec2.Tag{Key: aws.String("Name"), Value: aws.String("karpenter.sh/cluster/test-cluster/provisioner/default")},
+						&ec2.Tag{Key: aws.String("kubernetes.io/cluster/test-cluster"), Value: aws.String("owned")},
+						&ec2.Tag{Key: aws.String("karpenter.sh/cluster/test-cluster"), Value: aws.String("owned")},
+						&ec2.Tag{Key: aws.String(v1alpha1.ProvisionerNameTagKey), Value: aws.String("default")},
+						&ec2.Tag{Key: aws.String(v1alpha1.ManagedByTagKey), Value: aws.String("test-cluster")},
+					))
+				}
+				Expect(resourceTypes).To(ConsistOf(ec2.ResourceTypeInstance, ec2.ResourceTypeVolume, ec2.ResourceTypeNetworkInterface))
+			})
+			It("should tag resources with custom tags, which take precedence over the default tags", func() {
+				provider.Tags = map[string]string{"Name": "custom-name", "team": "test-team"}
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				launchTemplateInput := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				fleetInput := fakeEC2API.CalledWithCreateFleetInput.Pop().(*ec2.CreateFleetInput)
+				for _, tags := range [][]*ec2.Tag{
+					launchTemplateInput.TagSpecifications[0].Tags,
+					launchTemplateInput.LaunchTemplateData.TagSpecifications[0].Tags,
+					fleetInput.TagSpecifications[0].Tags,
+				} {
+					Expect(tags).To(ContainElements(
+						&ec2.Tag{Key: aws.String("Name"), Value: aws.String("custom-name")},
+						&ec2.Tag{Key: aws.String("team"), Value: aws.String("test-team")},
+						&ec2.Tag{Key: aws.String(v1alpha1.ProvisionerNameTagKey), Value: aws.String("default")},
+					))
+					Expect(tags).ToNot(ContainElement(&ec2.Tag{Key: aws.String("Name"), Value: aws.String("karpenter.sh/cluster/test-cluster/provisioner/default")}))
+				}
+			})
 		})
 		Context("Subnets", func() {
 			It("should default to the cluster's subnets", func() {
@@ -398,6 +891,127 @@ var _ = Describe("Allocation", func() {
 					&ec2.FleetLaunchTemplateOverridesRequest{SubnetId: aws.String("test-subnet-3"), InstanceType: aws.String("m5.large"), AvailabilityZone: aws.String("test-zone-1c")},
 				))
 			})
+			Context("Available IP Addresses", func() {
+				BeforeEach(func() {
+					provisioner.Spec.Requirements = v1alpha5.Requirements{
+						{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-1a"}},
+						{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpIn, Values: []string{"m5.large"}},
+					}
+				})
+				It("should launch into the subnet with the most available IP addresses", func() {
+					fakeEC2API.DescribeSubnetsOutput = &ec2.DescribeSubnetsOutput{Subnets: []*ec2.Subnet{
+						{SubnetId: aws.String("test-subnet-1"), AvailabilityZone: aws.String("test-zone-1a"), AvailableIpAddressCount: aws.Int64(10)},
+						{SubnetId: aws.String("test-subnet-4"), AvailabilityZone: aws.String("test-zone-1a"), AvailableIpAddressCount: aws.Int64(20)},
+					}}
+					pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+					ExpectScheduled(ctx, env.Client, pod)
+					Expect(fakeEC2API.CalledWithCreateFleetInput.Cardinality()).To(Equal(1))
+					input := fakeEC2API.CalledWithCreateFleetInput.Pop().(*ec2.CreateFleetInput)
+					Expect(input.LaunchTemplateConfigs[0].Overrides).To(ConsistOf(
+						&ec2.FleetLaunchTemplateOverridesRequest{SubnetId: aws.String("test-subnet-4"), InstanceType: aws.String("m5.large"), AvailabilityZone: aws.String("test-zone-1a")},
+					))
+				})
+				It("should spread launches across subnets as their IP addresses are used", func() {
+					fakeEC2API.DescribeSubnetsOutput = &ec2.DescribeSubnetsOutput{Subnets: []*ec2.Subnet{
+						{SubnetId: aws.String("test-subnet-1"), AvailabilityZone: aws.String("test-zone-1a"), AvailableIpAddressCount: aws.Int64(2)},
+						{SubnetId: aws.String("test-subnet-4"), AvailabilityZone: aws.String("test-zone-1a"), AvailableIpAddressCount: aws.Int64(2)},
+					}}
+					for i := 0; i < 2; i++ {
+						pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+						ExpectScheduled(ctx, env.Client, pod)
+						ExpectClusterStateCleanedUp(cluster)
+					}
+					Expect(fakeEC2API.CalledWithCreateFleetInput.Cardinality()).To(Equal(2))
+					Expect(LaunchedSubnets(fakeEC2API.CalledWithCreateFleetInput.Iter())).To(ConsistOf("test-subnet-1", "test-subnet-4"))
+				})
+				It("should not launch into subnets without available IP addresses", func() {
+					fakeEC2API.DescribeSubnetsOutput = &ec2.DescribeSubnetsOutput{Subnets: []*ec2.Subnet{
+						{SubnetId: aws.String("test-subnet-1"), AvailabilityZone: aws.String("test-zone-1a"), AvailableIpAddressCount: aws.Int64(0)},
+						{SubnetId: aws.String("test-subnet-4"), AvailabilityZone: aws.String("test-zone-1a"), AvailableIpAddressCount: aws.Int64(10)},
+					}}
+					pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+					ExpectScheduled(ctx, env.Client, pod)
+					Expect(LaunchedSubnets(fakeEC2API.CalledWithCreateFleetInput.Iter())).To(ConsistOf("test-subnet-4"))
+				})
+				It("should retry in an alternate subnet if a subnet runs out of IP addresses", func() {
+					fakeEC2API.DescribeSubnetsOutput = &ec2.DescribeSubnetsOutput{Subnets: []*ec2.Subnet{
+						{SubnetId: aws.String("test-subnet-1"), AvailabilityZone: aws.String("test-zone-1a"), AvailableIpAddressCount: aws.Int64(20)},
+						{SubnetId: aws.String("test-subnet-4"), AvailabilityZone: aws.String("test-zone-1a"), AvailableIpAddressCount: aws.Int64(10)},
+					}}
+					fakeEC2API.InsufficientFreeAddressesSubnets = []string{"test-subnet-1"}
+					pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+					ExpectScheduled(ctx, env.Client, pod)
+					Expect(fakeEC2API.CalledWithCreateFleetInput.Cardinality()).To(Equal(2))
+					Expect(LaunchedSubnets(fakeEC2API.CalledWithCreateFleetInput.Iter())).To(ConsistOf("test-subnet-1", "test-subnet-4"))
+				})
+				It("should not launch into zones whose subnets have no available IP addresses", func() {
+					provisioner.Spec.Requirements = v1alpha5.Requirements{
+						{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpIn, Values: []string{"m5.large"}},
+					}
+					fakeEC2API.DescribeSubnetsOutput = &ec2.DescribeSubnetsOutput{Subnets: []*ec2.Subnet{
+						{SubnetId: aws.String("test-subnet-1"), AvailabilityZone: aws.String("test-zone-1a"), AvailableIpAddressCount: aws.Int64(0)},
+						{SubnetId: aws.String("test-subnet-2"), AvailabilityZone: aws.String("test-zone-1b"), AvailableIpAddressCount: aws.Int64(10)},
+					}}
+					pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+					node := ExpectScheduled(ctx, env.Client, pod)
+					Expect(node.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, "test-zone-1b"))
+					Expect(LaunchedSubnets(fakeEC2API.CalledWithCreateFleetInput.Iter())).To(ConsistOf("test-subnet-2"))
+				})
+			})
+			Context("Outposts and Local Zones", func() {
+				outpostARN := "arn:aws:outposts:test-region:123456789012:outpost/op-0123456789abcdef0"
+				BeforeEach(func() {
+					instanceTypeCache.Flush()
+				})
+				AfterEach(func() {
+					instanceTypeCache.Flush()
+				})
+				It("should launch the instance types that Outposts have capacity for on demand", func() {
+					provider.SubnetSelector = map[string]string{v1alpha1.SubnetOutpostARNsSelectorKey: outpostARN}
+					provisioner.Spec.Requirements = v1alpha5.Requirements{{Key: v1alpha5.LabelCapacityType, Operator: v1.NodeSelectorOpIn, Values: []string{v1alpha1.CapacityTypeSpot, v1alpha1.CapacityTypeOnDemand}}}
+					fakeEC2API.DescribeSubnetsOutput = &ec2.DescribeSubnetsOutput{Subnets: []*ec2.Subnet{
+						{SubnetId: aws.String("test-subnet-outpost"), AvailabilityZone: aws.String("test-zone-1a"), AvailableIpAddressCount: aws.Int64(10), OutpostArn: aws.String(outpostARN)},
+					}}
+					fakeOutpostsAPI.InstanceTypes = map[string][]string{outpostARN: {"m5.xlarge"}}
+					pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+					node := ExpectScheduled(ctx, env.Client, pod)
+					Expect(node.Labels).To(HaveKeyWithValue(v1.LabelInstanceTypeStable, "m5.xlarge"))
+					Expect(node.Labels).To(HaveKeyWithValue(v1alpha5.LabelCapacityType, v1alpha1.CapacityTypeOnDemand))
+					Expect(fakeEC2API.CalledWithDescribeSubnetsInput.Pop().(*ec2.DescribeSubnetsInput).Filters).To(ConsistOf(
+						&ec2.Filter{Name: aws.String("outpost-arn"), Values: aws.StringSlice([]string{outpostARN})},
+					))
+					Expect(fakeOutpostsAPI.CalledWithGetOutpostInstanceTypesInput.ToSlice()).To(ConsistOf(outpostARN))
+					Expect(LaunchedSubnets(fakeEC2API.CalledWithCreateFleetInput.Iter())).To(ConsistOf("test-subnet-outpost"))
+				})
+				It("should not launch into subnets on Outposts unless they are selected by ARN", func() {
+					fakeEC2API.DescribeSubnetsOutput = &ec2.DescribeSubnetsOutput{Subnets: []*ec2.Subnet{
+						{SubnetId: aws.String("test-subnet-outpost"), AvailabilityZone: aws.String("test-zone-1a"), AvailableIpAddressCount: aws.Int64(10), OutpostArn: aws.String(outpostARN)},
+						{SubnetId: aws.String("test-subnet-2"), AvailabilityZone: aws.String("test-zone-1b"), AvailableIpAddressCount: aws.Int64(10)},
+					}}
+					pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+					node := ExpectScheduled(ctx, env.Client, pod)
+					Expect(node.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, "test-zone-1b"))
+					Expect(LaunchedSubnets(fakeEC2API.CalledWithCreateFleetInput.Iter())).To(ConsistOf("test-subnet-2"))
+					Expect(fakeOutpostsAPI.CalledWithGetOutpostInstanceTypesInput.Cardinality()).To(Equal(0))
+				})
+				It("should launch into Local Zones with the instance types that they offer", func() {
+					provider.SubnetSelector = map[string]string{v1alpha1.SubnetZonesSelectorKey: "test-zone-1a-lz-1a"}
+					fakeEC2API.DescribeSubnetsOutput = &ec2.DescribeSubnetsOutput{Subnets: []*ec2.Subnet{
+						{SubnetId: aws.String("test-subnet-local"), AvailabilityZone: aws.String("test-zone-1a-lz-1a"), AvailableIpAddressCount: aws.Int64(10)},
+					}}
+					fakeEC2API.LocalZoneInstanceTypeOfferings = []*ec2.InstanceTypeOffering{
+						{InstanceType: aws.String("m5.xlarge"), Location: aws.String("test-zone-1a-lz-1a")},
+					}
+					pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+					node := ExpectScheduled(ctx, env.Client, pod)
+					Expect(node.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, "test-zone-1a-lz-1a"))
+					Expect(node.Labels).To(HaveKeyWithValue(v1.LabelInstanceTypeStable, "m5.xlarge"))
+					Expect(fakeEC2API.CalledWithDescribeSubnetsInput.Pop().(*ec2.DescribeSubnetsInput).Filters).To(ConsistOf(
+						&ec2.Filter{Name: aws.String("availability-zone"), Values: aws.StringSlice([]string{"test-zone-1a-lz-1a"})},
+					))
+					Expect(fakeEC2API.CalledWithDescribeInstanceTypeOfferingsInput.Cardinality()).To(Equal(1))
+				})
+			})
 		})
 		Context("Security Groups", func() {
 			It("should default to the clusters security groups", func() {
@@ -411,54 +1025,1457 @@ var _ = Describe("Allocation", func() {
 					aws.String("test-security-group-3"),
 				))
 			})
+			It("should select security groups by ID", func() {
+				provider.SecurityGroupSelector = map[string]string{v1alpha1.SecurityGroupIDsSelectorKey: "test-security-group-1, test-security-group-2"}
+				fakeEC2API.DescribeSecurityGroupsOutput = &ec2.DescribeSecurityGroupsOutput{SecurityGroups: []*ec2.SecurityGroup{
+					{GroupId: aws.String("test-security-group-1"), VpcId: aws.String("test-vpc")},
+					{GroupId: aws.String("test-security-group-2"), VpcId: aws.String("test-vpc")},
+				}}
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				Expect(fakeEC2API.CalledWithDescribeSecurityGroupsInput.Pop().(*ec2.DescribeSecurityGroupsInput).Filters).To(ConsistOf(
+					&ec2.Filter{Name: aws.String("group-id"), Values: aws.StringSlice([]string{"test-security-group-1", "test-security-group-2"})},
+				))
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				Expect(input.LaunchTemplateData.SecurityGroupIds).To(ConsistOf(
+					aws.String("test-security-group-1"),
+					aws.String("test-security-group-2"),
+				))
+			})
+			It("should select security groups by name", func() {
+				provider.SecurityGroupSelector = map[string]string{v1alpha1.SecurityGroupNamesSelectorKey: "test-security-group-3"}
+				fakeEC2API.DescribeSecurityGroupsOutput = &ec2.DescribeSecurityGroupsOutput{SecurityGroups: []*ec2.SecurityGroup{
+					{GroupId: aws.String("test-security-group-3"), GroupName: aws.String("test-security-group-3"), VpcId: aws.String("test-vpc")},
+				}}
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				Expect(fakeEC2API.CalledWithDescribeSecurityGroupsInput.Pop().(*ec2.DescribeSecurityGroupsInput).Filters).To(ConsistOf(
+					&ec2.Filter{Name: aws.String("group-name"), Values: aws.StringSlice([]string{"test-security-group-3"})},
+				))
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				Expect(input.LaunchTemplateData.SecurityGroupIds).To(ConsistOf(aws.String("test-security-group-3")))
+			})
+			It("should select security groups by tag along with names", func() {
+				provider.SecurityGroupSelector = map[string]string{v1alpha1.SecurityGroupNamesSelectorKey: "test-security-group-3", "TestTag": "*"}
+				ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())
+				Expect(fakeEC2API.CalledWithDescribeSecurityGroupsInput.Pop().(*ec2.DescribeSecurityGroupsInput).Filters).To(ConsistOf(
+					&ec2.Filter{Name: aws.String("group-name"), Values: aws.StringSlice([]string{"test-security-group-3"})},
+					&ec2.Filter{Name: aws.String("tag-key"), Values: aws.StringSlice([]string{"TestTag"})},
+				))
+			})
 		})
-		Context("Kubelet Args", func() {
-			It("should specify the --dns-cluster-ip flag when clusterDNSIP is set", func() {
-				provisioner.Spec.KubeletConfiguration.ClusterDNS = []string{"10.0.10.100"}
+		Context("AMIs", func() {
+			It("should use the AL2 AMI family by default", func() {
 				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
 				ExpectScheduled(ctx, env.Client, pod)
+				Expect(fakeSSMAPI.CalledWithGetParameterInput.ToSlice()).To(ContainElement(MatchRegexp(`^/aws/service/eks/optimized-ami/.*/amazon-linux-2/recommended/image_id$`)))
+				Expect(fakeSSMAPI.CalledWithGetParameterInput.ToSlice()).ToNot(ContainElement(Not(HavePrefix("/aws/service/eks/"))))
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				userData, _ := base64.StdEncoding.DecodeString(*input.LaunchTemplateData.UserData)
+				Expect(string(userData)).To(ContainSubstring("/etc/eks/bootstrap.sh 'test-cluster'"))
+			})
+			It("should use the Bottlerocket AMI family's AMIs and user data", func() {
+				provider.AMIFamily = aws.String(v1alpha1.AMIFamilyBottlerocket)
+				provisioner.Spec.Labels = map[string]string{"foo": "bar"}
+				provisioner.Spec.Taints = []v1.Taint{
+					{Key: "a", Value: "b", Effect: v1.TaintEffectNoSchedule},
+					{Key: "a", Value: "b", Effect: v1.TaintEffectNoExecute},
+					{Key: "c", Value: "d", Effect: v1.TaintEffectNoSchedule},
+				}
+				provisioner.Spec.KubeletConfiguration.ClusterDNS = []string{"10.0.10.100"}
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod(
+					test.PodOptions{Tolerations: []v1.Toleration{{Key: "a", Operator: v1.TolerationOpExists}, {Key: "c", Operator: v1.TolerationOpExists}}},
+				))[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				Expect(fakeSSMAPI.CalledWithGetParameterInput.ToSlice()).To(ContainElement(MatchRegexp(`^/aws/service/bottlerocket/aws-k8s-.*/x86_64/latest/image_id$`)))
+				Expect(fakeSSMAPI.CalledWithGetParameterInput.ToSlice()).ToNot(ContainElement(Not(HavePrefix("/aws/service/bottlerocket/"))))
 				Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(1))
 				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
 				userData, _ := base64.StdEncoding.DecodeString(*input.LaunchTemplateData.UserData)
-				Expect(string(userData)).To(ContainSubstring("--dns-cluster-ip '10.0.10.100'"))
+				Expect(string(userData)).ToNot(ContainSubstring("bootstrap.sh"))
+				Expect(string(userData)).To(ContainSubstring("[settings.kubernetes]\napi-server = \"https://test-cluster\"\n"))
+				Expect(string(userData)).To(ContainSubstring("cluster-dns-ip = \"10.0.10.100\"\ncluster-name = \"test-cluster\"\n"))
+				Expect(string(userData)).To(ContainSubstring("[settings.kubernetes.node-labels]\nfoo = \"bar\"\n"))
+				Expect(string(userData)).To(ContainSubstring("[settings.kubernetes.node-taints]\na = [\"b:NoExecute\", \"b:NoSchedule\"]\nc = [\"d:NoSchedule\"]\n"))
 			})
-		})
-	})
-	Context("Defaulting", func() {
-		It("should default subnetSelector", func() {
-			provisioner.SetDefaults(ctx)
-			constraints, err := v1alpha1.Deserialize(&provisioner.Spec.Constraints)
-			Expect(err).ToNot(HaveOccurred())
-			Expect(constraints.SubnetSelector).To(Equal(map[string]string{"kubernetes.io/cluster/test-cluster": "*"}))
-		})
-		It("should default securityGroupSelector", func() {
-			provisioner.SetDefaults(ctx)
-			constraints, err := v1alpha1.Deserialize(&provisioner.Spec.Constraints)
-			Expect(err).ToNot(HaveOccurred())
-			Expect(constraints.SecurityGroupSelector).To(Equal(map[string]string{"kubernetes.io/cluster/test-cluster": "*"}))
-		})
-		It("should default requirements", func() {
-			provisioner.SetDefaults(ctx)
-			Expect(provisioner.Spec.Requirements.CapacityTypes().UnsortedList()).To(ConsistOf(v1alpha1.CapacityTypeOnDemand))
-			Expect(provisioner.Spec.Requirements.Architectures().UnsortedList()).To(ConsistOf(v1alpha5.ArchitectureAmd64))
-		})
-	})
-	Context("Validation", func() {
-		It("should not panic if provider undefined", func() {
-			provisioner.Spec.Provider = nil
-			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
-		})
-
-		Context("SubnetSelector", func() {
-			It("should not allow empty string keys or values", func() {
-				for key, value := range map[string]string{
-					"":    "value",
-					"key": "",
-				} {
-					provider.SubnetSelector = map[string]string{key: value}
-					provisioner := ProvisionerWithProvider(provisioner, provider)
-					Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			It("should use the Ubuntu AMI family's AMIs", func() {
+				provider.AMIFamily = aws.String(v1alpha1.AMIFamilyUbuntu)
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				Expect(fakeSSMAPI.CalledWithGetParameterInput.ToSlice()).To(ContainElement(MatchRegexp(`^/aws/service/canonical/ubuntu/eks/20.04/.*/stable/current/amd64/hvm/ebs-gp2/ami-id$`)))
+				Expect(fakeSSMAPI.CalledWithGetParameterInput.ToSlice()).ToNot(ContainElement(Not(HavePrefix("/aws/service/canonical/"))))
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				userData, _ := base64.StdEncoding.DecodeString(*input.LaunchTemplateData.UserData)
+				Expect(string(userData)).To(ContainSubstring("/etc/eks/bootstrap.sh 'test-cluster'"))
+			})
+			It("should use the AMI that matches the selector and architecture", func() {
+				provider.AMISelector = map[string]string{"Name": "test-ami"}
+				provisioner.Spec.Requirements = v1alpha5.Requirements{{Key: v1.LabelArchStable, Operator: v1.NodeSelectorOpIn, Values: []string{v1alpha5.ArchitectureArm64}}}
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				Expect(fakeSSMAPI.CalledWithGetParameterInput.Cardinality()).To(Equal(0))
+				Expect(fakeEC2API.CalledWithDescribeImagesInput.Cardinality()).To(Equal(1))
+				Expect(fakeEC2API.CalledWithDescribeImagesInput.Pop().(*ec2.DescribeImagesInput).Filters).To(ConsistOf(
+					&ec2.Filter{Name: aws.String("tag:Name"), Values: aws.StringSlice([]string{"test-ami"})},
+				))
+				Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(1))
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				Expect(*input.LaunchTemplateData.ImageId).To(Equal("test-ami-arm64"))
+			})
+			It("should use the most recently created AMI that matches the selector", func() {
+				provider.AMISelector = map[string]string{"Name": "*"}
+				fakeEC2API.DescribeImagesOutput = &ec2.DescribeImagesOutput{Images: []*ec2.Image{
+					{ImageId: aws.String("test-ami-old"), Architecture: aws.String("x86_64"), CreationDate: aws.String("2021-08-30T00:00:00.000Z")},
+					{ImageId: aws.String("test-ami-new"), Architecture: aws.String("x86_64"), CreationDate: aws.String("2021-08-31T00:00:00.000Z")},
+				}}
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				Expect(fakeEC2API.CalledWithDescribeImagesInput.Pop().(*ec2.DescribeImagesInput).Filters).To(ConsistOf(
+					&ec2.Filter{Name: aws.String("tag-key"), Values: aws.StringSlice([]string{"Name"})},
+				))
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				Expect(*input.LaunchTemplateData.ImageId).To(Equal("test-ami-new"))
+			})
+			It("should not schedule if no AMIs match the selector", func() {
+				provider.AMISelector = map[string]string{"Name": "test-ami"}
+				fakeEC2API.DescribeImagesOutput = &ec2.DescribeImagesOutput{Images: []*ec2.Image{}}
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				ExpectNotScheduled(ctx, env.Client, pod)
+			})
+			It("should cache the AMIs that match the selector", func() {
+				provider.AMISelector = map[string]string{"Name": "test-ami"}
+				for i := 0; i < 2; i++ {
+					pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+					ExpectScheduled(ctx, env.Client, pod)
+					ExpectClusterStateCleanedUp(cluster)
 				}
+				Expect(fakeEC2API.CalledWithCreateFleetInput.Cardinality()).To(Equal(2))
+				Expect(fakeEC2API.CalledWithDescribeImagesInput.Cardinality()).To(Equal(1))
+			})
+		})
+		Context("Architecture", func() {
+			BeforeEach(func() {
+				provisioner.Spec.Requirements = v1alpha5.Requirements{{Key: v1.LabelArchStable, Operator: v1.NodeSelectorOpIn, Values: []string{v1alpha5.ArchitectureAmd64, v1alpha5.ArchitectureArm64}}}
+			})
+			It("should label nodes with their architecture and operating system", func() {
+				provisioner.Spec.Requirements = nil
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				node := ExpectScheduled(ctx, env.Client, pod)
+				Expect(node.Labels).To(HaveKeyWithValue(v1.LabelArchStable, v1alpha5.ArchitectureAmd64))
+				Expect(node.Labels).To(HaveKeyWithValue(v1.LabelOSStable, v1alpha5.OperatingSystemLinux))
+			})
+			It("should launch arm64 instances with the arm64 AMI for pods that select arm64", func() {
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod(
+					test.PodOptions{NodeSelector: map[string]string{v1.LabelArchStable: v1alpha5.ArchitectureArm64}},
+				))[0]
+				node := ExpectScheduled(ctx, env.Client, pod)
+				Expect(node.Labels).To(HaveKeyWithValue(v1.LabelArchStable, v1alpha5.ArchitectureArm64))
+				Expect(node.Labels).To(HaveKeyWithValue(v1.LabelInstanceTypeStable, "c6g.large"))
+				Expect(fakeSSMAPI.CalledWithGetParameterInput.ToSlice()).To(ContainElement(MatchRegexp(`^/aws/service/eks/optimized-ami/.*/amazon-linux-2-arm64/recommended/image_id$`)))
+			})
+			It("should use the Bottlerocket AMI family's arm64 AMIs for pods that select arm64", func() {
+				provider.AMIFamily = aws.String(v1alpha1.AMIFamilyBottlerocket)
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod(
+					test.PodOptions{NodeSelector: map[string]string{v1.LabelArchStable: v1alpha5.ArchitectureArm64}},
+				))[0]
+				node := ExpectScheduled(ctx, env.Client, pod)
+				Expect(node.Labels).To(HaveKeyWithValue(v1.LabelArchStable, v1alpha5.ArchitectureArm64))
+				Expect(fakeSSMAPI.CalledWithGetParameterInput.ToSlice()).To(ContainElement(MatchRegexp(`^/aws/service/bottlerocket/aws-k8s-.*/arm64/latest/image_id$`)))
+			})
+			It("should launch instances of both architectures from a single provisioner", func() {
+				pods := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider),
+					test.UnschedulablePod(test.PodOptions{NodeSelector: map[string]string{v1.LabelArchStable: v1alpha5.ArchitectureAmd64}}),
+					test.UnschedulablePod(test.PodOptions{NodeSelector: map[string]string{v1.LabelArchStable: v1alpha5.ArchitectureArm64}}),
+				)
+				Expect(ExpectScheduled(ctx, env.Client, pods[0]).Labels).To(HaveKeyWithValue(v1.LabelArchStable, v1alpha5.ArchitectureAmd64))
+				Expect(ExpectScheduled(ctx, env.Client, pods[1]).Labels).To(HaveKeyWithValue(v1.LabelArchStable, v1alpha5.ArchitectureArm64))
+				Expect(fakeSSMAPI.CalledWithGetParameterInput.ToSlice()).To(ContainElements(
+					MatchRegexp(`^/aws/service/eks/optimized-ami/.*/amazon-linux-2/recommended/image_id$`),
+					MatchRegexp(`^/aws/service/eks/optimized-ami/.*/amazon-linux-2-arm64/recommended/image_id$`),
+				))
+			})
+		})
+		Context("Windows", func() {
+			windowsPod := func() *v1.Pod {
+				return test.UnschedulablePod(test.PodOptions{NodeSelector: map[string]string{v1.LabelOSStable: v1alpha5.OperatingSystemWindows}})
+			}
+			BeforeEach(func() {
+				provider.AMIFamily = aws.String(v1alpha1.AMIFamilyWindows2019)
+			})
+			It("should use the Windows AMI family's AMIs and user data", func() {
+				provisioner.Spec.Labels = map[string]string{"foo": "bar"}
+				provisioner.Spec.KubeletConfiguration.ClusterDNS = []string{"10.0.10.100"}
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), windowsPod())[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				Expect(fakeSSMAPI.CalledWithGetParameterInput.ToSlice()).To(ContainElement(MatchRegexp(`^/aws/service/ami-windows-latest/Windows_Server-2019-English-Core-EKS_Optimized-.*/image_id$`)))
+				Expect(fakeSSMAPI.CalledWithGetParameterInput.ToSlice()).ToNot(ContainElement(Not(HavePrefix("/aws/service/ami-windows-latest/"))))
+				Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(1))
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				userData, _ := base64.StdEncoding.DecodeString(*input.LaunchTemplateData.UserData)
+				Expect(string(userData)).To(HavePrefix("<powershell>\n"))
+				Expect(string(userData)).To(HaveSuffix("\n</powershell>"))
+				Expect(string(userData)).ToNot(ContainSubstring("bootstrap.sh"))
+				Expect(string(userData)).To(ContainSubstring(`Start-EKSBootstrap.ps1"`))
+				Expect(string(userData)).To(ContainSubstring("-EKSClusterName 'test-cluster' -APIServerEndpoint 'https://test-cluster'"))
+				Expect(string(userData)).To(MatchRegexp(`-KubeletExtraArgs '--node-labels=[^ ']*foo=bar`))
+				Expect(string(userData)).To(ContainSubstring("-DNSClusterIP '10.0.10.100'"))
+			})
+			It("should use the Windows Server 2022 AMIs", func() {
+				provider.AMIFamily = aws.String(v1alpha1.AMIFamilyWindows2022)
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), windowsPod())[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				Expect(fakeSSMAPI.CalledWithGetParameterInput.ToSlice()).To(ContainElement(MatchRegexp(`^/aws/service/ami-windows-latest/Windows_Server-2022-English-Core-EKS_Optimized-.*/image_id$`)))
+			})
+			It("should label nodes with the windows operating system", func() {
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), windowsPod())[0]
+				node := ExpectScheduled(ctx, env.Client, pod)
+				Expect(node.Labels).To(HaveKeyWithValue(v1.LabelOSStable, v1alpha5.OperatingSystemWindows))
+				Expect(node.Status.NodeInfo.OperatingSystem).To(Equal(v1alpha5.OperatingSystemWindows))
+			})
+			It("should schedule pods that select windows", func() {
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod(
+					test.PodOptions{NodeSelector: map[string]string{v1.LabelOSStable: v1alpha5.OperatingSystemWindows}},
+				))[0]
+				ExpectScheduled(ctx, env.Client, pod)
+			})
+			It("should not schedule pods that select linux", func() {
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod(
+					test.PodOptions{NodeSelector: map[string]string{v1.LabelOSStable: v1alpha5.OperatingSystemLinux}},
+				))[0]
+				ExpectNotScheduled(ctx, env.Client, pod)
+			})
+			It("should not schedule pods that don't select an operating system", func() {
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				ExpectNotScheduled(ctx, env.Client, pod)
+			})
+			It("should not schedule pods that select windows to linux provisioners", func() {
+				provider.AMIFamily = nil
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod(
+					test.PodOptions{NodeSelector: map[string]string{v1.LabelOSStable: v1alpha5.OperatingSystemWindows}},
+				))[0]
+				ExpectNotScheduled(ctx, env.Client, pod)
+			})
+			It("should not launch arm64 instances", func() {
+				provisioner.Spec.Requirements = v1alpha5.Requirements{{Key: v1.LabelArchStable, Operator: v1.NodeSelectorOpIn, Values: []string{v1alpha5.ArchitectureArm64}}}
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), windowsPod())[0]
+				ExpectNotScheduled(ctx, env.Client, pod)
+			})
+			It("should only assign pods the IP addresses of the primary ENI", func() {
+				provisioner.Spec.Requirements = v1alpha5.Requirements{{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpIn, Values: []string{"t3.large"}}}
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), windowsPod())[0]
+				node := ExpectScheduled(ctx, env.Client, pod)
+				// t3.large has 12 IPv4 addresses per ENI, one of which is the node's
+				Expect(node.Status.Allocatable.Pods().Value()).To(BeNumerically("==", 11))
+			})
+			It("should run the user data in the same PowerShell block as the bootstrap script", func() {
+				provider.UserData = aws.String("<powershell>\nWrite-Host 'custom user data'\n</powershell>")
+				provider.UserDataMode = aws.String(v1alpha1.UserDataModeAppend)
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), windowsPod())[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				userData, _ := base64.StdEncoding.DecodeString(*input.LaunchTemplateData.UserData)
+				Expect(strings.Count(string(userData), "<powershell>")).To(Equal(1))
+				Expect(strings.Count(string(userData), "</powershell>")).To(Equal(1))
+				Expect(strings.Index(string(userData), "Write-Host 'custom user data'")).To(BeNumerically(">", strings.Index(string(userData), "Start-EKSBootstrap.ps1")))
+			})
+		})
+		Context("Block Device Mappings", func() {
+			It("should not specify block device mappings by default", func() {
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(1))
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				Expect(input.LaunchTemplateData.BlockDeviceMappings).To(BeNil())
+			})
+			It("should specify block device mappings", func() {
+				provider.BlockDeviceMappings = []*v1alpha1.BlockDeviceMapping{{
+					DeviceName: aws.String("/dev/xvda"),
+					EBS: &v1alpha1.BlockDevice{
+						DeleteOnTermination: aws.Bool(true),
+						Encrypted:           aws.Bool(true),
+						IOPS:                aws.Int64(3000),
+						KMSKeyID:            aws.String("test-kms-key"),
+						Throughput:          aws.Int64(125),
+						VolumeSize:          resource.NewQuantity(100<<30, resource.BinarySI),
+						VolumeType:          aws.String(ec2.VolumeTypeGp3),
+					},
+				}, {
+					DeviceName: aws.String("/dev/xvdb"),
+					EBS:        &v1alpha1.BlockDevice{VolumeSize: resource.NewQuantity(1500*1024*1024, resource.BinarySI)},
+				}}
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(1))
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				Expect(input.LaunchTemplateData.BlockDeviceMappings).To(Equal([]*ec2.LaunchTemplateBlockDeviceMappingRequest{{
+					DeviceName: aws.String("/dev/xvda"),
+					Ebs: &ec2.LaunchTemplateEbsBlockDeviceRequest{
+						DeleteOnTermination: aws.Bool(true),
+						Encrypted:           aws.Bool(true),
+						Iops:                aws.Int64(3000),
+						KmsKeyId:            aws.String("test-kms-key"),
+						Throughput:          aws.Int64(125),
+						VolumeSize:          aws.Int64(100),
+						VolumeType:          aws.String(ec2.VolumeTypeGp3),
+					},
+				}, {
+					DeviceName: aws.String("/dev/xvdb"),
+					Ebs:        &ec2.LaunchTemplateEbsBlockDeviceRequest{VolumeSize: aws.Int64(2)},
+				}}))
+			})
+			It("should encrypt the root volume with the provider's KMS key", func() {
+				provider.KMSKeyID = aws.String("test-kms-key")
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(1))
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				Expect(input.LaunchTemplateData.BlockDeviceMappings).To(Equal([]*ec2.LaunchTemplateBlockDeviceMappingRequest{{
+					DeviceName: aws.String("/dev/xvda"),
+					Ebs:        &ec2.LaunchTemplateEbsBlockDeviceRequest{Encrypted: aws.Bool(true), KmsKeyId: aws.String("test-kms-key")},
+				}}))
+			})
+			It("should encrypt the Bottlerocket OS and data volumes with the default KMS key", func() {
+				opts := injection.GetOptions(ctx)
+				opts.AWSDefaultKMSKeyID = "test-default-kms-key"
+				ctx := injection.WithOptions(ctx, opts)
+				provider.AMIFamily = aws.String(v1alpha1.AMIFamilyBottlerocket)
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(1))
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				Expect(input.LaunchTemplateData.BlockDeviceMappings).To(Equal([]*ec2.LaunchTemplateBlockDeviceMappingRequest{{
+					DeviceName: aws.String("/dev/xvda"),
+					Ebs:        &ec2.LaunchTemplateEbsBlockDeviceRequest{Encrypted: aws.Bool(true), KmsKeyId: aws.String("test-default-kms-key")},
+				}, {
+					DeviceName: aws.String("/dev/xvdb"),
+					Ebs:        &ec2.LaunchTemplateEbsBlockDeviceRequest{Encrypted: aws.Bool(true), KmsKeyId: aws.String("test-default-kms-key")},
+				}}))
+			})
+			It("should encrypt block device mappings that don't specify whether they are encrypted", func() {
+				provider.KMSKeyID = aws.String("test-kms-key")
+				provider.BlockDeviceMappings = []*v1alpha1.BlockDeviceMapping{{
+					DeviceName: aws.String("/dev/xvda"),
+					EBS:        &v1alpha1.BlockDevice{VolumeSize: resource.NewQuantity(100<<30, resource.BinarySI)},
+				}, {
+					DeviceName: aws.String("/dev/xvdb"),
+					EBS:        &v1alpha1.BlockDevice{Encrypted: aws.Bool(false), VolumeSize: resource.NewQuantity(100<<30, resource.BinarySI)},
+				}}
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(1))
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				Expect(input.LaunchTemplateData.BlockDeviceMappings).To(Equal([]*ec2.LaunchTemplateBlockDeviceMappingRequest{{
+					DeviceName: aws.String("/dev/xvda"),
+					Ebs:        &ec2.LaunchTemplateEbsBlockDeviceRequest{Encrypted: aws.Bool(true), KmsKeyId: aws.String("test-kms-key"), VolumeSize: aws.Int64(100)},
+				}, {
+					DeviceName: aws.String("/dev/xvdb"),
+					Ebs:        &ec2.LaunchTemplateEbsBlockDeviceRequest{Encrypted: aws.Bool(false), VolumeSize: aws.Int64(100)},
+				}}))
+			})
+			It("should create a new launch template when the KMS key changes", func() {
+				provider.KMSKeyID = aws.String("test-kms-key")
+				ExpectScheduled(ctx, env.Client, ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0])
+				provider.KMSKeyID = aws.String("test-rotated-kms-key")
+				ExpectScheduled(ctx, env.Client, ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0])
+				Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(2))
+				kmsKeyIDs := []string{}
+				for input := range fakeEC2API.CalledWithCreateLaunchTemplateInput.Iter() {
+					kmsKeyIDs = append(kmsKeyIDs, aws.StringValue(input.(*ec2.CreateLaunchTemplateInput).LaunchTemplateData.BlockDeviceMappings[0].Ebs.KmsKeyId))
+				}
+				Expect(kmsKeyIDs).To(ConsistOf("test-kms-key", "test-rotated-kms-key"))
+			})
+		})
+		Context("Instance Store", func() {
+			BeforeEach(func() {
+				instanceTypeCache.Flush()
+				instanceTypeInfo := func(name string, instanceStorageInfo *ec2.InstanceStorageInfo) *ec2.InstanceTypeInfo {
+					return &ec2.InstanceTypeInfo{
+						InstanceType:                 aws.String(name),
+						SupportedUsageClasses:        fake.DefaultSupportedUsageClasses,
+						SupportedVirtualizationTypes: []*string{aws.String("hvm")},
+						Hypervisor:                   aws.String("nitro"),
+						ProcessorInfo:                &ec2.ProcessorInfo{SupportedArchitectures: aws.StringSlice([]string{"x86_64"})},
+						VCpuInfo:                     &ec2.VCpuInfo{DefaultVCpus: aws.Int64(2)},
+						MemoryInfo:                   &ec2.MemoryInfo{SizeInMiB: aws.Int64(8 * 1024)},
+						NetworkInfo:                  &ec2.NetworkInfo{MaximumNetworkInterfaces: aws.Int64(3), Ipv4AddressesPerInterface: aws.Int64(10)},
+						InstanceStorageSupported:     aws.Bool(instanceStorageInfo != nil),
+						InstanceStorageInfo:          instanceStorageInfo,
+					}
+				}
+				fakeEC2API.DescribeInstanceTypesOutput = &ec2.DescribeInstanceTypesOutput{InstanceTypes: []*ec2.InstanceTypeInfo{
+					instanceTypeInfo("m5.large", nil),
+					instanceTypeInfo("m5d.large", &ec2.InstanceStorageInfo{TotalSizeInGB: aws.Int64(75), NvmeSupport: aws.String(ec2.EphemeralNvmeSupportRequired)}),
+				}}
+				fakeEC2API.DescribeInstanceTypeOfferingsOutput = &ec2.DescribeInstanceTypeOfferingsOutput{InstanceTypeOfferings: []*ec2.InstanceTypeOffering{
+					{InstanceType: aws.String("m5.large"), Location: aws.String("test-zone-1a")},
+					{InstanceType: aws.String("m5d.large"), Location: aws.String("test-zone-1a")},
+				}}
+			})
+			AfterEach(func() {
+				instanceTypeCache.Flush()
+			})
+			It("should mount the instance store for pods that request more ephemeral storage than the root volume", func() {
+				provider.InstanceStorePolicy = aws.String(v1alpha1.InstanceStorePolicyRAID0)
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod(test.PodOptions{
+					ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceEphemeralStorage: resource.MustParse("50Gi")}},
+				}))[0]
+				node := ExpectScheduled(ctx, env.Client, pod)
+				Expect(node.Labels).To(HaveKeyWithValue(v1.LabelInstanceTypeStable, "m5d.large"))
+				Expect(node.Status.Capacity.StorageEphemeral().Value()).To(BeNumerically("==", 75*1000*1000*1000))
+				Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(1))
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				userData, _ := base64.StdEncoding.DecodeString(*input.LaunchTemplateData.UserData)
+				Expect(string(userData)).To(ContainSubstring("mdadm --create"))
+				Expect(strings.Index(string(userData), "mount --bind")).To(BeNumerically("<", strings.Index(string(userData), "/etc/eks/bootstrap.sh")))
+			})
+			It("should not use the instance store without an instance store policy", func() {
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod(test.PodOptions{
+					ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceEphemeralStorage: resource.MustParse("50Gi")}},
+				}))[0]
+				ExpectNotScheduled(ctx, env.Client, pod)
+			})
+			It("should size ephemeral storage by the root volume's block device mapping", func() {
+				provider.BlockDeviceMappings = []*v1alpha1.BlockDeviceMapping{{
+					DeviceName: aws.String("/dev/xvda"),
+					EBS:        &v1alpha1.BlockDevice{VolumeSize: resources.Quantity("100Gi")},
+				}}
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod(test.PodOptions{
+					ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceEphemeralStorage: resource.MustParse("50Gi")}},
+				}))[0]
+				node := ExpectScheduled(ctx, env.Client, pod)
+				Expect(node.Status.Capacity.StorageEphemeral().String()).To(Equal("100Gi"))
+			})
+			It("should size Bottlerocket's ephemeral storage by its data volume", func() {
+				provider.AMIFamily = aws.String(v1alpha1.AMIFamilyBottlerocket)
+				provider.BlockDeviceMappings = []*v1alpha1.BlockDeviceMapping{
+					{DeviceName: aws.String("/dev/xvda"), EBS: &v1alpha1.BlockDevice{VolumeSize: resources.Quantity("4Gi")}},
+					{DeviceName: aws.String("/dev/xvdb"), EBS: &v1alpha1.BlockDevice{VolumeSize: resources.Quantity("100Gi")}},
+				}
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod(test.PodOptions{
+					ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceEphemeralStorage: resource.MustParse("50Gi")}},
+				}))[0]
+				node := ExpectScheduled(ctx, env.Client, pod)
+				Expect(node.Status.Capacity.StorageEphemeral().String()).To(Equal("100Gi"))
+			})
+		})
+		Context("Metadata Options", func() {
+			It("should not specify metadata options by default", func() {
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(1))
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				Expect(input.LaunchTemplateData.MetadataOptions).To(BeNil())
+			})
+			It("should specify metadata options", func() {
+				provider.MetadataOptions = &v1alpha1.MetadataOptions{
+					HTTPEndpoint:            aws.String(ec2.LaunchTemplateInstanceMetadataEndpointStateEnabled),
+					HTTPPutResponseHopLimit: aws.Int64(2),
+					HTTPTokens:              aws.String(ec2.LaunchTemplateHttpTokensStateRequired),
+				}
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(1))
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				Expect(input.LaunchTemplateData.MetadataOptions).To(Equal(&ec2.LaunchTemplateInstanceMetadataOptionsRequest{
+					HttpEndpoint:            aws.String(ec2.LaunchTemplateInstanceMetadataEndpointStateEnabled),
+					HttpPutResponseHopLimit: aws.Int64(2),
+					HttpTokens:              aws.String(ec2.LaunchTemplateHttpTokensStateRequired),
+				}))
+			})
+			It("should use different launch templates for different metadata options", func() {
+				ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())
+				ExpectClusterStateCleanedUp(cluster)
+				provider.MetadataOptions = &v1alpha1.MetadataOptions{HTTPTokens: aws.String(ec2.LaunchTemplateHttpTokensStateRequired)}
+				ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())
+				Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(2))
+			})
+		})
+		Context("Placement Groups", func() {
+			It("should not specify a placement group by default", func() {
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(1))
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				Expect(input.LaunchTemplateData.Placement).To(BeNil())
+			})
+			It("should launch instances into the placement group", func() {
+				provider.PlacementGroup = aws.String("test-placement-group")
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(1))
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				Expect(input.LaunchTemplateData.Placement).To(Equal(&ec2.LaunchTemplatePlacementRequest{GroupName: aws.String("test-placement-group")}))
+			})
+			It("should launch instances of a cluster placement group in a single zone", func() {
+				provider.PlacementGroup = aws.String("test-placement-group")
+				fakeEC2API.DescribePlacementGroupsOutput = &ec2.DescribePlacementGroupsOutput{PlacementGroups: []*ec2.PlacementGroup{
+					{GroupName: aws.String("test-placement-group"), Strategy: aws.String(ec2.PlacementStrategyCluster)},
+				}}
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				input := fakeEC2API.CalledWithCreateFleetInput.Pop().(*ec2.CreateFleetInput)
+				zones := sets.NewString()
+				for _, launchTemplateConfig := range input.LaunchTemplateConfigs {
+					for _, override := range launchTemplateConfig.Overrides {
+						zones.Insert(aws.StringValue(override.AvailabilityZone))
+					}
+				}
+				Expect(zones.Len()).To(Equal(1))
+			})
+			It("should launch instances into the zone of the cluster placement group's instances", func() {
+				provider.PlacementGroup = aws.String("test-placement-group")
+				fakeEC2API.DescribePlacementGroupsOutput = &ec2.DescribePlacementGroupsOutput{PlacementGroups: []*ec2.PlacementGroup{
+					{GroupName: aws.String("test-placement-group"), Strategy: aws.String(ec2.PlacementStrategyCluster)},
+				}}
+				fakeEC2API.Instances.Store("i-in-placement-group", &ec2.Instance{
+					InstanceId: aws.String("i-in-placement-group"),
+					Placement:  &ec2.Placement{GroupName: aws.String("test-placement-group"), AvailabilityZone: aws.String("test-zone-1b")},
+				})
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				Expect(ExpectScheduled(ctx, env.Client, pod).Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, "test-zone-1b"))
+				input := fakeEC2API.CalledWithCreateFleetInput.Pop().(*ec2.CreateFleetInput)
+				for _, launchTemplateConfig := range input.LaunchTemplateConfigs {
+					for _, override := range launchTemplateConfig.Overrides {
+						Expect(aws.StringValue(override.AvailabilityZone)).To(Equal("test-zone-1b"))
+					}
+				}
+			})
+			It("should launch instances of a spread placement group in any zone", func() {
+				provider.PlacementGroup = aws.String("test-placement-group")
+				fakeEC2API.DescribePlacementGroupsOutput = &ec2.DescribePlacementGroupsOutput{PlacementGroups: []*ec2.PlacementGroup{
+					{GroupName: aws.String("test-placement-group"), Strategy: aws.String(ec2.PlacementStrategySpread)},
+				}}
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				input := fakeEC2API.CalledWithCreateFleetInput.Pop().(*ec2.CreateFleetInput)
+				zones := sets.NewString()
+				for _, launchTemplateConfig := range input.LaunchTemplateConfigs {
+					for _, override := range launchTemplateConfig.Overrides {
+						zones.Insert(aws.StringValue(override.AvailabilityZone))
+					}
+				}
+				Expect(zones.Len()).To(BeNumerically(">", 1))
+			})
+		})
+		Context("Elastic Fabric Adapters", func() {
+			efaPod := func() *v1.Pod {
+				return test.UnschedulablePod(test.PodOptions{
+					ResourceRequirements: v1.ResourceRequirements{
+						Requests: v1.ResourceList{resources.HabanaGaudi: resource.MustParse("8"), resources.AWSEFA: resource.MustParse("1")},
+						Limits:   v1.ResourceList{resources.HabanaGaudi: resource.MustParse("8"), resources.AWSEFA: resource.MustParse("1")},
+					},
+				})
+			}
+			It("should not attach network interfaces by default", func() {
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(1))
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				Expect(input.LaunchTemplateData.NetworkInterfaces).To(BeEmpty())
+				Expect(input.LaunchTemplateData.SecurityGroupIds).ToNot(BeEmpty())
+			})
+			It("should attach the maximum number of EFAs for pods that request them", func() {
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), efaPod())[0]
+				node := ExpectScheduled(ctx, env.Client, pod)
+				Expect(node.Labels).To(HaveKeyWithValue(v1.LabelInstanceTypeStable, "dl1.24xlarge"))
+				Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(1))
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				Expect(input.LaunchTemplateData.SecurityGroupIds).To(BeEmpty())
+				Expect(input.LaunchTemplateData.NetworkInterfaces).To(HaveLen(4))
+				for i, networkInterface := range input.LaunchTemplateData.NetworkInterfaces {
+					Expect(aws.StringValue(networkInterface.InterfaceType)).To(Equal(ec2.NetworkInterfaceTypeEfa))
+					Expect(aws.Int64Value(networkInterface.NetworkCardIndex)).To(BeNumerically("==", i))
+					Expect(networkInterface.Groups).To(ConsistOf(
+						aws.String("test-security-group-1"),
+						aws.String("test-security-group-2"),
+						aws.String("test-security-group-3"),
+					))
+				}
+				Expect(aws.Int64Value(input.LaunchTemplateData.NetworkInterfaces[0].DeviceIndex)).To(BeNumerically("==", 0))
+				Expect(aws.Int64Value(input.LaunchTemplateData.NetworkInterfaces[1].DeviceIndex)).To(BeNumerically("==", 1))
+			})
+			It("should advertise EFAs in the node's capacity", func() {
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), efaPod())[0]
+				node := ExpectScheduled(ctx, env.Client, pod)
+				capacity := node.Status.Capacity[resources.AWSEFA]
+				allocatable := node.Status.Allocatable[resources.AWSEFA]
+				Expect(capacity.Value()).To(BeNumerically("==", 4))
+				Expect(allocatable.Value()).To(BeNumerically("==", 4))
+			})
+			It("should not attach EFAs for pods that don't request them", func() {
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod(test.PodOptions{
+					ResourceRequirements: v1.ResourceRequirements{
+						Requests: v1.ResourceList{resources.HabanaGaudi: resource.MustParse("8")},
+						Limits:   v1.ResourceList{resources.HabanaGaudi: resource.MustParse("8")},
+					},
+				}))[0]
+				node := ExpectScheduled(ctx, env.Client, pod)
+				Expect(node.Labels).To(HaveKeyWithValue(v1.LabelInstanceTypeStable, "dl1.24xlarge"))
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				Expect(input.LaunchTemplateData.NetworkInterfaces).To(BeEmpty())
+			})
+			It("should not launch instance types without EFAs for pods that request them", func() {
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod(test.PodOptions{
+					ResourceRequirements: v1.ResourceRequirements{
+						Requests: v1.ResourceList{resources.AWSEFA: resource.MustParse("1")},
+						Limits:   v1.ResourceList{resources.AWSEFA: resource.MustParse("1")},
+					},
+				}))[0]
+				ExpectNotScheduled(ctx, env.Client, pod)
+			})
+			It("should only advertise EFAs on instance types that support them", func() {
+				instanceType := &InstanceType{InstanceTypeInfo: ec2.InstanceTypeInfo{NetworkInfo: &ec2.NetworkInfo{
+					EfaSupported: aws.Bool(false),
+					EfaInfo:      &ec2.EfaInfo{MaximumEfaInterfaces: aws.Int64(1)},
+				}}}
+				Expect(instanceType.ExtendedResources()).ToNot(HaveKey(resources.AWSEFA))
+				instanceType.NetworkInfo.EfaSupported = aws.Bool(true)
+				Expect(instanceType.ExtendedResources()).To(HaveKeyWithValue(resources.AWSEFA, resource.MustParse("1")))
+			})
+		})
+		Context("Public IP Addresses", func() {
+			It("should not associate public IP addresses if disabled", func() {
+				provider.AssociatePublicIPAddress = aws.Bool(false)
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(1))
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				Expect(input.LaunchTemplateData.SecurityGroupIds).To(BeEmpty())
+				Expect(input.LaunchTemplateData.NetworkInterfaces).To(HaveLen(1))
+				Expect(aws.Int64Value(input.LaunchTemplateData.NetworkInterfaces[0].DeviceIndex)).To(BeNumerically("==", 0))
+				Expect(input.LaunchTemplateData.NetworkInterfaces[0].AssociatePublicIpAddress).To(Equal(aws.Bool(false)))
+				Expect(input.LaunchTemplateData.NetworkInterfaces[0].Groups).To(ConsistOf(
+					aws.String("test-security-group-1"),
+					aws.String("test-security-group-2"),
+					aws.String("test-security-group-3"),
+				))
+			})
+			It("should only configure public IP addresses on the primary EFA", func() {
+				provider.AssociatePublicIPAddress = aws.Bool(false)
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod(test.PodOptions{
+					ResourceRequirements: v1.ResourceRequirements{
+						Requests: v1.ResourceList{resources.AWSEFA: resource.MustParse("1")},
+						Limits:   v1.ResourceList{resources.AWSEFA: resource.MustParse("1")},
+					},
+				}))[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				Expect(input.LaunchTemplateData.NetworkInterfaces).To(HaveLen(4))
+				Expect(input.LaunchTemplateData.NetworkInterfaces[0].AssociatePublicIpAddress).To(Equal(aws.Bool(false)))
+				Expect(input.LaunchTemplateData.NetworkInterfaces[1].AssociatePublicIpAddress).To(BeNil())
+			})
+		})
+		Context("Tenancy", func() {
+			It("should launch instances on dedicated hardware", func() {
+				provider.Tenancy = aws.String(ec2.TenancyDedicated)
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(1))
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				Expect(input.LaunchTemplateData.Placement).To(Equal(&ec2.LaunchTemplatePlacementRequest{Tenancy: aws.String(ec2.TenancyDedicated)}))
+			})
+			It("should launch instances on dedicated hosts from the host resource group", func() {
+				provider.Tenancy = aws.String(ec2.TenancyHost)
+				provider.HostResourceGroupARN = aws.String("arn:aws:resource-groups:us-west-2:111122223333:group/test-hosts")
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(1))
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				Expect(input.LaunchTemplateData.Placement).To(Equal(&ec2.LaunchTemplatePlacementRequest{
+					Tenancy:              aws.String(ec2.TenancyHost),
+					HostResourceGroupArn: aws.String("arn:aws:resource-groups:us-west-2:111122223333:group/test-hosts"),
+				}))
+			})
+			It("should launch on-demand capacity for dedicated tenancy", func() {
+				provider.Tenancy = aws.String(ec2.TenancyDedicated)
+				provisioner.Spec.Requirements = v1alpha5.Requirements{{Key: v1alpha5.LabelCapacityType, Operator: v1.NodeSelectorOpIn, Values: []string{v1alpha1.CapacityTypeSpot, v1alpha1.CapacityTypeOnDemand}}}
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				node := ExpectScheduled(ctx, env.Client, pod)
+				Expect(node.Labels).To(HaveKeyWithValue(v1alpha5.LabelCapacityType, v1alpha1.CapacityTypeOnDemand))
+			})
+		})
+		Context("Capacity Reservations", func() {
+			It("should not specify capacity reservations by default", func() {
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(1))
+				launchTemplateInput := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				Expect(launchTemplateInput.LaunchTemplateData.CapacityReservationSpecification).To(BeNil())
+				Expect(fakeEC2API.CalledWithCreateFleetInput.Cardinality()).To(Equal(1))
+				fleetInput := fakeEC2API.CalledWithCreateFleetInput.Pop().(*ec2.CreateFleetInput)
+				Expect(fleetInput.OnDemandOptions.CapacityReservationOptions).To(BeNil())
+			})
+			It("should specify the capacity reservation preference", func() {
+				provider.CapacityReservationSpecification = &v1alpha1.CapacityReservationSpecification{
+					CapacityReservationPreference: aws.String(ec2.CapacityReservationPreferenceNone),
+				}
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(1))
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				Expect(input.LaunchTemplateData.CapacityReservationSpecification).To(Equal(&ec2.LaunchTemplateCapacityReservationSpecificationRequest{
+					CapacityReservationPreference: aws.String(ec2.CapacityReservationPreferenceNone),
+				}))
+			})
+			It("should use the capacity reservations in the resource group first", func() {
+				provider.CapacityReservationSpecification = &v1alpha1.CapacityReservationSpecification{
+					CapacityReservationResourceGroupARN: aws.String("arn:aws:resource-groups:us-west-2:111122223333:group/test-group"),
+				}
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(1))
+				launchTemplateInput := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				Expect(launchTemplateInput.LaunchTemplateData.CapacityReservationSpecification).To(Equal(&ec2.LaunchTemplateCapacityReservationSpecificationRequest{
+					CapacityReservationTarget: &ec2.CapacityReservationTarget{
+						CapacityReservationResourceGroupArn: aws.String("arn:aws:resource-groups:us-west-2:111122223333:group/test-group"),
+					},
+				}))
+				Expect(fakeEC2API.CalledWithCreateFleetInput.Cardinality()).To(Equal(1))
+				fleetInput := fakeEC2API.CalledWithCreateFleetInput.Pop().(*ec2.CreateFleetInput)
+				Expect(fleetInput.OnDemandOptions.CapacityReservationOptions).To(Equal(&ec2.CapacityReservationOptionsRequest{
+					UsageStrategy: aws.String(ec2.FleetCapacityReservationUsageStrategyUseCapacityReservationsFirst),
+				}))
+			})
+		})
+		Context("Empty Instance Policy", func() {
+			var stoppedInstance *ec2.Instance
+			BeforeEach(func() {
+				stoppedInstance = &ec2.Instance{
+					InstanceId:     aws.String("i-0123456789abcdef0"),
+					InstanceType:   aws.String("m5.large"),
+					Placement:      &ec2.Placement{AvailabilityZone: aws.String("test-zone-1a")},
+					SubnetId:       aws.String("test-subnet-1"),
+					PrivateDnsName: aws.String("ip-192-168-0-1.test-region.compute.internal"),
+					State:          &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameStopped)},
+					Tags: []*ec2.Tag{
+						{Key: aws.String("karpenter.sh/cluster/test-cluster"), Value: aws.String("owned")},
+						{Key: aws.String(v1alpha1.ProvisionerNameTagKey), Value: aws.String(v1alpha5.DefaultProvisioner.Name)},
+						{Key: aws.String(v1alpha1.StoppedTimestampTagKey), Value: aws.String(time.Now().UTC().Format(time.RFC3339))},
+					},
+				}
+			})
+			It("should enable hibernation for on-demand instance types that support it", func() {
+				provider.EmptyInstancePolicy = aws.String(v1alpha1.EmptyInstancePolicyHibernate)
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod(
+					test.PodOptions{NodeSelector: map[string]string{v1.LabelInstanceTypeStable: "m5.large"}},
+				))[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(1))
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				Expect(input.LaunchTemplateData.HibernationOptions).To(Equal(&ec2.LaunchTemplateHibernationOptionsRequest{Configured: aws.Bool(true)}))
+			})
+			It("should not enable hibernation for instance types that don't support it", func() {
+				provider.EmptyInstancePolicy = aws.String(v1alpha1.EmptyInstancePolicyHibernate)
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod(
+					test.PodOptions{NodeSelector: map[string]string{v1.LabelInstanceTypeStable: "m5.xlarge"}},
+				))[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				Expect(input.LaunchTemplateData.HibernationOptions).To(BeNil())
+			})
+			It("should not enable hibernation for spot instances", func() {
+				provider.EmptyInstancePolicy = aws.String(v1alpha1.EmptyInstancePolicyHibernate)
+				provisioner.Spec.Requirements = v1alpha5.Requirements{{Key: v1alpha5.LabelCapacityType, Operator: v1.NodeSelectorOpIn, Values: []string{v1alpha1.CapacityTypeSpot}}}
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod(
+					test.PodOptions{NodeSelector: map[string]string{v1.LabelInstanceTypeStable: "m5.large"}},
+				))[0]
+				node := ExpectScheduled(ctx, env.Client, pod)
+				Expect(node.Annotations).ToNot(HaveKey(v1alpha1.EmptyInstancePolicyAnnotationKey))
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				Expect(input.LaunchTemplateData.HibernationOptions).To(BeNil())
+			})
+			It("should record the empty instance policy on on-demand nodes", func() {
+				provider.EmptyInstancePolicy = aws.String(v1alpha1.EmptyInstancePolicyStop)
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				node := ExpectScheduled(ctx, env.Client, pod)
+				Expect(node.Annotations).To(HaveKeyWithValue(v1alpha1.EmptyInstancePolicyAnnotationKey, v1alpha1.EmptyInstancePolicyStop))
+			})
+			It("should not record the empty instance policy by default", func() {
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				node := ExpectScheduled(ctx, env.Client, pod)
+				Expect(node.Annotations).ToNot(HaveKey(v1alpha1.EmptyInstancePolicyAnnotationKey))
+				Expect(node.Annotations).ToNot(HaveKey(v1alpha1.RebalanceRecommendationPolicyAnnotationKey))
+			})
+			It("should record the rebalance recommendation policy on nodes", func() {
+				provider.RebalanceRecommendationPolicy = aws.String(v1alpha1.RebalanceRecommendationPolicyReplace)
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				node := ExpectScheduled(ctx, env.Client, pod)
+				Expect(node.Annotations).To(HaveKeyWithValue(v1alpha1.RebalanceRecommendationPolicyAnnotationKey, v1alpha1.RebalanceRecommendationPolicyReplace))
+			})
+			specHashTag := func() *ec2.Tag {
+				hash, err := specHash(&v1alpha1.Constraints{Constraints: &ProvisionerWithProvider(provisioner, provider).Spec.Constraints, AWS: provider})
+				Expect(err).ToNot(HaveOccurred())
+				return &ec2.Tag{Key: aws.String(v1alpha1.SpecHashTagKey), Value: aws.String(hash)}
+			}
+			It("should restart stopped instances for pending pods", func() {
+				provider.EmptyInstancePolicy = aws.String(v1alpha1.EmptyInstancePolicyStop)
+				stoppedInstance.Tags = append(stoppedInstance.Tags, specHashTag())
+				fakeEC2API.Instances.Store(aws.StringValue(stoppedInstance.InstanceId), stoppedInstance)
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				node := ExpectScheduled(ctx, env.Client, pod)
+				Expect(node.Spec.ProviderID).To(Equal("aws:///test-zone-1a/i-0123456789abcdef0"))
+				Expect(node.Annotations).To(HaveKeyWithValue(v1alpha1.EmptyInstancePolicyAnnotationKey, v1alpha1.EmptyInstancePolicyStop))
+				Expect(fakeEC2API.CalledWithCreateFleetInput.Cardinality()).To(BeZero())
+				Expect(aws.StringValue(stoppedInstance.State.Name)).To(Equal(ec2.InstanceStateNameRunning))
+				_, ok := stoppedTimestamp(stoppedInstance)
+				Expect(ok).To(BeFalse())
+			})
+			It("should terminate stopped instances that were launched from another provisioner spec", func() {
+				provider.EmptyInstancePolicy = aws.String(v1alpha1.EmptyInstancePolicyStop)
+				stoppedInstance.Tags = append(stoppedInstance.Tags, &ec2.Tag{Key: aws.String(v1alpha1.SpecHashTagKey), Value: aws.String("stale")})
+				fakeEC2API.Instances.Store(aws.StringValue(stoppedInstance.InstanceId), stoppedInstance)
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				node := ExpectScheduled(ctx, env.Client, pod)
+				Expect(node.Spec.ProviderID).ToNot(ContainSubstring("i-0123456789abcdef0"))
+				Expect(fakeEC2API.CalledWithCreateFleetInput.Cardinality()).To(Equal(1))
+				_, ok := fakeEC2API.Instances.Load("i-0123456789abcdef0")
+				Expect(ok).To(BeFalse())
+			})
+			It("should terminate stopped instances that were stopped without a spec hash", func() {
+				provider.EmptyInstancePolicy = aws.String(v1alpha1.EmptyInstancePolicyStop)
+				fakeEC2API.Instances.Store(aws.StringValue(stoppedInstance.InstanceId), stoppedInstance)
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				Expect(fakeEC2API.CalledWithCreateFleetInput.Cardinality()).To(Equal(1))
+				_, ok := fakeEC2API.Instances.Load("i-0123456789abcdef0")
+				Expect(ok).To(BeFalse())
+			})
+			It("should not restart the stopped instances of other provisioners", func() {
+				provider.EmptyInstancePolicy = aws.String(v1alpha1.EmptyInstancePolicyStop)
+				stoppedInstance.Tags[1].Value = aws.String("other-provisioner")
+				fakeEC2API.Instances.Store(aws.StringValue(stoppedInstance.InstanceId), stoppedInstance)
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				node := ExpectScheduled(ctx, env.Client, pod)
+				Expect(node.Spec.ProviderID).ToNot(ContainSubstring("i-0123456789abcdef0"))
+				Expect(aws.StringValue(stoppedInstance.State.Name)).To(Equal(ec2.InstanceStateNameStopped))
+			})
+			It("should not restart stopped instances that the pods don't allow", func() {
+				provider.EmptyInstancePolicy = aws.String(v1alpha1.EmptyInstancePolicyStop)
+				fakeEC2API.Instances.Store(aws.StringValue(stoppedInstance.InstanceId), stoppedInstance)
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod(
+					test.PodOptions{NodeSelector: map[string]string{v1.LabelInstanceTypeStable: "m5.xlarge"}},
+				))[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				Expect(fakeEC2API.CalledWithCreateFleetInput.Cardinality()).To(Equal(1))
+				Expect(aws.StringValue(stoppedInstance.State.Name)).To(Equal(ec2.InstanceStateNameStopped))
+			})
+			It("should not restart stopped instances if the provisioner terminates empty instances", func() {
+				fakeEC2API.Instances.Store(aws.StringValue(stoppedInstance.InstanceId), stoppedInstance)
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				Expect(fakeEC2API.CalledWithCreateFleetInput.Cardinality()).To(Equal(1))
+				Expect(aws.StringValue(stoppedInstance.State.Name)).To(Equal(ec2.InstanceStateNameStopped))
+			})
+			Context("Deletion", func() {
+				var node *v1.Node
+				BeforeEach(func() {
+					stoppedInstance.State = &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)}
+					stoppedInstance.Tags = stoppedInstance.Tags[:2]
+					fakeEC2API.Instances.Store(aws.StringValue(stoppedInstance.InstanceId), stoppedInstance)
+					node = test.Node(test.NodeOptions{ProviderID: "aws:///test-zone-1a/i-0123456789abcdef0"})
+					node.Annotations = map[string]string{
+						v1alpha1.EmptyInstancePolicyAnnotationKey: v1alpha1.EmptyInstancePolicyStop,
+						v1alpha5.EmptinessTimestampAnnotationKey:  time.Now().Format(time.RFC3339),
+						v1alpha5.ReusableAnnotationKey:            "true",
+					}
+				})
+				It("should stop the instances of empty nodes", func() {
+					node.Annotations[v1alpha1.SpecHashAnnotationKey] = "123"
+					Expect(cloudProvider.Delete(ctx, node)).To(Succeed())
+					Expect(aws.StringValue(stoppedInstance.State.Name)).To(Equal(ec2.InstanceStateNameStopped))
+					_, ok := stoppedTimestamp(stoppedInstance)
+					Expect(ok).To(BeTrue())
+					hash, ok := getTag(stoppedInstance, v1alpha1.SpecHashTagKey)
+					Expect(ok).To(BeTrue())
+					Expect(hash).To(Equal("123"))
+					input := fakeEC2API.CalledWithStopInstancesInput.Pop().(*ec2.StopInstancesInput)
+					Expect(aws.BoolValue(input.Hibernate)).To(BeFalse())
+				})
+				It("should hibernate the instances of empty nodes that were launched with hibernation", func() {
+					node.Annotations[v1alpha1.EmptyInstancePolicyAnnotationKey] = v1alpha1.EmptyInstancePolicyHibernate
+					Expect(cloudProvider.Delete(ctx, node)).To(Succeed())
+					input := fakeEC2API.CalledWithStopInstancesInput.Pop().(*ec2.StopInstancesInput)
+					Expect(aws.BoolValue(input.Hibernate)).To(BeTrue())
+				})
+				It("should terminate the instances of nodes that aren't empty", func() {
+					delete(node.Annotations, v1alpha5.EmptinessTimestampAnnotationKey)
+					delete(node.Annotations, v1alpha5.ReusableAnnotationKey)
+					Expect(cloudProvider.Delete(ctx, node)).To(Succeed())
+					_, ok := fakeEC2API.Instances.Load("i-0123456789abcdef0")
+					Expect(ok).To(BeFalse())
+				})
+				It("should terminate the instances of empty nodes that were deleted for another reason", func() {
+					delete(node.Annotations, v1alpha5.ReusableAnnotationKey)
+					Expect(cloudProvider.Delete(ctx, node)).To(Succeed())
+					_, ok := fakeEC2API.Instances.Load("i-0123456789abcdef0")
+					Expect(ok).To(BeFalse())
+				})
+			})
+		})
+		Context("Targets", func() {
+			BeforeEach(func() {
+				cloudProvider.targets = &targets{
+					session: session.Must(session.NewSession(&aws.Config{Region: aws.String("test-region")})),
+					providers: map[target]*providers{
+						{}:                        cloudProvider.providers,
+						{region: "test-region-2"}: cloudProvider.providers,
+					},
+				}
+			})
+			AfterEach(func() {
+				cloudProvider.targets = nil
+			})
+			It("should annotate nodes launched in another region", func() {
+				provider.Region = aws.String("test-region-2")
+				provisioner = ProvisionerWithProvider(provisioner, provider)
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod())[0]
+				node := ExpectScheduled(ctx, env.Client, pod)
+				Expect(node.Annotations).To(HaveKeyWithValue(v1alpha1.RegionAnnotationKey, "test-region-2"))
+				Expect(node.Annotations).ToNot(HaveKey(v1alpha1.AssumeRoleARNAnnotationKey))
+			})
+			It("should not annotate nodes launched in Karpenter's own region", func() {
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod())[0]
+				node := ExpectScheduled(ctx, env.Client, pod)
+				Expect(node.Annotations).ToNot(HaveKey(v1alpha1.RegionAnnotationKey))
+			})
+			It("should share the providers of Karpenter's own region", func() {
+				Expect(cloudProvider.providersFor(target{region: "test-region"})).To(BeIdenticalTo(cloudProvider.providers))
+			})
+		})
+		Context("Node Templates", func() {
+			var nodeTemplate *v1alpha1.AWSNodeTemplate
+			BeforeEach(func() {
+				nodeTemplate = &v1alpha1.AWSNodeTemplate{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-node-template"},
+					Spec: v1alpha1.AWSNodeTemplateSpec{AWS: v1alpha1.AWS{
+						InstanceProfile:       "test-node-template-instance-profile",
+						SubnetSelector:        map[string]string{"*": "*"},
+						SecurityGroupSelector: map[string]string{"*": "*"},
+					}},
+				}
+				provisioner.Spec.Provider = nil
+				provisioner.Spec.ProviderRef = &v1alpha5.ProviderRef{Kind: "AWSNodeTemplate", Name: nodeTemplate.Name}
+			})
+			AfterEach(func() {
+				ExpectDeleted(ctx, env.Client, nodeTemplate)
+			})
+			It("should launch instances with the referenced node template", func() {
+				ExpectApplied(ctx, env.Client, nodeTemplate)
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod())[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				Expect(aws.StringValue(input.LaunchTemplateData.IamInstanceProfile.Name)).To(Equal("test-node-template-instance-profile"))
+			})
+			It("should not launch instances if the node template does not exist", func() {
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod())[0]
+				ExpectNotScheduled(ctx, env.Client, pod)
+			})
+			It("should report nodes launched with another node template spec", func() {
+				ExpectApplied(ctx, env.Client, nodeTemplate)
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod())[0]
+				node := ExpectScheduled(ctx, env.Client, pod)
+				launched, _ := fakeEC2API.Instances.Load(strings.Split(node.Spec.ProviderID, "/")[4])
+				launched.(*ec2.Instance).ImageId = aws.String("test-ami-id")
+				Expect(cloudProvider.IsDrifted(ctx, node, provisioner)).To(BeEmpty())
+				nodeTemplate.Spec.Tags = map[string]string{"test-key": "test-value"}
+				ExpectApplied(ctx, env.Client, nodeTemplate)
+				Expect(cloudProvider.IsDrifted(ctx, node, provisioner)).To(Equal("provisioner spec changed since the node was launched"))
+			})
+		})
+		Context("Drift", func() {
+			var instance *ec2.Instance
+			var node *v1.Node
+			BeforeEach(func() {
+				instance = &ec2.Instance{
+					InstanceId:     aws.String("i-0123456789abcdef0"),
+					InstanceType:   aws.String("m5.large"),
+					ImageId:        aws.String("test-ami-id"),
+					PrivateDnsName: aws.String("test-private-dns-name"),
+					Placement:      &ec2.Placement{AvailabilityZone: aws.String("test-zone-1a")},
+				}
+				fakeEC2API.Instances.Store(aws.StringValue(instance.InstanceId), instance)
+				node = test.Node(test.NodeOptions{ProviderID: "aws:///test-zone-1a/i-0123456789abcdef0"})
+			})
+			It("should not report instances with the provisioner's AMI", func() {
+				Expect(cloudProvider.IsDrifted(ctx, node, provisioner)).To(BeEmpty())
+			})
+			It("should report instances with another AMI", func() {
+				instance.ImageId = aws.String("test-ami-old")
+				Expect(cloudProvider.IsDrifted(ctx, node, provisioner)).To(Equal("instance has ami test-ami-old, but the provisioner launches test-ami-id"))
+			})
+			It("should report nodes launched with another spec", func() {
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod())[0]
+				node := ExpectScheduled(ctx, env.Client, pod)
+				Expect(node.Annotations).To(HaveKey(v1alpha1.SpecHashAnnotationKey))
+				launched, _ := fakeEC2API.Instances.Load(strings.Split(node.Spec.ProviderID, "/")[4])
+				launched.(*ec2.Instance).ImageId = aws.String("test-ami-id")
+				Expect(cloudProvider.IsDrifted(ctx, node, provisioner)).To(BeEmpty())
+				provider.Tags = map[string]string{"test-key": "test-value"}
+				Expect(cloudProvider.IsDrifted(ctx, node, ProvisionerWithProvider(provisioner, provider))).To(Equal("provisioner spec changed since the node was launched"))
+			})
+			Context("Launch Templates", func() {
+				BeforeEach(func() {
+					provider.LaunchTemplate = aws.String("test-launch-template")
+					provisioner = ProvisionerWithProvider(provisioner, provider)
+					fakeEC2API.DescribeLaunchTemplatesOutput = &ec2.DescribeLaunchTemplatesOutput{LaunchTemplates: []*ec2.LaunchTemplate{{
+						LaunchTemplateName:   aws.String("test-launch-template"),
+						LaunchTemplateId:     aws.String("lt-0123456789abcdef0"),
+						DefaultVersionNumber: aws.Int64(2),
+						LatestVersionNumber:  aws.Int64(3),
+					}}}
+					instance.Tags = []*ec2.Tag{
+						{Key: aws.String("aws:ec2launchtemplate:id"), Value: aws.String("lt-0123456789abcdef0")},
+						{Key: aws.String("aws:ec2launchtemplate:version"), Value: aws.String("2")},
+					}
+				})
+				It("should not report instances launched from the default version", func() {
+					Expect(cloudProvider.IsDrifted(ctx, node, provisioner)).To(BeEmpty())
+				})
+				It("should report instances launched from another version", func() {
+					provider.LaunchTemplateVersion = aws.String("$Latest")
+					Expect(cloudProvider.IsDrifted(ctx, node, ProvisionerWithProvider(provisioner, provider))).To(
+						Equal("instance was launched from version 2 of launch template test-launch-template, but the provisioner launches version 3"))
+				})
+				It("should report instances launched from another launch template", func() {
+					instance.Tags[0].Value = aws.String("lt-other")
+					Expect(cloudProvider.IsDrifted(ctx, node, provisioner)).To(ContainSubstring("launched from launch template lt-other"))
+				})
+			})
+		})
+		Context("User Data", func() {
+			It("should run the user data before the bootstrap script", func() {
+				provider.UserData = aws.String("#!/bin/bash\necho 'custom user data'\n")
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(1))
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				userData, _ := base64.StdEncoding.DecodeString(*input.LaunchTemplateData.UserData)
+				Expect(string(userData)).To(HavePrefix("MIME-Version: 1.0\nContent-Type: multipart/mixed"))
+				Expect(strings.Index(string(userData), "echo 'custom user data'")).To(BeNumerically("<", strings.Index(string(userData), "/etc/eks/bootstrap.sh")))
+			})
+			It("should run the user data after the bootstrap script when appended", func() {
+				provider.UserData = aws.String("#!/bin/bash\necho 'custom user data'\n")
+				provider.UserDataMode = aws.String(v1alpha1.UserDataModeAppend)
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(1))
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				userData, _ := base64.StdEncoding.DecodeString(*input.LaunchTemplateData.UserData)
+				Expect(strings.Index(string(userData), "echo 'custom user data'")).To(BeNumerically(">", strings.Index(string(userData), "/etc/eks/bootstrap.sh")))
+			})
+			It("should keep the parts of MIME multipart user data", func() {
+				provider.UserData = aws.String(strings.Join([]string{
+					"MIME-Version: 1.0",
+					`Content-Type: multipart/mixed; boundary="BOUNDARY"`,
+					"",
+					"--BOUNDARY",
+					`Content-Type: text/cloud-config; charset="us-ascii"`,
+					"",
+					"#cloud-config",
+					"--BOUNDARY",
+					`Content-Type: text/x-shellscript; charset="us-ascii"`,
+					"",
+					"echo 'custom user data'",
+					"--BOUNDARY--",
+				}, "\n"))
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(1))
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				userData, _ := base64.StdEncoding.DecodeString(*input.LaunchTemplateData.UserData)
+				Expect(string(userData)).ToNot(ContainSubstring("BOUNDARY"))
+				Expect(strings.Count(string(userData), "Content-Type: text/")).To(Equal(3))
+				Expect(string(userData)).To(ContainSubstring("Content-Type: text/cloud-config; charset=\"us-ascii\"\r\n\r\n#cloud-config"))
+				Expect(string(userData)).To(ContainSubstring("echo 'custom user data'"))
+			})
+			It("should keep the headers of MIME multipart user data parts", func() {
+				encoded := base64.StdEncoding.EncodeToString([]byte("#!/bin/bash\necho 'custom user data'\n"))
+				provider.UserData = aws.String(strings.Join([]string{
+					"MIME-Version: 1.0",
+					`Content-Type: multipart/mixed; boundary="BOUNDARY"`,
+					"",
+					"--BOUNDARY",
+					`Content-Type: text/x-shellscript; charset="us-ascii"`,
+					"Content-Transfer-Encoding: base64",
+					"",
+					encoded,
+					"--BOUNDARY--",
+				}, "\n"))
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(1))
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				userData, _ := base64.StdEncoding.DecodeString(*input.LaunchTemplateData.UserData)
+				Expect(string(userData)).To(ContainSubstring("Content-Transfer-Encoding: base64\r\nContent-Type: text/x-shellscript; charset=\"us-ascii\"\r\n\r\n" + encoded))
+			})
+			It("should merge Bottlerocket settings with the user data", func() {
+				provider.AMIFamily = aws.String(v1alpha1.AMIFamilyBottlerocket)
+				provider.UserData = aws.String(strings.Join([]string{
+					"[settings.kubernetes]",
+					`cluster-name = "wrong-cluster"`,
+					`image-gc-high-threshold-percent = "85"`,
+					"[settings.host-containers.admin]",
+					"enabled = true",
+				}, "\n"))
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(1))
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				userData, _ := base64.StdEncoding.DecodeString(*input.LaunchTemplateData.UserData)
+				Expect(string(userData)).To(ContainSubstring("[settings.host-containers.admin]\nenabled = true\n"))
+				Expect(string(userData)).To(ContainSubstring("cluster-name = \"test-cluster\"\nimage-gc-high-threshold-percent = \"85\"\n"))
+				Expect(string(userData)).ToNot(ContainSubstring("wrong-cluster"))
+			})
+			It("should keep arrays of tables and multi-line values in Bottlerocket settings", func() {
+				provider.AMIFamily = aws.String(v1alpha1.AMIFamilyBottlerocket)
+				provider.UserData = aws.String(strings.Join([]string{
+					"[settings.kernel.sysctl]",
+					`"net.ipv4.tcp_keepalive_time" = "600"`,
+					"[[settings.container-registry.mirrors]]",
+					`registry = "docker.io"`,
+					"endpoint = [",
+					`  "https://mirror.example.com",`,
+					"]",
+					"[settings.kubernetes]",
+					`cluster-name = "wrong-cluster"`,
+				}, "\n"))
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(1))
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				userData, _ := base64.StdEncoding.DecodeString(*input.LaunchTemplateData.UserData)
+				Expect(string(userData)).To(ContainSubstring("[settings.kernel.sysctl]\n\"net.ipv4.tcp_keepalive_time\" = \"600\"\n"))
+				Expect(string(userData)).To(ContainSubstring("[[settings.container-registry.mirrors]]\nendpoint = [\"https://mirror.example.com\"]\nregistry = \"docker.io\"\n"))
+				Expect(string(userData)).ToNot(ContainSubstring("wrong-cluster"))
+			})
+			It("should configure containerd to pull from registry mirrors", func() {
+				provider.ContainerRegistryMirrors = map[string]string{"docker.io": "https://mirror.example.com"}
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(1))
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				userData, _ := base64.StdEncoding.DecodeString(*input.LaunchTemplateData.UserData)
+				Expect(string(userData)).To(ContainSubstring("cat <<'EOF' > '/etc/containerd/certs.d/docker.io/hosts.toml'\n[host.\"https://mirror.example.com\"]\n"))
+				Expect(strings.Index(string(userData), "/etc/containerd/certs.d")).To(BeNumerically("<", strings.Index(string(userData), "/etc/eks/bootstrap.sh")))
+			})
+			It("should configure Bottlerocket to pull from registry mirrors", func() {
+				provider.AMIFamily = aws.String(v1alpha1.AMIFamilyBottlerocket)
+				provider.ContainerRegistryMirrors = map[string]string{"docker.io": "https://mirror.example.com", "public.ecr.aws": "https://ecr-mirror.example.com"}
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(1))
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				userData, _ := base64.StdEncoding.DecodeString(*input.LaunchTemplateData.UserData)
+				Expect(string(userData)).To(ContainSubstring(strings.Join([]string{
+					"[[settings.container-registry.mirrors]]",
+					`endpoint = ["https://mirror.example.com"]`,
+					`registry = "docker.io"`,
+					"",
+					"[[settings.container-registry.mirrors]]",
+					`endpoint = ["https://ecr-mirror.example.com"]`,
+					`registry = "public.ecr.aws"`,
+					"",
+				}, "\n")))
+			})
+			It("should not schedule if Bottlerocket user data can't be parsed", func() {
+				provider.AMIFamily = aws.String(v1alpha1.AMIFamilyBottlerocket)
+				provider.UserData = aws.String("[settings.kubernetes]\nnode-labels = [")
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				ExpectNotScheduled(ctx, env.Client, pod)
+			})
+		})
+		Context("Verification", func() {
+			It("should verify the provisioner", func() {
+				Expect(cloudProvider.Verify(ctx, &ProvisionerWithProvider(provisioner, provider).Spec.Constraints)).To(Succeed())
+			})
+			It("should fail if no subnets match", func() {
+				provider.SubnetSelector = map[string]string{"Name": "missing-subnet"}
+				fakeEC2API.DescribeSubnetsOutput = &ec2.DescribeSubnetsOutput{Subnets: []*ec2.Subnet{}}
+				err := cloudProvider.Verify(ctx, &ProvisionerWithProvider(provisioner, provider).Spec.Constraints)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("no subnets matched selector"))
+			})
+			It("should fail if no security groups match", func() {
+				fakeEC2API.DescribeSecurityGroupsOutput = &ec2.DescribeSecurityGroupsOutput{SecurityGroups: []*ec2.SecurityGroup{}}
+				err := cloudProvider.Verify(ctx, &ProvisionerWithProvider(provisioner, provider).Spec.Constraints)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("no security groups exist given constraints"))
+				reason, ok := cloudprovider.ConfigurationErrorReason(err)
+				Expect(ok).To(BeTrue())
+				Expect(reason).To(Equal(SecurityGroupsNotFoundReason))
+			})
+			It("should fail if a selected security group does not exist", func() {
+				provider.SecurityGroupSelector = map[string]string{v1alpha1.SecurityGroupIDsSelectorKey: "test-security-group-1,sg-missing"}
+				fakeEC2API.DescribeSecurityGroupsOutput = &ec2.DescribeSecurityGroupsOutput{SecurityGroups: []*ec2.SecurityGroup{
+					{GroupId: aws.String("test-security-group-1"), VpcId: aws.String("test-vpc")},
+				}}
+				err := cloudProvider.Verify(ctx, &ProvisionerWithProvider(provisioner, provider).Spec.Constraints)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("security groups [sg-missing] do not exist"))
+				reason, ok := cloudprovider.ConfigurationErrorReason(err)
+				Expect(ok).To(BeTrue())
+				Expect(reason).To(Equal(SecurityGroupsNotFoundReason))
+			})
+			It("should fail if the security groups are in a different vpc than the subnets", func() {
+				fakeEC2API.DescribeSecurityGroupsOutput = &ec2.DescribeSecurityGroupsOutput{SecurityGroups: []*ec2.SecurityGroup{
+					{GroupId: aws.String("test-security-group-1"), VpcId: aws.String("test-vpc")},
+					{GroupId: aws.String("test-security-group-2"), VpcId: aws.String("test-other-vpc")},
+				}}
+				err := cloudProvider.Verify(ctx, &ProvisionerWithProvider(provisioner, provider).Spec.Constraints)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("security groups and subnets must be in the same vpc"))
+				reason, ok := cloudprovider.ConfigurationErrorReason(err)
+				Expect(ok).To(BeTrue())
+				Expect(reason).To(Equal(SecurityGroupsConflictReason))
+			})
+			It("should report the reason on the provisioner's validated condition", func() {
+				fakeEC2API.DescribeSecurityGroupsOutput = &ec2.DescribeSecurityGroupsOutput{SecurityGroups: []*ec2.SecurityGroup{}}
+				ExpectApplied(ctx, env.Client, ProvisionerWithProvider(provisioner, provider))
+				ExpectReconcileFailed(ctx, provisioners, client.ObjectKeyFromObject(provisioner))
+				ExpectReconcileSucceeded(ctx, statusController, client.ObjectKeyFromObject(provisioner))
+				persisted := &v1alpha5.Provisioner{}
+				Expect(env.Client.Get(ctx, client.ObjectKeyFromObject(provisioner), persisted)).To(Succeed())
+				validated := persisted.StatusConditions().GetCondition(v1alpha5.Validated)
+				Expect(validated.IsFalse()).To(BeTrue())
+				Expect(validated.Reason).To(Equal(SecurityGroupsNotFoundReason))
+				Expect(provisioners.List(ctx)).To(BeEmpty())
+			})
+			It("should fail if the instance profile does not exist", func() {
+				provider.InstanceProfile = "missing-instance-profile"
+				fakeIAMAPI.WantErr = fmt.Errorf("instance profile not found")
+				err := cloudProvider.Verify(ctx, &ProvisionerWithProvider(provisioner, provider).Spec.Constraints)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("getting instance profile missing-instance-profile"))
+			})
+			It("should report instance profiles that do not exist", func() {
+				provider.InstanceProfile = "missing-instance-profile"
+				fakeIAMAPI.WantErr = awserr.New(iam.ErrCodeNoSuchEntityException, "instance profile not found", nil)
+				err := cloudProvider.Verify(ctx, &ProvisionerWithProvider(provisioner, provider).Spec.Constraints)
+				Expect(err).To(HaveOccurred())
+				reason, ok := cloudprovider.ConfigurationErrorReason(err)
+				Expect(ok).To(BeTrue())
+				Expect(reason).To(Equal(InstanceProfileNotFoundReason))
+			})
+			It("should verify the default instance profile", func() {
+				provider.InstanceProfile = ""
+				opts := injection.GetOptions(ctx)
+				opts.AWSDefaultInstanceProfile = "missing-default-instance-profile"
+				fakeIAMAPI.WantErr = fmt.Errorf("instance profile not found")
+				err := cloudProvider.Verify(injection.WithOptions(ctx, opts), &ProvisionerWithProvider(provisioner, provider).Spec.Constraints)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("getting instance profile missing-default-instance-profile"))
+			})
+			It("should not fail if the instance profile's role is missing node policies", func() {
+				fakeIAMAPI.AttachedRolePolicies = []*iam.AttachedPolicy{{PolicyName: aws.String("AmazonEKSWorkerNodePolicy")}}
+				Expect(cloudProvider.Verify(ctx, &ProvisionerWithProvider(provisioner, provider).Spec.Constraints)).To(Succeed())
+			})
+			It("should not verify the instance profile if IAM is unreachable", func() {
+				fakeIAMAPI.WantErr = awserr.New(request.ErrCodeRequestError, "send request failed", nil)
+				Expect(cloudProvider.Verify(ctx, &ProvisionerWithProvider(provisioner, provider).Spec.Constraints)).To(Succeed())
+			})
+			It("should not verify node policies if listing them is not permitted", func() {
+				fakeIAMAPI.ListAttachedRolePoliciesErr = awserr.New(AccessDeniedErrorCode, "not authorized", nil)
+				Expect(cloudProvider.Verify(ctx, &ProvisionerWithProvider(provisioner, provider).Spec.Constraints)).To(Succeed())
+			})
+			It("should keep provisioning if the instance profile's role is missing node policies", func() {
+				fakeIAMAPI.AttachedRolePolicies = []*iam.AttachedPolicy{}
+				ExpectApplied(ctx, env.Client, ProvisionerWithProvider(provisioner, provider))
+				ExpectReconcileSucceeded(ctx, provisioners, client.ObjectKeyFromObject(provisioner))
+				Expect(provisioners.List(ctx)).To(HaveLen(1))
+			})
+			It("should verify a launch template", func() {
+				provider.LaunchTemplate = aws.String("test-launch-template")
+				provider.InstanceProfile = ""
+				fakeEC2API.LaunchTemplates.Store("test-launch-template", &ec2.LaunchTemplate{LaunchTemplateName: aws.String("test-launch-template")})
+				Expect(cloudProvider.Verify(ctx, &ProvisionerWithProvider(provisioner, provider).Spec.Constraints)).To(Succeed())
+			})
+			It("should fail if the launch template does not exist", func() {
+				provider.LaunchTemplate = aws.String("missing-launch-template")
+				provider.InstanceProfile = ""
+				err := cloudProvider.Verify(ctx, &ProvisionerWithProvider(provisioner, provider).Spec.Constraints)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("describing launch template missing-launch-template"))
+			})
+			It("should not provision from a provisioner that fails verification", func() {
+				provider.InstanceProfile = "missing-instance-profile"
+				fakeIAMAPI.WantErr = fmt.Errorf("instance profile not found")
+				ExpectApplied(ctx, env.Client, ProvisionerWithProvider(provisioner, provider))
+				ExpectReconcileFailed(ctx, provisioners, client.ObjectKeyFromObject(provisioner))
+				Expect(provisioners.List(ctx)).To(BeEmpty())
+			})
+		})
+		Context("Kubelet Args", func() {
+			It("should specify the --dns-cluster-ip flag when clusterDNSIP is set", func() {
+				provisioner.Spec.KubeletConfiguration.ClusterDNS = []string{"10.0.10.100"}
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(1))
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				userData, _ := base64.StdEncoding.DecodeString(*input.LaunchTemplateData.UserData)
+				Expect(string(userData)).To(ContainSubstring("--dns-cluster-ip '10.0.10.100'"))
+			})
+			It("should register nodes with taints and startup taints", func() {
+				provisioner.Spec.Taints = []v1.Taint{{Key: "a", Value: "b", Effect: v1.TaintEffectNoSchedule}}
+				provisioner.Spec.StartupTaints = []v1.Taint{{Key: "c", Value: "d", Effect: v1.TaintEffectNoSchedule}}
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod(
+					test.PodOptions{Tolerations: []v1.Toleration{{Key: "a", Operator: v1.TolerationOpExists}}},
+				))[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(1))
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				userData, _ := base64.StdEncoding.DecodeString(*input.LaunchTemplateData.UserData)
+				Expect(string(userData)).To(ContainSubstring("--register-with-taints=a=b:NoSchedule,c=d:NoSchedule"))
+			})
+			It("should pass the kubelet configuration to the kubelet", func() {
+				provisioner.Spec.KubeletConfiguration.MaxPods = aws.Int32(20)
+				provisioner.Spec.KubeletConfiguration.KubeReserved = v1.ResourceList{v1.ResourceMemory: resource.MustParse("150Mi"), v1.ResourceCPU: resource.MustParse("200m")}
+				provisioner.Spec.KubeletConfiguration.SystemReserved = v1.ResourceList{v1.ResourceCPU: resource.MustParse("100m")}
+				provisioner.Spec.KubeletConfiguration.EvictionHard = map[string]string{"nodefs.available": "10%", "memory.available": "300Mi"}
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(1))
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				userData, _ := base64.StdEncoding.DecodeString(*input.LaunchTemplateData.UserData)
+				Expect(string(userData)).To(ContainSubstring("--eviction-hard=memory.available<300Mi,nodefs.available<10% --kube-reserved=cpu=200m,memory=150Mi --max-pods=20 --system-reserved=cpu=100m"))
+				Expect(string(userData)).To(ContainSubstring("--use-max-pods false"))
+			})
+			It("should not configure more pods than ENIs support", func() {
+				provisioner.Spec.Requirements = v1alpha5.Requirements{{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpIn, Values: []string{"t3.large", "m5.large"}}}
+				provisioner.Spec.KubeletConfiguration.MaxPods = aws.Int32(50)
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				node := ExpectScheduled(ctx, env.Client, pod)
+				Expect(node.Labels).To(HaveKeyWithValue(v1.LabelInstanceTypeStable, "t3.large"))
+				Expect(node.Status.Allocatable.Pods().Value()).To(BeNumerically("==", 35))
+				// t3.large supports 35 pods, while m5.large supports 89
+				Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(2))
+				maxPods := []string{}
+				for _, input := range fakeEC2API.CalledWithCreateLaunchTemplateInput.ToSlice() {
+					userData, _ := base64.StdEncoding.DecodeString(*input.(*ec2.CreateLaunchTemplateInput).LaunchTemplateData.UserData)
+					Expect(string(userData)).To(ContainSubstring("--use-max-pods false"))
+					for _, arg := range strings.Fields(string(userData)) {
+						if strings.HasPrefix(arg, "--max-pods=") {
+							maxPods = append(maxPods, arg)
+						}
+					}
+				}
+				Expect(maxPods).To(ConsistOf("--max-pods=35", "--max-pods=50"))
+			})
+			It("should use the kubelet's default max pods if pod density is not limited by ENIs", func() {
+				opts := injection.GetOptions(ctx)
+				opts.AWSENILimitedPodDensity = false
+				ctx := injection.WithOptions(ctx, opts)
+				instanceTypeCache.Flush()
+				defer instanceTypeCache.Flush()
+				provisioner.Spec.Requirements = v1alpha5.Requirements{{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpIn, Values: []string{"t3.large"}}}
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, ProvisionerWithProvider(provisioner, provider), test.UnschedulablePod())[0]
+				node := ExpectScheduled(ctx, env.Client, pod)
+				Expect(node.Status.Allocatable.Pods().Value()).To(BeNumerically("==", DefaultMaxPods))
+				Expect(fakeEC2API.CalledWithCreateLaunchTemplateInput.Cardinality()).To(Equal(1))
+				input := fakeEC2API.CalledWithCreateLaunchTemplateInput.Pop().(*ec2.CreateLaunchTemplateInput)
+				userData, _ := base64.StdEncoding.DecodeString(*input.LaunchTemplateData.UserData)
+				Expect(string(userData)).To(ContainSubstring("--max-pods=110"))
+				Expect(string(userData)).To(ContainSubstring("--use-max-pods false"))
+			})
+		})
+	})
+	Context("Defaulting", func() {
+		It("should default subnetSelector", func() {
+			provisioner.SetDefaults(ctx)
+			constraints, err := v1alpha1.Deserialize(&provisioner.Spec.Constraints)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(constraints.SubnetSelector).To(Equal(map[string]string{"kubernetes.io/cluster/test-cluster": "*"}))
+		})
+		It("should default securityGroupSelector", func() {
+			provisioner.SetDefaults(ctx)
+			constraints, err := v1alpha1.Deserialize(&provisioner.Spec.Constraints)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(constraints.SecurityGroupSelector).To(Equal(map[string]string{"kubernetes.io/cluster/test-cluster": "*"}))
+		})
+		It("should not default securityGroupSelector with a launch template", func() {
+			provider.LaunchTemplate = aws.String("test-launch-template")
+			provider.InstanceProfile = ""
+			provisioner := ProvisionerWithProvider(provisioner, provider)
+			provisioner.SetDefaults(ctx)
+			constraints, err := v1alpha1.Deserialize(&provisioner.Spec.Constraints)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(constraints.SecurityGroupSelector).To(BeNil())
+		})
+		It("should not default the provider of provisioners with a provider ref", func() {
+			provisioner.Spec.Provider = nil
+			provisioner.Spec.ProviderRef = &v1alpha5.ProviderRef{Kind: "AWSNodeTemplate", Name: "test-node-template"}
+			provisioner.SetDefaults(ctx)
+			Expect(provisioner.Spec.Provider).To(BeNil())
+			Expect(provisioner.Spec.Requirements.CapacityTypes().UnsortedList()).To(ConsistOf(v1alpha1.CapacityTypeOnDemand))
+		})
+		It("should default requirements", func() {
+			provisioner.SetDefaults(ctx)
+			Expect(provisioner.Spec.Requirements.CapacityTypes().UnsortedList()).To(ConsistOf(v1alpha1.CapacityTypeOnDemand))
+			Expect(provisioner.Spec.Requirements.Architectures().UnsortedList()).To(ConsistOf(v1alpha5.ArchitectureAmd64))
+		})
+	})
+	Context("Validation", func() {
+		It("should not panic if provider undefined", func() {
+			provisioner.Spec.Provider = nil
+			Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+		})
+		It("should not allow unknown provider fields", func() {
+			provisioner.Spec.Provider = &runtime.RawExtension{Raw: []byte(`{"instanceProfile": "test-instance-profile", "subnetSelectr": {"foo": "bar"}}`)}
+			err := provisioner.Validate(ctx)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("subnetSelectr"))
+		})
+
+		Context("ProviderRef", func() {
+			BeforeEach(func() {
+				provisioner.Spec.Provider = nil
+			})
+			It("should succeed for AWSNodeTemplates", func() {
+				provisioner.Spec.ProviderRef = &v1alpha5.ProviderRef{Kind: "AWSNodeTemplate", Name: "test-node-template"}
+				Expect(provisioner.Validate(ctx)).To(Succeed())
+				provisioner.Spec.ProviderRef.APIVersion = v1alpha1.SchemeGroupVersion.String()
+				Expect(provisioner.Validate(ctx)).To(Succeed())
+			})
+			It("should fail for other kinds", func() {
+				provisioner.Spec.ProviderRef = &v1alpha5.ProviderRef{Kind: "StaticMachine", Name: "test-node-template"}
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			})
+			It("should fail for other api versions", func() {
+				provisioner.Spec.ProviderRef = &v1alpha5.ProviderRef{APIVersion: "karpenter.k8s.aws/v1", Kind: "AWSNodeTemplate", Name: "test-node-template"}
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			})
+		})
+
+		Context("SubnetSelector", func() {
+			It("should not allow empty string keys or values", func() {
+				for key, value := range map[string]string{
+					"":    "value",
+					"key": "",
+				} {
+					provider.SubnetSelector = map[string]string{key: value}
+					provisioner := ProvisionerWithProvider(provisioner, provider)
+					Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+				}
+			})
+			It("should allow subnets to be selected by Outpost ARNs and zones", func() {
+				provider.SubnetSelector = map[string]string{
+					v1alpha1.SubnetOutpostARNsSelectorKey: "arn:aws:outposts:test-region:123456789012:outpost/op-0123456789abcdef0",
+					v1alpha1.SubnetZonesSelectorKey:       "test-zone-1a, test-zone-1a-lz-1a",
+				}
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).To(Succeed())
+			})
+			It("should not allow Outposts to be selected by anything but ARN", func() {
+				provider.SubnetSelector = map[string]string{v1alpha1.SubnetOutpostARNsSelectorKey: "op-0123456789abcdef0"}
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			})
+			It("should not allow empty zones", func() {
+				provider.SubnetSelector = map[string]string{v1alpha1.SubnetZonesSelectorKey: "test-zone-1a,"}
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
 			})
 		})
 		Context("SecurityGroupSelector", func() {
@@ -467,11 +2484,574 @@ var _ = Describe("Allocation", func() {
 					"":    "value",
 					"key": "",
 				} {
-					provider.SecurityGroupSelector = map[string]string{key: value}
+					provider.SecurityGroupSelector = map[string]string{key: value}
+					provisioner := ProvisionerWithProvider(provisioner, provider)
+					Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+				}
+			})
+			It("should allow lists of IDs and names", func() {
+				provider.SecurityGroupSelector = map[string]string{
+					v1alpha1.SecurityGroupIDsSelectorKey:   "sg-0123456789abcdef0, sg-0123456789abcdef1",
+					v1alpha1.SecurityGroupNamesSelectorKey: "my-security-group",
+				}
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).To(Succeed())
+			})
+			It("should not allow empty or invalid IDs and names", func() {
+				for key, value := range map[string]string{
+					v1alpha1.SecurityGroupIDsSelectorKey:   "sg-0123456789abcdef0,,sg-0123456789abcdef1",
+					v1alpha1.SecurityGroupNamesSelectorKey: "my-security-group, ",
+				} {
+					provider.SecurityGroupSelector = map[string]string{key: value}
+					provisioner := ProvisionerWithProvider(provisioner, provider)
+					provisioner.SetDefaults(ctx)
+					Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+				}
+				provider.SecurityGroupSelector = map[string]string{v1alpha1.SecurityGroupIDsSelectorKey: "my-security-group"}
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			})
+		})
+		Context("LaunchTemplate", func() {
+			It("should allow a launch template without an instance profile", func() {
+				provider.LaunchTemplate = aws.String("test-launch-template")
+				provider.LaunchTemplateVersion = aws.String("$Latest")
+				provider.InstanceProfile = ""
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).To(Succeed())
+			})
+			It("should require an instance profile without a launch template", func() {
+				provider.InstanceProfile = ""
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			})
+			It("should allow a default instance profile instead", func() {
+				provider.InstanceProfile = ""
+				opts := injection.GetOptions(ctx)
+				opts.AWSDefaultInstanceProfile = "test-default-instance-profile"
+				ctx := injection.WithOptions(ctx, opts)
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).To(Succeed())
+			})
+			It("should not allow an instance profile that does not exist", func() {
+				fakeIAMAPI.WantErr = awserr.New(iam.ErrCodeNoSuchEntityException, "instance profile not found", nil)
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			})
+			It("should allow an instance profile if IAM is unavailable", func() {
+				fakeIAMAPI.WantErr = fmt.Errorf("service unavailable")
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).To(Succeed())
+			})
+			It("should not allow a launch template with an instance profile", func() {
+				provider.LaunchTemplate = aws.String("test-launch-template")
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			})
+			It("should not allow a launch template with a security group selector", func() {
+				provider.LaunchTemplate = aws.String("test-launch-template")
+				provider.InstanceProfile = ""
+				provider.SecurityGroupSelector = map[string]string{"key": "value"}
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			})
+			It("should not allow a launch template version without a launch template", func() {
+				provider.LaunchTemplateVersion = aws.String("$Latest")
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			})
+			It("should not allow an empty launch template", func() {
+				provider.LaunchTemplate = aws.String("")
+				provider.InstanceProfile = ""
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			})
+		})
+		Context("AMIs", func() {
+			It("should allow supported AMI families", func() {
+				for _, amiFamily := range v1alpha1.SupportedAMIFamilies {
+					provider.AMIFamily = aws.String(amiFamily)
+					provisioner := ProvisionerWithProvider(provisioner, provider)
+					provisioner.SetDefaults(ctx)
+					Expect(provisioner.Validate(ctx)).To(Succeed())
+				}
+			})
+			It("should not allow unsupported AMI families", func() {
+				provider.AMIFamily = aws.String("Windows")
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			})
+			It("should not allow empty string keys or values in the AMI selector", func() {
+				for key, value := range map[string]string{
+					"":    "value",
+					"key": "",
+				} {
+					provider.AMISelector = map[string]string{key: value}
+					provisioner := ProvisionerWithProvider(provisioner, provider)
+					provisioner.SetDefaults(ctx)
+					Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+				}
+			})
+			It("should not allow an AMI family or selector with a launch template", func() {
+				for _, provider := range []*v1alpha1.AWS{
+					{LaunchTemplate: aws.String("test-launch-template"), AMIFamily: aws.String(v1alpha1.AMIFamilyBottlerocket)},
+					{LaunchTemplate: aws.String("test-launch-template"), AMISelector: map[string]string{"Name": "test-ami"}},
+				} {
+					provisioner := ProvisionerWithProvider(provisioner, provider)
+					provisioner.SetDefaults(ctx)
+					Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+				}
+			})
+		})
+		Context("BlockDeviceMappings", func() {
+			var blockDeviceMapping *v1alpha1.BlockDeviceMapping
+			BeforeEach(func() {
+				blockDeviceMapping = &v1alpha1.BlockDeviceMapping{
+					DeviceName: aws.String("/dev/xvda"),
+					EBS:        &v1alpha1.BlockDevice{VolumeSize: resource.NewScaledQuantity(100, resource.Giga)},
+				}
+				provider.BlockDeviceMappings = []*v1alpha1.BlockDeviceMapping{blockDeviceMapping}
+			})
+			It("should allow block device mappings", func() {
+				blockDeviceMapping.EBS.VolumeType = aws.String(ec2.VolumeTypeGp3)
+				blockDeviceMapping.EBS.IOPS = aws.Int64(3000)
+				blockDeviceMapping.EBS.Throughput = aws.Int64(125)
+				blockDeviceMapping.EBS.Encrypted = aws.Bool(true)
+				blockDeviceMapping.EBS.KMSKeyID = aws.String("test-kms-key")
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).To(Succeed())
+			})
+			It("should allow a snapshot without a volume size", func() {
+				blockDeviceMapping.EBS = &v1alpha1.BlockDevice{SnapshotID: aws.String("test-snapshot")}
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).To(Succeed())
+			})
+			It("should not allow invalid block device mappings", func() {
+				for _, invalid := range []func(*v1alpha1.BlockDeviceMapping){
+					func(b *v1alpha1.BlockDeviceMapping) { b.DeviceName = nil },
+					func(b *v1alpha1.BlockDeviceMapping) { b.EBS = nil },
+					func(b *v1alpha1.BlockDeviceMapping) { b.EBS.VolumeSize = nil },
+					func(b *v1alpha1.BlockDeviceMapping) {
+						b.EBS.VolumeSize = resource.NewScaledQuantity(100, resource.Mega)
+					},
+					func(b *v1alpha1.BlockDeviceMapping) {
+						b.EBS.VolumeSize = resource.NewScaledQuantity(100, resource.Tera)
+					},
+					func(b *v1alpha1.BlockDeviceMapping) { b.EBS.VolumeType = aws.String("gp9") },
+					func(b *v1alpha1.BlockDeviceMapping) { b.EBS.IOPS = aws.Int64(3000) },
+					func(b *v1alpha1.BlockDeviceMapping) {
+						b.EBS.VolumeType = aws.String(ec2.VolumeTypeIo1)
+						b.EBS.Throughput = aws.Int64(125)
+					},
+					func(b *v1alpha1.BlockDeviceMapping) { b.EBS.KMSKeyID = aws.String("test-kms-key") },
+				} {
+					blockDeviceMapping := blockDeviceMapping.DeepCopy()
+					invalid(blockDeviceMapping)
+					provider.BlockDeviceMappings = []*v1alpha1.BlockDeviceMapping{blockDeviceMapping}
+					provisioner := ProvisionerWithProvider(provisioner, provider)
+					provisioner.SetDefaults(ctx)
+					Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+				}
+			})
+			It("should not allow block device mappings with a launch template", func() {
+				provider.LaunchTemplate = aws.String("test-launch-template")
+				provider.InstanceProfile = ""
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			})
+		})
+		Context("ContainerRegistryMirrors", func() {
+			It("should allow registry mirrors", func() {
+				provider.ContainerRegistryMirrors = map[string]string{"docker.io": "https://mirror.example.com"}
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).To(Succeed())
+			})
+			It("should not allow mirrors that aren't URLs", func() {
+				provider.ContainerRegistryMirrors = map[string]string{"docker.io": "mirror.example.com"}
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			})
+			It("should not allow registries with paths", func() {
+				provider.ContainerRegistryMirrors = map[string]string{"docker.io/library": "https://mirror.example.com"}
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			})
+			It("should not allow registry mirrors for Windows", func() {
+				provider.ContainerRegistryMirrors = map[string]string{"docker.io": "https://mirror.example.com"}
+				provider.AMIFamily = aws.String(v1alpha1.AMIFamilyWindows2019)
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			})
+			It("should not allow registry mirrors with a launch template", func() {
+				provider.ContainerRegistryMirrors = map[string]string{"docker.io": "https://mirror.example.com"}
+				provider.LaunchTemplate = aws.String("test-launch-template")
+				provider.InstanceProfile = ""
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			})
+		})
+		Context("AssociatePublicIPAddress", func() {
+			It("should allow disabling public IP addresses", func() {
+				provider.AssociatePublicIPAddress = aws.Bool(false)
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).To(Succeed())
+			})
+			It("should not allow public IP addresses to be configured with a launch template", func() {
+				provider.AssociatePublicIPAddress = aws.Bool(false)
+				provider.LaunchTemplate = aws.String("test-launch-template")
+				provider.InstanceProfile = ""
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			})
+		})
+		Context("KMSKeyID", func() {
+			It("should allow a KMS key", func() {
+				provider.KMSKeyID = aws.String("test-kms-key")
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).To(Succeed())
+			})
+			It("should not allow an empty KMS key", func() {
+				provider.KMSKeyID = aws.String("")
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			})
+			It("should not allow a KMS key with a launch template", func() {
+				provider.KMSKeyID = aws.String("test-kms-key")
+				provider.LaunchTemplate = aws.String("test-launch-template")
+				provider.InstanceProfile = ""
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			})
+		})
+		Context("InstanceStorePolicy", func() {
+			It("should allow the RAID0 instance store policy", func() {
+				provider.InstanceStorePolicy = aws.String(v1alpha1.InstanceStorePolicyRAID0)
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).To(Succeed())
+			})
+			It("should not allow unsupported instance store policies", func() {
+				provider.InstanceStorePolicy = aws.String("RAID1")
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			})
+			It("should not allow an instance store policy for Bottlerocket", func() {
+				provider.InstanceStorePolicy = aws.String(v1alpha1.InstanceStorePolicyRAID0)
+				provider.AMIFamily = aws.String(v1alpha1.AMIFamilyBottlerocket)
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			})
+			It("should not allow an instance store policy with a launch template", func() {
+				provider.InstanceStorePolicy = aws.String(v1alpha1.InstanceStorePolicyRAID0)
+				provider.LaunchTemplate = aws.String("test-launch-template")
+				provider.InstanceProfile = ""
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			})
+		})
+		Context("EmptyInstancePolicy", func() {
+			It("should allow supported empty instance policies", func() {
+				for _, policy := range v1alpha1.SupportedEmptyInstancePolicies {
+					provider.EmptyInstancePolicy = aws.String(policy)
+					provisioner := ProvisionerWithProvider(provisioner, provider)
+					provisioner.SetDefaults(ctx)
+					Expect(provisioner.Validate(ctx)).To(Succeed())
+				}
+			})
+			It("should not allow unsupported empty instance policies", func() {
+				provider.EmptyInstancePolicy = aws.String("Pause")
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			})
+			It("should allow stopping empty instances with a launch template", func() {
+				provider.EmptyInstancePolicy = aws.String(v1alpha1.EmptyInstancePolicyStop)
+				provider.LaunchTemplate = aws.String("test-launch-template")
+				provider.InstanceProfile = ""
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).To(Succeed())
+			})
+			It("should not allow hibernating empty instances with a launch template", func() {
+				provider.EmptyInstancePolicy = aws.String(v1alpha1.EmptyInstancePolicyHibernate)
+				provider.LaunchTemplate = aws.String("test-launch-template")
+				provider.InstanceProfile = ""
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			})
+		})
+		Context("RebalanceRecommendationPolicy", func() {
+			It("should allow supported rebalance recommendation policies", func() {
+				for _, policy := range v1alpha1.SupportedRebalanceRecommendationPolicies {
+					provider.RebalanceRecommendationPolicy = aws.String(policy)
+					provisioner := ProvisionerWithProvider(provisioner, provider)
+					provisioner.SetDefaults(ctx)
+					Expect(provisioner.Validate(ctx)).To(Succeed())
+				}
+			})
+			It("should not allow unsupported rebalance recommendation policies", func() {
+				provider.RebalanceRecommendationPolicy = aws.String("Terminate")
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			})
+		})
+		Context("Region", func() {
+			It("should allow a region", func() {
+				provider.Region = aws.String("test-region-2")
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).To(Succeed())
+			})
+			It("should not allow an empty region", func() {
+				provider.Region = aws.String("")
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			})
+		})
+		Context("AssumeRoleARN", func() {
+			It("should allow the ARN of a role", func() {
+				provider.AssumeRoleARN = aws.String("arn:aws:iam::123456789012:role/KarpenterTarget")
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).To(Succeed())
+			})
+			It("should not allow ARNs of other resources", func() {
+				for _, roleARN := range []string{
+					"KarpenterTarget",
+					"arn:aws:iam::123456789012:user/KarpenterTarget",
+					"arn:aws:sts::123456789012:assumed-role/KarpenterTarget/session",
+				} {
+					provider.AssumeRoleARN = aws.String(roleARN)
+					provisioner := ProvisionerWithProvider(provisioner, provider)
+					provisioner.SetDefaults(ctx)
+					Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+				}
+			})
+		})
+		Context("MetadataOptions", func() {
+			It("should allow metadata options", func() {
+				provider.MetadataOptions = &v1alpha1.MetadataOptions{
+					HTTPEndpoint:            aws.String(ec2.LaunchTemplateInstanceMetadataEndpointStateEnabled),
+					HTTPPutResponseHopLimit: aws.Int64(2),
+					HTTPTokens:              aws.String(ec2.LaunchTemplateHttpTokensStateRequired),
+				}
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).To(Succeed())
+			})
+			It("should not allow invalid metadata options", func() {
+				for _, metadataOptions := range []*v1alpha1.MetadataOptions{
+					{HTTPEndpoint: aws.String("on")},
+					{HTTPPutResponseHopLimit: aws.Int64(0)},
+					{HTTPPutResponseHopLimit: aws.Int64(65)},
+					{HTTPTokens: aws.String("always")},
+				} {
+					provider.MetadataOptions = metadataOptions
+					provisioner := ProvisionerWithProvider(provisioner, provider)
+					provisioner.SetDefaults(ctx)
+					Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+				}
+			})
+			It("should not allow metadata options with a launch template", func() {
+				provider.LaunchTemplate = aws.String("test-launch-template")
+				provider.InstanceProfile = ""
+				provider.MetadataOptions = &v1alpha1.MetadataOptions{HTTPTokens: aws.String(ec2.LaunchTemplateHttpTokensStateRequired)}
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			})
+		})
+		Context("PlacementGroup", func() {
+			It("should allow a placement group", func() {
+				provider.PlacementGroup = aws.String("test-placement-group")
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).To(Succeed())
+			})
+			It("should not allow an empty placement group", func() {
+				provider.PlacementGroup = aws.String("")
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			})
+			It("should not allow a placement group with a launch template", func() {
+				provider.LaunchTemplate = aws.String("test-launch-template")
+				provider.InstanceProfile = ""
+				provider.PlacementGroup = aws.String("test-placement-group")
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			})
+		})
+		Context("Tenancy", func() {
+			It("should allow supported tenancies", func() {
+				for _, tenancy := range ec2.Tenancy_Values() {
+					provider.Tenancy = aws.String(tenancy)
+					provisioner := ProvisionerWithProvider(provisioner, provider)
+					provisioner.SetDefaults(ctx)
+					Expect(provisioner.Validate(ctx)).To(Succeed())
+				}
+			})
+			It("should allow a host resource group with host tenancy", func() {
+				provider.Tenancy = aws.String(ec2.TenancyHost)
+				provider.HostResourceGroupARN = aws.String("arn:aws:resource-groups:us-west-2:111122223333:group/test-hosts")
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).To(Succeed())
+			})
+			It("should not allow unsupported tenancies", func() {
+				provider.Tenancy = aws.String("shared")
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			})
+			It("should not allow a host resource group without host tenancy", func() {
+				for _, tenancy := range []*string{nil, aws.String(ec2.TenancyDedicated)} {
+					provider.Tenancy = tenancy
+					provider.HostResourceGroupARN = aws.String("arn:aws:resource-groups:us-west-2:111122223333:group/test-hosts")
+					provisioner := ProvisionerWithProvider(provisioner, provider)
+					provisioner.SetDefaults(ctx)
+					Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+				}
+			})
+			It("should not allow tenancy with a launch template", func() {
+				provider.LaunchTemplate = aws.String("test-launch-template")
+				provider.InstanceProfile = ""
+				provider.Tenancy = aws.String(ec2.TenancyDedicated)
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			})
+		})
+		Context("CapacityReservationSpecification", func() {
+			It("should allow capacity reservation specifications", func() {
+				for _, specification := range []*v1alpha1.CapacityReservationSpecification{
+					{CapacityReservationPreference: aws.String(ec2.CapacityReservationPreferenceOpen)},
+					{CapacityReservationPreference: aws.String(ec2.CapacityReservationPreferenceNone)},
+					{CapacityReservationResourceGroupARN: aws.String("arn:aws:resource-groups:us-west-2:111122223333:group/test-group")},
+				} {
+					provider.CapacityReservationSpecification = specification
+					provisioner := ProvisionerWithProvider(provisioner, provider)
+					provisioner.SetDefaults(ctx)
+					Expect(provisioner.Validate(ctx)).To(Succeed())
+				}
+			})
+			It("should not allow invalid capacity reservation specifications", func() {
+				for _, specification := range []*v1alpha1.CapacityReservationSpecification{
+					{CapacityReservationPreference: aws.String("targeted")},
+					{CapacityReservationResourceGroupARN: aws.String("test-group")},
+					{
+						CapacityReservationPreference:       aws.String(ec2.CapacityReservationPreferenceOpen),
+						CapacityReservationResourceGroupARN: aws.String("arn:aws:resource-groups:us-west-2:111122223333:group/test-group"),
+					},
+				} {
+					provider.CapacityReservationSpecification = specification
 					provisioner := ProvisionerWithProvider(provisioner, provider)
+					provisioner.SetDefaults(ctx)
 					Expect(provisioner.Validate(ctx)).ToNot(Succeed())
 				}
 			})
+			It("should not allow a capacity reservation specification with a launch template", func() {
+				provider.LaunchTemplate = aws.String("test-launch-template")
+				provider.InstanceProfile = ""
+				provider.CapacityReservationSpecification = &v1alpha1.CapacityReservationSpecification{CapacityReservationPreference: aws.String(ec2.CapacityReservationPreferenceNone)}
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			})
+		})
+		Context("SpotAllocationStrategy", func() {
+			It("should allow supported spot allocation strategies", func() {
+				for _, strategy := range v1alpha1.SupportedSpotAllocationStrategies {
+					provider.SpotAllocationStrategy = aws.String(strategy)
+					provisioner := ProvisionerWithProvider(provisioner, provider)
+					provisioner.SetDefaults(ctx)
+					Expect(provisioner.Validate(ctx)).To(Succeed())
+				}
+			})
+			It("should not allow unsupported spot allocation strategies", func() {
+				provider.SpotAllocationStrategy = aws.String("most-expensive")
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			})
+		})
+		Context("UserData", func() {
+			It("should allow user data with a user data mode", func() {
+				for _, mode := range v1alpha1.SupportedUserDataModes {
+					provider.UserData = aws.String("#!/bin/bash")
+					provider.UserDataMode = aws.String(mode)
+					provisioner := ProvisionerWithProvider(provisioner, provider)
+					provisioner.SetDefaults(ctx)
+					Expect(provisioner.Validate(ctx)).To(Succeed())
+				}
+			})
+			It("should not allow unsupported user data modes", func() {
+				provider.UserData = aws.String("#!/bin/bash")
+				provider.UserDataMode = aws.String("Replace")
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			})
+			It("should not allow a user data mode without user data", func() {
+				provider.UserDataMode = aws.String(v1alpha1.UserDataModeAppend)
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			})
+			It("should not allow user data with a launch template", func() {
+				provider.LaunchTemplate = aws.String("test-launch-template")
+				provider.InstanceProfile = ""
+				provider.UserData = aws.String("#!/bin/bash")
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			})
+			It("should allow Bottlerocket settings with arrays of tables", func() {
+				provider.AMIFamily = aws.String(v1alpha1.AMIFamilyBottlerocket)
+				provider.UserData = aws.String("[[settings.container-registry.mirrors]]\nregistry = \"docker.io\"\nendpoint = [\"https://mirror.example.com\"]")
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).To(Succeed())
+			})
+			It("should not allow Bottlerocket settings that can't be parsed", func() {
+				provider.AMIFamily = aws.String(v1alpha1.AMIFamilyBottlerocket)
+				provider.UserData = aws.String("[settings.kubernetes]\nnode-labels = [")
+				provisioner := ProvisionerWithProvider(provisioner, provider)
+				provisioner.SetDefaults(ctx)
+				Expect(provisioner.Validate(ctx)).ToNot(Succeed())
+			})
 		})
 		Context("Labels", func() {
 			It("should not allow unrecognized labels with the aws label prefix", func() {
@@ -488,6 +3068,379 @@ var _ = Describe("Allocation", func() {
 	})
 })
 
+var _ = Describe("Interruption", func() {
+	var interruptionController *InterruptionController
+	var node *v1.Node
+
+	BeforeEach(func() {
+		fakeSQSAPI.Reset()
+		unavailableOfferingsCache.Flush()
+		interruptionController = NewInterruptionController(env.Client, fakeSQSAPI, cloudProvider.instanceTypeProvider, "test-queue")
+		node = test.Node(test.NodeOptions{
+			Labels: map[string]string{
+				v1alpha5.ProvisionerNameLabelKey: v1alpha5.DefaultProvisioner.Name,
+				v1.LabelInstanceTypeStable:       "m5.large",
+				v1.LabelTopologyZone:             "test-zone-1a",
+				v1alpha5.LabelCapacityType:       v1alpha1.CapacityTypeSpot,
+			},
+			Finalizers: []string{v1alpha5.TerminationFinalizer},
+			ProviderID: "aws:///test-zone-1a/i-0123456789abcdef0",
+		})
+		ExpectCreated(ctx, env.Client, node)
+	})
+
+	AfterEach(func() {
+		ExpectCleanedUp(ctx, env.Client)
+	})
+
+	It("should delete nodes that receive a spot interruption warning", func() {
+		fakeSQSAPI.ReceiveMessageOutput = &sqs.ReceiveMessageOutput{Messages: []*sqs.Message{
+			InterruptionMessage(SpotInterruptionWarning, "i-0123456789abcdef0"),
+		}}
+		Expect(interruptionController.receive(ctx)).To(Succeed())
+		ExpectInterrupted(interruptionController, node)
+		Expect(ExpectNodeExists(ctx, env.Client, node.Name).DeletionTimestamp.IsZero()).To(BeFalse())
+		Expect(fakeSQSAPI.CalledWithDeleteMessageInput.Cardinality()).To(Equal(1))
+	})
+	It("should avoid the spot offerings of nodes that receive a spot interruption warning", func() {
+		fakeSQSAPI.ReceiveMessageOutput = &sqs.ReceiveMessageOutput{Messages: []*sqs.Message{
+			InterruptionMessage(SpotInterruptionWarning, "i-0123456789abcdef0"),
+		}}
+		Expect(interruptionController.receive(ctx)).To(Succeed())
+		_, ok := unavailableOfferingsCache.Get(UnavailableOfferingsCacheKey(v1alpha1.CapacityTypeSpot, "m5.large", "test-zone-1a"))
+		Expect(ok).To(BeTrue())
+	})
+	It("should delete nodes that receive a rebalance recommendation", func() {
+		fakeSQSAPI.ReceiveMessageOutput = &sqs.ReceiveMessageOutput{Messages: []*sqs.Message{
+			InterruptionMessage(RebalanceRecommendation, "i-0123456789abcdef0"),
+		}}
+		Expect(interruptionController.receive(ctx)).To(Succeed())
+		ExpectInterrupted(interruptionController, node)
+		Expect(ExpectNodeExists(ctx, env.Client, node.Name).DeletionTimestamp.IsZero()).To(BeFalse())
+		Expect(fakeSQSAPI.CalledWithDeleteMessageInput.Cardinality()).To(Equal(1))
+		Expect(unavailableOfferingsCache.ItemCount()).To(BeZero())
+	})
+	It("should annotate nodes to be replaced that receive a rebalance recommendation if their policy is Replace", func() {
+		node.Annotations = map[string]string{v1alpha1.RebalanceRecommendationPolicyAnnotationKey: v1alpha1.RebalanceRecommendationPolicyReplace}
+		ExpectApplied(ctx, env.Client, node)
+		fakeSQSAPI.ReceiveMessageOutput = &sqs.ReceiveMessageOutput{Messages: []*sqs.Message{
+			InterruptionMessage(RebalanceRecommendation, "i-0123456789abcdef0"),
+		}}
+		Expect(interruptionController.receive(ctx)).To(Succeed())
+		Expect(interruptionController.interrupted).ToNot(Receive())
+		node = ExpectNodeExists(ctx, env.Client, node.Name)
+		Expect(node.DeletionTimestamp.IsZero()).To(BeTrue())
+		Expect(node.Annotations).To(HaveKey(v1alpha5.ReplaceAnnotationKey))
+		Expect(fakeSQSAPI.CalledWithDeleteMessageInput.Cardinality()).To(Equal(1))
+		_, ok := unavailableOfferingsCache.Get(UnavailableOfferingsCacheKey(v1alpha1.CapacityTypeSpot, "m5.large", "test-zone-1a"))
+		Expect(ok).To(BeTrue())
+	})
+	It("should ignore rebalance recommendations of nodes whose policy is Ignore", func() {
+		node.Annotations = map[string]string{v1alpha1.RebalanceRecommendationPolicyAnnotationKey: v1alpha1.RebalanceRecommendationPolicyIgnore}
+		ExpectApplied(ctx, env.Client, node)
+		fakeSQSAPI.ReceiveMessageOutput = &sqs.ReceiveMessageOutput{Messages: []*sqs.Message{
+			InterruptionMessage(RebalanceRecommendation, "i-0123456789abcdef0"),
+		}}
+		Expect(interruptionController.receive(ctx)).To(Succeed())
+		Expect(interruptionController.interrupted).ToNot(Receive())
+		node = ExpectNodeExists(ctx, env.Client, node.Name)
+		Expect(node.DeletionTimestamp.IsZero()).To(BeTrue())
+		Expect(node.Annotations).ToNot(HaveKey(v1alpha5.ReplaceAnnotationKey))
+		Expect(fakeSQSAPI.CalledWithDeleteMessageInput.Cardinality()).To(Equal(1))
+	})
+	It("should ignore instances that are not nodes launched by Karpenter", func() {
+		fakeSQSAPI.ReceiveMessageOutput = &sqs.ReceiveMessageOutput{Messages: []*sqs.Message{
+			InterruptionMessage(SpotInterruptionWarning, "i-00000000000000000"),
+		}}
+		Expect(interruptionController.receive(ctx)).To(Succeed())
+		Expect(interruptionController.interrupted).ToNot(Receive())
+		Expect(fakeSQSAPI.CalledWithDeleteMessageInput.Cardinality()).To(Equal(1))
+		Expect(unavailableOfferingsCache.ItemCount()).To(BeZero())
+	})
+	It("should ignore messages that are not about interruptions", func() {
+		fakeSQSAPI.ReceiveMessageOutput = &sqs.ReceiveMessageOutput{Messages: []*sqs.Message{
+			InterruptionMessage("EC2 Instance State-change Notification", "i-0123456789abcdef0"),
+			{MessageId: aws.String("malformed"), ReceiptHandle: aws.String("malformed"), Body: aws.String("{")},
+		}}
+		Expect(interruptionController.receive(ctx)).To(Succeed())
+		Expect(interruptionController.interrupted).ToNot(Receive())
+		Expect(fakeSQSAPI.CalledWithDeleteMessageInput.Cardinality()).To(Equal(2))
+	})
+	It("should fail if messages cannot be received", func() {
+		fakeSQSAPI.WantErr = fmt.Errorf("failed")
+		Expect(interruptionController.receive(ctx)).ToNot(Succeed())
+	})
+	It("should receive messages from the queue of the settings", func() {
+		Expect(interruptionController.receive(injection.WithSettings(ctx, settings.NewStore(settings.Settings{AWSInterruptionQueueName: "test-settings-queue"})))).To(Succeed())
+		Expect(fakeSQSAPI.CalledWithReceiveMessageInput.Contains("https://sqs.test-region.amazonaws.com/123456789012/test-settings-queue")).To(BeTrue())
+		Expect(interruptionController.receive(ctx)).To(Succeed())
+		Expect(fakeSQSAPI.CalledWithReceiveMessageInput.Contains("https://sqs.test-region.amazonaws.com/123456789012/test-queue")).To(BeTrue())
+	})
+	It("should not receive messages if no queue is configured", func() {
+		interruptionController = NewInterruptionController(env.Client, fakeSQSAPI, cloudProvider.instanceTypeProvider, "")
+		Expect(interruptionController.receive(ctx)).To(Succeed())
+		Expect(fakeSQSAPI.CalledWithReceiveMessageInput.Cardinality()).To(BeZero())
+	})
+	It("should not receive messages if the feature is disabled", func() {
+		opts := injection.GetOptions(ctx)
+		opts.FeatureGates = "SpotInterruption=false"
+		Expect(interruptionController.receive(injection.WithOptions(ctx, opts))).To(Succeed())
+		Expect(fakeSQSAPI.CalledWithReceiveMessageInput.Cardinality()).To(BeZero())
+	})
+	It("should receive messages if the settings enable the feature that the options disable", func() {
+		opts := injection.GetOptions(ctx)
+		opts.FeatureGates = "SpotInterruption=false"
+		Expect(interruptionController.receive(injection.WithSettings(injection.WithOptions(ctx, opts), settings.NewStore(settings.Settings{
+			FeatureGates: featuregates.FeatureGates{featuregates.SpotInterruption: true},
+		})))).To(Succeed())
+		Expect(fakeSQSAPI.CalledWithReceiveMessageInput.Cardinality()).To(Equal(1))
+	})
+})
+
+var _ = Describe("Garbage Collection", func() {
+	var garbageCollectionController *GarbageCollectionController
+
+	BeforeEach(func() {
+		fakeEC2API.Reset()
+		garbageCollectionController = NewGarbageCollectionController(env.Client, cloudProvider.targetProviders(env.Client))
+	})
+
+	AfterEach(func() {
+		ExpectCleanedUp(ctx, env.Client)
+	})
+
+	It("should terminate instances without nodes", func() {
+		fakeEC2API.Instances.Store("i-0123456789abcdef0", &ec2.Instance{
+			InstanceId: aws.String("i-0123456789abcdef0"),
+			LaunchTime: aws.Time(time.Now().Add(-GarbageCollectionGracePeriod)),
+		})
+		ExpectReconcileSucceeded(ctx, garbageCollectionController, client.ObjectKey{})
+		_, ok := fakeEC2API.Instances.Load("i-0123456789abcdef0")
+		Expect(ok).To(BeFalse())
+	})
+	It("should terminate instances without nodes in other accounts and regions", func() {
+		otherEC2API := &fake.EC2API{}
+		cloudProvider.targets = &targets{
+			session: session.Must(session.NewSession(&aws.Config{Region: aws.String("test-region")})),
+			providers: map[target]*providers{
+				{}:                        cloudProvider.providers,
+				{region: "test-region-2"}: {instanceProvider: &InstanceProvider{ec2api: otherEC2API}},
+			},
+		}
+		defer func() { cloudProvider.targets = nil }()
+		otherEC2API.Instances.Store("i-0123456789abcdef0", &ec2.Instance{
+			InstanceId: aws.String("i-0123456789abcdef0"),
+			LaunchTime: aws.Time(time.Now().Add(-GarbageCollectionGracePeriod)),
+		})
+		ExpectReconcileSucceeded(ctx, garbageCollectionController, client.ObjectKey{})
+		_, ok := otherEC2API.Instances.Load("i-0123456789abcdef0")
+		Expect(ok).To(BeFalse())
+	})
+	It("should not terminate instances with nodes", func() {
+		fakeEC2API.Instances.Store("i-0123456789abcdef0", &ec2.Instance{
+			InstanceId: aws.String("i-0123456789abcdef0"),
+			LaunchTime: aws.Time(time.Now().Add(-GarbageCollectionGracePeriod)),
+		})
+		ExpectCreated(ctx, env.Client, test.Node(test.NodeOptions{ProviderID: "aws:///test-zone-1a/i-0123456789abcdef0"}))
+		ExpectReconcileSucceeded(ctx, garbageCollectionController, client.ObjectKey{})
+		_, ok := fakeEC2API.Instances.Load("i-0123456789abcdef0")
+		Expect(ok).To(BeTrue())
+	})
+	It("should not terminate instances that were launched recently", func() {
+		fakeEC2API.Instances.Store("i-0123456789abcdef0", &ec2.Instance{
+			InstanceId: aws.String("i-0123456789abcdef0"),
+			LaunchTime: aws.Time(time.Now()),
+		})
+		ExpectReconcileSucceeded(ctx, garbageCollectionController, client.ObjectKey{})
+		_, ok := fakeEC2API.Instances.Load("i-0123456789abcdef0")
+		Expect(ok).To(BeTrue())
+	})
+	It("should not terminate the stopped instances of empty nodes", func() {
+		fakeEC2API.Instances.Store("i-0123456789abcdef0", &ec2.Instance{
+			InstanceId: aws.String("i-0123456789abcdef0"),
+			LaunchTime: aws.Time(time.Now().Add(-GarbageCollectionGracePeriod)),
+			State:      &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameStopped)},
+			Tags:       []*ec2.Tag{{Key: aws.String(v1alpha1.StoppedTimestampTagKey), Value: aws.String(time.Now().UTC().Format(time.RFC3339))}},
+		})
+		ExpectReconcileSucceeded(ctx, garbageCollectionController, client.ObjectKey{})
+		_, ok := fakeEC2API.Instances.Load("i-0123456789abcdef0")
+		Expect(ok).To(BeTrue())
+	})
+	It("should terminate the stopped instances of empty nodes once they expire", func() {
+		fakeEC2API.Instances.Store("i-0123456789abcdef0", &ec2.Instance{
+			InstanceId: aws.String("i-0123456789abcdef0"),
+			LaunchTime: aws.Time(time.Now().Add(-StoppedInstanceTTL)),
+			State:      &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameStopped)},
+			Tags:       []*ec2.Tag{{Key: aws.String(v1alpha1.StoppedTimestampTagKey), Value: aws.String(time.Now().Add(-StoppedInstanceTTL).UTC().Format(time.RFC3339))}},
+		})
+		ExpectReconcileSucceeded(ctx, garbageCollectionController, client.ObjectKey{})
+		_, ok := fakeEC2API.Instances.Load("i-0123456789abcdef0")
+		Expect(ok).To(BeFalse())
+	})
+})
+
+var _ = Describe("Node Template Controller", func() {
+	var nodeTemplate *v1alpha1.AWSNodeTemplate
+
+	BeforeEach(func() {
+		fakeEC2API.Reset()
+		fakeSSMAPI.Reset()
+		amiCache.Flush()
+		subnetCache.Flush()
+		securityGroupCache.Flush()
+		nodeTemplate = &v1alpha1.AWSNodeTemplate{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-node-template"},
+			Spec: v1alpha1.AWSNodeTemplateSpec{AWS: v1alpha1.AWS{
+				InstanceProfile:       "test-instance-profile",
+				AMISelector:           map[string]string{"*": "*"},
+				SubnetSelector:        map[string]string{"*": "*"},
+				SecurityGroupSelector: map[string]string{"*": "*"},
+			}},
+		}
+	})
+
+	AfterEach(func() {
+		ExpectDeleted(ctx, env.Client, nodeTemplate)
+	})
+
+	It("should resolve the template's subnets, security groups and amis", func() {
+		ExpectApplied(ctx, env.Client, nodeTemplate)
+		ExpectReconcileSucceeded(ctx, nodeTemplateController, client.ObjectKeyFromObject(nodeTemplate))
+		Expect(env.Client.Get(ctx, client.ObjectKeyFromObject(nodeTemplate), nodeTemplate)).To(Succeed())
+		Expect(nodeTemplate.Status.Subnets).To(Equal([]v1alpha1.Subnet{
+			{ID: "test-subnet-1", Zone: "test-zone-1a"},
+			{ID: "test-subnet-2", Zone: "test-zone-1b"},
+			{ID: "test-subnet-3", Zone: "test-zone-1c"},
+		}))
+		Expect(nodeTemplate.Status.SecurityGroups).To(Equal([]v1alpha1.SecurityGroup{
+			{ID: "test-security-group-1", Name: "test-security-group-1"},
+			{ID: "test-security-group-2", Name: "test-security-group-2"},
+			{ID: "test-security-group-3", Name: "test-security-group-3"},
+		}))
+		Expect(nodeTemplate.Status.AMIs).To(Equal([]v1alpha1.AMI{
+			{ID: "test-ami-amd64", Architecture: v1alpha5.ArchitectureAmd64},
+			{ID: "test-ami-arm64", Architecture: v1alpha5.ArchitectureArm64},
+		}))
+		Expect(nodeTemplate.StatusConditions().IsHappy()).To(BeTrue())
+	})
+	It("should only resolve the subnets of templates with a launch template", func() {
+		nodeTemplate.Spec.LaunchTemplate = aws.String("test-launch-template")
+		nodeTemplate.Spec.SecurityGroupSelector = nil
+		ExpectApplied(ctx, env.Client, nodeTemplate)
+		ExpectReconcileSucceeded(ctx, nodeTemplateController, client.ObjectKeyFromObject(nodeTemplate))
+		Expect(env.Client.Get(ctx, client.ObjectKeyFromObject(nodeTemplate), nodeTemplate)).To(Succeed())
+		Expect(nodeTemplate.Status.Subnets).To(HaveLen(3))
+		Expect(nodeTemplate.Status.SecurityGroups).To(BeEmpty())
+		Expect(nodeTemplate.Status.AMIs).To(BeEmpty())
+	})
+	It("should not be ready if no subnets match", func() {
+		nodeTemplate.Spec.SubnetSelector = map[string]string{"Name": "test-subnet-missing"}
+		fakeEC2API.DescribeSubnetsOutput = &ec2.DescribeSubnetsOutput{}
+		ExpectApplied(ctx, env.Client, nodeTemplate)
+		ExpectReconcileFailed(ctx, nodeTemplateController, client.ObjectKeyFromObject(nodeTemplate))
+		Expect(env.Client.Get(ctx, client.ObjectKeyFromObject(nodeTemplate), nodeTemplate)).To(Succeed())
+		Expect(nodeTemplate.Status.Subnets).To(BeEmpty())
+		Expect(nodeTemplate.StatusConditions().GetCondition(apis.ConditionReady).IsFalse()).To(BeTrue())
+	})
+})
+
+var _ = Describe("Throttling", func() {
+	var ec2api *ec2.EC2
+	var throttled bool
+
+	BeforeEach(func() {
+		throttled = false
+		sess := withMetrics(withThrottling(session.Must(session.NewSession(&aws.Config{
+			Region:      aws.String("test-region"),
+			Credentials: credentials.NewStaticCredentials("test-access-key", "test-secret-key", ""),
+			MaxRetries:  aws.Int(0),
+		}))))
+		// Respond to requests without sending them
+		sess.Handlers.Send.Clear()
+		sess.Handlers.Send.PushBack(func(req *request.Request) {
+			req.HTTPResponse = &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: http.NoBody}
+			if throttled {
+				req.HTTPResponse.StatusCode = http.StatusServiceUnavailable
+				req.Error = awserr.New("RequestLimitExceeded", "Request limit exceeded.", nil)
+			}
+		})
+		sess.Handlers.UnmarshalMeta.Clear()
+		sess.Handlers.ValidateResponse.Clear()
+		sess.Handlers.Unmarshal.Clear()
+		sess.Handlers.UnmarshalError.Clear()
+		ec2api = ec2.New(sess)
+		rateLimitGaugeVec.Reset()
+		errorsCounterVec.Reset()
+		durationHistogramVec.Reset()
+	})
+
+	It("should halve the rate limit when requests are throttled", func() {
+		throttled = true
+		_, err := ec2api.DescribeInstancesWithContext(ctx, &ec2.DescribeInstancesInput{})
+		Expect(request.IsErrorThrottle(err)).To(BeTrue())
+		Expect(testutil.ToFloat64(rateLimitGaugeVec.WithLabelValues(ec2.ServiceName, "DescribeInstances"))).To(BeNumerically("==", APIQPS/2))
+		_, err = ec2api.DescribeInstancesWithContext(ctx, &ec2.DescribeInstancesInput{})
+		Expect(request.IsErrorThrottle(err)).To(BeTrue())
+		Expect(testutil.ToFloat64(rateLimitGaugeVec.WithLabelValues(ec2.ServiceName, "DescribeInstances"))).To(BeNumerically("==", APIQPS/4))
+	})
+	It("should increase the rate limit when requests succeed", func() {
+		throttled = true
+		_, err := ec2api.DescribeInstancesWithContext(ctx, &ec2.DescribeInstancesInput{})
+		Expect(err).To(HaveOccurred())
+		throttled = false
+		_, err = ec2api.DescribeInstancesWithContext(ctx, &ec2.DescribeInstancesInput{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(testutil.ToFloat64(rateLimitGaugeVec.WithLabelValues(ec2.ServiceName, "DescribeInstances"))).To(BeNumerically("~", APIQPS/2+APIQPSIncrement))
+	})
+	It("should not exceed the maximum rate limit", func() {
+		_, err := ec2api.DescribeInstancesWithContext(ctx, &ec2.DescribeInstancesInput{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(testutil.ToFloat64(rateLimitGaugeVec.WithLabelValues(ec2.ServiceName, "DescribeInstances"))).To(BeNumerically("==", APIQPS))
+	})
+	It("should limit the rate of each API separately", func() {
+		throttled = true
+		_, err := ec2api.DescribeInstancesWithContext(ctx, &ec2.DescribeInstancesInput{})
+		Expect(err).To(HaveOccurred())
+		throttled = false
+		_, err = ec2api.DescribeSubnetsWithContext(ctx, &ec2.DescribeSubnetsInput{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(testutil.ToFloat64(rateLimitGaugeVec.WithLabelValues(ec2.ServiceName, "DescribeInstances"))).To(BeNumerically("==", APIQPS/2))
+		Expect(testutil.ToFloat64(rateLimitGaugeVec.WithLabelValues(ec2.ServiceName, "DescribeSubnets"))).To(BeNumerically("==", APIQPS))
+	})
+	It("should publish errors and durations by API", func() {
+		throttled = true
+		_, err := ec2api.DescribeInstancesWithContext(ctx, &ec2.DescribeInstancesInput{})
+		Expect(err).To(HaveOccurred())
+		Expect(testutil.ToFloat64(errorsCounterVec.WithLabelValues(ec2.ServiceName, "DescribeInstances", "RequestLimitExceeded"))).To(BeNumerically("==", 1))
+		Expect(testutil.CollectAndCount(durationHistogramVec)).To(Equal(1))
+	})
+})
+
+var _ = Describe("Endpoints", func() {
+	It("should resolve overridden endpoints to their URLs", func() {
+		resolved, err := endpointResolver(map[string]string{"ssm": "https://vpce-123.ssm.us-west-2.vpce.amazonaws.com"}).EndpointFor("ssm", "us-west-2")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resolved.URL).To(Equal("https://vpce-123.ssm.us-west-2.vpce.amazonaws.com"))
+		Expect(resolved.SigningRegion).To(Equal("us-west-2"))
+	})
+	It("should resolve other endpoints to the partition's defaults", func() {
+		resolved, err := endpointResolver(map[string]string{"ssm": "https://vpce-123.ssm.us-west-2.vpce.amazonaws.com"}).EndpointFor("ec2", "cn-north-1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resolved.URL).To(Equal("https://ec2.cn-north-1.amazonaws.com.cn"))
+	})
+	It("should keep the signing region of global services", func() {
+		resolved, err := endpointResolver(map[string]string{"iam": "https://iam.example.com"}).EndpointFor("iam", "us-west-2")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resolved.URL).To(Equal("https://iam.example.com"))
+		Expect(resolved.SigningRegion).To(Equal("us-east-1"))
+	})
+	It("should resolve the price list API in the China regions", func() {
+		Expect(PricingRegion("cn-north-1")).To(Equal("cn-northwest-1"))
+		Expect(PricingRegion("ap-southeast-1")).To(Equal("ap-south-1"))
+		Expect(PricingRegion("us-west-2")).To(Equal("us-east-1"))
+	})
+})
+
 func ProvisionerWithProvider(provisioner *v1alpha5.Provisioner, provider *v1alpha1.AWS) *v1alpha5.Provisioner {
 	raw, err := json.Marshal(provider)
 	Expect(err).ToNot(HaveOccurred())
@@ -505,3 +3458,33 @@ func InstancesLaunchedFrom(createFleetInputIter <-chan interface{}) int {
 	}
 	return instancesLaunched
 }
+
+func InterruptionMessage(detailType string, instanceID string) *sqs.Message {
+	return &sqs.Message{
+		MessageId:     aws.String(randomdata.Alphanumeric(16)),
+		ReceiptHandle: aws.String(randomdata.Alphanumeric(16)),
+		Body: aws.String(fmt.Sprintf(`{"version":"0","detail-type":%q,"source":"aws.ec2","resources":["arn:aws:ec2:test-region:123456789012:instance/%s"],"detail":{"instance-id":%q,"instance-action":"terminate"}}`,
+			detailType, instanceID, instanceID)),
+	}
+}
+
+// LaunchedSubnets returns the subnets of the overrides that the fleets were
+// created with
+func LaunchedSubnets(createFleetInputIter <-chan interface{}) []string {
+	subnets := []string{}
+	for input := range createFleetInputIter {
+		for _, launchTemplateConfig := range input.(*ec2.CreateFleetInput).LaunchTemplateConfigs {
+			for _, override := range launchTemplateConfig.Overrides {
+				subnets = append(subnets, aws.StringValue(override.SubnetId))
+			}
+		}
+	}
+	return subnets
+}
+
+func ExpectInterrupted(interruptionController *InterruptionController, node *v1.Node) {
+	interrupted := event.GenericEvent{}
+	Expect(interruptionController.interrupted).To(Receive(&interrupted))
+	Expect(interrupted.Object.GetName()).To(Equal(node.Name))
+	ExpectReconcileSucceeded(ctx, interruptionController, client.ObjectKeyFromObject(node))
+}
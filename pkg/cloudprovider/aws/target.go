@@ -0,0 +1,133 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/outposts"
+	"github.com/aws/aws-sdk-go/service/pricing"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/karpenter/pkg/cloudprovider/aws/apis/v1alpha1"
+	"github.com/patrickmn/go-cache"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// providers discover and launch resources in a single account and region.
+type providers struct {
+	instanceTypeProvider    *InstanceTypeProvider
+	subnetProvider          *SubnetProvider
+	securityGroupProvider   *SecurityGroupProvider
+	amiProvider             *AMIProvider
+	instanceProfileProvider *InstanceProfileProvider
+	instanceProvider        *InstanceProvider
+}
+
+func newProviders(sess *session.Session, clientSet *kubernetes.Clientset) *providers {
+	ec2api := ec2.New(sess)
+	subnetProvider := NewSubnetProvider(ec2api)
+	pricingProvider := NewPricingProvider(ec2api, pricing.New(sess, &aws.Config{Region: aws.String(PricingRegion(*sess.Config.Region))}), *sess.Config.Region)
+	instanceTypeProvider := NewInstanceTypeProvider(ec2api, outposts.New(sess), subnetProvider, pricingProvider)
+	securityGroupProvider := NewSecurityGroupProvider(ec2api)
+	amiProvider := NewAMIProvider(ssm.New(sess), ec2api, clientSet)
+	return &providers{
+		instanceTypeProvider:    instanceTypeProvider,
+		subnetProvider:          subnetProvider,
+		securityGroupProvider:   securityGroupProvider,
+		amiProvider:             amiProvider,
+		instanceProfileProvider: NewInstanceProfileProvider(iam.New(sess)),
+		instanceProvider: &InstanceProvider{ec2api, instanceTypeProvider, subnetProvider,
+			NewLaunchTemplateProvider(ec2api, amiProvider, securityGroupProvider),
+			cache.New(FailedClientTokensCacheTTL, CacheCleanupInterval),
+		},
+	}
+}
+
+// target is the account and region that a provisioner launches instances in.
+// The zero value is Karpenter's own account and region.
+type target struct {
+	region  string
+	roleARN string
+}
+
+func targetFor(provider *v1alpha1.AWS) target {
+	return target{region: aws.StringValue(provider.Region), roleARN: aws.StringValue(provider.AssumeRoleARN)}
+}
+
+func targetForNode(node *v1.Node) target {
+	return target{region: node.Annotations[v1alpha1.RegionAnnotationKey], roleARN: node.Annotations[v1alpha1.AssumeRoleARNAnnotationKey]}
+}
+
+// annotations identify the target of the instances launched for a node, so
+// that they are terminated in the same account and region.
+func (t target) annotations() map[string]string {
+	annotations := map[string]string{}
+	if t.region != "" {
+		annotations[v1alpha1.RegionAnnotationKey] = t.region
+	}
+	if t.roleARN != "" {
+		annotations[v1alpha1.AssumeRoleARNAnnotationKey] = t.roleARN
+	}
+	return annotations
+}
+
+// targets caches the providers of each account and region that provisioners
+// launch instances in, so that their caches are shared between provisioners.
+type targets struct {
+	mu        sync.Mutex
+	session   *session.Session
+	clientSet *kubernetes.Clientset
+	providers map[target]*providers
+}
+
+// get returns the providers of the target, constructing them on first use.
+// Credentials of assumed roles are refreshed by the SDK before they expire.
+func (t *targets) get(key target) *providers {
+	if key.region == aws.StringValue(t.session.Config.Region) {
+		key.region = ""
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if p, ok := t.providers[key]; ok {
+		return p
+	}
+	config := &aws.Config{}
+	if key.region != "" {
+		config.Region = aws.String(key.region)
+	}
+	if key.roleARN != "" {
+		config.Credentials = stscreds.NewCredentials(t.session, key.roleARN)
+	}
+	p := newProviders(t.session.Copy(config), t.clientSet)
+	t.providers[key] = p
+	return p
+}
+
+// keys returns the targets whose providers have been constructed
+func (t *targets) keys() []target {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	keys := []target{}
+	for key := range t.providers {
+		keys = append(keys, key)
+	}
+	return keys
+}
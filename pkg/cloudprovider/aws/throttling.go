@@ -0,0 +1,162 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/karpenter/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// APIQPS limits the number of requests per second to each AWS API, which
+	// is the rate that EC2 refills its request tokens for most APIs.
+	// https://docs.aws.amazon.com/AWSEC2/latest/APIReference/throttling.html#throttling-limits
+	APIQPS = 20
+	// APIBurst limits the additional burst requests to each AWS API.
+	APIBurst = 100
+	// MinAPIQPS is the lowest rate that requests to an AWS API are limited to
+	// after it throttles them.
+	MinAPIQPS = 0.5
+	// APIQPSIncrement is how much the rate of requests to an AWS API recovers
+	// after each request that isn't throttled.
+	APIQPSIncrement = 0.1
+)
+
+// rateLimiter limits the rate of requests to each AWS API. The rate is halved
+// whenever the API throttles a request and recovers gradually as requests
+// succeed, so that Karpenter backs off when the account's API limits are shared
+// with other controllers rather than retrying into further throttling.
+type rateLimiter struct {
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+// withThrottling rate limits the requests of every client created from the
+// session. Throttled requests are also retried with exponential backoff by the
+// session's retryer.
+func withThrottling(sess *session.Session) *session.Session {
+	limiter := &rateLimiter{limiters: map[string]*rate.Limiter{}}
+	sess.Handlers.Sign.PushFrontNamed(request.NamedHandler{Name: "karpenter.RateLimitHandler", Fn: limiter.wait})
+	sess.Handlers.CompleteAttempt.PushBackNamed(request.NamedHandler{Name: "karpenter.AdaptRateLimitHandler", Fn: limiter.adapt})
+	return sess
+}
+
+// wait blocks until the API's rate limit allows the request to be sent, or the
+// request's context is done.
+func (r *rateLimiter) wait(req *request.Request) {
+	if err := r.limiter(req).Wait(req.Context()); err != nil {
+		req.Error = awserr.New(request.CanceledErrorCode, fmt.Sprintf("waiting for %s rate limit", apiName(req)), err)
+	}
+}
+
+// adapt halves the API's rate limit if the request was throttled, or increases
+// it if the request succeeded.
+func (r *rateLimiter) adapt(req *request.Request) {
+	limiter := r.limiter(req)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if request.IsErrorThrottle(req.Error) {
+		limiter.SetLimit(rate.Limit(math.Max(float64(limiter.Limit())/2, MinAPIQPS)))
+	} else if req.Error == nil {
+		limiter.SetLimit(rate.Limit(math.Min(float64(limiter.Limit())+APIQPSIncrement, APIQPS)))
+	}
+	rateLimitGaugeVec.WithLabelValues(req.ClientInfo.ServiceName, req.Operation.Name).Set(float64(limiter.Limit()))
+}
+
+func (r *rateLimiter) limiter(req *request.Request) *rate.Limiter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	limiter, ok := r.limiters[apiName(req)]
+	if !ok {
+		limiter = rate.NewLimiter(APIQPS, APIBurst)
+		r.limiters[apiName(req)] = limiter
+	}
+	return limiter
+}
+
+func apiName(req *request.Request) string {
+	return fmt.Sprintf("%s:%s", req.ClientInfo.ServiceName, req.Operation.Name)
+}
+
+// withMetrics publishes the latency and errors of the requests of every client
+// created from the session.
+func withMetrics(sess *session.Session) *session.Session {
+	sess.Handlers.CompleteAttempt.PushBackNamed(request.NamedHandler{Name: "karpenter.ErrorMetricsHandler", Fn: func(req *request.Request) {
+		if req.Error == nil {
+			return
+		}
+		code := "Unknown"
+		if err, ok := req.Error.(awserr.Error); ok {
+			code = err.Code()
+		}
+		errorsCounterVec.WithLabelValues(req.ClientInfo.ServiceName, req.Operation.Name, code).Inc()
+	}})
+	// The duration of each request includes waiting for its rate limit and retries
+	sess.Handlers.Complete.PushBackNamed(request.NamedHandler{Name: "karpenter.DurationMetricsHandler", Fn: func(req *request.Request) {
+		durationHistogramVec.WithLabelValues(req.ClientInfo.ServiceName, req.Operation.Name).Observe(time.Since(req.Time).Seconds())
+	}})
+	return sess
+}
+
+const (
+	metricLabelService   = "service"
+	metricLabelOperation = "operation"
+	metricLabelCode      = "code"
+)
+
+var (
+	durationHistogramVec = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: "cloudprovider",
+			Name:      "aws_api_duration_seconds",
+			Help:      "Duration of AWS API requests, including rate limiting and retries.",
+			Buckets:   metrics.DurationBuckets(),
+		},
+		[]string{metricLabelService, metricLabelOperation},
+	)
+	errorsCounterVec = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: "cloudprovider",
+			Name:      "aws_api_errors_total",
+			Help:      "Number of AWS API request attempts that failed, including those that were throttled or retried.",
+		},
+		[]string{metricLabelService, metricLabelOperation, metricLabelCode},
+	)
+	rateLimitGaugeVec = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: "cloudprovider",
+			Name:      "aws_api_rate_limit",
+			Help:      "Number of requests per second that are allowed to each AWS API, which is reduced when the API throttles requests.",
+		},
+		[]string{metricLabelService, metricLabelOperation},
+	)
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(durationHistogramVec, errorsCounterVec, rateLimitGaugeVec)
+}
@@ -0,0 +1,179 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/aws/karpenter/pkg/cloudprovider/aws/apis/v1alpha1"
+)
+
+// mimeBoundary is constant so that equivalent user data hashes the same
+const mimeBoundary = "//"
+
+type mimePart struct {
+	header textproto.MIMEHeader
+	body   string
+}
+
+// newMIMEPart returns a part of the content type
+func newMIMEPart(contentType string, body string) mimePart {
+	return mimePart{header: textproto.MIMEHeader{"Content-Type": []string{contentType}}, body: body}
+}
+
+// mergeMIME returns a MIME multipart document that runs the user's user data
+// before or after the bootstrap script, depending on the mode. The user data
+// may itself be a MIME multipart document, in which case its parts are kept.
+func mergeMIME(userData string, bootstrap string, mode string) (string, error) {
+	parts, err := mimeParts(userData)
+	if err != nil {
+		return "", err
+	}
+	bootstrapPart := newMIMEPart(`text/x-shellscript; charset="us-ascii"`, bootstrap)
+	if mode == v1alpha1.UserDataModeAppend {
+		parts = append([]mimePart{bootstrapPart}, parts...)
+	} else {
+		parts = append(parts, bootstrapPart)
+	}
+	var buffer bytes.Buffer
+	buffer.WriteString(fmt.Sprintf("MIME-Version: 1.0\nContent-Type: multipart/mixed; boundary=%q\n\n", mimeBoundary))
+	writer := multipart.NewWriter(&buffer)
+	if err := writer.SetBoundary(mimeBoundary); err != nil {
+		return "", err
+	}
+	for _, part := range parts {
+		w, err := writer.CreatePart(part.header)
+		if err != nil {
+			return "", err
+		}
+		if _, err := io.WriteString(w, part.body); err != nil {
+			return "", err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+	return buffer.String(), nil
+}
+
+// mimeParts returns the parts of a MIME multipart document, or the user data
+// as a single part if it is a shell script or cloud-config. Parts are kept as
+// they were encoded, with all of their headers, e.g. Content-Transfer-Encoding.
+func mimeParts(userData string) ([]mimePart, error) {
+	if !strings.HasPrefix(strings.ToLower(strings.TrimSpace(userData)), "mime-version:") {
+		contentType := `text/x-shellscript; charset="us-ascii"`
+		if strings.HasPrefix(userData, "#cloud-config") {
+			contentType = `text/cloud-config; charset="us-ascii"`
+		}
+		return []mimePart{newMIMEPart(contentType, userData)}, nil
+	}
+	message, err := mail.ReadMessage(strings.NewReader(strings.TrimSpace(userData)))
+	if err != nil {
+		return nil, fmt.Errorf("reading mime message, %w", err)
+	}
+	mediaType, params, err := mime.ParseMediaType(message.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, fmt.Errorf("parsing content type, %w", err)
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		return nil, fmt.Errorf("expected a multipart content type, but found %s", mediaType)
+	}
+	parts := []mimePart{}
+	reader := multipart.NewReader(message.Body, params["boundary"])
+	for {
+		part, err := reader.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading mime part, %w", err)
+		}
+		body, err := io.ReadAll(part)
+		if err != nil {
+			return nil, fmt.Errorf("reading mime part, %w", err)
+		}
+		parts = append(parts, mimePart{header: part.Header, body: string(body)})
+	}
+	return parts, nil
+}
+
+// powershellScript returns the body of the user's PowerShell script, without
+// the <powershell> tags that EC2 uses to recognize it
+func powershellScript(userData string) string {
+	script := strings.TrimSpace(userData)
+	script = strings.TrimPrefix(script, "<powershell>")
+	script = strings.TrimSuffix(script, "</powershell>")
+	return strings.TrimSpace(script)
+}
+
+// bottlerocketSettings are Bottlerocket's TOML settings, decoded into nested
+// tables so that Karpenter's settings can be merged into the user's
+type bottlerocketSettings map[string]interface{}
+
+// parseBottlerocketSettings decodes the user's TOML settings
+func parseBottlerocketSettings(userData string) (bottlerocketSettings, error) {
+	settings := map[string]interface{}{}
+	if _, err := toml.Decode(userData, &settings); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// set the key's value in the dotted table, replacing any existing value.
+// Missing tables are created, as are tables that the user set to values.
+func (s bottlerocketSettings) set(table string, key string, value interface{}) {
+	s.table(table)[key] = value
+}
+
+// append the table to the dotted array of tables
+func (s bottlerocketSettings) append(array string, value map[string]interface{}) {
+	dot := strings.LastIndex(array, ".")
+	parent, key := s.table(array[:dot]), array[dot+1:]
+	tables, _ := parent[key].([]map[string]interface{})
+	parent[key] = append(tables, value)
+}
+
+func (s bottlerocketSettings) table(name string) map[string]interface{} {
+	table := map[string]interface{}(s)
+	for _, key := range strings.Split(name, ".") {
+		child, ok := table[key].(map[string]interface{})
+		if !ok {
+			child = map[string]interface{}{}
+			table[key] = child
+		}
+		table = child
+	}
+	return table
+}
+
+// String encodes the settings with sorted keys, so that equivalent settings
+// hash the same
+func (s bottlerocketSettings) String() (string, error) {
+	var document bytes.Buffer
+	encoder := toml.NewEncoder(&document)
+	encoder.Indent = ""
+	if err := encoder.Encode(map[string]interface{}(s)); err != nil {
+		return "", err
+	}
+	return document.String(), nil
+}
@@ -0,0 +1,135 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudprovider
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/utils/resources"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"knative.dev/pkg/apis"
+)
+
+// MaxPodsSource describes how a cloud provider determines the number of pods
+// that nodes of an instance type can run.
+type MaxPodsSource string
+
+const (
+	// MaxPodsSourceInstanceType is used by cloud providers that compute max pods
+	// for each instance type, e.g. from its network interfaces.
+	MaxPodsSourceInstanceType MaxPodsSource = "InstanceType"
+	// MaxPodsSourceKubelet is used by cloud providers whose nodes run the
+	// kubelet's default of 110 pods, unless the machines declare otherwise.
+	MaxPodsSourceKubelet MaxPodsSource = "Kubelet"
+)
+
+// Capabilities describe what a cloud provider is able to launch, so that
+// provisioners and pods that require anything else are rejected at admission
+// and selection rather than failing at launch time.
+type Capabilities struct {
+	// CapacityTypes that the cloud provider can launch, e.g. on-demand and spot,
+	// or nil if any are supported
+	CapacityTypes sets.String
+	// Architectures that the cloud provider can launch, e.g. amd64 and arm64, or
+	// nil if any are supported
+	Architectures sets.String
+	// Accelerators is true if the cloud provider can launch nodes with GPUs or
+	// other accelerators
+	Accelerators bool
+	// MaxPodsSource describes how the pods of each instance type are determined
+	MaxPodsSource MaxPodsSource
+}
+
+// supported returns the supported values of a well known label, or nil if any
+// value is supported
+func (c Capabilities) supported(key string) sets.String {
+	switch key {
+	case v1alpha5.LabelCapacityType:
+		return c.CapacityTypes
+	case v1.LabelArchStable:
+		return c.Architectures
+	}
+	return nil
+}
+
+// Validate that the constraints' requirements and labels only allow values
+// that the cloud provider supports
+func (c Capabilities) Validate(constraints *v1alpha5.Constraints) (errs *apis.FieldError) {
+	for i, requirement := range constraints.Requirements {
+		supported := c.supported(requirement.Key)
+		if supported == nil || requirement.Operator != v1.NodeSelectorOpIn {
+			continue
+		}
+		for j, value := range requirement.Values {
+			if !supported.Has(value) {
+				errs = errs.Also(apis.ErrInvalidArrayValue(unsupported(requirement.Key, value, supported), "values", j).ViaFieldIndex("requirements", i))
+			}
+		}
+	}
+	keys := []string{}
+	for key := range constraints.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if supported := c.supported(key); supported != nil && !supported.Has(constraints.Labels[key]) {
+			errs = errs.Also(apis.ErrInvalidValue(unsupported(key, constraints.Labels[key], supported), fmt.Sprintf("labels[%s]", key)))
+		}
+	}
+	return errs
+}
+
+// ValidatePod returns an error if the pod can't run on any node that the cloud
+// provider can launch, i.e. it requests accelerators that aren't supported, or
+// none of its required node affinity terms allow a supported value.
+func (c Capabilities) ValidatePod(pod *v1.Pod) error {
+	if !c.Accelerators {
+		for resourceName, quantity := range resources.GPULimitsFor(pod) {
+			if !quantity.IsZero() {
+				return fmt.Errorf("%s is not supported by this cloud provider", resourceName)
+			}
+		}
+	}
+	var err error
+	for _, requirements := range v1alpha5.PodRequirementAlternatives(pod) {
+		if err = c.satisfiable(requirements); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// satisfiable returns an error if the requirements only allow unsupported
+// values of a well known label
+func (c Capabilities) satisfiable(requirements v1alpha5.Requirements) error {
+	for _, requirement := range requirements {
+		supported := c.supported(requirement.Key)
+		if supported == nil || requirement.Operator != v1.NodeSelectorOpIn {
+			continue
+		}
+		if !supported.HasAny(requirement.Values...) {
+			return errors.New(unsupported(requirement.Key, requirement.Values, supported))
+		}
+	}
+	return nil
+}
+
+func unsupported(key string, value interface{}, supported sets.String) string {
+	return fmt.Sprintf("%s %v is not supported by this cloud provider, expected one of %v", key, value, supported.List())
+}
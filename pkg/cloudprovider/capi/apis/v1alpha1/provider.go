@@ -0,0 +1,134 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Constraints wraps generic constraints with Cluster API specific parameters
+type Constraints struct {
+	*v1alpha5.Constraints
+	*CAPI
+}
+
+// CAPI contains parameters specific to this cloud provider. Nodes are created
+// by templating Cluster API Machines, so they may be backed by any
+// infrastructure that Cluster API supports.
+// +kubebuilder:object:root=true
+type CAPI struct {
+	// TypeMeta includes version and kind of the extensions, inferred if not provided.
+	// +optional
+	metav1.TypeMeta `json:",inline"`
+	// ClusterName is the name of the Cluster API Cluster that machines are
+	// created for. Defaults to Karpenter's cluster name.
+	// +optional
+	ClusterName string `json:"clusterName,omitempty"`
+	// Namespace of the Cluster and of the templates that machines are created
+	// from. Defaults to "default".
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+	// Version is the Kubernetes version of the machines, e.g. v1.21.5. Some
+	// infrastructure and bootstrap providers require it.
+	// +optional
+	Version *string `json:"version,omitempty"`
+	// BootstrapTemplate is the bootstrap config template that the bootstrap
+	// configs of machines are created from, e.g. a KubeadmConfigTemplate.
+	BootstrapTemplate TemplateReference `json:"bootstrapTemplate"`
+	// MachineTypes are the instance types that the provisioner may launch.
+	// Cluster API templates don't describe the resources of the machines that
+	// they create, so each machine type declares them.
+	MachineTypes []MachineType `json:"machineTypes"`
+}
+
+// MachineType is an instance type whose machines are created from an
+// infrastructure machine template.
+type MachineType struct {
+	// Name of the instance type, which nodes are labeled with.
+	Name string `json:"name"`
+	// InfrastructureTemplate is the infrastructure machine template that the
+	// infrastructure machines of machines are created from, e.g. a
+	// VSphereMachineTemplate.
+	InfrastructureTemplate TemplateReference `json:"infrastructureTemplate"`
+	// Resources are the capacity of nodes of the machine type. cpu and memory
+	// are required, and pods defaults to 110. Pods that request ephemeral
+	// storage or extended resources, such as nvidia.com/gpu, are only packed
+	// onto machine types that declare them.
+	Resources v1.ResourceList `json:"resources"`
+	// Architecture of the machines, e.g. amd64 or arm64. Defaults to amd64.
+	// +optional
+	Architecture string `json:"architecture,omitempty"`
+	// FailureDomains are the Cluster's failure domains that machines may be
+	// created in, which are used as their zones. Machines are created without
+	// a failure domain, in the "default" zone, if none are specified.
+	// +optional
+	FailureDomains []string `json:"failureDomains,omitempty"`
+}
+
+// TemplateReference refers to a Cluster API template in the provider's namespace
+type TemplateReference struct {
+	// APIVersion of the template, e.g. infrastructure.cluster.x-k8s.io/v1beta1
+	APIVersion string `json:"apiVersion"`
+	// Kind of the template, which ends with Template
+	Kind string `json:"kind"`
+	// Name of the template
+	Name string `json:"name"`
+}
+
+// GroupVersionKind returns the template's kind
+func (t TemplateReference) GroupVersionKind() schema.GroupVersionKind {
+	return schema.FromAPIVersionAndKind(t.APIVersion, t.Kind)
+}
+
+// ObjectGroupVersionKind returns the kind of the objects that are created
+// from the template, following Cluster API's convention of removing the
+// Template suffix, e.g. VSphereMachine for VSphereMachineTemplate.
+func (t TemplateReference) ObjectGroupVersionKind() schema.GroupVersionKind {
+	return schema.FromAPIVersionAndKind(t.APIVersion, strings.TrimSuffix(t.Kind, "Template"))
+}
+
+func Deserialize(constraints *v1alpha5.Constraints) (*Constraints, error) {
+	if constraints.Provider == nil {
+		return nil, fmt.Errorf("invariant violated: spec.provider is not defined. Is the defaulting webhook installed?")
+	}
+	capi := &CAPI{}
+	_, gvk, err := Codec.UniversalDeserializer().Decode(constraints.Provider.Raw, nil, capi)
+	if err != nil {
+		return nil, err
+	}
+	if gvk != nil {
+		capi.SetGroupVersionKind(*gvk)
+	}
+	return &Constraints{constraints, capi}, nil
+}
+
+func (c *CAPI) Serialize(constraints *v1alpha5.Constraints) error {
+	if constraints.Provider == nil {
+		return fmt.Errorf("invariant violated: spec.provider is not defined. Is the defaulting webhook installed?")
+	}
+	bytes, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	constraints.Provider.Raw = bytes
+	return nil
+}
@@ -0,0 +1,55 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/utils/injection"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// Default the constraints.
+func (c *Constraints) Default(ctx context.Context) {
+	c.defaultClusterName(injection.GetOptions(ctx).ClusterName)
+	c.defaultNamespace()
+	c.defaultMachineTypes()
+}
+
+func (c *Constraints) defaultClusterName(clusterName string) {
+	if c.ClusterName == "" {
+		c.ClusterName = clusterName
+	}
+}
+
+func (c *Constraints) defaultNamespace() {
+	if c.Namespace == "" {
+		c.Namespace = DefaultNamespace
+	}
+}
+
+func (c *Constraints) defaultMachineTypes() {
+	for i := range c.MachineTypes {
+		machineType := &c.MachineTypes[i]
+		if machineType.Architecture == "" {
+			machineType.Architecture = v1alpha5.ArchitectureAmd64
+		}
+		if _, ok := machineType.Resources[v1.ResourcePods]; !ok && machineType.Resources != nil {
+			machineType.Resources[v1.ResourcePods] = resource.MustParse(DefaultPods)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/utils/functional"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"knative.dev/pkg/apis"
+)
+
+var supportedArchitectures = []string{v1alpha5.ArchitectureAmd64, v1alpha5.ArchitectureArm64}
+
+func (c *CAPI) Validate(ctx context.Context) (errs *apis.FieldError) {
+	return c.validate().ViaField("provider")
+}
+
+func (c *CAPI) validate() (errs *apis.FieldError) {
+	return errs.Also(
+		c.validateClusterName(),
+		c.BootstrapTemplate.validate().ViaField("bootstrapTemplate"),
+		c.validateMachineTypes(),
+	)
+}
+
+func (c *CAPI) validateClusterName() (errs *apis.FieldError) {
+	if c.ClusterName == "" {
+		errs = errs.Also(apis.ErrMissingField("clusterName"))
+	}
+	return errs
+}
+
+func (c *CAPI) validateMachineTypes() (errs *apis.FieldError) {
+	if len(c.MachineTypes) == 0 {
+		return errs.Also(apis.ErrMissingField("machineTypes"))
+	}
+	names := sets.NewString()
+	for i, machineType := range c.MachineTypes {
+		if names.Has(machineType.Name) {
+			errs = errs.Also(apis.ErrGeneric(fmt.Sprintf("duplicate machine type %s", machineType.Name), "name").ViaFieldIndex("machineTypes", i))
+		}
+		names.Insert(machineType.Name)
+		errs = errs.Also(machineType.validate().ViaFieldIndex("machineTypes", i))
+	}
+	return errs
+}
+
+func (m *MachineType) validate() (errs *apis.FieldError) {
+	if m.Name == "" {
+		errs = errs.Also(apis.ErrMissingField("name"))
+	}
+	errs = errs.Also(m.InfrastructureTemplate.validate().ViaField("infrastructureTemplate"))
+	for _, resourceName := range []v1.ResourceName{v1.ResourceCPU, v1.ResourceMemory} {
+		if quantity, ok := m.Resources[resourceName]; !ok || quantity.Sign() <= 0 {
+			errs = errs.Also(apis.ErrMissingField(fmt.Sprintf("resources['%s']", resourceName)))
+		}
+	}
+	for resourceName, quantity := range m.Resources {
+		if quantity.Sign() < 0 {
+			errs = errs.Also(apis.ErrInvalidValue(quantity.String(), fmt.Sprintf("resources['%s']", resourceName)))
+		}
+	}
+	if m.Architecture != "" && !functional.ContainsString(supportedArchitectures, m.Architecture) {
+		errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%s not in %v", m.Architecture, supportedArchitectures), "architecture"))
+	}
+	failureDomains := sets.NewString()
+	for i, failureDomain := range m.FailureDomains {
+		if failureDomain == "" || failureDomains.Has(failureDomain) {
+			errs = errs.Also(apis.ErrInvalidArrayValue(failureDomain, "failureDomains", i))
+		}
+		failureDomains.Insert(failureDomain)
+	}
+	return errs
+}
+
+func (t TemplateReference) validate() (errs *apis.FieldError) {
+	if t.APIVersion == "" {
+		errs = errs.Also(apis.ErrMissingField("apiVersion"))
+	}
+	if t.Name == "" {
+		errs = errs.Also(apis.ErrMissingField("name"))
+	}
+	if !strings.HasSuffix(t.Kind, "Template") || t.Kind == "Template" {
+		errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("%q is not the kind of a template", t.Kind), "kind"))
+	}
+	return errs
+}
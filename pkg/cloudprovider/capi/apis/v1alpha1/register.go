@@ -0,0 +1,54 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+)
+
+var (
+	// CapacityTypeOnDemand is the only capacity type of machines, since
+	// Cluster API has no notion of interruptible capacity.
+	CapacityTypeOnDemand = "on-demand"
+	// DefaultZone is the zone of machine types that don't specify failure
+	// domains. Machines created in it aren't assigned a failure domain.
+	DefaultZone = "default"
+	// DefaultNamespace is the namespace of the Cluster and templates if the
+	// provider doesn't specify one.
+	DefaultNamespace = "default"
+	// DefaultPods is the number of pods that the kubelet allows by default.
+	DefaultPods = "110"
+	// MachineAnnotationKey is set on nodes to the namespaced name of their
+	// Machine, so that the Machine is deleted with the node.
+	MachineAnnotationKey = "karpenter.sh/capi-machine"
+	// ClusterNameLabelKey is set by Cluster API on the objects of a cluster
+	ClusterNameLabelKey = "cluster.x-k8s.io/cluster-name"
+	// MachineGroupVersionKind and ClusterGroupVersionKind are the Cluster API
+	// kinds that Karpenter reads and writes
+	MachineGroupVersionKind = schema.GroupVersionKind{Group: "cluster.x-k8s.io", Version: "v1beta1", Kind: "Machine"}
+	ClusterGroupVersionKind = schema.GroupVersionKind{Group: "cluster.x-k8s.io", Version: "v1beta1", Kind: "Cluster"}
+)
+
+var (
+	Scheme = runtime.NewScheme()
+	Codec  = serializer.NewCodecFactory(Scheme, serializer.EnableStrict)
+)
+
+func init() {
+	Scheme.AddKnownTypes(schema.GroupVersion{Group: v1alpha5.ExtensionsGroup, Version: "v1alpha1"}, &CAPI{})
+}
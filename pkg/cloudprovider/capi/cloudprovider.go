@@ -0,0 +1,138 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package capi
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/cloudprovider/capi/apis/v1alpha1"
+	"github.com/aws/karpenter/pkg/utils/injection"
+	"go.uber.org/multierr"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/dynamic"
+	"knative.dev/pkg/apis"
+	"knative.dev/pkg/logging"
+)
+
+// CloudProvider creates capacity by templating Cluster API Machines, so that
+// Karpenter can drive any infrastructure that Cluster API supports, e.g.
+// vSphere, OpenStack or bare metal.
+type CloudProvider struct {
+	machineProvider *MachineProvider
+}
+
+func NewCloudProvider(ctx context.Context, _ cloudprovider.Options) *CloudProvider {
+	return &CloudProvider{
+		machineProvider: NewMachineProvider(dynamic.NewForConfigOrDie(injection.GetConfig(ctx))),
+	}
+}
+
+// Create a node given the constraints.
+func (c *CloudProvider) Create(ctx context.Context, constraints *v1alpha5.Constraints, instanceTypes []cloudprovider.InstanceType, quantity int, callback func(*v1.Node) error) error {
+	vendorConstraints, err := v1alpha1.Deserialize(constraints)
+	if err != nil {
+		return err
+	}
+	// Nodes are bound for the machines that were created, even if others
+	// failed, and the failures are returned
+	nodes, err := c.machineProvider.Create(ctx, vendorConstraints, instanceTypes, quantity)
+	var errs error
+	if err != nil {
+		errs = fmt.Errorf("creating machines, %w", err)
+	}
+	for _, node := range nodes {
+		errs = multierr.Append(errs, callback(node))
+	}
+	return errs
+}
+
+// GetInstanceTypes returns the provisioner's machine types
+func (c *CloudProvider) GetInstanceTypes(ctx context.Context, provisioner *v1alpha5.Provisioner) ([]cloudprovider.InstanceType, error) {
+	vendorConstraints, err := v1alpha1.Deserialize(&provisioner.Spec.Constraints)
+	if err != nil {
+		return nil, apis.ErrGeneric(err.Error())
+	}
+	instanceTypes := []cloudprovider.InstanceType{}
+	for _, machineType := range vendorConstraints.MachineTypes {
+		instanceTypes = append(instanceTypes, NewInstanceType(machineType))
+	}
+	return instanceTypes, nil
+}
+
+// Delete the node's machine
+func (c *CloudProvider) Delete(ctx context.Context, node *v1.Node) error {
+	return c.machineProvider.Delete(ctx, node)
+}
+
+// Validate the provisioner
+func (c *CloudProvider) Validate(ctx context.Context, constraints *v1alpha5.Constraints) *apis.FieldError {
+	vendorConstraints, err := v1alpha1.Deserialize(constraints)
+	if err != nil {
+		return apis.ErrGeneric(err.Error())
+	}
+	return vendorConstraints.CAPI.Validate(ctx)
+}
+
+// Verify that the cluster and templates referenced by the provisioner exist
+func (c *CloudProvider) Verify(ctx context.Context, constraints *v1alpha5.Constraints) error {
+	vendorConstraints, err := v1alpha1.Deserialize(constraints)
+	if err != nil {
+		return err
+	}
+	return c.machineProvider.Verify(ctx, vendorConstraints.CAPI)
+}
+
+// IsDrifted returns the reason that the node's machine no longer matches the
+// provisioner, or an empty string if it hasn't drifted.
+func (c *CloudProvider) IsDrifted(ctx context.Context, node *v1.Node, provisioner *v1alpha5.Provisioner) (string, error) {
+	vendorConstraints, err := v1alpha1.Deserialize(&provisioner.Spec.Constraints)
+	if err != nil {
+		return "", err
+	}
+	return c.machineProvider.Drifted(ctx, node, vendorConstraints)
+}
+
+// Default the provisioner
+func (c *CloudProvider) Default(ctx context.Context, constraints *v1alpha5.Constraints) {
+	vendorConstraints, err := v1alpha1.Deserialize(constraints)
+	if err != nil {
+		logging.FromContext(ctx).Errorf("Failed to deserialize provider, %s", err.Error())
+		return
+	}
+	vendorConstraints.Default(ctx)
+	if err := vendorConstraints.Serialize(constraints); err != nil {
+		logging.FromContext(ctx).Errorf("Failed to serialize provider, %s", err.Error())
+	}
+}
+
+// Capabilities of Cluster API machines. Machine types may declare
+// accelerators in their resources, but are always on-demand.
+func (c *CloudProvider) Capabilities() cloudprovider.Capabilities {
+	return cloudprovider.Capabilities{
+		CapacityTypes: sets.NewString(v1alpha1.CapacityTypeOnDemand),
+		Architectures: sets.NewString(v1alpha5.ArchitectureAmd64, v1alpha5.ArchitectureArm64),
+		Accelerators:  true,
+		MaxPodsSource: cloudprovider.MaxPodsSourceKubelet,
+	}
+}
+
+// Name returns the CloudProvider implementation name.
+func (c *CloudProvider) Name() string {
+	return "capi"
+}
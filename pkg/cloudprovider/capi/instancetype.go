@@ -0,0 +1,131 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package capi
+
+import (
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/cloudprovider/capi/apis/v1alpha1"
+	"github.com/aws/karpenter/pkg/utils/resources"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// InstanceType is a machine type of the provider
+type InstanceType struct {
+	machineType v1alpha1.MachineType
+}
+
+func NewInstanceType(machineType v1alpha1.MachineType) *InstanceType {
+	return &InstanceType{machineType: machineType}
+}
+
+func (i *InstanceType) Name() string {
+	return i.machineType.Name
+}
+
+// Offerings are on-demand in each of the machine type's failure domains
+func (i *InstanceType) Offerings() cloudprovider.Offerings {
+	offerings := cloudprovider.Offerings{}
+	for _, zone := range i.Zones() {
+		offerings = append(offerings, cloudprovider.Offering{CapacityType: v1alpha1.CapacityTypeOnDemand, Zone: zone})
+	}
+	return offerings
+}
+
+// Zones returns the failure domains of the machine type, or the default zone
+// if it doesn't specify any.
+func (i *InstanceType) Zones() []string {
+	if len(i.machineType.FailureDomains) == 0 {
+		return []string{v1alpha1.DefaultZone}
+	}
+	return i.machineType.FailureDomains
+}
+
+func (i *InstanceType) Architecture() string {
+	return i.machineType.Architecture
+}
+
+func (i *InstanceType) OperatingSystems() sets.String {
+	return sets.NewString(v1alpha5.OperatingSystemLinux)
+}
+
+func (i *InstanceType) CPU() *resource.Quantity {
+	return i.quantity(v1.ResourceCPU)
+}
+
+func (i *InstanceType) Memory() *resource.Quantity {
+	return i.quantity(v1.ResourceMemory)
+}
+
+func (i *InstanceType) EphemeralStorage() *resource.Quantity {
+	return i.quantity(v1.ResourceEphemeralStorage)
+}
+
+func (i *InstanceType) Pods() *resource.Quantity {
+	return i.quantity(v1.ResourcePods)
+}
+
+func (i *InstanceType) NvidiaGPUs() *resource.Quantity {
+	return i.quantity(resources.NvidiaGPU)
+}
+
+func (i *InstanceType) AMDGPUs() *resource.Quantity {
+	return i.quantity(resources.AMDGPU)
+}
+
+func (i *InstanceType) AWSNeurons() *resource.Quantity {
+	return i.quantity(resources.AWSNeuron)
+}
+
+func (i *InstanceType) HabanaGaudis() *resource.Quantity {
+	return i.quantity(resources.HabanaGaudi)
+}
+
+func (i *InstanceType) AWSPodENI() *resource.Quantity {
+	return i.quantity(resources.AWSPodENI)
+}
+
+// ExtendedResources are the machine type's resources that aren't described
+// by the other methods, e.g. vendor.com/foo.
+func (i *InstanceType) ExtendedResources() v1.ResourceList {
+	extended := v1.ResourceList{}
+	for resourceName, quantity := range i.machineType.Resources {
+		switch resourceName {
+		case v1.ResourceCPU, v1.ResourceMemory, v1.ResourceEphemeralStorage, v1.ResourcePods,
+			resources.NvidiaGPU, resources.AMDGPU, resources.AWSNeuron, resources.HabanaGaudi, resources.AWSPodENI:
+			continue
+		}
+		extended[resourceName] = quantity.DeepCopy()
+	}
+	return extended
+}
+
+// Overhead is left to the provisioner's kubelet configuration, since the
+// resources that the kubelet reserves depend on the bootstrap template.
+func (i *InstanceType) Overhead() cloudprovider.InstanceTypeOverhead {
+	return cloudprovider.InstanceTypeOverhead{}
+}
+
+func (i *InstanceType) quantity(resourceName v1.ResourceName) *resource.Quantity {
+	quantity := i.machineType.Resources[resourceName]
+	return &quantity
+}
+
+// Labels are empty, since machine types don't describe their attributes as labels
+func (i *InstanceType) Labels() map[string]string {
+	return nil
+}
@@ -0,0 +1,402 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package capi
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/cloudprovider/capi/apis/v1alpha1"
+	"github.com/aws/karpenter/pkg/utils/functional"
+	"go.uber.org/multierr"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/util/workqueue"
+	"knative.dev/pkg/logging"
+)
+
+const (
+	// ClusterNotFoundReason is reported on the provisioner's Validated condition
+	// when the provider's Cluster doesn't exist
+	ClusterNotFoundReason = "ClusterNotFound"
+	// TemplateNotFoundReason is reported on the provisioner's Validated condition
+	// when a bootstrap or infrastructure template doesn't exist
+	TemplateNotFoundReason = "TemplateNotFound"
+	// Cluster API annotates objects that were created from templates with the
+	// template that they were cloned from
+	clonedFromNameAnnotationKey      = "cluster.x-k8s.io/cloned-from-name"
+	clonedFromGroupKindAnnotationKey = "cluster.x-k8s.io/cloned-from-groupkind"
+)
+
+var (
+	// ProviderIDTimeout is how long Cluster API has to set the provider IDs of
+	// created machines, which it copies from their infrastructure machines
+	// once the infrastructure is created
+	ProviderIDTimeout = 5 * time.Minute
+	// ProviderIDPollInterval is how often machines are checked for their
+	// provider IDs
+	ProviderIDPollInterval = 2 * time.Second
+)
+
+// MachineProvider creates and deletes Cluster API Machines, along with the
+// infrastructure machines and bootstrap configs that they refer to.
+type MachineProvider struct {
+	client dynamic.Interface
+}
+
+func NewMachineProvider(client dynamic.Interface) *MachineProvider {
+	return &MachineProvider{client: client}
+}
+
+// Create machines of the first instance type that has an offering that
+// satisfies the constraints. All machines are created before they're waited
+// on concurrently, under one deadline. Nodes are returned for the machines that
+// were created and given a provider ID, along with an error for the rest.
+// Machines that aren't given a provider ID in time are deleted.
+func (p *MachineProvider) Create(ctx context.Context, constraints *v1alpha1.Constraints, instanceTypes []cloudprovider.InstanceType, quantity int) ([]*v1.Node, error) {
+	machineType, zone, ok := p.offeringFor(constraints, instanceTypes)
+	if !ok {
+		return nil, cloudprovider.NewInsufficientCapacityError(fmt.Errorf("no offerings of %d instance type option(s) satisfy constraints", len(instanceTypes)))
+	}
+	machines := []*unstructured.Unstructured{}
+	var errs error
+	for i := 0; i < quantity; i++ {
+		machine, err := p.create(ctx, constraints, machineType, zone)
+		if err != nil {
+			errs = multierr.Append(errs, err)
+			continue
+		}
+		machines = append(machines, machine)
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, ProviderIDTimeout)
+	defer cancel()
+	providerIDs := make([]string, len(machines))
+	waitErrs := make([]error, len(machines))
+	workqueue.ParallelizeUntil(ctx, len(machines), len(machines), func(i int) {
+		providerIDs[i], waitErrs[i] = p.waitForProviderID(waitCtx, machines[i])
+	})
+	nodes := []*v1.Node{}
+	for i, machine := range machines {
+		if waitErrs[i] != nil {
+			errs = multierr.Combine(errs, waitErrs[i], p.delete(ctx, machine))
+			continue
+		}
+		node := p.nodeFor(machine, machineType, zone)
+		node.Spec.ProviderID = providerIDs[i]
+		nodes = append(nodes, node)
+	}
+	if errs != nil && len(nodes) > 0 {
+		logging.FromContext(ctx).Errorf("Created %d of %d machines, %s", len(nodes), quantity, errs.Error())
+	}
+	return nodes, errs
+}
+
+// offeringFor returns the machine type of the first instance type with a
+// compatible offering, and the offering's zone.
+func (p *MachineProvider) offeringFor(constraints *v1alpha1.Constraints, instanceTypes []cloudprovider.InstanceType) (v1alpha1.MachineType, string, bool) {
+	for _, instanceType := range instanceTypes {
+		for _, machineType := range constraints.MachineTypes {
+			if machineType.Name != instanceType.Name() {
+				continue
+			}
+			offerings := NewInstanceType(machineType).Offerings().Compatible(constraints.Requirements.Zones(), constraints.Requirements.CapacityTypes())
+			if len(offerings) > 0 {
+				return machineType, offerings[0].Zone, true
+			}
+		}
+	}
+	return v1alpha1.MachineType{}, "", false
+}
+
+// create a machine, its infrastructure machine and its bootstrap config,
+// which share a name. The infrastructure machine and bootstrap config are
+// created first, so that the machine's references are never dangling.
+func (p *MachineProvider) create(ctx context.Context, constraints *v1alpha1.Constraints, machineType v1alpha1.MachineType, zone string) (*unstructured.Unstructured, error) {
+	name := fmt.Sprintf("%s-%s", constraints.Labels[v1alpha5.ProvisionerNameLabelKey], utilrand.String(5))
+	infrastructure, err := p.createFromTemplate(ctx, constraints.CAPI, machineType.InfrastructureTemplate, name)
+	if err != nil {
+		return nil, fmt.Errorf("creating infrastructure machine, %w", err)
+	}
+	bootstrap, err := p.createFromTemplate(ctx, constraints.CAPI, constraints.BootstrapTemplate, name)
+	if err != nil {
+		return nil, multierr.Append(fmt.Errorf("creating bootstrap config, %w", err), p.delete(ctx, infrastructure))
+	}
+	machine := p.machine(constraints, name, zone, infrastructure, bootstrap)
+	if _, err := p.resource(machine.GroupVersionKind()).Namespace(machine.GetNamespace()).Create(ctx, machine, metav1.CreateOptions{}); err != nil {
+		return nil, multierr.Combine(fmt.Errorf("creating machine, %w", err), p.delete(ctx, infrastructure), p.delete(ctx, bootstrap))
+	}
+	logging.FromContext(ctx).Infof("Created machine %s/%s of type %s in zone %s", machine.GetNamespace(), name, machineType.Name, zone)
+	return machine, nil
+}
+
+// waitForProviderID returns the machine's provider ID once Cluster API sets
+// it. The node must have the provider ID when it's created, since Cluster API
+// matches nodes to machines by it, and kubelet doesn't set it on nodes that
+// already exist when it registers. It waits until the context is done.
+func (p *MachineProvider) waitForProviderID(ctx context.Context, machine *unstructured.Unstructured) (string, error) {
+	var providerID string
+	if err := wait.PollImmediateUntil(ProviderIDPollInterval, func() (bool, error) {
+		latest, err := p.resource(machine.GroupVersionKind()).Namespace(machine.GetNamespace()).Get(ctx, machine.GetName(), metav1.GetOptions{})
+		if err != nil {
+			return false, fmt.Errorf("getting machine %s, %w", machine.GetName(), err)
+		}
+		providerID, _, _ = unstructured.NestedString(latest.Object, "spec", "providerID")
+		return providerID != "", nil
+	}, ctx.Done()); err != nil {
+		return "", fmt.Errorf("waiting for the provider ID of machine %s, %w", machine.GetName(), err)
+	}
+	return providerID, nil
+}
+
+// createFromTemplate creates an object from the template's spec.template, in
+// the same way that Cluster API clones templates for MachineSets.
+func (p *MachineProvider) createFromTemplate(ctx context.Context, capi *v1alpha1.CAPI, reference v1alpha1.TemplateReference, name string) (*unstructured.Unstructured, error) {
+	template, err := p.resource(reference.GroupVersionKind()).Namespace(capi.Namespace).Get(ctx, reference.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("getting %s %s, %w", reference.Kind, reference.Name, err)
+	}
+	spec, _, err := unstructured.NestedMap(template.Object, "spec", "template", "spec")
+	if err != nil {
+		return nil, fmt.Errorf("getting spec.template.spec of %s %s, %w", reference.Kind, reference.Name, err)
+	}
+	labels, _, _ := unstructured.NestedStringMap(template.Object, "spec", "template", "metadata", "labels")
+	annotations, _, _ := unstructured.NestedStringMap(template.Object, "spec", "template", "metadata", "annotations")
+	object := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+	object.SetGroupVersionKind(reference.ObjectGroupVersionKind())
+	object.SetNamespace(capi.Namespace)
+	object.SetName(name)
+	object.SetLabels(functional.UnionStringMaps(labels, map[string]string{v1alpha1.ClusterNameLabelKey: capi.ClusterName}))
+	object.SetAnnotations(functional.UnionStringMaps(annotations, map[string]string{
+		clonedFromNameAnnotationKey:      reference.Name,
+		clonedFromGroupKindAnnotationKey: reference.GroupVersionKind().GroupKind().String(),
+	}))
+	return p.resource(object.GroupVersionKind()).Namespace(capi.Namespace).Create(ctx, object, metav1.CreateOptions{})
+}
+
+func (p *MachineProvider) machine(constraints *v1alpha1.Constraints, name string, zone string, infrastructure *unstructured.Unstructured, bootstrap *unstructured.Unstructured) *unstructured.Unstructured {
+	spec := map[string]interface{}{
+		"clusterName":       constraints.ClusterName,
+		"infrastructureRef": reference(infrastructure),
+		"bootstrap": map[string]interface{}{
+			"configRef": reference(bootstrap),
+		},
+	}
+	if constraints.Version != nil {
+		spec["version"] = *constraints.Version
+	}
+	if zone != v1alpha1.DefaultZone {
+		spec["failureDomain"] = zone
+	}
+	machine := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+	machine.SetGroupVersionKind(v1alpha1.MachineGroupVersionKind)
+	machine.SetNamespace(constraints.Namespace)
+	machine.SetName(name)
+	machine.SetLabels(map[string]string{
+		v1alpha1.ClusterNameLabelKey:     constraints.ClusterName,
+		v1alpha5.ProvisionerNameLabelKey: constraints.Labels[v1alpha5.ProvisionerNameLabelKey],
+	})
+	return machine
+}
+
+// nodeFor returns the node that the machine's kubelet is expected to register,
+// which is named after the machine.
+func (p *MachineProvider) nodeFor(machine *unstructured.Unstructured, machineType v1alpha1.MachineType, zone string) *v1.Node {
+	instanceType := NewInstanceType(machineType)
+	allocatable := v1.ResourceList{}
+	for resourceName, quantity := range machineType.Resources {
+		allocatable[resourceName] = quantity.DeepCopy()
+	}
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: machine.GetName(),
+			Labels: map[string]string{
+				v1.LabelTopologyZone:       zone,
+				v1.LabelInstanceTypeStable: machineType.Name,
+				v1alpha5.LabelCapacityType: v1alpha1.CapacityTypeOnDemand,
+			},
+			Annotations: map[string]string{
+				v1alpha1.MachineAnnotationKey: fmt.Sprintf("%s/%s", machine.GetNamespace(), machine.GetName()),
+			},
+		},
+		Status: v1.NodeStatus{
+			NodeInfo: v1.NodeSystemInfo{
+				Architecture:    instanceType.Architecture(),
+				OperatingSystem: v1alpha5.OperatingSystemLinux,
+			},
+			Allocatable: allocatable,
+		},
+	}
+}
+
+// Delete the node's machine. Cluster API deletes its infrastructure machine
+// and bootstrap config once the node has drained. Nodes without a machine are
+// ignored, since there is nothing to delete.
+func (p *MachineProvider) Delete(ctx context.Context, node *v1.Node) error {
+	namespacedName, ok := node.Annotations[v1alpha1.MachineAnnotationKey]
+	if !ok {
+		logging.FromContext(ctx).Debugf("Node %s has no machine annotation, skipping", node.Name)
+		return nil
+	}
+	namespace, name, err := machineKey(node)
+	if err != nil {
+		return err
+	}
+	if err := p.resource(v1alpha1.MachineGroupVersionKind).Namespace(namespace).Delete(ctx, name, metav1.DeleteOptions{}); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("deleting machine %s, %w", namespacedName, err)
+	}
+	logging.FromContext(ctx).Infof("Deleted machine %s", namespacedName)
+	return nil
+}
+
+// Drifted returns the reason that the node's machine no longer matches what
+// would be created for the constraints, i.e. it has another Kubernetes version
+// or was cloned from other templates, or an empty string if it doesn't. Nodes
+// without a machine never drift.
+func (p *MachineProvider) Drifted(ctx context.Context, node *v1.Node, constraints *v1alpha1.Constraints) (string, error) {
+	if _, ok := node.Annotations[v1alpha1.MachineAnnotationKey]; !ok {
+		return "", nil
+	}
+	namespace, name, err := machineKey(node)
+	if err != nil {
+		return "", err
+	}
+	machine, err := p.resource(v1alpha1.MachineGroupVersionKind).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return fmt.Sprintf("machine %s/%s no longer exists", namespace, name), nil
+		}
+		return "", fmt.Errorf("getting machine %s/%s, %w", namespace, name, err)
+	}
+	if version, _, _ := unstructured.NestedString(machine.Object, "spec", "version"); constraints.Version != nil && version != *constraints.Version {
+		return fmt.Sprintf("machine has version %s, but the provisioner creates %s", version, *constraints.Version), nil
+	}
+	var machineType *v1alpha1.MachineType
+	for i := range constraints.MachineTypes {
+		if constraints.MachineTypes[i].Name == node.Labels[v1.LabelInstanceTypeStable] {
+			machineType = &constraints.MachineTypes[i]
+		}
+	}
+	if machineType == nil {
+		return fmt.Sprintf("machine type %s is no longer offered by the provisioner", node.Labels[v1.LabelInstanceTypeStable]), nil
+	}
+	for _, drift := range []struct {
+		path     []string
+		template v1alpha1.TemplateReference
+	}{
+		{[]string{"spec", "infrastructureRef"}, machineType.InfrastructureTemplate},
+		{[]string{"spec", "bootstrap", "configRef"}, constraints.BootstrapTemplate},
+	} {
+		reason, err := p.clonedFromDrifted(ctx, machine, drift.path, drift.template)
+		if err != nil || reason != "" {
+			return reason, err
+		}
+	}
+	return "", nil
+}
+
+// clonedFromDrifted compares the template that the machine's referenced object
+// was cloned from with the template that it would be cloned from now. Objects
+// that weren't cloned by Karpenter or Cluster API aren't compared.
+func (p *MachineProvider) clonedFromDrifted(ctx context.Context, machine *unstructured.Unstructured, path []string, template v1alpha1.TemplateReference) (string, error) {
+	ref, ok, _ := unstructured.NestedStringMap(machine.Object, path...)
+	if !ok {
+		return "", nil
+	}
+	gvk := schema.FromAPIVersionAndKind(ref["apiVersion"], ref["kind"])
+	object, err := p.resource(gvk).Namespace(machine.GetNamespace()).Get(ctx, ref["name"], metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("getting %s %s/%s, %w", ref["kind"], machine.GetNamespace(), ref["name"], err)
+	}
+	name, ok := object.GetAnnotations()[clonedFromNameAnnotationKey]
+	if !ok {
+		return "", nil
+	}
+	groupKind := object.GetAnnotations()[clonedFromGroupKindAnnotationKey]
+	if name != template.Name || groupKind != template.GroupVersionKind().GroupKind().String() {
+		return fmt.Sprintf("%s %s was cloned from %s %s, but the provisioner clones %s %s", ref["kind"], ref["name"], groupKind, name, template.GroupVersionKind().GroupKind().String(), template.Name), nil
+	}
+	return "", nil
+}
+
+// Verify that the cluster and templates exist
+func (p *MachineProvider) Verify(ctx context.Context, capi *v1alpha1.CAPI) (errs error) {
+	if _, err := p.resource(v1alpha1.ClusterGroupVersionKind).Namespace(capi.Namespace).Get(ctx, capi.ClusterName, metav1.GetOptions{}); err != nil {
+		errs = multierr.Append(errs, p.verifyError(ClusterNotFoundReason, fmt.Errorf("getting cluster %s/%s, %w", capi.Namespace, capi.ClusterName, err)))
+	}
+	references := []v1alpha1.TemplateReference{capi.BootstrapTemplate}
+	for _, machineType := range capi.MachineTypes {
+		references = append(references, machineType.InfrastructureTemplate)
+	}
+	for _, reference := range references {
+		if _, err := p.resource(reference.GroupVersionKind()).Namespace(capi.Namespace).Get(ctx, reference.Name, metav1.GetOptions{}); err != nil {
+			errs = multierr.Append(errs, p.verifyError(TemplateNotFoundReason, fmt.Errorf("getting %s %s/%s, %w", reference.Kind, capi.Namespace, reference.Name, err)))
+		}
+	}
+	return errs
+}
+
+// verifyError reports objects that don't exist as configuration errors, so
+// that the reason is surfaced on the provisioner
+func (p *MachineProvider) verifyError(reason string, err error) error {
+	if errors.IsNotFound(err) {
+		return cloudprovider.NewConfigurationError(reason, err)
+	}
+	return err
+}
+
+func (p *MachineProvider) delete(ctx context.Context, object *unstructured.Unstructured) error {
+	if err := p.resource(object.GroupVersionKind()).Namespace(object.GetNamespace()).Delete(ctx, object.GetName(), metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("deleting %s %s, %w", object.GetKind(), object.GetName(), err)
+	}
+	return nil
+}
+
+// machineKey returns the namespace and name of the node's machine
+func machineKey(node *v1.Node) (string, string, error) {
+	namespacedName := node.Annotations[v1alpha1.MachineAnnotationKey]
+	parts := strings.SplitN(namespacedName, "/", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("parsing machine %q of node %s", namespacedName, node.Name)
+	}
+	return parts[0], parts[1], nil
+}
+
+func (p *MachineProvider) resource(gvk schema.GroupVersionKind) dynamic.NamespaceableResourceInterface {
+	gvr, _ := meta.UnsafeGuessKindToResource(gvk)
+	return p.client.Resource(gvr)
+}
+
+func reference(object *unstructured.Unstructured) map[string]interface{} {
+	return map[string]interface{}{
+		"apiVersion": object.GetAPIVersion(),
+		"kind":       object.GetKind(),
+		"name":       object.GetName(),
+		"namespace":  object.GetNamespace(),
+	}
+}
@@ -0,0 +1,456 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package capi
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/cloudprovider/capi/apis/v1alpha1"
+	"github.com/aws/karpenter/pkg/utils/injection"
+	"github.com/aws/karpenter/pkg/utils/options"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+	. "knative.dev/pkg/logging/testing"
+	"knative.dev/pkg/ptr"
+)
+
+var ctx context.Context
+var client *dynamicfake.FakeDynamicClient
+var cloudProvider *CloudProvider
+var provider *v1alpha1.CAPI
+var provisioner *v1alpha5.Provisioner
+
+func TestCAPI(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	ctx = injection.WithOptions(ctx, options.Options{ClusterName: "test-cluster"})
+	ProviderIDTimeout = 100 * time.Millisecond
+	ProviderIDPollInterval = 10 * time.Millisecond
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CloudProvider/CAPI")
+}
+
+var _ = BeforeEach(func() {
+	client = dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			{Group: "infrastructure.cluster.x-k8s.io", Version: "v1beta1", Resource: "vspheremachines"}: "VSphereMachineList",
+			{Group: "cluster.x-k8s.io", Version: "v1beta1", Resource: "machines"}:                       "MachineList",
+		},
+		object("cluster.x-k8s.io/v1beta1", "Cluster", "test-cluster", nil),
+		object("bootstrap.cluster.x-k8s.io/v1beta1", "KubeadmConfigTemplate", "test-bootstrap", map[string]interface{}{
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{"joinConfiguration": map[string]interface{}{"nodeRegistration": map[string]interface{}{"name": "{{ ds.meta_data.hostname }}"}}},
+			},
+		}),
+		object("infrastructure.cluster.x-k8s.io/v1beta1", "VSphereMachineTemplate", "test-small", map[string]interface{}{
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{"labels": map[string]interface{}{"test-label": "test-value"}},
+				"spec":     map[string]interface{}{"numCPUs": int64(2), "memoryMiB": int64(4096)},
+			},
+		}),
+		object("infrastructure.cluster.x-k8s.io/v1beta1", "VSphereMachineTemplate", "test-large", map[string]interface{}{
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{"numCPUs": int64(8), "memoryMiB": int64(16384)},
+			},
+		}),
+	)
+	// Cluster API sets the provider ID of machines once their infrastructure is created
+	client.PrependReactor("create", "machines", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		machine := action.(k8stesting.CreateAction).GetObject().(*unstructured.Unstructured)
+		return false, nil, unstructured.SetNestedField(machine.Object, "vsphere://"+machine.GetName(), "spec", "providerID")
+	})
+	cloudProvider = &CloudProvider{machineProvider: NewMachineProvider(client)}
+	provider = &v1alpha1.CAPI{
+		Version:           ptr.String("v1.21.5"),
+		BootstrapTemplate: v1alpha1.TemplateReference{APIVersion: "bootstrap.cluster.x-k8s.io/v1beta1", Kind: "KubeadmConfigTemplate", Name: "test-bootstrap"},
+		MachineTypes: []v1alpha1.MachineType{
+			{
+				Name:                   "small",
+				InfrastructureTemplate: v1alpha1.TemplateReference{APIVersion: "infrastructure.cluster.x-k8s.io/v1beta1", Kind: "VSphereMachineTemplate", Name: "test-small"},
+				Resources:              v1.ResourceList{v1.ResourceCPU: resource.MustParse("2"), v1.ResourceMemory: resource.MustParse("4Gi")},
+			},
+			{
+				Name:                   "large",
+				InfrastructureTemplate: v1alpha1.TemplateReference{APIVersion: "infrastructure.cluster.x-k8s.io/v1beta1", Kind: "VSphereMachineTemplate", Name: "test-large"},
+				Resources: v1.ResourceList{
+					v1.ResourceCPU:              resource.MustParse("8"),
+					v1.ResourceMemory:           resource.MustParse("16Gi"),
+					v1.ResourceEphemeralStorage: resource.MustParse("100Gi"),
+					"nvidia.com/gpu":            resource.MustParse("1"),
+					"vendor.com/foo":            resource.MustParse("2"),
+				},
+				Architecture:   v1alpha5.ArchitectureArm64,
+				FailureDomains: []string{"test-fd-1", "test-fd-2"},
+			},
+		},
+	}
+	provisioner = &v1alpha5.Provisioner{ObjectMeta: metav1.ObjectMeta{Name: "default"}}
+	provisioner.Spec.Labels = map[string]string{v1alpha5.ProvisionerNameLabelKey: "default"}
+})
+
+var _ = Describe("CAPI", func() {
+	Context("Defaulting", func() {
+		It("should default the cluster name, namespace and machine types", func() {
+			constraints := defaulted()
+			Expect(constraints.ClusterName).To(Equal("test-cluster"))
+			Expect(constraints.Namespace).To(Equal("default"))
+			Expect(constraints.MachineTypes[0].Architecture).To(Equal(v1alpha5.ArchitectureAmd64))
+			Expect(constraints.MachineTypes[0].Resources.Pods().String()).To(Equal("110"))
+			Expect(constraints.MachineTypes[1].Architecture).To(Equal(v1alpha5.ArchitectureArm64))
+		})
+		It("should not override the cluster name and namespace", func() {
+			provider.ClusterName = "other-cluster"
+			provider.Namespace = "other-namespace"
+			constraints := defaulted()
+			Expect(constraints.ClusterName).To(Equal("other-cluster"))
+			Expect(constraints.Namespace).To(Equal("other-namespace"))
+		})
+	})
+	Context("Validation", func() {
+		It("should allow a valid provider", func() {
+			Expect(validate()).To(Succeed())
+		})
+		It("should require machine types", func() {
+			provider.MachineTypes = nil
+			Expect(validate()).ToNot(Succeed())
+		})
+		It("should not allow duplicate machine types", func() {
+			provider.MachineTypes[1].Name = provider.MachineTypes[0].Name
+			Expect(validate()).ToNot(Succeed())
+		})
+		It("should require cpu and memory", func() {
+			delete(provider.MachineTypes[0].Resources, v1.ResourceMemory)
+			Expect(validate()).ToNot(Succeed())
+		})
+		It("should not allow negative resources", func() {
+			provider.MachineTypes[0].Resources[v1.ResourceEphemeralStorage] = resource.MustParse("-1Gi")
+			Expect(validate()).ToNot(Succeed())
+		})
+		It("should not allow unsupported architectures", func() {
+			provider.MachineTypes[0].Architecture = "s390x"
+			Expect(validate()).ToNot(Succeed())
+		})
+		It("should not allow duplicate failure domains", func() {
+			provider.MachineTypes[1].FailureDomains = []string{"test-fd-1", "test-fd-1"}
+			Expect(validate()).ToNot(Succeed())
+		})
+		It("should require templates", func() {
+			provider.BootstrapTemplate.Kind = "KubeadmConfig"
+			Expect(validate()).ToNot(Succeed())
+			provider.BootstrapTemplate.Kind = "KubeadmConfigTemplate"
+			provider.MachineTypes[0].InfrastructureTemplate.Name = ""
+			Expect(validate()).ToNot(Succeed())
+		})
+	})
+	Context("GetInstanceTypes", func() {
+		It("should return the machine types with their resources", func() {
+			instanceTypes := instanceTypes()
+			Expect(instanceTypes).To(HaveLen(2))
+			Expect(instanceTypes[1].Name()).To(Equal("large"))
+			Expect(instanceTypes[1].Architecture()).To(Equal(v1alpha5.ArchitectureArm64))
+			Expect(instanceTypes[1].OperatingSystems().List()).To(ConsistOf(v1alpha5.OperatingSystemLinux))
+			Expect(instanceTypes[1].CPU().String()).To(Equal("8"))
+			Expect(instanceTypes[1].Memory().String()).To(Equal("16Gi"))
+			Expect(instanceTypes[1].EphemeralStorage().String()).To(Equal("100Gi"))
+			Expect(instanceTypes[1].Pods().String()).To(Equal("110"))
+			Expect(instanceTypes[1].NvidiaGPUs().String()).To(Equal("1"))
+			Expect(instanceTypes[1].ExtendedResources()).To(HaveLen(1))
+			Expect(instanceTypes[1].ExtendedResources()).To(HaveKey(v1.ResourceName("vendor.com/foo")))
+		})
+		It("should offer on-demand capacity in each failure domain", func() {
+			instanceTypes := instanceTypes()
+			Expect(instanceTypes[0].Offerings()).To(Equal(cloudprovider.Offerings{{CapacityType: "on-demand", Zone: "default"}}))
+			Expect(instanceTypes[1].Offerings()).To(Equal(cloudprovider.Offerings{
+				{CapacityType: "on-demand", Zone: "test-fd-1"},
+				{CapacityType: "on-demand", Zone: "test-fd-2"},
+			}))
+		})
+	})
+	Context("Create", func() {
+		It("should create machines from the templates", func() {
+			nodes := create(v1alpha5.Requirements{
+				{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-fd-2"}},
+				{Key: v1alpha5.LabelCapacityType, Operator: v1.NodeSelectorOpIn, Values: []string{"on-demand"}},
+			}, 2)
+			Expect(nodes).To(HaveLen(2))
+			Expect(nodes[0].Name).ToNot(Equal(nodes[1].Name))
+			node := nodes[0]
+			Expect(node.Labels).To(Equal(map[string]string{
+				v1.LabelTopologyZone:       "test-fd-2",
+				v1.LabelInstanceTypeStable: "large",
+				v1alpha5.LabelCapacityType: "on-demand",
+			}))
+			Expect(node.Annotations).To(HaveKeyWithValue(v1alpha1.MachineAnnotationKey, "default/"+node.Name))
+			Expect(node.Spec.ProviderID).To(Equal("vsphere://" + node.Name))
+			Expect(node.Status.NodeInfo.Architecture).To(Equal(v1alpha5.ArchitectureArm64))
+			Expect(node.Status.Allocatable.Cpu().String()).To(Equal("8"))
+
+			machine := get("cluster.x-k8s.io/v1beta1", "Machine", node.Name)
+			Expect(machine.GetLabels()).To(Equal(map[string]string{
+				v1alpha1.ClusterNameLabelKey:     "test-cluster",
+				v1alpha5.ProvisionerNameLabelKey: "default",
+			}))
+			Expect(machine.Object["spec"]).To(Equal(map[string]interface{}{
+				"clusterName":   "test-cluster",
+				"version":       "v1.21.5",
+				"failureDomain": "test-fd-2",
+				"providerID":    "vsphere://" + node.Name,
+				"infrastructureRef": map[string]interface{}{
+					"apiVersion": "infrastructure.cluster.x-k8s.io/v1beta1", "kind": "VSphereMachine", "name": node.Name, "namespace": "default",
+				},
+				"bootstrap": map[string]interface{}{
+					"configRef": map[string]interface{}{
+						"apiVersion": "bootstrap.cluster.x-k8s.io/v1beta1", "kind": "KubeadmConfig", "name": node.Name, "namespace": "default",
+					},
+				},
+			}))
+
+			infrastructure := get("infrastructure.cluster.x-k8s.io/v1beta1", "VSphereMachine", node.Name)
+			Expect(infrastructure.Object["spec"]).To(Equal(map[string]interface{}{"numCPUs": int64(8), "memoryMiB": int64(16384)}))
+			Expect(infrastructure.GetLabels()).To(HaveKeyWithValue(v1alpha1.ClusterNameLabelKey, "test-cluster"))
+			Expect(infrastructure.GetAnnotations()).To(Equal(map[string]string{
+				"cluster.x-k8s.io/cloned-from-name":      "test-large",
+				"cluster.x-k8s.io/cloned-from-groupkind": "VSphereMachineTemplate.infrastructure.cluster.x-k8s.io",
+			}))
+			bootstrap := get("bootstrap.cluster.x-k8s.io/v1beta1", "KubeadmConfig", node.Name)
+			Expect(bootstrap.Object["spec"]).To(HaveKey("joinConfiguration"))
+		})
+		It("should copy the template's labels", func() {
+			nodes := create(v1alpha5.Requirements{
+				{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"default"}},
+				{Key: v1alpha5.LabelCapacityType, Operator: v1.NodeSelectorOpIn, Values: []string{"on-demand"}},
+			}, 1)
+			Expect(nodes[0].Labels).To(HaveKeyWithValue(v1.LabelInstanceTypeStable, "small"))
+			infrastructure := get("infrastructure.cluster.x-k8s.io/v1beta1", "VSphereMachine", nodes[0].Name)
+			Expect(infrastructure.GetLabels()).To(HaveKeyWithValue("test-label", "test-value"))
+			machine := get("cluster.x-k8s.io/v1beta1", "Machine", nodes[0].Name)
+			Expect(machine.Object["spec"]).ToNot(HaveKey("failureDomain"))
+		})
+		It("should return insufficient capacity if no offerings are compatible", func() {
+			constraints := defaulted()
+			constraints.Requirements = v1alpha5.Requirements{
+				{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-fd-3"}},
+				{Key: v1alpha5.LabelCapacityType, Operator: v1.NodeSelectorOpIn, Values: []string{"on-demand"}},
+			}
+			err := cloudProvider.Create(ctx, constraints.Constraints, instanceTypes(), 1, func(*v1.Node) error { return nil })
+			Expect(cloudprovider.IsInsufficientCapacityError(err)).To(BeTrue())
+		})
+		It("should clean up the infrastructure machine if the bootstrap template doesn't exist", func() {
+			provider.BootstrapTemplate.Name = "missing"
+			constraints := defaulted()
+			constraints.Requirements = v1alpha5.Requirements{
+				{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"default"}},
+				{Key: v1alpha5.LabelCapacityType, Operator: v1.NodeSelectorOpIn, Values: []string{"on-demand"}},
+			}
+			Expect(cloudProvider.Create(ctx, constraints.Constraints, instanceTypes(), 1, func(*v1.Node) error { return nil })).ToNot(Succeed())
+			infrastructure, err := client.Resource(schema.GroupVersionResource{Group: "infrastructure.cluster.x-k8s.io", Version: "v1beta1", Resource: "vspheremachines"}).
+				Namespace("default").List(ctx, metav1.ListOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(infrastructure.Items).To(BeEmpty())
+		})
+		It("should delete machines that aren't given a provider ID", func() {
+			// without the reactor that stands in for Cluster API
+			client.ReactionChain = client.ReactionChain[1:]
+			constraints := defaulted()
+			constraints.Requirements = v1alpha5.Requirements{
+				{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"default"}},
+				{Key: v1alpha5.LabelCapacityType, Operator: v1.NodeSelectorOpIn, Values: []string{"on-demand"}},
+			}
+			Expect(cloudProvider.Create(ctx, constraints.Constraints, instanceTypes(), 1, func(*v1.Node) error { return nil })).ToNot(Succeed())
+			machines, err := client.Resource(schema.GroupVersionResource{Group: "cluster.x-k8s.io", Version: "v1beta1", Resource: "machines"}).
+				Namespace("default").List(ctx, metav1.ListOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(machines.Items).To(BeEmpty())
+		})
+		It("should bind the machines that are given a provider ID and report the rest", func() {
+			// Cluster API only sets the provider ID of the first machine
+			created := 0
+			client.ReactionChain[0] = &k8stesting.SimpleReactor{Verb: "create", Resource: "machines", Reaction: func(action k8stesting.Action) (bool, runtime.Object, error) {
+				created++
+				if created > 1 {
+					return false, nil, nil
+				}
+				machine := action.(k8stesting.CreateAction).GetObject().(*unstructured.Unstructured)
+				return false, nil, unstructured.SetNestedField(machine.Object, "vsphere://"+machine.GetName(), "spec", "providerID")
+			}}
+			constraints := defaulted()
+			constraints.Requirements = v1alpha5.Requirements{
+				{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"default"}},
+				{Key: v1alpha5.LabelCapacityType, Operator: v1.NodeSelectorOpIn, Values: []string{"on-demand"}},
+			}
+			nodes := []*v1.Node{}
+			err := cloudProvider.Create(ctx, constraints.Constraints, instanceTypes(), 2, func(node *v1.Node) error {
+				nodes = append(nodes, node)
+				return nil
+			})
+			Expect(err).To(HaveOccurred())
+			Expect(nodes).To(HaveLen(1))
+			machines, err := client.Resource(schema.GroupVersionResource{Group: "cluster.x-k8s.io", Version: "v1beta1", Resource: "machines"}).
+				Namespace("default").List(ctx, metav1.ListOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(machines.Items).To(HaveLen(1))
+			Expect(machines.Items[0].GetName()).To(Equal(nodes[0].Name))
+		})
+		It("should wait for the provider IDs of machines concurrently", func() {
+			client.ReactionChain = client.ReactionChain[1:]
+			constraints := defaulted()
+			constraints.Requirements = v1alpha5.Requirements{
+				{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"default"}},
+				{Key: v1alpha5.LabelCapacityType, Operator: v1.NodeSelectorOpIn, Values: []string{"on-demand"}},
+			}
+			start := time.Now()
+			Expect(cloudProvider.Create(ctx, constraints.Constraints, instanceTypes(), 5, func(*v1.Node) error { return nil })).ToNot(Succeed())
+			Expect(time.Since(start)).To(BeNumerically("<", 3*ProviderIDTimeout))
+		})
+	})
+	Context("Delete", func() {
+		It("should delete the node's machine", func() {
+			nodes := create(v1alpha5.Requirements{
+				{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"default"}},
+				{Key: v1alpha5.LabelCapacityType, Operator: v1.NodeSelectorOpIn, Values: []string{"on-demand"}},
+			}, 1)
+			Expect(cloudProvider.Delete(ctx, nodes[0])).To(Succeed())
+			_, err := client.Resource(schema.GroupVersionResource{Group: "cluster.x-k8s.io", Version: "v1beta1", Resource: "machines"}).
+				Namespace("default").Get(ctx, nodes[0].Name, metav1.GetOptions{})
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+			Expect(cloudProvider.Delete(ctx, nodes[0])).To(Succeed())
+		})
+		It("should ignore nodes without a machine", func() {
+			Expect(cloudProvider.Delete(ctx, &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-node"}})).To(Succeed())
+		})
+	})
+	Context("IsDrifted", func() {
+		var node *v1.Node
+		BeforeEach(func() {
+			node = create(v1alpha5.Requirements{
+				{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-fd-1"}},
+				{Key: v1alpha5.LabelCapacityType, Operator: v1.NodeSelectorOpIn, Values: []string{"on-demand"}},
+			}, 1)[0]
+		})
+		It("should not report machines that match the provisioner", func() {
+			defaulted()
+			Expect(cloudProvider.IsDrifted(ctx, node, provisioner)).To(BeEmpty())
+		})
+		It("should report machines of another version", func() {
+			provider.Version = ptr.String("v1.22.4")
+			defaulted()
+			Expect(cloudProvider.IsDrifted(ctx, node, provisioner)).To(Equal("machine has version v1.21.5, but the provisioner creates v1.22.4"))
+		})
+		It("should report machines that were cloned from another infrastructure template", func() {
+			provider.MachineTypes[1].InfrastructureTemplate.Name = "test-large-v2"
+			defaulted()
+			Expect(cloudProvider.IsDrifted(ctx, node, provisioner)).To(ContainSubstring("cloned from VSphereMachineTemplate.infrastructure.cluster.x-k8s.io test-large"))
+		})
+		It("should report machines that were cloned from another bootstrap template", func() {
+			provider.BootstrapTemplate.Name = "test-bootstrap-v2"
+			defaulted()
+			Expect(cloudProvider.IsDrifted(ctx, node, provisioner)).To(ContainSubstring("cloned from KubeadmConfigTemplate.bootstrap.cluster.x-k8s.io test-bootstrap"))
+		})
+		It("should report machines of machine types that are no longer offered", func() {
+			provider.MachineTypes = provider.MachineTypes[:1]
+			defaulted()
+			Expect(cloudProvider.IsDrifted(ctx, node, provisioner)).To(Equal("machine type large is no longer offered by the provisioner"))
+		})
+		It("should report machines that no longer exist", func() {
+			Expect(cloudProvider.Delete(ctx, node)).To(Succeed())
+			Expect(cloudProvider.IsDrifted(ctx, node, provisioner)).To(ContainSubstring("no longer exists"))
+		})
+		It("should ignore nodes without a machine", func() {
+			Expect(cloudProvider.IsDrifted(ctx, &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-node"}}, provisioner)).To(BeEmpty())
+		})
+	})
+	Context("Verify", func() {
+		It("should succeed if the cluster and templates exist", func() {
+			Expect(cloudProvider.Verify(ctx, defaulted().Constraints)).To(Succeed())
+		})
+		It("should report a missing cluster", func() {
+			provider.ClusterName = "missing"
+			reason, ok := cloudprovider.ConfigurationErrorReason(cloudProvider.Verify(ctx, defaulted().Constraints))
+			Expect(ok).To(BeTrue())
+			Expect(reason).To(Equal(ClusterNotFoundReason))
+		})
+		It("should report a missing template", func() {
+			provider.MachineTypes[1].InfrastructureTemplate.Name = "missing"
+			reason, ok := cloudprovider.ConfigurationErrorReason(cloudProvider.Verify(ctx, defaulted().Constraints))
+			Expect(ok).To(BeTrue())
+			Expect(reason).To(Equal(TemplateNotFoundReason))
+		})
+	})
+})
+
+func object(apiVersion string, kind string, name string, spec map[string]interface{}) *unstructured.Unstructured {
+	o := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+	o.SetAPIVersion(apiVersion)
+	o.SetKind(kind)
+	o.SetNamespace("default")
+	o.SetName(name)
+	return o
+}
+
+func get(apiVersion string, kind string, name string) *unstructured.Unstructured {
+	o, err := cloudProvider.machineProvider.resource(schema.FromAPIVersionAndKind(apiVersion, kind)).Namespace("default").Get(ctx, name, metav1.GetOptions{})
+	Expect(err).ToNot(HaveOccurred())
+	return o
+}
+
+// defaulted returns the provisioner's constraints with the provider, defaulted
+// as they would be by the webhook
+func defaulted() *v1alpha1.Constraints {
+	raw, err := json.Marshal(provider)
+	Expect(err).ToNot(HaveOccurred())
+	provisioner.Spec.Provider = &runtime.RawExtension{Raw: raw}
+	cloudProvider.Default(ctx, &provisioner.Spec.Constraints)
+	constraints, err := v1alpha1.Deserialize(&provisioner.Spec.Constraints)
+	Expect(err).ToNot(HaveOccurred())
+	return constraints
+}
+
+func validate() error {
+	if err := cloudProvider.Validate(ctx, defaulted().Constraints); err != nil {
+		return err
+	}
+	return nil
+}
+
+func instanceTypes() []cloudprovider.InstanceType {
+	defaulted()
+	instanceTypes, err := cloudProvider.GetInstanceTypes(ctx, provisioner)
+	Expect(err).ToNot(HaveOccurred())
+	return instanceTypes
+}
+
+func create(requirements v1alpha5.Requirements, quantity int) []*v1.Node {
+	constraints := defaulted()
+	constraints.Requirements = requirements
+	nodes := []*v1.Node{}
+	Expect(cloudProvider.Create(ctx, constraints.Constraints, instanceTypes(), quantity, func(node *v1.Node) error {
+		nodes = append(nodes, node)
+		return nil
+	})).To(Succeed())
+	return nodes
+}
@@ -0,0 +1,263 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package conformance is a suite of tests that every CloudProvider
+// implementation must pass. The suite is provider agnostic and verifies the
+// contract that the provisioning, termination, and node controllers rely on:
+// the schema of instance types and offerings, the semantics of Create and
+// its typed errors, the semantics of Delete, and that new nodes haven't
+// drifted.
+//
+// Providers run the suite from their own ginkgo test suite:
+//
+//	var _ = conformance.Describe(func() conformance.Options {
+//		return conformance.Options{Context: ctx, CloudProvider: cloudProvider, Provisioner: provisioner}
+//	})
+package conformance
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// Options configure the conformance suite for a CloudProvider implementation
+type Options struct {
+	// Context is passed to all CloudProvider methods
+	Context context.Context
+	// CloudProvider is the implementation under test
+	CloudProvider cloudprovider.CloudProvider
+	// Provisioner returns a fresh copy of a provisioner that is valid for the
+	// CloudProvider, including any vendor specific provider configuration.
+	// Well known requirements are overridden by the suite.
+	Provisioner func() *v1alpha5.Provisioner
+}
+
+// Describe registers the conformance specs. The options are resolved before
+// each spec, so they may reference objects that are created in BeforeSuite.
+func Describe(options func() Options) bool {
+	return ginkgo.Describe("CloudProvider Conformance", func() {
+		var ctx context.Context
+		var cloudProvider cloudprovider.CloudProvider
+		var instanceTypes []cloudprovider.InstanceType
+		var provisioner *v1alpha5.Provisioner
+		var constraints *v1alpha5.Constraints
+
+		ginkgo.BeforeEach(func() {
+			opts := options()
+			ctx = opts.Context
+			cloudProvider = opts.CloudProvider
+			var err error
+			instanceTypes, err = cloudProvider.GetInstanceTypes(ctx, opts.Provisioner())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(instanceTypes).ToNot(BeEmpty(), "expected at least one instance type")
+			provisioner = opts.Provisioner()
+			constraints = &provisioner.Spec.Constraints
+			constraints.Requirements = constraintsFor(instanceTypes[0], instanceTypes[0].Offerings()[0])
+		})
+
+		ginkgo.Context("GetInstanceTypes", func() {
+			ginkgo.It("should return instance types with unique names", func() {
+				names := sets.NewString()
+				for _, instanceType := range instanceTypes {
+					Expect(instanceType.Name()).ToNot(BeEmpty())
+					Expect(names.Has(instanceType.Name())).To(BeFalse(), "duplicate instance type %s", instanceType.Name())
+					names.Insert(instanceType.Name())
+				}
+			})
+			ginkgo.It("should return unique offerings with a zone and capacity type", func() {
+				for _, instanceType := range instanceTypes {
+					Expect(instanceType.Offerings()).ToNot(BeEmpty(), "instance type %s has no offerings", instanceType.Name())
+					offerings := sets.NewString()
+					for _, offering := range instanceType.Offerings() {
+						Expect(offering.Zone).ToNot(BeEmpty(), "instance type %s has an offering without a zone", instanceType.Name())
+						Expect(offering.CapacityType).ToNot(BeEmpty(), "instance type %s has an offering without a capacity type", instanceType.Name())
+						key := fmt.Sprintf("%s/%s", offering.CapacityType, offering.Zone)
+						Expect(offerings.Has(key)).To(BeFalse(), "instance type %s has duplicate offering %s", instanceType.Name(), key)
+						offerings.Insert(key)
+					}
+				}
+			})
+			ginkgo.It("should return offerings with known or unknown prices", func() {
+				for _, instanceType := range instanceTypes {
+					for _, offering := range instanceType.Offerings() {
+						Expect(offering.Price).To(BeNumerically(">=", 0), "instance type %s has a negative price in %s/%s", instanceType.Name(), offering.CapacityType, offering.Zone)
+					}
+				}
+			})
+			ginkgo.It("should return instance types with schedulable resources", func() {
+				for _, instanceType := range instanceTypes {
+					Expect(instanceType.Architecture()).ToNot(BeEmpty(), "instance type %s has no architecture", instanceType.Name())
+					Expect(instanceType.OperatingSystems().Len()).ToNot(BeZero(), "instance type %s has no operating systems", instanceType.Name())
+					Expect(instanceType.CPU().Sign()).To(Equal(1), "instance type %s has no cpu", instanceType.Name())
+					Expect(instanceType.Memory().Sign()).To(Equal(1), "instance type %s has no memory", instanceType.Name())
+					Expect(instanceType.EphemeralStorage().Sign()).To(Equal(1), "instance type %s has no ephemeral storage", instanceType.Name())
+					Expect(instanceType.Pods().Sign()).To(Equal(1), "instance type %s has no pods", instanceType.Name())
+					for _, quantity := range []int{instanceType.NvidiaGPUs().Sign(), instanceType.AMDGPUs().Sign(), instanceType.AWSNeurons().Sign(), instanceType.HabanaGaudis().Sign(), instanceType.AWSPodENI().Sign()} {
+						Expect(quantity).To(BeNumerically(">=", 0), "instance type %s has negative resources", instanceType.Name())
+					}
+				}
+			})
+			ginkgo.It("should return overhead that is less than the instance type's resources", func() {
+				for _, instanceType := range instanceTypes {
+					overhead := instanceType.Overhead().Total()
+					Expect(overhead.Cpu().Cmp(*instanceType.CPU())).To(Equal(-1), "instance type %s has cpu overhead exceeding capacity", instanceType.Name())
+					Expect(overhead.Memory().Cmp(*instanceType.Memory())).To(Equal(-1), "instance type %s has memory overhead exceeding capacity", instanceType.Name())
+					Expect(overhead.StorageEphemeral().Cmp(*instanceType.EphemeralStorage())).To(Equal(-1), "instance type %s has ephemeral storage overhead exceeding capacity", instanceType.Name())
+				}
+			})
+		})
+
+		ginkgo.Context("Create", func() {
+			ginkgo.It("should call back once for each node", func() {
+				nodes := create(ctx, cloudProvider, constraints, instanceTypes, 2)
+				Expect(nodes).To(HaveLen(2))
+				Expect(nodes[0].Name).ToNot(Equal(nodes[1].Name))
+			})
+			ginkgo.It("should create nodes that match the constraints", func() {
+				for _, node := range create(ctx, cloudProvider, constraints, instanceTypes, 1) {
+					Expect(node.Name).ToNot(BeEmpty())
+					Expect(node.Spec.ProviderID).ToNot(BeEmpty())
+					for _, key := range []string{v1.LabelInstanceTypeStable, v1.LabelTopologyZone, v1alpha5.LabelCapacityType} {
+						Expect(constraints.Requirements.Requirement(key).Has(node.Labels[key])).To(BeTrue(),
+							"expected label %s=%s to be in %v", key, node.Labels[key], constraints.Requirements.Requirement(key).List())
+					}
+				}
+			})
+			ginkgo.It("should create nodes with the resources of an offered instance type", func() {
+				for _, node := range create(ctx, cloudProvider, constraints, instanceTypes, 1) {
+					instanceType := instanceTypeFor(node, instanceTypes)
+					Expect(instanceType).ToNot(BeNil(), "node has unknown instance type %s", node.Labels[v1.LabelInstanceTypeStable])
+					// Prices are optional, so only the zone and capacity type are compared
+					offerings := []cloudprovider.Offering{}
+					for _, offering := range instanceType.Offerings() {
+						offerings = append(offerings, cloudprovider.Offering{Zone: offering.Zone, CapacityType: offering.CapacityType})
+					}
+					Expect(offerings).To(ContainElement(cloudprovider.Offering{
+						Zone:         node.Labels[v1.LabelTopologyZone],
+						CapacityType: node.Labels[v1alpha5.LabelCapacityType],
+					}))
+					Expect(node.Status.Allocatable.Cpu().Cmp(*instanceType.CPU())).To(Equal(0))
+					Expect(node.Status.Allocatable.Memory().Cmp(*instanceType.Memory())).To(Equal(0))
+					Expect(node.Status.Allocatable.StorageEphemeral().Cmp(*instanceType.EphemeralStorage())).To(Equal(0))
+					Expect(node.Status.Allocatable.Pods().Cmp(*instanceType.Pods())).To(Equal(0))
+					for key, value := range instanceType.Labels() {
+						Expect(node.Labels).To(HaveKeyWithValue(key, value))
+					}
+				}
+			})
+			ginkgo.It("should return errors from the callback", func() {
+				err := cloudProvider.Create(ctx, constraints, optionsFor(constraints, instanceTypes), 1, func(*v1.Node) error { return fmt.Errorf("conformance") })
+				Expect(err).To(HaveOccurred())
+			})
+			ginkgo.It("should be safe to retry after a failed callback", func() {
+				failed := []*v1.Node{}
+				Expect(cloudProvider.Create(ctx, constraints, optionsFor(constraints, instanceTypes), 1, func(node *v1.Node) error {
+					failed = append(failed, node)
+					return fmt.Errorf("conformance")
+				})).ToNot(Succeed())
+				nodes := create(ctx, cloudProvider, constraints, instanceTypes, 1)
+				Expect(nodes).To(HaveLen(1))
+				for _, node := range failed {
+					Expect(nodes[0].Name).ToNot(Equal(node.Name))
+				}
+			})
+			ginkgo.It("should return an insufficient capacity error if no offerings satisfy the constraints", func() {
+				constraints.Requirements = constraints.Requirements.With(v1alpha5.Requirements{
+					{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpNotIn, Values: constraints.Requirements.Zones().List()},
+				})
+				called := false
+				err := cloudProvider.Create(ctx, constraints, optionsFor(constraints, instanceTypes), 1, func(*v1.Node) error { called = true; return nil })
+				Expect(cloudprovider.IsInsufficientCapacityError(err)).To(BeTrue(), "expected insufficient capacity error, got %v", err)
+				Expect(called).To(BeFalse())
+			})
+		})
+
+		ginkgo.Context("Verify", func() {
+			ginkgo.It("should verify the constraints", func() {
+				Expect(cloudProvider.Verify(ctx, constraints)).To(Succeed())
+			})
+		})
+
+		ginkgo.Context("IsDrifted", func() {
+			ginkgo.It("should not report new nodes as drifted", func() {
+				for _, node := range create(ctx, cloudProvider, constraints, instanceTypes, 1) {
+					reason, err := cloudProvider.IsDrifted(ctx, node, provisioner)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(reason).To(BeEmpty())
+				}
+			})
+		})
+
+		ginkgo.Context("Delete", func() {
+			ginkgo.It("should delete nodes", func() {
+				for _, node := range create(ctx, cloudProvider, constraints, instanceTypes, 1) {
+					Expect(cloudProvider.Delete(ctx, node)).To(Succeed())
+				}
+			})
+			ginkgo.It("should succeed if the node is already deleted", func() {
+				for _, node := range create(ctx, cloudProvider, constraints, instanceTypes, 1) {
+					Expect(cloudProvider.Delete(ctx, node)).To(Succeed())
+					Expect(cloudProvider.Delete(ctx, node)).To(Succeed())
+				}
+			})
+		})
+	})
+}
+
+// constraintsFor returns requirements that are only satisfied by the offering
+func constraintsFor(instanceType cloudprovider.InstanceType, offering cloudprovider.Offering) v1alpha5.Requirements {
+	return v1alpha5.Requirements{
+		{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpIn, Values: []string{instanceType.Name()}},
+		{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{offering.Zone}},
+		{Key: v1alpha5.LabelCapacityType, Operator: v1.NodeSelectorOpIn, Values: []string{offering.CapacityType}},
+		{Key: v1.LabelArchStable, Operator: v1.NodeSelectorOpIn, Values: []string{instanceType.Architecture()}},
+		{Key: v1.LabelOSStable, Operator: v1.NodeSelectorOpIn, Values: instanceType.OperatingSystems().List()},
+	}
+}
+
+func create(ctx context.Context, cloudProvider cloudprovider.CloudProvider, constraints *v1alpha5.Constraints, instanceTypes []cloudprovider.InstanceType, quantity int) []*v1.Node {
+	nodes := []*v1.Node{}
+	Expect(cloudProvider.Create(ctx, constraints, optionsFor(constraints, instanceTypes), quantity, func(node *v1.Node) error {
+		nodes = append(nodes, node)
+		return nil
+	})).To(Succeed())
+	return nodes
+}
+
+// optionsFor returns the instance types allowed by the constraints
+func optionsFor(constraints *v1alpha5.Constraints, instanceTypes []cloudprovider.InstanceType) []cloudprovider.InstanceType {
+	options := []cloudprovider.InstanceType{}
+	for _, instanceType := range instanceTypes {
+		if constraints.Requirements.InstanceTypes().Has(instanceType.Name()) {
+			options = append(options, instanceType)
+		}
+	}
+	return options
+}
+
+func instanceTypeFor(node *v1.Node, instanceTypes []cloudprovider.InstanceType) cloudprovider.InstanceType {
+	for _, instanceType := range instanceTypes {
+		if instanceType.Name() == node.Labels[v1.LabelInstanceTypeStable] {
+			return instanceType
+		}
+	}
+	return nil
+}
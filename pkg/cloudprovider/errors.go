@@ -0,0 +1,111 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudprovider
+
+import (
+	"errors"
+)
+
+// InsufficientCapacityError is returned by Create when none of the offerings
+// that satisfy the constraints could be launched. Callers may retry with
+// different constraints, e.g. by relaxing a pod's preferences.
+type InsufficientCapacityError struct {
+	error
+}
+
+func NewInsufficientCapacityError(err error) *InsufficientCapacityError {
+	return &InsufficientCapacityError{error: err}
+}
+
+func (e *InsufficientCapacityError) Error() string {
+	return "insufficient capacity, " + e.error.Error()
+}
+
+func (e *InsufficientCapacityError) Unwrap() error {
+	return e.error
+}
+
+// IsInsufficientCapacityError returns true if the error, or any error it
+// wraps, is an InsufficientCapacityError
+func IsInsufficientCapacityError(err error) bool {
+	var insufficientCapacityError *InsufficientCapacityError
+	return errors.As(err, &insufficientCapacityError)
+}
+
+// ConfigurationError is returned by Verify when the constraints resolve to
+// cloud provider resources that can't be used, e.g. security groups that are
+// missing or in a different network than the subnets. Its reason is reported
+// on the provisioner's Validated condition so that the problem can be diagnosed
+// without reading the controller's logs.
+type ConfigurationError struct {
+	error
+	reason string
+}
+
+func NewConfigurationError(reason string, err error) *ConfigurationError {
+	return &ConfigurationError{error: err, reason: reason}
+}
+
+func (e *ConfigurationError) Reason() string {
+	return e.reason
+}
+
+func (e *ConfigurationError) Unwrap() error {
+	return e.error
+}
+
+// ConfigurationErrorReason returns the reason of the first ConfigurationError
+// that the error wraps, or false if there are none
+func ConfigurationErrorReason(err error) (string, bool) {
+	var configurationError *ConfigurationError
+	if errors.As(err, &configurationError) {
+		return configurationError.Reason(), true
+	}
+	return "", false
+}
+
+// CodedError carries the cloud provider's codes of a failure, e.g. the error
+// codes of a fleet request, so that they can be reported without parsing the
+// error's message.
+type CodedError struct {
+	error
+	codes []string
+}
+
+func NewCodedError(codes []string, err error) *CodedError {
+	return &CodedError{error: err, codes: codes}
+}
+
+func (e *CodedError) Codes() []string {
+	return e.codes
+}
+
+func (e *CodedError) Unwrap() error {
+	return e.error
+}
+
+// ErrorCodes returns the codes of the first CodedError that the error wraps,
+// or else the code of the first error that has one, e.g. an AWS API error
+func ErrorCodes(err error) []string {
+	var codedError *CodedError
+	if errors.As(err, &codedError) {
+		return codedError.Codes()
+	}
+	var coder interface{ Code() string }
+	if errors.As(err, &coder) {
+		return []string{coder.Code()}
+	}
+	return nil
+}
@@ -18,46 +18,121 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"sync"
 
 	"github.com/Pallinder/go-randomdata"
 	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
 	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/utils/functional"
 	"go.uber.org/multierr"
 	"knative.dev/pkg/apis"
 
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
 )
 
 type CloudProvider struct {
 	InstanceTypes []cloudprovider.InstanceType
+	// VerifyError is returned by Verify, if set
+	VerifyError error
+	// MaxQuantity limits the number of nodes launched by each call to Create,
+	// if set, emulating partial fulfillment
+	MaxQuantity int
+	// DriftReasons are returned by IsDrifted, by node name
+	DriftReasons map[string]string
+	// CreateHook, GetInstanceTypesHook, DeleteHook, VerifyHook and
+	// IsDriftedHook are called before each call of their method, if set, to
+	// inject errors, latency or partial fulfillment
+	CreateHook           Hook
+	GetInstanceTypesHook Hook
+	DeleteHook           Hook
+	VerifyHook           Hook
+	IsDriftedHook        Hook
+	// Supported overrides the cloud provider's capabilities, if set
+	Supported *cloudprovider.Capabilities
+
+	mu    sync.Mutex
+	calls []Call
+}
+
+// Calls returns the calls that the cloud provider has handled, in order
+func (c *CloudProvider) Calls() []Call {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Call{}, c.calls...)
+}
+
+// CallsTo returns the calls of the method that the cloud provider has handled
+func (c *CloudProvider) CallsTo(method string) []Call {
+	calls := []Call{}
+	for _, call := range c.Calls() {
+		if call.Method == method {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// Reset the cloud provider's configuration and recorded calls
+func (c *CloudProvider) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.InstanceTypes = nil
+	c.VerifyError = nil
+	c.MaxQuantity = 0
+	c.DriftReasons = nil
+	c.CreateHook = nil
+	c.GetInstanceTypesHook = nil
+	c.DeleteHook = nil
+	c.VerifyHook = nil
+	c.IsDriftedHook = nil
+	c.Supported = nil
+	c.calls = nil
+}
+
+// handle records the call once it returns, after calling the hook
+func (c *CloudProvider) handle(ctx context.Context, hook Hook, call *Call, f func() error) error {
+	if hook != nil {
+		call.Err = hook(ctx, call)
+	}
+	if call.Err == nil {
+		call.Err = f()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls = append(c.calls, *call)
+	return call.Err
+}
+
+func (c *CloudProvider) Create(ctx context.Context, constraints *v1alpha5.Constraints, instanceTypes []cloudprovider.InstanceType, quantity int, bind func(*v1.Node) error) error {
+	call := &Call{Method: "Create", Constraints: constraints, InstanceTypes: instanceTypes, Quantity: quantity}
+	return c.handle(ctx, c.CreateHook, call, func() error {
+		return c.create(constraints, instanceTypes, call.Quantity, bind)
+	})
 }
 
-func (c *CloudProvider) Create(_ context.Context, constraints *v1alpha5.Constraints, instanceTypes []cloudprovider.InstanceType, quantity int, bind func(*v1.Node) error) error {
+func (c *CloudProvider) create(constraints *v1alpha5.Constraints, instanceTypes []cloudprovider.InstanceType, quantity int, bind func(*v1.Node) error) error {
+	instance, offering, ok := c.offeringFor(constraints, instanceTypes)
+	if !ok {
+		return cloudprovider.NewInsufficientCapacityError(fmt.Errorf("no offerings of %d instance type option(s) satisfy constraints", len(instanceTypes)))
+	}
+	zone, capacityType := offering.Zone, offering.CapacityType
+	if c.MaxQuantity > 0 && quantity > c.MaxQuantity {
+		quantity = c.MaxQuantity
+	}
 	var err error
 	for i := 0; i < quantity; i++ {
 		name := strings.ToLower(randomdata.SillyName())
-		instance := instanceTypes[0]
-		var zone, capacityType string
-		for _, o := range instance.Offerings() {
-			if constraints.Requirements.CapacityTypes().Has(o.CapacityType) {
-				if constraints.Requirements.Zones().Has(o.Zone) {
-					zone = o.Zone
-					capacityType = o.CapacityType
-					break
-				}
-			}
-		}
-
 		err = multierr.Append(err, bind(&v1.Node{
 			ObjectMeta: metav1.ObjectMeta{
 				Name: name,
-				Labels: map[string]string{
+				Labels: functional.UnionStringMaps(instance.Labels(), map[string]string{
 					v1.LabelTopologyZone:       zone,
 					v1.LabelInstanceTypeStable: instance.Name(),
 					v1alpha5.LabelCapacityType: capacityType,
-				},
+				}),
 			},
 			Spec: v1.NodeSpec{
 				ProviderID: fmt.Sprintf("fake:///%s/%s", name, zone),
@@ -68,9 +143,10 @@ func (c *CloudProvider) Create(_ context.Context, constraints *v1alpha5.Constrai
 					OperatingSystem: v1alpha5.OperatingSystemLinux,
 				},
 				Allocatable: v1.ResourceList{
-					v1.ResourcePods:   *instance.Pods(),
-					v1.ResourceCPU:    *instance.CPU(),
-					v1.ResourceMemory: *instance.Memory(),
+					v1.ResourcePods:             *instance.Pods(),
+					v1.ResourceCPU:              *instance.CPU(),
+					v1.ResourceMemory:           *instance.Memory(),
+					v1.ResourceEphemeralStorage: *instance.EphemeralStorage(),
 				},
 			},
 		}))
@@ -78,9 +154,31 @@ func (c *CloudProvider) Create(_ context.Context, constraints *v1alpha5.Constrai
 	return err
 }
 
-func (c *CloudProvider) GetInstanceTypes(_ context.Context, _ *v1alpha5.Constraints) ([]cloudprovider.InstanceType, error) {
+// offeringFor returns the first instance type and offering that satisfy the constraints
+func (c *CloudProvider) offeringFor(constraints *v1alpha5.Constraints, instanceTypes []cloudprovider.InstanceType) (cloudprovider.InstanceType, cloudprovider.Offering, bool) {
+	for _, instanceType := range instanceTypes {
+		if offerings := instanceType.Offerings().Compatible(constraints.Requirements.Zones(), constraints.Requirements.CapacityTypes()); len(offerings) > 0 {
+			return instanceType, offerings[0], true
+		}
+	}
+	return nil, cloudprovider.Offering{}, false
+}
+
+func (c *CloudProvider) GetInstanceTypes(ctx context.Context, provisioner *v1alpha5.Provisioner) ([]cloudprovider.InstanceType, error) {
+	var instanceTypes []cloudprovider.InstanceType
+	err := c.handle(ctx, c.GetInstanceTypesHook, &Call{Method: "GetInstanceTypes", Provisioner: provisioner}, func() error {
+		instanceTypes = c.instanceTypes()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return instanceTypes, nil
+}
+
+func (c *CloudProvider) instanceTypes() []cloudprovider.InstanceType {
 	if c.InstanceTypes != nil {
-		return c.InstanceTypes, nil
+		return c.InstanceTypes
 	}
 	return []cloudprovider.InstanceType{
 		NewInstanceType(InstanceTypeOptions{
@@ -107,15 +205,19 @@ func (c *CloudProvider) GetInstanceTypes(_ context.Context, _ *v1alpha5.Constrai
 			Name:       "aws-neuron-instance-type",
 			AWSNeurons: resource.MustParse("2"),
 		}),
+		NewInstanceType(InstanceTypeOptions{
+			Name:         "habana-gaudi-instance-type",
+			HabanaGaudis: resource.MustParse("2"),
+		}),
 		NewInstanceType(InstanceTypeOptions{
 			Name:         "arm-instance-type",
 			Architecture: "arm64",
 		}),
-	}, nil
+	}
 }
 
-func (c *CloudProvider) Delete(context.Context, *v1.Node) error {
-	return nil
+func (c *CloudProvider) Delete(ctx context.Context, node *v1.Node) error {
+	return c.handle(ctx, c.DeleteHook, &Call{Method: "Delete", Node: node}, func() error { return nil })
 }
 
 func (c *CloudProvider) Default(context.Context, *v1alpha5.Constraints) {
@@ -125,6 +227,34 @@ func (c *CloudProvider) Validate(context.Context, *v1alpha5.Constraints) *apis.F
 	return nil
 }
 
+func (c *CloudProvider) Verify(ctx context.Context, constraints *v1alpha5.Constraints) error {
+	return c.handle(ctx, c.VerifyHook, &Call{Method: "Verify", Constraints: constraints}, func() error { return c.VerifyError })
+}
+
+func (c *CloudProvider) IsDrifted(ctx context.Context, node *v1.Node, provisioner *v1alpha5.Provisioner) (string, error) {
+	var reason string
+	err := c.handle(ctx, c.IsDriftedHook, &Call{Method: "IsDrifted", Node: node, Provisioner: provisioner}, func() error {
+		reason = c.DriftReasons[node.Name]
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return reason, nil
+}
+
+func (c *CloudProvider) Capabilities() cloudprovider.Capabilities {
+	if c.Supported != nil {
+		return *c.Supported
+	}
+	return cloudprovider.Capabilities{
+		CapacityTypes: sets.NewString("spot", "on-demand"),
+		Architectures: sets.NewString(v1alpha5.ArchitectureAmd64, v1alpha5.ArchitectureArm64),
+		Accelerators:  true,
+		MaxPodsSource: cloudprovider.MaxPodsSourceInstanceType,
+	}
+}
+
 // Name returns the CloudProvider implementation name.
 func (c *CloudProvider) Name() string {
 	return "fake"
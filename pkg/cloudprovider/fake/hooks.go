@@ -0,0 +1,100 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fake
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	v1 "k8s.io/api/core/v1"
+)
+
+// ErrThrottled emulates a cloud provider API that rejected a request because
+// its rate limit was exceeded
+var ErrThrottled = errors.New("request limit exceeded")
+
+// Call records a call to the cloud provider, with its arguments and the error
+// it returned. Fields that don't apply to the method are empty.
+type Call struct {
+	// Method is the name of the method, e.g. Create
+	Method        string
+	Constraints   *v1alpha5.Constraints
+	InstanceTypes []cloudprovider.InstanceType
+	Quantity      int
+	Provisioner   *v1alpha5.Provisioner
+	Node          *v1.Node
+	Err           error
+}
+
+// Hook is called before the cloud provider handles a call. An error fails the
+// call, and a hook that blocks delays it. Create hooks may reduce the call's
+// quantity to emulate partial fulfillment.
+type Hook func(ctx context.Context, call *Call) error
+
+// Fail returns a hook that fails every call with the error
+func Fail(err error) Hook {
+	return FailN(-1, err)
+}
+
+// FailN returns a hook that fails the first n calls with the error, and lets
+// the rest through, e.g. to test that callers retry. n < 0 fails every call.
+func FailN(n int, err error) Hook {
+	failed := int64(0)
+	return func(context.Context, *Call) error {
+		if n < 0 || atomic.AddInt64(&failed, 1) <= int64(n) {
+			return err
+		}
+		return nil
+	}
+}
+
+// Delay returns a hook that delays each call by the duration, or fails it if
+// the context is done first
+func Delay(duration time.Duration) Hook {
+	return func(ctx context.Context, _ *Call) error {
+		select {
+		case <-time.After(duration):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Limit returns a hook that limits the quantity of each call to Create
+func Limit(quantity int) Hook {
+	return func(_ context.Context, call *Call) error {
+		if call.Quantity > quantity {
+			call.Quantity = quantity
+		}
+		return nil
+	}
+}
+
+// Chain returns a hook that calls the hooks in order, until one fails
+func Chain(hooks ...Hook) Hook {
+	return func(ctx context.Context, call *Call) error {
+		for _, hook := range hooks {
+			if err := hook(ctx, call); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
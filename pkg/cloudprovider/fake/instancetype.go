@@ -45,22 +45,29 @@ func NewInstanceType(options InstanceTypeOptions) *InstanceType {
 	if options.Memory.IsZero() {
 		options.Memory = resource.MustParse("4Gi")
 	}
+	if options.EphemeralStorage.IsZero() {
+		options.EphemeralStorage = resource.MustParse("20Gi")
+	}
 	if options.Pods.IsZero() {
 		options.Pods = resource.MustParse("5")
 	}
 	return &InstanceType{
 		options: InstanceTypeOptions{
-			Name:             options.Name,
-			Offerings:        options.Offerings,
-			Architecture:     options.Architecture,
-			OperatingSystems: options.OperatingSystems,
-			CPU:              options.CPU,
-			Memory:           options.Memory,
-			Pods:             options.Pods,
-			NvidiaGPUs:       options.NvidiaGPUs,
-			AMDGPUs:          options.AMDGPUs,
-			AWSNeurons:       options.AWSNeurons,
-			AWSPodENI:        options.AWSPodENI,
+			Name:              options.Name,
+			Offerings:         options.Offerings,
+			Architecture:      options.Architecture,
+			OperatingSystems:  options.OperatingSystems,
+			CPU:               options.CPU,
+			Memory:            options.Memory,
+			EphemeralStorage:  options.EphemeralStorage,
+			Pods:              options.Pods,
+			NvidiaGPUs:        options.NvidiaGPUs,
+			AMDGPUs:           options.AMDGPUs,
+			AWSNeurons:        options.AWSNeurons,
+			HabanaGaudis:      options.HabanaGaudis,
+			AWSPodENI:         options.AWSPodENI,
+			ExtendedResources: options.ExtendedResources,
+			Labels:            options.Labels,
 		},
 	}
 }
@@ -84,17 +91,21 @@ func InstanceTypes(total int) []cloudprovider.InstanceType {
 }
 
 type InstanceTypeOptions struct {
-	Name             string
-	Offerings        []cloudprovider.Offering
-	Architecture     string
-	OperatingSystems sets.String
-	CPU              resource.Quantity
-	Memory           resource.Quantity
-	Pods             resource.Quantity
-	NvidiaGPUs       resource.Quantity
-	AMDGPUs          resource.Quantity
-	AWSNeurons       resource.Quantity
-	AWSPodENI        resource.Quantity
+	Name              string
+	Offerings         []cloudprovider.Offering
+	Architecture      string
+	OperatingSystems  sets.String
+	CPU               resource.Quantity
+	Memory            resource.Quantity
+	EphemeralStorage  resource.Quantity
+	Pods              resource.Quantity
+	NvidiaGPUs        resource.Quantity
+	AMDGPUs           resource.Quantity
+	AWSNeurons        resource.Quantity
+	HabanaGaudis      resource.Quantity
+	AWSPodENI         resource.Quantity
+	ExtendedResources v1.ResourceList
+	Labels            map[string]string
 }
 
 type InstanceType struct {
@@ -105,7 +116,7 @@ func (i *InstanceType) Name() string {
 	return i.options.Name
 }
 
-func (i *InstanceType) Offerings() []cloudprovider.Offering {
+func (i *InstanceType) Offerings() cloudprovider.Offerings {
 	return i.options.Offerings
 }
 
@@ -125,6 +136,10 @@ func (i *InstanceType) Memory() *resource.Quantity {
 	return &i.options.Memory
 }
 
+func (i *InstanceType) EphemeralStorage() *resource.Quantity {
+	return &i.options.EphemeralStorage
+}
+
 func (i *InstanceType) Pods() *resource.Quantity {
 	return &i.options.Pods
 }
@@ -141,13 +156,27 @@ func (i *InstanceType) AWSNeurons() *resource.Quantity {
 	return &i.options.AWSNeurons
 }
 
+func (i *InstanceType) HabanaGaudis() *resource.Quantity {
+	return &i.options.HabanaGaudis
+}
+
 func (i *InstanceType) AWSPodENI() *resource.Quantity {
 	return &i.options.AWSPodENI
 }
 
-func (i *InstanceType) Overhead() v1.ResourceList {
-	return v1.ResourceList{
-		v1.ResourceCPU:    resource.MustParse("100m"),
-		v1.ResourceMemory: resource.MustParse("10Mi"),
+func (i *InstanceType) ExtendedResources() v1.ResourceList {
+	return i.options.ExtendedResources
+}
+
+func (i *InstanceType) Labels() map[string]string {
+	return i.options.Labels
+}
+
+func (i *InstanceType) Overhead() cloudprovider.InstanceTypeOverhead {
+	return cloudprovider.InstanceTypeOverhead{
+		SystemReserved: v1.ResourceList{
+			v1.ResourceCPU:    resource.MustParse("100m"),
+			v1.ResourceMemory: resource.MustParse("10Mi"),
+		},
 	}
 }
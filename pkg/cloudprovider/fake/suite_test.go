@@ -0,0 +1,216 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fake_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/cloudprovider/conformance"
+	"github.com/aws/karpenter/pkg/cloudprovider/fake"
+	"github.com/aws/karpenter/pkg/test"
+	"github.com/aws/karpenter/pkg/utils/resources"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	. "knative.dev/pkg/logging/testing"
+)
+
+var ctx context.Context
+
+func TestFake(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CloudProvider/Fake")
+}
+
+var _ = conformance.Describe(func() conformance.Options {
+	return conformance.Options{
+		Context:       ctx,
+		CloudProvider: &fake.CloudProvider{},
+		Provisioner:   func() *v1alpha5.Provisioner { return &v1alpha5.Provisioner{} },
+	}
+})
+
+var _ = Describe("Hooks", func() {
+	var cloudProvider *fake.CloudProvider
+	var constraints *v1alpha5.Constraints
+	BeforeEach(func() {
+		cloudProvider = &fake.CloudProvider{}
+		constraints = &v1alpha5.Constraints{Requirements: v1alpha5.Requirements{
+			{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-1"}},
+			{Key: v1alpha5.LabelCapacityType, Operator: v1.NodeSelectorOpIn, Values: []string{"on-demand"}},
+		}}
+	})
+	create := func(quantity int) (int, error) {
+		instanceTypes, err := cloudProvider.GetInstanceTypes(ctx, &v1alpha5.Provisioner{})
+		if err != nil {
+			return 0, err
+		}
+		nodes := 0
+		err = cloudProvider.Create(ctx, constraints, instanceTypes, quantity, func(*v1.Node) error {
+			nodes++
+			return nil
+		})
+		return nodes, err
+	}
+	It("should fail calls", func() {
+		cloudProvider.CreateHook = fake.Fail(cloudprovider.NewInsufficientCapacityError(fmt.Errorf("no capacity")))
+		_, err := create(1)
+		Expect(cloudprovider.IsInsufficientCapacityError(err)).To(BeTrue())
+		cloudProvider.GetInstanceTypesHook = fake.Fail(fake.ErrThrottled)
+		_, err = create(1)
+		Expect(err).To(MatchError(fake.ErrThrottled))
+		cloudProvider.DeleteHook = fake.Fail(fake.ErrThrottled)
+		Expect(cloudProvider.Delete(ctx, &v1.Node{})).To(MatchError(fake.ErrThrottled))
+	})
+	It("should fail the first calls", func() {
+		cloudProvider.CreateHook = fake.FailN(2, fake.ErrThrottled)
+		_, err := create(1)
+		Expect(err).To(MatchError(fake.ErrThrottled))
+		_, err = create(1)
+		Expect(err).To(MatchError(fake.ErrThrottled))
+		Expect(create(1)).To(Equal(1))
+	})
+	It("should limit the quantity", func() {
+		cloudProvider.CreateHook = fake.Limit(2)
+		Expect(create(5)).To(Equal(2))
+		Expect(cloudProvider.CallsTo("Create")[0].Quantity).To(Equal(2))
+	})
+	It("should delay calls until the context is done", func() {
+		cloudProvider.DeleteHook = fake.Delay(10 * time.Millisecond)
+		start := time.Now()
+		Expect(cloudProvider.Delete(ctx, &v1.Node{})).To(Succeed())
+		Expect(time.Since(start)).To(BeNumerically(">=", 10*time.Millisecond))
+
+		cloudProvider.DeleteHook = fake.Delay(time.Hour)
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		Expect(cloudProvider.Delete(cancelled, &v1.Node{})).To(MatchError(context.Canceled))
+	})
+	It("should chain hooks until one fails", func() {
+		cloudProvider.CreateHook = fake.Chain(fake.Limit(1), fake.FailN(1, fake.ErrThrottled))
+		_, err := create(3)
+		Expect(err).To(MatchError(fake.ErrThrottled))
+		Expect(create(3)).To(Equal(1))
+	})
+	It("should record calls and reset", func() {
+		cloudProvider.VerifyError = fmt.Errorf("invalid")
+		node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-node"}}
+		Expect(create(2)).To(Equal(2))
+		Expect(cloudProvider.Delete(ctx, node)).To(Succeed())
+		Expect(cloudProvider.Verify(ctx, constraints)).ToNot(Succeed())
+		calls := cloudProvider.Calls()
+		Expect(calls).To(HaveLen(4))
+		Expect(calls[0].Method).To(Equal("GetInstanceTypes"))
+		Expect(calls[1].Method).To(Equal("Create"))
+		Expect(calls[1].Constraints).To(Equal(constraints))
+		Expect(calls[1].Quantity).To(Equal(2))
+		Expect(calls[2].Node).To(Equal(node))
+		Expect(calls[3].Err).To(HaveOccurred())
+
+		cloudProvider.Reset()
+		Expect(cloudProvider.Calls()).To(BeEmpty())
+		Expect(cloudProvider.Verify(ctx, constraints)).To(Succeed())
+	})
+})
+
+var _ = Describe("Drift", func() {
+	var cloudProvider *fake.CloudProvider
+	BeforeEach(func() {
+		cloudProvider = &fake.CloudProvider{}
+	})
+	It("should return the node's drift reason", func() {
+		cloudProvider.DriftReasons = map[string]string{"test-node": "test-reason"}
+		Expect(cloudProvider.IsDrifted(ctx, &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-node"}}, &v1alpha5.Provisioner{})).To(Equal("test-reason"))
+		Expect(cloudProvider.IsDrifted(ctx, &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "other-node"}}, &v1alpha5.Provisioner{})).To(BeEmpty())
+		Expect(cloudProvider.CallsTo("IsDrifted")).To(HaveLen(2))
+	})
+	It("should return the hook's error", func() {
+		cloudProvider.IsDriftedHook = fake.Fail(fake.ErrThrottled)
+		_, err := cloudProvider.IsDrifted(ctx, &v1.Node{}, &v1alpha5.Provisioner{})
+		Expect(err).To(MatchError(fake.ErrThrottled))
+	})
+})
+
+var _ = Describe("Capabilities", func() {
+	var capabilities cloudprovider.Capabilities
+	BeforeEach(func() {
+		capabilities = cloudprovider.Capabilities{
+			CapacityTypes: sets.NewString("on-demand"),
+			Architectures: sets.NewString(v1alpha5.ArchitectureAmd64),
+		}
+	})
+	Context("Validate", func() {
+		It("should allow supported values", func() {
+			Expect(capabilities.Validate(&v1alpha5.Constraints{
+				Labels: map[string]string{v1.LabelArchStable: v1alpha5.ArchitectureAmd64},
+				Requirements: v1alpha5.Requirements{
+					{Key: v1alpha5.LabelCapacityType, Operator: v1.NodeSelectorOpIn, Values: []string{"on-demand"}},
+					{Key: v1alpha5.LabelCapacityType, Operator: v1.NodeSelectorOpNotIn, Values: []string{"spot"}},
+					{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-1"}},
+				},
+			})).To(Succeed())
+		})
+		It("should not allow unsupported requirements", func() {
+			err := capabilities.Validate(&v1alpha5.Constraints{Requirements: v1alpha5.Requirements{
+				{Key: v1alpha5.LabelCapacityType, Operator: v1.NodeSelectorOpIn, Values: []string{"on-demand", "spot"}},
+			}})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("karpenter.sh/capacity-type spot is not supported by this cloud provider"))
+			Expect(err.Error()).To(ContainSubstring("requirements[0].values[1]"))
+		})
+		It("should not allow unsupported labels", func() {
+			err := capabilities.Validate(&v1alpha5.Constraints{Labels: map[string]string{v1.LabelArchStable: v1alpha5.ArchitectureArm64}})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("kubernetes.io/arch arm64 is not supported by this cloud provider"))
+		})
+		It("should allow anything if unrestricted", func() {
+			Expect(cloudprovider.Capabilities{}.Validate(&v1alpha5.Constraints{Requirements: v1alpha5.Requirements{
+				{Key: v1alpha5.LabelCapacityType, Operator: v1.NodeSelectorOpIn, Values: []string{"spot"}},
+			}})).To(Succeed())
+		})
+	})
+	Context("ValidatePod", func() {
+		It("should allow pods without requirements", func() {
+			Expect(capabilities.ValidatePod(test.UnschedulablePod())).To(Succeed())
+		})
+		It("should not allow pods that request unsupported accelerators", func() {
+			pod := test.UnschedulablePod(test.PodOptions{
+				ResourceRequirements: v1.ResourceRequirements{Limits: v1.ResourceList{resources.NvidiaGPU: resource.MustParse("1")}},
+			})
+			Expect(capabilities.ValidatePod(pod)).ToNot(Succeed())
+			capabilities.Accelerators = true
+			Expect(capabilities.ValidatePod(pod)).To(Succeed())
+		})
+		It("should not allow pods that only allow unsupported values", func() {
+			Expect(capabilities.ValidatePod(test.UnschedulablePod(test.PodOptions{
+				NodeSelector: map[string]string{v1alpha5.LabelCapacityType: "spot"},
+			}))).ToNot(Succeed())
+			Expect(capabilities.ValidatePod(test.UnschedulablePod(test.PodOptions{
+				NodeRequirements: []v1.NodeSelectorRequirement{
+					{Key: v1.LabelArchStable, Operator: v1.NodeSelectorOpIn, Values: []string{v1alpha5.ArchitectureArm64, v1alpha5.ArchitectureAmd64}},
+				},
+			}))).To(Succeed())
+		})
+	})
+})
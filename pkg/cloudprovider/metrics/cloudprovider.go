@@ -76,9 +76,9 @@ func (d *decorator) Delete(ctx context.Context, node *v1.Node) error {
 	return d.CloudProvider.Delete(ctx, node)
 }
 
-func (d *decorator) GetInstanceTypes(ctx context.Context, constraints *v1alpha5.Constraints) ([]cloudprovider.InstanceType, error) {
+func (d *decorator) GetInstanceTypes(ctx context.Context, provisioner *v1alpha5.Provisioner) ([]cloudprovider.InstanceType, error) {
 	defer metrics.Measure(methodDurationHistogramVec.WithLabelValues(getControllerName(ctx), "GetInstanceTypes", d.Name()))()
-	return d.CloudProvider.GetInstanceTypes(ctx, constraints)
+	return d.CloudProvider.GetInstanceTypes(ctx, provisioner)
 }
 
 func (d *decorator) Default(ctx context.Context, constraints *v1alpha5.Constraints) {
@@ -91,6 +91,16 @@ func (d *decorator) Validate(ctx context.Context, constraints *v1alpha5.Constrai
 	return d.CloudProvider.Validate(ctx, constraints)
 }
 
+func (d *decorator) Verify(ctx context.Context, constraints *v1alpha5.Constraints) error {
+	defer metrics.Measure(methodDurationHistogramVec.WithLabelValues(getControllerName(ctx), "Verify", d.Name()))()
+	return d.CloudProvider.Verify(ctx, constraints)
+}
+
+func (d *decorator) IsDrifted(ctx context.Context, node *v1.Node, provisioner *v1alpha5.Provisioner) (string, error) {
+	defer metrics.Measure(methodDurationHistogramVec.WithLabelValues(getControllerName(ctx), "IsDrifted", d.Name()))()
+	return d.CloudProvider.IsDrifted(ctx, node, provisioner)
+}
+
 func getControllerName(ctx context.Context) string {
 	name := injection.GetControllerName(ctx)
 	if name == "" {
@@ -0,0 +1,211 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/cloudprovider/plugin/v1alpha1"
+	"github.com/aws/karpenter/pkg/utils/injection"
+	"go.uber.org/multierr"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"knative.dev/pkg/apis"
+	"knative.dev/pkg/logging"
+)
+
+// ProtocolVersion is the version of the plugin protocol, which is defined by
+// v1alpha1/cloudprovider.proto. Plugins must implement the same version.
+const ProtocolVersion = "v1alpha1"
+
+// CloudProvider implements cloudprovider.CloudProvider by calling a plugin
+// that serves it in another process.
+type CloudProvider struct {
+	client       v1alpha1.CloudProviderClient
+	name         string
+	capabilities cloudprovider.Capabilities
+}
+
+// Dial connects to a plugin at the address, e.g.
+// unix:///var/run/karpenter/cloudprovider.sock. Connections aren't encrypted,
+// so plugins are expected to run alongside the controller, e.g. as a sidecar.
+func Dial(ctx context.Context, address string) (*CloudProvider, error) {
+	conn, err := grpc.DialContext(ctx, address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, fmt.Errorf("connecting to cloud provider plugin %s, %w", address, err)
+	}
+	return NewCloudProvider(ctx, conn)
+}
+
+// NewCloudProvider returns a cloud provider that calls the plugin over the
+// connection. The plugin's name and capabilities are retrieved once, waiting
+// until the plugin is ready or the context is done, e.g. while a sidecar
+// starts. Plugins that implement another version of the protocol are
+// rejected.
+func NewCloudProvider(ctx context.Context, conn grpc.ClientConnInterface) (*CloudProvider, error) {
+	c := &CloudProvider{client: v1alpha1.NewCloudProviderClient(conn)}
+	response, err := c.client.Name(ctx, &v1alpha1.NameRequest{ProtocolVersion: ProtocolVersion}, grpc.WaitForReady(true))
+	if err != nil {
+		return nil, fmt.Errorf("getting cloud provider plugin name, %w", fromStatus(err))
+	}
+	if response.ProtocolVersion != ProtocolVersion {
+		return nil, fmt.Errorf("cloud provider plugin %s implements protocol version %q, expected %s", response.Name, response.ProtocolVersion, ProtocolVersion)
+	}
+	c.name = response.Name
+	capabilities, err := c.getCapabilities(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting cloud provider plugin capabilities, %w", err)
+	}
+	c.capabilities = capabilities
+	return c, nil
+}
+
+// getCapabilities of the plugin. Plugins that predate capabilities are
+// assumed to support anything, as they were before.
+func (c *CloudProvider) getCapabilities(ctx context.Context) (cloudprovider.Capabilities, error) {
+	response, err := c.client.Capabilities(ctx, &v1alpha1.CapabilitiesRequest{})
+	if err != nil {
+		if status.Code(err) == codes.Unimplemented {
+			return cloudprovider.Capabilities{Accelerators: true}, nil
+		}
+		return cloudprovider.Capabilities{}, fromStatus(err)
+	}
+	capabilities := cloudprovider.Capabilities{Accelerators: response.Accelerators, MaxPodsSource: cloudprovider.MaxPodsSource(response.MaxPodsSource)}
+	if len(response.CapacityTypes) > 0 {
+		capabilities.CapacityTypes = sets.NewString(response.CapacityTypes...)
+	}
+	if len(response.Architectures) > 0 {
+		capabilities.Architectures = sets.NewString(response.Architectures...)
+	}
+	return capabilities, nil
+}
+
+func (c *CloudProvider) Create(ctx context.Context, constraints *v1alpha5.Constraints, instanceTypes []cloudprovider.InstanceType, quantity int, bind func(*v1.Node) error) error {
+	stream, err := c.client.Create(ctx, &v1alpha1.CreateRequest{
+		Constraints:   encodeConstraints(constraints),
+		InstanceTypes: encodeInstanceTypes(instanceTypes),
+		Quantity:      int32(quantity),
+		LaunchId:      injection.GetLaunchID(ctx),
+		Requests:      encodeResourceList(injection.GetRequests(ctx)),
+	})
+	if err != nil {
+		return fromStatus(err)
+	}
+	// Nodes are bound as the plugin creates them, and binding errors are
+	// returned once it has finished, as they would be in process
+	var errs error
+	for {
+		response, err := stream.Recv()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				errs = multierr.Append(errs, fromStatus(err))
+			}
+			return errs
+		}
+		node, err := decodeNode(response.Node)
+		if err != nil {
+			errs = multierr.Append(errs, fmt.Errorf("decoding node, %w", err))
+			continue
+		}
+		errs = multierr.Append(errs, bind(node))
+	}
+}
+
+func (c *CloudProvider) Delete(ctx context.Context, node *v1.Node) error {
+	_, err := c.client.Delete(ctx, &v1alpha1.DeleteRequest{Node: encodeNode(node)})
+	return fromStatus(err)
+}
+
+func (c *CloudProvider) GetInstanceTypes(ctx context.Context, provisioner *v1alpha5.Provisioner) ([]cloudprovider.InstanceType, error) {
+	response, err := c.client.GetInstanceTypes(ctx, &v1alpha1.GetInstanceTypesRequest{Provisioner: encodeProvisioner(provisioner)})
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+	return decodeInstanceTypes(response.InstanceTypes)
+}
+
+func (c *CloudProvider) Default(ctx context.Context, constraints *v1alpha5.Constraints) {
+	response, err := c.client.Default(ctx, &v1alpha1.DefaultRequest{Constraints: encodeConstraints(constraints)})
+	if err != nil {
+		logging.FromContext(ctx).Errorf("Defaulting constraints with cloud provider plugin, %s", fromStatus(err).Error())
+		return
+	}
+	if response.Constraints == nil {
+		return
+	}
+	defaulted, err := decodeConstraints(response.Constraints)
+	if err != nil {
+		logging.FromContext(ctx).Errorf("Decoding constraints defaulted by cloud provider plugin, %s", err.Error())
+		return
+	}
+	*constraints = *defaulted
+}
+
+func (c *CloudProvider) Validate(ctx context.Context, constraints *v1alpha5.Constraints) *apis.FieldError {
+	response, err := c.client.Validate(ctx, &v1alpha1.ValidateRequest{Constraints: encodeConstraints(constraints)})
+	if err != nil {
+		return apis.ErrGeneric(fmt.Sprintf("validating constraints with cloud provider plugin, %s", fromStatus(err).Error()))
+	}
+	if response.Error != "" {
+		return apis.ErrGeneric(response.Error)
+	}
+	return nil
+}
+
+func (c *CloudProvider) Verify(ctx context.Context, constraints *v1alpha5.Constraints) error {
+	response, err := c.client.Verify(ctx, &v1alpha1.VerifyRequest{Constraints: encodeConstraints(constraints)})
+	if err != nil {
+		return fromStatus(err)
+	}
+	if response.Error == "" {
+		return nil
+	}
+	if response.Reason != "" {
+		return cloudprovider.NewConfigurationError(response.Reason, errors.New(response.Error))
+	}
+	return errors.New(response.Error)
+}
+
+// IsDrifted returns the reason that the plugin reports the node as drifted.
+// Plugins that predate drift never report nodes as drifted.
+func (c *CloudProvider) IsDrifted(ctx context.Context, node *v1.Node, provisioner *v1alpha5.Provisioner) (string, error) {
+	response, err := c.client.IsDrifted(ctx, &v1alpha1.IsDriftedRequest{Node: encodeNode(node), Provisioner: encodeProvisioner(provisioner)})
+	if err != nil {
+		if status.Code(err) == codes.Unimplemented {
+			return "", nil
+		}
+		return "", fromStatus(err)
+	}
+	return response.Reason, nil
+}
+
+// Capabilities returns the plugin's capabilities
+func (c *CloudProvider) Capabilities() cloudprovider.Capabilities {
+	return c.capabilities
+}
+
+// Name returns the plugin's cloud provider implementation name.
+func (c *CloudProvider) Name() string {
+	return c.name
+}
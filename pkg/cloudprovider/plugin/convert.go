@@ -0,0 +1,217 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"fmt"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider/plugin/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// The protocol's messages are converted to and from the types of the
+// CloudProvider interface at the process boundary. Decoding fails if a
+// quantity can't be parsed.
+
+func encodeProvisioner(provisioner *v1alpha5.Provisioner) *v1alpha1.Provisioner {
+	if provisioner == nil {
+		return nil
+	}
+	return &v1alpha1.Provisioner{Name: provisioner.Name, Constraints: encodeConstraints(&provisioner.Spec.Constraints)}
+}
+
+func decodeProvisioner(message *v1alpha1.Provisioner) (*v1alpha5.Provisioner, error) {
+	constraints, err := decodeConstraints(message.GetConstraints())
+	if err != nil {
+		return nil, err
+	}
+	return &v1alpha5.Provisioner{
+		ObjectMeta: metav1.ObjectMeta{Name: message.GetName()},
+		Spec:       v1alpha5.ProvisionerSpec{Constraints: *constraints},
+	}, nil
+}
+
+func encodeConstraints(constraints *v1alpha5.Constraints) *v1alpha1.Constraints {
+	if constraints == nil {
+		return nil
+	}
+	message := &v1alpha1.Constraints{
+		Labels:         constraints.Labels,
+		LabelTemplates: constraints.LabelTemplates,
+		Taints:         encodeTaints(constraints.Taints),
+		StartupTaints:  encodeTaints(constraints.StartupTaints),
+		KubeletConfiguration: &v1alpha1.KubeletConfiguration{
+			ClusterDns:     constraints.KubeletConfiguration.ClusterDNS,
+			MaxPods:        constraints.KubeletConfiguration.MaxPods,
+			KubeReserved:   encodeResourceList(constraints.KubeletConfiguration.KubeReserved),
+			SystemReserved: encodeResourceList(constraints.KubeletConfiguration.SystemReserved),
+			EvictionHard:   constraints.KubeletConfiguration.EvictionHard,
+		},
+	}
+	for _, requirement := range constraints.Requirements {
+		message.Requirements = append(message.Requirements, &v1alpha1.Requirement{
+			Key:      requirement.Key,
+			Operator: string(requirement.Operator),
+			Values:   requirement.Values,
+		})
+	}
+	if constraints.Provider != nil {
+		message.Provider = constraints.Provider.Raw
+	}
+	if ref := constraints.ProviderRef; ref != nil {
+		message.ProviderRef = &v1alpha1.ProviderRef{ApiVersion: ref.APIVersion, Kind: ref.Kind, Name: ref.Name}
+	}
+	return message
+}
+
+func decodeConstraints(message *v1alpha1.Constraints) (*v1alpha5.Constraints, error) {
+	kubeReserved, err := decodeResourceList(message.GetKubeletConfiguration().GetKubeReserved())
+	if err != nil {
+		return nil, err
+	}
+	systemReserved, err := decodeResourceList(message.GetKubeletConfiguration().GetSystemReserved())
+	if err != nil {
+		return nil, err
+	}
+	constraints := &v1alpha5.Constraints{
+		Labels:         message.GetLabels(),
+		LabelTemplates: message.GetLabelTemplates(),
+		Taints:         decodeTaints(message.GetTaints()),
+		StartupTaints:  decodeTaints(message.GetStartupTaints()),
+		KubeletConfiguration: v1alpha5.KubeletConfiguration{
+			ClusterDNS:     message.GetKubeletConfiguration().GetClusterDns(),
+			MaxPods:        message.GetKubeletConfiguration().MaxPods,
+			KubeReserved:   kubeReserved,
+			SystemReserved: systemReserved,
+			EvictionHard:   message.GetKubeletConfiguration().GetEvictionHard(),
+		},
+	}
+	for _, requirement := range message.GetRequirements() {
+		constraints.Requirements = append(constraints.Requirements, v1.NodeSelectorRequirement{
+			Key:      requirement.GetKey(),
+			Operator: v1.NodeSelectorOperator(requirement.GetOperator()),
+			Values:   requirement.GetValues(),
+		})
+	}
+	if len(message.GetProvider()) > 0 {
+		constraints.Provider = &runtime.RawExtension{Raw: message.GetProvider()}
+	}
+	if ref := message.GetProviderRef(); ref != nil {
+		constraints.ProviderRef = &v1alpha5.ProviderRef{APIVersion: ref.GetApiVersion(), Kind: ref.GetKind(), Name: ref.GetName()}
+	}
+	return constraints, nil
+}
+
+func encodeTaints(taints []v1.Taint) []*v1alpha1.Taint {
+	var messages []*v1alpha1.Taint
+	for _, taint := range taints {
+		messages = append(messages, &v1alpha1.Taint{Key: taint.Key, Value: taint.Value, Effect: string(taint.Effect)})
+	}
+	return messages
+}
+
+func decodeTaints(messages []*v1alpha1.Taint) []v1.Taint {
+	var taints []v1.Taint
+	for _, message := range messages {
+		taints = append(taints, v1.Taint{Key: message.GetKey(), Value: message.GetValue(), Effect: v1.TaintEffect(message.GetEffect())})
+	}
+	return taints
+}
+
+func encodeNode(node *v1.Node) *v1alpha1.Node {
+	return &v1alpha1.Node{
+		Name:            node.Name,
+		Labels:          node.Labels,
+		Annotations:     node.Annotations,
+		ProviderId:      node.Spec.ProviderID,
+		Taints:          encodeTaints(node.Spec.Taints),
+		Unschedulable:   node.Spec.Unschedulable,
+		Architecture:    node.Status.NodeInfo.Architecture,
+		OperatingSystem: node.Status.NodeInfo.OperatingSystem,
+		OsImage:         node.Status.NodeInfo.OSImage,
+		Capacity:        encodeResourceList(node.Status.Capacity),
+		Allocatable:     encodeResourceList(node.Status.Allocatable),
+	}
+}
+
+func decodeNode(message *v1alpha1.Node) (*v1.Node, error) {
+	capacity, err := decodeResourceList(message.GetCapacity())
+	if err != nil {
+		return nil, err
+	}
+	allocatable, err := decodeResourceList(message.GetAllocatable())
+	if err != nil {
+		return nil, err
+	}
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        message.GetName(),
+			Labels:      message.GetLabels(),
+			Annotations: message.GetAnnotations(),
+		},
+		Spec: v1.NodeSpec{
+			ProviderID:    message.GetProviderId(),
+			Taints:        decodeTaints(message.GetTaints()),
+			Unschedulable: message.GetUnschedulable(),
+		},
+		Status: v1.NodeStatus{
+			NodeInfo: v1.NodeSystemInfo{
+				Architecture:    message.GetArchitecture(),
+				OperatingSystem: message.GetOperatingSystem(),
+				OSImage:         message.GetOsImage(),
+			},
+			Capacity:    capacity,
+			Allocatable: allocatable,
+		},
+	}, nil
+}
+
+func encodeResourceList(resources v1.ResourceList) map[string]string {
+	if resources == nil {
+		return nil
+	}
+	message := map[string]string{}
+	for name, quantity := range resources {
+		message[string(name)] = quantity.String()
+	}
+	return message
+}
+
+func decodeResourceList(message map[string]string) (v1.ResourceList, error) {
+	if message == nil {
+		return nil, nil
+	}
+	resources := v1.ResourceList{}
+	for name, value := range message {
+		quantity, err := decodeQuantity(value)
+		if err != nil {
+			return nil, fmt.Errorf("decoding %s, %w", name, err)
+		}
+		resources[v1.ResourceName(name)] = quantity
+	}
+	return resources, nil
+}
+
+// decodeQuantity parses the quantity, which is zero if it's omitted
+func decodeQuantity(value string) (resource.Quantity, error) {
+	if value == "" {
+		return resource.Quantity{}, nil
+	}
+	return resource.ParseQuantity(value)
+}
@@ -0,0 +1,206 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"fmt"
+
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/cloudprovider/plugin/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// InstanceType is a cloudprovider.InstanceType that was received from a plugin
+type InstanceType struct {
+	name              string
+	offerings         cloudprovider.Offerings
+	architecture      string
+	operatingSystems  sets.String
+	cpu               resource.Quantity
+	memory            resource.Quantity
+	ephemeralStorage  resource.Quantity
+	pods              resource.Quantity
+	nvidiaGPUs        resource.Quantity
+	amdGPUs           resource.Quantity
+	awsNeurons        resource.Quantity
+	habanaGaudis      resource.Quantity
+	awsPodENI         resource.Quantity
+	extendedResources v1.ResourceList
+	overhead          cloudprovider.InstanceTypeOverhead
+	labels            map[string]string
+}
+
+func (i *InstanceType) Name() string {
+	return i.name
+}
+
+func (i *InstanceType) Offerings() cloudprovider.Offerings {
+	return i.offerings
+}
+
+func (i *InstanceType) Architecture() string {
+	return i.architecture
+}
+
+func (i *InstanceType) OperatingSystems() sets.String {
+	return i.operatingSystems
+}
+
+func (i *InstanceType) CPU() *resource.Quantity {
+	return &i.cpu
+}
+
+func (i *InstanceType) Memory() *resource.Quantity {
+	return &i.memory
+}
+
+func (i *InstanceType) EphemeralStorage() *resource.Quantity {
+	return &i.ephemeralStorage
+}
+
+func (i *InstanceType) Pods() *resource.Quantity {
+	return &i.pods
+}
+
+func (i *InstanceType) NvidiaGPUs() *resource.Quantity {
+	return &i.nvidiaGPUs
+}
+
+func (i *InstanceType) AMDGPUs() *resource.Quantity {
+	return &i.amdGPUs
+}
+
+func (i *InstanceType) AWSNeurons() *resource.Quantity {
+	return &i.awsNeurons
+}
+
+func (i *InstanceType) HabanaGaudis() *resource.Quantity {
+	return &i.habanaGaudis
+}
+
+func (i *InstanceType) AWSPodENI() *resource.Quantity {
+	return &i.awsPodENI
+}
+
+func (i *InstanceType) ExtendedResources() v1.ResourceList {
+	return i.extendedResources
+}
+
+func (i *InstanceType) Overhead() cloudprovider.InstanceTypeOverhead {
+	return i.overhead
+}
+
+func (i *InstanceType) Labels() map[string]string {
+	return i.labels
+}
+
+func encodeInstanceType(instanceType cloudprovider.InstanceType) *v1alpha1.InstanceType {
+	message := &v1alpha1.InstanceType{
+		Name:              instanceType.Name(),
+		Architecture:      instanceType.Architecture(),
+		OperatingSystems:  instanceType.OperatingSystems().List(),
+		Cpu:               instanceType.CPU().String(),
+		Memory:            instanceType.Memory().String(),
+		EphemeralStorage:  instanceType.EphemeralStorage().String(),
+		Pods:              instanceType.Pods().String(),
+		NvidiaGpus:        instanceType.NvidiaGPUs().String(),
+		AmdGpus:           instanceType.AMDGPUs().String(),
+		AwsNeurons:        instanceType.AWSNeurons().String(),
+		HabanaGaudis:      instanceType.HabanaGaudis().String(),
+		AwsPodEni:         instanceType.AWSPodENI().String(),
+		ExtendedResources: encodeResourceList(instanceType.ExtendedResources()),
+		Overhead: &v1alpha1.Overhead{
+			KubeReserved:      encodeResourceList(instanceType.Overhead().KubeReserved),
+			SystemReserved:    encodeResourceList(instanceType.Overhead().SystemReserved),
+			EvictionThreshold: encodeResourceList(instanceType.Overhead().EvictionThreshold),
+		},
+		Labels: instanceType.Labels(),
+	}
+	for _, offering := range instanceType.Offerings() {
+		message.Offerings = append(message.Offerings, &v1alpha1.Offering{CapacityType: offering.CapacityType, Zone: offering.Zone, Price: offering.Price})
+	}
+	return message
+}
+
+func decodeInstanceType(message *v1alpha1.InstanceType) (*InstanceType, error) {
+	instanceType := &InstanceType{
+		name:             message.GetName(),
+		architecture:     message.GetArchitecture(),
+		operatingSystems: sets.NewString(message.GetOperatingSystems()...),
+		labels:           message.GetLabels(),
+	}
+	for _, offering := range message.GetOfferings() {
+		instanceType.offerings = append(instanceType.offerings, cloudprovider.Offering{
+			CapacityType: offering.GetCapacityType(),
+			Zone:         offering.GetZone(),
+			Price:        offering.GetPrice(),
+		})
+	}
+	var err error
+	for _, quantity := range []struct {
+		field *resource.Quantity
+		value string
+	}{
+		{&instanceType.cpu, message.GetCpu()},
+		{&instanceType.memory, message.GetMemory()},
+		{&instanceType.ephemeralStorage, message.GetEphemeralStorage()},
+		{&instanceType.pods, message.GetPods()},
+		{&instanceType.nvidiaGPUs, message.GetNvidiaGpus()},
+		{&instanceType.amdGPUs, message.GetAmdGpus()},
+		{&instanceType.awsNeurons, message.GetAwsNeurons()},
+		{&instanceType.habanaGaudis, message.GetHabanaGaudis()},
+		{&instanceType.awsPodENI, message.GetAwsPodEni()},
+	} {
+		if *quantity.field, err = decodeQuantity(quantity.value); err != nil {
+			return nil, fmt.Errorf("decoding instance type %s, %w", message.GetName(), err)
+		}
+	}
+	if instanceType.extendedResources, err = decodeResourceList(message.GetExtendedResources()); err != nil {
+		return nil, fmt.Errorf("decoding instance type %s, %w", message.GetName(), err)
+	}
+	overhead := message.GetOverhead()
+	if instanceType.overhead.KubeReserved, err = decodeResourceList(overhead.GetKubeReserved()); err != nil {
+		return nil, fmt.Errorf("decoding instance type %s, %w", message.GetName(), err)
+	}
+	if instanceType.overhead.SystemReserved, err = decodeResourceList(overhead.GetSystemReserved()); err != nil {
+		return nil, fmt.Errorf("decoding instance type %s, %w", message.GetName(), err)
+	}
+	if instanceType.overhead.EvictionThreshold, err = decodeResourceList(overhead.GetEvictionThreshold()); err != nil {
+		return nil, fmt.Errorf("decoding instance type %s, %w", message.GetName(), err)
+	}
+	return instanceType, nil
+}
+
+func encodeInstanceTypes(instanceTypes []cloudprovider.InstanceType) []*v1alpha1.InstanceType {
+	messages := []*v1alpha1.InstanceType{}
+	for _, instanceType := range instanceTypes {
+		messages = append(messages, encodeInstanceType(instanceType))
+	}
+	return messages
+}
+
+func decodeInstanceTypes(messages []*v1alpha1.InstanceType) ([]cloudprovider.InstanceType, error) {
+	instanceTypes := []cloudprovider.InstanceType{}
+	for _, message := range messages {
+		instanceType, err := decodeInstanceType(message)
+		if err != nil {
+			return nil, err
+		}
+		instanceTypes = append(instanceTypes, instanceType)
+	}
+	return instanceTypes, nil
+}
@@ -0,0 +1,211 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/cloudprovider/plugin/v1alpha1"
+	"github.com/aws/karpenter/pkg/utils/injection"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+)
+
+// Register serves the cloud provider's implementation of the protocol with
+// the server, so that Karpenter can use it as a plugin.
+func Register(registrar grpc.ServiceRegistrar, cloudProvider cloudprovider.CloudProvider) {
+	v1alpha1.RegisterCloudProviderServer(registrar, &server{cloudProvider: cloudProvider})
+}
+
+type server struct {
+	v1alpha1.UnimplementedCloudProviderServer
+	cloudProvider cloudprovider.CloudProvider
+}
+
+func (s *server) Name(context.Context, *v1alpha1.NameRequest) (*v1alpha1.NameResponse, error) {
+	return &v1alpha1.NameResponse{Name: s.cloudProvider.Name(), ProtocolVersion: ProtocolVersion}, nil
+}
+
+func (s *server) Capabilities(context.Context, *v1alpha1.CapabilitiesRequest) (*v1alpha1.CapabilitiesResponse, error) {
+	capabilities := s.cloudProvider.Capabilities()
+	return &v1alpha1.CapabilitiesResponse{
+		CapacityTypes: capabilities.CapacityTypes.List(),
+		Architectures: capabilities.Architectures.List(),
+		Accelerators:  capabilities.Accelerators,
+		MaxPodsSource: string(capabilities.MaxPodsSource),
+	}, nil
+}
+
+func (s *server) Create(request *v1alpha1.CreateRequest, stream v1alpha1.CloudProvider_CreateServer) error {
+	constraints, err := decodeConstraints(request.Constraints)
+	if err != nil {
+		return invalid(err)
+	}
+	requested, err := decodeInstanceTypes(request.InstanceTypes)
+	if err != nil {
+		return invalid(err)
+	}
+	requests, err := decodeResourceList(request.Requests)
+	if err != nil {
+		return invalid(err)
+	}
+	ctx := injection.WithLaunchID(injection.WithRequests(stream.Context(), requests), request.LaunchId)
+	instanceTypes := resolve(ctx, s.cloudProvider, constraints, requested)
+	return toStatus(s.cloudProvider.Create(ctx, constraints, instanceTypes, int(request.Quantity), func(node *v1.Node) error {
+		return stream.Send(&v1alpha1.CreateResponse{Node: encodeNode(node)})
+	}))
+}
+
+// resolve returns the cloud provider's own instance types of the given names,
+// so that it may rely on their concrete types. The instance types described by
+// the request are used instead if the cloud provider's can't be retrieved.
+func resolve(ctx context.Context, cloudProvider cloudprovider.CloudProvider, constraints *v1alpha5.Constraints, requested []cloudprovider.InstanceType) []cloudprovider.InstanceType {
+	provisioner := &v1alpha5.Provisioner{Spec: v1alpha5.ProvisionerSpec{Constraints: *constraints}}
+	if name, ok := constraints.Labels[v1alpha5.ProvisionerNameLabelKey]; ok {
+		provisioner.Name = name
+	}
+	supported, err := cloudProvider.GetInstanceTypes(ctx, provisioner)
+	if err != nil {
+		return requested
+	}
+	byName := map[string]cloudprovider.InstanceType{}
+	for _, instanceType := range supported {
+		byName[instanceType.Name()] = instanceType
+	}
+	resolved := []cloudprovider.InstanceType{}
+	for _, instanceType := range requested {
+		if supported, ok := byName[instanceType.Name()]; ok {
+			resolved = append(resolved, supported)
+		} else {
+			resolved = append(resolved, instanceType)
+		}
+	}
+	return resolved
+}
+
+func (s *server) Delete(ctx context.Context, request *v1alpha1.DeleteRequest) (*v1alpha1.DeleteResponse, error) {
+	node, err := decodeNode(request.Node)
+	if err != nil {
+		return nil, invalid(err)
+	}
+	if err := s.cloudProvider.Delete(ctx, node); err != nil {
+		return nil, toStatus(err)
+	}
+	return &v1alpha1.DeleteResponse{}, nil
+}
+
+func (s *server) GetInstanceTypes(ctx context.Context, request *v1alpha1.GetInstanceTypesRequest) (*v1alpha1.GetInstanceTypesResponse, error) {
+	provisioner, err := decodeProvisioner(request.Provisioner)
+	if err != nil {
+		return nil, invalid(err)
+	}
+	instanceTypes, err := s.cloudProvider.GetInstanceTypes(ctx, provisioner)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &v1alpha1.GetInstanceTypesResponse{InstanceTypes: encodeInstanceTypes(instanceTypes)}, nil
+}
+
+func (s *server) Default(ctx context.Context, request *v1alpha1.DefaultRequest) (*v1alpha1.DefaultResponse, error) {
+	constraints, err := decodeConstraints(request.Constraints)
+	if err != nil {
+		return nil, invalid(err)
+	}
+	s.cloudProvider.Default(ctx, constraints)
+	return &v1alpha1.DefaultResponse{Constraints: encodeConstraints(constraints)}, nil
+}
+
+func (s *server) Validate(ctx context.Context, request *v1alpha1.ValidateRequest) (*v1alpha1.ValidateResponse, error) {
+	constraints, err := decodeConstraints(request.Constraints)
+	if err != nil {
+		return nil, invalid(err)
+	}
+	response := &v1alpha1.ValidateResponse{}
+	if err := s.cloudProvider.Validate(ctx, constraints); err != nil {
+		response.Error = err.Error()
+	}
+	return response, nil
+}
+
+func (s *server) Verify(ctx context.Context, request *v1alpha1.VerifyRequest) (*v1alpha1.VerifyResponse, error) {
+	constraints, err := decodeConstraints(request.Constraints)
+	if err != nil {
+		return nil, invalid(err)
+	}
+	response := &v1alpha1.VerifyResponse{}
+	if err := s.cloudProvider.Verify(ctx, constraints); err != nil {
+		response.Error = err.Error()
+		response.Reason, _ = cloudprovider.ConfigurationErrorReason(err)
+	}
+	return response, nil
+}
+
+func (s *server) IsDrifted(ctx context.Context, request *v1alpha1.IsDriftedRequest) (*v1alpha1.IsDriftedResponse, error) {
+	node, err := decodeNode(request.Node)
+	if err != nil {
+		return nil, invalid(err)
+	}
+	provisioner, err := decodeProvisioner(request.Provisioner)
+	if err != nil {
+		return nil, invalid(err)
+	}
+	reason, err := s.cloudProvider.IsDrifted(ctx, node, provisioner)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &v1alpha1.IsDriftedResponse{Reason: reason}, nil
+}
+
+// invalid reports a request that couldn't be decoded
+func invalid(err error) error {
+	return status.Error(codes.InvalidArgument, err.Error())
+}
+
+// toStatus converts errors that callers of the cloud provider distinguish to
+// status codes, so that the client can convert them back.
+func toStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	if cloudprovider.IsInsufficientCapacityError(err) {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	return status.Error(codes.Unknown, err.Error())
+}
+
+// fromStatus is the inverse of toStatus
+func fromStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	s, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	switch s.Code() {
+	case codes.ResourceExhausted:
+		return cloudprovider.NewInsufficientCapacityError(errors.New(strings.TrimPrefix(s.Message(), "insufficient capacity, ")))
+	case codes.Unknown:
+		return errors.New(s.Message())
+	default:
+		return err
+	}
+}
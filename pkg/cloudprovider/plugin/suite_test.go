@@ -0,0 +1,248 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/cloudprovider/conformance"
+	"github.com/aws/karpenter/pkg/cloudprovider/fake"
+	"github.com/aws/karpenter/pkg/cloudprovider/plugin"
+	"github.com/aws/karpenter/pkg/cloudprovider/plugin/v1alpha1"
+	"github.com/aws/karpenter/pkg/utils/injection"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"knative.dev/pkg/apis"
+	. "knative.dev/pkg/logging/testing"
+)
+
+var ctx context.Context
+var server *grpc.Server
+var fakeCloudProvider *validatingCloudProvider
+var cloudProvider *plugin.CloudProvider
+
+func TestPlugin(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CloudProvider/Plugin")
+}
+
+// validatingCloudProvider rejects constraints without labels and defaults
+// their taints, so that the hooks' results can be observed through the plugin.
+// It records the context values of the last launch.
+type validatingCloudProvider struct {
+	fake.CloudProvider
+	launchID string
+	requests v1.ResourceList
+}
+
+func (c *validatingCloudProvider) Create(ctx context.Context, constraints *v1alpha5.Constraints, instanceTypes []cloudprovider.InstanceType, quantity int, bind func(*v1.Node) error) error {
+	c.launchID = injection.GetLaunchID(ctx)
+	c.requests = injection.GetRequests(ctx)
+	return c.CloudProvider.Create(ctx, constraints, instanceTypes, quantity, bind)
+}
+
+func (c *validatingCloudProvider) Default(_ context.Context, constraints *v1alpha5.Constraints) {
+	constraints.Taints = append(constraints.Taints, v1.Taint{Key: "defaulted", Effect: v1.TaintEffectNoSchedule})
+}
+
+func (c *validatingCloudProvider) Validate(_ context.Context, constraints *v1alpha5.Constraints) *apis.FieldError {
+	if len(constraints.Labels) == 0 {
+		return apis.ErrMissingField("labels")
+	}
+	return nil
+}
+
+// otherVersionServer implements another version of the protocol
+type otherVersionServer struct {
+	v1alpha1.UnimplementedCloudProviderServer
+}
+
+func (otherVersionServer) Name(context.Context, *v1alpha1.NameRequest) (*v1alpha1.NameResponse, error) {
+	return &v1alpha1.NameResponse{Name: "other", ProtocolVersion: "v1beta1"}, nil
+}
+
+// serve starts a server that the services are registered with, and returns
+// it with a connection to it
+func serve(register func(*grpc.Server)) (*grpc.Server, *grpc.ClientConn) {
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	register(server)
+	go func() {
+		defer GinkgoRecover()
+		Expect(server.Serve(listener)).To(Succeed())
+	}()
+	conn, err := grpc.DialContext(ctx, "bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	Expect(err).ToNot(HaveOccurred())
+	return server, conn
+}
+
+var _ = BeforeSuite(func() {
+	fakeCloudProvider = &validatingCloudProvider{}
+	var conn *grpc.ClientConn
+	server, conn = serve(func(server *grpc.Server) { plugin.Register(server, fakeCloudProvider) })
+	var err error
+	cloudProvider, err = plugin.NewCloudProvider(ctx, conn)
+	Expect(err).ToNot(HaveOccurred())
+})
+
+var _ = AfterSuite(func() {
+	server.Stop()
+})
+
+var _ = conformance.Describe(func() conformance.Options {
+	return conformance.Options{
+		Context:       ctx,
+		CloudProvider: cloudProvider,
+		Provisioner:   func() *v1alpha5.Provisioner { return &v1alpha5.Provisioner{} },
+	}
+})
+
+var _ = Describe("Plugin", func() {
+	var constraints *v1alpha5.Constraints
+	BeforeEach(func() {
+		constraints = &v1alpha5.Constraints{Requirements: v1alpha5.Requirements{
+			{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-1"}},
+			{Key: v1alpha5.LabelCapacityType, Operator: v1.NodeSelectorOpIn, Values: []string{"spot"}},
+		}}
+		fakeCloudProvider.InstanceTypes = nil
+		fakeCloudProvider.VerifyError = nil
+		fakeCloudProvider.DriftReasons = nil
+	})
+	// The conformance specs share the fake cloud provider, and may run after
+	AfterEach(func() {
+		fakeCloudProvider.InstanceTypes = nil
+		fakeCloudProvider.VerifyError = nil
+		fakeCloudProvider.DriftReasons = nil
+	})
+	It("should return the plugin's name", func() {
+		Expect(cloudProvider.Name()).To(Equal("fake"))
+	})
+	It("should reject plugins that implement another protocol version", func() {
+		other, conn := serve(func(server *grpc.Server) { v1alpha1.RegisterCloudProviderServer(server, otherVersionServer{}) })
+		defer other.Stop()
+		_, err := plugin.NewCloudProvider(ctx, conn)
+		Expect(err).To(MatchError(ContainSubstring(`implements protocol version "v1beta1", expected v1alpha1`)))
+	})
+	It("should return the plugin's capabilities", func() {
+		Expect(cloudProvider.Capabilities()).To(Equal(fakeCloudProvider.Capabilities()))
+	})
+	It("should return the plugin's instance types", func() {
+		fakeCloudProvider.InstanceTypes = []cloudprovider.InstanceType{
+			fake.NewInstanceType(fake.InstanceTypeOptions{
+				Name:              "test-instance-type",
+				Offerings:         []cloudprovider.Offering{{CapacityType: "spot", Zone: "test-zone-1", Price: 0.5}},
+				NvidiaGPUs:        resource.MustParse("2"),
+				ExtendedResources: v1.ResourceList{"vendor.com/foo": resource.MustParse("1")},
+				Labels:            map[string]string{"example.com/gpu-count": "2"},
+			}),
+		}
+		instanceTypes, err := cloudProvider.GetInstanceTypes(ctx, &v1alpha5.Provisioner{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(instanceTypes).To(HaveLen(1))
+		Expect(instanceTypes[0].Name()).To(Equal("test-instance-type"))
+		Expect(instanceTypes[0].Offerings()).To(Equal(cloudprovider.Offerings{{CapacityType: "spot", Zone: "test-zone-1", Price: 0.5}}))
+		Expect(instanceTypes[0].OperatingSystems().List()).To(ConsistOf("darwin", "linux", "windows"))
+		Expect(instanceTypes[0].CPU().String()).To(Equal("4"))
+		Expect(instanceTypes[0].NvidiaGPUs().String()).To(Equal("2"))
+		Expect(instanceTypes[0].ExtendedResources()).To(HaveKey(v1.ResourceName("vendor.com/foo")))
+		Expect(instanceTypes[0].Labels()).To(Equal(map[string]string{"example.com/gpu-count": "2"}))
+		overhead := instanceTypes[0].Overhead()
+		Expect(overhead.SystemReserved.Cpu().String()).To(Equal("100m"))
+	})
+	It("should bind each node that the plugin creates", func() {
+		instanceTypes, err := cloudProvider.GetInstanceTypes(ctx, &v1alpha5.Provisioner{})
+		Expect(err).ToNot(HaveOccurred())
+		nodes := []*v1.Node{}
+		Expect(cloudProvider.Create(ctx, constraints, instanceTypes[:1], 3, func(node *v1.Node) error {
+			nodes = append(nodes, node)
+			return nil
+		})).To(Succeed())
+		Expect(nodes).To(HaveLen(3))
+		for _, node := range nodes {
+			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelInstanceTypeStable, instanceTypes[0].Name()))
+		}
+	})
+	It("should pass the launch's context values to the plugin", func() {
+		instanceTypes, err := cloudProvider.GetInstanceTypes(ctx, &v1alpha5.Provisioner{})
+		Expect(err).ToNot(HaveOccurred())
+		launchCtx := injection.WithLaunchID(injection.WithRequests(ctx, v1.ResourceList{v1.ResourceCPU: resource.MustParse("2")}), "test-launch")
+		Expect(cloudProvider.Create(launchCtx, constraints, instanceTypes[:1], 1, func(*v1.Node) error { return nil })).To(Succeed())
+		Expect(fakeCloudProvider.launchID).To(Equal("test-launch"))
+		Expect(fakeCloudProvider.requests.Cpu().String()).To(Equal("2"))
+	})
+	It("should return binding errors", func() {
+		instanceTypes, err := cloudProvider.GetInstanceTypes(ctx, &v1alpha5.Provisioner{})
+		Expect(err).ToNot(HaveOccurred())
+		err = cloudProvider.Create(ctx, constraints, instanceTypes[:1], 1, func(node *v1.Node) error {
+			return fmt.Errorf("binding %s", node.Name)
+		})
+		Expect(err).To(MatchError(ContainSubstring("binding")))
+	})
+	It("should return insufficient capacity errors", func() {
+		err := cloudProvider.Create(ctx, constraints, []cloudprovider.InstanceType{}, 1, func(*v1.Node) error { return nil })
+		Expect(cloudprovider.IsInsufficientCapacityError(err)).To(BeTrue())
+		Expect(err.Error()).To(Equal("insufficient capacity, no offerings of 0 instance type option(s) satisfy constraints"))
+	})
+	It("should default constraints", func() {
+		constraints := &v1alpha5.Constraints{}
+		cloudProvider.Default(ctx, constraints)
+		Expect(constraints.Taints).To(ConsistOf(v1.Taint{Key: "defaulted", Effect: v1.TaintEffectNoSchedule}))
+	})
+	It("should pass the provider through to the plugin", func() {
+		constraints := &v1alpha5.Constraints{Provider: &runtime.RawExtension{Raw: []byte(`{"foo":"bar"}`)}}
+		cloudProvider.Default(ctx, constraints)
+		Expect(string(constraints.Provider.Raw)).To(Equal(`{"foo":"bar"}`))
+	})
+	It("should validate constraints", func() {
+		Expect(cloudProvider.Validate(ctx, &v1alpha5.Constraints{Labels: map[string]string{"foo": "bar"}})).To(BeNil())
+		Expect(cloudProvider.Validate(ctx, &v1alpha5.Constraints{})).To(MatchError(ContainSubstring("missing field(s): labels")))
+	})
+	It("should return configuration errors with their reason", func() {
+		fakeCloudProvider.VerifyError = cloudprovider.NewConfigurationError("TestReason", fmt.Errorf("test error"))
+		err := cloudProvider.Verify(ctx, &v1alpha5.Constraints{})
+		Expect(err).To(MatchError("test error"))
+		reason, ok := cloudprovider.ConfigurationErrorReason(err)
+		Expect(ok).To(BeTrue())
+		Expect(reason).To(Equal("TestReason"))
+	})
+	It("should return the plugin's drift reasons", func() {
+		fakeCloudProvider.DriftReasons = map[string]string{"test-node": "test-reason"}
+		Expect(cloudProvider.IsDrifted(ctx, &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-node"}}, &v1alpha5.Provisioner{})).To(Equal("test-reason"))
+		Expect(cloudProvider.IsDrifted(ctx, &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "other-node"}}, &v1alpha5.Provisioner{})).To(BeEmpty())
+	})
+	It("should return other errors", func() {
+		fakeCloudProvider.VerifyError = fmt.Errorf("test error")
+		err := cloudProvider.Verify(ctx, &v1alpha5.Constraints{})
+		Expect(err).To(MatchError("test error"))
+		_, ok := cloudprovider.ConfigurationErrorReason(err)
+		Expect(ok).To(BeFalse())
+	})
+})
@@ -1,4 +1,4 @@
-//go:build !aws
+//go:build !aws && !capi && !static
 
 /*
 Licensed under the Apache License, Version 2.0 (the "License");
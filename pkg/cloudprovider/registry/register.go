@@ -16,22 +16,86 @@ package registry
 
 import (
 	"context"
+	"time"
 
 	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
 	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/cloudprovider/plugin"
+	"github.com/aws/karpenter/pkg/controllers"
+	"github.com/aws/karpenter/pkg/utils/injection"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"knative.dev/pkg/apis"
+	"knative.dev/pkg/logging"
+	"knative.dev/pkg/webhook/resourcesemantics"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+// ControllerProvider is implemented by cloud providers that run controllers of
+// their own, e.g. to react to events from the cloud.
+type ControllerProvider interface {
+	Controllers(context.Context, client.Client) []controllers.Controller
+}
+
+// ResourceProvider is implemented by cloud providers with resources of their
+// own that are defaulted and validated by the webhook, e.g. the resources
+// that provisioners reference with their providerRef.
+type ResourceProvider interface {
+	Resources() map[schema.GroupVersionKind]resourcesemantics.GenericCRD
+}
+
+// NewCloudProvider returns the cloud provider that the binary was built with,
+// or the plugin at the cloud provider plugin address, if one is configured.
 func NewCloudProvider(ctx context.Context, options cloudprovider.Options) cloudprovider.CloudProvider {
-	cloudProvider := newCloudProvider(ctx, options)
+	var cloudProvider cloudprovider.CloudProvider
+	if address := injection.GetOptions(ctx).CloudProviderPluginAddress; address != "" {
+		cloudProvider = newPluginOrDie(ctx, address)
+	} else {
+		cloudProvider = newCloudProvider(ctx, options)
+	}
 	RegisterOrDie(ctx, cloudProvider)
 	return cloudProvider
 }
 
+// pluginStartupTimeout is how long to wait for a cloud provider plugin to
+// become ready, e.g. while its sidecar container starts
+const pluginStartupTimeout = time.Minute
+
+func newPluginOrDie(ctx context.Context, address string) cloudprovider.CloudProvider {
+	ctx, cancel := context.WithTimeout(ctx, pluginStartupTimeout)
+	defer cancel()
+	cloudProvider, err := plugin.Dial(ctx, address)
+	if err != nil {
+		panic(err)
+	}
+	logging.FromContext(ctx).Infof("Using %s cloud provider plugin at %s", cloudProvider.Name(), address)
+	return cloudProvider
+}
+
 // RegisterOrDie populates supported instance types, zones, operating systems,
 // architectures, and validation logic. This operation should only be called
 // once at startup time. Typically, this call is made by NewCloudProvider(), but
 // must be called if the cloud provider is constructed manually (e.g. tests).
 func RegisterOrDie(ctx context.Context, cloudProvider cloudprovider.CloudProvider) {
-	v1alpha5.ValidateHook = cloudProvider.Validate
+	v1alpha5.ValidateHook = func(ctx context.Context, constraints *v1alpha5.Constraints) *apis.FieldError {
+		return cloudProvider.Validate(ctx, constraints).Also(cloudProvider.Capabilities().Validate(constraints))
+	}
 	v1alpha5.DefaultHook = cloudProvider.Default
 }
+
+// NewControllers returns the cloud provider's own controllers, if it has any.
+// It must be called with the cloud provider returned by NewCloudProvider(),
+// rather than a decorated one.
+func NewControllers(ctx context.Context, cloudProvider cloudprovider.CloudProvider, kubeClient client.Client) []controllers.Controller {
+	if controllerProvider, ok := cloudProvider.(ControllerProvider); ok {
+		return controllerProvider.Controllers(ctx, kubeClient)
+	}
+	return nil
+}
+
+// NewResources returns the cloud provider's own resources, if it has any.
+func NewResources(cloudProvider cloudprovider.CloudProvider) map[schema.GroupVersionKind]resourcesemantics.GenericCRD {
+	if resourceProvider, ok := cloudProvider.(ResourceProvider); ok {
+		return resourceProvider.Resources()
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudprovider
+
+import (
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// Requirements returns the well known label requirements that are satisfiable
+// by at least one of the instance types. Instance types that don't have one of
+// the other instance types' labels allow its empty value.
+func Requirements(instanceTypes []InstanceType) (requirements v1alpha5.Requirements) {
+	supported := map[string]sets.String{
+		v1.LabelInstanceTypeStable: sets.NewString(),
+		v1.LabelTopologyZone:       sets.NewString(),
+		v1.LabelArchStable:         sets.NewString(),
+		v1.LabelOSStable:           sets.NewString(),
+		v1alpha5.LabelCapacityType: sets.NewString(),
+	}
+	for _, instanceType := range instanceTypes {
+		supported[v1.LabelTopologyZone].Insert(instanceType.Offerings().Zones().UnsortedList()...)
+		supported[v1alpha5.LabelCapacityType].Insert(instanceType.Offerings().CapacityTypes().UnsortedList()...)
+		supported[v1.LabelInstanceTypeStable].Insert(instanceType.Name())
+		supported[v1.LabelArchStable].Insert(instanceType.Architecture())
+		supported[v1.LabelOSStable].Insert(instanceType.OperatingSystems().List()...)
+	}
+	for key := range LabelKeys(instanceTypes) {
+		supported[key] = sets.NewString()
+		for _, instanceType := range instanceTypes {
+			supported[key].Insert(instanceType.Labels()[key])
+		}
+	}
+	for key, values := range supported {
+		requirements = append(requirements, v1.NodeSelectorRequirement{Key: key, Operator: v1.NodeSelectorOpIn, Values: values.UnsortedList()})
+	}
+	return requirements
+}
+
+// LabelKeys returns the keys of the labels of the instance types
+func LabelKeys(instanceTypes []InstanceType) sets.String {
+	keys := sets.NewString()
+	for _, instanceType := range instanceTypes {
+		for key := range instanceType.Labels() {
+			keys.Insert(key)
+		}
+	}
+	return keys
+}
@@ -0,0 +1,54 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Constraints wraps generic constraints with static specific parameters
+type Constraints struct {
+	*v1alpha5.Constraints
+	*Static
+}
+
+// Static contains parameters specific to this cloud provider
+// +kubebuilder:object:root=true
+type Static struct {
+	// TypeMeta includes version and kind of the extensions, inferred if not provided.
+	// +optional
+	metav1.TypeMeta `json:",inline"`
+	// MachineSelector selects the StaticMachines that the provisioner may
+	// attach to nodes by their labels. All machines are selected if not set.
+	// +optional
+	MachineSelector map[string]string `json:"machineSelector,omitempty"`
+}
+
+// Deserialize the provider's parameters, which are optional
+func Deserialize(constraints *v1alpha5.Constraints) (*Constraints, error) {
+	static := &Static{}
+	if constraints.Provider == nil {
+		return &Constraints{constraints, static}, nil
+	}
+	_, gvk, err := Codec.UniversalDeserializer().Decode(constraints.Provider.Raw, nil, static)
+	if err != nil {
+		return nil, err
+	}
+	if gvk != nil {
+		static.SetGroupVersionKind(*gvk)
+	}
+	return &Constraints{constraints, static}, nil
+}
@@ -0,0 +1,41 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+
+	"knative.dev/pkg/apis"
+)
+
+func (s *Static) Validate(ctx context.Context) (errs *apis.FieldError) {
+	return s.validate().ViaField("provider")
+}
+
+func (s *Static) validate() (errs *apis.FieldError) {
+	return errs.Also(
+		s.validateMachineSelector(),
+	)
+}
+
+func (s *Static) validateMachineSelector() (errs *apis.FieldError) {
+	for key, value := range s.MachineSelector {
+		if key == "" || value == "" {
+			errs = errs.Also(apis.ErrInvalidValue("\"\"", fmt.Sprintf("machineSelector['%s']", key)))
+		}
+	}
+	return errs
+}
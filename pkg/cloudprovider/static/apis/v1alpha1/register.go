@@ -0,0 +1,60 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+)
+
+var (
+	// CapacityTypeOnDemand is the capacity type of all machines, since they
+	// aren't reclaimed while in use.
+	CapacityTypeOnDemand = "on-demand"
+	// PowerOn and PowerOff are the power states of a machine
+	PowerOn  = "On"
+	PowerOff = "Off"
+	// DefaultPods is the number of pods that the kubelet allows by default.
+	DefaultPods = "110"
+	// ProviderIDFormat is the provider ID of nodes whose machines don't specify one
+	ProviderIDFormat = "static:///%s"
+	// MachineAnnotationKey is set on nodes to the name of their StaticMachine,
+	// so that the machine is returned to the pool with the node.
+	MachineAnnotationKey = "karpenter.sh/static-machine"
+)
+
+var (
+	// Scheme and Codec decode the provider's parameters of provisioners
+	Scheme = runtime.NewScheme()
+	Codec  = serializer.NewCodecFactory(Scheme, serializer.EnableStrict)
+	// SchemeGroupVersion is the API group of StaticMachines
+	SchemeGroupVersion = schema.GroupVersion{Group: "static.karpenter.sh", Version: "v1alpha1"}
+	SchemeBuilder      = runtime.NewSchemeBuilder(func(scheme *runtime.Scheme) error {
+		scheme.AddKnownTypes(SchemeGroupVersion,
+			&StaticMachine{},
+			&StaticMachineList{},
+		)
+		metav1.AddToGroupVersion(scheme, SchemeGroupVersion)
+		return nil
+	})
+	AddToScheme = SchemeBuilder.AddToScheme
+)
+
+func init() {
+	Scheme.AddKnownTypes(schema.GroupVersion{Group: v1alpha5.ExtensionsGroup, Version: "v1alpha1"}, &Static{})
+}
@@ -0,0 +1,94 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// StaticMachineSpec describes a machine of the pool, and whether Karpenter
+// wants it powered on. Machines are powered on and off by an agent that
+// manages them, e.g. through their BMCs, which reconciles the desired power
+// state and reports the observed one.
+type StaticMachineSpec struct {
+	// NodeName is the name that the machine's kubelet registers its node with.
+	NodeName string `json:"nodeName"`
+	// ProviderID is the provider ID of the machine's node, e.g. if its kubelet
+	// is started with --provider-id. Defaults to static:///<name>.
+	// +optional
+	ProviderID string `json:"providerID,omitempty"`
+	// InstanceType of the machine, which its node is labeled with. Machines of
+	// the same instance type are expected to have the same resources.
+	InstanceType string `json:"instanceType"`
+	// Zone that the machine is in, which its node is labeled with.
+	Zone string `json:"zone"`
+	// Architecture of the machine, e.g. amd64 or arm64. Defaults to amd64.
+	// +optional
+	Architecture string `json:"architecture,omitempty"`
+	// Resources are the capacity of the machine's node. cpu and memory are
+	// required, and pods defaults to 110.
+	Resources v1.ResourceList `json:"resources"`
+	// Power is the desired power state of the machine, On or Off. Karpenter
+	// sets it to On when it attaches the machine to a node, and to Off when it
+	// returns the machine to the pool.
+	// +optional
+	Power string `json:"power,omitempty"`
+	// Provisioner is the name of the provisioner whose node the machine is
+	// attached to, or empty if the machine is in the pool.
+	// +optional
+	Provisioner string `json:"provisioner,omitempty"`
+}
+
+// StaticMachineStatus is reported by the agent that manages the machine.
+type StaticMachineStatus struct {
+	// Power is the observed power state of the machine. Machines in the pool
+	// that are still powered on aren't attached to nodes until they're off.
+	// +optional
+	Power string `json:"power,omitempty"`
+}
+
+// StaticMachine is the Schema for the StaticMachines API. StaticMachines are
+// a pre-registered pool of powered off machines that the static cloud
+// provider attaches to nodes, and returns to the pool when nodes are deleted.
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:path=staticmachines,scope=Cluster
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Instance Type",type="string",JSONPath=".spec.instanceType"
+// +kubebuilder:printcolumn:name="Zone",type="string",JSONPath=".spec.zone"
+// +kubebuilder:printcolumn:name="Provisioner",type="string",JSONPath=".spec.provisioner"
+// +kubebuilder:printcolumn:name="Power",type="string",JSONPath=".status.power"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+type StaticMachine struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   StaticMachineSpec   `json:"spec,omitempty"`
+	Status StaticMachineStatus `json:"status,omitempty"`
+}
+
+// StaticMachineList contains a list of StaticMachine
+// +kubebuilder:object:root=true
+type StaticMachineList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []StaticMachine `json:"items"`
+}
+
+// Available returns true if the machine is in the pool and powered off, so
+// that it may be attached to a node.
+func (m *StaticMachine) Available() bool {
+	return m.Spec.Provisioner == "" && m.Spec.Power != PowerOn && m.Status.Power != PowerOn
+}
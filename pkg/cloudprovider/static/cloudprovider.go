@@ -0,0 +1,131 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package static
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/cloudprovider/static/apis/v1alpha1"
+	"github.com/aws/karpenter/pkg/utils/injection"
+	"go.uber.org/multierr"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"knative.dev/pkg/apis"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// CloudProvider attaches machines of a pre-registered pool of powered off
+// StaticMachines to nodes, e.g. bare metal machines on-prem, so that
+// Karpenter's scheduling and termination logic applies to them.
+type CloudProvider struct {
+	poolProvider *PoolProvider
+}
+
+func NewCloudProvider(ctx context.Context, _ cloudprovider.Options) *CloudProvider {
+	scheme := runtime.NewScheme()
+	utilruntime.Must(v1alpha1.AddToScheme(scheme))
+	kubeClient, err := client.New(injection.GetConfig(ctx), client.Options{Scheme: scheme})
+	if err != nil {
+		panic(fmt.Sprintf("Failed to create kube client, %s", err.Error()))
+	}
+	return &CloudProvider{poolProvider: NewPoolProvider(kubeClient)}
+}
+
+// Create a node given the constraints.
+func (c *CloudProvider) Create(ctx context.Context, constraints *v1alpha5.Constraints, instanceTypes []cloudprovider.InstanceType, quantity int, callback func(*v1.Node) error) error {
+	vendorConstraints, err := v1alpha1.Deserialize(constraints)
+	if err != nil {
+		return err
+	}
+	// Create will only return an error if zero machines could be attached.
+	// Partial fulfillment will be logged
+	nodes, err := c.poolProvider.Create(ctx, vendorConstraints, instanceTypes, quantity)
+	if err != nil {
+		return fmt.Errorf("attaching machines, %w", err)
+	}
+	var errs error
+	for _, node := range nodes {
+		errs = multierr.Append(errs, callback(node))
+	}
+	return errs
+}
+
+// GetInstanceTypes returns the instance types of the provisioner's machines
+// that are available in the pool
+func (c *CloudProvider) GetInstanceTypes(ctx context.Context, provisioner *v1alpha5.Provisioner) ([]cloudprovider.InstanceType, error) {
+	vendorConstraints, err := v1alpha1.Deserialize(&provisioner.Spec.Constraints)
+	if err != nil {
+		return nil, apis.ErrGeneric(err.Error())
+	}
+	return c.poolProvider.InstanceTypes(ctx, vendorConstraints.Static)
+}
+
+// Delete returns the node's machine to the pool
+func (c *CloudProvider) Delete(ctx context.Context, node *v1.Node) error {
+	return c.poolProvider.Delete(ctx, node)
+}
+
+// Validate the provisioner
+func (c *CloudProvider) Validate(ctx context.Context, constraints *v1alpha5.Constraints) *apis.FieldError {
+	vendorConstraints, err := v1alpha1.Deserialize(constraints)
+	if err != nil {
+		return apis.ErrGeneric(err.Error())
+	}
+	return vendorConstraints.Static.Validate(ctx)
+}
+
+// Verify that the provisioner selects machines
+func (c *CloudProvider) Verify(ctx context.Context, constraints *v1alpha5.Constraints) error {
+	vendorConstraints, err := v1alpha1.Deserialize(constraints)
+	if err != nil {
+		return err
+	}
+	return c.poolProvider.Verify(ctx, vendorConstraints.Static)
+}
+
+// IsDrifted returns the reason that the node's machine is no longer selected
+// by the provisioner, or an empty string if it hasn't drifted.
+func (c *CloudProvider) IsDrifted(ctx context.Context, node *v1.Node, provisioner *v1alpha5.Provisioner) (string, error) {
+	vendorConstraints, err := v1alpha1.Deserialize(&provisioner.Spec.Constraints)
+	if err != nil {
+		return "", err
+	}
+	return c.poolProvider.Drifted(ctx, node, vendorConstraints.Static)
+}
+
+// Default the provisioner. There are no defaults.
+func (c *CloudProvider) Default(context.Context, *v1alpha5.Constraints) {
+}
+
+// Capabilities of the pool's machines, which may declare accelerators in
+// their resources, but are always on-demand.
+func (c *CloudProvider) Capabilities() cloudprovider.Capabilities {
+	return cloudprovider.Capabilities{
+		CapacityTypes: sets.NewString(v1alpha1.CapacityTypeOnDemand),
+		Architectures: sets.NewString(v1alpha5.ArchitectureAmd64, v1alpha5.ArchitectureArm64),
+		Accelerators:  true,
+		MaxPodsSource: cloudprovider.MaxPodsSourceKubelet,
+	}
+}
+
+// Name returns the CloudProvider implementation name.
+func (c *CloudProvider) Name() string {
+	return "static"
+}
@@ -0,0 +1,139 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package static
+
+import (
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/cloudprovider/static/apis/v1alpha1"
+	"github.com/aws/karpenter/pkg/utils/resources"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// InstanceType is described by a machine of the pool, and offered in the
+// zones that have available machines of its type.
+type InstanceType struct {
+	machine   *v1alpha1.StaticMachine
+	offerings cloudprovider.Offerings
+}
+
+func NewInstanceType(machine *v1alpha1.StaticMachine) *InstanceType {
+	return &InstanceType{machine: machine}
+}
+
+func (i *InstanceType) Name() string {
+	return i.machine.Spec.InstanceType
+}
+
+func (i *InstanceType) Offerings() cloudprovider.Offerings {
+	return i.offerings
+}
+
+// offer the instance type in the zone, if it isn't already
+func (i *InstanceType) offer(zone string) {
+	if _, ok := i.offerings.Get(v1alpha1.CapacityTypeOnDemand, zone); !ok {
+		i.offerings = append(i.offerings, cloudprovider.Offering{CapacityType: v1alpha1.CapacityTypeOnDemand, Zone: zone})
+	}
+}
+
+func (i *InstanceType) Architecture() string {
+	if i.machine.Spec.Architecture == "" {
+		return v1alpha5.ArchitectureAmd64
+	}
+	return i.machine.Spec.Architecture
+}
+
+func (i *InstanceType) OperatingSystems() sets.String {
+	return sets.NewString(v1alpha5.OperatingSystemLinux)
+}
+
+func (i *InstanceType) CPU() *resource.Quantity {
+	return i.quantity(v1.ResourceCPU)
+}
+
+func (i *InstanceType) Memory() *resource.Quantity {
+	return i.quantity(v1.ResourceMemory)
+}
+
+func (i *InstanceType) EphemeralStorage() *resource.Quantity {
+	return i.quantity(v1.ResourceEphemeralStorage)
+}
+
+func (i *InstanceType) Pods() *resource.Quantity {
+	return i.quantity(v1.ResourcePods)
+}
+
+func (i *InstanceType) NvidiaGPUs() *resource.Quantity {
+	return i.quantity(resources.NvidiaGPU)
+}
+
+func (i *InstanceType) AMDGPUs() *resource.Quantity {
+	return i.quantity(resources.AMDGPU)
+}
+
+func (i *InstanceType) AWSNeurons() *resource.Quantity {
+	return i.quantity(resources.AWSNeuron)
+}
+
+func (i *InstanceType) HabanaGaudis() *resource.Quantity {
+	return i.quantity(resources.HabanaGaudi)
+}
+
+func (i *InstanceType) AWSPodENI() *resource.Quantity {
+	return i.quantity(resources.AWSPodENI)
+}
+
+// ExtendedResources are the machine's resources that aren't described by the
+// other methods, e.g. vendor.com/foo.
+func (i *InstanceType) ExtendedResources() v1.ResourceList {
+	extended := v1.ResourceList{}
+	for resourceName, quantity := range i.machine.Spec.Resources {
+		switch resourceName {
+		case v1.ResourceCPU, v1.ResourceMemory, v1.ResourceEphemeralStorage, v1.ResourcePods,
+			resources.NvidiaGPU, resources.AMDGPU, resources.AWSNeuron, resources.HabanaGaudi, resources.AWSPodENI:
+			continue
+		}
+		extended[resourceName] = quantity.DeepCopy()
+	}
+	return extended
+}
+
+// Overhead is left to the provisioner's kubelet configuration, since the
+// machines' kubelets are configured outside of Karpenter.
+func (i *InstanceType) Overhead() cloudprovider.InstanceTypeOverhead {
+	return cloudprovider.InstanceTypeOverhead{}
+}
+
+// Capacity returns the resources of the machine, with the kubelet's default
+// pod limit if the machine doesn't specify one
+func (i *InstanceType) Capacity() v1.ResourceList {
+	capacity := v1.ResourceList{v1.ResourcePods: resource.MustParse(v1alpha1.DefaultPods)}
+	for resourceName, quantity := range i.machine.Spec.Resources {
+		capacity[resourceName] = quantity.DeepCopy()
+	}
+	return capacity
+}
+
+func (i *InstanceType) quantity(resourceName v1.ResourceName) *resource.Quantity {
+	quantity := i.Capacity()[resourceName]
+	return &quantity
+}
+
+// Labels are empty, since machines don't describe their attributes as labels
+func (i *InstanceType) Labels() map[string]string {
+	return nil
+}
@@ -0,0 +1,237 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package static
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/cloudprovider/static/apis/v1alpha1"
+	"go.uber.org/multierr"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"knative.dev/pkg/logging"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// MachinesNotFoundReason is reported on the provisioner's Validated condition
+// when its machine selector doesn't select any StaticMachines
+const MachinesNotFoundReason = "MachinesNotFound"
+
+// PoolProvider attaches StaticMachines of the pool to nodes, and returns them
+// to the pool when the nodes are deleted.
+type PoolProvider struct {
+	kubeClient client.Client
+}
+
+func NewPoolProvider(kubeClient client.Client) *PoolProvider {
+	return &PoolProvider{kubeClient: kubeClient}
+}
+
+// InstanceTypes returns the instance types of the selected machines, offered
+// in the zones that have available machines. Instance types without available
+// machines are omitted.
+func (p *PoolProvider) InstanceTypes(ctx context.Context, static *v1alpha1.Static) ([]cloudprovider.InstanceType, error) {
+	machines, err := p.list(ctx, static)
+	if err != nil {
+		return nil, err
+	}
+	byName := map[string]*InstanceType{}
+	names := []string{}
+	for i := range machines {
+		machine := &machines[i]
+		if !machine.Available() {
+			continue
+		}
+		instanceType, ok := byName[machine.Spec.InstanceType]
+		if !ok {
+			instanceType = NewInstanceType(machine)
+			byName[machine.Spec.InstanceType] = instanceType
+			names = append(names, machine.Spec.InstanceType)
+		}
+		instanceType.offer(machine.Spec.Zone)
+	}
+	sort.Strings(names)
+	instanceTypes := []cloudprovider.InstanceType{}
+	for _, name := range names {
+		instanceTypes = append(instanceTypes, byName[name])
+	}
+	return instanceTypes, nil
+}
+
+// Create attaches available machines of the instance types, in order, that
+// are in one of the allowed zones. Nodes are returned for the machines that
+// were attached, and an error only if none were.
+func (p *PoolProvider) Create(ctx context.Context, constraints *v1alpha1.Constraints, instanceTypes []cloudprovider.InstanceType, quantity int) ([]*v1.Node, error) {
+	machines, err := p.list(ctx, constraints.Static)
+	if err != nil {
+		return nil, err
+	}
+	nodes := []*v1.Node{}
+	var errs error
+	if constraints.Requirements.CapacityTypes().Has(v1alpha1.CapacityTypeOnDemand) {
+		for _, instanceType := range instanceTypes {
+			for i := range machines {
+				machine := &machines[i]
+				if len(nodes) == quantity {
+					return nodes, nil
+				}
+				if machine.Spec.InstanceType != instanceType.Name() || !machine.Available() || !constraints.Requirements.Zones().Has(machine.Spec.Zone) {
+					continue
+				}
+				if err := p.attach(ctx, machine, constraints.Labels[v1alpha5.ProvisionerNameLabelKey]); err != nil {
+					// Another node may have attached the machine first
+					if !errors.IsConflict(err) {
+						errs = multierr.Append(errs, err)
+					}
+					continue
+				}
+				nodes = append(nodes, p.nodeFor(machine))
+			}
+		}
+	}
+	if len(nodes) == 0 {
+		if errs != nil {
+			return nil, errs
+		}
+		return nil, cloudprovider.NewInsufficientCapacityError(fmt.Errorf("no machines of %d instance type option(s) are available in zones %v", len(instanceTypes), constraints.Requirements.Zones().List()))
+	}
+	if len(nodes) < quantity {
+		logging.FromContext(ctx).Infof("Attached %d of %d requested machines", len(nodes), quantity)
+	}
+	if errs != nil {
+		logging.FromContext(ctx).Errorf("Failed to attach machines, %s", errs.Error())
+	}
+	return nodes, nil
+}
+
+// attach the machine to a node of the provisioner, and power it on. The
+// update fails with a conflict if the machine changed since it was listed.
+func (p *PoolProvider) attach(ctx context.Context, machine *v1alpha1.StaticMachine, provisionerName string) error {
+	machine.Spec.Provisioner = provisionerName
+	machine.Spec.Power = v1alpha1.PowerOn
+	if err := p.kubeClient.Update(ctx, machine); err != nil {
+		return fmt.Errorf("attaching machine %s, %w", machine.Name, err)
+	}
+	logging.FromContext(ctx).Infof("Attached machine %s of type %s in zone %s", machine.Name, machine.Spec.InstanceType, machine.Spec.Zone)
+	return nil
+}
+
+func (p *PoolProvider) nodeFor(machine *v1alpha1.StaticMachine) *v1.Node {
+	instanceType := NewInstanceType(machine)
+	providerID := machine.Spec.ProviderID
+	if providerID == "" {
+		providerID = fmt.Sprintf(v1alpha1.ProviderIDFormat, machine.Name)
+	}
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: machine.Spec.NodeName,
+			Labels: map[string]string{
+				v1.LabelTopologyZone:       machine.Spec.Zone,
+				v1.LabelInstanceTypeStable: machine.Spec.InstanceType,
+				v1alpha5.LabelCapacityType: v1alpha1.CapacityTypeOnDemand,
+			},
+			Annotations: map[string]string{
+				v1alpha1.MachineAnnotationKey: machine.Name,
+			},
+		},
+		Spec: v1.NodeSpec{
+			ProviderID: providerID,
+		},
+		Status: v1.NodeStatus{
+			NodeInfo: v1.NodeSystemInfo{
+				Architecture:    instanceType.Architecture(),
+				OperatingSystem: v1alpha5.OperatingSystemLinux,
+			},
+			Allocatable: instanceType.Capacity(),
+		},
+	}
+}
+
+// Delete returns the node's machine to the pool and powers it off. Nodes
+// without a machine are ignored, since there is nothing to return.
+func (p *PoolProvider) Delete(ctx context.Context, node *v1.Node) error {
+	name, ok := node.Annotations[v1alpha1.MachineAnnotationKey]
+	if !ok {
+		logging.FromContext(ctx).Debugf("Node %s has no machine annotation, skipping", node.Name)
+		return nil
+	}
+	machine := &v1alpha1.StaticMachine{}
+	if err := p.kubeClient.Get(ctx, types.NamespacedName{Name: name}, machine); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("getting machine %s, %w", name, err)
+	}
+	if machine.Spec.Provisioner == "" && machine.Spec.Power == v1alpha1.PowerOff {
+		return nil
+	}
+	machine.Spec.Provisioner = ""
+	machine.Spec.Power = v1alpha1.PowerOff
+	if err := p.kubeClient.Update(ctx, machine); err != nil {
+		return fmt.Errorf("returning machine %s to the pool, %w", name, err)
+	}
+	logging.FromContext(ctx).Infof("Returned machine %s to the pool", name)
+	return nil
+}
+
+// Drifted returns the reason that the node's machine is no longer selected by
+// the machine selector, e.g. because it was relabeled for maintenance, or an
+// empty string if it is. Nodes without a machine never drift.
+func (p *PoolProvider) Drifted(ctx context.Context, node *v1.Node, static *v1alpha1.Static) (string, error) {
+	name, ok := node.Annotations[v1alpha1.MachineAnnotationKey]
+	if !ok {
+		return "", nil
+	}
+	machine := &v1alpha1.StaticMachine{}
+	if err := p.kubeClient.Get(ctx, types.NamespacedName{Name: name}, machine); err != nil {
+		if errors.IsNotFound(err) {
+			return fmt.Sprintf("machine %s no longer exists", name), nil
+		}
+		return "", fmt.Errorf("getting machine %s, %w", name, err)
+	}
+	if !labels.SelectorFromSet(static.MachineSelector).Matches(labels.Set(machine.Labels)) {
+		return fmt.Sprintf("machine %s no longer matches selector %v", name, static.MachineSelector), nil
+	}
+	return "", nil
+}
+
+// Verify that the machine selector selects machines
+func (p *PoolProvider) Verify(ctx context.Context, static *v1alpha1.Static) error {
+	machines, err := p.list(ctx, static)
+	if err != nil {
+		return err
+	}
+	if len(machines) == 0 {
+		return cloudprovider.NewConfigurationError(MachinesNotFoundReason, fmt.Errorf("no machines match selector %v", static.MachineSelector))
+	}
+	return nil
+}
+
+// list the machines selected by the provider, sorted by name
+func (p *PoolProvider) list(ctx context.Context, static *v1alpha1.Static) ([]v1alpha1.StaticMachine, error) {
+	machines := &v1alpha1.StaticMachineList{}
+	if err := p.kubeClient.List(ctx, machines, client.MatchingLabels(static.MachineSelector)); err != nil {
+		return nil, fmt.Errorf("listing machines, %w", err)
+	}
+	sort.Slice(machines.Items, func(i, j int) bool { return machines.Items[i].Name < machines.Items[j].Name })
+	return machines.Items, nil
+}
@@ -0,0 +1,274 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package static
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/cloudprovider/static/apis/v1alpha1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	. "knative.dev/pkg/logging/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var ctx context.Context
+var kubeClient client.Client
+var cloudProvider *CloudProvider
+var provider *v1alpha1.Static
+var provisioner *v1alpha5.Provisioner
+
+func TestStatic(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CloudProvider/Static")
+}
+
+var _ = BeforeEach(func() {
+	scheme := runtime.NewScheme()
+	utilruntime.Must(v1alpha1.AddToScheme(scheme))
+	kubeClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		machine("test-machine-1", "small", "test-zone-1", map[string]string{"rack": "a"}),
+		machine("test-machine-2", "small", "test-zone-2", map[string]string{"rack": "a"}),
+		machine("test-machine-3", "large", "test-zone-1", map[string]string{"rack": "a"}),
+		machine("test-machine-4", "large", "test-zone-2", map[string]string{"rack": "b"}),
+	).Build()
+	cloudProvider = &CloudProvider{poolProvider: NewPoolProvider(kubeClient)}
+	provider = &v1alpha1.Static{MachineSelector: map[string]string{"rack": "a"}}
+	provisioner = &v1alpha5.Provisioner{ObjectMeta: metav1.ObjectMeta{Name: "default"}}
+	provisioner.Spec.Labels = map[string]string{v1alpha5.ProvisionerNameLabelKey: "default"}
+})
+
+var _ = Describe("Static", func() {
+	Context("Validation", func() {
+		It("should allow a valid provider", func() {
+			Expect(cloudProvider.Validate(ctx, constraints().Constraints)).To(Succeed())
+		})
+		It("should not allow empty selector keys or values", func() {
+			provider.MachineSelector = map[string]string{"": "a"}
+			Expect(cloudProvider.Validate(ctx, constraints().Constraints)).ToNot(Succeed())
+			provider.MachineSelector = map[string]string{"rack": ""}
+			Expect(cloudProvider.Validate(ctx, constraints().Constraints)).ToNot(Succeed())
+		})
+	})
+	Context("GetInstanceTypes", func() {
+		It("should return the instance types of the selected machines with their resources", func() {
+			instanceTypes := instanceTypes()
+			Expect(instanceTypes).To(HaveLen(2))
+			Expect(instanceTypes[0].Name()).To(Equal("large"))
+			Expect(instanceTypes[1].Name()).To(Equal("small"))
+			Expect(instanceTypes[0].Architecture()).To(Equal(v1alpha5.ArchitectureAmd64))
+			Expect(instanceTypes[0].OperatingSystems().List()).To(ConsistOf(v1alpha5.OperatingSystemLinux))
+			Expect(instanceTypes[0].CPU().String()).To(Equal("32"))
+			Expect(instanceTypes[0].Memory().String()).To(Equal("128Gi"))
+			Expect(instanceTypes[0].Pods().String()).To(Equal("110"))
+			Expect(instanceTypes[0].NvidiaGPUs().String()).To(Equal("1"))
+			Expect(instanceTypes[0].ExtendedResources()).To(Equal(v1.ResourceList{"vendor.com/foo": resource.MustParse("2")}))
+			Expect(instanceTypes[1].CPU().String()).To(Equal("8"))
+			Expect(instanceTypes[1].NvidiaGPUs().IsZero()).To(BeTrue())
+		})
+		It("should offer on-demand capacity in the zones of available machines", func() {
+			attach("test-machine-2", "other")
+			instanceTypes := instanceTypes()
+			Expect(instanceTypes[0].Offerings()).To(Equal(cloudprovider.Offerings{{CapacityType: "on-demand", Zone: "test-zone-1"}}))
+			Expect(instanceTypes[1].Offerings()).To(Equal(cloudprovider.Offerings{{CapacityType: "on-demand", Zone: "test-zone-1"}}))
+		})
+		It("should omit instance types without available machines", func() {
+			attach("test-machine-3", "other")
+			instanceTypes := instanceTypes()
+			Expect(instanceTypes).To(HaveLen(1))
+			Expect(instanceTypes[0].Name()).To(Equal("small"))
+		})
+	})
+	Context("Create", func() {
+		It("should attach and power on available machines", func() {
+			nodes := create(1, "test-zone-2")
+			Expect(nodes).To(HaveLen(1))
+			node := nodes[0]
+			Expect(node.Name).To(Equal("test-machine-2.example.com"))
+			Expect(node.Spec.ProviderID).To(Equal("static:///test-machine-2"))
+			Expect(node.Labels).To(Equal(map[string]string{
+				v1.LabelTopologyZone:       "test-zone-2",
+				v1.LabelInstanceTypeStable: "small",
+				v1alpha5.LabelCapacityType: "on-demand",
+			}))
+			Expect(node.Annotations).To(HaveKeyWithValue(v1alpha1.MachineAnnotationKey, "test-machine-2"))
+			Expect(node.Status.Allocatable.Cpu().String()).To(Equal("8"))
+			Expect(node.Status.Allocatable.Pods().String()).To(Equal("110"))
+
+			machine := get("test-machine-2")
+			Expect(machine.Spec.Provisioner).To(Equal("default"))
+			Expect(machine.Spec.Power).To(Equal(v1alpha1.PowerOn))
+			Expect(machine.Available()).To(BeFalse())
+		})
+		It("should use the machine's provider id", func() {
+			machine := get("test-machine-3")
+			machine.Spec.ProviderID = "ipmi://10.0.0.1"
+			Expect(kubeClient.Update(ctx, machine)).To(Succeed())
+			nodes := create(1, "test-zone-1")
+			Expect(nodes[0].Spec.ProviderID).To(Equal("ipmi://10.0.0.1"))
+		})
+		It("should partially fulfill if there aren't enough machines", func() {
+			nodes := create(5, "test-zone-1", "test-zone-2")
+			Expect(nodes).To(HaveLen(3))
+		})
+		It("should not attach machines that aren't selected or available", func() {
+			attach("test-machine-1", "other")
+			nodes := create(5, "test-zone-1")
+			Expect(nodes).To(HaveLen(1))
+			Expect(nodes[0].Name).To(Equal("test-machine-3.example.com"))
+			Expect(get("test-machine-1").Spec.Provisioner).To(Equal("other"))
+			Expect(get("test-machine-4").Available()).To(BeTrue())
+		})
+		It("should return insufficient capacity if no machines are available", func() {
+			attach("test-machine-1", "other")
+			attach("test-machine-3", "other")
+			err := cloudProvider.Create(ctx, constraints("test-zone-1").Constraints, []cloudprovider.InstanceType{
+				NewInstanceType(get("test-machine-1")), NewInstanceType(get("test-machine-3")),
+			}, 1, func(*v1.Node) error { return nil })
+			Expect(cloudprovider.IsInsufficientCapacityError(err)).To(BeTrue())
+		})
+	})
+	Context("Delete", func() {
+		It("should return the node's machine to the pool", func() {
+			nodes := create(1, "test-zone-1")
+			Expect(cloudProvider.Delete(ctx, nodes[0])).To(Succeed())
+			Expect(nodes[0].Name).To(Equal("test-machine-3.example.com"))
+			machine := get("test-machine-3")
+			Expect(machine.Spec.Provisioner).To(BeEmpty())
+			Expect(machine.Spec.Power).To(Equal(v1alpha1.PowerOff))
+			Expect(cloudProvider.Delete(ctx, nodes[0])).To(Succeed())
+		})
+		It("should ignore nodes without a machine", func() {
+			Expect(cloudProvider.Delete(ctx, &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-node"}})).To(Succeed())
+			Expect(cloudProvider.Delete(ctx, &v1.Node{ObjectMeta: metav1.ObjectMeta{
+				Name:        "test-node",
+				Annotations: map[string]string{v1alpha1.MachineAnnotationKey: "missing"},
+			}})).To(Succeed())
+		})
+	})
+	Context("IsDrifted", func() {
+		It("should not report machines that are selected", func() {
+			nodes := create(1, "test-zone-1")
+			Expect(cloudProvider.IsDrifted(ctx, nodes[0], provisioner)).To(BeEmpty())
+		})
+		It("should report machines that are no longer selected", func() {
+			nodes := create(1, "test-zone-1")
+			machine := get("test-machine-3")
+			machine.Labels["rack"] = "maintenance"
+			Expect(kubeClient.Update(ctx, machine)).To(Succeed())
+			Expect(cloudProvider.IsDrifted(ctx, nodes[0], provisioner)).To(Equal("machine test-machine-3 no longer matches selector map[rack:a]"))
+		})
+		It("should report machines that no longer exist", func() {
+			nodes := create(1, "test-zone-1")
+			Expect(kubeClient.Delete(ctx, get("test-machine-3"))).To(Succeed())
+			Expect(cloudProvider.IsDrifted(ctx, nodes[0], provisioner)).To(Equal("machine test-machine-3 no longer exists"))
+		})
+		It("should ignore nodes without a machine", func() {
+			constraints()
+			Expect(cloudProvider.IsDrifted(ctx, &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-node"}}, provisioner)).To(BeEmpty())
+		})
+	})
+	Context("Verify", func() {
+		It("should succeed if the selector selects machines", func() {
+			Expect(cloudProvider.Verify(ctx, constraints().Constraints)).To(Succeed())
+		})
+		It("should report a selector that doesn't select machines", func() {
+			provider.MachineSelector = map[string]string{"rack": "c"}
+			reason, ok := cloudprovider.ConfigurationErrorReason(cloudProvider.Verify(ctx, constraints().Constraints))
+			Expect(ok).To(BeTrue())
+			Expect(reason).To(Equal(MachinesNotFoundReason))
+		})
+	})
+})
+
+func machine(name string, instanceType string, zone string, labels map[string]string) *v1alpha1.StaticMachine {
+	resources := v1.ResourceList{v1.ResourceCPU: resource.MustParse("8"), v1.ResourceMemory: resource.MustParse("32Gi")}
+	if instanceType == "large" {
+		resources = v1.ResourceList{
+			v1.ResourceCPU:    resource.MustParse("32"),
+			v1.ResourceMemory: resource.MustParse("128Gi"),
+			"nvidia.com/gpu":  resource.MustParse("1"),
+			"vendor.com/foo":  resource.MustParse("2"),
+		}
+	}
+	return &v1alpha1.StaticMachine{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+		Spec: v1alpha1.StaticMachineSpec{
+			NodeName:     name + ".example.com",
+			InstanceType: instanceType,
+			Zone:         zone,
+			Resources:    resources,
+			Power:        v1alpha1.PowerOff,
+		},
+	}
+}
+
+func get(name string) *v1alpha1.StaticMachine {
+	machine := &v1alpha1.StaticMachine{}
+	Expect(kubeClient.Get(ctx, types.NamespacedName{Name: name}, machine)).To(Succeed())
+	return machine
+}
+
+// attach the machine to another provisioner
+func attach(name string, provisionerName string) {
+	machine := get(name)
+	machine.Spec.Provisioner = provisionerName
+	machine.Spec.Power = v1alpha1.PowerOn
+	Expect(kubeClient.Update(ctx, machine)).To(Succeed())
+}
+
+// constraints returns the provisioner's constraints with the provider, allowing
+// on-demand capacity in the zones
+func constraints(zones ...string) *v1alpha1.Constraints {
+	raw, err := json.Marshal(provider)
+	Expect(err).ToNot(HaveOccurred())
+	provisioner.Spec.Provider = &runtime.RawExtension{Raw: raw}
+	provisioner.Spec.Requirements = v1alpha5.Requirements{
+		{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: zones},
+		{Key: v1alpha5.LabelCapacityType, Operator: v1.NodeSelectorOpIn, Values: []string{"on-demand"}},
+	}
+	constraints, err := v1alpha1.Deserialize(&provisioner.Spec.Constraints)
+	Expect(err).ToNot(HaveOccurred())
+	return constraints
+}
+
+func instanceTypes() []cloudprovider.InstanceType {
+	constraints()
+	instanceTypes, err := cloudProvider.GetInstanceTypes(ctx, provisioner)
+	Expect(err).ToNot(HaveOccurred())
+	return instanceTypes
+}
+
+func create(quantity int, zones ...string) []*v1.Node {
+	instanceTypes := instanceTypes()
+	nodes := []*v1.Node{}
+	Expect(cloudProvider.Create(ctx, constraints(zones...).Constraints, instanceTypes, quantity, func(node *v1.Node) error {
+		nodes = append(nodes, node)
+		return nil
+	})).To(Succeed())
+	return nodes
+}
@@ -16,13 +16,16 @@ package cloudprovider
 
 import (
 	"context"
+	"strconv"
 
 	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/utils/resources"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/client-go/kubernetes"
 	"knative.dev/pkg/apis"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 // CloudProvider interface is implemented by cloud providers to support provisioning.
@@ -34,13 +37,27 @@ type CloudProvider interface {
 	Create(context.Context, *v1alpha5.Constraints, []InstanceType, int, func(*v1.Node) error) error
 	// Delete node in cloudprovider
 	Delete(context.Context, *v1.Node) error
-	// GetInstanceTypes returns instance types supported by the cloudprovider.
-	// Availability of types or zone may vary by provisioner or over time.
-	GetInstanceTypes(context.Context, *v1alpha5.Constraints) ([]InstanceType, error)
+	// GetInstanceTypes returns the instance types that the provisioner may
+	// launch, each with the offerings that it is available in. Offerings may
+	// vary by provisioner or over time, e.g. as capacity becomes unavailable or
+	// prices change. The provisioner's requirements are not applied, so that
+	// the scheduler can reason about all of the offerings uniformly.
+	GetInstanceTypes(context.Context, *v1alpha5.Provisioner) ([]InstanceType, error)
 	// Default is a hook for additional defaulting logic at webhook time.
 	Default(context.Context, *v1alpha5.Constraints)
 	// Validate is a hook for additional validation logic at webhook time.
 	Validate(context.Context, *v1alpha5.Constraints) *apis.FieldError
+	// Verify checks the constraints against the cloud, e.g. that the resources
+	// they reference exist. Unlike Validate, it is called by the controller
+	// before provisioning and may call cloud provider APIs.
+	Verify(context.Context, *v1alpha5.Constraints) error
+	// IsDrifted returns the reason that the node no longer matches what would
+	// be launched for the provisioner, e.g. because its image was updated, or
+	// an empty string if it hasn't drifted.
+	IsDrifted(context.Context, *v1.Node, *v1alpha5.Provisioner) (string, error)
+	// Capabilities describe what the cloud provider is able to launch. They're
+	// checked when provisioners are admitted and pods are selected.
+	Capabilities() Capabilities
 	// Name returns the CloudProvider implementation name.
 	Name() string
 }
@@ -48,24 +65,57 @@ type CloudProvider interface {
 // Options are injected into cloud providers' factories
 type Options struct {
 	ClientSet *kubernetes.Clientset
+	// KubeClient is the manager's client, which reads through its cache. It's
+	// nil in binaries that don't run a manager, e.g. the webhook.
+	KubeClient client.Client
 }
 
 // InstanceType describes the properties of a potential node (either concrete attributes of an instance of this type
 // or supported options in the case of arrays)
 type InstanceType interface {
 	Name() string
-	// Note that though this is an array it is expected that all the Offerings are unique from one another
-	Offerings() []Offering
+	// Offerings are the zones and capacity types that the instance type is
+	// available in, each of which is expected to be unique.
+	Offerings() Offerings
 	Architecture() string
 	OperatingSystems() sets.String
 	CPU() *resource.Quantity
 	Memory() *resource.Quantity
+	// EphemeralStorage is the size of the filesystem that the kubelet uses for
+	// pods' ephemeral storage, such as container logs and emptyDir volumes.
+	EphemeralStorage() *resource.Quantity
 	Pods() *resource.Quantity
 	NvidiaGPUs() *resource.Quantity
 	AMDGPUs() *resource.Quantity
 	AWSNeurons() *resource.Quantity
+	HabanaGaudis() *resource.Quantity
 	AWSPodENI() *resource.Quantity
-	Overhead() v1.ResourceList
+	// ExtendedResources are any additional extended resources advertised by
+	// nodes of this instance type, such as vendor.com/foo.
+	ExtendedResources() v1.ResourceList
+	// Overhead is the instance type's default reservations for the kubelet,
+	// system daemons, and hard eviction thresholds.
+	Overhead() InstanceTypeOverhead
+	// Labels describe the instance type's attributes, e.g. its number of vCPUs,
+	// so that requirements can select instance types by them. They are set on
+	// the instance type's nodes, and their keys are expected to be registered
+	// as well known labels. Labels that an instance type doesn't have, e.g.
+	// the manufacturer of its GPUs, are treated as empty values.
+	Labels() map[string]string
+}
+
+// InstanceTypeOverhead is the resources that aren't allocatable to pods on a
+// node. Each component is kept separate so that a provisioner's kubelet
+// configuration can override it without dropping the others.
+type InstanceTypeOverhead struct {
+	KubeReserved      v1.ResourceList `json:"kubeReserved,omitempty"`
+	SystemReserved    v1.ResourceList `json:"systemReserved,omitempty"`
+	EvictionThreshold v1.ResourceList `json:"evictionThreshold,omitempty"`
+}
+
+// Total returns the sum of the overhead's components
+func (o InstanceTypeOverhead) Total() v1.ResourceList {
+	return resources.Merge(o.KubeReserved, o.SystemReserved, o.EvictionThreshold)
 }
 
 // An Offering describes where an InstanceType is available to be used, with the expectation that its properties
@@ -73,4 +123,85 @@ type InstanceType interface {
 type Offering struct {
 	CapacityType string
 	Zone         string
+	// Price is the hourly price of the offering in USD, or zero if it is unknown
+	Price float64
+}
+
+// Offerings are the offerings of an instance type. The zone and capacity type
+// of a node are chosen together, so requirements are satisfied by offerings
+// that are in an allowed zone and of an allowed capacity type, rather than by
+// any offering in an allowed zone and any offering of an allowed capacity type.
+type Offerings []Offering
+
+// Get returns the offering of the capacity type in the zone, if there is one.
+func (ofs Offerings) Get(capacityType, zone string) (Offering, bool) {
+	for _, offering := range ofs {
+		if offering.CapacityType == capacityType && offering.Zone == zone {
+			return offering, true
+		}
+	}
+	return Offering{}, false
+}
+
+// Compatible returns the offerings that are in one of the zones and of one of
+// the capacity types.
+func (ofs Offerings) Compatible(zones sets.String, capacityTypes sets.String) Offerings {
+	compatible := Offerings{}
+	for _, offering := range ofs {
+		if zones.Has(offering.Zone) && capacityTypes.Has(offering.CapacityType) {
+			compatible = append(compatible, offering)
+		}
+	}
+	return compatible
+}
+
+// Cheapest returns the offering with the lowest price, or false if none of
+// the offerings have a known price.
+func (ofs Offerings) Cheapest() (Offering, bool) {
+	cheapest, ok := Offering{}, false
+	for _, offering := range ofs {
+		if offering.Price <= 0 {
+			continue
+		}
+		if !ok || offering.Price < cheapest.Price {
+			cheapest, ok = offering, true
+		}
+	}
+	return cheapest, ok
+}
+
+// Zones returns the zones of the offerings.
+func (ofs Offerings) Zones() sets.String {
+	zones := sets.NewString()
+	for _, offering := range ofs {
+		zones.Insert(offering.Zone)
+	}
+	return zones
+}
+
+// CapacityTypes returns the capacity types of the offerings.
+func (ofs Offerings) CapacityTypes() sets.String {
+	capacityTypes := sets.NewString()
+	for _, offering := range ofs {
+		capacityTypes.Insert(offering.CapacityType)
+	}
+	return capacityTypes
+}
+
+// NodePrice returns the hourly price that the node was launched at, from its
+// price annotation, or else the price of its instance type's offering of its
+// capacity type in its zone, or zero if the price is unknown.
+func NodePrice(node *v1.Node, instanceTypes []InstanceType) float64 {
+	if price, err := strconv.ParseFloat(node.Annotations[v1alpha5.PriceAnnotationKey], 64); err == nil {
+		return price
+	}
+	for _, instanceType := range instanceTypes {
+		if instanceType.Name() != node.Labels[v1.LabelInstanceTypeStable] {
+			continue
+		}
+		if offering, ok := instanceType.Offerings().Get(node.Labels[v1alpha5.LabelCapacityType], node.Labels[v1.LabelTopologyZone]); ok {
+			return offering.Price
+		}
+	}
+	return 0
 }
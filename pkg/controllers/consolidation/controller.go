@@ -0,0 +1,331 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consolidation
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"knative.dev/pkg/logging"
+	controllerruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/controllers/disruption"
+	"github.com/aws/karpenter/pkg/controllers/state"
+	"github.com/aws/karpenter/pkg/events"
+	"github.com/aws/karpenter/pkg/scheduling"
+	"github.com/aws/karpenter/pkg/utils/featuregates"
+	"github.com/aws/karpenter/pkg/utils/injectabletime"
+	"github.com/aws/karpenter/pkg/utils/injection"
+	karpenterlogging "github.com/aws/karpenter/pkg/utils/logging"
+	"github.com/aws/karpenter/pkg/utils/node"
+	"github.com/aws/karpenter/pkg/utils/pod"
+	"github.com/aws/karpenter/pkg/utils/resources"
+)
+
+const (
+	controllerName = "consolidation"
+	// RequeueInterval is how often the nodes of each provisioner are evaluated
+	RequeueInterval = 5 * time.Minute
+	// StabilizationWindow is how long nodes must have existed before they are
+	// consolidated, so that pods have time to be scheduled to new nodes
+	StabilizationWindow = 5 * time.Minute
+)
+
+// Controller consolidates the nodes of provisioners, terminating a node if its
+// pods fit on the provisioner's other nodes, or replacing it if they fit on a
+// single node that is cheaper than it. Terminated nodes are cordoned and
+// drained by the termination controller, and their pods are rescheduled by the
+// kube scheduler. Replaced nodes are only deleted by the replacement controller
+// once the cheaper node is ready, so that their pods aren't pending while it
+// launches. At most one node of each provisioner is consolidated each
+// interval, and none while the provisioner's consolidation budget is exhausted
+// or the disruption budgets block it.
+type Controller struct {
+	kubeClient    client.Client
+	cloudProvider cloudprovider.CloudProvider
+	cluster       *state.Cluster
+	gatekeeper    *disruption.Gatekeeper
+	recorder      events.Recorder
+}
+
+// NewController is a constructor
+func NewController(kubeClient client.Client, cloudProvider cloudprovider.CloudProvider, cluster *state.Cluster, gatekeeper *disruption.Gatekeeper, recorder events.Recorder) *Controller {
+	return &Controller{
+		kubeClient:    kubeClient,
+		cloudProvider: cloudProvider,
+		cluster:       cluster,
+		gatekeeper:    gatekeeper,
+		recorder:      recorder,
+	}
+}
+
+// candidate is a node that may be consolidated, or that may run the pods of
+// nodes that are
+type candidate struct {
+	node *v1.Node
+	// pods are rescheduled if the node is terminated
+	pods []*v1.Pod
+	// requested are the resources of all of the node's pods, including daemons
+	requested v1.ResourceList
+	// consolidatable is false if the node is new, or runs pods that can't be
+	// rescheduled
+	consolidatable bool
+}
+
+// utilization is the fraction of the node's allocatable CPU that is requested
+func (c *candidate) utilization() float64 {
+	allocatable := c.node.Status.Allocatable.Cpu().MilliValue()
+	if allocatable == 0 {
+		return 0
+	}
+	return float64(c.requested.Cpu().MilliValue()) / float64(allocatable)
+}
+
+// Reconcile consolidates at most one of the provisioner's nodes
+func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	ctx = karpenterlogging.WithReconcile(ctx, controllerName, "provisioner", req.Name)
+	provisioner := &v1alpha5.Provisioner{}
+	if err := c.kubeClient.Get(ctx, req.NamespacedName, provisioner); err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+	// The feature gate may be enabled by the global settings without changing
+	// the provisioner, so disabled provisioners are requeued as well
+	if !provisioner.Spec.Consolidation.IsEnabled() || !injection.IsFeatureEnabled(ctx, featuregates.ConsolidationPolicy) {
+		return reconcile.Result{RequeueAfter: RequeueInterval}, nil
+	}
+	if err := c.consolidate(ctx, provisioner); err != nil {
+		return reconcile.Result{}, err
+	}
+	return reconcile.Result{RequeueAfter: RequeueInterval}, nil
+}
+
+func (c *Controller) consolidate(ctx context.Context, provisioner *v1alpha5.Provisioner) error {
+	nodes := &v1.NodeList{}
+	if err := c.kubeClient.List(ctx, nodes, client.MatchingLabels{v1alpha5.ProvisionerNameLabelKey: provisioner.Name}); err != nil {
+		return fmt.Errorf("listing nodes, %w", err)
+	}
+	// Respect the budget, and wait for nodes that are launching, since pending
+	// pods may be bound to them
+	terminating := int32(0)
+	for i := range nodes.Items {
+		if _, replacing := nodes.Items[i].Annotations[v1alpha5.ReplaceAnnotationKey]; replacing || !nodes.Items[i].DeletionTimestamp.IsZero() {
+			terminating++
+		}
+		if c.cluster.IsInFlight(nodes.Items[i].Name) {
+			return nil
+		}
+	}
+	if terminating >= provisioner.Spec.Consolidation.GetMaxUnavailable() {
+		logging.FromContext(ctx).Debugf("Skipping consolidation, %d node(s) are terminating", terminating)
+		return nil
+	}
+	candidates, err := c.candidates(ctx, nodes.Items)
+	if err != nil {
+		return err
+	}
+	// Lightly used nodes are the most likely to be consolidated
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].utilization() < candidates[j].utilization() })
+	var instanceTypes []cloudprovider.InstanceType
+	for _, candidate := range candidates {
+		if !candidate.consolidatable {
+			continue
+		}
+		if fitsOn(candidate, candidates) {
+			return c.terminate(ctx, provisioner, candidate, "its pods fit on other nodes")
+		}
+		if instanceTypes == nil {
+			if instanceTypes, err = c.cloudProvider.GetInstanceTypes(ctx, provisioner); err != nil {
+				return fmt.Errorf("getting instance types, %w", err)
+			}
+		}
+		cheaper, err := c.cheaperReplacement(ctx, provisioner, candidate, instanceTypes)
+		if err != nil {
+			return err
+		}
+		if cheaper != "" {
+			return c.replace(ctx, provisioner, candidate, cheaper)
+		}
+	}
+	return nil
+}
+
+// candidates returns the nodes that are able to run pods, i.e. that aren't
+// terminating, being replaced, not ready or cordoned. Of those, nodes aren't
+// consolidatable if they're new, reserved by scheduled capacity, or if they run
+// pods that can't be rescheduled: pods that aren't owned by a controller, that
+// are annotated to not be evicted, or whose pod (anti-)affinity Karpenter is
+// unable to simulate.
+func (c *Controller) candidates(ctx context.Context, nodes []v1.Node) ([]*candidate, error) {
+	candidates := []*candidate{}
+	for i := range nodes {
+		n := &nodes[i]
+		if _, replacing := n.Annotations[v1alpha5.ReplaceAnnotationKey]; replacing || !n.DeletionTimestamp.IsZero() || !node.IsReady(n) || n.Spec.Unschedulable {
+			continue
+		}
+		pods := &v1.PodList{}
+		if err := c.kubeClient.List(ctx, pods, client.MatchingFields{"spec.nodeName": n.Name}); err != nil {
+			return nil, fmt.Errorf("listing pods for node, %w", err)
+		}
+		candidate := &candidate{node: n, consolidatable: !injectabletime.Now().Before(n.CreationTimestamp.Add(StabilizationWindow))}
+		if _, ok := n.Annotations[v1alpha5.ScheduledCapacityAnnotationKey]; ok {
+			candidate.consolidatable = false
+		}
+		scheduled := []*v1.Pod{}
+		for j := range pods.Items {
+			p := &pods.Items[j]
+			if pod.IsTerminal(p) {
+				continue
+			}
+			scheduled = append(scheduled, p)
+			if pod.IsOwnedByDaemonSet(p) || pod.IsOwnedByNode(p) {
+				continue
+			}
+			if len(p.OwnerReferences) == 0 || p.Annotations[v1alpha5.DoNotEvictPodAnnotationKey] == "true" || hasPodAffinity(p) {
+				candidate.consolidatable = false
+			}
+			candidate.pods = append(candidate.pods, p)
+		}
+		candidate.requested = resources.RequestsForPods(scheduled...)
+		candidate.requested[v1.ResourcePods] = *resource.NewQuantity(int64(len(scheduled)), resource.DecimalSI)
+		candidates = append(candidates, candidate)
+	}
+	return candidates, nil
+}
+
+// hasPodAffinity returns true if the pod has pod affinity or anti-affinity
+func hasPodAffinity(p *v1.Pod) bool {
+	return p.Spec.Affinity != nil && (p.Spec.Affinity.PodAffinity != nil || p.Spec.Affinity.PodAntiAffinity != nil)
+}
+
+// fitsOn returns true if the candidate's pods fit on the remaining capacity of
+// the other nodes, placing each pod, from largest to smallest, on the first
+// node that it fits. Like binding pods to in flight nodes, pods with topology
+// spread constraints or host ports don't fit on other nodes, and are left to
+// the simulation of a replacement, which solves their topologies and avoids
+// port conflicts in the same way as provisioning.
+func fitsOn(candidate *candidate, others []*candidate) bool {
+	for _, p := range candidate.pods {
+		if len(p.Spec.TopologySpreadConstraints) > 0 || len(pod.HostPorts(p)) > 0 {
+			return false
+		}
+	}
+	nodes := []*state.InFlightNode{}
+	for _, other := range others {
+		if other != candidate {
+			nodes = append(nodes, &state.InFlightNode{Node: other.node, Requested: other.requested.DeepCopy()})
+		}
+	}
+	pods := append([]*v1.Pod{}, candidate.pods...)
+	sort.SliceStable(pods, func(i, j int) bool {
+		a, b := resources.RequestsForPods(pods[i]), resources.RequestsForPods(pods[j])
+		return a.Cpu().Cmp(*b.Cpu()) > 0
+	})
+	for _, p := range pods {
+		placed := false
+		for _, n := range nodes {
+			if !n.Compatible(p) {
+				continue
+			}
+			if requested, ok := n.Fits(p); ok {
+				n.Requested = requested
+				placed = true
+				break
+			}
+		}
+		if !placed {
+			return false
+		}
+	}
+	return true
+}
+
+// cheaperReplacement returns why the candidate should be replaced if the
+// scheduler simulation packs its pods on a single node of the provisioner that
+// is cheaper than it, or an empty string otherwise. Nodes whose price is
+// unknown aren't replaced.
+func (c *Controller) cheaperReplacement(ctx context.Context, provisioner *v1alpha5.Provisioner, candidate *candidate, instanceTypes []cloudprovider.InstanceType) (string, error) {
+	current := cloudprovider.NodePrice(candidate.node, instanceTypes)
+	if current == 0 || len(candidate.pods) == 0 {
+		return "", nil
+	}
+	result, err := scheduling.Simulate(ctx, candidate.pods, []*v1alpha5.Provisioner{provisioner}, instanceTypes)
+	if err != nil {
+		return "", fmt.Errorf("simulating replacement, %w", err)
+	}
+	if len(result.Nodes) != 1 || len(result.Unschedulable) != 0 {
+		return "", nil
+	}
+	replacement := result.Nodes[0]
+	zones, capacityTypes := replacement.Constraints.Requirements.Zones(), replacement.Constraints.Requirements.CapacityTypes()
+	for _, instanceType := range replacement.InstanceTypeOptions {
+		offering, ok := instanceType.Offerings().Compatible(zones, capacityTypes).Cheapest()
+		if ok && offering.Price < current {
+			return fmt.Sprintf("its pods fit on a cheaper %s %s node, at $%.4f/hour rather than $%.4f/hour", offering.CapacityType, instanceType.Name(), offering.Price, current), nil
+		}
+	}
+	return "", nil
+}
+
+// replace launches the cheaper node before deleting the candidate, through the
+// replacement controller
+func (c *Controller) replace(ctx context.Context, provisioner *v1alpha5.Provisioner, candidate *candidate, reason string) error {
+	replaced, err := c.gatekeeper.Replace(ctx, provisioner, candidate.node, fmt.Sprintf("consolidated, %s", reason))
+	if err != nil {
+		return fmt.Errorf("replacing node %s, %w", candidate.node.Name, err)
+	}
+	if replaced {
+		logging.FromContext(ctx).Infof("Replacing node %s to consolidate it, %s", candidate.node.Name, reason)
+		c.recorder.NodeConsolidated(candidate.node, reason)
+	}
+	return nil
+}
+
+func (c *Controller) terminate(ctx context.Context, provisioner *v1alpha5.Provisioner, candidate *candidate, reason string) error {
+	disrupted, err := c.gatekeeper.Disrupt(ctx, provisioner, candidate.node, fmt.Sprintf("consolidated, %s", reason))
+	if err != nil {
+		return fmt.Errorf("disrupting node %s, %w", candidate.node.Name, err)
+	}
+	if disrupted {
+		logging.FromContext(ctx).Infof("Consolidating node %s, %s", candidate.node.Name, reason)
+		c.recorder.NodeConsolidated(candidate.node, reason)
+	}
+	return nil
+}
+
+// Register the controller to the manager
+func (c *Controller) Register(_ context.Context, m manager.Manager) error {
+	return controllerruntime.
+		NewControllerManagedBy(m).
+		Named(controllerName).
+		For(&v1alpha5.Provisioner{}).
+		// Nodes are consolidated one at a time, so that their pods are never
+		// moved to a node that another reconcile is terminating
+		WithOptions(controller.Options{MaxConcurrentReconciles: 1}).
+		Complete(c)
+}
@@ -0,0 +1,256 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consolidation_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/cloudprovider/fake"
+	"github.com/aws/karpenter/pkg/controllers/consolidation"
+	"github.com/aws/karpenter/pkg/controllers/disruption"
+	"github.com/aws/karpenter/pkg/controllers/state"
+	"github.com/aws/karpenter/pkg/events"
+	"github.com/aws/karpenter/pkg/test"
+	"github.com/aws/karpenter/pkg/utils/injectabletime"
+	"github.com/aws/karpenter/pkg/utils/injection"
+	"github.com/aws/karpenter/pkg/utils/options"
+
+	. "github.com/aws/karpenter/pkg/test/expectations"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	. "knative.dev/pkg/logging/testing"
+	"knative.dev/pkg/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var ctx context.Context
+var cloudProvider *fake.CloudProvider
+var cluster *state.Cluster
+var controller *consolidation.Controller
+var env *test.Environment
+
+func TestAPIs(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Consolidation")
+}
+
+var _ = BeforeSuite(func() {
+	env = test.NewEnvironment(ctx, func(e *test.Environment) {
+		cloudProvider = &fake.CloudProvider{}
+		cluster = state.NewCluster()
+		controller = consolidation.NewController(e.Client, cloudProvider, cluster, disruption.NewGatekeeper(e.Client), events.NewRecorder(&record.FakeRecorder{}))
+	})
+	Expect(env.Start()).To(Succeed(), "Failed to start environment")
+	ctx = injection.WithOptions(ctx, options.Options{FeatureGates: "ConsolidationPolicy=true"})
+})
+
+var _ = AfterSuite(func() {
+	Expect(env.Stop()).To(Succeed(), "Failed to stop environment")
+})
+
+var _ = Describe("Consolidation", func() {
+	var provisioner *v1alpha5.Provisioner
+	BeforeEach(func() {
+		provisioner = &v1alpha5.Provisioner{
+			ObjectMeta: metav1.ObjectMeta{Name: v1alpha5.DefaultProvisioner.Name},
+			Spec:       v1alpha5.ProvisionerSpec{Consolidation: v1alpha5.Consolidation{Enabled: ptr.Bool(true)}},
+		}
+		cloudProvider.Reset()
+		cloudProvider.InstanceTypes = []cloudprovider.InstanceType{
+			fake.NewInstanceType(fake.InstanceTypeOptions{
+				Name:      "large-instance-type",
+				CPU:       resource.MustParse("4"),
+				Offerings: []cloudprovider.Offering{{CapacityType: "on-demand", Zone: "test-zone-1", Price: 1}},
+			}),
+			fake.NewInstanceType(fake.InstanceTypeOptions{
+				Name:      "small-instance-type",
+				CPU:       resource.MustParse("2"),
+				Offerings: []cloudprovider.Offering{{CapacityType: "on-demand", Zone: "test-zone-1", Price: 0.1}},
+			}),
+		}
+		// Nodes are only consolidated after the stabilization window
+		injectabletime.Now = func() time.Time { return time.Now().Add(consolidation.StabilizationWindow) }
+	})
+
+	AfterEach(func() {
+		injectabletime.Now = time.Now
+		ExpectCleanedUp(ctx, env.Client)
+	})
+
+	node := func(instanceType string, cpu string) *v1.Node {
+		return test.Node(test.NodeOptions{
+			Finalizers: []string{v1alpha5.TerminationFinalizer},
+			Labels: map[string]string{
+				v1alpha5.ProvisionerNameLabelKey: provisioner.Name,
+				v1.LabelInstanceTypeStable:       instanceType,
+				v1alpha5.LabelCapacityType:       "on-demand",
+				v1.LabelTopologyZone:             "test-zone-1",
+			},
+			Allocatable: v1.ResourceList{v1.ResourceCPU: resource.MustParse(cpu), v1.ResourcePods: resource.MustParse("10")},
+		})
+	}
+	pod := func(node *v1.Node, cpu string) *v1.Pod {
+		return test.Pod(test.PodOptions{
+			NodeName:             node.Name,
+			OwnerReferences:      []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "test-replicaset", UID: types.UID("test-uid")}},
+			ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse(cpu)}},
+		})
+	}
+	ExpectTerminating := func(node *v1.Node, terminating bool) {
+		Expect(ExpectNodeExists(ctx, env.Client, node.Name).DeletionTimestamp.IsZero()).To(Equal(!terminating))
+	}
+
+	It("should terminate empty nodes", func() {
+		empty := node("large-instance-type", "4")
+		ExpectApplied(ctx, env.Client, provisioner)
+		ExpectCreatedWithStatus(ctx, env.Client, empty)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+		ExpectTerminating(empty, true)
+	})
+	It("should terminate a node whose pods fit on other nodes", func() {
+		underutilized, utilized := node("large-instance-type", "4"), node("large-instance-type", "4")
+		ExpectApplied(ctx, env.Client, provisioner)
+		ExpectCreatedWithStatus(ctx, env.Client, underutilized, utilized)
+		ExpectCreated(ctx, env.Client, pod(underutilized, "1"), pod(utilized, "2"))
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+		ExpectTerminating(underutilized, true)
+		ExpectTerminating(utilized, false)
+	})
+	It("should replace a node whose pods fit on a cheaper node before terminating it", func() {
+		expensive := node("large-instance-type", "4")
+		ExpectApplied(ctx, env.Client, provisioner)
+		ExpectCreatedWithStatus(ctx, env.Client, expensive)
+		ExpectCreated(ctx, env.Client, pod(expensive, "1"))
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+		ExpectTerminating(expensive, false)
+		Expect(ExpectNodeExists(ctx, env.Client, expensive.Name).Annotations).To(HaveKey(v1alpha5.ReplaceAnnotationKey))
+	})
+	It("should not move pods with topology spread constraints to other nodes", func() {
+		underutilized, utilized := node("small-instance-type", "2"), node("large-instance-type", "4")
+		spread := pod(underutilized, "1")
+		spread.Spec.TopologySpreadConstraints = []v1.TopologySpreadConstraint{{
+			MaxSkew:           1,
+			TopologyKey:       v1.LabelHostname,
+			WhenUnsatisfiable: v1.DoNotSchedule,
+			LabelSelector:     &metav1.LabelSelector{MatchLabels: spread.Labels},
+		}}
+		ExpectApplied(ctx, env.Client, provisioner)
+		ExpectCreatedWithStatus(ctx, env.Client, underutilized, utilized)
+		ExpectCreated(ctx, env.Client, spread, pod(utilized, "2"))
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+		ExpectTerminating(underutilized, false)
+	})
+	It("should not consolidate nodes with pods that have pod anti-affinity", func() {
+		n := node("large-instance-type", "4")
+		antiAffinity := pod(n, "1")
+		antiAffinity.Spec.Affinity = &v1.Affinity{PodAntiAffinity: &v1.PodAntiAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: []v1.PodAffinityTerm{{TopologyKey: v1.LabelHostname, LabelSelector: &metav1.LabelSelector{MatchLabels: antiAffinity.Labels}}},
+		}}
+		ExpectApplied(ctx, env.Client, provisioner)
+		ExpectCreatedWithStatus(ctx, env.Client, n)
+		ExpectCreated(ctx, env.Client, antiAffinity)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+		ExpectTerminating(n, false)
+		Expect(ExpectNodeExists(ctx, env.Client, n.Name).Annotations).ToNot(HaveKey(v1alpha5.ReplaceAnnotationKey))
+	})
+	It("should not terminate a node that is the cheapest for its pods", func() {
+		cheapest := node("small-instance-type", "2")
+		ExpectApplied(ctx, env.Client, provisioner)
+		ExpectCreatedWithStatus(ctx, env.Client, cheapest)
+		ExpectCreated(ctx, env.Client, pod(cheapest, "1"))
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+		ExpectTerminating(cheapest, false)
+	})
+	It("should terminate at most one node at a time", func() {
+		first, second := node("large-instance-type", "4"), node("large-instance-type", "4")
+		ExpectApplied(ctx, env.Client, provisioner)
+		ExpectCreatedWithStatus(ctx, env.Client, first, second)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+		Expect(ExpectNodeExists(ctx, env.Client, first.Name).DeletionTimestamp.IsZero()).ToNot(
+			Equal(ExpectNodeExists(ctx, env.Client, second.Name).DeletionTimestamp.IsZero()))
+	})
+	It("should terminate as many nodes at a time as the budget allows", func() {
+		provisioner.Spec.Consolidation.MaxUnavailable = ptr.Int32(2)
+		first, second := node("large-instance-type", "4"), node("large-instance-type", "4")
+		ExpectApplied(ctx, env.Client, provisioner)
+		ExpectCreatedWithStatus(ctx, env.Client, first, second)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+		ExpectTerminating(first, true)
+		ExpectTerminating(second, true)
+	})
+	It("should not terminate nodes with pods that can't be evicted", func() {
+		n := node("large-instance-type", "4")
+		doNotEvict := pod(n, "1")
+		doNotEvict.Annotations = map[string]string{v1alpha5.DoNotEvictPodAnnotationKey: "true"}
+		ExpectApplied(ctx, env.Client, provisioner)
+		ExpectCreatedWithStatus(ctx, env.Client, n)
+		ExpectCreated(ctx, env.Client, doNotEvict)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+		ExpectTerminating(n, false)
+	})
+	It("should not terminate nodes with pods that aren't owned by a controller", func() {
+		n := node("large-instance-type", "4")
+		unowned := pod(n, "1")
+		unowned.OwnerReferences = nil
+		ExpectApplied(ctx, env.Client, provisioner)
+		ExpectCreatedWithStatus(ctx, env.Client, n)
+		ExpectCreated(ctx, env.Client, unowned)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+		ExpectTerminating(n, false)
+	})
+	It("should not terminate nodes of scheduled capacity", func() {
+		empty := node("large-instance-type", "4")
+		empty.Annotations = map[string]string{v1alpha5.ScheduledCapacityAnnotationKey: "test-scheduled-capacity"}
+		ExpectApplied(ctx, env.Client, provisioner)
+		ExpectCreatedWithStatus(ctx, env.Client, empty)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+		ExpectTerminating(empty, false)
+	})
+	It("should not terminate new nodes", func() {
+		injectabletime.Now = time.Now
+		empty := node("large-instance-type", "4")
+		ExpectApplied(ctx, env.Client, provisioner)
+		ExpectCreatedWithStatus(ctx, env.Client, empty)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+		ExpectTerminating(empty, false)
+	})
+	It("should not terminate nodes of provisioners without consolidation", func() {
+		provisioner.Spec.Consolidation.Enabled = nil
+		empty := node("large-instance-type", "4")
+		ExpectApplied(ctx, env.Client, provisioner)
+		ExpectCreatedWithStatus(ctx, env.Client, empty)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+		ExpectTerminating(empty, false)
+	})
+	It("should not terminate nodes if the feature gate is disabled", func() {
+		empty := node("large-instance-type", "4")
+		ExpectApplied(ctx, env.Client, provisioner)
+		ExpectCreatedWithStatus(ctx, env.Client, empty)
+		ExpectReconcileSucceeded(injection.WithOptions(ctx, options.Options{}), controller, client.ObjectKeyFromObject(provisioner))
+		ExpectTerminating(empty, false)
+	})
+})
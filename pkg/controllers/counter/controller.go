@@ -18,8 +18,8 @@ import (
 	"fmt"
 
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
-	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/types"
 	controllerruntime "sigs.k8s.io/controller-runtime"
 
@@ -38,12 +38,16 @@ import (
 // Controller for the resource
 type Controller struct {
 	kubeClient client.Client
+	resources  *Resources
+	nodes      *Nodes
 }
 
 // NewController is a constructor
 func NewController(kubeClient client.Client) *Controller {
 	return &Controller{
 		kubeClient: kubeClient,
+		resources:  &Resources{},
+		nodes:      &Nodes{},
 	}
 }
 
@@ -57,35 +61,28 @@ func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reco
 		return reconcile.Result{}, nil
 	}
 	persisted := provisioner.DeepCopy()
-	// Determine resource usage and update provisioner.status.resources
-	resourceCounts, err := c.resourceCountsFor(ctx, provisioner.Name)
-	if err != nil {
-		return reconcile.Result{}, fmt.Errorf("computing resource usage, %w", err)
+	nodes := v1.NodeList{}
+	if err := c.kubeClient.List(ctx, &nodes, client.MatchingLabels{v1alpha5.ProvisionerNameLabelKey: provisioner.Name}); err != nil {
+		return reconcile.Result{}, fmt.Errorf("listing nodes, %w", err)
+	}
+	// Each reconciler updates a portion of provisioner.status from the provisioner's nodes
+	for _, reconciler := range []interface {
+		Reconcile(context.Context, *v1alpha5.Provisioner, []v1.Node)
+	}{
+		c.resources,
+		c.nodes,
+	} {
+		reconciler.Reconcile(ctx, provisioner, nodes.Items)
+	}
+	if equality.Semantic.DeepEqual(provisioner.Status, persisted.Status) {
+		return reconcile.Result{}, nil
 	}
-	provisioner.Status.Resources = resourceCounts
 	if err := c.kubeClient.Status().Patch(ctx, provisioner, client.MergeFrom(persisted)); err != nil {
 		return reconcile.Result{}, fmt.Errorf("patching provisioner, %w", err)
 	}
 	return reconcile.Result{}, nil
 }
 
-func (c *Controller) resourceCountsFor(ctx context.Context, provisionerName string) (v1.ResourceList, error) {
-	nodes := v1.NodeList{}
-	if err := c.kubeClient.List(ctx, &nodes, client.MatchingLabels{v1alpha5.ProvisionerNameLabelKey: provisionerName}); err != nil {
-		return nil, err
-	}
-	var cpu = resource.NewScaledQuantity(0, 0)
-	var memory = resource.NewScaledQuantity(0, resource.Giga)
-	for _, node := range nodes.Items {
-		cpu.Add(*node.Status.Capacity.Cpu())
-		memory.Add(*node.Status.Capacity.Memory())
-	}
-	return v1.ResourceList{
-		v1.ResourceCPU:    *cpu,
-		v1.ResourceMemory: *memory,
-	}, nil
-}
-
 // Register the controller to the manager
 func (c *Controller) Register(ctx context.Context, m manager.Manager) error {
 	return controllerruntime.
@@ -0,0 +1,45 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package counter
+
+import (
+	"context"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	v1 "k8s.io/api/core/v1"
+	"knative.dev/pkg/apis"
+)
+
+// Nodes is a subreconciler that records the number of nodes owned by the
+// provisioner, by capacity type, and when the most recent one was launched.
+type Nodes struct{}
+
+// Reconcile reconciles the provisioner's node counts
+func (n *Nodes) Reconcile(_ context.Context, provisioner *v1alpha5.Provisioner, nodes []v1.Node) {
+	provisioner.Status.Nodes = int32(len(nodes))
+	provisioner.Status.NodesByCapacityType = nil
+	for _, node := range nodes {
+		if capacityType, ok := node.Labels[v1alpha5.LabelCapacityType]; ok {
+			if provisioner.Status.NodesByCapacityType == nil {
+				provisioner.Status.NodesByCapacityType = map[string]int32{}
+			}
+			provisioner.Status.NodesByCapacityType[capacityType]++
+		}
+		// Only move forward in time, since the most recent node may have been deleted
+		if lastProvisioningTime := provisioner.Status.LastProvisioningTime; lastProvisioningTime == nil || lastProvisioningTime.Inner.Before(&node.CreationTimestamp) {
+			provisioner.Status.LastProvisioningTime = &apis.VolatileTime{Inner: node.CreationTimestamp}
+		}
+	}
+}
@@ -0,0 +1,45 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package counter
+
+import (
+	"context"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// Resources is a subreconciler that sums the capacity of the provisioner's
+// nodes into provisioner.status.resources
+type Resources struct{}
+
+// Reconcile reconciles the provisioner's resources
+func (r *Resources) Reconcile(_ context.Context, provisioner *v1alpha5.Provisioner, nodes []v1.Node) {
+	provisioner.Status.Resources = resourceCountsFor(nodes)
+}
+
+func resourceCountsFor(nodes []v1.Node) v1.ResourceList {
+	var cpu = resource.NewScaledQuantity(0, 0)
+	var memory = resource.NewScaledQuantity(0, resource.Giga)
+	for _, node := range nodes {
+		cpu.Add(*node.Status.Capacity.Cpu())
+		memory.Add(*node.Status.Capacity.Memory())
+	}
+	return v1.ResourceList{
+		v1.ResourceCPU:    *cpu,
+		v1.ResourceMemory: *memory,
+	}
+}
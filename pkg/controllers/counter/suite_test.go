@@ -0,0 +1,99 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package counter_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/controllers/counter"
+	"github.com/aws/karpenter/pkg/test"
+
+	. "github.com/aws/karpenter/pkg/test/expectations"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	. "knative.dev/pkg/logging/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var ctx context.Context
+var controller *counter.Controller
+var env *test.Environment
+
+func TestAPIs(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Counter")
+}
+
+var _ = BeforeSuite(func() {
+	env = test.NewEnvironment(ctx, func(e *test.Environment) {
+		controller = counter.NewController(e.Client)
+	})
+	Expect(env.Start()).To(Succeed(), "Failed to start environment")
+})
+
+var _ = AfterSuite(func() {
+	Expect(env.Stop()).To(Succeed(), "Failed to stop environment")
+})
+
+var _ = Describe("Controller", func() {
+	var provisioner *v1alpha5.Provisioner
+	BeforeEach(func() {
+		provisioner = &v1alpha5.Provisioner{
+			ObjectMeta: metav1.ObjectMeta{Name: v1alpha5.DefaultProvisioner.Name},
+			Spec:       v1alpha5.ProvisionerSpec{},
+		}
+	})
+
+	AfterEach(func() {
+		ExpectCleanedUp(ctx, env.Client)
+	})
+
+	It("should count nodes by capacity type", func() {
+		ExpectCreated(ctx, env.Client, provisioner,
+			test.Node(test.NodeOptions{Labels: map[string]string{v1alpha5.ProvisionerNameLabelKey: provisioner.Name, v1alpha5.LabelCapacityType: "spot"}}),
+			test.Node(test.NodeOptions{Labels: map[string]string{v1alpha5.ProvisionerNameLabelKey: provisioner.Name, v1alpha5.LabelCapacityType: "spot"}}),
+			test.Node(test.NodeOptions{Labels: map[string]string{v1alpha5.ProvisionerNameLabelKey: provisioner.Name, v1alpha5.LabelCapacityType: "on-demand"}}),
+			test.Node(test.NodeOptions{Labels: map[string]string{v1alpha5.ProvisionerNameLabelKey: "other", v1alpha5.LabelCapacityType: "on-demand"}}),
+		)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+		Expect(env.Client.Get(ctx, client.ObjectKeyFromObject(provisioner), provisioner)).To(Succeed())
+		Expect(provisioner.Status.Nodes).To(BeNumerically("==", 3))
+		Expect(provisioner.Status.NodesByCapacityType).To(Equal(map[string]int32{"spot": 2, "on-demand": 1}))
+	})
+	It("should record the creation time of the most recent node", func() {
+		ExpectCreated(ctx, env.Client, provisioner)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+		Expect(env.Client.Get(ctx, client.ObjectKeyFromObject(provisioner), provisioner)).To(Succeed())
+		Expect(provisioner.Status.LastProvisioningTime).To(BeNil())
+
+		node := test.Node(test.NodeOptions{Labels: map[string]string{v1alpha5.ProvisionerNameLabelKey: provisioner.Name}})
+		ExpectCreated(ctx, env.Client, node)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+		Expect(env.Client.Get(ctx, client.ObjectKeyFromObject(provisioner), provisioner)).To(Succeed())
+		Expect(provisioner.Status.LastProvisioningTime).ToNot(BeNil())
+		Expect(provisioner.Status.LastProvisioningTime.Inner.Time.Unix()).To(Equal(ExpectNodeExists(ctx, env.Client, node.Name).CreationTimestamp.Unix()))
+
+		// The time is retained after the node is deleted
+		ExpectDeleted(ctx, env.Client, node)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+		Expect(env.Client.Get(ctx, client.ObjectKeyFromObject(provisioner), provisioner)).To(Succeed())
+		Expect(provisioner.Status.LastProvisioningTime).ToNot(BeNil())
+		Expect(provisioner.Status.Nodes).To(BeNumerically("==", 0))
+	})
+})
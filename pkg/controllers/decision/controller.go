@@ -0,0 +1,78 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package decision
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/utils/injectabletime"
+	"github.com/aws/karpenter/pkg/utils/injection"
+	karpenterlogging "github.com/aws/karpenter/pkg/utils/logging"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"knative.dev/pkg/logging"
+	controllerruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const controllerName = "decision"
+
+// Controller garbage collects placement decisions once they are older than
+// their time to live
+type Controller struct {
+	kubeClient client.Client
+}
+
+// NewController constructs a controller instance
+func NewController(kubeClient client.Client) *Controller {
+	return &Controller{kubeClient: kubeClient}
+}
+
+// Reconcile the resource
+func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	ctx = karpenterlogging.WithReconcile(ctx, controllerName, "placementdecision", req.String())
+	decision := &v1alpha5.PlacementDecision{}
+	if err := c.kubeClient.Get(ctx, req.NamespacedName, decision); err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+	// Decisions are kept until they expire. If recording is disabled, existing
+	// decisions are deleted rather than kept forever.
+	ttl := injection.GetOptions(ctx).PlacementDecisionTTL
+	expirationTime := decision.CreationTimestamp.Add(ttl)
+	if now := injectabletime.Now(); now.Before(expirationTime) {
+		return reconcile.Result{RequeueAfter: expirationTime.Sub(now)}, nil
+	}
+	logging.FromContext(ctx).Debugf("Deleting expired placement decision after %s", ttl)
+	if err := c.kubeClient.Delete(ctx, decision); err != nil && !errors.IsNotFound(err) {
+		return reconcile.Result{}, fmt.Errorf("deleting placement decision, %w", err)
+	}
+	return reconcile.Result{}, nil
+}
+
+func (c *Controller) Register(_ context.Context, m manager.Manager) error {
+	return controllerruntime.
+		NewControllerManagedBy(m).
+		Named(controllerName).
+		For(&v1alpha5.PlacementDecision{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: 10}).
+		Complete(c)
+}
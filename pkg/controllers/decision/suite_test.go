@@ -0,0 +1,102 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package decision_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Pallinder/go-randomdata"
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/controllers/decision"
+	"github.com/aws/karpenter/pkg/test"
+	"github.com/aws/karpenter/pkg/utils/injectabletime"
+	"github.com/aws/karpenter/pkg/utils/injection"
+	"github.com/aws/karpenter/pkg/utils/options"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	. "github.com/aws/karpenter/pkg/test/expectations"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "knative.dev/pkg/logging/testing"
+)
+
+var ctx context.Context
+var controller *decision.Controller
+var env *test.Environment
+
+func TestAPIs(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Decision")
+}
+
+var _ = BeforeSuite(func() {
+	ctx = injection.WithOptions(ctx, options.Options{PlacementDecisionTTL: time.Hour})
+	env = test.NewEnvironment(ctx, func(e *test.Environment) {
+		controller = decision.NewController(e.Client)
+	})
+	Expect(env.Start()).To(Succeed(), "Failed to start environment")
+})
+
+var _ = AfterSuite(func() {
+	Expect(env.Stop()).To(Succeed(), "Failed to stop environment")
+})
+
+var _ = Describe("Controller", func() {
+	var placementDecision *v1alpha5.PlacementDecision
+	BeforeEach(func() {
+		placementDecision = &v1alpha5.PlacementDecision{
+			ObjectMeta: metav1.ObjectMeta{Name: strings.ToLower(randomdata.SillyName()), Namespace: "default"},
+			Spec: v1alpha5.PlacementDecisionSpec{
+				Provisioner: v1alpha5.DefaultProvisioner.Name,
+				Node:        strings.ToLower(randomdata.SillyName()),
+			},
+		}
+	})
+
+	AfterEach(func() {
+		injectabletime.Now = time.Now
+		ExpectCleanedUp(ctx, env.Client)
+	})
+
+	It("should keep decisions until they expire", func() {
+		ExpectCreated(ctx, env.Client, placementDecision)
+		result, err := controller.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(placementDecision)})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.RequeueAfter).To(BeNumerically(">", 0))
+		Expect(result.RequeueAfter).To(BeNumerically("<=", time.Hour))
+		Expect(env.Client.Get(ctx, client.ObjectKeyFromObject(placementDecision), placementDecision)).To(Succeed())
+	})
+	It("should delete decisions once they expire", func() {
+		ExpectCreated(ctx, env.Client, placementDecision)
+		// Simulate time passing
+		injectabletime.Now = func() time.Time { return time.Now().Add(time.Hour) }
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(placementDecision))
+		ExpectNotFound(ctx, env.Client, placementDecision)
+	})
+	It("should delete decisions if recording is disabled", func() {
+		ExpectCreated(ctx, env.Client, placementDecision)
+		ExpectReconcileSucceeded(injection.WithOptions(ctx, options.Options{}), controller, client.ObjectKeyFromObject(placementDecision))
+		ExpectNotFound(ctx, env.Client, placementDecision)
+	})
+	It("should ignore decisions that do not exist", func() {
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(placementDecision))
+	})
+})
@@ -0,0 +1,176 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deletion
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/logging"
+	controllerruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/audit"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/utils/functional"
+	karpenterlogging "github.com/aws/karpenter/pkg/utils/logging"
+)
+
+const (
+	controllerName = "deletion"
+	// RequeueInterval is how often a provisioner that is being deleted is
+	// checked for its nodes to be gone
+	RequeueInterval = 10 * time.Second
+)
+
+// Controller applies the deletion policy of provisioners, with the termination
+// finalizer that it adds to them. Nodes are deleted and drained, orphaned, or
+// terminated without draining them, and the finalizer is removed once they're
+// gone or orphaned. Orphaned nodes are released from Karpenter entirely.
+// Provisioners that are deleted with --cascade=orphan orphan their nodes,
+// regardless of their policy.
+type Controller struct {
+	kubeClient    client.Client
+	cloudProvider cloudprovider.CloudProvider
+}
+
+// NewController is a constructor
+func NewController(kubeClient client.Client, cloudProvider cloudprovider.CloudProvider) *Controller {
+	return &Controller{
+		kubeClient:    kubeClient,
+		cloudProvider: cloudProvider,
+	}
+}
+
+// Reconcile adds the finalizer to the provisioner, or applies its deletion
+// policy if it's being deleted
+func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	ctx = karpenterlogging.WithReconcile(ctx, controllerName, "provisioner", req.Name)
+	provisioner := &v1alpha5.Provisioner{}
+	if err := c.kubeClient.Get(ctx, req.NamespacedName, provisioner); err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+	if provisioner.DeletionTimestamp.IsZero() {
+		if functional.ContainsString(provisioner.Finalizers, v1alpha5.TerminationFinalizer) {
+			return reconcile.Result{}, nil
+		}
+		persisted := provisioner.DeepCopy()
+		provisioner.Finalizers = append(provisioner.Finalizers, v1alpha5.TerminationFinalizer)
+		if err := c.kubeClient.Patch(ctx, provisioner, client.MergeFrom(persisted)); err != nil {
+			return reconcile.Result{}, fmt.Errorf("adding finalizer, %w", err)
+		}
+		return reconcile.Result{}, nil
+	}
+	if !functional.ContainsString(provisioner.Finalizers, v1alpha5.TerminationFinalizer) {
+		return reconcile.Result{}, nil
+	}
+	nodes := &v1.NodeList{}
+	if err := c.kubeClient.List(ctx, nodes, client.MatchingLabels{v1alpha5.ProvisionerNameLabelKey: provisioner.Name}); err != nil {
+		return reconcile.Result{}, fmt.Errorf("listing nodes, %w", err)
+	}
+	policy := provisioner.Spec.GetDeletionPolicy()
+	if functional.ContainsString(provisioner.Finalizers, metav1.FinalizerOrphanDependents) {
+		policy = v1alpha5.DeletionPolicyOrphan
+	}
+	for i := range nodes.Items {
+		if err := c.finalize(ctx, provisioner, policy, &nodes.Items[i]); err != nil {
+			return reconcile.Result{}, fmt.Errorf("finalizing node %s, %w", nodes.Items[i].Name, err)
+		}
+	}
+	// Drained nodes are deleted once the termination controller has drained them
+	if policy == v1alpha5.DeletionPolicyDrain && len(nodes.Items) > 0 {
+		return reconcile.Result{RequeueAfter: RequeueInterval}, nil
+	}
+	persisted := provisioner.DeepCopy()
+	provisioner.Finalizers = functional.StringSliceWithout(provisioner.Finalizers, v1alpha5.TerminationFinalizer)
+	if err := c.kubeClient.Patch(ctx, provisioner, client.MergeFrom(persisted)); err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, fmt.Errorf("removing finalizer, %w", err)
+	}
+	logging.FromContext(ctx).Infof("Finalized provisioner with deletion policy %s", policy)
+	return reconcile.Result{}, nil
+}
+
+// finalize applies the deletion policy to the node
+func (c *Controller) finalize(ctx context.Context, provisioner *v1alpha5.Provisioner, policy v1alpha5.DeletionPolicy, node *v1.Node) error {
+	switch policy {
+	case v1alpha5.DeletionPolicyOrphan:
+		// Orphaned nodes are no longer labelled with the provisioner's name, so
+		// that they aren't adopted if a provisioner of the same name is
+		// created, and no longer have the termination finalizer, so that
+		// they're deleted without draining them or terminating their instances
+		persisted := node.DeepCopy()
+		delete(node.Labels, v1alpha5.ProvisionerNameLabelKey)
+		node.Finalizers = functional.StringSliceWithout(node.Finalizers, v1alpha5.TerminationFinalizer)
+		node.OwnerReferences = nil
+		for _, owner := range persisted.OwnerReferences {
+			if owner.UID != provisioner.UID {
+				node.OwnerReferences = append(node.OwnerReferences, owner)
+			}
+		}
+		if err := c.kubeClient.Patch(ctx, node, client.MergeFrom(persisted)); err != nil {
+			return client.IgnoreNotFound(err)
+		}
+		logging.FromContext(ctx).Infof("Orphaned node %s", node.Name)
+	case v1alpha5.DeletionPolicyTerminate:
+		audit.Deleted(ctx, node, fmt.Sprintf("provisioner %s deleted", provisioner.Name), nil)
+		if err := c.cloudProvider.Delete(ctx, node); err != nil {
+			return fmt.Errorf("terminating cloudprovider instance, %w", err)
+		}
+		audit.Terminated(ctx, node)
+		persisted := node.DeepCopy()
+		node.Finalizers = functional.StringSliceWithout(node.Finalizers, v1alpha5.TerminationFinalizer)
+		if err := c.kubeClient.Patch(ctx, node, client.MergeFrom(persisted)); err != nil {
+			return client.IgnoreNotFound(err)
+		}
+		if err := c.kubeClient.Delete(ctx, node); err != nil {
+			return client.IgnoreNotFound(err)
+		}
+		logging.FromContext(ctx).Infof("Terminated node %s without draining it", node.Name)
+	default:
+		if !node.DeletionTimestamp.IsZero() {
+			return nil
+		}
+		if err := c.kubeClient.Delete(ctx, node); err != nil {
+			return client.IgnoreNotFound(err)
+		}
+		audit.Deleted(ctx, node, fmt.Sprintf("provisioner %s deleted", provisioner.Name), nil)
+		logging.FromContext(ctx).Infof("Triggered termination of node %s", node.Name)
+	}
+	return nil
+}
+
+// Register the controller to the manager
+func (c *Controller) Register(_ context.Context, m manager.Manager) error {
+	return controllerruntime.
+		NewControllerManagedBy(m).
+		Named(controllerName).
+		For(&v1alpha5.Provisioner{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: 10}).
+		Complete(c)
+}
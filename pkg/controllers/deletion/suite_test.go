@@ -0,0 +1,148 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deletion_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/karpenter/pkg/apis"
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider/fake"
+	"github.com/aws/karpenter/pkg/controllers/deletion"
+	"github.com/aws/karpenter/pkg/test"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	. "knative.dev/pkg/logging/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var ctx context.Context
+
+func TestAPIs(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Deletion")
+}
+
+var _ = Describe("Deletion", func() {
+	var provisioner *v1alpha5.Provisioner
+	var node *v1.Node
+	var kubeClient client.Client
+	var cloudProvider *fake.CloudProvider
+	var controller *deletion.Controller
+	BeforeEach(func() {
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(apis.AddToScheme(scheme)).To(Succeed())
+		kubeClient = fakeclient.NewClientBuilder().WithScheme(scheme).Build()
+		cloudProvider = &fake.CloudProvider{}
+		controller = deletion.NewController(kubeClient, cloudProvider)
+		provisioner = &v1alpha5.Provisioner{ObjectMeta: metav1.ObjectMeta{Name: v1alpha5.DefaultProvisioner.Name, UID: "test-uid"}}
+		Expect(kubeClient.Create(ctx, provisioner)).To(Succeed())
+		node = test.Node(test.NodeOptions{
+			Finalizers: []string{v1alpha5.TerminationFinalizer},
+			Labels:     map[string]string{v1alpha5.ProvisionerNameLabelKey: provisioner.Name},
+		})
+		node.OwnerReferences = []metav1.OwnerReference{provisioner.OwnerReference()}
+		Expect(kubeClient.Create(ctx, node)).To(Succeed())
+	})
+
+	ExpectReconciled := func() reconcile.Result {
+		result, err := controller.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(provisioner)})
+		Expect(err).ToNot(HaveOccurred())
+		return result
+	}
+	ExpectDeleted := func(policy v1alpha5.DeletionPolicy, finalizers ...string) {
+		Expect(kubeClient.Get(ctx, client.ObjectKeyFromObject(provisioner), provisioner)).To(Succeed())
+		provisioner.Spec.DeletionPolicy = policy
+		provisioner.Finalizers = append(provisioner.Finalizers, finalizers...)
+		Expect(kubeClient.Update(ctx, provisioner)).To(Succeed())
+		Expect(kubeClient.Delete(ctx, provisioner)).To(Succeed())
+	}
+	ExpectProvisionerFinalized := func() {
+		Expect(kubeClient.Get(ctx, client.ObjectKeyFromObject(provisioner), provisioner)).To(Succeed())
+		Expect(provisioner.Finalizers).ToNot(ContainElement(v1alpha5.TerminationFinalizer))
+	}
+	ExpectNodeGone := func() {
+		err := kubeClient.Get(ctx, client.ObjectKeyFromObject(node), &v1.Node{})
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+	}
+	GetNode := func() *v1.Node {
+		persisted := &v1.Node{}
+		Expect(kubeClient.Get(ctx, client.ObjectKeyFromObject(node), persisted)).To(Succeed())
+		return persisted
+	}
+
+	It("should add the finalizer to provisioners", func() {
+		ExpectReconciled()
+		Expect(kubeClient.Get(ctx, client.ObjectKeyFromObject(provisioner), provisioner)).To(Succeed())
+		Expect(provisioner.Finalizers).To(ContainElement(v1alpha5.TerminationFinalizer))
+	})
+	It("should drain the nodes of a deleted provisioner by default", func() {
+		ExpectReconciled()
+		// Keep the provisioner around to check that its finalizer was removed
+		ExpectDeleted("", "test.com/finalizer")
+		Expect(ExpectReconciled().RequeueAfter).To(Equal(deletion.RequeueInterval))
+		Expect(GetNode().DeletionTimestamp.IsZero()).To(BeFalse())
+		Expect(cloudProvider.CallsTo("Delete")).To(BeEmpty())
+
+		// The termination controller drains the node and removes its finalizer
+		persisted := GetNode()
+		persisted.Finalizers = nil
+		Expect(kubeClient.Update(ctx, persisted)).To(Succeed())
+		ExpectNodeGone()
+		Expect(ExpectReconciled().RequeueAfter).To(BeZero())
+		ExpectProvisionerFinalized()
+	})
+	It("should orphan the nodes of a deleted provisioner", func() {
+		ExpectReconciled()
+		ExpectDeleted(v1alpha5.DeletionPolicyOrphan, "test.com/finalizer")
+		ExpectReconciled()
+		persisted := GetNode()
+		Expect(persisted.DeletionTimestamp.IsZero()).To(BeTrue())
+		Expect(persisted.OwnerReferences).To(BeEmpty())
+		Expect(persisted.Labels).ToNot(HaveKey(v1alpha5.ProvisionerNameLabelKey))
+		Expect(persisted.Finalizers).ToNot(ContainElement(v1alpha5.TerminationFinalizer))
+		ExpectProvisionerFinalized()
+	})
+	It("should orphan the nodes of a provisioner deleted with --cascade=orphan", func() {
+		ExpectReconciled()
+		ExpectDeleted(v1alpha5.DeletionPolicyTerminate, metav1.FinalizerOrphanDependents)
+		ExpectReconciled()
+		persisted := GetNode()
+		Expect(persisted.DeletionTimestamp.IsZero()).To(BeTrue())
+		Expect(persisted.OwnerReferences).To(BeEmpty())
+		Expect(persisted.Labels).ToNot(HaveKey(v1alpha5.ProvisionerNameLabelKey))
+		Expect(cloudProvider.CallsTo("Delete")).To(BeEmpty())
+		ExpectProvisionerFinalized()
+	})
+	It("should terminate the nodes of a deleted provisioner without draining them", func() {
+		ExpectReconciled()
+		ExpectDeleted(v1alpha5.DeletionPolicyTerminate, "test.com/finalizer")
+		ExpectReconciled()
+		ExpectNodeGone()
+		Expect(cloudProvider.CallsTo("Delete")).To(HaveLen(1))
+		ExpectProvisionerFinalized()
+	})
+})
@@ -0,0 +1,186 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package disruption
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"knative.dev/pkg/logging"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/audit"
+	"github.com/aws/karpenter/pkg/utils/functional"
+	"github.com/aws/karpenter/pkg/utils/injectabletime"
+	"github.com/aws/karpenter/pkg/utils/injection"
+	"github.com/aws/karpenter/pkg/utils/ptr"
+)
+
+// RetryInterval is how long deprovisioners wait before retrying to disrupt a
+// node that the budgets blocked
+const RetryInterval = time.Minute
+
+// Gatekeeper enforces the disruption budgets of the cluster and of
+// provisioners. Voluntary deprovisioners, i.e. expiration, emptiness, drift and
+// consolidation, terminate nodes through it, so that together they never have
+// more nodes terminating than the budgets allow. Nodes that are terminating
+// for any reason count against the budgets.
+type Gatekeeper struct {
+	kubeClient client.Client
+	mu         sync.Mutex
+	// disrupted are the nodes that were deleted but may not be terminating in
+	// the client's cache yet, so that they're counted by the next decision
+	disrupted sets.String
+}
+
+// NewGatekeeper is a constructor
+func NewGatekeeper(kubeClient client.Client) *Gatekeeper {
+	return &Gatekeeper{
+		kubeClient: kubeClient,
+		disrupted:  sets.NewString(),
+	}
+}
+
+// Disrupt deletes the node for the reason, which is audited, and the node is
+// cordoned and drained by the termination controller, if the budgets of the
+// cluster and of the node's provisioner allow another of their nodes to
+// terminate. Otherwise, it returns false without deleting the node, and the
+// caller should retry later.
+func (g *Gatekeeper) Disrupt(ctx context.Context, provisioner *v1alpha5.Provisioner, node *v1.Node, reason string) (bool, error) {
+	return g.disrupt(ctx, provisioner, node, reason, false)
+}
+
+// DisruptEmpty disrupts the empty node like Disrupt, but annotates it as
+// reusable before it's deleted, so that the cloud provider may keep its
+// instance for pending pods rather than terminating it. Nodes that are deleted
+// for any other reason are terminated.
+func (g *Gatekeeper) DisruptEmpty(ctx context.Context, provisioner *v1alpha5.Provisioner, node *v1.Node, reason string) (bool, error) {
+	return g.disrupt(ctx, provisioner, node, reason, true)
+}
+
+func (g *Gatekeeper) disrupt(ctx context.Context, provisioner *v1alpha5.Provisioner, node *v1.Node, reason string, reusable bool) (bool, error) {
+	pods, deleted, err := g.delete(ctx, provisioner, node, reusable)
+	if err != nil || !deleted {
+		return false, err
+	}
+	audit.Deleted(ctx, node, reason, pods)
+	return true, nil
+}
+
+// delete deletes the node if the budgets allow it, returning the pods that
+// were running on it. The pods are only listed if deletions are audited.
+func (g *Gatekeeper) delete(ctx context.Context, provisioner *v1alpha5.Provisioner, node *v1.Node, reusable bool) ([]*v1.Pod, bool, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if allowed, err := g.allowed(ctx, provisioner, node); err != nil || !allowed {
+		return nil, false, err
+	}
+	var pods []*v1.Pod
+	if audit.GetSink(ctx) != nil {
+		podList := &v1.PodList{}
+		if err := g.kubeClient.List(ctx, podList, client.MatchingFields{"spec.nodeName": node.Name}); err != nil {
+			return nil, false, fmt.Errorf("listing pods on node, %w", err)
+		}
+		pods = ptr.PodListToSlice(podList)
+	}
+	if reusable {
+		stored := node.DeepCopy()
+		node.Annotations = functional.UnionStringMaps(node.Annotations, map[string]string{v1alpha5.ReusableAnnotationKey: "true"})
+		if err := g.kubeClient.Patch(ctx, node, client.MergeFrom(stored)); err != nil {
+			return nil, false, fmt.Errorf("patching node, %w", err)
+		}
+	}
+	if err := g.kubeClient.Delete(ctx, node); err != nil {
+		return nil, false, fmt.Errorf("deleting node, %w", err)
+	}
+	g.disrupted.Insert(node.Name)
+	return pods, true, nil
+}
+
+// Replace annotates the node to be replaced for the reason, if the budgets
+// allow it like Disrupt. The replacement controller launches capacity for the
+// node's pods, and deletes the node once the capacity is ready, so that its
+// pods don't wait for new nodes once it's drained. The deletion is audited by
+// the replacement controller.
+func (g *Gatekeeper) Replace(ctx context.Context, provisioner *v1alpha5.Provisioner, node *v1.Node, reason string) (bool, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if allowed, err := g.allowed(ctx, provisioner, node); err != nil || !allowed {
+		return false, err
+	}
+	stored := node.DeepCopy()
+	node.Annotations = functional.UnionStringMaps(node.Annotations, map[string]string{v1alpha5.ReplaceAnnotationKey: reason})
+	if err := g.kubeClient.Patch(ctx, node, client.MergeFrom(stored)); err != nil {
+		return false, fmt.Errorf("patching node, %w", err)
+	}
+	g.disrupted.Insert(node.Name)
+	return true, nil
+}
+
+// allowed returns true if the budgets of the cluster and of the provisioner
+// allow another of their nodes to terminate. Nodes that are being replaced are
+// terminating as far as the budgets are concerned.
+func (g *Gatekeeper) allowed(ctx context.Context, provisioner *v1alpha5.Provisioner, node *v1.Node) (bool, error) {
+	nodes := &v1.NodeList{}
+	if err := g.kubeClient.List(ctx, nodes, client.HasLabels{v1alpha5.ProvisionerNameLabelKey}); err != nil {
+		return false, fmt.Errorf("listing nodes, %w", err)
+	}
+	provisionerNodes, provisionerTerminating := 0, 0
+	clusterTerminating := 0
+	current := sets.NewString()
+	for i := range nodes.Items {
+		n := &nodes.Items[i]
+		current.Insert(n.Name)
+		terminating := !n.DeletionTimestamp.IsZero()
+		if terminating {
+			g.disrupted.Delete(n.Name)
+		}
+		_, replacing := n.Annotations[v1alpha5.ReplaceAnnotationKey]
+		terminating = terminating || replacing || g.disrupted.Has(n.Name)
+		if terminating {
+			clusterTerminating++
+		}
+		if n.Labels[v1alpha5.ProvisionerNameLabelKey] == provisioner.Name {
+			provisionerNodes++
+			if terminating {
+				provisionerTerminating++
+			}
+		}
+	}
+	// Forget nodes that are gone
+	g.disrupted = g.disrupted.Intersection(current)
+
+	if allowed := provisioner.Spec.Disruption.Allowed(injectabletime.Now(), provisionerNodes); provisionerTerminating >= allowed {
+		logging.FromContext(ctx).Debugf("Disruption of node %s blocked by the provisioner's budget, %d of %d node(s) are terminating", node.Name, provisionerTerminating, allowed)
+		return false, nil
+	}
+	if budget := injection.GetSettings(ctx).DisruptionBudget; budget != nil {
+		allowed, err := intstr.GetScaledValueFromIntOrPercent(budget, len(nodes.Items), true)
+		if err != nil {
+			return false, fmt.Errorf("scaling disruption budget, %w", err)
+		}
+		if clusterTerminating >= allowed {
+			logging.FromContext(ctx).Debugf("Disruption of node %s blocked by the cluster's budget, %d of %d node(s) are terminating", node.Name, clusterTerminating, allowed)
+			return false, nil
+		}
+	}
+	return true, nil
+}
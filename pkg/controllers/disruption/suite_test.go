@@ -0,0 +1,198 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package disruption_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/karpenter/pkg/apis"
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/audit"
+	"github.com/aws/karpenter/pkg/controllers/disruption"
+	"github.com/aws/karpenter/pkg/test"
+	"github.com/aws/karpenter/pkg/utils/injectabletime"
+	"github.com/aws/karpenter/pkg/utils/injection"
+	"github.com/aws/karpenter/pkg/utils/settings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	. "knative.dev/pkg/logging/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var ctx context.Context
+
+func TestAPIs(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Disruption")
+}
+
+var _ = Describe("Gatekeeper", func() {
+	var provisioner *v1alpha5.Provisioner
+	var kubeClient client.Client
+	var gatekeeper *disruption.Gatekeeper
+	BeforeEach(func() {
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(apis.AddToScheme(scheme)).To(Succeed())
+		kubeClient = fake.NewClientBuilder().WithScheme(scheme).Build()
+		gatekeeper = disruption.NewGatekeeper(kubeClient)
+		provisioner = &v1alpha5.Provisioner{ObjectMeta: metav1.ObjectMeta{Name: v1alpha5.DefaultProvisioner.Name}}
+	})
+	AfterEach(func() {
+		injectabletime.Now = time.Now
+	})
+
+	nodes := func(provisionerName string, count int) (nodes []*v1.Node) {
+		for i := 0; i < count; i++ {
+			node := test.Node(test.NodeOptions{
+				Finalizers: []string{v1alpha5.TerminationFinalizer},
+				Labels:     map[string]string{v1alpha5.ProvisionerNameLabelKey: provisionerName},
+			})
+			Expect(kubeClient.Create(ctx, node)).To(Succeed())
+			nodes = append(nodes, node)
+		}
+		return nodes
+	}
+	ExpectDisrupted := func(ctx context.Context, node *v1.Node, expected bool) {
+		disrupted, err := gatekeeper.Disrupt(ctx, provisioner, node, "test")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(disrupted).To(Equal(expected))
+		persisted := &v1.Node{}
+		Expect(kubeClient.Get(ctx, client.ObjectKeyFromObject(node), persisted)).To(Succeed())
+		Expect(persisted.DeletionTimestamp.IsZero()).To(Equal(!expected))
+	}
+
+	It("should audit disrupted nodes with their reason and pods", func() {
+		n := nodes(provisioner.Name, 2)
+		pod := test.Pod(test.PodOptions{NodeName: n[0].Name})
+		Expect(kubeClient.Create(ctx, pod)).To(Succeed())
+		sink := &fakeSink{}
+		disrupted, err := gatekeeper.Disrupt(audit.WithSink(ctx, sink), provisioner, n[0], "expired after 1h0m0s")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(disrupted).To(BeTrue())
+		Expect(sink.records).To(HaveLen(1))
+		Expect(sink.records[0].Action).To(Equal(audit.ActionDelete))
+		Expect(sink.records[0].Node).To(Equal(n[0].Name))
+		Expect(sink.records[0].Reason).To(Equal("expired after 1h0m0s"))
+		Expect(sink.records[0].Pods).To(ConsistOf(client.ObjectKeyFromObject(pod).String()))
+	})
+	It("should only annotate nodes that are disrupted for being empty as reusable", func() {
+		n := nodes(provisioner.Name, 2)
+		ExpectDisrupted(ctx, n[0], true)
+		disrupted, err := gatekeeper.DisruptEmpty(ctx, provisioner, n[1], "empty for 30s")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(disrupted).To(BeTrue())
+		for i, reusable := range []bool{false, true} {
+			persisted := &v1.Node{}
+			Expect(kubeClient.Get(ctx, client.ObjectKeyFromObject(n[i]), persisted)).To(Succeed())
+			Expect(persisted.DeletionTimestamp.IsZero()).To(BeFalse())
+			if reusable {
+				Expect(persisted.Annotations).To(HaveKeyWithValue(v1alpha5.ReusableAnnotationKey, "true"))
+			} else {
+				Expect(persisted.Annotations).ToNot(HaveKey(v1alpha5.ReusableAnnotationKey))
+			}
+		}
+	})
+	It("should disrupt nodes without budgets", func() {
+		for _, node := range nodes(provisioner.Name, 3) {
+			ExpectDisrupted(ctx, node, true)
+		}
+	})
+	It("should block disruption while the budget's nodes are terminating", func() {
+		provisioner.Spec.Disruption.Budgets = []v1alpha5.Budget{{Nodes: intstr.FromInt(1)}}
+		n := nodes(provisioner.Name, 2)
+		ExpectDisrupted(ctx, n[0], true)
+		ExpectDisrupted(ctx, n[1], false)
+	})
+	It("should count nodes that are terminating for other reasons", func() {
+		provisioner.Spec.Disruption.Budgets = []v1alpha5.Budget{{Nodes: intstr.FromInt(1)}}
+		n := nodes(provisioner.Name, 2)
+		Expect(kubeClient.Delete(ctx, n[0])).To(Succeed())
+		ExpectDisrupted(ctx, n[1], false)
+	})
+	It("should annotate replaced nodes and count them against the budget", func() {
+		provisioner.Spec.Disruption.Budgets = []v1alpha5.Budget{{Nodes: intstr.FromInt(1)}}
+		n := nodes(provisioner.Name, 2)
+		replaced, err := gatekeeper.Replace(ctx, provisioner, n[0], "consolidated")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(replaced).To(BeTrue())
+		persisted := &v1.Node{}
+		Expect(kubeClient.Get(ctx, client.ObjectKeyFromObject(n[0]), persisted)).To(Succeed())
+		Expect(persisted.Annotations).To(HaveKeyWithValue(v1alpha5.ReplaceAnnotationKey, "consolidated"))
+		Expect(persisted.DeletionTimestamp.IsZero()).To(BeTrue())
+		ExpectDisrupted(ctx, n[1], false)
+		// The annotation is counted after the gatekeeper forgets the node, e.g. after a restart
+		Expect(disruption.NewGatekeeper(kubeClient).Disrupt(ctx, provisioner, n[1], "test")).To(BeFalse())
+	})
+	It("should round percentages up", func() {
+		provisioner.Spec.Disruption.Budgets = []v1alpha5.Budget{{Nodes: intstr.FromString("10%")}}
+		n := nodes(provisioner.Name, 3)
+		ExpectDisrupted(ctx, n[0], true)
+		ExpectDisrupted(ctx, n[1], false)
+	})
+	It("should block all disruption with a budget of zero", func() {
+		provisioner.Spec.Disruption.Budgets = []v1alpha5.Budget{{Nodes: intstr.FromInt(0)}}
+		ExpectDisrupted(ctx, nodes(provisioner.Name, 1)[0], false)
+	})
+	It("should apply the most restrictive budget", func() {
+		provisioner.Spec.Disruption.Budgets = []v1alpha5.Budget{{Nodes: intstr.FromInt(2)}, {Nodes: intstr.FromString("50%")}}
+		n := nodes(provisioner.Name, 2)
+		ExpectDisrupted(ctx, n[0], true)
+		ExpectDisrupted(ctx, n[1], false)
+	})
+	It("should only apply scheduled budgets during their windows", func() {
+		provisioner.Spec.Disruption.Budgets = []v1alpha5.Budget{{
+			Nodes:    intstr.FromInt(0),
+			Schedule: &v1alpha5.Schedule{Days: []string{"Saturday"}, Start: "22:00", Duration: metav1.Duration{Duration: 4 * time.Hour}},
+		}}
+		n := nodes(provisioner.Name, 2)
+		// Sunday, within the window that started on Saturday
+		injectabletime.Now = func() time.Time { return time.Date(2022, time.January, 2, 1, 0, 0, 0, time.UTC) }
+		ExpectDisrupted(ctx, n[0], false)
+		// Sunday, after the window ended
+		injectabletime.Now = func() time.Time { return time.Date(2022, time.January, 2, 3, 0, 0, 0, time.UTC) }
+		ExpectDisrupted(ctx, n[0], true)
+	})
+	It("should not count the nodes of other provisioners against the provisioner's budget", func() {
+		provisioner.Spec.Disruption.Budgets = []v1alpha5.Budget{{Nodes: intstr.FromInt(1)}}
+		Expect(kubeClient.Delete(ctx, nodes("other", 1)[0])).To(Succeed())
+		ExpectDisrupted(ctx, nodes(provisioner.Name, 1)[0], true)
+	})
+	It("should count the nodes of every provisioner against the cluster's budget", func() {
+		budget := intstr.FromInt(1)
+		ctx := injection.WithSettings(ctx, settings.NewStore(settings.Settings{DisruptionBudget: &budget}))
+		Expect(kubeClient.Delete(ctx, nodes("other", 1)[0])).To(Succeed())
+		ExpectDisrupted(ctx, nodes(provisioner.Name, 1)[0], false)
+	})
+})
+
+type fakeSink struct {
+	records []*audit.Record
+}
+
+func (f *fakeSink) Write(_ context.Context, record *audit.Record) error {
+	f.records = append(f.records, record)
+	return nil
+}
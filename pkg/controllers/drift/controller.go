@@ -0,0 +1,193 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drift
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"knative.dev/pkg/logging"
+	controllerruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/controllers/disruption"
+	"github.com/aws/karpenter/pkg/events"
+	"github.com/aws/karpenter/pkg/utils/featuregates"
+	"github.com/aws/karpenter/pkg/utils/injection"
+	karpenterlogging "github.com/aws/karpenter/pkg/utils/logging"
+)
+
+const (
+	controllerName = "drift"
+	// RequeueInterval is how often the nodes of each provisioner are checked
+	// for drift
+	RequeueInterval = 5 * time.Minute
+	// SpecChangedReason is the drift reason of nodes that were launched from a
+	// different provisioner spec than the current one
+	SpecChangedReason = "provisioner spec changed"
+)
+
+// Controller replaces the nodes of provisioners that no longer match what the
+// provisioner would launch. A node has drifted if the hash of the provisioner's
+// spec changed since it was launched, or if the cloud provider reports that its
+// instance differs, e.g. in its image or launch template. Drifted nodes are
+// annotated with the reason, and the oldest are terminated through the
+// termination controller while fewer than the provisioner's drift budget of
+// nodes are terminating, and the disruption budgets allow it. Their pods are rescheduled, or provisioned on new
+// nodes that match the provisioner.
+type Controller struct {
+	kubeClient    client.Client
+	cloudProvider cloudprovider.CloudProvider
+	gatekeeper    *disruption.Gatekeeper
+	recorder      events.Recorder
+}
+
+// NewController is a constructor
+func NewController(kubeClient client.Client, cloudProvider cloudprovider.CloudProvider, gatekeeper *disruption.Gatekeeper, recorder events.Recorder) *Controller {
+	return &Controller{
+		kubeClient:    kubeClient,
+		cloudProvider: cloudProvider,
+		gatekeeper:    gatekeeper,
+		recorder:      recorder,
+	}
+}
+
+// Reconcile marks the provisioner's drifted nodes and replaces them within
+// its budget
+func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	ctx = karpenterlogging.WithReconcile(ctx, controllerName, "provisioner", req.Name)
+	provisioner := &v1alpha5.Provisioner{}
+	if err := c.kubeClient.Get(ctx, req.NamespacedName, provisioner); err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+	// The feature gate may be enabled by the global settings without changing
+	// the provisioner, so provisioners are requeued while it's disabled
+	if !injection.IsFeatureEnabled(ctx, featuregates.Drift) {
+		return reconcile.Result{RequeueAfter: RequeueInterval}, nil
+	}
+	nodes := &v1.NodeList{}
+	if err := c.kubeClient.List(ctx, nodes, client.MatchingLabels{v1alpha5.ProvisionerNameLabelKey: provisioner.Name}); err != nil {
+		return reconcile.Result{}, fmt.Errorf("listing nodes, %w", err)
+	}
+	drifted, terminating, err := c.mark(ctx, provisioner, nodes.Items)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if err := c.replace(ctx, provisioner, drifted, terminating); err != nil {
+		return reconcile.Result{}, err
+	}
+	return reconcile.Result{RequeueAfter: RequeueInterval}, nil
+}
+
+// mark annotates the nodes that drifted with the reason, and removes the
+// annotation from nodes that no longer drift, e.g. because the provisioner's
+// spec was reverted. It returns the drifted nodes that aren't terminating, and
+// the number of nodes that are.
+func (c *Controller) mark(ctx context.Context, provisioner *v1alpha5.Provisioner, nodes []v1.Node) ([]*v1.Node, int32, error) {
+	var drifted []*v1.Node
+	terminating := int32(0)
+	for i := range nodes {
+		node := &nodes[i]
+		if !node.DeletionTimestamp.IsZero() {
+			terminating++
+			continue
+		}
+		reason, err := c.reason(ctx, provisioner, node)
+		if err != nil {
+			return nil, 0, fmt.Errorf("checking drift of node %s, %w", node.Name, err)
+		}
+		if reason != node.Annotations[v1alpha5.DriftedAnnotationKey] {
+			persisted := node.DeepCopy()
+			if reason == "" {
+				delete(node.Annotations, v1alpha5.DriftedAnnotationKey)
+			} else {
+				if node.Annotations == nil {
+					node.Annotations = map[string]string{}
+				}
+				node.Annotations[v1alpha5.DriftedAnnotationKey] = reason
+				logging.FromContext(ctx).Infof("Node %s drifted, %s", node.Name, reason)
+			}
+			if err := c.kubeClient.Patch(ctx, node, client.MergeFrom(persisted)); err != nil {
+				return nil, 0, fmt.Errorf("patching node %s, %w", node.Name, err)
+			}
+			if reason != "" {
+				c.recorder.NodeDrifted(node, reason)
+			}
+		}
+		if reason != "" {
+			drifted = append(drifted, node)
+		}
+	}
+	return drifted, terminating, nil
+}
+
+// reason returns why the node drifted, or an empty string if it hasn't
+func (c *Controller) reason(ctx context.Context, provisioner *v1alpha5.Provisioner, node *v1.Node) (string, error) {
+	changed, err := provisioner.Spec.HashChanged(node)
+	if err != nil {
+		return "", err
+	}
+	if changed {
+		return SpecChangedReason, nil
+	}
+	return c.cloudProvider.IsDrifted(ctx, node, provisioner)
+}
+
+// replace terminates the oldest drifted nodes while fewer than the drift
+// budget of the provisioner's nodes are terminating, and the disruption budgets
+// allow it
+func (c *Controller) replace(ctx context.Context, provisioner *v1alpha5.Provisioner, drifted []*v1.Node, terminating int32) error {
+	sort.Slice(drifted, func(i, j int) bool {
+		return drifted[i].CreationTimestamp.Before(&drifted[j].CreationTimestamp)
+	})
+	for i, node := range drifted {
+		if terminating >= provisioner.Spec.Drift.GetMaxUnavailable() {
+			logging.FromContext(ctx).Debugf("Waiting to replace %d drifted node(s), %d node(s) are terminating", len(drifted)-i, terminating)
+			return nil
+		}
+		disrupted, err := c.gatekeeper.Disrupt(ctx, provisioner, node, fmt.Sprintf("drifted, %s", node.Annotations[v1alpha5.DriftedAnnotationKey]))
+		if err != nil {
+			return fmt.Errorf("disrupting node %s, %w", node.Name, err)
+		}
+		if !disrupted {
+			return nil
+		}
+		logging.FromContext(ctx).Infof("Replacing drifted node %s, %s", node.Name, node.Annotations[v1alpha5.DriftedAnnotationKey])
+		terminating++
+	}
+	return nil
+}
+
+// Register the controller to the manager
+func (c *Controller) Register(_ context.Context, m manager.Manager) error {
+	return controllerruntime.
+		NewControllerManagedBy(m).
+		Named(controllerName).
+		For(&v1alpha5.Provisioner{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: 10}).
+		Complete(c)
+}
@@ -0,0 +1,171 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package drift_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider/fake"
+	"github.com/aws/karpenter/pkg/controllers/disruption"
+	"github.com/aws/karpenter/pkg/controllers/drift"
+	"github.com/aws/karpenter/pkg/events"
+	"github.com/aws/karpenter/pkg/test"
+	"github.com/aws/karpenter/pkg/utils/injection"
+	"github.com/aws/karpenter/pkg/utils/options"
+
+	. "github.com/aws/karpenter/pkg/test/expectations"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	. "knative.dev/pkg/logging/testing"
+	"knative.dev/pkg/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var ctx context.Context
+var cloudProvider *fake.CloudProvider
+var controller *drift.Controller
+var recorder *record.FakeRecorder
+var env *test.Environment
+
+func TestAPIs(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Drift")
+}
+
+var _ = BeforeSuite(func() {
+	env = test.NewEnvironment(ctx, func(e *test.Environment) {
+		cloudProvider = &fake.CloudProvider{}
+		recorder = record.NewFakeRecorder(100)
+		controller = drift.NewController(e.Client, cloudProvider, disruption.NewGatekeeper(e.Client), events.NewRecorder(recorder))
+	})
+	Expect(env.Start()).To(Succeed(), "Failed to start environment")
+	ctx = injection.WithOptions(ctx, options.Options{FeatureGates: "Drift=true"})
+})
+
+var _ = AfterSuite(func() {
+	Expect(env.Stop()).To(Succeed(), "Failed to stop environment")
+})
+
+var _ = Describe("Drift", func() {
+	var provisioner *v1alpha5.Provisioner
+	BeforeEach(func() {
+		provisioner = &v1alpha5.Provisioner{ObjectMeta: metav1.ObjectMeta{Name: v1alpha5.DefaultProvisioner.Name}}
+		cloudProvider.Reset()
+		for len(recorder.Events) > 0 {
+			<-recorder.Events
+		}
+	})
+
+	AfterEach(func() {
+		ExpectCleanedUp(ctx, env.Client)
+	})
+
+	node := func(hash string) *v1.Node {
+		return test.Node(test.NodeOptions{
+			Annotations: map[string]string{v1alpha5.ProvisionerHashAnnotationKey: hash},
+			Finalizers:  []string{v1alpha5.TerminationFinalizer},
+			Labels:      map[string]string{v1alpha5.ProvisionerNameLabelKey: provisioner.Name},
+		})
+	}
+	current := func() string {
+		hash, err := provisioner.Spec.Hash()
+		Expect(err).ToNot(HaveOccurred())
+		return hash
+	}
+	ExpectDrifted := func(node *v1.Node, reason string, terminating bool) {
+		persisted := ExpectNodeExists(ctx, env.Client, node.Name)
+		Expect(persisted.Annotations[v1alpha5.DriftedAnnotationKey]).To(Equal(reason))
+		Expect(persisted.DeletionTimestamp.IsZero()).To(Equal(!terminating))
+	}
+
+	It("should replace nodes launched from a different provisioner spec", func() {
+		stale := node("stale")
+		ExpectApplied(ctx, env.Client, provisioner)
+		ExpectCreated(ctx, env.Client, stale)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+		ExpectDrifted(stale, drift.SpecChangedReason, true)
+	})
+	It("should replace nodes that the cloud provider reports as drifted", func() {
+		drifted := node(current())
+		cloudProvider.DriftReasons = map[string]string{drifted.Name: "image changed"}
+		ExpectApplied(ctx, env.Client, provisioner)
+		ExpectCreated(ctx, env.Client, drifted)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+		ExpectDrifted(drifted, "image changed", true)
+		Expect(recorder.Events).To(Receive(ContainSubstring("Node drifted from its provisioner, image changed")))
+	})
+	It("should not replace nodes that haven't drifted", func() {
+		n := node(current())
+		ExpectApplied(ctx, env.Client, provisioner)
+		ExpectCreated(ctx, env.Client, n)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+		ExpectDrifted(n, "", false)
+	})
+	It("should unmark nodes that no longer drift", func() {
+		n := node(current())
+		n.Annotations[v1alpha5.DriftedAnnotationKey] = drift.SpecChangedReason
+		ExpectApplied(ctx, env.Client, provisioner)
+		ExpectCreated(ctx, env.Client, n)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+		ExpectDrifted(n, "", false)
+	})
+	It("should replace one node at a time by default", func() {
+		first, second := node("stale"), node("stale")
+		ExpectApplied(ctx, env.Client, provisioner)
+		ExpectCreated(ctx, env.Client, first, second)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+		Expect(ExpectNodeExists(ctx, env.Client, first.Name).DeletionTimestamp.IsZero()).ToNot(
+			Equal(ExpectNodeExists(ctx, env.Client, second.Name).DeletionTimestamp.IsZero()))
+	})
+	It("should replace as many nodes at a time as the budget allows", func() {
+		provisioner.Spec.Drift.MaxUnavailable = ptr.Int32(2)
+		first, second := node("stale"), node("stale")
+		ExpectApplied(ctx, env.Client, provisioner)
+		ExpectCreated(ctx, env.Client, first, second)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+		ExpectDrifted(first, drift.SpecChangedReason, true)
+		ExpectDrifted(second, drift.SpecChangedReason, true)
+	})
+	It("should count nodes that are terminating for other reasons against the budget", func() {
+		terminating, stale := node(current()), node("stale")
+		ExpectApplied(ctx, env.Client, provisioner)
+		ExpectCreated(ctx, env.Client, terminating, stale)
+		Expect(env.Client.Delete(ctx, terminating)).To(Succeed())
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+		ExpectDrifted(stale, drift.SpecChangedReason, false)
+	})
+	It("should ignore nodes of other provisioners", func() {
+		other := node("stale")
+		other.Labels[v1alpha5.ProvisionerNameLabelKey] = "other"
+		ExpectApplied(ctx, env.Client, provisioner)
+		ExpectCreated(ctx, env.Client, other)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+		ExpectDrifted(other, "", false)
+	})
+	It("should do nothing if the feature gate is disabled", func() {
+		stale := node("stale")
+		ExpectApplied(ctx, env.Client, provisioner)
+		ExpectCreated(ctx, env.Client, stale)
+		ExpectReconcileSucceeded(injection.WithOptions(ctx, options.Options{}), controller, client.ObjectKeyFromObject(provisioner))
+		ExpectDrifted(stale, "", false)
+	})
+})
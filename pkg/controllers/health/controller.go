@@ -0,0 +1,275 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package health
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/events"
+	"github.com/aws/karpenter/pkg/utils/injectabletime"
+	"github.com/aws/karpenter/pkg/utils/injection"
+	karpenterlogging "github.com/aws/karpenter/pkg/utils/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	knativeapis "knative.dev/pkg/apis"
+	"knative.dev/pkg/logging"
+	controllerruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	controllerName = "health"
+	// ThresholdsBreachedReason is reported on the Degraded and Ready conditions
+	ThresholdsBreachedReason = "ThresholdsBreached"
+	// SampleInterval is how often the controllers' reconcile metrics are
+	// sampled and the KarpenterStatus is refreshed
+	SampleInterval = 30 * time.Second
+	// minReconciles is the number of reconciles in the window below which a
+	// controller's error rate is ignored, so that a few failures of a quiet
+	// controller don't degrade Karpenter
+	minReconciles = 10
+	// slowFraction is the fraction of reconciles that may be slow, i.e. the
+	// latency threshold applies to the 99th percentile
+	slowFraction = 0.01
+
+	// Reconcile metrics that controller-runtime records for every controller
+	reconcileTotalMetric = "controller_runtime_reconcile_total"
+	reconcileTimeMetric  = "controller_runtime_reconcile_time_seconds"
+)
+
+// Controller reports Karpenter's health on the KarpenterStatus singleton. It
+// samples the reconcile metrics that controller-runtime records for every
+// controller, and marks Karpenter Degraded while any controller's error rate
+// or reconcile latency over the health window breaches its threshold.
+type Controller struct {
+	kubeClient client.Client
+	gatherer   prometheus.Gatherer
+	recorder   events.Recorder
+
+	mu      sync.Mutex
+	samples []sample
+}
+
+// sample is a snapshot of the controllers' cumulative reconcile counts
+type sample struct {
+	time        time.Time
+	controllers map[string]counts
+}
+
+type counts struct {
+	reconciles float64
+	errors     float64
+	slow       float64
+}
+
+// NewController is a constructor. The gatherer is usually the controller
+// runtime's metrics registry.
+func NewController(kubeClient client.Client, gatherer prometheus.Gatherer, recorder events.Recorder) *Controller {
+	return &Controller{kubeClient: kubeClient, gatherer: gatherer, recorder: recorder}
+}
+
+// Reconcile samples the reconcile metrics and reports the controllers' health
+func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	ctx = karpenterlogging.WithReconcile(ctx, controllerName, "karpenterstatus", req.Name)
+	if req.Name != v1alpha5.KarpenterStatusName {
+		return reconcile.Result{}, nil
+	}
+	status := &v1alpha5.KarpenterStatus{}
+	if err := c.kubeClient.Get(ctx, req.NamespacedName, status); err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, c.create(ctx)
+		}
+		return reconcile.Result{}, err
+	}
+	if err := c.sample(ctx); err != nil {
+		return reconcile.Result{}, fmt.Errorf("sampling reconcile metrics, %w", err)
+	}
+	persisted := status.DeepCopy()
+	c.report(ctx, status)
+	if !equality.Semantic.DeepEqual(status.Status, persisted.Status) {
+		if err := c.kubeClient.Status().Patch(ctx, status, client.MergeFrom(persisted)); err != nil {
+			return reconcile.Result{}, fmt.Errorf("patching karpenter status, %w", err)
+		}
+	}
+	return reconcile.Result{RequeueAfter: SampleInterval}, nil
+}
+
+// create creates the KarpenterStatus singleton, unless it already exists
+func (c *Controller) create(ctx context.Context) error {
+	if err := c.kubeClient.Create(ctx, &v1alpha5.KarpenterStatus{ObjectMeta: metav1.ObjectMeta{Name: v1alpha5.KarpenterStatusName}}); err != nil && !errors.IsAlreadyExists(err) {
+		return fmt.Errorf("creating karpenter status, %w", err)
+	}
+	return nil
+}
+
+// sample records the controllers' cumulative reconcile counts, and forgets the
+// samples that are no longer needed to measure the health window
+func (c *Controller) sample(ctx context.Context) error {
+	families, err := c.gatherer.Gather()
+	if err != nil {
+		return err
+	}
+	threshold := injection.GetOptions(ctx).HealthLatencyThreshold.Seconds()
+	controllers := map[string]counts{}
+	for _, family := range families {
+		if family.GetName() != reconcileTotalMetric && family.GetName() != reconcileTimeMetric {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			name := label(metric, "controller")
+			count := controllers[name]
+			if family.GetName() == reconcileTotalMetric {
+				count.reconciles += metric.GetCounter().GetValue()
+				if label(metric, "result") == "error" {
+					count.errors += metric.GetCounter().GetValue()
+				}
+			} else {
+				count.slow += slow(metric.GetHistogram(), threshold)
+			}
+			controllers[name] = count
+		}
+	}
+	now := injectabletime.Now()
+	windowStart := now.Add(-injection.GetOptions(ctx).HealthWindow)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.samples = append(c.samples, sample{time: now, controllers: controllers})
+	// Keep the newest sample from before the window, to measure it in full
+	for len(c.samples) > 1 && !c.samples[1].time.After(windowStart) {
+		c.samples = c.samples[1:]
+	}
+	return nil
+}
+
+// slow returns how many of the histogram's observations exceeded the
+// threshold, rounded down to the histogram's buckets
+func slow(histogram *dto.Histogram, threshold float64) float64 {
+	fast := uint64(0)
+	for _, bucket := range histogram.GetBucket() {
+		if bucket.GetUpperBound() <= threshold {
+			fast = bucket.GetCumulativeCount()
+		}
+	}
+	return float64(histogram.GetSampleCount() - fast)
+}
+
+func label(metric *dto.Metric, name string) string {
+	for _, pair := range metric.GetLabel() {
+		if pair.GetName() == name {
+			return pair.GetValue()
+		}
+	}
+	return ""
+}
+
+// health returns each controller's reconciles over the health window, and how
+// it breached a threshold, if it did
+func (c *Controller) health(ctx context.Context) []v1alpha5.ControllerHealth {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.samples) < 2 {
+		return nil
+	}
+	oldest, newest := c.samples[0], c.samples[len(c.samples)-1]
+	window := newest.time.Sub(oldest.time).Round(time.Second)
+	opts := injection.GetOptions(ctx)
+	healths := []v1alpha5.ControllerHealth{}
+	for name, count := range newest.controllers {
+		health := v1alpha5.ControllerHealth{
+			Name:           name,
+			Reconciles:     int64(count.reconciles - oldest.controllers[name].reconciles),
+			Errors:         int64(count.errors - oldest.controllers[name].errors),
+			SlowReconciles: int64(count.slow - oldest.controllers[name].slow),
+		}
+		if health.Reconciles == 0 {
+			continue
+		}
+		breaches := []string{}
+		if health.Reconciles >= minReconciles && float64(health.Errors) > opts.HealthErrorRateThreshold*float64(health.Reconciles) {
+			breaches = append(breaches, fmt.Sprintf("%d of %d reconciles failed", health.Errors, health.Reconciles))
+		}
+		if health.SlowReconciles > 0 && float64(health.SlowReconciles) > slowFraction*float64(health.Reconciles) {
+			breaches = append(breaches, fmt.Sprintf("%d of %d reconciles took longer than %s", health.SlowReconciles, health.Reconciles, opts.HealthLatencyThreshold))
+		}
+		if len(breaches) > 0 {
+			health.Degraded = fmt.Sprintf("%s in the last %s", strings.Join(breaches, " and "), window)
+		}
+		healths = append(healths, health)
+	}
+	sort.Slice(healths, func(i, j int) bool { return healths[i].Name < healths[j].Name })
+	return healths
+}
+
+// report sets the controllers' health and the conditions of the status, and
+// records an event when Karpenter becomes Degraded or recovers
+func (c *Controller) report(ctx context.Context, status *v1alpha5.KarpenterStatus) {
+	status.Status.Controllers = c.health(ctx)
+	degraded := []string{}
+	for _, health := range status.Status.Controllers {
+		if health.Degraded != "" {
+			degraded = append(degraded, fmt.Sprintf("%s controller %s", health.Name, health.Degraded))
+		}
+	}
+	wasDegraded := status.StatusConditions().GetCondition(v1alpha5.Degraded).IsTrue()
+	// Degraded isn't a dependent of Ready, so Ready is marked after it
+	if len(degraded) > 0 {
+		message := strings.Join(degraded, "; ")
+		status.StatusConditions().MarkTrueWithReason(v1alpha5.Degraded, ThresholdsBreachedReason, "%s", message)
+		status.StatusConditions().MarkFalse(knativeapis.ConditionReady, ThresholdsBreachedReason, "%s", message)
+		if !wasDegraded {
+			logging.FromContext(ctx).Errorf("Controllers degraded, %s", message)
+			c.recorder.ControllersDegraded(status, message)
+		}
+		return
+	}
+	status.StatusConditions().MarkFalse(v1alpha5.Degraded, "", "")
+	status.StatusConditions().MarkTrue(knativeapis.ConditionReady)
+	if wasDegraded {
+		logging.FromContext(ctx).Infof("Controllers recovered")
+		c.recorder.ControllersRecovered(status)
+	}
+}
+
+// Register the controller to the manager. The KarpenterStatus is created once
+// elected, and recreated if it's deleted. Patches of its status don't trigger
+// reconciles, since it's reconciled every sample interval.
+func (c *Controller) Register(_ context.Context, m manager.Manager) error {
+	if err := m.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		if err := c.create(ctx); err != nil {
+			logging.FromContext(ctx).Errorf("Unable to report health, %s", err.Error())
+		}
+		return nil
+	})); err != nil {
+		return err
+	}
+	return controllerruntime.
+		NewControllerManagedBy(m).
+		Named(controllerName).
+		For(&v1alpha5.KarpenterStatus{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Complete(c)
+}
@@ -0,0 +1,153 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package health_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/karpenter/pkg/apis"
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/controllers/health"
+	"github.com/aws/karpenter/pkg/events"
+	"github.com/aws/karpenter/pkg/utils/injectabletime"
+	"github.com/aws/karpenter/pkg/utils/injection"
+	"github.com/aws/karpenter/pkg/utils/options"
+
+	. "github.com/aws/karpenter/pkg/test/expectations"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	knativeapis "knative.dev/pkg/apis"
+	. "knative.dev/pkg/logging/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var ctx context.Context
+
+func TestAPIs(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Health")
+}
+
+var _ = Describe("Health", func() {
+	var kubeClient client.Client
+	var recorder *record.FakeRecorder
+	var controller *health.Controller
+	var reconcileTotal *prometheus.CounterVec
+	var reconcileTime *prometheus.HistogramVec
+	var now time.Time
+	key := client.ObjectKey{Name: v1alpha5.KarpenterStatusName}
+
+	BeforeEach(func() {
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(apis.AddToScheme(scheme)).To(Succeed())
+		kubeClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			&v1alpha5.KarpenterStatus{ObjectMeta: metav1.ObjectMeta{Name: v1alpha5.KarpenterStatusName}},
+		).Build()
+		// Mirror the reconcile metrics that controller-runtime registers
+		registry := prometheus.NewRegistry()
+		reconcileTotal = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "controller_runtime_reconcile_total"}, []string{"controller", "result"})
+		reconcileTime = prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "controller_runtime_reconcile_time_seconds", Buckets: []float64{1, 10, 30, 60}}, []string{"controller"})
+		registry.MustRegister(reconcileTotal, reconcileTime)
+		recorder = record.NewFakeRecorder(100)
+		controller = health.NewController(kubeClient, registry, events.NewRecorder(recorder))
+		ctx = injection.WithOptions(ctx, options.Options{HealthWindow: 5 * time.Minute, HealthErrorRateThreshold: 0.25, HealthLatencyThreshold: 30 * time.Second})
+		now = time.Now()
+		injectabletime.Now = func() time.Time { return now }
+		// The first sample is the baseline of the window
+		ExpectReconcileSucceeded(ctx, controller, key)
+	})
+	AfterEach(func() {
+		injectabletime.Now = time.Now
+	})
+
+	reconcileAfter := func(duration time.Duration) *v1alpha5.KarpenterStatus {
+		now = now.Add(duration)
+		ExpectReconcileSucceeded(ctx, controller, key)
+		status := &v1alpha5.KarpenterStatus{}
+		Expect(kubeClient.Get(ctx, key, status)).To(Succeed())
+		return status
+	}
+	reconciles := func(controller string, result string, count int, latency time.Duration) {
+		reconcileTotal.WithLabelValues(controller, result).Add(float64(count))
+		for i := 0; i < count; i++ {
+			reconcileTime.WithLabelValues(controller).Observe(latency.Seconds())
+		}
+	}
+
+	It("should create the status if it doesn't exist", func() {
+		Expect(kubeClient.Delete(ctx, &v1alpha5.KarpenterStatus{ObjectMeta: metav1.ObjectMeta{Name: v1alpha5.KarpenterStatusName}})).To(Succeed())
+		ExpectReconcileSucceeded(ctx, controller, key)
+		Expect(kubeClient.Get(ctx, key, &v1alpha5.KarpenterStatus{})).To(Succeed())
+	})
+	It("should report healthy controllers", func() {
+		reconciles("selection", "success", 20, time.Second)
+		reconciles("selection", "error", 2, time.Second)
+		status := reconcileAfter(health.SampleInterval)
+		Expect(status.StatusConditions().IsHappy()).To(BeTrue())
+		Expect(status.StatusConditions().GetCondition(v1alpha5.Degraded).IsFalse()).To(BeTrue())
+		Expect(status.Status.Controllers).To(Equal([]v1alpha5.ControllerHealth{{Name: "selection", Reconciles: 22, Errors: 2}}))
+		Expect(recorder.Events).To(BeEmpty())
+	})
+	It("should degrade when a controller's error rate breaches the threshold", func() {
+		reconciles("selection", "success", 10, time.Second)
+		reconciles("selection", "error", 10, time.Second)
+		reconciles("termination", "success", 10, time.Second)
+		status := reconcileAfter(health.SampleInterval)
+		degraded := status.StatusConditions().GetCondition(v1alpha5.Degraded)
+		Expect(degraded.IsTrue()).To(BeTrue())
+		Expect(degraded.Reason).To(Equal(health.ThresholdsBreachedReason))
+		Expect(degraded.Message).To(Equal("selection controller 10 of 20 reconciles failed in the last 30s"))
+		Expect(status.StatusConditions().GetCondition(knativeapis.ConditionReady).IsFalse()).To(BeTrue())
+		Expect(status.Status.Controllers).To(HaveLen(2))
+		Expect(recorder.Events).To(Receive(Equal("Warning Degraded Controllers degraded, selection controller 10 of 20 reconciles failed in the last 30s")))
+
+		// Errors age out of the window
+		reconciles("selection", "success", 20, time.Second)
+		status = reconcileAfter(6 * time.Minute)
+		Expect(status.StatusConditions().IsHappy()).To(BeTrue())
+		Expect(status.StatusConditions().GetCondition(v1alpha5.Degraded).IsFalse()).To(BeTrue())
+		Expect(recorder.Events).To(Receive(Equal("Normal Recovered Controllers recovered")))
+	})
+	It("should ignore the error rate of quiet controllers", func() {
+		reconciles("deletion", "error", 5, time.Second)
+		status := reconcileAfter(health.SampleInterval)
+		Expect(status.StatusConditions().IsHappy()).To(BeTrue())
+	})
+	It("should degrade when a controller's reconcile latency breaches the threshold", func() {
+		reconciles("provisioning", "success", 99, time.Second)
+		reconciles("provisioning", "success", 2, 45*time.Second)
+		status := reconcileAfter(health.SampleInterval)
+		Expect(status.StatusConditions().GetCondition(v1alpha5.Degraded).Message).To(Equal("provisioning controller 2 of 101 reconciles took longer than 30s in the last 30s"))
+		Expect(status.Status.Controllers[0].SlowReconciles).To(Equal(int64(2)))
+	})
+	It("should only record an event when Karpenter becomes degraded", func() {
+		reconciles("selection", "error", 20, time.Second)
+		reconcileAfter(health.SampleInterval)
+		Expect(recorder.Events).To(Receive(HavePrefix("Warning Degraded")))
+		reconciles("selection", "error", 20, time.Second)
+		reconcileAfter(health.SampleInterval)
+		Expect(recorder.Events).To(BeEmpty())
+	})
+})
@@ -17,6 +17,8 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"time"
 
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/rest"
@@ -55,9 +57,23 @@ func (m *GenericControllerManager) RegisterControllers(ctx context.Context, cont
 	if err := m.AddReadyzCheck("readyz", healthz.Ping); err != nil {
 		panic(fmt.Sprintf("Failed to add ready probe, %s", err.Error()))
 	}
+	if err := m.AddReadyzCheck("informers", m.informersSynced); err != nil {
+		panic(fmt.Sprintf("Failed to add informer sync probe, %s", err.Error()))
+	}
 	return m
 }
 
+// informersSynced fails until the informers of the manager's cache have synced,
+// since controllers that read from an unsynced cache see a partial cluster
+func (m *GenericControllerManager) informersSynced(req *http.Request) error {
+	ctx, cancel := context.WithTimeout(req.Context(), time.Second)
+	defer cancel()
+	if !m.GetCache().WaitForCacheSync(ctx) {
+		return fmt.Errorf("informers not synced")
+	}
+	return nil
+}
+
 func podSchedulingIndex(object client.Object) []string {
 	pod, ok := object.(*v1.Pod)
 	if !ok {
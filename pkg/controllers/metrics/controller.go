@@ -59,7 +59,8 @@ func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reco
 			return reconcile.Result{}, err
 		}
 
-		// The provisioner has been deleted.
+		// The provisioner has been deleted, so it no longer costs anything.
+		clusterCostEstimateByProvisioner.DeleteLabelValues(req.Name)
 		return reconcile.Result{}, nil
 	}
 
@@ -98,7 +99,7 @@ func (c *Controller) updateCounts(ctx context.Context, provisioner *v1alpha5.Pro
 }
 
 func (c *Controller) updateNodeCounts(ctx context.Context, provisioner *v1alpha5.Provisioner) error {
-	instanceTypes, err := c.CloudProvider.GetInstanceTypes(ctx, &provisioner.Spec.Constraints)
+	instanceTypes, err := c.CloudProvider.GetInstanceTypes(ctx, provisioner)
 	if err != nil {
 		return err
 	}
@@ -109,9 +110,7 @@ func (c *Controller) updateNodeCounts(ctx context.Context, provisioner *v1alpha5
 	for _, instanceType := range instanceTypes {
 		archValues.Insert(instanceType.Architecture())
 		instanceTypeValues.Insert(instanceType.Name())
-		for _, offering := range instanceType.Offerings() {
-			zoneValues.Insert(offering.Zone)
-		}
+		zoneValues.Insert(instanceType.Offerings().Zones().UnsortedList()...)
 	}
 	knownValuesForNodeLabels := map[string]sets.String{
 		nodeLabelArch:         archValues,
@@ -119,13 +118,21 @@ func (c *Controller) updateNodeCounts(ctx context.Context, provisioner *v1alpha5
 		nodeLabelZone:         zoneValues,
 	}
 
-	return publishNodeCounts(provisioner.Name, knownValuesForNodeLabels, func(matchingLabels client.MatchingLabels, consume nodeListConsumerFunc) error {
-		nodes := v1.NodeList{}
-		if err := c.KubeClient.List(ctx, &nodes, matchingLabels); err != nil {
-			return err
-		}
-		return consume(nodes.Items)
-	})
+	nodes := v1.NodeList{}
+	if err := c.KubeClient.List(ctx, &nodes, client.MatchingLabels{nodeLabelProvisioner: provisioner.Name}); err != nil {
+		return err
+	}
+
+	return multierr.Append(
+		publishNodeCounts(provisioner.Name, knownValuesForNodeLabels, func(matchingLabels client.MatchingLabels, consume nodeListConsumerFunc) error {
+			nodes := v1.NodeList{}
+			if err := c.KubeClient.List(ctx, &nodes, matchingLabels); err != nil {
+				return err
+			}
+			return consume(nodes.Items)
+		}),
+		publishCost(provisioner.Name, nodes.Items, instanceTypes),
+	)
 }
 
 func (c *Controller) updatePodCounts(ctx context.Context, provisioner *v1alpha5.Provisioner) error {
@@ -0,0 +1,54 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	v1 "k8s.io/api/core/v1"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const metricSubsystemCluster = "cluster"
+
+var clusterCostEstimateByProvisioner = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: metricSubsystemCluster,
+		Name:      "cost_estimate_hourly",
+		Help:      "Estimated hourly cost in USD of the nodes by provisioner, from the prices of their offerings. Nodes with an unknown price are excluded.",
+	},
+	[]string{
+		metricLabelProvisioner,
+	},
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(clusterCostEstimateByProvisioner)
+}
+
+func publishCost(provisioner string, nodes []v1.Node, instanceTypes []cloudprovider.InstanceType) error {
+	cost := 0.0
+	for i := range nodes {
+		cost += cloudprovider.NodePrice(&nodes[i], instanceTypes)
+	}
+	gauge, err := clusterCostEstimateByProvisioner.GetMetricWith(prometheus.Labels{metricLabelProvisioner: provisioner})
+	if err != nil {
+		return err
+	}
+	gauge.Set(cost)
+	return nil
+}
@@ -0,0 +1,92 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package node
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	v1 "k8s.io/api/core/v1"
+	"knative.dev/pkg/logging"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/audit"
+	"github.com/aws/karpenter/pkg/metrics"
+	"github.com/aws/karpenter/pkg/utils/injectabletime"
+)
+
+// CancellationGracePeriod is how long after a node is created before it may be
+// cancelled, so that the pods it was launched for are bound to it first
+const CancellationGracePeriod = 30 * time.Second
+
+var cancelledCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "nodes",
+		Name:      "cancelled_total",
+		Help:      "Number of nodes that were terminated before joining the cluster, because the pods they were launched for were gone. Broken down by provisioner.",
+	},
+	[]string{metrics.ProvisionerLabel},
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(cancelledCounter)
+}
+
+// Cancellation is a subreconciler that deletes nodes that haven't joined the
+// cluster yet, if the pods they were launched for are gone, e.g. because they
+// were deleted when their workload scaled down. Their instances are terminated
+// rather than waiting for them to join and for the emptiness TTL. Nodes
+// launched for headroom or as replacements have no pods bound to them, and
+// aren't cancelled.
+type Cancellation struct {
+	kubeClient client.Client
+}
+
+// Reconcile reconciles the node
+func (r *Cancellation) Reconcile(ctx context.Context, provisioner *v1alpha5.Provisioner, n *v1.Node) (reconcile.Result, error) {
+	if _, ok := n.Annotations[v1alpha5.CancelableAnnotationKey]; !ok {
+		return reconcile.Result{}, nil
+	}
+	// Nodes that fail to join are replaced by the liveness subreconciler
+	timeSinceCreation := injectabletime.Now().Sub(n.GetCreationTimestamp().Time)
+	if hasJoined(n) || timeSinceCreation >= LivenessTimeout {
+		return reconcile.Result{}, nil
+	}
+	if timeSinceCreation < CancellationGracePeriod {
+		return reconcile.Result{RequeueAfter: CancellationGracePeriod - timeSinceCreation}, nil
+	}
+	// Pod changes requeue the node. Pods deleted after they were bound to it
+	// stay terminating, since the node has no kubelet to acknowledge them.
+	empty, err := isEmpty(ctx, r.kubeClient, n)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if !empty {
+		return reconcile.Result{}, nil
+	}
+	if err := r.kubeClient.Delete(ctx, n); err != nil {
+		return reconcile.Result{}, fmt.Errorf("deleting node, %w", err)
+	}
+	audit.Deleted(ctx, n, "cancelled, its pods were gone before it joined", nil)
+	cancelledCounter.WithLabelValues(provisioner.Name).Inc()
+	logging.FromContext(ctx).Infof("Triggered termination for node that has no pods before joining")
+	return reconcile.Result{}, nil
+}
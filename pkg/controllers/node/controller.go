@@ -33,35 +33,41 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/controllers/disruption"
+	"github.com/aws/karpenter/pkg/events"
+	karpenterlogging "github.com/aws/karpenter/pkg/utils/logging"
 	"github.com/aws/karpenter/pkg/utils/result"
 )
 
 const controllerName = "node"
 
 // NewController constructs a controller instance
-func NewController(kubeClient client.Client) *Controller {
+func NewController(kubeClient client.Client, gatekeeper *disruption.Gatekeeper, recorder events.Recorder) *Controller {
 	return &Controller{
-		kubeClient: kubeClient,
-		liveness:   &Liveness{kubeClient: kubeClient},
-		emptiness:  &Emptiness{kubeClient: kubeClient},
-		expiration: &Expiration{kubeClient: kubeClient},
+		kubeClient:   kubeClient,
+		liveness:     &Liveness{kubeClient: kubeClient},
+		cancellation: &Cancellation{kubeClient: kubeClient},
+		emptiness:    &Emptiness{kubeClient: kubeClient, gatekeeper: gatekeeper},
+		expiration:   &Expiration{gatekeeper: gatekeeper, recorder: recorder},
 	}
 }
 
 // Controller manages a set of properties on karpenter provisioned nodes, such as
 // taints, labels, finalizers.
 type Controller struct {
-	kubeClient client.Client
-	readiness  *Readiness
-	liveness   *Liveness
-	emptiness  *Emptiness
-	expiration *Expiration
-	finalizer  *Finalizer
+	kubeClient   client.Client
+	readiness    *Readiness
+	liveness     *Liveness
+	cancellation *Cancellation
+	emptiness    *Emptiness
+	expiration   *Expiration
+	finalizer    *Finalizer
+	ownership    *Ownership
 }
 
 // Reconcile executes a reallocation control loop for the resource
 func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
-	ctx = logging.WithLogger(ctx, logging.FromContext(ctx).Named(controllerName).With("node", req.Name))
+	ctx = karpenterlogging.WithReconcile(ctx, controllerName, "node", req.Name)
 	// 1. Retrieve Node, ignore if not provisioned or terminating
 	stored := &v1.Node{}
 	if err := c.kubeClient.Get(ctx, req.NamespacedName, stored); err != nil {
@@ -95,9 +101,11 @@ func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reco
 	}{
 		c.readiness,
 		c.liveness,
+		c.cancellation,
 		c.expiration,
 		c.emptiness,
 		c.finalizer,
+		c.ownership,
 	} {
 		res, err := reconciler.Reconcile(ctx, provisioner, node)
 		errs = multierr.Append(errs, err)
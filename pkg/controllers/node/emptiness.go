@@ -20,6 +20,7 @@ import (
 	"time"
 
 	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/controllers/disruption"
 	"github.com/aws/karpenter/pkg/utils/functional"
 	"github.com/aws/karpenter/pkg/utils/injectabletime"
 	"github.com/aws/karpenter/pkg/utils/node"
@@ -34,6 +35,7 @@ import (
 // Emptiness is a subreconciler that deletes nodes that are empty after a ttl
 type Emptiness struct {
 	kubeClient client.Client
+	gatekeeper *disruption.Gatekeeper
 }
 
 // Reconcile reconciles the node
@@ -45,8 +47,13 @@ func (r *Emptiness) Reconcile(ctx context.Context, provisioner *v1alpha5.Provisi
 	if !node.IsReady(n) {
 		return reconcile.Result{}, nil
 	}
+	// Scheduled capacity is empty until its window starts, and is released
+	// once it ends
+	if _, ok := n.Annotations[v1alpha5.ScheduledCapacityAnnotationKey]; ok {
+		return reconcile.Result{}, nil
+	}
 	// 2. Remove ttl if not empty
-	empty, err := r.isEmpty(ctx, n)
+	empty, err := isEmpty(ctx, r.kubeClient, n)
 	if err != nil {
 		return reconcile.Result{}, err
 	}
@@ -73,22 +80,29 @@ func (r *Emptiness) Reconcile(ctx context.Context, provisioner *v1alpha5.Provisi
 		return reconcile.Result{}, fmt.Errorf("parsing emptiness timestamp, %s", emptinessTimestamp)
 	}
 	if injectabletime.Now().After(emptinessTime.Add(ttl)) {
-		logging.FromContext(ctx).Infof("Triggering termination after %s for empty node", ttl)
-		if err := r.kubeClient.Delete(ctx, n); err != nil {
-			return reconcile.Result{}, fmt.Errorf("deleting node, %w", err)
+		disrupted, err := r.gatekeeper.DisruptEmpty(ctx, provisioner, n, fmt.Sprintf("empty for %s", ttl))
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		if !disrupted {
+			return reconcile.Result{RequeueAfter: disruption.RetryInterval}, nil
 		}
+		logging.FromContext(ctx).Infof("Triggered termination after %s for empty node", ttl)
 	}
 	return reconcile.Result{}, nil
 }
 
-func (r *Emptiness) isEmpty(ctx context.Context, n *v1.Node) (bool, error) {
+// isEmpty returns true if the node runs no pods, other than daemons and static
+// pods. Terminating pods are ignored, since those on a node whose kubelet isn't
+// running stay terminating until the node is deleted.
+func isEmpty(ctx context.Context, kubeClient client.Client, n *v1.Node) (bool, error) {
 	pods := &v1.PodList{}
-	if err := r.kubeClient.List(ctx, pods, client.MatchingFields{"spec.nodeName": n.Name}); err != nil {
+	if err := kubeClient.List(ctx, pods, client.MatchingFields{"spec.nodeName": n.Name}); err != nil {
 		return false, fmt.Errorf("listing pods for node, %w", err)
 	}
 	for i := range pods.Items {
 		p := pods.Items[i]
-		if pod.IsTerminal(&p) {
+		if pod.IsTerminal(&p) || pod.IsTerminating(&p) {
 			continue
 		}
 		if !pod.IsOwnedByDaemonSet(&p) && !pod.IsOwnedByNode(&p) {
@@ -17,37 +17,44 @@ package node
 import (
 	"context"
 	"fmt"
-	"time"
 
 	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/controllers/disruption"
+	"github.com/aws/karpenter/pkg/events"
 	"github.com/aws/karpenter/pkg/utils/injectabletime"
-	"github.com/aws/karpenter/pkg/utils/ptr"
 	v1 "k8s.io/api/core/v1"
 	"knative.dev/pkg/logging"
-	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
 // Expiration is a subreconciler that terminates nodes after a period of time.
 type Expiration struct {
-	kubeClient client.Client
+	gatekeeper *disruption.Gatekeeper
+	recorder   events.Recorder
 }
 
 // Reconcile reconciles the node
 func (r *Expiration) Reconcile(ctx context.Context, provisioner *v1alpha5.Provisioner, node *v1.Node) (reconcile.Result, error) {
 	// 1. Ignore node if not applicable
-	if provisioner.Spec.TTLSecondsUntilExpired == nil {
+	expirationTTL, ok := provisioner.Spec.ExpirationTTL(node)
+	if !ok {
 		return reconcile.Result{}, nil
 	}
 	// 2. Trigger termination workflow if expired
-	expirationTTL := time.Duration(ptr.Int64Value(provisioner.Spec.TTLSecondsUntilExpired)) * time.Second
 	expirationTime := node.CreationTimestamp.Add(expirationTTL)
-	if injectabletime.Now().After(expirationTime) {
-		logging.FromContext(ctx).Infof("Triggering termination for expired node after %s (+%s)", expirationTTL, time.Since(expirationTime))
-		if err := r.kubeClient.Delete(ctx, node); err != nil {
-			return reconcile.Result{}, fmt.Errorf("deleting node, %w", err)
+	now := injectabletime.Now()
+	if now.After(expirationTime) {
+		disrupted, err := r.gatekeeper.Disrupt(ctx, provisioner, node, fmt.Sprintf("expired after %s", expirationTTL))
+		if err != nil {
+			return reconcile.Result{}, err
 		}
+		if !disrupted {
+			return reconcile.Result{RequeueAfter: disruption.RetryInterval}, nil
+		}
+		logging.FromContext(ctx).Infof("Triggered termination for expired node after %s (+%s)", expirationTTL, now.Sub(expirationTime))
+		r.recorder.NodeExpired(node, expirationTTL)
+		return reconcile.Result{}, nil
 	}
 	// 3. Backoff until expired
-	return reconcile.Result{RequeueAfter: time.Until(expirationTime)}, nil
+	return reconcile.Result{RequeueAfter: expirationTime.Sub(now)}, nil
 }
@@ -19,39 +19,101 @@ import (
 	"fmt"
 	"time"
 
-	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
-	"github.com/aws/karpenter/pkg/utils/injectabletime"
-	"github.com/aws/karpenter/pkg/utils/node"
+	"github.com/prometheus/client_golang/prometheus"
 	v1 "k8s.io/api/core/v1"
 	"knative.dev/pkg/logging"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/audit"
+	"github.com/aws/karpenter/pkg/metrics"
+	"github.com/aws/karpenter/pkg/utils/injectabletime"
+	"github.com/aws/karpenter/pkg/utils/node"
+)
+
+const (
+	// LivenessTimeout is how long a node may take to join the cluster
+	LivenessTimeout = 15 * time.Minute
+	// NotReadyTimeout is how long a node that joined may be NotReady without
+	// pods before it's replaced. The node lifecycle controller evicts pods from
+	// NotReady nodes after five minutes by default.
+	NotReadyTimeout = 10 * time.Minute
+
+	registrationTimeoutReason = "registration_timeout"
+	notReadyReason            = "not_ready"
 )
 
-const LivenessTimeout = 15 * time.Minute
+var unhealthyReplacedCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "nodes",
+		Name:      "unhealthy_replaced_total",
+		Help:      "Number of unhealthy nodes that were terminated to be replaced. Broken down by provisioner and reason, registration_timeout or not_ready.",
+	},
+	[]string{metrics.ProvisionerLabel, "reason"},
+)
 
-// Liveness is a subreconciler that deletes nodes determined to be unrecoverable
+func init() {
+	crmetrics.Registry.MustRegister(unhealthyReplacedCounter)
+}
+
+// Liveness is a subreconciler that deletes nodes determined to be
+// unrecoverable: nodes that failed to join the cluster, and nodes that have
+// been NotReady for too long and no longer run pods. Their pods, if any, are
+// provisioned on replacement nodes.
 type Liveness struct {
 	kubeClient client.Client
 }
 
 // Reconcile reconciles the node
-func (r *Liveness) Reconcile(ctx context.Context, _ *v1alpha5.Provisioner, n *v1.Node) (reconcile.Result, error) {
-	if timeSinceCreation := injectabletime.Now().Sub(n.GetCreationTimestamp().Time); timeSinceCreation < LivenessTimeout {
-		return reconcile.Result{RequeueAfter: LivenessTimeout - timeSinceCreation}, nil
-	}
+func (r *Liveness) Reconcile(ctx context.Context, provisioner *v1alpha5.Provisioner, n *v1.Node) (reconcile.Result, error) {
 	condition := node.GetCondition(n.Status.Conditions, v1.NodeReady)
-	// If the reason is "", then the condition has never been set. We expect
-	// either the kubelet to set this reason, or the kcm's
-	// node-lifecycle-controller to set the status to NodeStatusNeverUpdated if
-	// the kubelet cannot connect. Once the value is NodeStatusNeverUpdated and
-	// the node is beyond the liveness timeout, we will delete the node.
-	if condition.Reason != "" && condition.Reason != "NodeStatusNeverUpdated" {
+	// Once the node is beyond the liveness timeout without joining, we will
+	// delete the node.
+	if !hasJoined(n) {
+		if timeSinceCreation := injectabletime.Now().Sub(n.GetCreationTimestamp().Time); timeSinceCreation < LivenessTimeout {
+			return reconcile.Result{RequeueAfter: LivenessTimeout - timeSinceCreation}, nil
+		}
+		logging.FromContext(ctx).Infof("Triggering termination for node that failed to join")
+		return reconcile.Result{}, r.replace(ctx, provisioner, n, registrationTimeoutReason)
+	}
+	if condition.Status == v1.ConditionTrue {
 		return reconcile.Result{}, nil
 	}
-	logging.FromContext(ctx).Infof("Triggering termination for node that failed to join")
+	// Nodes that joined but became NotReady may recover, so they're only
+	// replaced once they've been NotReady for a while, and their pods were
+	// evicted. Pod changes requeue the node.
+	if timeNotReady := injectabletime.Now().Sub(condition.LastTransitionTime.Time); timeNotReady < NotReadyTimeout {
+		return reconcile.Result{RequeueAfter: NotReadyTimeout - timeNotReady}, nil
+	}
+	empty, err := isEmpty(ctx, r.kubeClient, n)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if !empty {
+		return reconcile.Result{}, nil
+	}
+	logging.FromContext(ctx).Infof("Triggering termination for node that has been NotReady since %s", condition.LastTransitionTime.Format(time.RFC3339))
+	return reconcile.Result{}, r.replace(ctx, provisioner, n, notReadyReason)
+}
+
+// hasJoined returns true if the kubelet of the node has reported its status.
+// If the reason of the ready condition is "", then the condition has never been
+// set. We expect either the kubelet to set this reason, or the kcm's
+// node-lifecycle-controller to set the status to NodeStatusNeverUpdated if the
+// kubelet cannot connect.
+func hasJoined(n *v1.Node) bool {
+	reason := node.GetCondition(n.Status.Conditions, v1.NodeReady).Reason
+	return reason != "" && reason != "NodeStatusNeverUpdated"
+}
+
+func (r *Liveness) replace(ctx context.Context, provisioner *v1alpha5.Provisioner, n *v1.Node, reason string) error {
 	if err := r.kubeClient.Delete(ctx, n); err != nil {
-		return reconcile.Result{}, fmt.Errorf("deleting node, %w", err)
+		return fmt.Errorf("deleting node, %w", err)
 	}
-	return reconcile.Result{}, nil
+	audit.Deleted(ctx, n, fmt.Sprintf("unhealthy, %s", reason), nil)
+	unhealthyReplacedCounter.WithLabelValues(provisioner.Name, reason).Inc()
+	return nil
 }
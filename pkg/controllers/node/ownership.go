@@ -0,0 +1,51 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package node
+
+import (
+	"context"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// Ownership is a subreconciler that ensures nodes are owned by their
+// provisioner, so that they're garbage collected when it's deleted. This adopts
+// nodes that were launched before owner references were set, that came online
+// without the node object Karpenter created, or that were launched by an
+// earlier provisioner of the same name. Nodes aren't adopted by provisioners
+// that are being deleted, so that deleting them with --cascade=orphan orphans
+// their nodes.
+type Ownership struct{}
+
+// Reconcile reconciles the node
+func (r *Ownership) Reconcile(_ context.Context, provisioner *v1alpha5.Provisioner, n *v1.Node) (reconcile.Result, error) {
+	if !provisioner.DeletionTimestamp.IsZero() || provisioner.Owns(n) {
+		return reconcile.Result{}, nil
+	}
+	owners := []metav1.OwnerReference{}
+	for _, owner := range n.OwnerReferences {
+		// Replace references to provisioners of any version that no longer exist
+		if gv, err := schema.ParseGroupVersion(owner.APIVersion); err == nil && gv.Group == v1alpha5.SchemeGroupVersion.Group && owner.Kind == "Provisioner" {
+			continue
+		}
+		owners = append(owners, owner)
+	}
+	n.OwnerReferences = append(owners, provisioner.OwnerReference())
+	return reconcile.Result{}, nil
+}
@@ -22,7 +22,9 @@ import (
 
 	"github.com/Pallinder/go-randomdata"
 	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/controllers/disruption"
 	"github.com/aws/karpenter/pkg/controllers/node"
+	"github.com/aws/karpenter/pkg/events"
 	"github.com/aws/karpenter/pkg/test"
 	"github.com/aws/karpenter/pkg/utils/injectabletime"
 
@@ -31,6 +33,8 @@ import (
 	. "github.com/onsi/gomega"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
 	. "knative.dev/pkg/logging/testing"
 	"knative.dev/pkg/ptr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -38,6 +42,7 @@ import (
 
 var ctx context.Context
 var controller *node.Controller
+var recorder *record.FakeRecorder
 var env *test.Environment
 
 func TestAPIs(t *testing.T) {
@@ -48,7 +53,8 @@ func TestAPIs(t *testing.T) {
 
 var _ = BeforeSuite(func() {
 	env = test.NewEnvironment(ctx, func(e *test.Environment) {
-		controller = node.NewController(e.Client)
+		recorder = record.NewFakeRecorder(100)
+		controller = node.NewController(e.Client, disruption.NewGatekeeper(e.Client), events.NewRecorder(recorder))
 	})
 	Expect(env.Start()).To(Succeed(), "Failed to start environment")
 })
@@ -64,6 +70,9 @@ var _ = Describe("Controller", func() {
 			ObjectMeta: metav1.ObjectMeta{Name: v1alpha5.DefaultProvisioner.Name},
 			Spec:       v1alpha5.ProvisionerSpec{},
 		}
+		for len(recorder.Events) > 0 {
+			<-recorder.Events
+		}
 	})
 
 	AfterEach(func() {
@@ -115,6 +124,48 @@ var _ = Describe("Controller", func() {
 			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
 			n = ExpectNodeExists(ctx, env.Client, n.Name)
 			Expect(n.DeletionTimestamp.IsZero()).To(BeFalse())
+			Expect(recorder.Events).To(Receive(ContainSubstring("Terminating node after it expired")))
+		})
+		It("should delete nodes after their jittered expiry", func() {
+			provisioner.Spec.TTLSecondsUntilExpired = ptr.Int64(100)
+			provisioner.Spec.ExpirationJitterPercent = ptr.Int32(50)
+			n := test.Node(test.NodeOptions{
+				Finalizers: []string{v1alpha5.TerminationFinalizer},
+				Labels: map[string]string{
+					v1alpha5.ProvisionerNameLabelKey: provisioner.Name,
+				},
+			})
+			ExpectCreated(ctx, env.Client, provisioner, n)
+
+			// Should still exist before the earliest expiry
+			injectabletime.Now = func() time.Time { return time.Now().Add(49 * time.Second) }
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+			n = ExpectNodeExists(ctx, env.Client, n.Name)
+			Expect(n.DeletionTimestamp.IsZero()).To(BeTrue())
+
+			// Should be deleted after the latest expiry
+			injectabletime.Now = func() time.Time { return time.Now().Add(151 * time.Second) }
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+			n = ExpectNodeExists(ctx, env.Client, n.Name)
+			Expect(n.DeletionTimestamp.IsZero()).To(BeFalse())
+		})
+		It("should not delete expired nodes while the disruption budget is exhausted", func() {
+			provisioner.Spec.TTLSecondsUntilExpired = ptr.Int64(30)
+			provisioner.Spec.Disruption.Budgets = []v1alpha5.Budget{{Nodes: intstr.FromInt(0)}}
+			n := test.Node(test.NodeOptions{
+				Finalizers: []string{v1alpha5.TerminationFinalizer},
+				Labels: map[string]string{
+					v1alpha5.ProvisionerNameLabelKey: provisioner.Name,
+				},
+			})
+			ExpectCreated(ctx, env.Client, provisioner, n)
+
+			injectabletime.Now = func() time.Time {
+				return time.Now().Add(time.Duration(*provisioner.Spec.TTLSecondsUntilExpired) * time.Second)
+			}
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+			n = ExpectNodeExists(ctx, env.Client, n.Name)
+			Expect(n.DeletionTimestamp.IsZero()).To(BeTrue())
 		})
 	})
 
@@ -226,6 +277,143 @@ var _ = Describe("Controller", func() {
 			n = ExpectNodeExists(ctx, env.Client, n.Name)
 			Expect(n.DeletionTimestamp.IsZero()).To(BeFalse())
 		})
+		It("should delete nodes that have been NotReady without pods after 10 minutes", func() {
+			n := test.Node(test.NodeOptions{
+				Finalizers:  []string{v1alpha5.TerminationFinalizer},
+				Labels:      map[string]string{v1alpha5.ProvisionerNameLabelKey: provisioner.Name},
+				ReadyStatus: v1.ConditionUnknown,
+				ReadyReason: "NodeStatusUnknown",
+			})
+			n.Status.Conditions[0].LastTransitionTime = metav1.Now()
+			ExpectCreated(ctx, env.Client, provisioner)
+			ExpectCreatedWithStatus(ctx, env.Client, n)
+
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+			n = ExpectNodeExists(ctx, env.Client, n.Name)
+			Expect(n.DeletionTimestamp.IsZero()).To(BeTrue())
+
+			injectabletime.Now = func() time.Time { return time.Now().Add(node.NotReadyTimeout) }
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+			n = ExpectNodeExists(ctx, env.Client, n.Name)
+			Expect(n.DeletionTimestamp.IsZero()).To(BeFalse())
+		})
+		It("should not delete NotReady nodes that still have pods", func() {
+			n := test.Node(test.NodeOptions{
+				Finalizers:  []string{v1alpha5.TerminationFinalizer},
+				Labels:      map[string]string{v1alpha5.ProvisionerNameLabelKey: provisioner.Name},
+				ReadyStatus: v1.ConditionFalse,
+				ReadyReason: "KubeletNotReady",
+			})
+			n.Status.Conditions[0].LastTransitionTime = metav1.Now()
+			ExpectCreated(ctx, env.Client, provisioner, test.Pod(test.PodOptions{NodeName: n.Name}))
+			ExpectCreatedWithStatus(ctx, env.Client, n)
+
+			injectabletime.Now = func() time.Time { return time.Now().Add(node.NotReadyTimeout) }
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+			n = ExpectNodeExists(ctx, env.Client, n.Name)
+			Expect(n.DeletionTimestamp.IsZero()).To(BeTrue())
+		})
+		It("should delete NotReady nodes whose pods are terminating", func() {
+			n := test.Node(test.NodeOptions{
+				Finalizers:  []string{v1alpha5.TerminationFinalizer},
+				Labels:      map[string]string{v1alpha5.ProvisionerNameLabelKey: provisioner.Name},
+				ReadyStatus: v1.ConditionFalse,
+				ReadyReason: "KubeletNotReady",
+			})
+			n.Status.Conditions[0].LastTransitionTime = metav1.Now()
+			pod := test.Pod(test.PodOptions{NodeName: n.Name})
+			ExpectCreated(ctx, env.Client, provisioner, pod)
+			ExpectCreatedWithStatus(ctx, env.Client, n)
+			// Without a kubelet, the pod stays terminating until its grace period is acknowledged
+			Expect(env.Client.Delete(ctx, pod)).To(Succeed())
+
+			injectabletime.Now = func() time.Time { return time.Now().Add(node.NotReadyTimeout) }
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+			n = ExpectNodeExists(ctx, env.Client, n.Name)
+			Expect(n.DeletionTimestamp.IsZero()).To(BeFalse())
+		})
+		It("should not delete ready nodes", func() {
+			n := test.Node(test.NodeOptions{
+				Finalizers:  []string{v1alpha5.TerminationFinalizer},
+				Labels:      map[string]string{v1alpha5.ProvisionerNameLabelKey: provisioner.Name},
+				ReadyStatus: v1.ConditionTrue,
+				ReadyReason: "KubeletReady",
+			})
+			ExpectCreated(ctx, env.Client, provisioner)
+			ExpectCreatedWithStatus(ctx, env.Client, n)
+
+			injectabletime.Now = func() time.Time { return time.Now().Add(node.LivenessTimeout) }
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+			n = ExpectNodeExists(ctx, env.Client, n.Name)
+			Expect(n.DeletionTimestamp.IsZero()).To(BeTrue())
+		})
+	})
+	Context("Cancellation", func() {
+		var n *v1.Node
+		BeforeEach(func() {
+			n = test.Node(test.NodeOptions{
+				Finalizers:  []string{v1alpha5.TerminationFinalizer},
+				Labels:      map[string]string{v1alpha5.ProvisionerNameLabelKey: provisioner.Name},
+				Annotations: map[string]string{v1alpha5.CancelableAnnotationKey: "true"},
+				ReadyStatus: v1.ConditionUnknown,
+				ReadyReason: "NodeStatusNeverUpdated",
+			})
+		})
+		It("should delete nodes without pods that haven't joined after the grace period", func() {
+			ExpectCreated(ctx, env.Client, provisioner)
+			ExpectCreatedWithStatus(ctx, env.Client, n)
+
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+			n = ExpectNodeExists(ctx, env.Client, n.Name)
+			Expect(n.DeletionTimestamp.IsZero()).To(BeTrue())
+
+			injectabletime.Now = func() time.Time { return time.Now().Add(node.CancellationGracePeriod) }
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+			n = ExpectNodeExists(ctx, env.Client, n.Name)
+			Expect(n.DeletionTimestamp.IsZero()).To(BeFalse())
+		})
+		It("should delete nodes whose pods were deleted before they joined", func() {
+			pod := test.Pod(test.PodOptions{NodeName: n.Name})
+			ExpectCreated(ctx, env.Client, provisioner, pod)
+			ExpectCreatedWithStatus(ctx, env.Client, n)
+			// The workload scaled down, but the pod was bound to the node, so it stays terminating
+			Expect(env.Client.Delete(ctx, pod)).To(Succeed())
+
+			injectabletime.Now = func() time.Time { return time.Now().Add(node.CancellationGracePeriod) }
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+			n = ExpectNodeExists(ctx, env.Client, n.Name)
+			Expect(n.DeletionTimestamp.IsZero()).To(BeFalse())
+		})
+		It("should not delete nodes that still have pods", func() {
+			ExpectCreated(ctx, env.Client, provisioner, test.Pod(test.PodOptions{NodeName: n.Name}))
+			ExpectCreatedWithStatus(ctx, env.Client, n)
+
+			injectabletime.Now = func() time.Time { return time.Now().Add(node.CancellationGracePeriod) }
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+			n = ExpectNodeExists(ctx, env.Client, n.Name)
+			Expect(n.DeletionTimestamp.IsZero()).To(BeTrue())
+		})
+		It("should not delete nodes that were not launched for pods", func() {
+			delete(n.Annotations, v1alpha5.CancelableAnnotationKey)
+			ExpectCreated(ctx, env.Client, provisioner)
+			ExpectCreatedWithStatus(ctx, env.Client, n)
+
+			injectabletime.Now = func() time.Time { return time.Now().Add(node.CancellationGracePeriod) }
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+			n = ExpectNodeExists(ctx, env.Client, n.Name)
+			Expect(n.DeletionTimestamp.IsZero()).To(BeTrue())
+		})
+		It("should not delete nodes that have joined", func() {
+			n.Status.Conditions[0].Status = v1.ConditionTrue
+			n.Status.Conditions[0].Reason = "KubeletReady"
+			ExpectCreated(ctx, env.Client, provisioner)
+			ExpectCreatedWithStatus(ctx, env.Client, n)
+
+			injectabletime.Now = func() time.Time { return time.Now().Add(node.CancellationGracePeriod) }
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+			n = ExpectNodeExists(ctx, env.Client, n.Name)
+			Expect(n.DeletionTimestamp.IsZero()).To(BeTrue())
+		})
 	})
 	Describe("Emptiness", func() {
 		It("should not TTL nodes that have ready status unknown", func() {
@@ -268,6 +456,19 @@ var _ = Describe("Controller", func() {
 			node = ExpectNodeExists(ctx, env.Client, node.Name)
 			Expect(node.Annotations).To(HaveKey(v1alpha5.EmptinessTimestampAnnotationKey))
 		})
+		It("should not add TTL to nodes of scheduled capacity", func() {
+			provisioner.Spec.TTLSecondsAfterEmpty = ptr.Int64(30)
+			node := test.Node(test.NodeOptions{
+				Labels:      map[string]string{v1alpha5.ProvisionerNameLabelKey: provisioner.Name},
+				Annotations: map[string]string{v1alpha5.ScheduledCapacityAnnotationKey: "test-scheduled-capacity"},
+			})
+			ExpectCreated(ctx, env.Client, provisioner)
+			ExpectCreatedWithStatus(ctx, env.Client, node)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(node))
+
+			node = ExpectNodeExists(ctx, env.Client, node.Name)
+			Expect(node.Annotations).ToNot(HaveKey(v1alpha5.EmptinessTimestampAnnotationKey))
+		})
 		It("should remove labels from non-empty nodes", func() {
 			provisioner.Spec.TTLSecondsAfterEmpty = ptr.Int64(30)
 			node := test.Node(test.NodeOptions{
@@ -303,6 +504,82 @@ var _ = Describe("Controller", func() {
 
 			node = ExpectNodeExists(ctx, env.Client, node.Name)
 			Expect(node.DeletionTimestamp.IsZero()).To(BeFalse())
+			Expect(node.Annotations).To(HaveKeyWithValue(v1alpha5.ReusableAnnotationKey, "true"))
+		})
+		It("should not delete empty nodes before their TTL", func() {
+			provisioner.Spec.TTLSecondsAfterEmpty = ptr.Int64(30)
+			node := test.Node(test.NodeOptions{
+				Finalizers: []string{v1alpha5.TerminationFinalizer},
+				Labels:     map[string]string{v1alpha5.ProvisionerNameLabelKey: provisioner.Name},
+				Annotations: map[string]string{
+					v1alpha5.EmptinessTimestampAnnotationKey: time.Now().Add(-10 * time.Second).Format(time.RFC3339),
+				},
+			})
+			ExpectCreated(ctx, env.Client, provisioner, node)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(node))
+
+			node = ExpectNodeExists(ctx, env.Client, node.Name)
+			Expect(node.DeletionTimestamp.IsZero()).To(BeTrue())
+			Expect(node.Annotations).To(HaveKey(v1alpha5.EmptinessTimestampAnnotationKey))
+		})
+		It("should consider nodes with only daemonset pods as empty", func() {
+			provisioner.Spec.TTLSecondsAfterEmpty = ptr.Int64(30)
+			node := test.Node(test.NodeOptions{
+				Labels: map[string]string{v1alpha5.ProvisionerNameLabelKey: provisioner.Name},
+			})
+			ExpectCreated(ctx, env.Client, provisioner)
+			ExpectCreatedWithStatus(ctx, env.Client, node)
+			ExpectCreatedWithStatus(ctx, env.Client, test.Pod(test.PodOptions{
+				Name:       strings.ToLower(randomdata.SillyName()),
+				Namespace:  provisioner.Namespace,
+				NodeName:   node.Name,
+				Conditions: []v1.PodCondition{{Type: v1.PodReady, Status: v1.ConditionTrue}},
+				OwnerReferences: []metav1.OwnerReference{{
+					APIVersion: "apps/v1",
+					Kind:       "DaemonSet",
+					Name:       strings.ToLower(randomdata.SillyName()),
+					UID:        "test-uid",
+				}},
+			}))
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(node))
+
+			node = ExpectNodeExists(ctx, env.Client, node.Name)
+			Expect(node.Annotations).To(HaveKey(v1alpha5.EmptinessTimestampAnnotationKey))
+		})
+	})
+	Context("Ownership", func() {
+		It("should add the provisioner as the owner of the node", func() {
+			n := test.Node(test.NodeOptions{
+				Labels: map[string]string{v1alpha5.ProvisionerNameLabelKey: provisioner.Name},
+			})
+			ExpectCreated(ctx, env.Client, provisioner)
+			ExpectCreatedWithStatus(ctx, env.Client, n)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+
+			n = ExpectNodeExists(ctx, env.Client, n.Name)
+			Expect(n.OwnerReferences).To(ConsistOf(provisioner.OwnerReference()))
+		})
+		It("should replace the owner reference of a provisioner that no longer exists", func() {
+			n := test.Node(test.NodeOptions{
+				Labels: map[string]string{v1alpha5.ProvisionerNameLabelKey: provisioner.Name},
+			})
+			n.OwnerReferences = []metav1.OwnerReference{{APIVersion: v1alpha5.SchemeGroupVersion.String(), Kind: "Provisioner", Name: provisioner.Name, UID: "test-uid"}}
+			ExpectCreated(ctx, env.Client, provisioner)
+			ExpectCreatedWithStatus(ctx, env.Client, n)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(n))
+
+			n = ExpectNodeExists(ctx, env.Client, n.Name)
+			Expect(n.OwnerReferences).To(ConsistOf(provisioner.OwnerReference()))
+		})
+		It("should not add the owner reference of a provisioner that is being deleted", func() {
+			n := test.Node(test.NodeOptions{
+				Labels: map[string]string{v1alpha5.ProvisionerNameLabelKey: provisioner.Name},
+			})
+			provisioner.UID = "test-uid"
+			provisioner.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+			_, err := (&node.Ownership{}).Reconcile(ctx, provisioner, n)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(n.OwnerReferences).To(BeEmpty())
 		})
 	})
 	Context("Finalizer", func() {
@@ -21,18 +21,27 @@ import (
 
 	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
 	"github.com/aws/karpenter/pkg/cloudprovider"
+	podutil "github.com/aws/karpenter/pkg/utils/pod"
 	"github.com/aws/karpenter/pkg/utils/resources"
 	"go.uber.org/multierr"
 	v1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"knative.dev/pkg/logging"
 )
 
 type Packable struct {
 	cloudprovider.InstanceType
-	reserved v1.ResourceList
-	total    v1.ResourceList
+	// reserved and total are tracked in milli-units so that pods can be
+	// packed without allocating resource quantities
+	reserved map[v1.ResourceName]int64
+	total    map[v1.ResourceName]int64
+	// hourlyPrice is the lowest price of the instance type's offerings that
+	// satisfy the constraints, or zero if their prices are unknown
+	hourlyPrice float64
+	// hostPorts are the ports bound to the host by packed pods. Pods with
+	// conflicting host ports are rejected by the kubelet, so they cannot
+	// share a node.
+	hostPorts []v1.ContainerPort
 }
 
 type Result struct {
@@ -43,6 +52,13 @@ type Result struct {
 // PackablesFor creates viable packables for the provided constraints, excluding
 // those that can't fit resources or violate constraints.
 func PackablesFor(ctx context.Context, instanceTypes []cloudprovider.InstanceType, constraints *v1alpha5.Constraints, pods []*v1.Pod, daemons []*v1.Pod) []*Packable {
+	// Compute the requirements and requested resources once, rather than for
+	// each instance type
+	requirements := requirementsFor(constraints, cloudprovider.LabelKeys(instanceTypes))
+	extendedResources := sets.NewString()
+	for _, pod := range pods {
+		extendedResources = extendedResources.Union(extendedResourceNames(pod))
+	}
 	packables := []*Packable{}
 	for _, instanceType := range instanceTypes {
 		packable := PackableFor(instanceType)
@@ -51,43 +67,57 @@ func PackablesFor(ctx context.Context, instanceTypes []cloudprovider.InstanceTyp
 		// removing instance types that obviously lack resources, such
 		// as GPUs, for the workload being presented).
 		if err := multierr.Combine(
-			packable.validateZones(constraints),
-			packable.validateInstanceType(constraints),
-			packable.validateArchitecture(constraints),
-			packable.validateOperatingSystems(constraints),
-			packable.validateCapacityTypes(constraints),
-			packable.validateAWSPodENI(pods),
-			packable.validateGPUs(pods),
+			packable.validateOfferings(requirements),
+			packable.validateInstanceType(requirements),
+			packable.validateArchitecture(requirements),
+			packable.validateOperatingSystems(requirements),
+			packable.validateLabels(requirements),
+			packable.validateExtendedResources(extendedResources),
 		); err != nil {
 			continue
 		}
+		if offering, ok := instanceType.Offerings().Compatible(requirements.zones, requirements.capacityTypes).Cheapest(); ok {
+			packable.hourlyPrice = offering.Price
+		}
+		// The kubelet will not run more pods than its configured maximum
+		if maxPods := constraints.KubeletConfiguration.MaxPods; maxPods != nil && packable.total[v1.ResourcePods] > int64(*maxPods)*1000 {
+			packable.total[v1.ResourcePods] = int64(*maxPods) * 1000
+		}
 		// Calculate Kubelet Overhead
-		if ok := packable.reserve(instanceType.Overhead()); !ok {
+		if ok := packable.reserve(Overhead(instanceType, constraints.KubeletConfiguration)); !ok {
 			logging.FromContext(ctx).Debugf("Excluding instance type %s because there are not enough resources for kubelet and system overhead", packable.Name())
 			continue
 		}
 		// Calculate Daemonset Overhead
-		if len(packable.Pack(daemons).unpacked) > 0 {
+		if len(packable.Pack(DaemonsFor(instanceType, constraints, daemons)).unpacked) > 0 {
 			logging.FromContext(ctx).Debugf("Excluding instance type %s because there are not enough resources for daemons", packable.Name())
 			continue
 		}
 		packables = append(packables, packable)
 	}
-	// Sort in ascending order so that the packer can short circuit bin-packing for larger instance types
-	sort.Slice(packables, func(i, j int) bool {
+	// Sort in ascending order so that the packer can short circuit bin-packing
+	// for larger instance types. Instance types are first ordered by name so
+	// that the order doesn't depend on the order returned by the cloud provider.
+	sort.Slice(packables, func(i, j int) bool { return packables[i].Name() < packables[j].Name() })
+	sort.SliceStable(packables, func(i, j int) bool {
 		// Check GPU equality assuming GPU classes are mutually exclusive
 		if packables[i].AMDGPUs().Equal(*packables[j].AMDGPUs()) ||
 			packables[i].NvidiaGPUs().Equal(*packables[j].NvidiaGPUs()) ||
-			packables[i].AWSNeurons().Equal(*packables[j].AWSNeurons()) {
+			packables[i].AWSNeurons().Equal(*packables[j].AWSNeurons()) ||
+			packables[i].HabanaGaudis().Equal(*packables[j].HabanaGaudis()) {
 			if packables[i].CPU().Equal(*packables[j].CPU()) {
-				// check for memory
+				// check for memory, then ephemeral storage
+				if packables[i].Memory().Equal(*packables[j].Memory()) {
+					return packables[i].EphemeralStorage().Cmp(*packables[j].EphemeralStorage()) == -1
+				}
 				return packables[i].Memory().Cmp(*packables[j].Memory()) == -1
 			}
 			return packables[i].CPU().Cmp(*packables[j].CPU()) == -1
 		}
 		return packables[i].AMDGPUs().Cmp(*packables[j].AMDGPUs()) == -1 ||
 			packables[i].NvidiaGPUs().Cmp(*packables[j].NvidiaGPUs()) == -1 ||
-			packables[i].AWSNeurons().Cmp(*packables[j].AWSNeurons()) == -1
+			packables[i].AWSNeurons().Cmp(*packables[j].AWSNeurons()) == -1 ||
+			packables[i].HabanaGaudis().Cmp(*packables[j].HabanaGaudis()) == -1
 	})
 	return packables
 }
@@ -95,16 +125,75 @@ func PackablesFor(ctx context.Context, instanceTypes []cloudprovider.InstanceTyp
 func PackableFor(i cloudprovider.InstanceType) *Packable {
 	return &Packable{
 		InstanceType: i,
-		total: v1.ResourceList{
-			v1.ResourceCPU:      *i.CPU(),
-			v1.ResourceMemory:   *i.Memory(),
-			resources.NvidiaGPU: *i.NvidiaGPUs(),
-			resources.AMDGPU:    *i.AMDGPUs(),
-			resources.AWSNeuron: *i.AWSNeurons(),
-			resources.AWSPodENI: *i.AWSPodENI(),
-			v1.ResourcePods:     *i.Pods(),
-		},
+		reserved:     map[v1.ResourceName]int64{},
+		total: milliValues(resources.Merge(i.ExtendedResources(), v1.ResourceList{
+			v1.ResourceCPU:              *i.CPU(),
+			v1.ResourceMemory:           *i.Memory(),
+			v1.ResourceEphemeralStorage: *i.EphemeralStorage(),
+			resources.NvidiaGPU:         *i.NvidiaGPUs(),
+			resources.AMDGPU:            *i.AMDGPUs(),
+			resources.AWSNeuron:         *i.AWSNeurons(),
+			resources.HabanaGaudi:       *i.HabanaGaudis(),
+			resources.AWSPodENI:         *i.AWSPodENI(),
+			v1.ResourcePods:             *i.Pods(),
+		})),
+	}
+}
+
+// Overhead returns the resources reserved on a node of the instance type for
+// the kubelet, system daemons, and hard eviction thresholds. Resources that the
+// kubelet configuration reserves replace the instance type's default for that
+// component only, e.g. setting kubeReserved cpu keeps the default
+// systemReserved cpu.
+func Overhead(instanceType cloudprovider.InstanceType, kubeletConfiguration v1alpha5.KubeletConfiguration) v1.ResourceList {
+	capacity := v1.ResourceList{v1.ResourceCPU: *instanceType.CPU(), v1.ResourceMemory: *instanceType.Memory(), v1.ResourceEphemeralStorage: *instanceType.EphemeralStorage()}
+	evictionThresholds := v1.ResourceList{}
+	for signal, threshold := range kubeletConfiguration.EvictionHard {
+		resourceName, ok := v1alpha5.EvictionSignalResources[signal]
+		if !ok {
+			continue
+		}
+		if quantity, err := v1alpha5.EvictionThreshold(threshold, capacity[resourceName]); err == nil {
+			evictionThresholds[resourceName] = quantity
+		}
+	}
+	defaults := instanceType.Overhead()
+	return resources.Merge(
+		override(defaults.KubeReserved, kubeletConfiguration.KubeReserved, capacity),
+		override(defaults.SystemReserved, kubeletConfiguration.SystemReserved, capacity),
+		override(defaults.EvictionThreshold, evictionThresholds, capacity),
+	)
+}
+
+// override replaces the default quantities of the resources that are
+// configured. Only resources that are tracked by the packer may be reserved.
+func override(defaults v1.ResourceList, configured v1.ResourceList, capacity v1.ResourceList) v1.ResourceList {
+	result := defaults.DeepCopy()
+	if result == nil {
+		result = v1.ResourceList{}
+	}
+	for resourceName, quantity := range configured {
+		if _, ok := capacity[resourceName]; ok {
+			result[resourceName] = quantity
+		}
+	}
+	return result
+}
+
+// DaemonsFor returns the daemons that will schedule to a node of the instance
+// type launched with the constraints. The node's labels are narrowed to the
+// instance type's name, architecture, operating systems, zones, and capacity
+// types, so daemons that select a different node shape are not included.
+func DaemonsFor(instanceType cloudprovider.InstanceType, constraints *v1alpha5.Constraints, daemons []*v1.Pod) []*v1.Pod {
+	shape := constraints.DeepCopy()
+	shape.Requirements = shape.Requirements.With(cloudprovider.Requirements([]cloudprovider.InstanceType{instanceType}))
+	result := []*v1.Pod{}
+	for _, daemon := range daemons {
+		if err := shape.ValidatePod(daemon); err == nil {
+			result = append(result, daemon)
+		}
 	}
+	return result
 }
 
 // Pack attempts to pack the pods, keeping track of previously packed
@@ -112,150 +201,261 @@ func PackableFor(i cloudprovider.InstanceType) *Packable {
 // resources on the packable, will be left unpacked.
 func (p *Packable) Pack(pods []*v1.Pod) *Result {
 	result := &Result{}
-	for i, pod := range pods {
-		if ok := p.reservePod(pod); ok {
-			result.packed = append(result.packed, pod)
+	sets := podSetsFor(pods)
+	for i, count := range p.packSets(sets) {
+		result.packed = append(result.packed, sets[i].pods[:count]...)
+		result.unpacked = append(result.unpacked, sets[i].pods[count:]...)
+	}
+	return result
+}
+
+// packSets reserves as many pods of each set as fit, in order, and returns the
+// number of pods reserved from each set. Packing stops once the smallest pod
+// no longer fits, and if the largest pod doesn't fit, no pods are packed.
+func (p *Packable) packSets(sets []*podSet) []int {
+	counts := make([]int, len(sets))
+	packed := 0
+	for i, set := range sets {
+		counts[i] = p.reserveSet(set, len(set.pods))
+		packed += counts[i]
+		if counts[i] == len(set.pods) {
 			continue
 		}
-		if p.fits(pods[len(pods)-1]) {
-			result.unpacked = append(result.unpacked, pods[i:]...)
-			return result
-		}
 		// if largest pod can't be packed, set it aside
-		if len(result.packed) == 0 {
-			result.unpacked = append(result.unpacked, pods...)
-			return result
+		if packed == 0 || p.full(sets[len(sets)-1]) {
+			return counts
 		}
-		result.unpacked = append(result.unpacked, pod)
 	}
-	return result
+	return counts
 }
 
 func (p *Packable) DeepCopy() *Packable {
+	reserved := make(map[v1.ResourceName]int64, len(p.reserved))
+	for resourceName, quantity := range p.reserved {
+		reserved[resourceName] = quantity
+	}
 	return &Packable{
 		InstanceType: p.InstanceType,
-		reserved:     p.reserved.DeepCopy(),
-		total:        p.total.DeepCopy(),
+		reserved:     reserved,
+		// total isn't modified once the packable is created, so it's shared
+		total:       p.total,
+		hostPorts:   append([]v1.ContainerPort{}, p.hostPorts...),
+		hourlyPrice: p.hourlyPrice,
 	}
 }
 
-// fits checks if adding the pod would overflow the total resources
-// available. It also ensures that instance types that could not
-// possibly satisfy the pod at all (for example if the pod needs
-// NvidiaGPUs and the instance type doesn't have any) will be
-// eliminated from consideration.
-func (p *Packable) fits(pod *v1.Pod) bool {
-	minResourceList := resources.RequestsForPods(pod)
-	for resourceName, totalQuantity := range p.total {
-		reservedQuantity := p.reserved[resourceName].DeepCopy()
-		reservedQuantity.Add(minResourceList[resourceName])
-		if !totalQuantity.IsZero() && reservedQuantity.Cmp(totalQuantity) >= 0 {
+// full checks if adding a pod of the set would fill any of the resources
+// available. It also ensures that instance types that could not possibly
+// satisfy the pod at all (for example if the pod needs NvidiaGPUs and the
+// instance type doesn't have any) will be eliminated from consideration.
+func (p *Packable) full(set *podSet) bool {
+	for resourceName, total := range p.total {
+		if total != 0 && p.reserved[resourceName]+set.requests[resourceName] >= total {
 			return true
 		}
 	}
 	return false
 }
 
+// reserve reserves the resources if all of them fit
 func (p *Packable) reserve(requests v1.ResourceList) bool {
-	candidate := resources.Merge(p.reserved, requests)
-	// If any candidate resource exceeds total, fail to reserve
-	for resourceName, quantity := range candidate {
-		if quantity.Cmp(p.total[resourceName]) > 0 {
+	for resourceName, quantity := range requests {
+		if p.reserved[resourceName]+quantity.MilliValue() > p.total[resourceName] {
 			return false
 		}
 	}
-	p.reserved = candidate
+	for resourceName, quantity := range requests {
+		p.reserved[resourceName] += quantity.MilliValue()
+	}
 	return true
 }
 
-func (p *Packable) reservePod(pod *v1.Pod) bool {
-	requests := resources.RequestsForPods(pod)
-	requests[v1.ResourcePods] = *resource.NewQuantity(1, resource.BinarySI)
-	return p.reserve(requests)
+// reserveSet reserves up to max pods of the set, and returns the number of
+// pods reserved.
+func (p *Packable) reserveSet(set *podSet, max int) int {
+	count := p.capacityFor(set, max)
+	if count == 0 {
+		return 0
+	}
+	for resourceName, quantity := range set.requests {
+		p.reserved[resourceName] += quantity * int64(count)
+	}
+	p.reserved[v1.ResourcePods] += 1000 * int64(count)
+	p.hostPorts = append(p.hostPorts, set.hostPorts...)
+	return count
 }
 
-func (p *Packable) validateInstanceType(constraints *v1alpha5.Constraints) error {
-	if !constraints.Requirements.InstanceTypes().Has(p.Name()) {
-		return fmt.Errorf("instance type %s not in %v", p.Name(), constraints.Requirements.InstanceTypes().List())
+// capacityFor returns the number of pods of the set, up to max, that fit in
+// the packable's remaining resources
+func (p *Packable) capacityFor(set *podSet, max int) int {
+	if p.hostPortsConflict(set.hostPorts) {
+		return 0
 	}
-	return nil
+	count := int64(max)
+	for resourceName, quantity := range set.requests {
+		if quantity <= 0 {
+			continue
+		}
+		if fit := (p.total[resourceName] - p.reserved[resourceName]) / quantity; fit < count {
+			count = fit
+		}
+	}
+	if fit := (p.total[v1.ResourcePods] - p.reserved[v1.ResourcePods]) / 1000; fit < count {
+		count = fit
+	}
+	if count < 0 {
+		return 0
+	}
+	return int(count)
 }
 
-func (p *Packable) validateArchitecture(constraints *v1alpha5.Constraints) error {
-	if !constraints.Requirements.Architectures().Has(p.Architecture()) {
-		return fmt.Errorf("architecture %s is not in %v", p.Architecture(), constraints.Requirements.Architectures().List())
+// remainingWith returns the fraction of the packable's CPU and memory that
+// would remain unreserved if a pod of the set were packed, or false if it
+// doesn't fit.
+func (p *Packable) remainingWith(set *podSet) (float64, bool) {
+	if p.capacityFor(set, 1) == 0 {
+		return 0, false
 	}
-	return nil
+	remaining := 0.0
+	for _, resourceName := range []v1.ResourceName{v1.ResourceCPU, v1.ResourceMemory} {
+		if total := p.total[resourceName]; total != 0 {
+			remaining += 1 - float64(p.reserved[resourceName]+set.requests[resourceName])/float64(total)
+		}
+	}
+	return remaining, true
 }
 
-func (p *Packable) validateOperatingSystems(constraints *v1alpha5.Constraints) error {
-	if constraints.Requirements.OperatingSystems().Intersection(p.OperatingSystems()).Len() == 0 {
-		return fmt.Errorf("operating systems %s not in %v", p.OperatingSystems(), constraints.Requirements.OperatingSystems().List())
+func (p *Packable) hostPortsConflict(hostPorts []v1.ContainerPort) bool {
+	for _, reserved := range p.hostPorts {
+		for _, hostPort := range hostPorts {
+			if podutil.HostPortsConflict(reserved, hostPort) {
+				return true
+			}
+		}
 	}
-	return nil
+	return false
 }
 
-func (p *Packable) validateZones(constraints *v1alpha5.Constraints) error {
-	zones := sets.String{}
-	for _, offering := range p.Offerings() {
-		zones.Insert(offering.Zone)
+// price returns the hourly price of the instance type, or if it's unpriced, its
+// estimated price at the rate of the priced instance types.
+func (p *Packable) price(rate float64) float64 {
+	if p.hourlyPrice > 0 {
+		return p.hourlyPrice
 	}
-	if constraints.Requirements.Zones().Intersection(zones).Len() == 0 {
-		return fmt.Errorf("zones %v are not in %v", zones, constraints.Requirements.Zones().List())
+	return float64(p.estimatedPrice()) * rate
+}
+
+// rateFor returns the average hourly price per unit of estimated price of the
+// priced packables, so that the estimates of unpriced packables are comparable
+// with their prices. If none are priced, estimates are compared as is.
+func rateFor(packables []*Packable) float64 {
+	rate, priced := 0.0, 0
+	for _, packable := range packables {
+		if estimate := packable.estimatedPrice(); packable.hourlyPrice > 0 && estimate > 0 {
+			rate += packable.hourlyPrice / float64(estimate)
+			priced++
+		}
 	}
-	return nil
+	if priced == 0 {
+		return 1
+	}
+	return rate / float64(priced)
 }
 
-func (p *Packable) validateCapacityTypes(constraints *v1alpha5.Constraints) error {
-	capacityTypes := sets.String{}
-	for _, offering := range p.Offerings() {
-		capacityTypes.Insert(offering.CapacityType)
+// estimatedPrice estimates the relative price of the instance type from its resources,
+// where a vCPU is 1024 units, a GiB of memory is 128 units and an accelerator
+// is 75 vCPUs. The weights roughly approximate on-demand prices in public
+// clouds, and are only meaningful when comparing instance types.
+func (p *Packable) estimatedPrice() int64 {
+	return p.CPU().MilliValue()*1024/1000 +
+		p.Memory().Value()*128/(1024*1024*1024) +
+		(p.NvidiaGPUs().Value()+p.AMDGPUs().Value()+p.AWSNeurons().Value()+p.HabanaGaudis().Value())*75*1024
+}
+
+// allowed are the values that the constraints' requirements allow, which are
+// computed once rather than for each instance type
+type allowed struct {
+	zones            sets.String
+	instanceTypes    sets.String
+	architectures    sets.String
+	operatingSystems sets.String
+	capacityTypes    sets.String
+	// labels are the allowed values of the instance types' labels, for keys
+	// that the requirements constrain
+	labels map[string]sets.String
+}
+
+func requirementsFor(constraints *v1alpha5.Constraints, labelKeys sets.String) *allowed {
+	labels := map[string]sets.String{}
+	for key := range labelKeys {
+		if values := constraints.Requirements.Requirement(key); values != nil {
+			labels[key] = values
+		}
+	}
+	return &allowed{
+		zones:            constraints.Requirements.Zones(),
+		instanceTypes:    constraints.Requirements.InstanceTypes(),
+		architectures:    constraints.Requirements.Architectures(),
+		operatingSystems: constraints.Requirements.OperatingSystems(),
+		capacityTypes:    constraints.Requirements.CapacityTypes(),
+		labels:           labels,
 	}
-	if constraints.Requirements.CapacityTypes().Intersection(capacityTypes).Len() == 0 {
-		return fmt.Errorf("capacity types %v are not in %v", capacityTypes, constraints.Requirements.CapacityTypes().List())
+}
+
+func (p *Packable) validateInstanceType(requirements *allowed) error {
+	if !requirements.instanceTypes.Has(p.Name()) {
+		return fmt.Errorf("instance type %s not in %v", p.Name(), requirements.instanceTypes.List())
 	}
 	return nil
 }
 
-func (p *Packable) validateGPUs(pods []*v1.Pod) error {
-	gpuResources := map[v1.ResourceName]*resource.Quantity{
-		resources.NvidiaGPU: p.InstanceType.NvidiaGPUs(),
-		resources.AMDGPU:    p.InstanceType.AMDGPUs(),
-		resources.AWSNeuron: p.InstanceType.AWSNeurons(),
+func (p *Packable) validateArchitecture(requirements *allowed) error {
+	if !requirements.architectures.Has(p.Architecture()) {
+		return fmt.Errorf("architecture %s is not in %v", p.Architecture(), requirements.architectures.List())
 	}
-	for resourceName, instanceTypeResourceQuantity := range gpuResources {
-		if p.requiresResource(pods, resourceName) && instanceTypeResourceQuantity.IsZero() {
-			return fmt.Errorf("%s is required", resourceName)
-		} else if !p.requiresResource(pods, resourceName) && !instanceTypeResourceQuantity.IsZero() {
-			return fmt.Errorf("%s is not required", resourceName)
-		}
+	return nil
+}
+
+func (p *Packable) validateOperatingSystems(requirements *allowed) error {
+	if requirements.operatingSystems.Intersection(p.OperatingSystems()).Len() == 0 {
+		return fmt.Errorf("operating systems %s not in %v", p.OperatingSystems(), requirements.operatingSystems.List())
 	}
 	return nil
 }
 
-func (p *Packable) requiresResource(pods []*v1.Pod, resource v1.ResourceName) bool {
-	for _, pod := range pods {
-		for _, container := range pod.Spec.Containers {
-			if _, ok := container.Resources.Requests[resource]; ok {
-				return true
-			}
-			if _, ok := container.Resources.Limits[resource]; ok {
-				return true
-			}
+// validateLabels ensures that the instance type's labels are allowed, treating
+// labels that it doesn't have as empty values.
+func (p *Packable) validateLabels(requirements *allowed) error {
+	for key, values := range requirements.labels {
+		if value := p.Labels()[key]; !values.Has(value) {
+			return fmt.Errorf("label %s=%s not in %v", key, value, values.List())
 		}
 	}
-	return false
+	return nil
 }
 
-func (p *Packable) validateAWSPodENI(pods []*v1.Pod) error {
-	for _, pod := range pods {
-		for _, container := range pod.Spec.Containers {
-			if _, ok := container.Resources.Requests[resources.AWSPodENI]; ok {
-				if p.InstanceType.AWSPodENI().IsZero() {
-					return fmt.Errorf("aws pod eni is required")
-				}
-				return nil
-			}
+// validateOfferings ensures that the instance type is offered in one of the
+// allowed zones with one of the allowed capacity types, together.
+func (p *Packable) validateOfferings(requirements *allowed) error {
+	if len(p.Offerings().Compatible(requirements.zones, requirements.capacityTypes)) == 0 {
+		return fmt.Errorf("no offerings in zones %v with capacity types %v", requirements.zones.List(), requirements.capacityTypes.List())
+	}
+	return nil
+}
+
+// validateExtendedResources ensures that the instance type advertises every
+// extended resource requested by the pods, e.g. nvidia.com/gpu or
+// vendor.com/foo. Instance types with accelerators that none of the pods
+// require are also excluded, since they are expensive.
+func (p *Packable) validateExtendedResources(requested sets.String) error {
+	for _, resourceName := range []v1.ResourceName{resources.NvidiaGPU, resources.AMDGPU, resources.AWSNeuron, resources.HabanaGaudi} {
+		if p.total[resourceName] != 0 && !requested.Has(string(resourceName)) {
+			return fmt.Errorf("%s is not required", resourceName)
+		}
+	}
+	for resourceName := range requested {
+		if p.total[v1.ResourceName(resourceName)] == 0 {
+			return fmt.Errorf("%s is required", resourceName)
 		}
 	}
 	return nil
@@ -17,18 +17,24 @@ package binpacking
 import (
 	"context"
 	"fmt"
+	"math"
 	"sort"
+	"strings"
 
 	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
 	"github.com/aws/karpenter/pkg/cloudprovider"
 	"github.com/aws/karpenter/pkg/metrics"
+	"github.com/aws/karpenter/pkg/tracing"
 	"github.com/aws/karpenter/pkg/utils/apiobject"
 	"github.com/aws/karpenter/pkg/utils/injection"
 	"github.com/aws/karpenter/pkg/utils/resources"
 	"github.com/mitchellh/hashstructure/v2"
 	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"knative.dev/pkg/logging"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
@@ -76,57 +82,174 @@ type Packing struct {
 	InstanceTypeOptions []cloudprovider.InstanceType
 }
 
-// Pack returns the node packings for the provided pods. It computes a set of viable
-// instance types for each packing of pods. InstanceType variety enables the cloud provider
-// to make better cost and availability decisions. The instance types returned are sorted by resources.
-// Pods provided are all schedulable in the same zone as tightly as possible.
-// It follows the First Fit Decreasing bin packing technique, reference-
-// https://en.wikipedia.org/wiki/Bin_packing_problem#First_Fit_Decreasing_(FFD)
-func (p *Packer) Pack(ctx context.Context, constraints *v1alpha5.Constraints, pods []*v1.Pod) ([]*Packing, error) {
+// Pack returns the node packings for the provided pods within the constraints,
+// using the instance types that the cloud provider offers the provisioner, the
+// provisioner's packing strategy, and the daemons running in the cluster.
+func (p *Packer) Pack(ctx context.Context, provisioner *v1alpha5.Provisioner, constraints *v1alpha5.Constraints, pods []*v1.Pod) (packings []*Packing, err error) {
 	defer metrics.Measure(packDuration.WithLabelValues(injection.GetNamespacedName(ctx).Name))()
+	ctx, span := tracing.Start(ctx, "binpacking.Pack", trace.WithAttributes(attribute.Int(tracing.PodsAttribute, len(pods))))
+	defer func() {
+		tracing.SetError(span, err)
+		span.End()
+	}()
 
 	// Get instance type options
-	instanceTypes, err := p.cloudProvider.GetInstanceTypes(ctx, constraints)
+	instanceTypes, err := p.cloudProvider.GetInstanceTypes(ctx, provisioner)
 	if err != nil {
 		return nil, fmt.Errorf("getting instance types, %w", err)
 	}
 	// Get daemons for overhead calculations
-	daemons, err := p.getDaemons(ctx, constraints)
+	daemons, err := p.GetDaemons(ctx, constraints)
 	if err != nil {
 		return nil, fmt.Errorf("getting schedulable daemon pods, %w", err)
 	}
+	return Pack(ctx, constraints, pods, instanceTypes, daemons, provisioner.Spec.Provisioning.PackingStrategy)
+}
+
+// Pack returns the node packings for the provided pods. It computes a set of viable
+// instance types for each packing of pods. InstanceType variety enables the cloud provider
+// to make better cost and availability decisions. The instance types returned are sorted by resources.
+// Pods provided are all schedulable in the same zone as tightly as possible.
+// Pods are packed in decreasing order of requests, and the packing strategy
+// determines which node each pod is placed on and the instance type of each
+// node, so a batch of pods may be packed onto a mix of instance types.
+// Pods that request different extended resources never share a node.
+func Pack(ctx context.Context, constraints *v1alpha5.Constraints, pods []*v1.Pod, instanceTypes []cloudprovider.InstanceType, daemons []*v1.Pod, strategy v1alpha5.PackingStrategy) ([]*Packing, error) {
 	// Sort pods in decreasing order by the amount of CPU requested, if
-	// CPU requested is equal compare memory requested.
-	sort.Slice(pods, func(a, b int) bool {
-		resourcePodA := resources.RequestsForPods(pods[a])
-		resourcePodB := resources.RequestsForPods(pods[b])
-		if resourcePodA.Cpu().Equal(*resourcePodB.Cpu()) {
-			// check for memory
-			return resourcePodA.Memory().Cmp(*resourcePodB.Memory()) == 1
-		}
-		return resourcePodA.Cpu().Cmp(*resourcePodB.Cpu()) == 1
+	// CPU requested is equal compare memory requested. Pods with equal
+	// requests are ordered by name so that identical batches are packed
+	// identically, regardless of the order in which their pods arrived, and
+	// pods with identical requests are adjacent so they can be packed as a set.
+	// Requests are computed once rather than on each comparison.
+	requests := make(map[*v1.Pod]map[v1.ResourceName]int64, len(pods))
+	signatures := make(map[*v1.Pod]string, len(pods))
+	for _, pod := range pods {
+		requests[pod] = requestsFor(pod)
+		signatures[pod] = signature(requests[pod])
+	}
+	sort.SliceStable(pods, func(a, b int) bool {
+		requestsA, requestsB := requests[pods[a]], requests[pods[b]]
+		if requestsA[v1.ResourceCPU] != requestsB[v1.ResourceCPU] {
+			return requestsA[v1.ResourceCPU] > requestsB[v1.ResourceCPU]
+		}
+		if requestsA[v1.ResourceMemory] != requestsB[v1.ResourceMemory] {
+			return requestsA[v1.ResourceMemory] > requestsB[v1.ResourceMemory]
+		}
+		if signatures[pods[a]] != signatures[pods[b]] {
+			return signatures[pods[a]] < signatures[pods[b]]
+		}
+		if pods[a].Namespace != pods[b].Namespace {
+			return pods[a].Namespace < pods[b].Namespace
+		}
+		return pods[a].Name < pods[b].Name
 	})
-	packs := map[uint64]*Packing{}
 	var packings []*Packing
-	var packing *Packing
-	remainingPods := pods
-	emptyPackables := PackablesFor(ctx, instanceTypes, constraints, pods, daemons)
-	for len(remainingPods) > 0 {
-		packables := []*Packable{}
-		for _, packable := range emptyPackables {
-			packables = append(packables, packable.DeepCopy())
-		}
-		if len(packables) == 0 {
-			logging.FromContext(ctx).Errorf("Failed to find instance type option(s) for %v", apiobject.PodNamespacedNames(remainingPods))
-			return packings, nil
-		}
-		packing, remainingPods = p.packWithLargestPod(remainingPods, packables)
-		// checked all instance types and found no packing option
-		if flattenedLen(packing.Pods...) == 0 {
-			logging.FromContext(ctx).Errorf("Failed to compute packing, pod(s) %s did not fit in instance type option(s) %v", apiobject.PodNamespacedNames(remainingPods), packableNames(packables))
-			remainingPods = remainingPods[1:]
+	for _, group := range groupByExtendedResources(pods) {
+		groupPackings, err := packGroup(ctx, constraints, group, instanceTypes, daemons, StrategyFor(strategy))
+		if err != nil {
+			return nil, err
+		}
+		packings = append(packings, groupPackings...)
+	}
+	for _, pack := range packings {
+		logging.FromContext(ctx).Infof("Computed packing of %d node(s) for %d pod(s) with instance type option(s) %s", pack.NodeQuantity, flattenedLen(pack.Pods...), instanceTypeNames(pack.InstanceTypeOptions))
+	}
+	return packings, nil
+}
+
+// Cheapest returns the index of the constraints under which the pod packs onto
+// the cheapest instance type. Instance types without an offering price are
+// priced by estimates from their resources. Ties go to the earliest
+// constraints, and ok is false if the pod doesn't pack under any of them.
+func Cheapest(ctx context.Context, alternatives []*v1alpha5.Constraints, pod *v1.Pod, instanceTypes []cloudprovider.InstanceType, daemons []*v1.Pod) (int, bool) {
+	fits := make([][]*Packable, len(alternatives))
+	all := []*Packable{}
+	for i, constraints := range alternatives {
+		for _, packable := range PackablesFor(ctx, instanceTypes, constraints, []*v1.Pod{pod}, daemons) {
+			if len(packable.Pack([]*v1.Pod{pod}).unpacked) == 0 {
+				fits[i] = append(fits[i], packable)
+			}
+		}
+		all = append(all, fits[i]...)
+	}
+	rate := rateFor(all)
+	costs := make([]float64, len(alternatives))
+	for i := range alternatives {
+		costs[i] = math.MaxFloat64
+		for _, packable := range fits[i] {
+			costs[i] = math.Min(costs[i], packable.price(rate))
+		}
+	}
+	cheapest := -1
+	for i, cost := range costs {
+		if cost < math.MaxFloat64 && (cheapest < 0 || cost < costs[cheapest]) {
+			cheapest = i
+		}
+	}
+	return cheapest, cheapest >= 0
+}
+
+// Unpackable returns the reason that the pod can't be packed onto any of the
+// instance types under the constraints, or nil if it can. Instance types that
+// aren't offered in the allowed zones with the allowed capacity types, and
+// those whose daemons bind the pod's host ports, are reported before those that
+// lack resources.
+func Unpackable(ctx context.Context, constraints *v1alpha5.Constraints, pod *v1.Pod, instanceTypes []cloudprovider.InstanceType, daemons []*v1.Pod) error {
+	requirements := requirementsFor(constraints, cloudprovider.LabelKeys(instanceTypes))
+	offered := false
+	for _, instanceType := range instanceTypes {
+		if PackableFor(instanceType).validateOfferings(requirements) == nil {
+			offered = true
+			break
+		}
+	}
+	if !offered {
+		return fmt.Errorf("no instance type option is offered in zones %v with capacity types %v", requirements.zones.List(), requirements.capacityTypes.List())
+	}
+	set := podSetsFor([]*v1.Pod{pod})[0]
+	conflicts := false
+	for _, packable := range PackablesFor(ctx, instanceTypes, constraints, []*v1.Pod{pod}, daemons) {
+		// Check resources separately from the host ports bound by daemons
+		withoutPorts := packable.DeepCopy()
+		withoutPorts.hostPorts = nil
+		if withoutPorts.capacityFor(set, 1) == 0 {
 			continue
 		}
+		if !packable.hostPortsConflict(set.hostPorts) {
+			return nil
+		}
+		conflicts = true
+	}
+	if conflicts {
+		return fmt.Errorf("host ports %v conflict with daemons on every instance type option with enough resources", hostPortNumbers(set.hostPorts))
+	}
+	return fmt.Errorf("no instance type option has enough resources for requests %s after overhead and daemons", resources.String(resources.RequestsForPods(pod)))
+}
+
+func hostPortNumbers(hostPorts []v1.ContainerPort) []int32 {
+	numbers := []int32{}
+	for _, hostPort := range hostPorts {
+		numbers = append(numbers, hostPort.HostPort)
+	}
+	return numbers
+}
+
+// packGroup packs pods that request the same extended resources, using only
+// the instance types that are viable for all of them.
+func packGroup(ctx context.Context, constraints *v1alpha5.Constraints, pods []*v1.Pod, instanceTypes []cloudprovider.InstanceType, daemons []*v1.Pod, strategy Strategy) ([]*Packing, error) {
+	packables := PackablesFor(ctx, instanceTypes, constraints, pods, daemons)
+	if len(packables) == 0 {
+		logging.FromContext(ctx).Errorf("Failed to find instance type option(s) for %v", apiobject.PodNamespacedNames(pods))
+		return nil, nil
+	}
+	nodes, unpacked := strategy.Pack(ctx, pods, packables)
+	if len(unpacked) > 0 {
+		logging.FromContext(ctx).Errorf("Failed to compute packing, pod(s) %s did not fit in instance type option(s) %v", apiobject.PodNamespacedNames(unpacked), packableNames(packables))
+	}
+	// Merge nodes with the same instance type options into a single packing
+	packs := map[uint64]*Packing{}
+	var packings []*Packing
+	for _, packing := range nodes {
 		key, err := hashstructure.Hash(packing, hashstructure.FormatV2, &hashstructure.HashOptions{SlicesAsSets: true})
 		if err != nil {
 			return nil, fmt.Errorf("hashing packings, %w", err)
@@ -139,13 +262,45 @@ func (p *Packer) Pack(ctx context.Context, constraints *v1alpha5.Constraints, po
 		packs[key] = packing
 		packings = append(packings, packing)
 	}
-	for _, pack := range packings {
-		logging.FromContext(ctx).Infof("Computed packing of %d node(s) for %d pod(s) with instance type option(s) %s", pack.NodeQuantity, flattenedLen(pack.Pods...), instanceTypeNames(pack.InstanceTypeOptions))
-	}
 	return packings, nil
 }
 
-func (p *Packer) getDaemons(ctx context.Context, constraints *v1alpha5.Constraints) ([]*v1.Pod, error) {
+// groupByExtendedResources partitions the pods by the extended resources they
+// request, e.g. nvidia.com/gpu or vendor.com/foo. Instance types are filtered
+// by the extended resources of the pods being packed, so packing each group
+// separately prevents pods that need a resource from excluding instance types
+// for pods that don't. The order of pods within each group is preserved.
+func groupByExtendedResources(pods []*v1.Pod) (groups [][]*v1.Pod) {
+	indices := map[string]int{}
+	for _, pod := range pods {
+		key := strings.Join(extendedResourceNames(pod).List(), ",")
+		index, ok := indices[key]
+		if !ok {
+			index = len(groups)
+			indices[key] = index
+			groups = append(groups, []*v1.Pod{})
+		}
+		groups[index] = append(groups[index], pod)
+	}
+	return groups
+}
+
+func extendedResourceNames(pod *v1.Pod) sets.String {
+	names := sets.NewString()
+	for _, container := range pod.Spec.Containers {
+		for _, resourceList := range []v1.ResourceList{container.Resources.Requests, container.Resources.Limits} {
+			for resourceName := range resourceList {
+				if resources.IsExtended(resourceName) {
+					names.Insert(string(resourceName))
+				}
+			}
+		}
+	}
+	return names
+}
+
+// GetDaemons returns the pods of daemonsets that will schedule to nodes with the given constraints
+func (p *Packer) GetDaemons(ctx context.Context, constraints *v1alpha5.Constraints) ([]*v1.Pod, error) {
 	daemonSetList := &appsv1.DaemonSetList{}
 	if err := p.kubeClient.List(ctx, daemonSetList); err != nil {
 		return nil, fmt.Errorf("listing daemonsets, %w", err)
@@ -161,37 +316,6 @@ func (p *Packer) getDaemons(ctx context.Context, constraints *v1alpha5.Constrain
 	return pods, nil
 }
 
-// packWithLargestPod will try to pack max number of pods with largest pod in
-// pods across all available node capacities. It returns Packing: max pod count
-// that fit; with their node capacities and list of leftover pods
-func (p *Packer) packWithLargestPod(unpackedPods []*v1.Pod, packables []*Packable) (*Packing, []*v1.Pod) {
-	bestPackedPods := []*v1.Pod{}
-	bestInstances := []cloudprovider.InstanceType{}
-	remainingPods := unpackedPods
-
-	// Try to pack the largest instance type to get an upper bound on efficiency
-	maxPodsPacked := len(packables[len(packables)-1].DeepCopy().Pack(unpackedPods).packed)
-	if maxPodsPacked == 0 {
-		return &Packing{Pods: [][]*v1.Pod{bestPackedPods}, InstanceTypeOptions: bestInstances}, remainingPods
-	}
-
-	for i, packable := range packables {
-		// check how many pods we can fit with the available capacity
-		if result := packable.Pack(unpackedPods); len(result.packed) == maxPodsPacked {
-			// Add all packable nodes that have more resources than this one
-			// Trim the bestInstances so that provisioning APIs in cloud providers are not overwhelmed by the number of instance type options
-			// For example, the AWS EC2 Fleet API only allows the request to be 145kb which equates to about 130 instance type options.
-			for j := i; j < len(packables) && j-i < MaxInstanceTypes; j++ {
-				bestInstances = append(bestInstances, packables[j])
-			}
-			bestPackedPods = result.packed
-			remainingPods = result.unpacked
-			break
-		}
-	}
-	return &Packing{Pods: [][]*v1.Pod{bestPackedPods}, InstanceTypeOptions: bestInstances, NodeQuantity: 1}, remainingPods
-}
-
 func instanceTypeNames(instanceTypes []cloudprovider.InstanceType) []string {
 	names := []string{}
 	for _, instanceType := range instanceTypes {
@@ -65,10 +65,73 @@ func BenchmarkPacker(b *testing.B) {
 		Pods: pods,
 	}
 
+	provisioner := &v1alpha5.Provisioner{Spec: v1alpha5.ProvisionerSpec{Constraints: *schedule.Constraints}}
+	provisioner.Spec.Provisioning.PackingStrategy = v1alpha5.PackingStrategyLowestCost
+
 	// Pack benchmark
 	for i := 0; i < b.N; i++ {
-		if packings, err := packer.Pack(ctx, schedule.Constraints, pods); err != nil || len(packings) == 0 {
+		if packings, err := packer.Pack(ctx, provisioner, schedule.Constraints, pods); err != nil || len(packings) == 0 {
 			b.FailNow()
 		}
 	}
 }
+
+// BenchmarkPackingStrategies compares the packing strategies on large sets of
+// heterogeneous pods. Besides time, it reports the number of nodes and the
+// total vCPUs of the smallest instance type option of each node.
+func BenchmarkPackingStrategies(b *testing.B) {
+	ctx := context.Background()
+	instanceTypes := fake.InstanceTypes(100)
+	instanceTypeNames := []string{}
+	for _, it := range instanceTypes {
+		instanceTypeNames = append(instanceTypeNames, it.Name())
+	}
+	constraints := &v1alpha5.Constraints{
+		Requirements: []v1.NodeSelectorRequirement{
+			{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-1", "test-zone-2", "test-zone-3"}},
+			{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpIn, Values: instanceTypeNames},
+			{Key: v1.LabelArchStable, Operator: v1.NodeSelectorOpIn, Values: []string{v1alpha5.ArchitectureAmd64, v1alpha5.ArchitectureArm64}},
+			{Key: v1alpha5.LabelCapacityType, Operator: v1.NodeSelectorOpIn, Values: []string{"spot", "on-demand"}},
+			{Key: v1.LabelOSStable, Operator: v1.NodeSelectorOpIn, Values: []string{"linux"}},
+		},
+	}
+	for _, count := range []int{250, 1_000, 5_000} {
+		pods := heterogeneousPods(count)
+		for _, strategy := range v1alpha5.PackingStrategies {
+			b.Run(fmt.Sprintf("%s/%d", strategy, count), func(b *testing.B) {
+				var nodes, cpus int64
+				for i := 0; i < b.N; i++ {
+					packings, err := binpacking.Pack(ctx, constraints, pods, instanceTypes, nil, strategy)
+					if err != nil || len(packings) == 0 {
+						b.FailNow()
+					}
+					nodes, cpus = 0, 0
+					for _, packing := range packings {
+						nodes += int64(packing.NodeQuantity)
+						cpus += int64(packing.NodeQuantity) * packing.InstanceTypeOptions[0].CPU().Value()
+					}
+				}
+				b.ReportMetric(float64(nodes), "nodes")
+				b.ReportMetric(float64(cpus), "vcpus")
+			})
+		}
+	}
+}
+
+// heterogeneousPods returns pods with a repeating mix of CPU and memory requests
+func heterogeneousPods(count int) []*v1.Pod {
+	cpus := []string{"100m", "250m", "500m", "1", "1500m", "2", "3"}
+	memories := []string{"128Mi", "256Mi", "512Mi", "1Gi", "2Gi", "3Gi"}
+	pods := []*v1.Pod{}
+	for i := 0; i < count; i++ {
+		pods = append(pods, test.Pod(test.PodOptions{
+			ResourceRequirements: v1.ResourceRequirements{
+				Requests: v1.ResourceList{
+					v1.ResourceCPU:    resource.MustParse(cpus[i%len(cpus)]),
+					v1.ResourceMemory: resource.MustParse(memories[i%len(memories)]),
+				},
+			},
+		}))
+	}
+	return pods
+}
@@ -0,0 +1,93 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package binpacking
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	podutil "github.com/aws/karpenter/pkg/utils/pod"
+	v1 "k8s.io/api/core/v1"
+)
+
+// podSet is a run of pods with identical resource requests, which can be packed
+// by count rather than one at a time. Pods that bind host ports are never
+// grouped, since they may conflict with each other.
+type podSet struct {
+	pods []*v1.Pod
+	// requests are the resources requested by each pod, in milli-units,
+	// excluding the pod itself
+	requests  map[v1.ResourceName]int64
+	hostPorts []v1.ContainerPort
+}
+
+// podSetsFor groups consecutive pods with identical requests, preserving the
+// order of the pods.
+func podSetsFor(pods []*v1.Pod) []*podSet {
+	sets := []*podSet{}
+	for _, pod := range pods {
+		requests := requestsFor(pod)
+		hostPorts := podutil.HostPorts(pod)
+		if last := len(sets) - 1; last >= 0 && len(hostPorts) == 0 && len(sets[last].hostPorts) == 0 && equal(sets[last].requests, requests) {
+			sets[last].pods = append(sets[last].pods, pod)
+			continue
+		}
+		sets = append(sets, &podSet{pods: []*v1.Pod{pod}, requests: requests, hostPorts: hostPorts})
+	}
+	return sets
+}
+
+// requestsFor returns the sum of the pod's container requests in milli-units
+func requestsFor(pod *v1.Pod) map[v1.ResourceName]int64 {
+	requests := map[v1.ResourceName]int64{}
+	for _, container := range pod.Spec.Containers {
+		for resourceName, quantity := range container.Resources.Requests {
+			requests[resourceName] += quantity.MilliValue()
+		}
+	}
+	return requests
+}
+
+// signature formats the requests as a comma separated list sorted by name, so
+// that pods with identical requests have the same signature
+func signature(requests map[v1.ResourceName]int64) string {
+	pairs := make([]string, 0, len(requests))
+	for resourceName, quantity := range requests {
+		pairs = append(pairs, fmt.Sprintf("%s=%d", resourceName, quantity))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func milliValues(resourceList v1.ResourceList) map[v1.ResourceName]int64 {
+	values := make(map[v1.ResourceName]int64, len(resourceList))
+	for resourceName, quantity := range resourceList {
+		values[resourceName] = quantity.MilliValue()
+	}
+	return values
+}
+
+func equal(a map[v1.ResourceName]int64, b map[v1.ResourceName]int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for resourceName, quantity := range a {
+		if other, ok := b[resourceName]; !ok || other != quantity {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,260 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package binpacking
+
+import (
+	"context"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	v1 "k8s.io/api/core/v1"
+	"knative.dev/pkg/logging"
+)
+
+// Strategy packs pods onto nodes of the packables' instance types. It returns a
+// packing for each node and the pods that do not fit on any instance type.
+// Pods are sorted in decreasing order of requests and packables in increasing
+// order of resources. Strategies must not modify the packables, and must
+// return the same packings for the same pods and packables. Each decision is
+// logged at debug level so that a packing can be explained.
+type Strategy interface {
+	Pack(ctx context.Context, pods []*v1.Pod, packables []*Packable) ([]*Packing, []*v1.Pod)
+}
+
+var strategies = map[v1alpha5.PackingStrategy]Strategy{
+	v1alpha5.PackingStrategyLowestCost:         LowestCost{},
+	v1alpha5.PackingStrategyFirstFitDecreasing: FirstFitDecreasing{},
+	v1alpha5.PackingStrategyBestFit:            BestFit{},
+	v1alpha5.PackingStrategyMinimizeNodes:      MinimizeNodes{},
+}
+
+// StrategyFor returns the implementation of the packing strategy, which
+// defaults to LowestCost.
+func StrategyFor(name v1alpha5.PackingStrategy) Strategy {
+	if strategy, ok := strategies[name]; ok {
+		return strategy
+	}
+	return LowestCost{}
+}
+
+// LowestCost fills one node at a time, choosing the instance type with the
+// lowest price per pod and preferring more pods if prices are equal. Instance
+// types without an offering price are priced by estimates from their resources.
+// Rather than sizing every node
+// to fit as many pods as the largest instance type, this allows a batch of
+// heterogeneous pods to be split across whichever mix of instance types costs
+// the least.
+type LowestCost struct{}
+
+func (LowestCost) Pack(ctx context.Context, pods []*v1.Pod, packables []*Packable) ([]*Packing, []*v1.Pod) {
+	return packEach(ctx, pods, packables, func(candidate option, best option) bool {
+		// Compare price per pod, i.e. price(i)/len(i) < price(best)/len(best), without division
+		cost := candidate.price * float64(best.packed)
+		bestCost := best.price * float64(candidate.packed)
+		return cost < bestCost || (cost == bestCost && candidate.packed > best.packed)
+	})
+}
+
+// MinimizeNodes fills one node at a time, choosing the instance type that fits
+// the most pods and preferring the lowest price if pods are equal.
+type MinimizeNodes struct{}
+
+func (MinimizeNodes) Pack(ctx context.Context, pods []*v1.Pod, packables []*Packable) ([]*Packing, []*v1.Pod) {
+	return packEach(ctx, pods, packables, func(candidate option, best option) bool {
+		return candidate.packed > best.packed || (candidate.packed == best.packed && candidate.price < best.price)
+	})
+}
+
+// FirstFitDecreasing places each pod on the first node that it fits, opening a
+// node if it fits none of them. Nodes are sized to the smallest instance type
+// that fits their pods once every pod is placed.
+// https://en.wikipedia.org/wiki/Bin_packing_problem#First_Fit_Decreasing_(FFD)
+type FirstFitDecreasing struct{}
+
+func (FirstFitDecreasing) Pack(ctx context.Context, pods []*v1.Pod, packables []*Packable) ([]*Packing, []*v1.Pod) {
+	return packOpen(ctx, pods, packables, func(nodes []*Packable, set *podSet) int {
+		for i, node := range nodes {
+			if _, ok := node.remainingWith(set); ok {
+				return i
+			}
+		}
+		return -1
+	})
+}
+
+// BestFit places each pod on the node with the least capacity remaining after
+// it is placed, opening a node if it fits none of them. Nodes are sized to the
+// smallest instance type that fits their pods once every pod is placed.
+type BestFit struct{}
+
+func (BestFit) Pack(ctx context.Context, pods []*v1.Pod, packables []*Packable) ([]*Packing, []*v1.Pod) {
+	return packOpen(ctx, pods, packables, func(nodes []*Packable, set *podSet) int {
+		best, bestRemaining := -1, 0.0
+		for i, node := range nodes {
+			if remaining, ok := node.remainingWith(set); ok && (best == -1 || remaining < bestRemaining) {
+				best, bestRemaining = i, remaining
+			}
+		}
+		return best
+	})
+}
+
+// option is the packing of pods onto a node of the packable at index, where
+// counts are the number of pods packed from each set
+type option struct {
+	index  int
+	price  float64
+	counts []int
+	packed int
+}
+
+// packEach fills one node at a time. The remaining pods are packed onto each of
+// the packables, and the node is sized to the best option. Ties are broken by
+// the order of the packables, so the smallest of equal options is chosen. Pods
+// with identical requests are packed as a set, so the cost of each node depends
+// on the number of distinct pods rather than the number of pods.
+func packEach(ctx context.Context, pods []*v1.Pod, packables []*Packable, better func(candidate option, best option) bool) (packings []*Packing, unpacked []*v1.Pod) {
+	rate := rateFor(packables)
+	sets := podSetsFor(pods)
+	for len(sets) > 0 {
+		// Short circuit if the largest pod doesn't fit any instance type
+		if largestFor(packables, sets[0]) < 0 {
+			pod := sets[0].pods[0]
+			logging.FromContext(ctx).Debugf("Node %d: pod %s/%s does not fit any instance type", len(packings), pod.Namespace, pod.Name)
+			unpacked = append(unpacked, pod)
+			_, sets = take(sets, []int{1})
+			continue
+		}
+		var best *option
+		for i, packable := range packables {
+			candidate := option{index: i, price: packable.price(rate), counts: packable.DeepCopy().packSets(sets)}
+			for _, count := range candidate.counts {
+				candidate.packed += count
+			}
+			if candidate.packed == 0 {
+				continue
+			}
+			logging.FromContext(ctx).Debugf("Node %d: instance type %s fits %d pod(s) at price %g", len(packings), packable.Name(), candidate.packed, candidate.price)
+			if best == nil || better(candidate, *best) {
+				best = &candidate
+			}
+		}
+		logging.FromContext(ctx).Debugf("Node %d: chose instance type %s for %d pod(s)", len(packings), packables[best.index].Name(), best.packed)
+		var packed []*v1.Pod
+		packed, sets = take(sets, best.counts)
+		packings = append(packings, &Packing{Pods: [][]*v1.Pod{packed}, InstanceTypeOptions: instanceTypeOptions(packables, best.index, packed), NodeQuantity: 1})
+	}
+	return packings, unpacked
+}
+
+// take removes the first count pods from each set, returning the removed pods
+// and the sets that have pods remaining
+func take(sets []*podSet, counts []int) (taken []*v1.Pod, remaining []*podSet) {
+	for i, set := range sets {
+		count := 0
+		if i < len(counts) {
+			count = counts[i]
+		}
+		taken = append(taken, set.pods[:count]...)
+		if count < len(set.pods) {
+			remaining = append(remaining, &podSet{pods: set.pods[count:], requests: set.requests, hostPorts: set.hostPorts})
+		}
+	}
+	return taken, remaining
+}
+
+// packOpen places each pod on the open node chosen by choose, or opens a node of
+// the largest instance type that fits the pod if none is chosen. Once every pod is placed, each
+// node is sized to the smallest instance type that fits its pods.
+func packOpen(ctx context.Context, pods []*v1.Pod, packables []*Packable, choose func(nodes []*Packable, set *podSet) int) (packings []*Packing, unpacked []*v1.Pod) {
+	nodes := []*Packable{}
+	nodePods := [][]*v1.Pod{}
+	for _, set := range podSetsFor(pods) {
+		for _, pod := range set.pods {
+			if i := choose(nodes, set); i >= 0 {
+				logging.FromContext(ctx).Debugf("Node %d: placed pod %s/%s", i, pod.Namespace, pod.Name)
+				nodes[i].reserveSet(set, 1)
+				nodePods[i] = append(nodePods[i], pod)
+				continue
+			}
+			largest := largestFor(packables, set)
+			if largest < 0 {
+				logging.FromContext(ctx).Debugf("Pod %s/%s does not fit any instance type", pod.Namespace, pod.Name)
+				unpacked = append(unpacked, pod)
+				continue
+			}
+			node := packables[largest].DeepCopy()
+			node.reserveSet(set, 1)
+			logging.FromContext(ctx).Debugf("Node %d: opened for pod %s/%s", len(nodes), pod.Namespace, pod.Name)
+			nodes = append(nodes, node)
+			nodePods = append(nodePods, []*v1.Pod{pod})
+		}
+	}
+	for i, pods := range nodePods {
+		index := smallest(packables, pods)
+		logging.FromContext(ctx).Debugf("Node %d: chose instance type %s for %d pod(s)", i, packables[index].Name(), len(pods))
+		packings = append(packings, &Packing{Pods: [][]*v1.Pod{pods}, InstanceTypeOptions: instanceTypeOptions(packables, index, pods), NodeQuantity: 1})
+	}
+	return packings, unpacked
+}
+
+// largestFor returns the index of the largest packable that fits a pod of the
+// set, or -1 if none do. Packables are ordered by CPU and memory before
+// ephemeral storage, so a smaller packable may fit a pod that a larger one
+// doesn't, e.g. if it has instance store volumes.
+func largestFor(packables []*Packable, set *podSet) int {
+	for i := len(packables) - 1; i >= 0; i-- {
+		if packables[i].capacityFor(set, 1) > 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// smallest returns the index of the smallest packable that fits all of the pods
+func smallest(packables []*Packable, pods []*v1.Pod) int {
+	sets := podSetsFor(pods)
+	for i, packable := range packables {
+		packed := 0
+		for _, count := range packable.DeepCopy().packSets(sets) {
+			packed += count
+		}
+		if packed == len(pods) {
+			return i
+		}
+	}
+	return len(packables) - 1
+}
+
+// instanceTypeOptions returns the packable at the index and the packables that
+// have more resources than it and also fit all of the pods. The instance types
+// are trimmed so that provisioning APIs in cloud providers are not overwhelmed
+// by the number of instance type options. For example, the AWS EC2 Fleet API
+// only allows the request to be 145kb which equates to about 130 instance type
+// options.
+func instanceTypeOptions(packables []*Packable, index int, pods []*v1.Pod) []cloudprovider.InstanceType {
+	instanceTypes := []cloudprovider.InstanceType{packables[index]}
+	sets := podSetsFor(pods)
+	for j := index + 1; j < len(packables) && len(instanceTypes) < MaxInstanceTypes; j++ {
+		packed := 0
+		for _, count := range packables[j].DeepCopy().packSets(sets) {
+			packed += count
+		}
+		if packed == len(pods) {
+			instanceTypes = append(instanceTypes, packables[j])
+		}
+	}
+	return instanceTypes
+}
@@ -15,13 +15,12 @@ package provisioning
 
 import (
 	"context"
+	"fmt"
 	"sort"
 	"sync"
 	"time"
 
-	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
-	"k8s.io/apimachinery/pkg/util/sets"
 	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"knative.dev/pkg/logging"
 	controllerruntime "sigs.k8s.io/controller-runtime"
@@ -33,8 +32,11 @@ import (
 	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
 	"github.com/aws/karpenter/pkg/cloudprovider"
 	"github.com/aws/karpenter/pkg/controllers/provisioning/scheduling"
+	"github.com/aws/karpenter/pkg/controllers/state"
+	"github.com/aws/karpenter/pkg/events"
 	"github.com/aws/karpenter/pkg/utils/functional"
 	"github.com/aws/karpenter/pkg/utils/injection"
+	karpenterlogging "github.com/aws/karpenter/pkg/utils/logging"
 	"github.com/mitchellh/hashstructure/v2"
 )
 
@@ -44,27 +46,33 @@ const controllerName = "provisioning"
 type Controller struct {
 	ctx           context.Context
 	provisioners  *sync.Map
+	verifications *sync.Map
 	scheduler     *scheduling.Scheduler
 	coreV1Client  corev1.CoreV1Interface
 	kubeClient    client.Client
 	cloudProvider cloudprovider.CloudProvider
+	cluster       *state.Cluster
+	recorder      events.Recorder
 }
 
 // NewController is a constructor
-func NewController(ctx context.Context, kubeClient client.Client, coreV1Client corev1.CoreV1Interface, cloudProvider cloudprovider.CloudProvider) *Controller {
+func NewController(ctx context.Context, kubeClient client.Client, coreV1Client corev1.CoreV1Interface, cloudProvider cloudprovider.CloudProvider, cluster *state.Cluster, recorder events.Recorder) *Controller {
 	return &Controller{
 		ctx:           ctx,
 		provisioners:  &sync.Map{},
+		verifications: &sync.Map{},
 		kubeClient:    kubeClient,
 		coreV1Client:  coreV1Client,
 		cloudProvider: cloudProvider,
+		cluster:       cluster,
+		recorder:      recorder,
 		scheduler:     scheduling.NewScheduler(kubeClient),
 	}
 }
 
 // Reconcile a control loop for the resource
 func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
-	ctx = logging.WithLogger(ctx, logging.FromContext(ctx).Named(controllerName).With("provisioner", req.Name))
+	ctx = karpenterlogging.WithReconcile(ctx, controllerName, "provisioner", req.Name)
 	ctx = injection.WithNamespacedName(ctx, req.NamespacedName)
 	ctx = injection.WithControllerName(ctx, controllerName)
 
@@ -72,17 +80,31 @@ func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reco
 	if err := c.kubeClient.Get(ctx, req.NamespacedName, provisioner); err != nil {
 		if errors.IsNotFound(err) {
 			c.Delete(req.Name)
+			c.verifications.Delete(req.Name)
 			return reconcile.Result{}, nil
 		}
 		return reconcile.Result{}, err
 	}
-	if err := c.Apply(ctx, provisioner); err != nil {
+	// Provisioners that are being deleted don't launch nodes while their
+	// existing nodes are finalized
+	if !provisioner.DeletionTimestamp.IsZero() {
+		c.Delete(req.Name)
+		c.verifications.Delete(req.Name)
+		return reconcile.Result{}, nil
+	}
+	// The status of the provisioner is reported by the status controller
+	if err := c.Apply(ctx, provisioner.DeepCopy()); err != nil {
 		return reconcile.Result{}, err
 	}
 	// Requeue in order to discover any changes from GetInstanceTypes.
 	return reconcile.Result{RequeueAfter: 5 * time.Minute}, nil
 }
 
+// Capabilities returns what the cloud provider is able to launch
+func (c *Controller) Capabilities() cloudprovider.Capabilities {
+	return c.cloudProvider.Capabilities()
+}
+
 // Delete stops and removes a provisioner. Enqueued pods will be provisioned.
 func (c *Controller) Delete(name string) {
 	if p, ok := c.provisioners.LoadAndDelete(name); ok {
@@ -90,26 +112,71 @@ func (c *Controller) Delete(name string) {
 	}
 }
 
-// Apply creates or updates the provisioner to the latest configuration
+// Apply creates or updates the provisioner to the latest configuration once
+// the cloud provider verifies it. The provisioner is stopped if it's
+// misconfigured, so that pods are not provisioned until the problem is fixed.
+// Other failures to verify it, e.g. because the cloud provider throttled the
+// requests, are transient, so the last configuration that was verified keeps
+// running, and verification is retried. The result is reported on the
+// provisioner's conditions by the status controller.
 func (c *Controller) Apply(ctx context.Context, provisioner *v1alpha5.Provisioner) error {
+	if err := c.cloudProvider.Verify(ctx, &provisioner.Spec.Constraints); err != nil {
+		c.verifications.Store(provisioner.Name, verification{err: err})
+		if _, misconfigured := cloudprovider.ConfigurationErrorReason(err); misconfigured {
+			c.Delete(provisioner.Name)
+		}
+		return fmt.Errorf("verifying provisioner, %w", err)
+	}
 	// Refresh global requirements using instance type availability
-	instanceTypes, err := c.cloudProvider.GetInstanceTypes(ctx, &provisioner.Spec.Constraints)
+	instanceTypes, err := c.cloudProvider.GetInstanceTypes(ctx, provisioner)
 	if err != nil {
 		return err
 	}
-	provisioner.Spec.Labels = functional.UnionStringMaps(provisioner.Spec.Labels, map[string]string{v1alpha5.ProvisionerNameLabelKey: provisioner.Name})
-	provisioner.Spec.Requirements = provisioner.Spec.Requirements.
-		With(requirements(instanceTypes)).
-		With(v1alpha5.LabelRequirements(provisioner.Spec.Labels)).
-		Consolidate()
+	Refresh(provisioner, instanceTypes)
 	// Update the provisioner if anything has changed
 	if c.hasChanged(ctx, provisioner) {
 		c.Delete(provisioner.Name)
-		c.provisioners.Store(provisioner.Name, NewProvisioner(ctx, provisioner, c.kubeClient, c.coreV1Client, c.cloudProvider))
+		c.provisioners.Store(provisioner.Name, NewProvisioner(c.provisionerContext(ctx, provisioner), provisioner, c.kubeClient, c.coreV1Client, c.cloudProvider, c.cluster, c.recorder))
 	}
+	c.verifications.Store(provisioner.Name, verification{})
 	return nil
 }
 
+// provisionerContext returns the reconcile's context with a logger that isn't
+// correlated with the reconcile, since the provisioner outlives it. Its logs
+// are correlated by the provisioner's name, and each batch's by its own ID.
+func (c *Controller) provisionerContext(ctx context.Context, provisioner *v1alpha5.Provisioner) context.Context {
+	logger := logging.FromContext(karpenterlogging.WithController(c.ctx, controllerName)).With("provisioner", provisioner.Name)
+	return logging.WithLogger(ctx, logger)
+}
+
+// verification is the result of the most recent attempt to verify a
+// provisioner
+type verification struct {
+	err error
+}
+
+// Verification returns the error of the most recent attempt to verify the
+// provisioner, or false if it hasn't been applied yet
+func (c *Controller) Verification(name string) (bool, error) {
+	v, ok := c.verifications.Load(name)
+	if !ok {
+		return false, nil
+	}
+	return true, v.(verification).err
+}
+
+// Refresh constrains the provisioner to its own label and the requirements of
+// the instance types that the cloud provider offers it, as the controller does
+// before provisioning for it
+func Refresh(provisioner *v1alpha5.Provisioner, instanceTypes []cloudprovider.InstanceType) {
+	provisioner.Spec.Labels = functional.UnionStringMaps(provisioner.Spec.Labels, map[string]string{v1alpha5.ProvisionerNameLabelKey: provisioner.Name})
+	provisioner.Spec.Requirements = provisioner.Spec.Requirements.
+		With(cloudprovider.Requirements(instanceTypes)).
+		With(v1alpha5.LabelRequirements(provisioner.Spec.Labels)).
+		Consolidate()
+}
+
 // Returns true if the new candidate provisioner is different than the provisioner in memory.
 func (c *Controller) hasChanged(ctx context.Context, provisionerNew *v1alpha5.Provisioner) bool {
 	oldProvisioner, ok := c.provisioners.Load(provisionerNew.Name)
@@ -127,6 +194,16 @@ func (c *Controller) hasChanged(ctx context.Context, provisionerNew *v1alpha5.Pr
 	return hashKeyOld != hashKeyNew
 }
 
+// Get returns the running provisioner of the name, or false if it isn't
+// running, e.g. because it failed verification
+func (c *Controller) Get(name string) (*Provisioner, bool) {
+	p, ok := c.provisioners.Load(name)
+	if !ok {
+		return nil, false
+	}
+	return p.(*Provisioner), true
+}
+
 // List active provisioners in order of priority
 func (c *Controller) List(ctx context.Context) []*Provisioner {
 	provisioners := []*Provisioner{}
@@ -138,29 +215,6 @@ func (c *Controller) List(ctx context.Context) []*Provisioner {
 	return provisioners
 }
 
-func requirements(instanceTypes []cloudprovider.InstanceType) (requirements v1alpha5.Requirements) {
-	supported := map[string]sets.String{
-		v1.LabelInstanceTypeStable: sets.NewString(),
-		v1.LabelTopologyZone:       sets.NewString(),
-		v1.LabelArchStable:         sets.NewString(),
-		v1.LabelOSStable:           sets.NewString(),
-		v1alpha5.LabelCapacityType: sets.NewString(),
-	}
-	for _, instanceType := range instanceTypes {
-		for _, offering := range instanceType.Offerings() {
-			supported[v1.LabelTopologyZone].Insert(offering.Zone)
-			supported[v1alpha5.LabelCapacityType].Insert(offering.CapacityType)
-		}
-		supported[v1.LabelInstanceTypeStable].Insert(instanceType.Name())
-		supported[v1.LabelArchStable].Insert(instanceType.Architecture())
-		supported[v1.LabelOSStable].Insert(instanceType.OperatingSystems().List()...)
-	}
-	for key, values := range supported {
-		requirements = append(requirements, v1.NodeSelectorRequirement{Key: key, Operator: v1.NodeSelectorOpIn, Values: values.UnsortedList()})
-	}
-	return requirements
-}
-
 // Register the controller to the manager
 func (c *Controller) Register(_ context.Context, m manager.Manager) error {
 	return controllerruntime.
@@ -16,47 +16,67 @@ package provisioning
 
 import (
 	"context"
+	goerrors "errors"
 	"fmt"
+	"strconv"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/audit"
 	"github.com/aws/karpenter/pkg/cloudprovider"
 	"github.com/aws/karpenter/pkg/controllers/provisioning/binpacking"
 	"github.com/aws/karpenter/pkg/controllers/provisioning/scheduling"
+	"github.com/aws/karpenter/pkg/controllers/state"
+	"github.com/aws/karpenter/pkg/events"
 	"github.com/aws/karpenter/pkg/metrics"
+	"github.com/aws/karpenter/pkg/tracing"
 	"github.com/aws/karpenter/pkg/utils/functional"
 	"github.com/aws/karpenter/pkg/utils/injection"
+	podutil "github.com/aws/karpenter/pkg/utils/pod"
+	"github.com/aws/karpenter/pkg/utils/resources"
 	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/rand"
 	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/util/workqueue"
 	"knative.dev/pkg/logging"
+	"knative.dev/pkg/system"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
 )
 
 var (
-	MaxBatchDuration = time.Second * 10
-	MinBatchDuration = time.Second * 1
 	// MaxPodsPerBatch limits the number of pods we process at one time to avoid using too much memory
 	MaxPodsPerBatch = 2_000
 )
 
-func NewProvisioner(ctx context.Context, provisioner *v1alpha5.Provisioner, kubeClient client.Client, coreV1Client corev1.CoreV1Interface, cloudProvider cloudprovider.CloudProvider) *Provisioner {
+// headroomAnnotationKey marks the placeholder pods that reserve a
+// provisioner's headroom, or the capacity that replaces a node. They are packed
+// like pending pods, but never bound.
+var headroomAnnotationKey = v1alpha5.Group + "/headroom"
+
+func NewProvisioner(ctx context.Context, provisioner *v1alpha5.Provisioner, kubeClient client.Client, coreV1Client corev1.CoreV1Interface, cloudProvider cloudprovider.CloudProvider, cluster *state.Cluster, recorder events.Recorder) *Provisioner {
 	running, stop := context.WithCancel(ctx)
 	p := &Provisioner{
 		Provisioner:   provisioner,
-		pods:          make(chan *v1.Pod),
+		current:       Batch{Phase: BatchPhaseWaiting},
+		pods:          make(chan enqueued),
 		wait:          make(chan struct{}),
 		running:       running,
 		Stop:          stop,
 		cloudProvider: cloudProvider,
 		kubeClient:    kubeClient,
 		coreV1Client:  coreV1Client,
+		cluster:       cluster,
+		recorder:      recorder,
 		scheduler:     scheduling.NewScheduler(kubeClient),
 		packer:        binpacking.NewPacker(kubeClient, cloudProvider),
 	}
@@ -76,7 +96,7 @@ func NewProvisioner(ctx context.Context, provisioner *v1alpha5.Provisioner, kube
 type Provisioner struct {
 	// State
 	*v1alpha5.Provisioner
-	pods    chan *v1.Pod
+	pods    chan enqueued
 	wait    chan struct{}
 	running context.Context
 	Stop    context.CancelFunc
@@ -84,16 +104,83 @@ type Provisioner struct {
 	cloudProvider cloudprovider.CloudProvider
 	kubeClient    client.Client
 	coreV1Client  corev1.CoreV1Interface
+	cluster       *state.Cluster
+	recorder      events.Recorder
 	scheduler     *scheduling.Scheduler
 	packer        *binpacking.Packer
+	// launchErr is the error of the most recent launch, if it failed
+	launchErr   error
+	launchErrMu sync.RWMutex
+	// current is the batch that the provisioner is gathering or provisioning
+	current   Batch
+	currentMu sync.RWMutex
+	// mu serializes launching capacity for batches and for replacements
+	mu sync.Mutex
+}
+
+// LaunchError returns the error of the provisioner's most recent attempt to
+// launch a node, or nil if it succeeded or there was none
+func (p *Provisioner) LaunchError() error {
+	p.launchErrMu.RLock()
+	defer p.launchErrMu.RUnlock()
+	return p.launchErr
+}
+
+func (p *Provisioner) setLaunchError(err error) {
+	p.launchErrMu.Lock()
+	defer p.launchErrMu.Unlock()
+	p.launchErr = err
+}
+
+// BatchPhase is the progress of a provisioner's batch
+type BatchPhase string
+
+const (
+	// BatchPhaseWaiting is waiting for the first pod of a batch
+	BatchPhaseWaiting BatchPhase = "Waiting"
+	// BatchPhaseBatching is gathering pods until the batch is idle or full
+	BatchPhaseBatching BatchPhase = "Batching"
+	// BatchPhaseProvisioning is launching capacity for and binding the pods
+	BatchPhaseProvisioning BatchPhase = "Provisioning"
+)
+
+// Batch describes the pods that a provisioner is batching or provisioning
+type Batch struct {
+	Phase BatchPhase `json:"phase"`
+	// Started is when the first pod of the batch was received
+	Started *metav1.Time `json:"started,omitempty"`
+	// Pods are the namespace/name of the batched pods
+	Pods []string `json:"pods,omitempty"`
+}
+
+// CurrentBatch returns a copy of the batch that the provisioner is gathering or
+// provisioning
+func (p *Provisioner) CurrentBatch() Batch {
+	p.currentMu.RLock()
+	defer p.currentMu.RUnlock()
+	return Batch{Phase: p.current.Phase, Started: p.current.Started.DeepCopy(), Pods: append([]string(nil), p.current.Pods...)}
+}
+
+func (p *Provisioner) updateBatch(update func(*Batch)) {
+	p.currentMu.Lock()
+	defer p.currentMu.Unlock()
+	update(&p.current)
+}
+
+// enqueued is a pod waiting to be batched, with the span that enqueued it so
+// that the span of its batch can be linked to it
+type enqueued struct {
+	pod  *v1.Pod
+	span trace.SpanContext
 }
 
 // Add a pod to the provisioner and block until it's processed. The caller
 // is responsible for verifying that the pod was scheduled correctly. In the
 // future, this may be expanded to include concepts such as retriable errors.
 func (p *Provisioner) Add(ctx context.Context, pod *v1.Pod) {
+	e := enqueued{pod: pod, span: trace.SpanContextFromContext(ctx)}
 	select {
-	case p.pods <- pod: // Block until pod is enqueued
+	case p.pods <- e: // Block until pod is enqueued
 		<-p.wait
 	case <-p.running.Done(): // Leave if closed
 	}
@@ -101,65 +188,377 @@ func (p *Provisioner) Add(ctx context.Context, pod *v1.Pod) {
 
 func (p *Provisioner) provision(ctx context.Context) (err error) {
 	// Wait for a batch of pods, release when done
-	pods := p.batch(ctx)
+	ctx, pods := p.batch(ctx)
+	span := trace.SpanFromContext(ctx)
 	defer func() {
+		tracing.SetError(span, err)
+		span.End()
+	}()
+	metrics.BatchSize.WithLabelValues(p.Name).Observe(float64(len(pods)))
+	// Correlate the logs of the batch, which outlives the provisioner's reconcile
+	ctx = logging.WithLogger(ctx, logging.FromContext(ctx).With("batchID", rand.String(8)))
+	batched := len(pods)
+	defer func() {
+		p.updateBatch(func(b *Batch) { *b = Batch{Phase: BatchPhaseWaiting} })
 		for i := 0; i < len(pods); i++ {
 			p.wait <- struct{}{}
 		}
 	}()
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	// Ensure pods are still provisionable
 	pods, err = p.filter(ctx, pods)
 	if err != nil {
 		return fmt.Errorf("filtering provisionable pods, %w", err)
 	}
+	provisionable := len(pods)
+	// Bind pods to capacity that has already been launched, but isn't ready
+	pods = p.bindInFlight(ctx, pods)
+	boundInFlight := provisionable - len(pods)
+	// Top up the headroom on the capacity launched for the remaining pods
+	if len(pods) > 0 {
+		headroom, err := p.missingHeadroom(ctx)
+		if err != nil {
+			return fmt.Errorf("computing missing headroom, %w", err)
+		}
+		pods = append(pods, headroom...)
+	}
 	// Separate pods by scheduling constraints
-	schedules, err := p.scheduler.Solve(ctx, p.Provisioner, pods)
+	instanceTypes, err := p.cloudProvider.GetInstanceTypes(ctx, p.Provisioner)
+	if err != nil {
+		return fmt.Errorf("getting instance types, %w", err)
+	}
+	schedules, err := p.scheduler.Solve(ctx, p.Provisioner, instanceTypes, pods)
 	if err != nil {
 		return fmt.Errorf("solving scheduling constraints, %w", err)
 	}
+	// Determine how many nodes may be launched
+	remaining, limited, err := p.remainingNodes(ctx)
+	if err != nil {
+		return fmt.Errorf("counting nodes, %w", err)
+	}
 	// Launch capacity and bind pods
+	pending, unschedulable, failures := 0, 0, 0
+	nodes := []string{}
 	for _, schedule := range schedules {
-		packings, err := p.packer.Pack(ctx, schedule.Constraints, schedule.Pods)
+		packings, err := p.packer.Pack(ctx, p.Provisioner, schedule.Constraints, schedule.Pods)
 		if err != nil {
 			return fmt.Errorf("binpacking pods, %w", err)
 		}
+		if pods := withoutHeadroom(unpacked(schedule.Pods, packings)); len(pods) > 0 {
+			daemons, err := p.packer.GetDaemons(ctx, schedule.Constraints)
+			if err != nil {
+				return fmt.Errorf("getting schedulable daemon pods, %w", err)
+			}
+			for _, pod := range pods {
+				unschedulable++
+				if err := binpacking.Unpackable(ctx, schedule.Constraints, pod, instanceTypes, daemons); err != nil {
+					p.recorder.ProvisioningFailed(pod, err)
+				}
+			}
+		}
 		for _, packing := range packings {
-			if err := p.launch(ctx, schedule.Constraints, packing); err != nil {
+			if limited {
+				pending += truncate(packing, remaining)
+				if packing.NodeQuantity == 0 {
+					continue
+				}
+			}
+			// Only the nodes that launched count against the limit, so that
+			// failed launches don't starve the remaining packings
+			launched, err := p.launch(ctx, schedule.Constraints, packing)
+			nodes = append(nodes, launched...)
+			remaining -= len(launched)
+			if err != nil {
+				failures++
 				logging.FromContext(ctx).Errorf("Could not launch node, %s", err.Error())
 				continue
 			}
 		}
 	}
+	if pending > 0 {
+		logging.FromContext(ctx).Errorf("Node limit of %d exceeded, %d pod(s) left pending", *p.Spec.Limits.MaxNodes, pending)
+		p.recorder.NodeLimitExceeded(p.Provisioner, *p.Spec.Limits.MaxNodes, pending)
+	}
+	// Summarize the decision in a single line of fields, so it can be parsed
+	logging.FromContext(ctx).Infow("Provisioned batch",
+		"batched", batched,
+		"provisionable", provisionable,
+		"boundInFlight", boundInFlight,
+		"schedules", len(schedules),
+		"launched", nodes,
+		"launchFailures", failures,
+		"unschedulable", unschedulable,
+		"limited", pending,
+	)
 	return nil
 }
 
-// Batch returns a slice of enqueued pods after idle or timeout
-func (p *Provisioner) batch(ctx context.Context) (pods []*v1.Pod) {
+// unpacked returns the pods that were not included in any of the packings
+func unpacked(pods []*v1.Pod, packings []*binpacking.Packing) []*v1.Pod {
+	packed := map[*v1.Pod]bool{}
+	for _, packing := range packings {
+		for _, nodePods := range packing.Pods {
+			for _, pod := range nodePods {
+				packed[pod] = true
+			}
+		}
+	}
+	remaining := []*v1.Pod{}
+	for _, pod := range pods {
+		if !packed[pod] {
+			remaining = append(remaining, pod)
+		}
+	}
+	return remaining
+}
+
+// remainingNodes returns the number of nodes that the provisioner may launch,
+// or false if the provisioner does not limit its nodes.
+func (p *Provisioner) remainingNodes(ctx context.Context) (int, bool, error) {
+	if p.Spec.Limits.MaxNodes == nil {
+		return 0, false, nil
+	}
+	nodes := &v1.NodeList{}
+	if err := p.kubeClient.List(ctx, nodes, client.MatchingLabels{v1alpha5.ProvisionerNameLabelKey: p.Name}); err != nil {
+		return 0, false, err
+	}
+	remaining, limited := p.Spec.Limits.RemainingNodes(len(nodes.Items))
+	return remaining, limited, nil
+}
+
+// truncate limits the packing to the given number of nodes, returning the
+// number of pods that were removed.
+func truncate(packing *binpacking.Packing, nodes int) (pending int) {
+	if packing.NodeQuantity <= nodes {
+		return 0
+	}
+	for _, pods := range packing.Pods[nodes:] {
+		pending += len(withoutHeadroom(pods))
+	}
+	packing.Pods = packing.Pods[:nodes]
+	packing.NodeQuantity = nodes
+	return pending
+}
+
+// headroom returns placeholder pods that request the provisioner's headroom
+func (p *Provisioner) headroom() []*v1.Pod {
+	return placeholders(p.Name+"-headroom", p.Spec.Provisioning.Headroom)
+}
+
+// missingHeadroom returns the headroom placeholders that don't fit in the spare
+// capacity of the provisioner's nodes, including those in flight, so that each
+// batch only replaces the headroom that pods have consumed rather than adding
+// to it.
+func (p *Provisioner) missingHeadroom(ctx context.Context) ([]*v1.Pod, error) {
+	headroom := p.headroom()
+	if len(headroom) == 0 {
+		return nil, nil
+	}
+	nodes, err := p.spareCapacity(ctx)
+	if err != nil {
+		return nil, err
+	}
+	missing := []*v1.Pod{}
+	for _, placeholder := range headroom {
+		fits := false
+		for _, n := range nodes {
+			if requested, ok := n.Fits(placeholder); ok {
+				n.Requested = requested
+				fits = true
+				break
+			}
+		}
+		if !fits {
+			missing = append(missing, placeholder)
+		}
+	}
+	return missing, nil
+}
+
+// spareCapacity returns the provisioner's nodes along with the resources that
+// are requested on them, which are reserved for in flight nodes and requested
+// by scheduled pods for registered ones. Nodes that are being deleted have no
+// spare capacity.
+func (p *Provisioner) spareCapacity(ctx context.Context) ([]*state.InFlightNode, error) {
+	spare := []*state.InFlightNode{}
+	for _, n := range p.cluster.InFlight() {
+		if n.Node.Labels[v1alpha5.ProvisionerNameLabelKey] == p.Name {
+			spare = append(spare, n)
+		}
+	}
+	nodes := &v1.NodeList{}
+	if err := p.kubeClient.List(ctx, nodes, client.MatchingLabels{v1alpha5.ProvisionerNameLabelKey: p.Name}); err != nil {
+		return nil, fmt.Errorf("listing nodes, %w", err)
+	}
+	for i := range nodes.Items {
+		n := &nodes.Items[i]
+		if !n.DeletionTimestamp.IsZero() || p.cluster.IsInFlight(n.Name) {
+			continue
+		}
+		pods := &v1.PodList{}
+		if err := p.kubeClient.List(ctx, pods, client.MatchingFields{"spec.nodeName": n.Name}); err != nil {
+			return nil, fmt.Errorf("listing pods for node, %w", err)
+		}
+		scheduled := []*v1.Pod{}
+		for j := range pods.Items {
+			if !podutil.IsTerminal(&pods.Items[j]) {
+				scheduled = append(scheduled, &pods.Items[j])
+			}
+		}
+		requested := resources.RequestsForPods(scheduled...)
+		requested[v1.ResourcePods] = *resource.NewQuantity(int64(len(scheduled)), resource.DecimalSI)
+		spare = append(spare, &state.InFlightNode{Node: n, Requested: requested})
+	}
+	return spare, nil
+}
+
+// placeholders returns placeholder pods that request the units of capacity.
+// They tolerate all taints so that they pack alongside any pending pod, and
+// limit what they request so that extended resources are packed like those of
+// pending pods.
+func placeholders(name string, capacity []v1alpha5.Headroom) []*v1.Pod {
+	pods := []*v1.Pod{}
+	for i, headroom := range capacity {
+		for j := int32(0); j < headroom.GetReplicas(); j++ {
+			pods = append(pods, &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        fmt.Sprintf("%s-%d-%d", name, i, j),
+					Annotations: map[string]string{headroomAnnotationKey: "true"},
+				},
+				Spec: v1.PodSpec{
+					Containers:  []v1.Container{{Name: "headroom", Resources: v1.ResourceRequirements{Requests: headroom.Requests, Limits: headroom.Requests}}},
+					Tolerations: []v1.Toleration{{Operator: v1.TolerationOpExists}},
+				},
+			})
+		}
+	}
+	return pods
+}
+
+// withoutHeadroom returns the pods that are not headroom placeholders
+func withoutHeadroom(pods []*v1.Pod) []*v1.Pod {
+	filtered := []*v1.Pod{}
+	for _, pod := range pods {
+		if _, ok := pod.Annotations[headroomAnnotationKey]; !ok {
+			filtered = append(filtered, pod)
+		}
+	}
+	return filtered
+}
+
+// bindInFlight binds pods to nodes that have been launched, but have not yet
+// registered, returning the pods that still require new capacity. Pods with
+// topology spread constraints are left to the scheduler, which is responsible
+// for computing their domains, and pods with host ports are left to the packer,
+// which is responsible for avoiding port conflicts.
+func (p *Provisioner) bindInFlight(ctx context.Context, pods []*v1.Pod) []*v1.Pod {
+	ctx, span := tracing.Start(ctx, "provisioning.BindInFlight")
+	defer span.End()
+	remaining := []*v1.Pod{}
+	namespaces := map[string]*v1.Namespace{}
+	for _, pod := range pods {
+		if len(pod.Spec.TopologySpreadConstraints) > 0 || len(podutil.HostPorts(pod)) > 0 {
+			remaining = append(remaining, pod)
+			continue
+		}
+		labels, err := p.templatedLabels(ctx, pod, namespaces)
+		if err != nil {
+			remaining = append(remaining, pod)
+			continue
+		}
+		name, ok := p.cluster.Reserve(p.Name, pod, labels)
+		if !ok {
+			remaining = append(remaining, pod)
+			continue
+		}
+		binding := &v1.Binding{TypeMeta: pod.TypeMeta, ObjectMeta: pod.ObjectMeta, Target: v1.ObjectReference{Name: name}}
+		if err := p.coreV1Client.Pods(pod.Namespace).Bind(ctx, binding, metav1.CreateOptions{}); err != nil {
+			logging.FromContext(ctx).Errorf("Failed to bind %s/%s to in flight node %s, %s", pod.Namespace, pod.Name, name, err.Error())
+			p.cluster.Release(name, pod)
+			continue
+		}
+		metrics.PodBindLatency.WithLabelValues(p.Name).Observe(time.Since(pendingSince(pod)).Seconds())
+		logging.FromContext(ctx).Infof("Bound pod %s/%s to in flight node %s", pod.Namespace, pod.Name, name)
+	}
+	return remaining
+}
+
+// templatedLabels returns the labels that the provisioner's label templates
+// render for the pod, so that it's only bound to in flight nodes that were
+// launched for pods that render the same labels. Templates that render empty
+// have empty values, since their labels are absent from the nodes.
+func (p *Provisioner) templatedLabels(ctx context.Context, pod *v1.Pod, namespaces map[string]*v1.Namespace) (map[string]string, error) {
+	if len(p.Spec.LabelTemplates) == 0 {
+		return nil, nil
+	}
+	rendered, err := p.scheduler.RenderLabelTemplates(ctx, &p.Spec.Constraints, pod, namespaces)
+	if err != nil {
+		return nil, err
+	}
+	labels := map[string]string{}
+	for key := range p.Spec.LabelTemplates {
+		labels[key] = rendered[key]
+	}
+	return labels, nil
+}
+
+// Batch returns a slice of enqueued pods after idle or timeout. The durations
+// are read from the settings for every batch, so that changes to them apply
+// to the next one. The returned context carries the span of provisioning the
+// batch, which starts with the first pod and must be ended by the caller.
+func (p *Provisioner) batch(ctx context.Context) (context.Context, []*v1.Pod) {
 	logging.FromContext(ctx).Infof("Waiting for unschedulable pods")
 	// Start the batching window after the first pod is received
-	pods = append(pods, <-p.pods)
-	timeout := time.NewTimer(MaxBatchDuration)
-	idle := time.NewTimer(MinBatchDuration)
+	first := <-p.pods
 	start := time.Now()
-	defer func() {
-		logging.FromContext(ctx).Infof("Batched %d pods in %s", len(pods), time.Since(start))
-	}()
-	for {
-		if len(pods) >= MaxPodsPerBatch {
-			return pods
-		}
-		select {
-		case pod := <-p.pods:
-			idle.Reset(MinBatchDuration)
-			pods = append(pods, pod)
-		case <-ctx.Done():
-			return pods
-		case <-timeout.C:
-			return pods
-		case <-idle.C:
-			return pods
+	pods := []*v1.Pod{}
+	links := []trace.Link{}
+	p.updateBatch(func(b *Batch) { *b = Batch{Phase: BatchPhaseBatching, Started: &metav1.Time{Time: start}} })
+	add := func(e enqueued) {
+		pods = append(pods, e.pod)
+		p.updateBatch(func(b *Batch) { b.Pods = append(b.Pods, client.ObjectKeyFromObject(e.pod).String()) })
+		if e.span.IsValid() {
+			links = append(links, trace.Link{SpanContext: e.span})
 		}
 	}
+	add(first)
+	settings := injection.GetSettings(ctx)
+	timeout := time.NewTimer(settings.BatchMaxDuration)
+	idle := time.NewTimer(settings.BatchIdleDuration)
+	func() {
+		for {
+			if len(pods) >= MaxPodsPerBatch {
+				return
+			}
+			select {
+			case e := <-p.pods:
+				idle.Reset(settings.BatchIdleDuration)
+				add(e)
+			case <-ctx.Done():
+				return
+			case <-timeout.C:
+				return
+			case <-idle.C:
+				return
+			}
+		}
+	}()
+	// Links can only be added to a span when it starts, so the spans of the
+	// batch start once it's complete, backdated to when its first pod arrived
+	ctx, _ = tracing.Start(ctx, "provisioning.Provision",
+		trace.WithTimestamp(start),
+		trace.WithLinks(links...),
+		trace.WithAttributes(attribute.String(tracing.ProvisionerAttribute, p.Name)),
+	)
+	_, batchSpan := tracing.Start(ctx, "provisioning.Batch",
+		trace.WithTimestamp(start),
+		trace.WithAttributes(attribute.Int(tracing.PodsAttribute, len(pods))),
+	)
+	batchSpan.End()
+	p.updateBatch(func(b *Batch) { b.Phase = BatchPhaseProvisioning })
+	logging.FromContext(ctx).Infof("Batched %d pods in %s", len(pods), time.Since(start))
+	return ctx, pods
 }
 
 // filter removes pods that have been assigned a node.
@@ -184,14 +583,40 @@ func (p *Provisioner) filter(ctx context.Context, pods []*v1.Pod) ([]*v1.Pod, er
 	return provisionable, nil
 }
 
-func (p *Provisioner) launch(ctx context.Context, constraints *v1alpha5.Constraints, packing *binpacking.Packing) error {
+// launch creates nodes for the packing and binds its pods to them, returning
+// the names of the nodes that were created
+func (p *Provisioner) launch(ctx context.Context, constraints *v1alpha5.Constraints, packing *binpacking.Packing) (launched []string, err error) {
+	ctx, span := tracing.Start(ctx, "provisioning.Launch", trace.WithAttributes(
+		attribute.Int(tracing.NodesAttribute, packing.NodeQuantity),
+		attribute.Int(tracing.InstanceTypesAttribute, len(packing.InstanceTypeOptions)),
+	))
+	defer func() {
+		tracing.SetError(span, err)
+		span.End()
+	}()
 	// Check limits
 	latest := &v1alpha5.Provisioner{}
 	if err := p.kubeClient.Get(ctx, client.ObjectKeyFromObject(p.Provisioner), latest); err != nil {
-		return fmt.Errorf("getting current resource usage, %w", err)
+		return nil, fmt.Errorf("getting current resource usage, %w", err)
 	}
 	if err := p.Spec.Limits.ExceededBy(latest.Status.Resources); err != nil {
-		return err
+		return nil, err
+	}
+	// Exceeding limits isn't a failure to launch, but anything after is
+	defer func() {
+		p.setLaunchError(err)
+		if err != nil {
+			metrics.LaunchFailures.WithLabelValues(p.Name, launchErrorClass(err)).Inc()
+			p.recorder.LaunchFailed(p.Provisioner, err)
+		}
+	}()
+	daemons, err := p.packer.GetDaemons(ctx, constraints)
+	if err != nil {
+		return nil, fmt.Errorf("getting schedulable daemon pods, %w", err)
+	}
+	hash, err := p.Spec.Hash()
+	if err != nil {
+		return nil, fmt.Errorf("hashing provisioner spec, %w", err)
 	}
 	// Create and Bind
 	pods := make(chan []*v1.Pod, len(packing.Pods))
@@ -199,15 +624,239 @@ func (p *Provisioner) launch(ctx context.Context, constraints *v1alpha5.Constrai
 	for _, ps := range packing.Pods {
 		pods <- ps
 	}
-	return p.cloudProvider.Create(ctx, constraints, packing.InstanceTypeOptions, packing.NodeQuantity, func(node *v1.Node) error {
+	callback := func(node *v1.Node) error {
 		node.Labels = functional.UnionStringMaps(node.Labels, constraints.Labels)
 		node.Spec.Taints = append(node.Spec.Taints, constraints.Taints...)
-		return p.bind(ctx, node, <-pods)
-	})
+		node.Spec.Taints = append(node.Spec.Taints, constraints.StartupTaints...)
+		// Record the spec that the node was launched from, so that changes to it can be detected
+		node.Annotations = functional.UnionStringMaps(node.Annotations, map[string]string{v1alpha5.ProvisionerHashAnnotationKey: hash})
+		// Nodes are garbage collected along with their provisioner
+		node.OwnerReferences = append(node.OwnerReferences, p.OwnerReference())
+		// The kubelet will not run more pods than its configured maximum
+		if maxPods := constraints.KubeletConfiguration.MaxPods; maxPods != nil && node.Status.Allocatable.Pods().Value() > int64(*maxPods) {
+			node.Status.Allocatable[v1.ResourcePods] = *resource.NewQuantity(int64(*maxPods), resource.DecimalSI)
+		}
+		// Record the price of the launched offering for cost reporting
+		if price := cloudprovider.NodePrice(node, packing.InstanceTypeOptions); price > 0 {
+			node.Annotations = functional.UnionStringMaps(node.Annotations, map[string]string{
+				v1alpha5.PriceAnnotationKey: strconv.FormatFloat(price, 'f', -1, 64),
+			})
+		}
+		bound := withoutHeadroom(<-pods)
+		// Nodes launched for pending pods are no longer needed if the pods are
+		// gone before the node joins, unlike nodes launched for headroom
+		if len(bound) > 0 {
+			node.Annotations = functional.UnionStringMaps(node.Annotations, map[string]string{v1alpha5.CancelableAnnotationKey: "true"})
+		}
+		if err := p.bind(ctx, node, bound); err != nil {
+			return err
+		}
+		launched = append(launched, node.Name)
+		p.recorder.NodeLaunched(node)
+		metrics.NodesLaunched.WithLabelValues(p.Name, node.Labels[v1.LabelInstanceTypeStable], node.Labels[v1alpha5.LabelCapacityType]).Inc()
+		// Track the node until it registers so that its remaining capacity can be used by subsequent batches
+		p.cluster.Launched(ctx, node, requested(node, constraints, packing.InstanceTypeOptions, daemons, bound), constraints.StartupTaints)
+		audit.Created(ctx, node, bound, constraints.Requirements, instanceTypeNames(packing.InstanceTypeOptions))
+		// Recording the decision is best effort, and must not fail the launch
+		if err := p.record(ctx, constraints, packing, node, bound); err != nil {
+			logging.FromContext(ctx).Errorf("Failed to record placement decision for node %s, %s", node.Name, err.Error())
+		}
+		return nil
+	}
+	// Pods are packed with others that request the same extended resources, so
+	// the cloud provider can configure every node in the launch for them
+	launchRequests := podRequests(packing.Pods)
+	// The cloud provider may launch fewer nodes than requested, so launch the
+	// remainder in the same pass rather than leaving their pods for the next batch
+	for remaining := packing.NodeQuantity; remaining > 0; remaining = len(pods) {
+		// Identify the launch attempt, so that the cloud provider returns the
+		// nodes it already launched if its request is retried, rather than
+		// launching more. Later attempts for the same pods, e.g. after their
+		// nodes failed to register, launch new nodes.
+		launchCtx := injection.WithLaunchID(injection.WithRequests(ctx, launchRequests), rand.String(16))
+		createCtx, createSpan := tracing.Start(launchCtx, "cloudprovider.Create", trace.WithAttributes(attribute.Int(tracing.NodesAttribute, remaining)))
+		err := p.cloudProvider.Create(createCtx, constraints, packing.InstanceTypeOptions, remaining, callback)
+		tracing.SetError(createSpan, err)
+		createSpan.End()
+		if err != nil {
+			return launched, err
+		}
+		if len(pods) == remaining {
+			return launched, fmt.Errorf("launched 0/%d node(s)", remaining)
+		}
+		if len(pods) > 0 {
+			logging.FromContext(ctx).Infof("Launched %d/%d node(s), launching the remainder", remaining-len(pods), remaining)
+		}
+	}
+	return launched, nil
+}
+
+// Replace launches capacity for the pods of the node, so that they can be
+// rescheduled without waiting for new nodes once it's drained. The pods aren't
+// bound to the new nodes, which are returned. No nodes are launched if the node
+// doesn't run pods that would be rescheduled.
+func (p *Provisioner) Replace(ctx context.Context, node *v1.Node) ([]string, error) {
+	pods := &v1.PodList{}
+	if err := p.kubeClient.List(ctx, pods, client.MatchingFields{"spec.nodeName": node.Name}); err != nil {
+		return nil, fmt.Errorf("listing pods, %w", err)
+	}
+	placeholders := []*v1.Pod{}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if podutil.IsTerminal(pod) || podutil.IsOwnedByDaemonSet(pod) || podutil.IsOwnedByNode(pod) {
+			continue
+		}
+		placeholder := pod.DeepCopy()
+		placeholder.Spec.NodeName = ""
+		placeholder.Annotations = functional.UnionStringMaps(placeholder.Annotations, map[string]string{headroomAnnotationKey: "true"})
+		placeholders = append(placeholders, placeholder)
+	}
+	// The node is deleted once it's drained, so it doesn't count towards the node limit
+	return p.launchPlaceholders(ctx, placeholders, 1)
+}
+
+// Reserve launches capacity in units that are shaped like pods, e.g. for
+// scheduled capacity, returning the new nodes. Pending pods are bound to the
+// capacity while it's in flight, and scheduled to it once it's ready, like the
+// provisioner's headroom.
+func (p *Provisioner) Reserve(ctx context.Context, name string, capacity []v1alpha5.Headroom) ([]string, error) {
+	return p.launchPlaceholders(ctx, placeholders(name, capacity), 0)
+}
+
+// launchPlaceholders launches nodes for placeholder pods, which are never
+// bound to them. Like pending pods, they're limited by the provisioner's node
+// limit, excluding the nodes that are released once the placeholders launch.
+func (p *Provisioner) launchPlaceholders(ctx context.Context, placeholders []*v1.Pod, released int) ([]string, error) {
+	if len(placeholders) == 0 {
+		return nil, nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	instanceTypes, err := p.cloudProvider.GetInstanceTypes(ctx, p.Provisioner)
+	if err != nil {
+		return nil, fmt.Errorf("getting instance types, %w", err)
+	}
+	schedules, err := p.scheduler.Solve(ctx, p.Provisioner, instanceTypes, placeholders)
+	if err != nil {
+		return nil, fmt.Errorf("solving scheduling constraints, %w", err)
+	}
+	remaining, limited, err := p.remainingNodes(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("counting nodes, %w", err)
+	}
+	remaining += released
+	exceeded := 0
+	launched := []string{}
+	for _, schedule := range schedules {
+		packings, err := p.packer.Pack(ctx, p.Provisioner, schedule.Constraints, schedule.Pods)
+		if err != nil {
+			return launched, fmt.Errorf("binpacking pods, %w", err)
+		}
+		if unpacked := unpacked(schedule.Pods, packings); len(unpacked) > 0 {
+			return launched, fmt.Errorf("no instance type option has enough resources for %d pod(s)", len(unpacked))
+		}
+		for _, packing := range packings {
+			if limited {
+				quantity := packing.NodeQuantity
+				truncate(packing, remaining)
+				exceeded += quantity - packing.NodeQuantity
+				if packing.NodeQuantity == 0 {
+					continue
+				}
+			}
+			names, err := p.launch(ctx, schedule.Constraints, packing)
+			launched = append(launched, names...)
+			remaining -= len(names)
+			if err != nil {
+				return launched, fmt.Errorf("launching replacement, %w", err)
+			}
+		}
+	}
+	if exceeded > 0 {
+		return launched, fmt.Errorf("node limit of %d exceeded, %d node(s) not launched", *p.Spec.Limits.MaxNodes, exceeded)
+	}
+	return launched, nil
+}
+
+// podRequests returns the total resources requested by the pods of a packing.
+func podRequests(pods [][]*v1.Pod) v1.ResourceList {
+	flattened := []*v1.Pod{}
+	for _, ps := range pods {
+		flattened = append(flattened, ps...)
+	}
+	return resources.RequestsForPods(flattened...)
+}
+
+// instanceTypeNames returns the names of the instance types, in order
+func instanceTypeNames(instanceTypes []cloudprovider.InstanceType) []string {
+	names := []string{}
+	for _, instanceType := range instanceTypes {
+		names = append(names, instanceType.Name())
+	}
+	return names
+}
+
+// record creates a PlacementDecision for the launched node, so that the pods,
+// constraints and instance types that it was launched for can be audited
+// after the fact. Decisions are not recorded if their time to live is zero.
+// Names of nodes may be reused within the time to live, e.g. EC2's private DNS
+// names, so decisions are named after the node with a generated suffix.
+func (p *Provisioner) record(ctx context.Context, constraints *v1alpha5.Constraints, packing *binpacking.Packing, node *v1.Node, pods []*v1.Pod) error {
+	if injection.GetOptions(ctx).PlacementDecisionTTL == 0 {
+		return nil
+	}
+	decision := &v1alpha5.PlacementDecision{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: node.Name + "-",
+			Namespace:    system.Namespace(),
+			Labels:       map[string]string{v1alpha5.ProvisionerNameLabelKey: p.Name},
+		},
+		Spec: v1alpha5.PlacementDecisionSpec{
+			Provisioner:     p.Name,
+			Node:            node.Name,
+			Requirements:    constraints.Requirements,
+			PackingStrategy: p.Spec.Provisioning.PackingStrategy,
+			InstanceType:    node.Labels[v1.LabelInstanceTypeStable],
+			Zone:            node.Labels[v1.LabelTopologyZone],
+			CapacityType:    node.Labels[v1alpha5.LabelCapacityType],
+		},
+	}
+	for _, pod := range pods {
+		decision.Spec.Pods = append(decision.Spec.Pods, client.ObjectKeyFromObject(pod).String())
+	}
+	decision.Spec.InstanceTypeOptions = instanceTypeNames(packing.InstanceTypeOptions)
+	if err := p.kubeClient.Create(ctx, decision); err != nil {
+		return fmt.Errorf("creating placement decision, %w", err)
+	}
+	return nil
+}
+
+// requested returns the resources reserved on a newly launched node by
+// overhead, the daemons that schedule to its instance type, and pods.
+func requested(node *v1.Node, constraints *v1alpha5.Constraints, instanceTypes []cloudprovider.InstanceType, daemons []*v1.Pod, pods []*v1.Pod) v1.ResourceList {
+	overhead := v1.ResourceList{}
+	for _, instanceType := range instanceTypes {
+		if instanceType.Name() == node.Labels[v1.LabelInstanceTypeStable] {
+			daemons = binpacking.DaemonsFor(instanceType, constraints, daemons)
+			overhead = binpacking.Overhead(instanceType, constraints.KubeletConfiguration)
+			break
+		}
+	}
+	requests := resources.Merge(resources.RequestsForPods(daemons...), resources.RequestsForPods(pods...), overhead)
+	requests[v1.ResourcePods] = *resource.NewQuantity(int64(len(daemons)+len(pods)), resource.DecimalSI)
+	return requests
 }
 
 func (p *Provisioner) bind(ctx context.Context, node *v1.Node, pods []*v1.Pod) (err error) {
 	defer metrics.Measure(bindTimeHistogram.WithLabelValues(injection.GetNamespacedName(ctx).Name))()
+	ctx, span := tracing.Start(ctx, "provisioning.Bind", trace.WithAttributes(
+		attribute.String(tracing.NodeAttribute, node.Name),
+		attribute.Int(tracing.PodsAttribute, len(pods)),
+	))
+	defer func() {
+		tracing.SetError(span, err)
+		span.End()
+	}()
 
 	// Add the Karpenter finalizer to the node to enable the termination workflow
 	node.Finalizers = append(node.Finalizers, v1alpha5.TerminationFinalizer)
@@ -229,10 +878,14 @@ func (p *Provisioner) bind(ctx context.Context, node *v1.Node, pods []*v1.Pod) (
 	// with the API server. In the common case, we create the node object
 	// ourselves to enforce the binding decision and enable images to be pulled
 	// before the node is fully Ready.
-	if _, err := p.coreV1Client.Nodes().Create(ctx, node, metav1.CreateOptions{}); err != nil {
+	created, err := p.coreV1Client.Nodes().Create(ctx, node, metav1.CreateOptions{})
+	if err != nil {
 		if !errors.IsAlreadyExists(err) {
 			return fmt.Errorf("creating node %s, %w", node.Name, err)
 		}
+	} else {
+		// Events about the node are attached to it by its UID
+		node.UID = created.UID
 	}
 	// Bind pods
 	var bound int64
@@ -243,12 +896,36 @@ func (p *Provisioner) bind(ctx context.Context, node *v1.Node, pods []*v1.Pod) (
 			logging.FromContext(ctx).Errorf("Failed to bind %s/%s to %s, %s", pod.Namespace, pod.Name, node.Name, err.Error())
 		} else {
 			atomic.AddInt64(&bound, 1)
+			metrics.PodBindLatency.WithLabelValues(p.Name).Observe(time.Since(pendingSince(pod)).Seconds())
 		}
 	})
 	logging.FromContext(ctx).Infof("Bound %d pod(s) to node %s", bound, node.Name)
 	return nil
 }
 
+// pendingSince returns when kube-scheduler failed to schedule the pod, or when
+// it was created if that isn't known
+func pendingSince(pod *v1.Pod) time.Time {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1.PodScheduled && condition.Reason == v1.PodReasonUnschedulable && !condition.LastTransitionTime.IsZero() {
+			return condition.LastTransitionTime.Time
+		}
+	}
+	return pod.CreationTimestamp.Time
+}
+
+// launchErrorClass classifies the error of a failed launch for metrics
+func launchErrorClass(err error) string {
+	switch {
+	case cloudprovider.IsInsufficientCapacityError(err):
+		return metrics.ErrorClassInsufficientCapacity
+	case goerrors.Is(err, context.DeadlineExceeded), goerrors.Is(err, context.Canceled):
+		return metrics.ErrorClassTimeout
+	default:
+		return metrics.ErrorClassUnknown
+	}
+}
+
 var bindTimeHistogram = prometheus.NewHistogramVec(
 	prometheus.HistogramOpts{
 		Namespace: metrics.Namespace,
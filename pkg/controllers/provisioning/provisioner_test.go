@@ -0,0 +1,92 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provisioning_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/cloudprovider/fake"
+	"github.com/aws/karpenter/pkg/controllers/provisioning/binpacking"
+	"github.com/aws/karpenter/pkg/controllers/provisioning/scheduling"
+	"github.com/aws/karpenter/pkg/test"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	testclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// BenchmarkProvisioning measures the time to solve and pack a burst of pending
+// pods, which determines how quickly capacity is launched for large scale ups.
+// Pods have a mix of requests and zonal node selectors, so they are separated
+// into several schedules of many identical pods.
+func BenchmarkProvisioning(b *testing.B) {
+	ctx := context.Background()
+	instanceTypes := fake.InstanceTypes(100)
+	kubeClient := testclient.NewClientBuilder().WithLists(&appsv1.DaemonSetList{}).Build()
+	scheduler := scheduling.NewScheduler(kubeClient)
+	packer := binpacking.NewPacker(kubeClient, &fake.CloudProvider{InstanceTypes: instanceTypes})
+	provisioner := &v1alpha5.Provisioner{ObjectMeta: metav1.ObjectMeta{Name: v1alpha5.DefaultProvisioner.Name}}
+	provisioner.SetDefaults(ctx)
+	provisioner.Spec.Requirements = provisioner.Spec.Requirements.
+		With(cloudprovider.Requirements(instanceTypes)).
+		Consolidate()
+	for _, strategy := range v1alpha5.PackingStrategies {
+		for _, count := range []int{1_000, 5_000} {
+			provisioner.Spec.Provisioning.PackingStrategy = strategy
+			b.Run(fmt.Sprintf("%s/%d", strategy, count), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					// Solving injects topology into the pods, so they're created for each iteration
+					b.StopTimer()
+					pods := pendingPods(count)
+					b.StartTimer()
+					schedules, err := scheduler.Solve(ctx, provisioner, instanceTypes, pods)
+					if err != nil {
+						b.Fatal(err)
+					}
+					for _, schedule := range schedules {
+						if _, err := packer.Pack(ctx, provisioner, schedule.Constraints, schedule.Pods); err != nil {
+							b.Fatal(err)
+						}
+					}
+				}
+			})
+		}
+	}
+}
+
+// pendingPods returns pods with a repeating mix of requests and zones
+func pendingPods(count int) []*v1.Pod {
+	cpus := []string{"100m", "250m", "500m", "1", "2"}
+	memories := []string{"128Mi", "512Mi", "1Gi", "2Gi"}
+	zones := []string{"test-zone-1", "test-zone-2", "test-zone-3"}
+	pods := []*v1.Pod{}
+	for i := 0; i < count; i++ {
+		pods = append(pods, test.UnschedulablePod(test.PodOptions{
+			NodeSelector: map[string]string{v1.LabelTopologyZone: zones[i%len(zones)]},
+			ResourceRequirements: v1.ResourceRequirements{
+				Requests: v1.ResourceList{
+					v1.ResourceCPU:    resource.MustParse(cpus[i%len(cpus)]),
+					v1.ResourceMemory: resource.MustParse(memories[i%len(memories)]),
+				},
+			},
+		}))
+	}
+	return pods
+}
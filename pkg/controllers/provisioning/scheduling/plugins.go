@@ -0,0 +1,159 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduling
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/utils/resources"
+	"go.uber.org/multierr"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// ConstraintPlugin is a placement rule that decides whether a pod may run on
+// nodes launched from a node template, i.e. a provisioner's constraints.
+// Plugins are evaluated when selecting a provisioner for a pod and when solving
+// schedules, so a pod is only provisioned for if every plugin accepts it.
+type ConstraintPlugin interface {
+	// Filter returns an error explaining why the pod cannot run on nodes
+	// launched from the node template, or nil if it can.
+	Filter(pod *v1.Pod, nodeTemplate *v1alpha5.Constraints) error
+}
+
+var (
+	pluginsMu sync.RWMutex
+	plugins   = []ConstraintPlugin{
+		&Taints{},
+		&NodeAffinity{},
+		&TopologySpread{},
+		&Resources{},
+		&OperatingSystem{},
+	}
+)
+
+// RegisterConstraintPlugin adds a plugin that is evaluated after the built in
+// plugins. It is intended to be called during initialization, e.g. by forks
+// that add custom placement rules.
+func RegisterConstraintPlugin(plugin ConstraintPlugin) {
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	plugins = append(plugins, plugin)
+}
+
+// Filter returns the errors of all plugins that reject the pod for the node
+// template, or nil if the pod may run on nodes launched from it. The extra
+// plugins are evaluated after the registered ones, e.g. the capabilities of the
+// cloud provider that launches the nodes.
+func Filter(pod *v1.Pod, nodeTemplate *v1alpha5.Constraints, extra ...ConstraintPlugin) (errs error) {
+	pluginsMu.RLock()
+	defer pluginsMu.RUnlock()
+	for _, plugin := range append(append([]ConstraintPlugin{}, plugins...), extra...) {
+		errs = multierr.Append(errs, plugin.Filter(pod, nodeTemplate))
+	}
+	return errs
+}
+
+// Taints rejects pods that do not tolerate the node template's taints
+type Taints struct{}
+
+func (t *Taints) Filter(pod *v1.Pod, nodeTemplate *v1alpha5.Constraints) error {
+	return nodeTemplate.Taints.Tolerates(pod)
+}
+
+// NodeAffinity rejects pods whose node selector and required node affinity
+// cannot be satisfied by the node template's requirements
+type NodeAffinity struct{}
+
+func (n *NodeAffinity) Filter(pod *v1.Pod, nodeTemplate *v1alpha5.Constraints) error {
+	_, err := nodeTemplate.PodRequirements(pod)
+	return err
+}
+
+// TopologySpread rejects pods that spread across topologies that Karpenter
+// is unable to provision for
+type TopologySpread struct{}
+
+func (t *TopologySpread) Filter(pod *v1.Pod, _ *v1alpha5.Constraints) (errs error) {
+	supported := sets.NewString(v1.LabelHostname, v1.LabelTopologyZone)
+	for _, constraint := range pod.Spec.TopologySpreadConstraints {
+		if !supported.Has(constraint.TopologyKey) {
+			errs = multierr.Append(errs, fmt.Errorf("unsupported topology key, %s not in %s", constraint.TopologyKey, supported))
+		}
+	}
+	return errs
+}
+
+// Resources rejects pods whose resource requests cannot be satisfied by any
+// single node, i.e. pods that request more than one class of accelerator
+type Resources struct{}
+
+func (r *Resources) Filter(pod *v1.Pod, _ *v1alpha5.Constraints) error {
+	accelerators := []string{}
+	for resourceName, quantity := range resources.GPULimitsFor(pod) {
+		if !quantity.IsZero() {
+			accelerators = append(accelerators, string(resourceName))
+		}
+	}
+	if len(accelerators) > 1 {
+		sort.Strings(accelerators)
+		return fmt.Errorf("requests multiple accelerator types, %v", accelerators)
+	}
+	return nil
+}
+
+// OperatingSystem rejects pods that don't require an operating system for node
+// templates that don't allow linux. Such pods are assumed to be built for
+// linux, so they don't run on e.g. a provisioner of Windows nodes.
+type OperatingSystem struct{}
+
+func (o *OperatingSystem) Filter(pod *v1.Pod, nodeTemplate *v1alpha5.Constraints) error {
+	if requiresOperatingSystem(pod) {
+		return nil
+	}
+	if allowed := nodeTemplate.Requirements.OperatingSystems(); allowed != nil && !allowed.Has(v1alpha5.OperatingSystemLinux) {
+		return fmt.Errorf("pods without a %s requirement run on %s, not in %v", v1.LabelOSStable, v1alpha5.OperatingSystemLinux, allowed.List())
+	}
+	return nil
+}
+
+// requiresOperatingSystem returns true if the pod's node selector or any of its
+// required node affinity terms constrain the operating system
+func requiresOperatingSystem(pod *v1.Pod) bool {
+	for _, requirements := range v1alpha5.PodRequirementAlternatives(pod) {
+		for _, key := range requirements.Keys() {
+			if key == v1.LabelOSStable {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Capabilities rejects pods that require anything that the cloud provider can't
+// launch, e.g. accelerators or capacity types that it doesn't support.
+// Capabilities differ between cloud providers, so rather than being registered,
+// the plugin is passed to Filter by those that know the cloud provider.
+type Capabilities struct {
+	cloudprovider.Capabilities
+}
+
+func (c *Capabilities) Filter(pod *v1.Pod, _ *v1alpha5.Constraints) error {
+	return c.ValidatePod(pod)
+}
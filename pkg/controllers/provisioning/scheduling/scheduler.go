@@ -17,14 +17,23 @@ package scheduling
 import (
 	"context"
 	"fmt"
+	"sort"
 
 	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/controllers/provisioning/binpacking"
 	"github.com/aws/karpenter/pkg/metrics"
+	"github.com/aws/karpenter/pkg/tracing"
+	"github.com/aws/karpenter/pkg/utils/functional"
 	"github.com/aws/karpenter/pkg/utils/injection"
 	"github.com/aws/karpenter/pkg/utils/resources"
 	"github.com/mitchellh/hashstructure/v2"
 	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"knative.dev/pkg/logging"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
@@ -63,8 +72,16 @@ func NewScheduler(kubeClient client.Client) *Scheduler {
 	}
 }
 
-func (s *Scheduler) Solve(ctx context.Context, provisioner *v1alpha5.Provisioner, pods []*v1.Pod) (schedules []*Schedule, err error) {
+// Solve separates the pods into schedules. Pods with several required node
+// affinity terms are scheduled by the term that is cheapest to launch on the
+// instance types, or by the broadest term if the instance types are unknown.
+func (s *Scheduler) Solve(ctx context.Context, provisioner *v1alpha5.Provisioner, instanceTypes []cloudprovider.InstanceType, pods []*v1.Pod) (schedules []*Schedule, err error) {
 	defer metrics.Measure(schedulingDuration.WithLabelValues(injection.GetNamespacedName(ctx).Name))()
+	ctx, span := tracing.Start(ctx, "scheduling.Solve", trace.WithAttributes(attribute.Int(tracing.PodsAttribute, len(pods))))
+	defer func() {
+		tracing.SetError(span, err)
+		span.End()
+	}()
 	constraints := provisioner.Spec.Constraints.DeepCopy()
 	// Inject temporarily adds specific NodeSelectors to pods, which are then
 	// used by scheduling logic. This isn't strictly necessary, but is a useful
@@ -74,7 +91,7 @@ func (s *Scheduler) Solve(ctx context.Context, provisioner *v1alpha5.Provisioner
 		return nil, fmt.Errorf("injecting topology, %w", err)
 	}
 	// Separate pods into schedules of isomorphic scheduling constraints.
-	schedules, err = s.getSchedules(ctx, constraints, pods)
+	schedules, err = s.getSchedules(ctx, constraints, instanceTypes, pods)
 	if err != nil {
 		return nil, fmt.Errorf("getting schedules, %w", err)
 	}
@@ -84,15 +101,32 @@ func (s *Scheduler) Solve(ctx context.Context, provisioner *v1alpha5.Provisioner
 // getSchedules separates pods into a set of schedules. All pods in each group
 // contain isomorphic scheduling constraints and can be deployed together on the
 // same node, or multiple similar nodes if the pods exceed one node's capacity.
-func (s *Scheduler) getSchedules(ctx context.Context, constraints *v1alpha5.Constraints, pods []*v1.Pod) ([]*Schedule, error) {
+func (s *Scheduler) getSchedules(ctx context.Context, constraints *v1alpha5.Constraints, instanceTypes []cloudprovider.InstanceType, pods []*v1.Pod) ([]*Schedule, error) {
 	// schedule uniqueness is tracked by hash(Constraints)
 	schedules := map[uint64]*Schedule{}
+	namespaces := map[string]*v1.Namespace{}
 	for _, pod := range pods {
-		if err := constraints.ValidatePod(pod); err != nil {
+		if err := Filter(pod, constraints); err != nil {
 			logging.FromContext(ctx).Infof("Unable to schedule pod %s/%s, %s", pod.Name, pod.Namespace, err.Error())
 			continue
 		}
-		tightened := constraints.Tighten(pod)
+		tightened := tighten(ctx, constraints, instanceTypes, pod)
+		// Pods that don't require an operating system are built for linux
+		if !requiresOperatingSystem(pod) {
+			tightened.Requirements = tightened.Requirements.With(v1alpha5.Requirements{
+				{Key: v1.LabelOSStable, Operator: v1.NodeSelectorOpIn, Values: []string{v1alpha5.OperatingSystemLinux}},
+			})
+		}
+		// Labels rendered from templates are part of the constraints, so pods
+		// that render different labels are launched on different nodes
+		if len(constraints.LabelTemplates) > 0 {
+			labels, err := s.RenderLabelTemplates(ctx, constraints, pod, namespaces)
+			if err != nil {
+				logging.FromContext(ctx).Infof("Unable to schedule pod %s/%s, %s", pod.Name, pod.Namespace, err.Error())
+				continue
+			}
+			tightened.Labels = functional.UnionStringMaps(tightened.Labels, labels)
+		}
 
 		// schedulingConstraints applies the provisioner constraints
 		// and any inferred constraints such as GPU resource requests from the pods
@@ -117,9 +151,53 @@ func (s *Scheduler) getSchedules(ctx context.Context, constraints *v1alpha5.Cons
 		schedules[key].Pods = append(schedules[key].Pods, pod)
 	}
 
+	// Order schedules by key so that identical batches are solved identically
+	keys := []uint64{}
+	for key := range schedules {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
 	result := []*Schedule{}
-	for _, schedule := range schedules {
-		result = append(result, schedule)
+	for _, key := range keys {
+		result = append(result, schedules[key])
 	}
 	return result, nil
 }
+
+// tighten tightens the constraints by whichever of the pod's required node
+// affinity terms packs it onto the cheapest instance type, falling back to the
+// broadest term if the pod doesn't pack under any of them.
+func tighten(ctx context.Context, constraints *v1alpha5.Constraints, instanceTypes []cloudprovider.InstanceType, pod *v1.Pod) *v1alpha5.Constraints {
+	// Pods are validated before they are tightened
+	alternatives, _ := constraints.CompatiblePodRequirements(pod)
+	if len(alternatives) < 2 || len(instanceTypes) == 0 {
+		return constraints.Tighten(pod)
+	}
+	tightened := make([]*v1alpha5.Constraints, len(alternatives))
+	for i, podRequirements := range alternatives {
+		tightened[i] = constraints.TightenWith(pod, podRequirements)
+	}
+	if cheapest, ok := binpacking.Cheapest(ctx, tightened, pod, instanceTypes, nil); ok {
+		return tightened[cheapest]
+	}
+	return constraints.Tighten(pod)
+}
+
+// RenderLabelTemplates renders the label templates of the constraints for the
+// pod. Namespaces are cached in the map, e.g. for the duration of the solve.
+// Pods without a namespace, e.g. headroom placeholders, render with an empty
+// namespace.
+func (s *Scheduler) RenderLabelTemplates(ctx context.Context, constraints *v1alpha5.Constraints, pod *v1.Pod, namespaces map[string]*v1.Namespace) (map[string]string, error) {
+	namespace, ok := namespaces[pod.Namespace]
+	if !ok {
+		namespace = &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: pod.Namespace}}
+		// Without a cluster, e.g. when simulating, namespaces have no labels
+		if s.KubeClient != nil && pod.Namespace != "" {
+			if err := s.KubeClient.Get(ctx, types.NamespacedName{Name: pod.Namespace}, namespace); err != nil {
+				return nil, fmt.Errorf("getting namespace %s, %w", pod.Namespace, err)
+			}
+		}
+		namespaces[pod.Namespace] = namespace
+	}
+	return constraints.RenderLabelTemplates(pod, namespace)
+}
@@ -27,12 +27,15 @@ import (
 	"github.com/aws/karpenter/pkg/controllers/provisioning"
 	"github.com/aws/karpenter/pkg/controllers/provisioning/scheduling"
 	"github.com/aws/karpenter/pkg/controllers/selection"
+	"github.com/aws/karpenter/pkg/controllers/state"
+	"github.com/aws/karpenter/pkg/events"
 	"github.com/aws/karpenter/pkg/test"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
 
 	. "github.com/aws/karpenter/pkg/test/expectations"
 	. "github.com/onsi/ginkgo"
@@ -44,6 +47,7 @@ var ctx context.Context
 var provisioner *v1alpha5.Provisioner
 var provisioners *provisioning.Controller
 var selectionController *selection.Controller
+var cluster *state.Cluster
 var env *test.Environment
 
 func TestAPIs(t *testing.T) {
@@ -56,8 +60,9 @@ var _ = BeforeSuite(func() {
 	env = test.NewEnvironment(ctx, func(e *test.Environment) {
 		cloudProvider := &fake.CloudProvider{}
 		registry.RegisterOrDie(ctx, cloudProvider)
-		provisioners = provisioning.NewController(ctx, e.Client, corev1.NewForConfigOrDie(e.Config), cloudProvider)
-		selectionController = selection.NewController(e.Client, provisioners)
+		cluster = state.NewCluster()
+		provisioners = provisioning.NewController(ctx, e.Client, corev1.NewForConfigOrDie(e.Config), cloudProvider, cluster, events.NewRecorder(&record.FakeRecorder{}))
+		selectionController = selection.NewController(e.Client, provisioners, events.NewRecorder(&record.FakeRecorder{}))
 	})
 	Expect(env.Start()).To(Succeed(), "Failed to start environment")
 })
@@ -76,6 +81,7 @@ var _ = BeforeEach(func() {
 
 var _ = AfterEach(func() {
 	ExpectProvisioningCleanedUp(ctx, env.Client, provisioners)
+	ExpectClusterStateCleanedUp(cluster)
 })
 
 var _ = Describe("Combined Constraints", func() {
@@ -122,14 +128,15 @@ var _ = Describe("Combined Constraints", func() {
 			node := ExpectScheduled(ctx, env.Client, pod)
 			Expect(node.Labels).To(HaveKeyWithValue("test-key", "test-value"))
 		})
-		It("should not schedule pods with have conflicting preferences", func() {
+		It("should ignore conflicting preferences", func() {
 			provisioner.Spec.Labels = map[string]string{"test-key": "test-value"}
 			pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod(
 				test.PodOptions{NodePreferences: []v1.NodeSelectorRequirement{
 					{Key: "test-key", Operator: v1.NodeSelectorOpNotIn, Values: []string{"test-value"}},
 				}},
 			))[0]
-			ExpectNotScheduled(ctx, env.Client, pod)
+			node := ExpectScheduled(ctx, env.Client, pod)
+			Expect(node.Labels).To(HaveKeyWithValue("test-key", "test-value"))
 		})
 	})
 	Context("Well Known Labels", func() {
@@ -208,7 +215,7 @@ var _ = Describe("Combined Constraints", func() {
 			node := ExpectScheduled(ctx, env.Client, pod)
 			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, "test-zone-2"))
 		})
-		It("should not schedule incompatible preferences and requirements with Operator=In", func() {
+		It("should ignore incompatible preferences and schedule requirements with Operator=In", func() {
 			pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod(
 				test.PodOptions{
 					NodeRequirements: []v1.NodeSelectorRequirement{
@@ -217,7 +224,8 @@ var _ = Describe("Combined Constraints", func() {
 						{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"unknown"}}},
 				},
 			))[0]
-			ExpectNotScheduled(ctx, env.Client, pod)
+			node := ExpectScheduled(ctx, env.Client, pod)
+			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, BeElementOf("test-zone-1", "test-zone-2", "test-zone-3")))
 		})
 
 		It("should schedule compatible preferences and requirements with Operator=NotIn", func() {
@@ -232,7 +240,7 @@ var _ = Describe("Combined Constraints", func() {
 			node := ExpectScheduled(ctx, env.Client, pod)
 			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, "test-zone-2"))
 		})
-		It("should not schedule incompatible preferences and requirements with Operator=NotIn", func() {
+		It("should ignore incompatible preferences and schedule requirements with Operator=NotIn", func() {
 			pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod(
 				test.PodOptions{
 					NodeRequirements: []v1.NodeSelectorRequirement{
@@ -241,7 +249,8 @@ var _ = Describe("Combined Constraints", func() {
 						{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpNotIn, Values: []string{"test-zone-1", "test-zone-2", "test-zone-3"}}},
 				},
 			))[0]
-			ExpectNotScheduled(ctx, env.Client, pod)
+			node := ExpectScheduled(ctx, env.Client, pod)
+			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, BeElementOf("test-zone-1", "test-zone-2", "test-zone-3")))
 		})
 		It("should schedule compatible node selectors, preferences and requirements", func() {
 			pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod(
@@ -256,7 +265,7 @@ var _ = Describe("Combined Constraints", func() {
 			node := ExpectScheduled(ctx, env.Client, pod)
 			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, "test-zone-3"))
 		})
-		It("should not schedule incompatible node selectors, preferences and requirements", func() {
+		It("should ignore preferences that are incompatible with node selectors and requirements", func() {
 			pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod(
 				test.PodOptions{
 					NodeSelector: map[string]string{v1.LabelTopologyZone: "test-zone-3"},
@@ -266,7 +275,8 @@ var _ = Describe("Combined Constraints", func() {
 						{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpNotIn, Values: []string{"test-zone-2", "test-zone-3"}}},
 				},
 			))[0]
-			ExpectNotScheduled(ctx, env.Client, pod)
+			node := ExpectScheduled(ctx, env.Client, pod)
+			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, "test-zone-3"))
 		})
 		It("should combine multidimensional node selectors, preferences and requirements", func() {
 			pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod(
@@ -289,7 +299,7 @@ var _ = Describe("Combined Constraints", func() {
 			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, "test-zone-3"))
 			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelInstanceTypeStable, "arm-instance-type"))
 		})
-		It("should not combine incompatible multidimensional node selectors, preferences and requirements", func() {
+		It("should ignore incompatible multidimensional preferences", func() {
 			pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod(
 				test.PodOptions{
 					NodeSelector: map[string]string{
@@ -306,7 +316,9 @@ var _ = Describe("Combined Constraints", func() {
 					},
 				},
 			))[0]
-			ExpectNotScheduled(ctx, env.Client, pod)
+			node := ExpectScheduled(ctx, env.Client, pod)
+			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, "test-zone-3"))
+			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelInstanceTypeStable, "arm-instance-type"))
 		})
 	})
 })
@@ -332,7 +344,7 @@ var _ = Describe("Preferential Fallback", func() {
 			pod = ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, pod)[0]
 			ExpectNotScheduled(ctx, env.Client, pod)
 		})
-		It("should relax multiple terms", func() {
+		It("should skip terms that cannot be satisfied", func() {
 			pod := test.UnschedulablePod()
 			pod.Spec.Affinity = &v1.Affinity{NodeAffinity: &v1.NodeAffinity{RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{NodeSelectorTerms: []v1.NodeSelectorTerm{
 				{MatchExpressions: []v1.NodeSelectorRequirement{
@@ -345,23 +357,30 @@ var _ = Describe("Preferential Fallback", func() {
 					{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-1"}},
 				}},
 				{MatchExpressions: []v1.NodeSelectorRequirement{
-					{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-2"}}, // OR operator, never get to this one
+					{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-2"}}, // Equivalent options, earlier terms win
 				}},
 			}}}}
-			// Remove first term
-			pod = ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, pod)[0]
-			ExpectNotScheduled(ctx, env.Client, pod)
-			// Remove second term
-			pod = ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, pod)[0]
-			ExpectNotScheduled(ctx, env.Client, pod)
-			// Success
 			pod = ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, pod)[0]
 			node := ExpectScheduled(ctx, env.Client, pod)
 			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, "test-zone-1"))
 		})
+		It("should select the term that allows the most options", func() {
+			pod := test.UnschedulablePod()
+			pod.Spec.Affinity = &v1.Affinity{NodeAffinity: &v1.NodeAffinity{RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{NodeSelectorTerms: []v1.NodeSelectorTerm{
+				{MatchExpressions: []v1.NodeSelectorRequirement{
+					{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpIn, Values: []string{"small-instance-type"}},
+				}},
+				{MatchExpressions: []v1.NodeSelectorRequirement{
+					{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-2"}},
+				}},
+			}}}}
+			pod = ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, pod)[0]
+			node := ExpectScheduled(ctx, env.Client, pod)
+			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, "test-zone-2"))
+		})
 	})
 	Context("Preferred", func() {
-		It("should relax all terms", func() {
+		It("should ignore terms that cannot be satisfied", func() {
 			pod := test.UnschedulablePod()
 			pod.Spec.Affinity = &v1.Affinity{NodeAffinity: &v1.NodeAffinity{PreferredDuringSchedulingIgnoredDuringExecution: []v1.PreferredSchedulingTerm{
 				{
@@ -375,17 +394,10 @@ var _ = Describe("Preferential Fallback", func() {
 					}},
 				},
 			}}}
-			// Remove first term
-			pod = ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, pod)[0]
-			ExpectNotScheduled(ctx, env.Client, pod)
-			// Remove second term
-			pod = ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, pod)[0]
-			ExpectNotScheduled(ctx, env.Client, pod)
-			// Success
 			pod = ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, pod)[0]
 			ExpectScheduled(ctx, env.Client, pod)
 		})
-		It("should relax to use lighter weights", func() {
+		It("should use lighter weights if heavier terms cannot be satisfied", func() {
 			provisioner.Spec.Requirements = v1alpha5.Requirements{{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-1", "test-zone-2"}}}
 			pod := test.UnschedulablePod()
 			pod.Spec.Affinity = &v1.Affinity{NodeAffinity: &v1.NodeAffinity{PreferredDuringSchedulingIgnoredDuringExecution: []v1.PreferredSchedulingTerm{
@@ -405,14 +417,29 @@ var _ = Describe("Preferential Fallback", func() {
 					}},
 				},
 			}}}
-			// Remove heaviest term
-			pod = ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, pod)[0]
-			ExpectNotScheduled(ctx, env.Client, pod)
-			// Success
 			pod = ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, pod)[0]
 			node := ExpectScheduled(ctx, env.Client, pod)
 			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, "test-zone-2"))
 		})
+		It("should combine compatible terms", func() {
+			pod := test.UnschedulablePod()
+			pod.Spec.Affinity = &v1.Affinity{NodeAffinity: &v1.NodeAffinity{PreferredDuringSchedulingIgnoredDuringExecution: []v1.PreferredSchedulingTerm{
+				{
+					Weight: 1, Preference: v1.NodeSelectorTerm{MatchExpressions: []v1.NodeSelectorRequirement{
+						{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-3"}},
+					}},
+				},
+				{
+					Weight: 1, Preference: v1.NodeSelectorTerm{MatchExpressions: []v1.NodeSelectorRequirement{
+						{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpIn, Values: []string{"arm-instance-type"}},
+					}},
+				},
+			}}}
+			pod = ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, pod)[0]
+			node := ExpectScheduled(ctx, env.Client, pod)
+			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, "test-zone-3"))
+			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelInstanceTypeStable, "arm-instance-type"))
+		})
 	})
 })
 
@@ -657,6 +684,12 @@ var _ = Describe("Taints", func() {
 			ExpectNotScheduled(ctx, env.Client, pod)
 		}
 	})
+	It("should taint nodes with startup taints without requiring pods to tolerate them", func() {
+		provisioner.Spec.StartupTaints = []v1.Taint{{Key: "test", Value: "bar", Effect: v1.TaintEffectNoSchedule}}
+		pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod())[0]
+		node := ExpectScheduled(ctx, env.Client, pod)
+		Expect(node.Spec.Taints).To(ContainElement(provisioner.Spec.StartupTaints[0]))
+	})
 	It("should not generate taints for OpExists", func() {
 		pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner,
 			test.UnschedulablePod(test.PodOptions{Tolerations: []v1.Toleration{{Key: "test-key", Operator: v1.TolerationOpExists, Effect: v1.TaintEffectNoExecute}}}),
@@ -18,9 +18,8 @@ import (
 	"context"
 	"fmt"
 	"math"
-	"strings"
+	"sort"
 
-	"github.com/Pallinder/go-randomdata"
 	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
 	"github.com/aws/karpenter/pkg/utils/functional"
 	"github.com/aws/karpenter/pkg/utils/pod"
@@ -46,7 +45,11 @@ func (t *Topology) Inject(ctx context.Context, constraints *v1alpha5.Constraints
 			return fmt.Errorf("computing topology, %w", err)
 		}
 		for _, pod := range topologyGroup.Pods {
-			domain := topologyGroup.NextDomain(constraints.Requirements.With(v1alpha5.PodRequirements(pod)).Requirement(topologyGroup.Constraint.TopologyKey))
+			podRequirements, err := constraints.PodRequirements(pod)
+			if err != nil {
+				continue // The pod will fail validation when it is scheduled
+			}
+			domain := topologyGroup.NextDomain(constraints.Requirements.With(podRequirements).Requirement(topologyGroup.Constraint.TopologyKey))
 			pod.Spec.NodeSelector = functional.UnionStringMaps(pod.Spec.NodeSelector, map[string]string{topologyGroup.Constraint.TopologyKey: domain})
 		}
 	}
@@ -67,8 +70,17 @@ func (t *Topology) getTopologyGroups(pods []*v1.Pod) []*TopologyGroup {
 			}
 		}
 	}
+	// Order groups by key and pods by name so that domains are chosen
+	// identically for identical batches
+	keys := []uint64{}
+	for key := range topologyGroupMap {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
 	topologyGroups := []*TopologyGroup{}
-	for _, topologyGroup := range topologyGroupMap {
+	for _, key := range keys {
+		topologyGroup := topologyGroupMap[key]
+		sort.SliceStable(topologyGroup.Pods, func(i, j int) bool { return topologyGroup.Pods[i].Name < topologyGroup.Pods[j].Name })
 		topologyGroups = append(topologyGroups, topologyGroup)
 	}
 	return topologyGroups
@@ -91,11 +103,13 @@ func (t *Topology) computeCurrentTopology(ctx context.Context, constraints *v1al
 // `hostname` is 0. Thus, we can always improve topology skew (computed against
 // the global minimum) by adding pods to the cluster. We will generate
 // len(pods)/MaxSkew number of domains, to ensure that skew is not violated for
-// new instances.
+// new instances. Domains are named after the topology group, rather than
+// randomly, so that identical batches are packed identically.
 func (t *Topology) computeHostnameTopology(topologyGroup *TopologyGroup, constraints *v1alpha5.Constraints) error {
+	key := topologyGroupKey(topologyGroup.Pods[0].Namespace, topologyGroup.Constraint)
 	domains := []string{}
 	for i := 0; i < int(math.Ceil(float64(len(topologyGroup.Pods))/float64(topologyGroup.Constraint.MaxSkew))); i++ {
-		domains = append(domains, strings.ToLower(randomdata.Alphanumeric(8)))
+		domains = append(domains, fmt.Sprintf("%x-%d", key, i))
 	}
 	topologyGroup.Register(domains...)
 	// This is a bit of a hack that allows the constraints to recognize viable hostname topologies
@@ -118,6 +132,10 @@ func (t *Topology) computeZonalTopology(ctx context.Context, requirements v1alph
 }
 
 func (t *Topology) countMatchingPods(ctx context.Context, topologyGroup *TopologyGroup) error {
+	// Without a cluster, e.g. when simulating, there are no existing pods to count
+	if t.kubeClient == nil {
+		return nil
+	}
 	pods := &v1.PodList{}
 	if err := t.kubeClient.List(ctx, pods, TopologyListOptions(topologyGroup.Pods[0].Namespace, &topologyGroup.Constraint)); err != nil {
 		return fmt.Errorf("listing pods, %w", err)
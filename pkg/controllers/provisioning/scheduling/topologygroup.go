@@ -50,7 +50,8 @@ func (t *TopologyGroup) Increment(domain string) {
 	}
 }
 
-// NextDomain chooses a domain within the constraints that minimizes skew
+// NextDomain chooses a domain within the constraints that minimizes skew,
+// breaking ties by the domain's name
 func (t *TopologyGroup) NextDomain(requirement sets.String) string {
 	minDomain := ""
 	minCount := math.MaxInt32
@@ -58,7 +59,7 @@ func (t *TopologyGroup) NextDomain(requirement sets.String) string {
 		if requirement != nil && !requirement.Has(domain) {
 			continue
 		}
-		if count <= minCount {
+		if count < minCount || (count == minCount && domain < minDomain) {
 			minDomain = domain
 			minCount = count
 		}
@@ -16,20 +16,39 @@ package provisioning_test
 
 import (
 	"context"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
 	"github.com/aws/karpenter/pkg/cloudprovider/fake"
 	"github.com/aws/karpenter/pkg/cloudprovider/registry"
 	"github.com/aws/karpenter/pkg/controllers/provisioning"
 	"github.com/aws/karpenter/pkg/controllers/selection"
+	"github.com/aws/karpenter/pkg/controllers/state"
+	"github.com/aws/karpenter/pkg/events"
+	"github.com/aws/karpenter/pkg/metrics"
 	"github.com/aws/karpenter/pkg/test"
+	"github.com/aws/karpenter/pkg/utils/injection"
+	"github.com/aws/karpenter/pkg/utils/options"
 	"github.com/aws/karpenter/pkg/utils/resources"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
 	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"knative.dev/pkg/ptr"
+	"knative.dev/pkg/system"
+	_ "knative.dev/pkg/system/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	. "github.com/aws/karpenter/pkg/test/expectations"
 	. "github.com/onsi/ginkgo"
@@ -38,8 +57,10 @@ import (
 )
 
 var ctx context.Context
+var cloudProvider *fake.CloudProvider
 var provisioningController *provisioning.Controller
 var selectionController *selection.Controller
+var cluster *state.Cluster
 var env *test.Environment
 
 func TestAPIs(t *testing.T) {
@@ -50,12 +71,15 @@ func TestAPIs(t *testing.T) {
 
 var _ = BeforeSuite(func() {
 	env = test.NewEnvironment(ctx, func(e *test.Environment) {
-		cloudProvider := &fake.CloudProvider{}
+		cloudProvider = &fake.CloudProvider{}
 		registry.RegisterOrDie(ctx, cloudProvider)
-		provisioningController = provisioning.NewController(ctx, e.Client, corev1.NewForConfigOrDie(e.Config), cloudProvider)
-		selectionController = selection.NewController(e.Client, provisioningController)
+		cluster = state.NewCluster()
+		provisioningController = provisioning.NewController(ctx, e.Client, corev1.NewForConfigOrDie(e.Config), cloudProvider, cluster, events.NewRecorder(&record.FakeRecorder{}))
+		selectionController = selection.NewController(e.Client, provisioningController, events.NewRecorder(&record.FakeRecorder{}))
 	})
 	Expect(env.Start()).To(Succeed(), "Failed to start environment")
+	// Placement decisions are recorded in the system namespace
+	ExpectCreated(ctx, env.Client, &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: system.Namespace()}})
 })
 
 var _ = AfterSuite(func() {
@@ -78,10 +102,68 @@ var _ = Describe("Provisioning", func() {
 			},
 		}
 		provisioner.SetDefaults(ctx)
+		cloudProvider.Reset()
 	})
 
 	AfterEach(func() {
 		ExpectProvisioningCleanedUp(ctx, env.Client, provisioningController)
+		ExpectClusterStateCleanedUp(cluster)
+	})
+
+	Context("Metrics", func() {
+		It("should count nodes launched by provisioner, instance type and capacity type", func() {
+			pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, test.UnschedulablePod())[0]
+			node := ExpectScheduled(ctx, env.Client, pod)
+			launched := metrics.NodesLaunched.WithLabelValues(provisioner.Name, node.Labels[v1.LabelInstanceTypeStable], node.Labels[v1alpha5.LabelCapacityType])
+			Expect(testutil.ToFloat64(launched)).To(BeNumerically(">=", 1))
+		})
+		It("should observe batch sizes and pod bind latency", func() {
+			ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, test.UnschedulablePod(), test.UnschedulablePod())
+			Expect(testutil.CollectAndCount(metrics.BatchSize)).To(BeNumerically(">=", 1))
+			Expect(testutil.CollectAndCount(metrics.PodBindLatency)).To(BeNumerically(">=", 1))
+		})
+		It("should count launch failures by error class", func() {
+			failures := metrics.LaunchFailures.WithLabelValues(provisioner.Name, metrics.ErrorClassInsufficientCapacity)
+			before := testutil.ToFloat64(failures)
+			cloudProvider.CreateHook = fake.Fail(cloudprovider.NewInsufficientCapacityError(fmt.Errorf("no capacity")))
+			ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, test.UnschedulablePod())
+			Expect(testutil.ToFloat64(failures)).To(BeNumerically(">", before))
+		})
+	})
+
+	Context("Tracing", func() {
+		It("should trace the provisioning pipeline", func() {
+			recorder := tracetest.NewSpanRecorder()
+			otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+			defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+			pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, test.UnschedulablePod())[0]
+			ExpectScheduled(ctx, env.Client, pod)
+			names := []string{}
+			for _, span := range recorder.Ended() {
+				names = append(names, span.Name())
+			}
+			Expect(names).To(ContainElements(
+				"selection.SelectProvisioner",
+				"provisioning.Provision",
+				"provisioning.Batch",
+				"provisioning.BindInFlight",
+				"scheduling.Solve",
+				"binpacking.Pack",
+				"provisioning.Launch",
+				"cloudprovider.Create",
+				"provisioning.Bind",
+			))
+		})
+	})
+
+	Context("Batches", func() {
+		It("should wait for the next batch once provisioned", func() {
+			pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, test.UnschedulablePod())[0]
+			ExpectScheduled(ctx, env.Client, pod)
+			p, ok := provisioningController.Get(provisioner.Name)
+			Expect(ok).To(BeTrue())
+			Expect(p.CurrentBatch()).To(Equal(provisioning.Batch{Phase: provisioning.BatchPhaseWaiting}))
+		})
 	})
 
 	Context("Reconciliation", func() {
@@ -94,6 +176,92 @@ var _ = Describe("Provisioning", func() {
 				ExpectScheduled(ctx, env.Client, pod)
 			}
 		})
+		It("should annotate nodes with the hash of the provisioner's spec", func() {
+			pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, test.UnschedulablePod())[0]
+			node := ExpectScheduled(ctx, env.Client, pod)
+			hash, err := provisioner.Spec.Hash()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(node.Annotations).To(HaveKeyWithValue(v1alpha5.ProvisionerHashAnnotationKey, hash))
+		})
+		It("should set the provisioner as the owner of nodes", func() {
+			pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, test.UnschedulablePod())[0]
+			node := ExpectScheduled(ctx, env.Client, pod)
+			persisted := &v1alpha5.Provisioner{}
+			Expect(env.Client.Get(ctx, client.ObjectKeyFromObject(provisioner), persisted)).To(Succeed())
+			Expect(node.OwnerReferences).To(ConsistOf(persisted.OwnerReference()))
+		})
+		It("should annotate nodes launched for pods as cancelable", func() {
+			pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, test.UnschedulablePod())[0]
+			node := ExpectScheduled(ctx, env.Client, pod)
+			Expect(node.Annotations).To(HaveKeyWithValue(v1alpha5.CancelableAnnotationKey, "true"))
+		})
+		It("should launch nodes for pods that exceed the largest instance type in one pass", func() {
+			pods := []*v1.Pod{}
+			for i := 0; i < 3; i++ {
+				pods = append(pods, test.UnschedulablePod(test.PodOptions{
+					ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("3")}},
+				}))
+			}
+			nodeNames := sets.NewString()
+			for _, pod := range ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, pods...) {
+				nodeNames.Insert(ExpectScheduled(ctx, env.Client, pod).Name)
+			}
+			Expect(nodeNames.Len()).To(Equal(3))
+		})
+		It("should launch the remaining nodes when the cloud provider partially fulfills a launch", func() {
+			cloudProvider.MaxQuantity = 1
+			pods := []*v1.Pod{}
+			for i := 0; i < 3; i++ {
+				pods = append(pods, test.UnschedulablePod(test.PodOptions{
+					ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("3")}},
+				}))
+			}
+			nodeNames := sets.NewString()
+			for _, pod := range ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, pods...) {
+				nodeNames.Insert(ExpectScheduled(ctx, env.Client, pod).Name)
+			}
+			Expect(nodeNames.Len()).To(Equal(3))
+		})
+		It("should launch the remainder of a launch that is limited by the cloud provider", func() {
+			cloudProvider.CreateHook = fake.Limit(1)
+			pods := []*v1.Pod{}
+			for i := 0; i < 3; i++ {
+				pods = append(pods, test.UnschedulablePod(test.PodOptions{
+					ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("3")}},
+				}))
+			}
+			for _, pod := range ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, pods...) {
+				ExpectScheduled(ctx, env.Client, pod)
+			}
+			quantities := []int{}
+			for _, call := range cloudProvider.CallsTo("Create") {
+				quantities = append(quantities, call.Quantity)
+			}
+			Expect(quantities).To(Equal([]int{1, 1, 1}))
+		})
+		It("should not schedule pods if the cloud provider has insufficient capacity", func() {
+			cloudProvider.CreateHook = fake.Fail(cloudprovider.NewInsufficientCapacityError(fmt.Errorf("no capacity")))
+			for _, pod := range ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, test.UnschedulablePod()) {
+				ExpectNotScheduled(ctx, env.Client, pod)
+			}
+			Expect(cloudProvider.CallsTo("Create")).ToNot(BeEmpty())
+			Expect(cloudprovider.IsInsufficientCapacityError(cloudProvider.CallsTo("Create")[0].Err)).To(BeTrue())
+		})
+		It("should schedule pods once the cloud provider stops throttling", func() {
+			cloudProvider.CreateHook = fake.FailN(1, fake.ErrThrottled)
+			for _, pod := range ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, test.UnschedulablePod()) {
+				ExpectNotScheduled(ctx, env.Client, pod)
+			}
+			for _, pod := range ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, test.UnschedulablePod()) {
+				ExpectScheduled(ctx, env.Client, pod)
+			}
+		})
+		It("should not provision from a provisioner that fails verification", func() {
+			cloudProvider.VerifyError = fmt.Errorf("no subnets matched selector")
+			ExpectApplied(ctx, env.Client, provisioner)
+			ExpectReconcileFailed(ctx, provisioningController, client.ObjectKeyFromObject(provisioner))
+			Expect(provisioningController.List(ctx)).To(BeEmpty())
+		})
 		It("should provision nodes for pods with supported node selectors", func() {
 			schedulable := []*v1.Pod{
 				// Constrained by provisioner
@@ -141,10 +309,32 @@ var _ = Describe("Provisioning", func() {
 				test.UnschedulablePod(test.PodOptions{
 					ResourceRequirements: v1.ResourceRequirements{Limits: v1.ResourceList{resources.AWSNeuron: resource.MustParse("1")}},
 				}),
+				test.UnschedulablePod(test.PodOptions{
+					ResourceRequirements: v1.ResourceRequirements{Limits: v1.ResourceList{resources.HabanaGaudi: resource.MustParse("1")}},
+				}),
 			) {
 				ExpectScheduled(ctx, env.Client, pod)
 			}
 		})
+		It("should only provision offerings that satisfy both the zone and capacity type", func() {
+			cloudProvider.InstanceTypes = []cloudprovider.InstanceType{
+				fake.NewInstanceType(fake.InstanceTypeOptions{
+					Name: "split-instance-type",
+					Offerings: []cloudprovider.Offering{
+						{CapacityType: "spot", Zone: "test-zone-1"},
+						{CapacityType: "on-demand", Zone: "test-zone-2"},
+					},
+				}),
+			}
+			pods := ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner,
+				test.UnschedulablePod(test.PodOptions{NodeSelector: map[string]string{v1.LabelTopologyZone: "test-zone-1", v1alpha5.LabelCapacityType: "spot"}}),
+				test.UnschedulablePod(test.PodOptions{NodeSelector: map[string]string{v1.LabelTopologyZone: "test-zone-2", v1alpha5.LabelCapacityType: "spot"}}),
+			)
+			node := ExpectScheduled(ctx, env.Client, pods[0])
+			Expect(node.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, "test-zone-1"))
+			Expect(node.Labels).To(HaveKeyWithValue(v1alpha5.LabelCapacityType, "spot"))
+			ExpectNotScheduled(ctx, env.Client, pods[1])
+		})
 		Context("Resource Limits", func() {
 			It("should not schedule when limits are exceeded", func() {
 				provisioner.Status = v1alpha5.ProvisionerStatus{
@@ -156,6 +346,131 @@ var _ = Describe("Provisioning", func() {
 				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, test.UnschedulablePod())[0]
 				ExpectNotScheduled(ctx, env.Client, pod)
 			})
+			It("should not schedule when the provisioner already owns max nodes", func() {
+				provisioner.Spec.Limits.MaxNodes = ptr.Int32(1)
+				ExpectCreated(ctx, env.Client, test.Node(test.NodeOptions{Labels: map[string]string{v1alpha5.ProvisionerNameLabelKey: provisioner.Name}}))
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, test.UnschedulablePod())[0]
+				ExpectNotScheduled(ctx, env.Client, pod)
+			})
+			It("should only launch up to max nodes", func() {
+				provisioner.Spec.Limits.MaxNodes = ptr.Int32(1)
+				pods := []*v1.Pod{test.UnschedulablePod(), test.UnschedulablePod()}
+				for _, pod := range pods {
+					pod.Spec.Containers[0].Ports = []v1.ContainerPort{{ContainerPort: 8080, HostPort: 80}}
+				}
+				scheduled := 0
+				for _, pod := range ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, pods...) {
+					if pod.Spec.NodeName != "" {
+						scheduled++
+					}
+				}
+				Expect(scheduled).To(Equal(1))
+			})
+			It("should not count nodes that failed to launch against max nodes", func() {
+				provisioner.Spec.Limits.MaxNodes = ptr.Int32(2)
+				// Whichever zone's packing launches first fails
+				cloudProvider.CreateHook = fake.FailN(1, cloudprovider.NewInsufficientCapacityError(fmt.Errorf("no capacity")))
+				pods := []*v1.Pod{}
+				for _, zone := range []string{"test-zone-1", "test-zone-1", "test-zone-2", "test-zone-2"} {
+					pod := test.UnschedulablePod(test.PodOptions{NodeSelector: map[string]string{v1.LabelTopologyZone: zone}})
+					pod.Spec.Containers[0].Ports = []v1.ContainerPort{{ContainerPort: 8080, HostPort: 80}}
+					pods = append(pods, pod)
+				}
+				scheduled := 0
+				for _, pod := range ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, pods...) {
+					if pod.Spec.NodeName != "" {
+						scheduled++
+					}
+				}
+				Expect(scheduled).To(Equal(2))
+			})
+		})
+		Context("Headroom", func() {
+			It("should launch headroom alongside pending pods", func() {
+				provisioner.Spec.Provisioning.Headroom = []v1alpha5.Headroom{{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("2")}}}
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, test.UnschedulablePod(
+					test.PodOptions{
+						ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")}},
+					},
+				))[0]
+				node := ExpectScheduled(ctx, env.Client, pod)
+				Expect(*node.Status.Allocatable.Cpu()).To(Equal(resource.MustParse("4")))
+			})
+			It("should launch nodes for headroom that does not fit alongside pending pods", func() {
+				provisioner.Spec.Provisioning.Headroom = []v1alpha5.Headroom{{Replicas: ptr.Int32(2), Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("3")}}}
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, test.UnschedulablePod(
+					test.PodOptions{
+						ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")}},
+					},
+				))[0]
+				node := ExpectScheduled(ctx, env.Client, pod)
+				nodes := &v1.NodeList{}
+				Expect(env.Client.List(ctx, nodes)).To(Succeed())
+				Expect(len(nodes.Items)).To(Equal(2))
+				for i := range nodes.Items {
+					if nodes.Items[i].Name != node.Name {
+						Expect(nodes.Items[i].Annotations).ToNot(HaveKey(v1alpha5.CancelableAnnotationKey))
+					}
+				}
+			})
+			It("should only launch headroom that doesn't fit in spare capacity", func() {
+				provisioner.Spec.Provisioning.Headroom = []v1alpha5.Headroom{{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("2")}}}
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, test.UnschedulablePod(
+					test.PodOptions{
+						ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")}},
+					},
+				))[0]
+				first := ExpectScheduled(ctx, env.Client, pod)
+				Expect(*first.Status.Allocatable.Cpu()).To(Equal(resource.MustParse("4")))
+				// Pods with host ports aren't bound to in flight nodes, so a node is launched without headroom
+				pod = test.UnschedulablePod(test.PodOptions{
+					ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")}},
+				})
+				pod.Spec.Containers[0].Ports = []v1.ContainerPort{{ContainerPort: 8080, HostPort: 80}}
+				pod = ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, pod)[0]
+				second := ExpectScheduled(ctx, env.Client, pod)
+				Expect(second.Name).ToNot(Equal(first.Name))
+				Expect(*second.Status.Allocatable.Cpu()).To(Equal(resource.MustParse("2")))
+			})
+			It("should launch headroom for provisioners with label templates", func() {
+				provisioner.Spec.LabelTemplates = map[string]string{"example.com/team": "{{ .Namespace.Labels.team }}"}
+				provisioner.Spec.Provisioning.Headroom = []v1alpha5.Headroom{{Replicas: ptr.Int32(2), Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("3")}}}
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, test.UnschedulablePod(
+					test.PodOptions{
+						ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")}},
+					},
+				))[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				nodes := &v1.NodeList{}
+				Expect(env.Client.List(ctx, nodes)).To(Succeed())
+				Expect(len(nodes.Items)).To(Equal(2))
+			})
+		})
+		Context("Placement Decisions", func() {
+			It("should record a placement decision for each launched node", func() {
+				pod := ExpectProvisioned(injection.WithOptions(ctx, options.Options{PlacementDecisionTTL: time.Hour}), env.Client, selectionController, provisioningController, provisioner, test.UnschedulablePod())[0]
+				node := ExpectScheduled(ctx, env.Client, pod)
+				decisions := &v1alpha5.PlacementDecisionList{}
+				Expect(env.Client.List(ctx, decisions, client.InNamespace(system.Namespace()))).To(Succeed())
+				Expect(decisions.Items).To(HaveLen(1))
+				decision := decisions.Items[0]
+				Expect(decision.Name).To(HavePrefix(node.Name + "-"))
+				Expect(decision.Labels).To(HaveKeyWithValue(v1alpha5.ProvisionerNameLabelKey, provisioner.Name))
+				Expect(decision.Spec.Provisioner).To(Equal(provisioner.Name))
+				Expect(decision.Spec.Node).To(Equal(node.Name))
+				Expect(decision.Spec.Pods).To(ConsistOf(client.ObjectKeyFromObject(pod).String()))
+				Expect(decision.Spec.InstanceType).To(Equal(node.Labels[v1.LabelInstanceTypeStable]))
+				Expect(decision.Spec.InstanceTypeOptions).To(ContainElement(node.Labels[v1.LabelInstanceTypeStable]))
+				Expect(decision.Spec.Zone).To(Equal(node.Labels[v1.LabelTopologyZone]))
+				Expect(decision.Spec.PackingStrategy).To(Equal(v1alpha5.PackingStrategyLowestCost))
+			})
+			It("should not record placement decisions if their time to live is zero", func() {
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, test.UnschedulablePod())[0]
+				ExpectScheduled(ctx, env.Client, pod)
+				decisions := &v1alpha5.PlacementDecisionList{}
+				Expect(env.Client.List(ctx, decisions)).To(Succeed())
+				Expect(decisions.Items).To(BeEmpty())
+			})
 		})
 		Context("Daemonsets and Node Overhead", func() {
 			It("should account for overhead", func() {
@@ -232,6 +547,43 @@ var _ = Describe("Provisioning", func() {
 				Expect(*node.Status.Allocatable.Cpu()).To(Equal(resource.MustParse("2")))
 				Expect(*node.Status.Allocatable.Memory()).To(Equal(resource.MustParse("2Gi")))
 			})
+			It("should only count daemonsets that schedule to each instance type", func() {
+				ExpectCreated(ctx, env.Client, test.DaemonSet(
+					test.DaemonSetOptions{PodOptions: test.PodOptions{
+						NodeSelector:         map[string]string{v1.LabelInstanceTypeStable: "default-instance-type"},
+						ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("3")}},
+					}},
+				))
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, test.UnschedulablePod(
+					test.PodOptions{
+						ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")}},
+					},
+				))[0]
+				node := ExpectScheduled(ctx, env.Client, pod)
+				Expect(node.Labels[v1.LabelInstanceTypeStable]).ToNot(Equal("default-instance-type"))
+			})
+		})
+		Context("Host Ports", func() {
+			hostPortPod := func(hostPort int32) *v1.Pod {
+				pod := test.UnschedulablePod()
+				pod.Spec.Containers[0].Ports = []v1.ContainerPort{{ContainerPort: 8080, HostPort: hostPort}}
+				return pod
+			}
+			It("should pack pods with conflicting host ports onto separate nodes", func() {
+				pods := ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, hostPortPod(80), hostPortPod(80))
+				Expect(ExpectScheduled(ctx, env.Client, pods[0]).Name).ToNot(Equal(ExpectScheduled(ctx, env.Client, pods[1]).Name))
+			})
+			It("should pack pods with different host ports onto the same node", func() {
+				pods := ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, hostPortPod(80), hostPortPod(443))
+				Expect(ExpectScheduled(ctx, env.Client, pods[0]).Name).To(Equal(ExpectScheduled(ctx, env.Client, pods[1]).Name))
+			})
+			It("should not schedule pods with host ports that conflict with daemons", func() {
+				daemonSet := test.DaemonSet()
+				daemonSet.Spec.Template.Spec.Containers[0].Ports = []v1.ContainerPort{{ContainerPort: 8080, HostPort: 80}}
+				ExpectCreated(ctx, env.Client, daemonSet)
+				pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, hostPortPod(80))[0]
+				ExpectNotScheduled(ctx, env.Client, pod)
+			})
 		})
 		Context("Labels", func() {
 			It("should label nodes", func() {
@@ -245,6 +597,21 @@ var _ = Describe("Provisioning", func() {
 					Expect(node.Labels).To(HaveKey(v1.LabelInstanceTypeStable))
 				}
 			})
+			It("should label nodes with the label templates of their pods", func() {
+				provisioner.Spec.LabelTemplates = map[string]string{"example.com/team": "{{ .Namespace.Labels.team }}"}
+				teamA := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Labels: map[string]string{"team": "a"}}}
+				teamB := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-b", Labels: map[string]string{"team": "b"}}}
+				ExpectCreated(ctx, env.Client, teamA, teamB)
+				pods := ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner,
+					test.UnschedulablePod(test.PodOptions{Namespace: teamA.Name}),
+					test.UnschedulablePod(test.PodOptions{Namespace: teamB.Name}),
+				)
+				nodeA := ExpectScheduled(ctx, env.Client, pods[0])
+				nodeB := ExpectScheduled(ctx, env.Client, pods[1])
+				Expect(nodeA.Name).ToNot(Equal(nodeB.Name))
+				Expect(nodeA.Labels).To(HaveKeyWithValue("example.com/team", "a"))
+				Expect(nodeB.Labels).To(HaveKeyWithValue("example.com/team", "b"))
+			})
 		})
 		Context("Taints", func() {
 			It("should apply unready taints", func() {
@@ -254,6 +621,16 @@ var _ = Describe("Provisioning", func() {
 					Expect(node.Spec.Taints).To(ContainElement(v1.Taint{Key: v1alpha5.NotReadyTaintKey, Effect: v1.TaintEffectNoSchedule}))
 				}
 			})
+			It("should apply the provisioner's taints and startup taints", func() {
+				provisioner.Spec.Taints = v1alpha5.Taints{{Key: "test-key", Value: "test-value", Effect: v1.TaintEffectNoSchedule}}
+				provisioner.Spec.StartupTaints = v1alpha5.Taints{{Key: "test-startup-key", Effect: v1.TaintEffectNoExecute}}
+				for _, pod := range ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, test.UnschedulablePod(test.PodOptions{
+					Tolerations: []v1.Toleration{{Key: "test-key", Operator: v1.TolerationOpExists}},
+				})) {
+					node := ExpectScheduled(ctx, env.Client, pod)
+					Expect(node.Spec.Taints).To(ContainElements(provisioner.Spec.Taints[0], provisioner.Spec.StartupTaints[0]))
+				}
+			})
 		})
 	})
 })
@@ -0,0 +1,169 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package replacement
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/multierr"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"knative.dev/pkg/logging"
+	controllerruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/audit"
+	"github.com/aws/karpenter/pkg/controllers/provisioning"
+	"github.com/aws/karpenter/pkg/utils/functional"
+	karpenterlogging "github.com/aws/karpenter/pkg/utils/logging"
+	"github.com/aws/karpenter/pkg/utils/node"
+)
+
+const (
+	controllerName = "replacement"
+	// RequeueInterval is how often nodes are checked for their replacements
+	// to become ready
+	RequeueInterval = 10 * time.Second
+)
+
+// Controller replaces nodes that are annotated to be replaced, e.g. because
+// their instance is at an elevated risk of interruption. Unlike deleting the
+// node, the capacity for its pods is launched first: the node is cordoned,
+// nodes are launched for its pods, and it's only deleted, which drains it, once
+// they're ready. Replacements that fail to launch, or that are terminated
+// before becoming ready, aren't waited for.
+type Controller struct {
+	kubeClient   client.Client
+	provisioners *provisioning.Controller
+}
+
+// NewController is a constructor
+func NewController(kubeClient client.Client, provisioners *provisioning.Controller) *Controller {
+	return &Controller{
+		kubeClient:   kubeClient,
+		provisioners: provisioners,
+	}
+}
+
+// Reconcile launches the replacements of the node, and deletes it once they're
+// ready
+func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	ctx = karpenterlogging.WithReconcile(ctx, controllerName, "node", req.Name)
+	stored := &v1.Node{}
+	if err := c.kubeClient.Get(ctx, req.NamespacedName, stored); err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+	reason, ok := stored.Annotations[v1alpha5.ReplaceAnnotationKey]
+	if !ok || !stored.DeletionTimestamp.IsZero() {
+		return reconcile.Result{}, nil
+	}
+	provisionerName, ok := stored.Labels[v1alpha5.ProvisionerNameLabelKey]
+	if !ok {
+		return reconcile.Result{}, nil
+	}
+	replacements, launched := stored.Annotations[v1alpha5.ReplacementAnnotationKey]
+	if !launched {
+		return c.launch(ctx, provisionerName, stored, reason)
+	}
+	for _, name := range strings.Split(replacements, ",") {
+		if name == "" {
+			continue
+		}
+		replacement := &v1.Node{}
+		if err := c.kubeClient.Get(ctx, types.NamespacedName{Name: name}, replacement); err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return reconcile.Result{}, err
+		}
+		if replacement.DeletionTimestamp.IsZero() && !node.IsReady(replacement) {
+			return reconcile.Result{RequeueAfter: RequeueInterval}, nil
+		}
+	}
+	if err := c.kubeClient.Delete(ctx, stored); err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, fmt.Errorf("deleting node, %w", err)
+	}
+	audit.Deleted(ctx, stored, fmt.Sprintf("replaced, %s", reason), nil)
+	logging.FromContext(ctx).Infof("Deleted node after launching its replacement, %s", reason)
+	return reconcile.Result{}, nil
+}
+
+// launch cordons the node and launches nodes for its pods, recording them on
+// the node. The node is annotated before the launch, with an optimistic lock,
+// so that replacements are launched at most once, even if recording them
+// fails. At worst, the node is then deleted without waiting for them.
+func (c *Controller) launch(ctx context.Context, provisionerName string, stored *v1.Node, reason string) (reconcile.Result, error) {
+	provisioner, ok := c.provisioners.Get(provisionerName)
+	if !ok {
+		// The provisioner may not be running yet
+		return reconcile.Result{RequeueAfter: RequeueInterval}, nil
+	}
+	launching := stored.DeepCopy()
+	launching.Spec.Unschedulable = true
+	launching.Annotations = functional.UnionStringMaps(launching.Annotations, map[string]string{v1alpha5.ReplacementAnnotationKey: ""})
+	if err := c.kubeClient.Patch(ctx, launching, client.MergeFromWithOptions(stored, client.MergeFromWithOptimisticLock{})); err != nil {
+		return reconcile.Result{}, fmt.Errorf("patching node, %w", err)
+	}
+	launched, err := provisioner.Replace(ctx, launching)
+	if err != nil && len(launched) == 0 {
+		// Nothing was launched, so the launch is retried
+		withdrawn := launching.DeepCopy()
+		withdrawn.Spec.Unschedulable = stored.Spec.Unschedulable
+		delete(withdrawn.Annotations, v1alpha5.ReplacementAnnotationKey)
+		return reconcile.Result{}, multierr.Append(fmt.Errorf("launching replacement, %w", err), c.patch(ctx, withdrawn, launching))
+	}
+	if err != nil {
+		logging.FromContext(ctx).Errorf("Launched %d replacement node(s), %s", len(launched), err.Error())
+	}
+	replaced := launching.DeepCopy()
+	replaced.Annotations[v1alpha5.ReplacementAnnotationKey] = strings.Join(launched, ",")
+	if err := c.patch(ctx, replaced, launching); err != nil {
+		return reconcile.Result{}, err
+	}
+	logging.FromContext(ctx).Infof("Cordoned node and launched replacement node(s) %v, %s", launched, reason)
+	return reconcile.Result{RequeueAfter: RequeueInterval}, nil
+}
+
+// patch the node's changes from its stored version
+func (c *Controller) patch(ctx context.Context, node *v1.Node, stored *v1.Node) error {
+	if err := c.kubeClient.Patch(ctx, node, client.MergeFrom(stored)); err != nil {
+		return fmt.Errorf("patching node, %w", err)
+	}
+	return nil
+}
+
+// Register the controller to the manager
+func (c *Controller) Register(_ context.Context, m manager.Manager) error {
+	return controllerruntime.
+		NewControllerManagedBy(m).
+		Named(controllerName).
+		For(&v1.Node{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: 10}).
+		Complete(c)
+}
@@ -0,0 +1,154 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package replacement_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider/fake"
+	"github.com/aws/karpenter/pkg/cloudprovider/registry"
+	"github.com/aws/karpenter/pkg/controllers/provisioning"
+	"github.com/aws/karpenter/pkg/controllers/replacement"
+	"github.com/aws/karpenter/pkg/controllers/state"
+	"github.com/aws/karpenter/pkg/events"
+	"github.com/aws/karpenter/pkg/test"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	. "github.com/aws/karpenter/pkg/test/expectations"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "knative.dev/pkg/logging/testing"
+)
+
+var ctx context.Context
+var provisioningController *provisioning.Controller
+var controller *replacement.Controller
+var env *test.Environment
+
+func TestAPIs(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Replacement")
+}
+
+var _ = BeforeSuite(func() {
+	env = test.NewEnvironment(ctx, func(e *test.Environment) {
+		cloudProvider := &fake.CloudProvider{}
+		registry.RegisterOrDie(ctx, cloudProvider)
+		provisioningController = provisioning.NewController(ctx, e.Client, corev1.NewForConfigOrDie(e.Config), cloudProvider, state.NewCluster(), events.NewRecorder(&record.FakeRecorder{}))
+		controller = replacement.NewController(e.Client, provisioningController)
+	})
+	Expect(env.Start()).To(Succeed(), "Failed to start environment")
+})
+
+var _ = AfterSuite(func() {
+	Expect(env.Stop()).To(Succeed(), "Failed to stop environment")
+})
+
+var _ = Describe("Replacement", func() {
+	var provisioner *v1alpha5.Provisioner
+	var node *v1.Node
+
+	BeforeEach(func() {
+		provisioner = &v1alpha5.Provisioner{
+			ObjectMeta: metav1.ObjectMeta{Name: v1alpha5.DefaultProvisioner.Name},
+			Spec:       v1alpha5.ProvisionerSpec{},
+		}
+		provisioner.SetDefaults(ctx)
+		node = test.Node(test.NodeOptions{
+			Labels:      map[string]string{v1alpha5.ProvisionerNameLabelKey: provisioner.Name},
+			Annotations: map[string]string{v1alpha5.ReplaceAnnotationKey: "rebalance recommendation"},
+		})
+		ExpectApplied(ctx, env.Client, provisioner)
+		ExpectReconcileSucceeded(ctx, provisioningController, client.ObjectKeyFromObject(provisioner))
+	})
+
+	AfterEach(func() {
+		ExpectProvisioningCleanedUp(ctx, env.Client, provisioningController)
+	})
+
+	It("should ignore nodes that are not annotated to be replaced", func() {
+		delete(node.Annotations, v1alpha5.ReplaceAnnotationKey)
+		ExpectCreated(ctx, env.Client, node)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(node))
+		node = ExpectNodeExists(ctx, env.Client, node.Name)
+		Expect(node.Spec.Unschedulable).To(BeFalse())
+		Expect(node.Annotations).ToNot(HaveKey(v1alpha5.ReplacementAnnotationKey))
+	})
+	It("should cordon the node and launch a replacement for its pods", func() {
+		ExpectCreated(ctx, env.Client, node)
+		pod := test.Pod(test.PodOptions{NodeName: node.Name})
+		ExpectCreated(ctx, env.Client, pod)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(node))
+		node = ExpectNodeExists(ctx, env.Client, node.Name)
+		Expect(node.Spec.Unschedulable).To(BeTrue())
+		Expect(node.DeletionTimestamp.IsZero()).To(BeTrue())
+		Expect(node.Annotations).To(HaveKey(v1alpha5.ReplacementAnnotationKey))
+		replacements := strings.Split(node.Annotations[v1alpha5.ReplacementAnnotationKey], ",")
+		Expect(replacements).To(HaveLen(1))
+		ExpectNodeExists(ctx, env.Client, replacements[0])
+		Expect(ExpectPodExists(ctx, env.Client, pod.Name, pod.Namespace).Spec.NodeName).To(Equal(node.Name))
+	})
+	It("should not launch replacements for daemonset pods", func() {
+		ExpectCreated(ctx, env.Client, node)
+		ExpectCreated(ctx, env.Client, test.Pod(test.PodOptions{
+			NodeName:        node.Name,
+			OwnerReferences: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "DaemonSet", Name: "test-daemonset", UID: "test-uid"}},
+		}))
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(node))
+		node = ExpectNodeExists(ctx, env.Client, node.Name)
+		Expect(node.Spec.Unschedulable).To(BeTrue())
+		Expect(node.Annotations).To(HaveKeyWithValue(v1alpha5.ReplacementAnnotationKey, ""))
+	})
+	It("should not delete the node until its replacement is ready", func() {
+		replacementNode := test.Node(test.NodeOptions{ReadyStatus: v1.ConditionFalse})
+		node.Annotations[v1alpha5.ReplacementAnnotationKey] = replacementNode.Name
+		ExpectCreated(ctx, env.Client, node)
+		ExpectCreatedWithStatus(ctx, env.Client, replacementNode)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(node))
+		ExpectNodeExists(ctx, env.Client, node.Name)
+
+		replacementNode = ExpectNodeExists(ctx, env.Client, replacementNode.Name)
+		replacementNode.Status.Conditions = []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}}
+		ExpectStatusUpdated(ctx, env.Client, replacementNode)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(node))
+		ExpectNotFound(ctx, env.Client, node)
+	})
+	It("should not launch replacements again if they were launched but not recorded", func() {
+		// Launching replacements is recorded before they launch
+		node.Annotations[v1alpha5.ReplacementAnnotationKey] = ""
+		ExpectCreated(ctx, env.Client, node)
+		ExpectCreated(ctx, env.Client, test.Pod(test.PodOptions{NodeName: node.Name}))
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(node))
+		ExpectNotFound(ctx, env.Client, node)
+		nodes := &v1.NodeList{}
+		Expect(env.Client.List(ctx, nodes)).To(Succeed())
+		Expect(nodes.Items).To(BeEmpty())
+	})
+	It("should delete the node if its replacement no longer exists", func() {
+		node.Annotations[v1alpha5.ReplacementAnnotationKey] = "test-missing-node"
+		ExpectCreated(ctx, env.Client, node)
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(node))
+		ExpectNotFound(ctx, env.Client, node)
+	})
+})
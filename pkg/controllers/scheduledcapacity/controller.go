@@ -0,0 +1,278 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduledcapacity
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"knative.dev/pkg/logging"
+	controllerruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/controllers/disruption"
+	"github.com/aws/karpenter/pkg/controllers/provisioning"
+	"github.com/aws/karpenter/pkg/utils/functional"
+	"github.com/aws/karpenter/pkg/utils/injectabletime"
+	karpenterlogging "github.com/aws/karpenter/pkg/utils/logging"
+	"github.com/aws/karpenter/pkg/utils/pod"
+)
+
+const (
+	controllerName = "scheduledcapacity"
+	// RequeueInterval is how often capacity is retried if its provisioner
+	// isn't running
+	RequeueInterval = 10 * time.Second
+)
+
+// Controller launches scheduled capacity ahead of its windows, and releases it
+// after them. Nodes are launched through the provisioner, which counts them as
+// in flight for pending pods, and are annotated so that they aren't
+// deprovisioned for being empty or consolidated during the window. Once the
+// window ends, nodes that are still empty are terminated, within the
+// disruption budgets, and the others are left to the usual deprovisioners.
+type Controller struct {
+	kubeClient   client.Client
+	provisioners *provisioning.Controller
+	gatekeeper   *disruption.Gatekeeper
+}
+
+// NewController is a constructor
+func NewController(kubeClient client.Client, provisioners *provisioning.Controller, gatekeeper *disruption.Gatekeeper) *Controller {
+	return &Controller{
+		kubeClient:   kubeClient,
+		provisioners: provisioners,
+		gatekeeper:   gatekeeper,
+	}
+}
+
+// Reconcile launches or releases the capacity, depending on its window
+func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	ctx = karpenterlogging.WithReconcile(ctx, controllerName, "scheduledcapacity", req.Name)
+	stored := &v1alpha5.ScheduledCapacity{}
+	if err := c.kubeClient.Get(ctx, req.NamespacedName, stored); err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+	if !stored.DeletionTimestamp.IsZero() {
+		return c.finalize(ctx, stored)
+	}
+	if !functional.ContainsString(stored.Finalizers, v1alpha5.TerminationFinalizer) {
+		persisted := stored.DeepCopy()
+		stored.Finalizers = append(stored.Finalizers, v1alpha5.TerminationFinalizer)
+		if err := c.kubeClient.Patch(ctx, stored, client.MergeFrom(persisted)); err != nil {
+			return reconcile.Result{}, fmt.Errorf("adding finalizer, %w", err)
+		}
+	}
+	start, active, next, err := stored.Spec.Window(injectabletime.Now())
+	if err != nil {
+		return reconcile.Result{}, fmt.Errorf("computing window, %w", err)
+	}
+	// Release the capacity of a window that has ended
+	if stored.Status.WindowStart != nil && (!active || !stored.Status.WindowStart.Time.Equal(start)) {
+		if result, err := c.release(ctx, stored); err != nil || !result.IsZero() {
+			return result, err
+		}
+	}
+	if active && stored.Status.WindowStart == nil {
+		if result, err := c.reserve(ctx, stored, start); err != nil || !result.IsZero() {
+			return result, err
+		}
+	}
+	if next.IsZero() {
+		return reconcile.Result{}, nil
+	}
+	return reconcile.Result{RequeueAfter: next.Sub(injectabletime.Now())}, nil
+}
+
+// reserve launches the capacity for the window, annotating its nodes and
+// recording them in the status
+func (c *Controller) reserve(ctx context.Context, stored *v1alpha5.ScheduledCapacity, start time.Time) (reconcile.Result, error) {
+	provisioner, ok := c.provisioners.Get(stored.Spec.Provisioner)
+	if !ok {
+		// The provisioner may not be running yet
+		return reconcile.Result{RequeueAfter: RequeueInterval}, nil
+	}
+	launched, err := provisioner.Reserve(ctx, stored.Name, stored.Spec.Capacity)
+	if err != nil && len(launched) == 0 {
+		return reconcile.Result{}, fmt.Errorf("launching capacity, %w", err)
+	}
+	if err != nil {
+		logging.FromContext(ctx).Errorf("Launched %d node(s), %s", len(launched), err.Error())
+	}
+	for _, name := range launched {
+		if err := c.annotate(ctx, name, stored.Name); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+	persisted := stored.DeepCopy()
+	stored.Status.WindowStart = &metav1.Time{Time: start}
+	stored.Status.Nodes = launched
+	if err := c.kubeClient.Status().Patch(ctx, stored, client.MergeFrom(persisted)); err != nil {
+		return reconcile.Result{}, fmt.Errorf("patching status, %w", err)
+	}
+	logging.FromContext(ctx).Infof("Launched node(s) %v for window starting at %s", launched, start.Format(time.RFC3339))
+	return reconcile.Result{}, nil
+}
+
+// release terminates the nodes of the capacity that are empty, and removes the
+// annotation from the others, clearing the status once every node is released.
+// Nodes that the disruption budgets block are retried later.
+func (c *Controller) release(ctx context.Context, stored *v1alpha5.ScheduledCapacity) (reconcile.Result, error) {
+	remaining := []string{}
+	for _, name := range stored.Status.Nodes {
+		released, err := c.releaseNode(ctx, name)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		if !released {
+			remaining = append(remaining, name)
+		}
+	}
+	persisted := stored.DeepCopy()
+	stored.Status.Nodes = remaining
+	if len(remaining) == 0 {
+		stored.Status.WindowStart = nil
+	}
+	if err := c.kubeClient.Status().Patch(ctx, stored, client.MergeFrom(persisted)); err != nil {
+		return reconcile.Result{}, fmt.Errorf("patching status, %w", err)
+	}
+	if len(remaining) > 0 {
+		return reconcile.Result{RequeueAfter: disruption.RetryInterval}, nil
+	}
+	logging.FromContext(ctx).Infof("Released %d node(s) after window", len(persisted.Status.Nodes))
+	return reconcile.Result{}, nil
+}
+
+// releaseNode returns false if the node is empty, but its termination was
+// blocked by the disruption budgets
+func (c *Controller) releaseNode(ctx context.Context, name string) (bool, error) {
+	stored := &v1.Node{}
+	if err := c.kubeClient.Get(ctx, types.NamespacedName{Name: name}, stored); err != nil {
+		if errors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	if !stored.DeletionTimestamp.IsZero() {
+		return true, nil
+	}
+	empty, err := c.isEmpty(ctx, stored)
+	if err != nil {
+		return false, err
+	}
+	provisioner := &v1alpha5.Provisioner{}
+	if err := c.kubeClient.Get(ctx, types.NamespacedName{Name: stored.Labels[v1alpha5.ProvisionerNameLabelKey]}, provisioner); err != nil {
+		if !errors.IsNotFound(err) {
+			return false, err
+		}
+		empty = false
+	}
+	if empty {
+		disrupted, err := c.gatekeeper.Disrupt(ctx, provisioner, stored, "empty after its scheduled capacity window")
+		if err != nil {
+			return false, err
+		}
+		if disrupted {
+			logging.FromContext(ctx).Infof("Triggered termination of empty node %s", name)
+		}
+		return disrupted, nil
+	}
+	released := stored.DeepCopy()
+	delete(released.Annotations, v1alpha5.ScheduledCapacityAnnotationKey)
+	if err := c.kubeClient.Patch(ctx, released, client.MergeFrom(stored)); err != nil {
+		if errors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, fmt.Errorf("patching node, %w", err)
+	}
+	return true, nil
+}
+
+// finalize releases the capacity before the scheduled capacity is deleted
+func (c *Controller) finalize(ctx context.Context, stored *v1alpha5.ScheduledCapacity) (reconcile.Result, error) {
+	if !functional.ContainsString(stored.Finalizers, v1alpha5.TerminationFinalizer) {
+		return reconcile.Result{}, nil
+	}
+	if len(stored.Status.Nodes) > 0 {
+		if result, err := c.release(ctx, stored); err != nil || !result.IsZero() {
+			return result, err
+		}
+	}
+	persisted := stored.DeepCopy()
+	stored.Finalizers = functional.StringSliceWithout(stored.Finalizers, v1alpha5.TerminationFinalizer)
+	if err := c.kubeClient.Patch(ctx, stored, client.MergeFrom(persisted)); err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, fmt.Errorf("removing finalizer, %w", err)
+	}
+	return reconcile.Result{}, nil
+}
+
+// annotate marks the node as reserved by the scheduled capacity
+func (c *Controller) annotate(ctx context.Context, name string, scheduledCapacity string) error {
+	stored := &v1.Node{}
+	if err := c.kubeClient.Get(ctx, types.NamespacedName{Name: name}, stored); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	annotated := stored.DeepCopy()
+	annotated.Annotations = functional.UnionStringMaps(annotated.Annotations, map[string]string{v1alpha5.ScheduledCapacityAnnotationKey: scheduledCapacity})
+	if err := c.kubeClient.Patch(ctx, annotated, client.MergeFrom(stored)); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("patching node, %w", err)
+	}
+	return nil
+}
+
+// isEmpty returns true if the node runs no pods, other than daemons and static
+// pods
+func (c *Controller) isEmpty(ctx context.Context, n *v1.Node) (bool, error) {
+	pods := &v1.PodList{}
+	if err := c.kubeClient.List(ctx, pods, client.MatchingFields{"spec.nodeName": n.Name}); err != nil {
+		return false, fmt.Errorf("listing pods for node, %w", err)
+	}
+	for i := range pods.Items {
+		p := &pods.Items[i]
+		if pod.IsTerminal(p) || pod.IsOwnedByDaemonSet(p) || pod.IsOwnedByNode(p) {
+			continue
+		}
+		return false, nil
+	}
+	return true, nil
+}
+
+// Register the controller to the manager
+func (c *Controller) Register(_ context.Context, m manager.Manager) error {
+	return controllerruntime.
+		NewControllerManagedBy(m).
+		Named(controllerName).
+		For(&v1alpha5.ScheduledCapacity{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: 10}).
+		Complete(c)
+}
@@ -0,0 +1,186 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduledcapacity_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider/fake"
+	"github.com/aws/karpenter/pkg/cloudprovider/registry"
+	"github.com/aws/karpenter/pkg/controllers/disruption"
+	"github.com/aws/karpenter/pkg/controllers/provisioning"
+	"github.com/aws/karpenter/pkg/controllers/scheduledcapacity"
+	"github.com/aws/karpenter/pkg/controllers/state"
+	"github.com/aws/karpenter/pkg/events"
+	"github.com/aws/karpenter/pkg/test"
+	"github.com/aws/karpenter/pkg/utils/injectabletime"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"knative.dev/pkg/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	. "github.com/aws/karpenter/pkg/test/expectations"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "knative.dev/pkg/logging/testing"
+)
+
+var ctx context.Context
+var provisioningController *provisioning.Controller
+var controller *scheduledcapacity.Controller
+var env *test.Environment
+
+func TestAPIs(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "ScheduledCapacity")
+}
+
+var _ = BeforeSuite(func() {
+	env = test.NewEnvironment(ctx, func(e *test.Environment) {
+		cloudProvider := &fake.CloudProvider{}
+		registry.RegisterOrDie(ctx, cloudProvider)
+		provisioningController = provisioning.NewController(ctx, e.Client, corev1.NewForConfigOrDie(e.Config), cloudProvider, state.NewCluster(), events.NewRecorder(&record.FakeRecorder{}))
+		controller = scheduledcapacity.NewController(e.Client, provisioningController, disruption.NewGatekeeper(e.Client))
+	})
+	Expect(env.Start()).To(Succeed(), "Failed to start environment")
+})
+
+var _ = AfterSuite(func() {
+	Expect(env.Stop()).To(Succeed(), "Failed to stop environment")
+})
+
+var _ = Describe("ScheduledCapacity", func() {
+	var provisioner *v1alpha5.Provisioner
+	var scheduledCapacity *v1alpha5.ScheduledCapacity
+	// Windows start at 09:00 and last an hour
+	windowStart := time.Date(2021, time.January, 1, 9, 0, 0, 0, time.UTC)
+
+	BeforeEach(func() {
+		provisioner = &v1alpha5.Provisioner{
+			ObjectMeta: metav1.ObjectMeta{Name: v1alpha5.DefaultProvisioner.Name},
+			Spec:       v1alpha5.ProvisionerSpec{},
+		}
+		provisioner.SetDefaults(ctx)
+		scheduledCapacity = &v1alpha5.ScheduledCapacity{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-scheduled-capacity"},
+			Spec: v1alpha5.ScheduledCapacitySpec{
+				Provisioner: provisioner.Name,
+				Schedule:    "0 9 * * *",
+				Duration:    metav1.Duration{Duration: time.Hour},
+				Capacity:    []v1alpha5.Headroom{{Replicas: ptr.Int32(2), Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")}}},
+			},
+		}
+		ExpectApplied(ctx, env.Client, provisioner)
+		ExpectReconcileSucceeded(ctx, provisioningController, client.ObjectKeyFromObject(provisioner))
+	})
+
+	AfterEach(func() {
+		injectabletime.Now = time.Now
+		ExpectProvisioningCleanedUp(ctx, env.Client, provisioningController)
+	})
+
+	reconcileAt := func(now time.Time) *v1alpha5.ScheduledCapacity {
+		injectabletime.Now = func() time.Time { return now }
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(scheduledCapacity))
+		stored := &v1alpha5.ScheduledCapacity{}
+		Expect(env.Client.Get(ctx, client.ObjectKeyFromObject(scheduledCapacity), stored)).To(Succeed())
+		return stored
+	}
+
+	It("should not launch capacity before the lead of the window", func() {
+		ExpectCreated(ctx, env.Client, scheduledCapacity)
+		stored := reconcileAt(windowStart.Add(-time.Hour))
+		Expect(stored.Status.WindowStart).To(BeNil())
+		Expect(stored.Finalizers).To(ContainElement(v1alpha5.TerminationFinalizer))
+		nodes := &v1.NodeList{}
+		Expect(env.Client.List(ctx, nodes)).To(Succeed())
+		Expect(nodes.Items).To(BeEmpty())
+	})
+	It("should launch and annotate capacity during the lead of the window", func() {
+		ExpectCreated(ctx, env.Client, scheduledCapacity)
+		stored := reconcileAt(windowStart.Add(-5 * time.Minute))
+		Expect(stored.Status.WindowStart.Time.Equal(windowStart)).To(BeTrue())
+		Expect(stored.Status.Nodes).ToNot(BeEmpty())
+		for _, name := range stored.Status.Nodes {
+			node := ExpectNodeExists(ctx, env.Client, name)
+			Expect(node.Annotations).To(HaveKeyWithValue(v1alpha5.ScheduledCapacityAnnotationKey, scheduledCapacity.Name))
+		}
+	})
+	It("should only launch capacity once per window", func() {
+		ExpectCreated(ctx, env.Client, scheduledCapacity)
+		launched := reconcileAt(windowStart.Add(-5 * time.Minute)).Status.Nodes
+		Expect(reconcileAt(windowStart.Add(30 * time.Minute)).Status.Nodes).To(Equal(launched))
+		nodes := &v1.NodeList{}
+		Expect(env.Client.List(ctx, nodes)).To(Succeed())
+		Expect(nodes.Items).To(HaveLen(len(launched)))
+	})
+	It("should not launch capacity beyond the provisioner's node limit", func() {
+		provisioner.Spec.Limits.MaxNodes = ptr.Int32(0)
+		ExpectApplied(ctx, env.Client, provisioner)
+		ExpectReconcileSucceeded(ctx, provisioningController, client.ObjectKeyFromObject(provisioner))
+		ExpectCreated(ctx, env.Client, scheduledCapacity)
+		injectabletime.Now = func() time.Time { return windowStart.Add(-5 * time.Minute) }
+		ExpectReconcileFailed(ctx, controller, client.ObjectKeyFromObject(scheduledCapacity))
+		nodes := &v1.NodeList{}
+		Expect(env.Client.List(ctx, nodes)).To(Succeed())
+		Expect(nodes.Items).To(BeEmpty())
+	})
+	It("should terminate empty capacity after the window", func() {
+		ExpectCreated(ctx, env.Client, scheduledCapacity)
+		launched := reconcileAt(windowStart.Add(-5 * time.Minute)).Status.Nodes
+		stored := reconcileAt(windowStart.Add(2 * time.Hour))
+		Expect(stored.Status.WindowStart).To(BeNil())
+		Expect(stored.Status.Nodes).To(BeEmpty())
+		for _, name := range launched {
+			Expect(ExpectNodeExists(ctx, env.Client, name).DeletionTimestamp.IsZero()).To(BeFalse())
+		}
+	})
+	It("should release capacity that runs pods after the window", func() {
+		ExpectCreated(ctx, env.Client, scheduledCapacity)
+		launched := reconcileAt(windowStart.Add(-5 * time.Minute)).Status.Nodes
+		for _, name := range launched {
+			ExpectCreated(ctx, env.Client, test.Pod(test.PodOptions{NodeName: name}))
+		}
+		reconcileAt(windowStart.Add(2 * time.Hour))
+		for _, name := range launched {
+			node := ExpectNodeExists(ctx, env.Client, name)
+			Expect(node.DeletionTimestamp.IsZero()).To(BeTrue())
+			Expect(node.Annotations).ToNot(HaveKey(v1alpha5.ScheduledCapacityAnnotationKey))
+		}
+	})
+	It("should release capacity when deleted", func() {
+		ExpectCreated(ctx, env.Client, scheduledCapacity)
+		launched := reconcileAt(windowStart.Add(-5 * time.Minute)).Status.Nodes
+		Expect(env.Client.Delete(ctx, scheduledCapacity)).To(Succeed())
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(scheduledCapacity))
+		ExpectNotFound(ctx, env.Client, scheduledCapacity)
+		for _, name := range launched {
+			Expect(ExpectNodeExists(ctx, env.Client, name).DeletionTimestamp.IsZero()).To(BeFalse())
+		}
+	})
+	It("should requeue until the provisioner is running", func() {
+		scheduledCapacity.Spec.Provisioner = "unknown"
+		ExpectCreated(ctx, env.Client, scheduledCapacity)
+		Expect(reconcileAt(windowStart.Add(-5 * time.Minute)).Status.WindowStart).To(BeNil())
+	})
+})
@@ -19,14 +19,28 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
 	"github.com/aws/karpenter/pkg/controllers/provisioning"
+	"github.com/aws/karpenter/pkg/controllers/provisioning/scheduling"
+	"github.com/aws/karpenter/pkg/events"
+	"github.com/aws/karpenter/pkg/tracing"
+	"github.com/aws/karpenter/pkg/utils/functional"
+	"github.com/aws/karpenter/pkg/utils/injection"
+	karpenterlogging "github.com/aws/karpenter/pkg/utils/logging"
 	"github.com/aws/karpenter/pkg/utils/pod"
 	"github.com/go-logr/zapr"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/multierr"
 	"go.uber.org/zap"
+	"golang.org/x/time/rate"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/util/workqueue"
 	"knative.dev/pkg/logging"
 	controllerruntime "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -35,27 +49,35 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
-const controllerName = "selection"
+const (
+	controllerName = "selection"
+	// NamespaceNodeSelectorAnnotationKey is the annotation used by the
+	// PodNodeSelector admission plugin to set a default node selector for all
+	// pods in a namespace, e.g. "env=prod,team=a".
+	NamespaceNodeSelectorAnnotationKey = "scheduler.alpha.kubernetes.io/node-selector"
+)
 
 // Controller for the resource
 type Controller struct {
 	kubeClient   client.Client
 	provisioners *provisioning.Controller
 	preferences  *Preferences
+	recorder     events.Recorder
 }
 
 // NewController constructs a controller instance
-func NewController(kubeClient client.Client, provisioners *provisioning.Controller) *Controller {
+func NewController(kubeClient client.Client, provisioners *provisioning.Controller, recorder events.Recorder) *Controller {
 	return &Controller{
 		kubeClient:   kubeClient,
 		provisioners: provisioners,
 		preferences:  NewPreferences(),
+		recorder:     recorder,
 	}
 }
 
 // Reconcile the resource
 func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
-	ctx = logging.WithLogger(ctx, logging.FromContext(ctx).Named(controllerName).With("pod", req.String()))
+	ctx = karpenterlogging.WithReconcile(ctx, controllerName, "pod", req.String())
 	pod := &v1.Pod{}
 	if err := c.kubeClient.Get(ctx, req.NamespacedName, pod); err != nil {
 		if errors.IsNotFound(err) {
@@ -67,49 +89,144 @@ func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reco
 	if !isProvisionable(pod) {
 		return reconcile.Result{}, nil
 	}
+	if err := applyNamespaceNodeSelector(ctx, c.kubeClient, pod); err != nil {
+		return reconcile.Result{}, err
+	}
 	if err := validate(pod); err != nil {
 		logging.FromContext(ctx).Debugf("Ignoring pod, %s", err.Error())
-		return reconcile.Result{}, nil
+		c.recorder.ProvisioningFailed(pod, fmt.Errorf("unsupported scheduling constraints, %w", err))
+		return requeuePending(ctx), nil
+	}
+	// Deferring isn't a failed attempt to schedule, so it's checked before preferences are relaxed
+	deferral, err := c.deferToPreemption(ctx, pod)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if deferral > 0 {
+		logging.FromContext(ctx).Debugf("Deferring to preemption of lower priority pods by kube-scheduler")
+		return reconcile.Result{RequeueAfter: deferral}, nil
 	}
 	// Select a provisioner, wait for it to bind the pod, and verify scheduling succeeded in the next loop
+	ctx, span := tracing.Start(ctx, "selection.SelectProvisioner", trace.WithAttributes(attribute.String(tracing.PodAttribute, req.String())))
+	defer span.End()
 	if err := c.selectProvisioner(ctx, pod); err != nil {
+		tracing.SetError(span, err)
 		logging.FromContext(ctx).Debugf("Could not schedule pod, %s", err.Error())
 		return reconcile.Result{}, err
 	}
 	return reconcile.Result{RequeueAfter: time.Second * 1}, nil
 }
 
-func (c *Controller) selectProvisioner(ctx context.Context, pod *v1.Pod) (errs error) {
+func (c *Controller) selectProvisioner(ctx context.Context, pod *v1.Pod) error {
 	// Relax preferences if pod has previously failed to schedule.
 	c.preferences.Relax(ctx, pod)
 	// Pick provisioner
-	var provisioner *provisioning.Provisioner
 	provisioners := c.provisioners.List(ctx)
-	if len(provisioners) == 0 {
+	_, named := pod.Labels[v1alpha5.ProvisionerNameLabelKey]
+	if !named && len(provisioners) == 0 {
+		c.recorder.ProvisioningFailed(pod, fmt.Errorf("no provisioners exist"))
 		return nil
 	}
-	for _, candidate := range c.provisioners.List(ctx) {
-		if err := candidate.Spec.DeepCopy().ValidatePod(pod); err != nil {
-			errs = multierr.Append(errs, fmt.Errorf("tried provisioner/%s: %w", candidate.Name, err))
+	candidates := []*v1alpha5.Provisioner{}
+	for _, provisioner := range provisioners {
+		candidates = append(candidates, provisioner.Provisioner)
+	}
+	selected, err := Select(pod, candidates, c.provisioners.Capabilities())
+	if err != nil {
+		if named {
+			c.recorder.ProvisionerSelectionFailed(pod, err)
 		} else {
-			provisioner = candidate
-			break
+			c.recorder.ProvisioningFailed(pod, err)
+		}
+		return err
+	}
+	for _, provisioner := range provisioners {
+		if provisioner.Provisioner == selected {
+			provisioner.Add(ctx, pod)
+		}
+	}
+	return nil
+}
+
+// Select returns the provisioner that provisions the pod. The provisioner named
+// by the pod's label is a hard constraint, and other provisioners are never
+// considered for the pod. Otherwise, it's the first of the provisioners, in
+// order of priority, that the pod is compatible with. Compatibility is decided by
+// the constraint plugins, including the capabilities of the cloud provider.
+func Select(pod *v1.Pod, provisioners []*v1alpha5.Provisioner, capabilities cloudprovider.Capabilities) (*v1alpha5.Provisioner, error) {
+	supported := &scheduling.Capabilities{Capabilities: capabilities}
+	if name, ok := pod.Labels[v1alpha5.ProvisionerNameLabelKey]; ok {
+		for _, provisioner := range provisioners {
+			if provisioner.Name != name {
+				continue
+			}
+			if err := scheduling.Filter(pod, provisioner.Spec.Constraints.DeepCopy(), supported); err != nil {
+				return nil, fmt.Errorf("incompatible with provisioner/%s, %w", name, err)
+			}
+			return provisioner, nil
 		}
+		return nil, fmt.Errorf("provisioner/%s not found", name)
 	}
-	if provisioner == nil {
-		return fmt.Errorf("matched 0/%d provisioners, %w", len(multierr.Errors(errs)), errs)
+	if len(provisioners) == 0 {
+		return nil, fmt.Errorf("no provisioners exist")
+	}
+	var errs error
+	for _, provisioner := range provisioners {
+		err := scheduling.Filter(pod, provisioner.Spec.Constraints.DeepCopy(), supported)
+		if err == nil {
+			return provisioner, nil
+		}
+		errs = multierr.Append(errs, fmt.Errorf("tried provisioner/%s: %w", provisioner.Name, err))
+	}
+	return nil, fmt.Errorf("matched 0/%d provisioners, %w", len(multierr.Errors(errs)), errs)
+}
+
+// applyNamespaceNodeSelector merges the default node selector of the pod's
+// namespace into the pod's node selector, so that it is solved the same way as
+// pods admitted by the PodNodeSelector admission plugin. The pod's own node
+// selector takes precedence. The pod is only modified in memory.
+func applyNamespaceNodeSelector(ctx context.Context, kubeClient client.Client, pod *v1.Pod) error {
+	namespace := &v1.Namespace{}
+	if err := kubeClient.Get(ctx, types.NamespacedName{Name: pod.Namespace}, namespace); err != nil {
+		return fmt.Errorf("getting namespace %s, %w", pod.Namespace, err)
+	}
+	selector, ok := namespace.Annotations[NamespaceNodeSelectorAnnotationKey]
+	if !ok {
+		return nil
+	}
+	nodeSelector, err := labels.ConvertSelectorToLabelsMap(selector)
+	if err != nil {
+		return fmt.Errorf("parsing node selector of namespace %s, %w", pod.Namespace, err)
 	}
-	provisioner.Add(ctx, pod)
+	pod.Spec.NodeSelector = functional.UnionStringMaps(nodeSelector, pod.Spec.NodeSelector)
 	return nil
 }
 
+// requeuePending re-examines a skipped pod after the pending pod requeue
+// interval, so that it is reconsidered if the pod or its provisioners change.
+func requeuePending(ctx context.Context) reconcile.Result {
+	return reconcile.Result{RequeueAfter: injection.GetOptions(ctx).PendingPodRequeueInterval}
+}
+
 func isProvisionable(p *v1.Pod) bool {
-	return !pod.IsScheduled(p) &&
-		!pod.IsPreempting(p) &&
-		pod.FailedToSchedule(p) &&
-		!pod.IsOwnedByDaemonSet(p) &&
-		!pod.IsOwnedByNode(p)
+	return provisionable(p) == nil
+}
 
+// provisionable returns why Karpenter ignores the pod, or nil if it doesn't
+func provisionable(p *v1.Pod) error {
+	switch {
+	case pod.IsScheduled(p):
+		return fmt.Errorf("scheduled to node %s", p.Spec.NodeName)
+	case pod.IsPreempting(p):
+		return fmt.Errorf("nominated to node %s, preempting lower priority pods", p.Status.NominatedNodeName)
+	case !pod.FailedToSchedule(p):
+		return fmt.Errorf("not marked unschedulable by kube-scheduler")
+	case pod.IsOwnedByDaemonSet(p):
+		return fmt.Errorf("owned by a daemonset")
+	case pod.IsOwnedByNode(p):
+		return fmt.Errorf("static pod")
+	}
+	return nil
 }
 
 func validate(p *v1.Pod) error {
@@ -157,7 +274,7 @@ func validateNodeSelectorTerm(term v1.NodeSelectorTerm) (errs error) {
 	}
 	if term.MatchExpressions != nil {
 		for _, requirement := range term.MatchExpressions {
-			if !sets.NewString(string(v1.NodeSelectorOpIn), string(v1.NodeSelectorOpNotIn)).Has(string(requirement.Operator)) {
+			if !sets.NewString(v1alpha5.SupportedNodeSelectorOps...).Has(string(requirement.Operator)) {
 				errs = multierr.Append(errs, fmt.Errorf("node selector term has unsupported operator, %s", requirement.Operator))
 			}
 		}
@@ -165,12 +282,27 @@ func validateNodeSelectorTerm(term v1.NodeSelectorTerm) (errs error) {
 	return errs
 }
 
-func (c *Controller) Register(_ context.Context, m manager.Manager) error {
+// rateLimiter caps the backoff of pods that fail selection at maxDelay, so
+// that transient capacity errors are retried without recreating the pod.
+func rateLimiter(maxDelay time.Duration) workqueue.RateLimiter {
+	if maxDelay <= 0 {
+		return workqueue.DefaultControllerRateLimiter()
+	}
+	return workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(5*time.Millisecond, maxDelay),
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(10), 100)},
+	)
+}
+
+func (c *Controller) Register(ctx context.Context, m manager.Manager) error {
 	return controllerruntime.
 		NewControllerManagedBy(m).
 		Named(controllerName).
 		For(&v1.Pod{}).
-		WithOptions(controller.Options{MaxConcurrentReconciles: 10_000}).
+		WithOptions(controller.Options{
+			MaxConcurrentReconciles: 10_000,
+			RateLimiter:             rateLimiter(injection.GetOptions(ctx).PendingPodRequeueInterval),
+		}).
 		WithLogger(zapr.NewLogger(zap.NewNop())).
 		Complete(c)
 }
@@ -0,0 +1,61 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package selection
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Explain returns the provisioner that would provision the pending pod, or why
+// none would, by the same checks that the controller makes before it adds the
+// pod to a provisioner's batch. The provisioners' requirements are expected to
+// be refreshed from their instance types. The pod is only modified in memory.
+func Explain(ctx context.Context, kubeClient client.Client, capabilities cloudprovider.Capabilities, pod *v1.Pod, provisioners []*v1alpha5.Provisioner) (*v1alpha5.Provisioner, error) {
+	if err := provisionable(pod); err != nil {
+		return nil, fmt.Errorf("ignored, %w", err)
+	}
+	if err := Validate(ctx, kubeClient, pod); err != nil {
+		return nil, err
+	}
+	deferral, err := DeferToPreemption(ctx, kubeClient, capabilities, pod, provisioners)
+	if err != nil {
+		return nil, err
+	}
+	if deferral > 0 {
+		return nil, fmt.Errorf("deferring to preemption of lower priority pods by kube-scheduler for %s", deferral.Round(time.Second))
+	}
+	return Select(pod, provisioners, capabilities)
+}
+
+// Validate applies the pod's namespace node selector and rejects scheduling
+// constraints that Karpenter does not support. Constraints that the cloud
+// provider does not support are rejected when a provisioner is selected. The
+// pod is only modified in memory.
+func Validate(ctx context.Context, kubeClient client.Client, pod *v1.Pod) error {
+	if err := applyNamespaceNodeSelector(ctx, kubeClient, pod); err != nil {
+		return err
+	}
+	if err := validate(pod); err != nil {
+		return fmt.Errorf("unsupported scheduling constraints, %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package selection
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/controllers/state"
+	"github.com/aws/karpenter/pkg/utils/injectabletime"
+	"github.com/aws/karpenter/pkg/utils/node"
+	"github.com/aws/karpenter/pkg/utils/pod"
+	"github.com/aws/karpenter/pkg/utils/resources"
+)
+
+// PreemptionTimeout is how long after failing to schedule that pods are
+// deferred to preemption. Nodes are launched for them afterwards, in case
+// kube-scheduler doesn't preempt, e.g. because the Pod Disruption Budgets of
+// the lower priority pods don't allow it.
+var PreemptionTimeout = time.Minute
+
+// deferToPreemption returns how long until the preemption times out if the
+// pod's provisioner defers to preemption, and kube-scheduler could schedule the
+// pod on an existing node by preempting lower priority pods, or zero if it
+// doesn't defer. Once kube-scheduler nominates a node for the pod, the pod is
+// no longer provisionable, and the preempted pods are provisioned instead. The
+// provisioner is selected with the pod's unrelaxed preferences, as they're
+// what kube-scheduler preempts for.
+func (c *Controller) deferToPreemption(ctx context.Context, p *v1.Pod) (time.Duration, error) {
+	candidates := []*v1alpha5.Provisioner{}
+	for _, provisioner := range c.provisioners.List(ctx) {
+		candidates = append(candidates, provisioner.Provisioner)
+	}
+	return DeferToPreemption(ctx, c.kubeClient, c.provisioners.Capabilities(), p, candidates)
+}
+
+// DeferToPreemption returns how long the pod is deferred to preemption when
+// selecting from the provisioners, or zero if it isn't
+func DeferToPreemption(ctx context.Context, kubeClient client.Client, capabilities cloudprovider.Capabilities, p *v1.Pod, provisioners []*v1alpha5.Provisioner) (time.Duration, error) {
+	if p.Spec.PreemptionPolicy != nil && *p.Spec.PreemptionPolicy == v1.PreemptNever {
+		return 0, nil
+	}
+	deferral := PreemptionTimeout
+	if since, ok := unschedulableSince(p); ok {
+		deferral = since.Add(PreemptionTimeout).Sub(injectabletime.Now())
+	}
+	if deferral <= 0 {
+		return 0, nil
+	}
+	provisioner, err := Select(p, provisioners, capabilities)
+	if err != nil || provisioner.Spec.GetPreemptionPolicy() != v1alpha5.PreemptionPolicyDefer {
+		return 0, nil
+	}
+	nodes := &v1.NodeList{}
+	if err := kubeClient.List(ctx, nodes); err != nil {
+		return 0, fmt.Errorf("listing nodes, %w", err)
+	}
+	for i := range nodes.Items {
+		n := &nodes.Items[i]
+		if !n.DeletionTimestamp.IsZero() || !node.IsReady(n) || n.Spec.Unschedulable {
+			continue
+		}
+		preemptible, err := fitsByPreempting(ctx, kubeClient, n, p)
+		if err != nil {
+			return 0, err
+		}
+		if preemptible {
+			return deferral, nil
+		}
+	}
+	return 0, nil
+}
+
+// fitsByPreempting returns true if the pod fits on the node once the node's
+// lower priority pods are preempted. Nodes without lower priority pods are
+// ignored, since the pod would have been scheduled on them if it fit.
+func fitsByPreempting(ctx context.Context, kubeClient client.Client, n *v1.Node, p *v1.Pod) (bool, error) {
+	candidate := &state.InFlightNode{Node: n}
+	if !candidate.Compatible(p) {
+		return false, nil
+	}
+	pods := &v1.PodList{}
+	if err := kubeClient.List(ctx, pods, client.MatchingFields{"spec.nodeName": n.Name}); err != nil {
+		return false, fmt.Errorf("listing pods for node, %w", err)
+	}
+	remaining := []*v1.Pod{}
+	victims := 0
+	for i := range pods.Items {
+		scheduled := &pods.Items[i]
+		if pod.IsTerminal(scheduled) {
+			continue
+		}
+		if priority(scheduled) < priority(p) {
+			victims++
+			continue
+		}
+		remaining = append(remaining, scheduled)
+	}
+	if victims == 0 {
+		return false, nil
+	}
+	candidate.Requested = resources.RequestsForPods(remaining...)
+	candidate.Requested[v1.ResourcePods] = *resource.NewQuantity(int64(len(remaining)), resource.DecimalSI)
+	_, fits := candidate.Fits(p)
+	return fits, nil
+}
+
+// unschedulableSince returns when kube-scheduler first failed to schedule the
+// pod
+func unschedulableSince(p *v1.Pod) (time.Time, bool) {
+	for _, condition := range p.Status.Conditions {
+		if condition.Type == v1.PodScheduled && condition.Reason == v1.PodReasonUnschedulable {
+			return condition.LastTransitionTime.Time, !condition.LastTransitionTime.IsZero()
+		}
+	}
+	return time.Time{}, false
+}
+
+func priority(p *v1.Pod) int32 {
+	if p.Spec.Priority == nil {
+		return 0
+	}
+	return *p.Spec.Priority
+}
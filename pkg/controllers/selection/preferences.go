@@ -20,11 +20,14 @@ import (
 	"sort"
 	"time"
 
+	"github.com/aws/karpenter/pkg/metrics"
 	"github.com/aws/karpenter/pkg/utils/pretty"
 	"github.com/patrickmn/go-cache"
+	"github.com/prometheus/client_golang/prometheus"
 	v1 "k8s.io/api/core/v1"
 	"knative.dev/pkg/logging"
 	"knative.dev/pkg/ptr"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
 )
 
 const (
@@ -37,9 +40,11 @@ type Preferences struct {
 }
 
 func NewPreferences() *Preferences {
-	return &Preferences{
+	p := &Preferences{
 		cache: cache.New(ExpirationTTL, CleanupInterval),
 	}
+	p.cache.OnEvicted(func(string, interface{}) { preferencesCacheSizeGauge.Set(float64(p.cache.ItemCount())) })
+	return p
 }
 
 // Relax removes soft preferences from pod to enable scheduling if the cloud
@@ -51,9 +56,12 @@ func (p *Preferences) Relax(ctx context.Context, pod *v1.Pod) {
 	affinity, ok := p.cache.Get(string(pod.UID))
 	// Add to cache if we've never seen it before
 	if !ok {
+		preferencesCacheLookupCounter.WithLabelValues("miss").Inc()
 		p.cache.SetDefault(string(pod.UID), pod.Spec.Affinity)
+		preferencesCacheSizeGauge.Set(float64(p.cache.ItemCount()))
 		return
 	}
+	preferencesCacheLookupCounter.WithLabelValues("hit").Inc()
 	// Attempt to relax the pod and update the cache
 	pod.Spec.Affinity = affinity.(*v1.Affinity)
 	if relaxed := p.relax(ctx, pod); relaxed {
@@ -104,3 +112,27 @@ func (p *Preferences) removeRequiredNodeAffinityTerm(pod *v1.Pod) *string {
 	}
 	return nil
 }
+
+var (
+	preferencesCacheSizeGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: "selection_controller",
+			Name:      "preferences_cache_size",
+			Help:      "Number of pods whose scheduling preferences are cached for relaxation.",
+		},
+	)
+	preferencesCacheLookupCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: "selection_controller",
+			Name:      "preferences_cache_lookups_total",
+			Help:      "Number of lookups of cached scheduling preferences. Broken down by result, hit or miss.",
+		},
+		[]string{"result"},
+	)
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(preferencesCacheSizeGauge, preferencesCacheLookupCounter)
+}
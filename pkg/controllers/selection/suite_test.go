@@ -16,17 +16,30 @@ package selection_test
 
 import (
 	"context"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/Pallinder/go-randomdata"
 	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
 	"github.com/aws/karpenter/pkg/cloudprovider/fake"
 	"github.com/aws/karpenter/pkg/cloudprovider/registry"
 	"github.com/aws/karpenter/pkg/controllers/provisioning"
 	"github.com/aws/karpenter/pkg/controllers/selection"
+	"github.com/aws/karpenter/pkg/controllers/state"
+	"github.com/aws/karpenter/pkg/events"
 	"github.com/aws/karpenter/pkg/test"
+	"github.com/aws/karpenter/pkg/utils/injectabletime"
 
+	v1 "k8s.io/api/core/v1"
+	schedulingv1 "k8s.io/api/scheduling/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"knative.dev/pkg/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	. "github.com/aws/karpenter/pkg/test/expectations"
 	. "github.com/onsi/ginkgo"
@@ -38,6 +51,8 @@ var ctx context.Context
 var provisioner *v1alpha5.Provisioner
 var provisioners *provisioning.Controller
 var selectionController *selection.Controller
+var cluster *state.Cluster
+var recorder *record.FakeRecorder
 var env *test.Environment
 
 func TestAPIs(t *testing.T) {
@@ -50,8 +65,10 @@ var _ = BeforeSuite(func() {
 	env = test.NewEnvironment(ctx, func(e *test.Environment) {
 		cloudProvider := &fake.CloudProvider{}
 		registry.RegisterOrDie(ctx, cloudProvider)
-		provisioners = provisioning.NewController(ctx, e.Client, corev1.NewForConfigOrDie(e.Config), cloudProvider)
-		selectionController = selection.NewController(e.Client, provisioners)
+		recorder = record.NewFakeRecorder(100)
+		cluster = state.NewCluster()
+		provisioners = provisioning.NewController(ctx, e.Client, corev1.NewForConfigOrDie(e.Config), cloudProvider, cluster, events.NewRecorder(recorder))
+		selectionController = selection.NewController(e.Client, provisioners, events.NewRecorder(recorder))
 	})
 	Expect(env.Start()).To(Succeed(), "Failed to start environment")
 })
@@ -66,10 +83,14 @@ var _ = BeforeEach(func() {
 		Spec:       v1alpha5.ProvisionerSpec{},
 	}
 	provisioner.SetDefaults(ctx)
+	for len(recorder.Events) > 0 {
+		<-recorder.Events
+	}
 })
 
 var _ = AfterEach(func() {
 	ExpectProvisioningCleanedUp(ctx, env.Client, provisioners)
+	ExpectClusterStateCleanedUp(cluster)
 })
 
 var _ = Describe("Multiple Provisioners", func() {
@@ -83,6 +104,59 @@ var _ = Describe("Multiple Provisioners", func() {
 		node := ExpectScheduled(ctx, env.Client, pod)
 		Expect(node.Labels[v1alpha5.ProvisionerNameLabelKey]).To(Equal(provisioner2.Name))
 	})
+	It("should schedule to a provisioner selected by the pod's label", func() {
+		provisioner2 := provisioner.DeepCopy()
+		provisioner2.Name = "provisioner2"
+		ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner2)
+		pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner,
+			test.UnschedulablePod(test.PodOptions{Labels: map[string]string{v1alpha5.ProvisionerNameLabelKey: provisioner2.Name}}),
+		)[0]
+		node := ExpectScheduled(ctx, env.Client, pod)
+		Expect(node.Labels[v1alpha5.ProvisionerNameLabelKey]).To(Equal(provisioner2.Name))
+	})
+	It("should schedule to a provisioner whose taints the pod tolerates", func() {
+		provisioner.Spec.Taints = []v1.Taint{{Key: "test-key", Value: "test-value", Effect: v1.TaintEffectNoSchedule}}
+		provisioner2 := provisioner.DeepCopy()
+		provisioner2.Name = "provisioner2"
+		provisioner2.Spec.Taints = nil
+		ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner2)
+		pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod())[0]
+		node := ExpectScheduled(ctx, env.Client, pod)
+		Expect(node.Labels[v1alpha5.ProvisionerNameLabelKey]).To(Equal(provisioner2.Name))
+		Expect(node.Spec.Taints).ToNot(ContainElement(provisioner.Spec.Taints[0]))
+	})
+	It("should not schedule to a provisioner whose taints the pod only partially tolerates", func() {
+		provisioner.Spec.Taints = []v1.Taint{
+			{Key: "test-key", Value: "test-value", Effect: v1.TaintEffectNoSchedule},
+			{Key: "test-key", Value: "test-value", Effect: v1.TaintEffectPreferNoSchedule},
+		}
+		pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod(test.PodOptions{
+			Tolerations: []v1.Toleration{{Key: "test-key", Operator: v1.TolerationOpEqual, Value: "test-value", Effect: v1.TaintEffectNoSchedule}},
+		}))[0]
+		ExpectNotScheduled(ctx, env.Client, pod)
+		Expect(recorder.Events).To(Receive(ContainSubstring("did not tolerate test-key=test-value:PreferNoSchedule")))
+	})
+	It("should not schedule if the provisioner selected by the pod's label does not exist", func() {
+		pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner,
+			test.UnschedulablePod(test.PodOptions{Labels: map[string]string{v1alpha5.ProvisionerNameLabelKey: "does-not-exist"}}),
+		)[0]
+		ExpectNotScheduled(ctx, env.Client, pod)
+		Expect(recorder.Events).To(Receive(ContainSubstring("provisioner/does-not-exist not found")))
+	})
+	It("should not schedule if the provisioner selected by the pod's label does not match", func() {
+		provisioner2 := provisioner.DeepCopy()
+		provisioner2.Name = "provisioner2"
+		provisioner2.Spec.Labels = map[string]string{"foo": "bar"}
+		ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner2)
+		pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner,
+			test.UnschedulablePod(test.PodOptions{
+				Labels:       map[string]string{v1alpha5.ProvisionerNameLabelKey: provisioner2.Name},
+				NodeSelector: map[string]string{"foo": "baz"},
+			}),
+		)[0]
+		ExpectNotScheduled(ctx, env.Client, pod)
+		Expect(recorder.Events).To(Receive(ContainSubstring("incompatible with provisioner/provisioner2")))
+	})
 	It("should schedule to a provisioner by labels", func() {
 		provisioner2 := provisioner.DeepCopy()
 		provisioner2.Name = "provisioner2"
@@ -104,3 +178,156 @@ var _ = Describe("Multiple Provisioners", func() {
 		Expect(node.Labels[v1alpha5.ProvisionerNameLabelKey]).To(Equal(provisioner2.Name))
 	})
 })
+
+var _ = Describe("Namespace Node Selectors", func() {
+	var namespace *v1.Namespace
+	BeforeEach(func() {
+		namespace = &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: strings.ToLower(randomdata.SillyName())}}
+	})
+	It("should schedule using the namespace's node selector", func() {
+		provisioner2 := provisioner.DeepCopy()
+		provisioner2.Name = "provisioner2"
+		provisioner2.Spec.Labels = map[string]string{"foo": "bar"}
+		provisioner.Spec.Labels = map[string]string{"foo": "baz"}
+		ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner2)
+		namespace.Annotations = map[string]string{selection.NamespaceNodeSelectorAnnotationKey: "foo=bar"}
+		ExpectCreated(ctx, env.Client, namespace)
+		pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod(test.PodOptions{Namespace: namespace.Name}))[0]
+		node := ExpectScheduled(ctx, env.Client, pod)
+		Expect(node.Labels[v1alpha5.ProvisionerNameLabelKey]).To(Equal(provisioner2.Name))
+		Expect(node.Labels).To(HaveKeyWithValue("foo", "bar"))
+	})
+	It("should prefer the pod's node selector over the namespace's", func() {
+		provisioner.Spec.Labels = map[string]string{"foo": "baz"}
+		namespace.Annotations = map[string]string{selection.NamespaceNodeSelectorAnnotationKey: "foo=bar"}
+		ExpectCreated(ctx, env.Client, namespace)
+		pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod(test.PodOptions{
+			Namespace:    namespace.Name,
+			NodeSelector: map[string]string{"foo": "baz"},
+		}))[0]
+		ExpectScheduled(ctx, env.Client, pod)
+	})
+	It("should not schedule if no provisioner matches the namespace's node selector", func() {
+		namespace.Annotations = map[string]string{selection.NamespaceNodeSelectorAnnotationKey: "foo=bar"}
+		ExpectCreated(ctx, env.Client, namespace)
+		pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod(test.PodOptions{Namespace: namespace.Name}))[0]
+		ExpectNotScheduled(ctx, env.Client, pod)
+	})
+})
+
+var _ = Describe("Diagnosis", func() {
+	It("should emit an event if the pod's scheduling constraints are unsupported", func() {
+		pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod(test.PodOptions{
+			TopologySpreadConstraints: []v1.TopologySpreadConstraint{{TopologyKey: "unknown", MaxSkew: 1, WhenUnsatisfiable: v1.DoNotSchedule}},
+		}))[0]
+		ExpectNotScheduled(ctx, env.Client, pod)
+		Expect(recorder.Events).To(Receive(ContainSubstring("unsupported topology key")))
+	})
+	It("should emit an event if the pod requires a capacity type that the cloud provider doesn't support", func() {
+		pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod(test.PodOptions{
+			NodeSelector: map[string]string{v1alpha5.LabelCapacityType: "reserved"},
+		}))[0]
+		ExpectNotScheduled(ctx, env.Client, pod)
+		Expect(recorder.Events).To(Receive(ContainSubstring("karpenter.sh/capacity-type [reserved] is not supported by this cloud provider")))
+	})
+	It("should emit an event if the pod does not tolerate any provisioner's taints", func() {
+		provisioner.Spec.Taints = []v1.Taint{{Key: "test-key", Value: "test-value", Effect: v1.TaintEffectNoSchedule}}
+		pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod())[0]
+		ExpectNotScheduled(ctx, env.Client, pod)
+		Expect(recorder.Events).To(Receive(And(
+			ContainSubstring("matched 0/1 provisioners"),
+			ContainSubstring("did not tolerate test-key=test-value:NoSchedule"),
+		)))
+	})
+	It("should emit an event if the pod does not fit any instance type", func() {
+		pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, test.UnschedulablePod(test.PodOptions{
+			ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("10000")}},
+		}))[0]
+		ExpectNotScheduled(ctx, env.Client, pod)
+		Expect(recorder.Events).To(Receive(ContainSubstring("no instance type option has enough resources for requests cpu=10k")))
+	})
+})
+
+var _ = Describe("Preemption", func() {
+	var priorityClass *schedulingv1.PriorityClass
+	var node *v1.Node
+
+	BeforeEach(func() {
+		provisioner.Spec.PreemptionPolicy = v1alpha5.PreemptionPolicyDefer
+		priorityClass = &schedulingv1.PriorityClass{ObjectMeta: metav1.ObjectMeta{Name: strings.ToLower(randomdata.SillyName())}, Value: 100}
+		node = test.Node(test.NodeOptions{Allocatable: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4"), v1.ResourcePods: resource.MustParse("10")}})
+		ExpectCreated(ctx, env.Client, priorityClass)
+		ExpectCreatedWithStatus(ctx, env.Client, node)
+	})
+	AfterEach(func() {
+		injectabletime.Now = time.Now
+		ExpectDeleted(ctx, env.Client, priorityClass)
+	})
+
+	scheduledPod := func(priorityClassName string) *v1.Pod {
+		scheduled := test.Pod(test.PodOptions{
+			NodeName:             node.Name,
+			ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("3")}},
+		})
+		if priorityClassName != "" {
+			scheduled.Spec.PriorityClassName = priorityClassName
+			scheduled.Spec.Priority = ptr.Int32(priorityClass.Value)
+		}
+		return scheduled
+	}
+	pendingPod := func() *v1.Pod {
+		pending := test.UnschedulablePod(test.PodOptions{
+			ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("2")}},
+		})
+		pending.Spec.PriorityClassName = priorityClass.Name
+		pending.Spec.Priority = ptr.Int32(priorityClass.Value)
+		return pending
+	}
+
+	It("should defer to preemption of lower priority pods", func() {
+		ExpectCreated(ctx, env.Client, scheduledPod(""))
+		pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, pendingPod())[0]
+		ExpectNotScheduled(ctx, env.Client, pod)
+	})
+	It("should requeue deferred pods once the preemption times out", func() {
+		ExpectCreated(ctx, env.Client, scheduledPod(""))
+		pending := pendingPod()
+		pending.Status.Conditions[0].LastTransitionTime = metav1.Now()
+		ExpectCreatedWithStatus(ctx, env.Client, pending)
+		result, err := selectionController.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(pending)})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.RequeueAfter).To(BeNumerically("~", selection.PreemptionTimeout, 5*time.Second))
+	})
+	It("should launch nodes regardless if the provisioner doesn't defer to preemption", func() {
+		provisioner.Spec.PreemptionPolicy = v1alpha5.PreemptionPolicyLaunch
+		ExpectCreated(ctx, env.Client, scheduledPod(""))
+		pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, pendingPod())[0]
+		Expect(ExpectScheduled(ctx, env.Client, pod).Name).ToNot(Equal(node.Name))
+	})
+	It("should launch nodes if preempting lower priority pods wouldn't make room", func() {
+		ExpectCreated(ctx, env.Client, scheduledPod(""), scheduledPod(priorityClass.Name))
+		pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, pendingPod())[0]
+		ExpectScheduled(ctx, env.Client, pod)
+	})
+	It("should launch nodes if there are no lower priority pods", func() {
+		ExpectCreated(ctx, env.Client, scheduledPod(priorityClass.Name))
+		pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, pendingPod())[0]
+		ExpectScheduled(ctx, env.Client, pod)
+	})
+	It("should launch nodes for pods that never preempt", func() {
+		ExpectCreated(ctx, env.Client, scheduledPod(""))
+		pending := pendingPod()
+		never := v1.PreemptNever
+		pending.Spec.PreemptionPolicy = &never
+		pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, pending)[0]
+		ExpectScheduled(ctx, env.Client, pod)
+	})
+	It("should launch nodes once the preemption times out", func() {
+		ExpectCreated(ctx, env.Client, scheduledPod(""))
+		pending := pendingPod()
+		pending.Status.Conditions[0].LastTransitionTime = metav1.Now()
+		injectabletime.Now = func() time.Time { return time.Now().Add(2 * selection.PreemptionTimeout) }
+		pod := ExpectProvisioned(ctx, env.Client, selectionController, provisioners, provisioner, pending)[0]
+		ExpectScheduled(ctx, env.Client, pod)
+	})
+})
@@ -0,0 +1,271 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/metrics"
+	"github.com/aws/karpenter/pkg/tracing"
+	"github.com/aws/karpenter/pkg/utils/resources"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// Cluster tracks nodes that Karpenter has launched, but that have not yet
+// registered with the cluster. The kube scheduler is unable to schedule pods to
+// these nodes until they become ready, so pods that are created in the meantime
+// are considered unschedulable. Without this state, each burst of pods triggers
+// additional launches for capacity that is already on its way.
+type Cluster struct {
+	mu       sync.RWMutex
+	inflight map[string]*InFlightNode
+}
+
+// InFlightNode is a node that has been launched but is not yet ready.
+type InFlightNode struct {
+	Node *v1.Node
+	// Requested is the sum of all resources reserved on the node, including
+	// overhead, daemons, and pods that have been bound to the node.
+	Requested v1.ResourceList
+	// StartupTaints are expected to be removed from the node by another agent
+	// once it starts, so pods are not required to tolerate them.
+	StartupTaints v1alpha5.Taints
+	// registration spans the node's registration, from its launch until it's
+	// forgotten
+	registration trace.Span
+}
+
+// NewCluster is a constructor
+func NewCluster() *Cluster {
+	return &Cluster{inflight: map[string]*InFlightNode{}}
+}
+
+// Launched records a node that was created by the provisioner along with the
+// resources that have been reserved on it and the startup taints that will be
+// removed from it. Its registration is traced as a child of the context's span.
+func (c *Cluster) Launched(ctx context.Context, node *v1.Node, requested v1.ResourceList, startupTaints v1alpha5.Taints) {
+	_, span := tracing.Start(ctx, "state.Register", trace.WithAttributes(attribute.String(tracing.NodeAttribute, node.Name)))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if existing, ok := c.inflight[node.Name]; ok {
+		existing.registration.End()
+	}
+	c.inflight[node.Name] = &InFlightNode{Node: node.DeepCopy(), Requested: requested.DeepCopy(), StartupTaints: startupTaints, registration: span}
+	c.publish()
+}
+
+// Forget stops tracking the node, either because it has registered or because
+// it no longer exists.
+func (c *Cluster) Forget(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n, ok := c.inflight[name]; ok {
+		n.registration.End()
+	}
+	delete(c.inflight, name)
+	c.publish()
+}
+
+// IsInFlight returns true if the node is tracked as launched but not ready.
+func (c *Cluster) IsInFlight(name string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	_, ok := c.inflight[name]
+	return ok
+}
+
+// InFlight returns a copy of the currently tracked nodes, sorted by name.
+func (c *Cluster) InFlight() []*InFlightNode {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	nodes := []*InFlightNode{}
+	for _, n := range c.inflight {
+		nodes = append(nodes, &InFlightNode{Node: n.Node.DeepCopy(), Requested: n.Requested.DeepCopy(), StartupTaints: n.StartupTaints})
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Node.Name < nodes[j].Node.Name })
+	return nodes
+}
+
+// Reserve finds an in flight node for the given provisioner that is compatible
+// with the pod, has the labels that the provisioner's label templates render
+// for the pod, and has enough remaining capacity, and reserves the pod's
+// resources on it. Labels with empty values must be absent from the node.
+// Returns the name of the node, or false if none were found.
+func (c *Cluster) Reserve(provisionerName string, pod *v1.Pod, labels map[string]string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	names := []string{}
+	for name := range c.inflight {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		n := c.inflight[name]
+		if n.Node.Labels[v1alpha5.ProvisionerNameLabelKey] != provisionerName {
+			continue
+		}
+		if !n.Compatible(pod) || !n.hasLabels(labels) {
+			continue
+		}
+		requested, ok := n.Fits(pod)
+		if !ok {
+			continue
+		}
+		n.Requested = requested
+		c.publish()
+		return name, true
+	}
+	return "", false
+}
+
+// Release returns the resources that were reserved for the pod on the in
+// flight node, e.g. because the pod couldn't be bound to it.
+func (c *Cluster) Release(name string, pod *v1.Pod) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n, ok := c.inflight[name]
+	if !ok {
+		return
+	}
+	requests := resources.RequestsForPods(pod)
+	requests[v1.ResourcePods] = *resource.NewQuantity(1, resource.DecimalSI)
+	requested := n.Requested.DeepCopy()
+	for resourceName, quantity := range requests {
+		if current, ok := requested[resourceName]; ok {
+			current.Sub(quantity)
+			requested[resourceName] = current
+		}
+	}
+	n.Requested = requested
+	c.publish()
+}
+
+// Compatible returns true if the node's labels satisfy any of the pod's
+// alternative requirements and the pod tolerates the node's taints. It is also
+// used to simulate moving pods between registered nodes, whose not ready taint
+// has been removed.
+func (n *InFlightNode) Compatible(pod *v1.Pod) bool {
+	matched := false
+	for _, requirements := range v1alpha5.PodRequirementAlternatives(pod) {
+		if n.matches(requirements) {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return false
+	}
+	taints := v1alpha5.Taints{}
+	for _, taint := range n.Node.Spec.Taints {
+		// The not ready taint is removed once the node registers, and startup
+		// taints are removed once the node starts
+		if taint.Key != v1alpha5.NotReadyTaintKey && !n.StartupTaints.Has(taint) {
+			taints = append(taints, taint)
+		}
+	}
+	return taints.Tolerates(pod) == nil
+}
+
+// hasLabels returns true if the node has the labels, and doesn't have those
+// with empty values
+func (n *InFlightNode) hasLabels(labels map[string]string) bool {
+	for key, value := range labels {
+		if actual, ok := n.Node.Labels[key]; ok != (value != "") || actual != value {
+			return false
+		}
+	}
+	return true
+}
+
+// matches returns true if the node's labels satisfy the requirements.
+func (n *InFlightNode) matches(requirements v1alpha5.Requirements) bool {
+	for _, requirement := range requirements {
+		value, ok := n.Node.Labels[requirement.Key]
+		switch requirement.Operator {
+		case v1.NodeSelectorOpDoesNotExist:
+			if ok {
+				return false
+			}
+		case v1.NodeSelectorOpNotIn:
+			if ok && !v1alpha5.Allows(requirement, value) {
+				return false
+			}
+		default:
+			if !ok || !v1alpha5.Allows(requirement, value) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Fits returns the node's requested resources with the pod included, and
+// whether the result is within the node's allocatable capacity.
+func (n *InFlightNode) Fits(pod *v1.Pod) (v1.ResourceList, bool) {
+	requests := resources.RequestsForPods(pod)
+	requests[v1.ResourcePods] = *resource.NewQuantity(1, resource.DecimalSI)
+	requested := resources.Merge(n.Requested, requests)
+	for resourceName, quantity := range requested {
+		allocatable, ok := n.Node.Status.Allocatable[resourceName]
+		if !ok && quantity.IsZero() {
+			continue
+		}
+		if quantity.Cmp(allocatable) > 0 {
+			return nil, false
+		}
+	}
+	return requested, true
+}
+
+// publish updates the size of the tracked state. Must be called while holding the lock.
+func (c *Cluster) publish() {
+	pods := int64(0)
+	for _, n := range c.inflight {
+		pods += n.Requested.Pods().Value()
+	}
+	inFlightNodesGauge.Set(float64(len(c.inflight)))
+	inFlightPodsGauge.Set(float64(pods))
+}
+
+var (
+	inFlightNodesGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: "cluster_state",
+			Name:      "node_count",
+			Help:      "Number of nodes that have been launched but are not yet ready, tracked by the cluster state.",
+		},
+	)
+	inFlightPodsGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: "cluster_state",
+			Name:      "pod_count",
+			Help:      "Number of pods reserved on nodes that are tracked by the cluster state, including daemons.",
+		},
+	)
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(inFlightNodesGauge, inFlightPodsGauge)
+}
@@ -0,0 +1,76 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"context"
+
+	karpenterlogging "github.com/aws/karpenter/pkg/utils/logging"
+	"github.com/aws/karpenter/pkg/utils/node"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"knative.dev/pkg/logging"
+	controllerruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const controllerName = "state"
+
+// Controller removes nodes from the cluster state once they register or are deleted
+type Controller struct {
+	kubeClient client.Client
+	cluster    *Cluster
+}
+
+// NewController constructs a controller instance
+func NewController(kubeClient client.Client, cluster *Cluster) *Controller {
+	return &Controller{
+		kubeClient: kubeClient,
+		cluster:    cluster,
+	}
+}
+
+// Reconcile the resource
+func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	if !c.cluster.IsInFlight(req.Name) {
+		return reconcile.Result{}, nil
+	}
+	ctx = karpenterlogging.WithReconcile(ctx, controllerName, "node", req.Name)
+	stored := &v1.Node{}
+	if err := c.kubeClient.Get(ctx, req.NamespacedName, stored); err != nil {
+		if errors.IsNotFound(err) {
+			c.cluster.Forget(req.Name)
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+	if !stored.DeletionTimestamp.IsZero() || node.IsReady(stored) {
+		logging.FromContext(ctx).Debugf("Node is no longer in flight")
+		c.cluster.Forget(req.Name)
+	}
+	return reconcile.Result{}, nil
+}
+
+func (c *Controller) Register(_ context.Context, m manager.Manager) error {
+	return controllerruntime.
+		NewControllerManagedBy(m).
+		Named(controllerName).
+		For(&v1.Node{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: 10}).
+		Complete(c)
+}
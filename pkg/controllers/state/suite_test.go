@@ -0,0 +1,189 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/controllers/state"
+	"github.com/aws/karpenter/pkg/test"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestState(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "State Suite")
+}
+
+var _ = Describe("Cluster", func() {
+	var ctx = context.Background()
+	var cluster *state.Cluster
+	var node *v1.Node
+
+	BeforeEach(func() {
+		cluster = state.NewCluster()
+		node = test.Node(test.NodeOptions{
+			Name:        "in-flight",
+			ReadyStatus: v1.ConditionUnknown,
+			Labels: map[string]string{
+				v1alpha5.ProvisionerNameLabelKey: "default",
+				v1.LabelTopologyZone:             "test-zone-1",
+			},
+			Taints: []v1.Taint{{Key: v1alpha5.NotReadyTaintKey, Effect: v1.TaintEffectNoSchedule}},
+			Allocatable: v1.ResourceList{
+				v1.ResourceCPU:    resource.MustParse("2"),
+				v1.ResourceMemory: resource.MustParse("2Gi"),
+				v1.ResourcePods:   resource.MustParse("10"),
+			},
+		})
+	})
+
+	It("should trace the registration of launched nodes until they're forgotten", func() {
+		recorder := tracetest.NewSpanRecorder()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+		defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+		ctx, launch := otel.Tracer("test").Start(ctx, "launch")
+		cluster.Launched(ctx, node, v1.ResourceList{}, nil)
+		Expect(recorder.Ended()).To(BeEmpty())
+		cluster.Forget(node.Name)
+		Expect(recorder.Ended()).To(HaveLen(1))
+		Expect(recorder.Ended()[0].Name()).To(Equal("state.Register"))
+		Expect(recorder.Ended()[0].Parent().SpanID()).To(Equal(launch.SpanContext().SpanID()))
+		Expect(recorder.Ended()[0].Attributes()).To(ContainElement(attribute.String("node", node.Name)))
+		launch.End()
+	})
+	It("should track launched nodes until forgotten", func() {
+		cluster.Launched(ctx, node, v1.ResourceList{}, nil)
+		Expect(cluster.IsInFlight(node.Name)).To(BeTrue())
+		Expect(cluster.InFlight()).To(HaveLen(1))
+		cluster.Forget(node.Name)
+		Expect(cluster.IsInFlight(node.Name)).To(BeFalse())
+		Expect(cluster.InFlight()).To(BeEmpty())
+	})
+	It("should reserve capacity on a compatible node", func() {
+		cluster.Launched(ctx, node, v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")}, nil)
+		name, ok := cluster.Reserve("default", test.UnschedulablePod(test.PodOptions{
+			NodeSelector:         map[string]string{v1.LabelTopologyZone: "test-zone-1"},
+			ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")}},
+		}), nil)
+		Expect(ok).To(BeTrue())
+		Expect(name).To(Equal(node.Name))
+		requested := cluster.InFlight()[0].Requested
+		Expect(requested.Cpu().String()).To(Equal("2"))
+		Expect(requested.Pods().String()).To(Equal("1"))
+	})
+	It("should release the capacity reserved for a pod", func() {
+		cluster.Launched(ctx, node, v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")}, nil)
+		pod := test.UnschedulablePod(test.PodOptions{
+			ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")}},
+		})
+		name, ok := cluster.Reserve("default", pod, nil)
+		Expect(ok).To(BeTrue())
+		_, ok = cluster.Reserve("default", pod, nil)
+		Expect(ok).To(BeFalse())
+		cluster.Release(name, pod)
+		requested := cluster.InFlight()[0].Requested
+		Expect(requested.Cpu().String()).To(Equal("1"))
+		Expect(requested.Pods().String()).To(Equal("0"))
+		_, ok = cluster.Reserve("default", pod, nil)
+		Expect(ok).To(BeTrue())
+	})
+	It("should not reserve capacity on a node that is full", func() {
+		cluster.Launched(ctx, node, v1.ResourceList{v1.ResourceCPU: resource.MustParse("1500m")}, nil)
+		_, ok := cluster.Reserve("default", test.UnschedulablePod(test.PodOptions{
+			ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")}},
+		}), nil)
+		Expect(ok).To(BeFalse())
+	})
+	It("should not reserve more pods than allocatable", func() {
+		cluster.Launched(ctx, node, v1.ResourceList{v1.ResourcePods: resource.MustParse("10")}, nil)
+		_, ok := cluster.Reserve("default", test.UnschedulablePod(), nil)
+		Expect(ok).To(BeFalse())
+	})
+	It("should not reserve capacity on a node from another provisioner", func() {
+		cluster.Launched(ctx, node, v1.ResourceList{}, nil)
+		_, ok := cluster.Reserve("other", test.UnschedulablePod(), nil)
+		Expect(ok).To(BeFalse())
+	})
+	It("should not reserve capacity on a node with incompatible labels", func() {
+		cluster.Launched(ctx, node, v1.ResourceList{}, nil)
+		_, ok := cluster.Reserve("default", test.UnschedulablePod(test.PodOptions{
+			NodeSelector: map[string]string{v1.LabelTopologyZone: "test-zone-2"},
+		}), nil)
+		Expect(ok).To(BeFalse())
+		_, ok = cluster.Reserve("default", test.UnschedulablePod(test.PodOptions{
+			NodeRequirements: []v1.NodeSelectorRequirement{{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpNotIn, Values: []string{"test-zone-1"}}},
+		}), nil)
+		Expect(ok).To(BeFalse())
+	})
+	It("should reserve capacity for pods that exclude other values", func() {
+		cluster.Launched(ctx, node, v1.ResourceList{}, nil)
+		_, ok := cluster.Reserve("default", test.UnschedulablePod(test.PodOptions{
+			NodeRequirements: []v1.NodeSelectorRequirement{{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpNotIn, Values: []string{"test-zone-2"}}},
+		}), nil)
+		Expect(ok).To(BeTrue())
+	})
+	It("should match existence requirements against the node's labels", func() {
+		cluster.Launched(ctx, node, v1.ResourceList{}, nil)
+		_, ok := cluster.Reserve("default", test.UnschedulablePod(test.PodOptions{
+			NodeRequirements: []v1.NodeSelectorRequirement{{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpDoesNotExist}},
+		}), nil)
+		Expect(ok).To(BeFalse())
+		_, ok = cluster.Reserve("default", test.UnschedulablePod(test.PodOptions{
+			NodeRequirements: []v1.NodeSelectorRequirement{
+				{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpExists},
+				{Key: "example.com/gpu", Operator: v1.NodeSelectorOpDoesNotExist},
+			},
+		}), nil)
+		Expect(ok).To(BeTrue())
+	})
+	It("should only reserve capacity on a node with the pod's templated labels", func() {
+		node.Labels["team"] = "a"
+		cluster.Launched(ctx, node, v1.ResourceList{}, nil)
+		_, ok := cluster.Reserve("default", test.UnschedulablePod(), map[string]string{"team": "b"})
+		Expect(ok).To(BeFalse())
+		_, ok = cluster.Reserve("default", test.UnschedulablePod(), map[string]string{"team": ""})
+		Expect(ok).To(BeFalse())
+		_, ok = cluster.Reserve("default", test.UnschedulablePod(), map[string]string{"team": "a"})
+		Expect(ok).To(BeTrue())
+	})
+	It("should not reserve capacity on a node with untolerated taints", func() {
+		node.Spec.Taints = append(node.Spec.Taints, v1.Taint{Key: "test-key", Value: "test-value", Effect: v1.TaintEffectNoSchedule})
+		cluster.Launched(ctx, node, v1.ResourceList{}, nil)
+		_, ok := cluster.Reserve("default", test.UnschedulablePod(), nil)
+		Expect(ok).To(BeFalse())
+		_, ok = cluster.Reserve("default", test.UnschedulablePod(test.PodOptions{
+			Tolerations: []v1.Toleration{{Key: "test-key", Operator: v1.TolerationOpEqual, Value: "test-value", Effect: v1.TaintEffectNoSchedule}},
+		}), nil)
+		Expect(ok).To(BeTrue())
+	})
+	It("should ignore startup taints", func() {
+		startupTaint := v1.Taint{Key: "test-key", Value: "test-value", Effect: v1.TaintEffectNoSchedule}
+		node.Spec.Taints = append(node.Spec.Taints, startupTaint)
+		cluster.Launched(ctx, node, v1.ResourceList{}, v1alpha5.Taints{startupTaint})
+		_, ok := cluster.Reserve("default", test.UnschedulablePod(), nil)
+		Expect(ok).To(BeTrue())
+	})
+})
@@ -0,0 +1,168 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package status
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	controllerruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/controllers/provisioning"
+	karpenterlogging "github.com/aws/karpenter/pkg/utils/logging"
+)
+
+const (
+	controllerName = "status"
+	// InvalidSpecReason is reported on the Validated condition of provisioners
+	// that fail validation, e.g. because they were created before the webhook
+	// rejected them
+	InvalidSpecReason = "InvalidSpec"
+	// VerificationFailedReason is reported on the Validated condition of
+	// provisioners that the cloud provider fails to verify without a more
+	// specific reason
+	VerificationFailedReason = "VerificationFailed"
+	// ResourceLimitExceededReason and NodeLimitExceededReason are reported on
+	// the LimitsExceeded condition
+	ResourceLimitExceededReason = "ResourceLimitExceeded"
+	NodeLimitExceededReason     = "NodeLimitExceeded"
+	// LaunchFailedReason is reported on the Degraded condition
+	LaunchFailedReason = "LaunchFailed"
+	// VerificationRetryingReason is reported on the Degraded condition of
+	// running provisioners that the cloud provider transiently failed to
+	// verify, e.g. because it throttled the requests
+	VerificationRetryingReason = "VerificationRetrying"
+	// RequeueInterval is how often conditions are refreshed, so that launch
+	// failures are reported even if the provisioner doesn't change
+	RequeueInterval = time.Minute
+)
+
+// Controller reports the conditions of provisioners, so that their health can
+// be checked without reading Karpenter's logs
+type Controller struct {
+	kubeClient   client.Client
+	provisioners *provisioning.Controller
+}
+
+// NewController is a constructor
+func NewController(kubeClient client.Client, provisioners *provisioning.Controller) *Controller {
+	return &Controller{
+		kubeClient:   kubeClient,
+		provisioners: provisioners,
+	}
+}
+
+// Reconcile updates the conditions of the provisioner
+func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	ctx = karpenterlogging.WithReconcile(ctx, controllerName, "provisioner", req.Name)
+	provisioner := &v1alpha5.Provisioner{}
+	if err := c.kubeClient.Get(ctx, req.NamespacedName, provisioner); err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+	persisted := provisioner.DeepCopy()
+	c.validate(ctx, provisioner)
+	c.limit(provisioner)
+	c.degrade(provisioner)
+	if equality.Semantic.DeepEqual(provisioner.Status, persisted.Status) {
+		return reconcile.Result{RequeueAfter: RequeueInterval}, nil
+	}
+	if err := c.kubeClient.Status().Patch(ctx, provisioner, client.MergeFrom(persisted)); err != nil {
+		return reconcile.Result{}, fmt.Errorf("patching provisioner, %w", err)
+	}
+	return reconcile.Result{RequeueAfter: RequeueInterval}, nil
+}
+
+// validate marks the provisioner Validated, and therefore Ready, if its spec is
+// valid and it's running. Whether it's running is decided by the provisioning
+// controller when the cloud provider verifies it, so the condition is left as
+// it was until the provisioner is applied.
+func (c *Controller) validate(ctx context.Context, provisioner *v1alpha5.Provisioner) {
+	if err := provisioner.Validate(ctx); err != nil {
+		provisioner.StatusConditions().MarkFalse(v1alpha5.Validated, InvalidSpecReason, "%s", err.Error())
+		return
+	}
+	applied, err := c.provisioners.Verification(provisioner.Name)
+	if !applied {
+		return
+	}
+	// Transient failures to verify running provisioners are reported as Degraded
+	if _, running := c.provisioners.Get(provisioner.Name); running {
+		provisioner.StatusConditions().MarkTrue(v1alpha5.Validated)
+		return
+	}
+	if err != nil {
+		reason := VerificationFailedReason
+		if configurationErrorReason, ok := cloudprovider.ConfigurationErrorReason(err); ok {
+			reason = configurationErrorReason
+		}
+		provisioner.StatusConditions().MarkFalse(v1alpha5.Validated, reason, "%s", err.Error())
+	}
+}
+
+// limit marks the provisioner LimitsExceeded if it owns as many resources or
+// nodes as its limits allow
+func (c *Controller) limit(provisioner *v1alpha5.Provisioner) {
+	if err := provisioner.Spec.Limits.ExceededBy(provisioner.Status.Resources); err != nil {
+		provisioner.StatusConditions().MarkTrueWithReason(v1alpha5.LimitsExceeded, ResourceLimitExceededReason, "%s", err.Error())
+		return
+	}
+	if remaining, limited := provisioner.Spec.Limits.RemainingNodes(int(provisioner.Status.Nodes)); limited && remaining == 0 {
+		provisioner.StatusConditions().MarkTrueWithReason(v1alpha5.LimitsExceeded, NodeLimitExceededReason, "%d node(s) reached the limit of %d", provisioner.Status.Nodes, *provisioner.Spec.Limits.MaxNodes)
+		return
+	}
+	provisioner.StatusConditions().MarkFalse(v1alpha5.LimitsExceeded, "", "")
+}
+
+// degrade marks the provisioner Degraded if its most recent verification or
+// launch failed. Provisioners that aren't running don't launch, so their
+// condition is left as it was.
+func (c *Controller) degrade(provisioner *v1alpha5.Provisioner) {
+	running, ok := c.provisioners.Get(provisioner.Name)
+	if !ok {
+		return
+	}
+	// The last configuration that was verified keeps running
+	if _, err := c.provisioners.Verification(provisioner.Name); err != nil {
+		provisioner.StatusConditions().MarkTrueWithReason(v1alpha5.Degraded, VerificationRetryingReason, "%s", err.Error())
+		return
+	}
+	if err := running.LaunchError(); err != nil {
+		provisioner.StatusConditions().MarkTrueWithReason(v1alpha5.Degraded, LaunchFailedReason, "%s", err.Error())
+		return
+	}
+	provisioner.StatusConditions().MarkFalse(v1alpha5.Degraded, "", "")
+}
+
+// Register the controller to the manager
+func (c *Controller) Register(_ context.Context, m manager.Manager) error {
+	return controllerruntime.
+		NewControllerManagedBy(m).
+		Named(controllerName).
+		For(&v1alpha5.Provisioner{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: 10}).
+		Complete(c)
+}
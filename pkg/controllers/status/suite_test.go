@@ -0,0 +1,242 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package status_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/cloudprovider/fake"
+	"github.com/aws/karpenter/pkg/cloudprovider/registry"
+	"github.com/aws/karpenter/pkg/controllers/provisioning"
+	"github.com/aws/karpenter/pkg/controllers/selection"
+	"github.com/aws/karpenter/pkg/controllers/state"
+	"github.com/aws/karpenter/pkg/controllers/status"
+	"github.com/aws/karpenter/pkg/events"
+	"github.com/aws/karpenter/pkg/test"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"knative.dev/pkg/apis"
+	"knative.dev/pkg/ptr"
+	"knative.dev/pkg/system"
+	_ "knative.dev/pkg/system/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	. "github.com/aws/karpenter/pkg/test/expectations"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "knative.dev/pkg/logging/testing"
+)
+
+var ctx context.Context
+var cloudProvider *fake.CloudProvider
+var provisioningController *provisioning.Controller
+var selectionController *selection.Controller
+var controller *status.Controller
+var env *test.Environment
+
+func TestAPIs(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Controllers/Status")
+}
+
+var _ = BeforeSuite(func() {
+	env = test.NewEnvironment(ctx, func(e *test.Environment) {
+		cloudProvider = &fake.CloudProvider{}
+		registry.RegisterOrDie(ctx, cloudProvider)
+		provisioningController = provisioning.NewController(ctx, e.Client, corev1.NewForConfigOrDie(e.Config), cloudProvider, state.NewCluster(), events.NewRecorder(&record.FakeRecorder{}))
+		selectionController = selection.NewController(e.Client, provisioningController, events.NewRecorder(&record.FakeRecorder{}))
+		controller = status.NewController(e.Client, provisioningController)
+	})
+	Expect(env.Start()).To(Succeed(), "Failed to start environment")
+	// Placement decisions are recorded in the system namespace
+	ExpectCreated(ctx, env.Client, &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: system.Namespace()}})
+})
+
+var _ = AfterSuite(func() {
+	Expect(env.Stop()).To(Succeed(), "Failed to stop environment")
+})
+
+var _ = Describe("Status", func() {
+	var provisioner *v1alpha5.Provisioner
+	BeforeEach(func() {
+		provisioner = &v1alpha5.Provisioner{
+			ObjectMeta: metav1.ObjectMeta{Name: v1alpha5.DefaultProvisioner.Name},
+			Spec:       v1alpha5.ProvisionerSpec{},
+		}
+		provisioner.SetDefaults(ctx)
+		cloudProvider.Reset()
+	})
+
+	AfterEach(func() {
+		ExpectProvisioningCleanedUp(ctx, env.Client, provisioningController)
+	})
+
+	ExpectReconciled := func() *v1alpha5.Provisioner {
+		// Conditions are derived from whether the provisioning controller applied the provisioner
+		_, _ = provisioningController.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(provisioner)})
+		ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+		persisted := &v1alpha5.Provisioner{}
+		Expect(env.Client.Get(ctx, client.ObjectKeyFromObject(provisioner), persisted)).To(Succeed())
+		return persisted
+	}
+
+	Context("Validated", func() {
+		It("should mark the provisioner ready", func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			persisted := ExpectReconciled()
+			Expect(persisted.StatusConditions().GetCondition(v1alpha5.Validated).IsTrue()).To(BeTrue())
+			Expect(persisted.StatusConditions().IsHappy()).To(BeTrue())
+		})
+		It("should not mark a provisioner with an invalid spec ready", func() {
+			provisioner.Spec.Labels = map[string]string{v1.LabelHostname: "test-hostname"}
+			ExpectApplied(ctx, env.Client, provisioner)
+			persisted := ExpectReconciled()
+			validated := persisted.StatusConditions().GetCondition(v1alpha5.Validated)
+			Expect(validated.IsFalse()).To(BeTrue())
+			Expect(validated.Reason).To(Equal(status.InvalidSpecReason))
+			Expect(persisted.StatusConditions().GetCondition(apis.ConditionReady).IsFalse()).To(BeTrue())
+		})
+		It("should not mark a provisioner that fails verification ready", func() {
+			cloudProvider.VerifyError = fmt.Errorf("no subnets matched selector")
+			ExpectApplied(ctx, env.Client, provisioner)
+			persisted := ExpectReconciled()
+			validated := persisted.StatusConditions().GetCondition(v1alpha5.Validated)
+			Expect(validated.IsFalse()).To(BeTrue())
+			Expect(validated.Reason).To(Equal(status.VerificationFailedReason))
+			ready := persisted.StatusConditions().GetCondition(apis.ConditionReady)
+			Expect(ready.IsFalse()).To(BeTrue())
+			Expect(ready.Message).To(ContainSubstring("no subnets matched selector"))
+		})
+		It("should report the reason of a configuration error", func() {
+			cloudProvider.VerifyError = cloudprovider.NewConfigurationError("SecurityGroupsNotFound", fmt.Errorf("no security groups exist given constraints"))
+			ExpectApplied(ctx, env.Client, provisioner)
+			persisted := ExpectReconciled()
+			validated := persisted.StatusConditions().GetCondition(v1alpha5.Validated)
+			Expect(validated.IsFalse()).To(BeTrue())
+			Expect(validated.Reason).To(Equal("SecurityGroupsNotFound"))
+			Expect(validated.Message).To(ContainSubstring("no security groups exist given constraints"))
+		})
+		It("should not mark a provisioner ready until it's applied", func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			ExpectReconcileSucceeded(ctx, controller, client.ObjectKeyFromObject(provisioner))
+			persisted := &v1alpha5.Provisioner{}
+			Expect(env.Client.Get(ctx, client.ObjectKeyFromObject(provisioner), persisted)).To(Succeed())
+			Expect(persisted.StatusConditions().GetCondition(v1alpha5.Validated).IsUnknown()).To(BeTrue())
+		})
+		It("should keep a running provisioner ready when it transiently fails verification", func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			Expect(ExpectReconciled().StatusConditions().IsHappy()).To(BeTrue())
+			cloudProvider.VerifyError = fmt.Errorf("request limit exceeded")
+			Expect(ExpectReconciled().StatusConditions().IsHappy()).To(BeTrue())
+		})
+		It("should not change the transition time of an unchanged condition", func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			transitioned := ExpectReconciled().StatusConditions().GetCondition(v1alpha5.Validated).LastTransitionTime
+			Expect(ExpectReconciled().StatusConditions().GetCondition(v1alpha5.Validated).LastTransitionTime).To(Equal(transitioned))
+		})
+	})
+	Context("LimitsExceeded", func() {
+		It("should not mark a provisioner within its limits", func() {
+			provisioner.Spec.Limits.Resources = v1.ResourceList{v1.ResourceCPU: resource.MustParse("10")}
+			ExpectApplied(ctx, env.Client, provisioner)
+			provisioner.Status.Resources = v1.ResourceList{v1.ResourceCPU: resource.MustParse("5")}
+			ExpectStatusUpdated(ctx, env.Client, provisioner)
+			persisted := ExpectReconciled()
+			Expect(persisted.StatusConditions().GetCondition(v1alpha5.LimitsExceeded).IsFalse()).To(BeTrue())
+		})
+		It("should mark a provisioner that exceeds its resource limits", func() {
+			provisioner.Spec.Limits.Resources = v1.ResourceList{v1.ResourceCPU: resource.MustParse("10")}
+			ExpectApplied(ctx, env.Client, provisioner)
+			provisioner.Status.Resources = v1.ResourceList{v1.ResourceCPU: resource.MustParse("10")}
+			ExpectStatusUpdated(ctx, env.Client, provisioner)
+			persisted := ExpectReconciled()
+			limitsExceeded := persisted.StatusConditions().GetCondition(v1alpha5.LimitsExceeded)
+			Expect(limitsExceeded.IsTrue()).To(BeTrue())
+			Expect(limitsExceeded.Reason).To(Equal(status.ResourceLimitExceededReason))
+			Expect(persisted.StatusConditions().IsHappy()).To(BeTrue())
+		})
+		It("should mark a provisioner that reached its node limit", func() {
+			provisioner.Spec.Limits.MaxNodes = ptr.Int32(2)
+			ExpectApplied(ctx, env.Client, provisioner)
+			provisioner.Status.Nodes = 2
+			ExpectStatusUpdated(ctx, env.Client, provisioner)
+			persisted := ExpectReconciled()
+			limitsExceeded := persisted.StatusConditions().GetCondition(v1alpha5.LimitsExceeded)
+			Expect(limitsExceeded.IsTrue()).To(BeTrue())
+			Expect(limitsExceeded.Reason).To(Equal(status.NodeLimitExceededReason))
+			Expect(limitsExceeded.Message).To(ContainSubstring("limit of 2"))
+		})
+	})
+	Context("Degraded", func() {
+		It("should not mark a provisioner that isn't running", func() {
+			cloudProvider.VerifyError = cloudprovider.NewConfigurationError("SecurityGroupsNotFound", fmt.Errorf("no security groups exist given constraints"))
+			ExpectApplied(ctx, env.Client, provisioner)
+			persisted := ExpectReconciled()
+			Expect(persisted.StatusConditions().GetCondition(v1alpha5.Degraded)).To(BeNil())
+		})
+		It("should mark a provisioner whose launch failed", func() {
+			cloudProvider.CreateHook = fake.Fail(fmt.Errorf("launch template not found"))
+			for _, pod := range ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, test.UnschedulablePod()) {
+				ExpectNotScheduled(ctx, env.Client, pod)
+			}
+			persisted := ExpectReconciled()
+			degraded := persisted.StatusConditions().GetCondition(v1alpha5.Degraded)
+			Expect(degraded.IsTrue()).To(BeTrue())
+			Expect(degraded.Reason).To(Equal(status.LaunchFailedReason))
+			Expect(degraded.Message).To(ContainSubstring("launch template not found"))
+			Expect(persisted.StatusConditions().IsHappy()).To(BeTrue())
+		})
+		It("should mark a running provisioner that transiently fails verification", func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			ExpectReconcileSucceeded(ctx, provisioningController, client.ObjectKeyFromObject(provisioner))
+			cloudProvider.VerifyError = fmt.Errorf("request limit exceeded")
+			ExpectReconcileFailed(ctx, provisioningController, client.ObjectKeyFromObject(provisioner))
+			_, running := provisioningController.Get(provisioner.Name)
+			Expect(running).To(BeTrue())
+			degraded := ExpectReconciled().StatusConditions().GetCondition(v1alpha5.Degraded)
+			Expect(degraded.IsTrue()).To(BeTrue())
+			Expect(degraded.Reason).To(Equal(status.VerificationRetryingReason))
+			Expect(degraded.Message).To(ContainSubstring("request limit exceeded"))
+		})
+		It("should stop a provisioner that is misconfigured", func() {
+			ExpectApplied(ctx, env.Client, provisioner)
+			ExpectReconcileSucceeded(ctx, provisioningController, client.ObjectKeyFromObject(provisioner))
+			cloudProvider.VerifyError = cloudprovider.NewConfigurationError("SecurityGroupsNotFound", fmt.Errorf("no security groups exist given constraints"))
+			ExpectReconcileFailed(ctx, provisioningController, client.ObjectKeyFromObject(provisioner))
+			_, running := provisioningController.Get(provisioner.Name)
+			Expect(running).To(BeFalse())
+		})
+		It("should recover once a launch succeeds", func() {
+			cloudProvider.CreateHook = fake.Fail(fmt.Errorf("launch template not found"))
+			ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, test.UnschedulablePod())
+			Expect(ExpectReconciled().StatusConditions().GetCondition(v1alpha5.Degraded).IsTrue()).To(BeTrue())
+			cloudProvider.CreateHook = nil
+			for _, pod := range ExpectProvisioned(ctx, env.Client, selectionController, provisioningController, provisioner, test.UnschedulablePod()) {
+				ExpectScheduled(ctx, env.Client, pod)
+			}
+			Expect(ExpectReconciled().StatusConditions().GetCondition(v1alpha5.Degraded).IsFalse()).To(BeTrue())
+		})
+	})
+})
@@ -20,7 +20,6 @@ import (
 	"time"
 
 	"golang.org/x/time/rate"
-	"knative.dev/pkg/logging"
 
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
@@ -36,6 +35,7 @@ import (
 	"github.com/aws/karpenter/pkg/cloudprovider"
 	"github.com/aws/karpenter/pkg/utils/functional"
 	"github.com/aws/karpenter/pkg/utils/injection"
+	karpenterlogging "github.com/aws/karpenter/pkg/utils/logging"
 )
 
 const controllerName = "termination"
@@ -61,7 +61,7 @@ func NewController(ctx context.Context, kubeClient client.Client, coreV1Client c
 
 // Reconcile executes a termination control loop for the resource
 func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
-	ctx = logging.WithLogger(ctx, logging.FromContext(ctx).Named(controllerName).With("node", req.Name))
+	ctx = karpenterlogging.WithReconcile(ctx, controllerName, "node", req.Name)
 	ctx = injection.WithControllerName(ctx, controllerName)
 
 	// 1. Retrieve node from reconcile request
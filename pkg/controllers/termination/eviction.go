@@ -50,11 +50,18 @@ func NewEvictionQueue(ctx context.Context, coreV1Client corev1.CoreV1Interface)
 		coreV1Client: coreV1Client,
 	}
 	go queue.Start(ctx)
+	go func() {
+		<-ctx.Done()
+		queue.ShutDown()
+	}()
 	return queue
 }
 
-// Add adds pods to the EvictionQueue
+// Add adds pods to the EvictionQueue, unless it has shut down
 func (e *EvictionQueue) Add(pods []*v1.Pod) {
+	if e.RateLimitingInterface.ShuttingDown() {
+		return
+	}
 	for _, pod := range pods {
 		if nn := client.ObjectKeyFromObject(pod); !e.Set.Contains(nn) {
 			e.Set.Add(nn)
@@ -63,6 +70,10 @@ func (e *EvictionQueue) Add(pods []*v1.Pod) {
 	}
 }
 
+// Start evicts the queued pods until the queue shuts down. Once the context is
+// cancelled, e.g. because this replica is handing off leadership, the
+// remaining pods are flushed instead of evicted, since the next leader
+// requeues the pods of terminating nodes.
 func (e *EvictionQueue) Start(ctx context.Context) {
 	for {
 		// Get pod from queue. This waits until queue is non-empty.
@@ -71,6 +82,11 @@ func (e *EvictionQueue) Start(ctx context.Context) {
 			break
 		}
 		nn := item.(types.NamespacedName)
+		if ctx.Err() != nil {
+			e.RateLimitingInterface.Forget(nn)
+			e.RateLimitingInterface.Done(nn)
+			continue
+		}
 		// Evict pod
 		if e.evict(ctx, nn) {
 			logging.FromContext(ctx).Debugf("Evicted pod %s", nn.String())
@@ -83,6 +99,14 @@ func (e *EvictionQueue) Start(ctx context.Context) {
 		// Requeue pod if eviction failed
 		e.RateLimitingInterface.AddRateLimited(nn)
 	}
+	if ctx.Err() != nil {
+		// Pods that are waiting to be retried are never returned by the queue
+		if pending := e.Set.Cardinality(); pending > 0 {
+			logging.FromContext(ctx).Infof("Stopped evicting, handing off %d pod(s) to the next leader", pending)
+		}
+		e.Set.Clear()
+		return
+	}
 	logging.FromContext(ctx).Errorf("EvictionQueue is broken and has shutdown.")
 }
 
@@ -286,6 +286,18 @@ var _ = Describe("Termination", func() {
 			ExpectNotFound(ctx, env.Client, node)
 		})
 	})
+	Context("Handoff", func() {
+		It("should flush pods instead of evicting them once stopped", func() {
+			pod := test.Pod(test.PodOptions{NodeName: node.Name})
+			ExpectCreated(ctx, env.Client, node, pod)
+			stopped, stop := context.WithCancel(ctx)
+			stop()
+			queue := termination.NewEvictionQueue(stopped, corev1.NewForConfigOrDie(env.Config))
+			queue.Add([]*v1.Pod{pod})
+			Eventually(func() bool { return queue.Contains(client.ObjectKeyFromObject(pod)) }).Should(BeFalse())
+			Expect(ExpectPodExists(ctx, env.Client, pod.Name, pod.Namespace).GetDeletionTimestamp().IsZero()).To(BeTrue())
+		})
+	})
 })
 
 func ExpectEnqueuedForEviction(e *termination.EvictionQueue, pods ...*v1.Pod) {
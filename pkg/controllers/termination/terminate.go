@@ -25,6 +25,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/audit"
 	"github.com/aws/karpenter/pkg/cloudprovider"
 	"github.com/aws/karpenter/pkg/utils/functional"
 	"github.com/aws/karpenter/pkg/utils/injectabletime"
@@ -87,6 +88,7 @@ func (t *Terminator) terminate(ctx context.Context, node *v1.Node) error {
 	if err := t.CloudProvider.Delete(ctx, node); err != nil {
 		return fmt.Errorf("terminating cloudprovider instance, %w", err)
 	}
+	audit.Terminated(ctx, node)
 	// 2. Remove finalizer from node in APIServer
 	persisted := node.DeepCopy()
 	node.Finalizers = functional.StringSliceWithout(node.Finalizers, v1alpha5.TerminationFinalizer)
@@ -112,16 +114,7 @@ func (t *Terminator) getPods(ctx context.Context, node *v1.Node) ([]*v1.Pod, err
 func (t *Terminator) getEvictablePods(pods []*v1.Pod) []*v1.Pod {
 	evictable := []*v1.Pod{}
 	for _, p := range pods {
-		// Ignore if unschedulable is tolerated, since they will reschedule
-		if (v1alpha5.Taints{{Key: v1.TaintNodeUnschedulable, Effect: v1.TaintEffectNoSchedule}}).Tolerates(p) == nil {
-			continue
-		}
-		// Ignore if kubelet is partitioned and pods are beyond graceful termination window
-		if IsStuckTerminating(p) {
-			continue
-		}
-		// Ignore static mirror pods
-		if pod.IsOwnedByNode(p) {
+		if Ignored(p) != "" {
 			continue
 		}
 		evictable = append(evictable, p)
@@ -129,6 +122,24 @@ func (t *Terminator) getEvictablePods(pods []*v1.Pod) []*v1.Pod {
 	return evictable
 }
 
+// Ignored returns why the pod isn't evicted when its node drains, or "" if
+// it's evicted
+func Ignored(p *v1.Pod) string {
+	// Ignore if unschedulable is tolerated, since they will reschedule
+	if (v1alpha5.Taints{{Key: v1.TaintNodeUnschedulable, Effect: v1.TaintEffectNoSchedule}}).Tolerates(p) == nil {
+		return "tolerates the unschedulable taint"
+	}
+	// Ignore if kubelet is partitioned and pods are beyond graceful termination window
+	if IsStuckTerminating(p) {
+		return "stuck terminating beyond its grace period"
+	}
+	// Ignore static mirror pods
+	if pod.IsOwnedByNode(p) {
+		return "static pod"
+	}
+	return ""
+}
+
 func (t *Terminator) evict(pods []*v1.Pod) {
 	// 1. Prioritize noncritical pods https://kubernetes.io/docs/concepts/architecture/nodes/#graceful-node-shutdown
 	critical := []*v1.Pod{}
@@ -0,0 +1,149 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package debug serves the state of the controller for debugging it in
+// production, e.g. profiles and the batches of pods that are stuck provisioning
+package debug
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"time"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/controllers/provisioning"
+	"github.com/aws/karpenter/pkg/controllers/state"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/logging"
+)
+
+// Provisioners lists the active provisioners
+type Provisioners interface {
+	List(context.Context) []*provisioning.Provisioner
+}
+
+// Server serves profiles under /debug/pprof and the provisioners' current
+// batches and the in flight nodes under /debug/provisioning. It runs on every
+// replica, not only the leader, so that standby replicas can be profiled too.
+type Server struct {
+	address      string
+	provisioners Provisioners
+	cluster      *state.Cluster
+}
+
+// NewServer is a constructor
+func NewServer(address string, provisioners Provisioners, cluster *state.Cluster) *Server {
+	return &Server{address: address, provisioners: provisioners, cluster: cluster}
+}
+
+// Handler routes the debug endpoints
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/provisioning", s.provisioning)
+	return mux
+}
+
+// Start serves until the context is cancelled
+func (s *Server) Start(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.address)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: s.Handler()}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdown)
+	}()
+	logging.FromContext(ctx).Infof("Serving debug endpoints on %s", listener.Addr())
+	if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// NeedLeaderElection is false so that the server runs on standby replicas
+func (s *Server) NeedLeaderElection() bool {
+	return false
+}
+
+// Provisioning is the state of provisioning, as served by /debug/provisioning
+type Provisioning struct {
+	Provisioners []Provisioner  `json:"provisioners"`
+	InFlight     []InFlightNode `json:"inFlight"`
+}
+
+// Provisioner is the current batch of a provisioner and its most recent
+// launch error
+type Provisioner struct {
+	Name        string             `json:"name"`
+	Batch       provisioning.Batch `json:"batch"`
+	LaunchError string             `json:"launchError,omitempty"`
+}
+
+// InFlightNode is a node that was launched but is not yet ready
+type InFlightNode struct {
+	Name          string          `json:"name"`
+	Provisioner   string          `json:"provisioner,omitempty"`
+	InstanceType  string          `json:"instanceType,omitempty"`
+	Zone          string          `json:"zone,omitempty"`
+	CapacityType  string          `json:"capacityType,omitempty"`
+	Created       metav1.Time     `json:"created,omitempty"`
+	Requested     v1.ResourceList `json:"requested,omitempty"`
+	StartupTaints []v1.Taint      `json:"startupTaints,omitempty"`
+}
+
+// Snapshot returns the current state of provisioning
+func (s *Server) Snapshot(ctx context.Context) Provisioning {
+	snapshot := Provisioning{Provisioners: []Provisioner{}, InFlight: []InFlightNode{}}
+	for _, p := range s.provisioners.List(ctx) {
+		provisioner := Provisioner{Name: p.Name, Batch: p.CurrentBatch()}
+		if err := p.LaunchError(); err != nil {
+			provisioner.LaunchError = err.Error()
+		}
+		snapshot.Provisioners = append(snapshot.Provisioners, provisioner)
+	}
+	for _, n := range s.cluster.InFlight() {
+		snapshot.InFlight = append(snapshot.InFlight, InFlightNode{
+			Name:          n.Node.Name,
+			Provisioner:   n.Node.Labels[v1alpha5.ProvisionerNameLabelKey],
+			InstanceType:  n.Node.Labels[v1.LabelInstanceTypeStable],
+			Zone:          n.Node.Labels[v1.LabelTopologyZone],
+			CapacityType:  n.Node.Labels[v1alpha5.LabelCapacityType],
+			Created:       n.Node.CreationTimestamp,
+			Requested:     n.Requested,
+			StartupTaints: n.StartupTaints,
+		})
+	}
+	return snapshot
+}
+
+func (s *Server) provisioning(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(s.Snapshot(r.Context())); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
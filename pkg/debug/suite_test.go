@@ -0,0 +1,105 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package debug_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/controllers/provisioning"
+	"github.com/aws/karpenter/pkg/controllers/state"
+	"github.com/aws/karpenter/pkg/debug"
+	"github.com/aws/karpenter/pkg/test"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var ctx = context.Background()
+var cluster *state.Cluster
+var provisioners fakeProvisioners
+var server *httptest.Server
+
+func TestDebug(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Debug")
+}
+
+var _ = BeforeEach(func() {
+	cluster = state.NewCluster()
+	provisioners = fakeProvisioners{}
+	server = httptest.NewServer(debug.NewServer("", &provisioners, cluster).Handler())
+})
+
+var _ = AfterEach(func() {
+	server.Close()
+})
+
+var _ = Describe("Debug", func() {
+	It("should serve profiles", func() {
+		response, err := http.Get(server.URL + "/debug/pprof/")
+		Expect(err).ToNot(HaveOccurred())
+		defer response.Body.Close()
+		Expect(response.StatusCode).To(Equal(http.StatusOK))
+	})
+	It("should serve provisioners and in flight nodes", func() {
+		provisioners = append(provisioners, &provisioning.Provisioner{Provisioner: &v1alpha5.Provisioner{ObjectMeta: metav1.ObjectMeta{Name: "default"}}})
+		cluster.Launched(ctx, test.Node(test.NodeOptions{
+			Name: "in-flight",
+			Labels: map[string]string{
+				v1alpha5.ProvisionerNameLabelKey: "default",
+				v1.LabelInstanceTypeStable:       "m5.large",
+				v1alpha5.LabelCapacityType:       "spot",
+				v1.LabelTopologyZone:             "test-zone-1",
+			},
+		}), v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")}, nil)
+		response, err := http.Get(server.URL + "/debug/provisioning")
+		Expect(err).ToNot(HaveOccurred())
+		defer response.Body.Close()
+		Expect(response.StatusCode).To(Equal(http.StatusOK))
+		snapshot := debug.Provisioning{}
+		Expect(json.NewDecoder(response.Body).Decode(&snapshot)).To(Succeed())
+		Expect(snapshot.Provisioners).To(HaveLen(1))
+		Expect(snapshot.Provisioners[0].Name).To(Equal("default"))
+		Expect(snapshot.InFlight).To(HaveLen(1))
+		Expect(snapshot.InFlight[0].Name).To(Equal("in-flight"))
+		Expect(snapshot.InFlight[0].Provisioner).To(Equal("default"))
+		Expect(snapshot.InFlight[0].InstanceType).To(Equal("m5.large"))
+		Expect(snapshot.InFlight[0].CapacityType).To(Equal("spot"))
+		Expect(snapshot.InFlight[0].Zone).To(Equal("test-zone-1"))
+		Expect(snapshot.InFlight[0].Requested.Cpu().String()).To(Equal("1"))
+	})
+	It("should serve an empty state", func() {
+		response, err := http.Get(server.URL + "/debug/provisioning")
+		Expect(err).ToNot(HaveOccurred())
+		defer response.Body.Close()
+		snapshot := debug.Provisioning{}
+		Expect(json.NewDecoder(response.Body).Decode(&snapshot)).To(Succeed())
+		Expect(snapshot.Provisioners).To(BeEmpty())
+		Expect(snapshot.InFlight).To(BeEmpty())
+	})
+})
+
+type fakeProvisioners []*provisioning.Provisioner
+
+func (f *fakeProvisioners) List(context.Context) []*provisioning.Provisioner {
+	return *f
+}
@@ -0,0 +1,116 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package events
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// Recorder is used to record events that occur about Karpenter's resources.
+type Recorder interface {
+	// NodeLimitExceeded is called when a provisioner is unable to launch nodes
+	// because it owns the maximum number of nodes allowed by its limits.
+	NodeLimitExceeded(provisioner *v1alpha5.Provisioner, maxNodes int32, pendingPods int)
+	// ProvisionerSelectionFailed is called when a pod that explicitly selects a
+	// provisioner cannot be provisioned by it.
+	ProvisionerSelectionFailed(pod *v1.Pod, err error)
+	// ProvisioningFailed is called with a diagnosis when Karpenter decides that
+	// it cannot provision capacity for a pod, e.g. because no provisioner
+	// matches it or its requests do not fit any instance type.
+	ProvisioningFailed(pod *v1.Pod, err error)
+	// NodeLaunched is called when a node is launched for a provisioner, with
+	// its instance type, zone and capacity type, and its price if it's known.
+	NodeLaunched(node *v1.Node)
+	// LaunchFailed is called when a provisioner fails to launch nodes, with the
+	// cloud provider's error codes if there are any.
+	LaunchFailed(provisioner *v1alpha5.Provisioner, err error)
+	// NodeExpired is called when a node is terminated because it outlived its
+	// provisioner's ttlSecondsUntilExpired.
+	NodeExpired(node *v1.Node, ttl time.Duration)
+	// NodeDrifted is called when a node no longer matches its provisioner.
+	NodeDrifted(node *v1.Node, reason string)
+	// NodeConsolidated is called when a node is terminated or replaced to
+	// consolidate the provisioner's capacity.
+	NodeConsolidated(node *v1.Node, reason string)
+	// ControllersDegraded is called when a controller breaches its error rate
+	// or reconcile latency threshold, and ControllersRecovered once none do.
+	ControllersDegraded(status *v1alpha5.KarpenterStatus, message string)
+	ControllersRecovered(status *v1alpha5.KarpenterStatus)
+}
+
+type recorder struct {
+	record.EventRecorder
+}
+
+// NewRecorder wraps the event recorder to publish Karpenter's events
+func NewRecorder(r record.EventRecorder) Recorder {
+	return recorder{EventRecorder: r}
+}
+
+func (r recorder) NodeLimitExceeded(provisioner *v1alpha5.Provisioner, maxNodes int32, pendingPods int) {
+	r.Eventf(provisioner, v1.EventTypeWarning, "NodeLimitExceeded", "Node limit of %d exceeded, %d pod(s) left pending", maxNodes, pendingPods)
+}
+
+func (r recorder) ProvisionerSelectionFailed(pod *v1.Pod, err error) {
+	r.Eventf(pod, v1.EventTypeWarning, "ProvisionerSelectionFailed", "Failed to select provisioner, %s", err.Error())
+}
+
+func (r recorder) ProvisioningFailed(pod *v1.Pod, err error) {
+	r.Eventf(pod, v1.EventTypeWarning, "ProvisioningFailed", "Failed to provision pod, %s", err.Error())
+}
+
+func (r recorder) NodeLaunched(node *v1.Node) {
+	message := fmt.Sprintf("Launched %s %s node in %s",
+		node.Labels[v1.LabelInstanceTypeStable], node.Labels[v1alpha5.LabelCapacityType], node.Labels[v1.LabelTopologyZone])
+	if price, ok := node.Annotations[v1alpha5.PriceAnnotationKey]; ok {
+		message = fmt.Sprintf("%s at $%s/hour", message, price)
+	}
+	r.Eventf(node, v1.EventTypeNormal, "Launched", "%s for provisioner %s", message, node.Labels[v1alpha5.ProvisionerNameLabelKey])
+}
+
+func (r recorder) LaunchFailed(provisioner *v1alpha5.Provisioner, err error) {
+	if codes := cloudprovider.ErrorCodes(err); len(codes) > 0 {
+		r.Eventf(provisioner, v1.EventTypeWarning, "LaunchFailed", "Failed to launch node(s) with error code(s) %s, %s", strings.Join(codes, ", "), err.Error())
+		return
+	}
+	r.Eventf(provisioner, v1.EventTypeWarning, "LaunchFailed", "Failed to launch node(s), %s", err.Error())
+}
+
+func (r recorder) NodeExpired(node *v1.Node, ttl time.Duration) {
+	r.Eventf(node, v1.EventTypeNormal, "Expired", "Terminating node after it expired, %s after it was created", ttl)
+}
+
+func (r recorder) NodeDrifted(node *v1.Node, reason string) {
+	r.Eventf(node, v1.EventTypeNormal, "Drifted", "Node drifted from its provisioner, %s", reason)
+}
+
+func (r recorder) NodeConsolidated(node *v1.Node, reason string) {
+	r.Eventf(node, v1.EventTypeNormal, "Consolidated", "Consolidating node, %s", reason)
+}
+
+func (r recorder) ControllersDegraded(status *v1alpha5.KarpenterStatus, message string) {
+	r.Eventf(status, v1.EventTypeWarning, "Degraded", "Controllers degraded, %s", message)
+}
+
+func (r recorder) ControllersRecovered(status *v1alpha5.KarpenterStatus) {
+	r.Eventf(status, v1.EventTypeNormal, "Recovered", "Controllers recovered")
+}
@@ -0,0 +1,93 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package events_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/events"
+	"github.com/aws/karpenter/pkg/test"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+var fakeRecorder *record.FakeRecorder
+var recorder events.Recorder
+
+func TestEvents(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Events")
+}
+
+var _ = BeforeEach(func() {
+	fakeRecorder = record.NewFakeRecorder(10)
+	recorder = events.NewRecorder(fakeRecorder)
+})
+
+var _ = Describe("Events", func() {
+	It("should record launched nodes with their offering", func() {
+		recorder.NodeLaunched(test.Node(test.NodeOptions{
+			Labels: map[string]string{
+				v1alpha5.ProvisionerNameLabelKey: "default",
+				v1.LabelInstanceTypeStable:       "m5.large",
+				v1alpha5.LabelCapacityType:       "spot",
+				v1.LabelTopologyZone:             "test-zone-1",
+			},
+			Annotations: map[string]string{v1alpha5.PriceAnnotationKey: "0.096"},
+		}))
+		Expect(fakeRecorder.Events).To(Receive(Equal("Normal Launched Launched m5.large spot node in test-zone-1 at $0.096/hour for provisioner default")))
+	})
+	It("should record launched nodes without a price", func() {
+		recorder.NodeLaunched(test.Node(test.NodeOptions{
+			Labels: map[string]string{
+				v1alpha5.ProvisionerNameLabelKey: "default",
+				v1.LabelInstanceTypeStable:       "m5.large",
+				v1alpha5.LabelCapacityType:       "on-demand",
+				v1.LabelTopologyZone:             "test-zone-1",
+			},
+		}))
+		Expect(fakeRecorder.Events).To(Receive(Equal("Normal Launched Launched m5.large on-demand node in test-zone-1 for provisioner default")))
+	})
+	It("should record the cloud provider's codes of launch failures", func() {
+		provisioner := &v1alpha5.Provisioner{ObjectMeta: metav1.ObjectMeta{Name: "default"}}
+		recorder.LaunchFailed(provisioner, cloudprovider.NewInsufficientCapacityError(
+			cloudprovider.NewCodedError([]string{"InsufficientInstanceCapacity", "UnfulfillableCapacity"}, fmt.Errorf("with fleet error(s)"))))
+		Expect(fakeRecorder.Events).To(Receive(And(
+			HavePrefix("Warning LaunchFailed"),
+			ContainSubstring("error code(s) InsufficientInstanceCapacity, UnfulfillableCapacity"),
+		)))
+		recorder.LaunchFailed(provisioner, fmt.Errorf("creating fleet, %w", awserr.New("UnauthorizedOperation", "not authorized", nil)))
+		Expect(fakeRecorder.Events).To(Receive(ContainSubstring("error code(s) UnauthorizedOperation")))
+		recorder.LaunchFailed(provisioner, fmt.Errorf("timed out"))
+		Expect(fakeRecorder.Events).To(Receive(Equal("Warning LaunchFailed Failed to launch node(s), timed out")))
+	})
+	It("should record disruptions of nodes", func() {
+		node := test.Node()
+		recorder.NodeExpired(node, 30*time.Second)
+		Expect(fakeRecorder.Events).To(Receive(Equal("Normal Expired Terminating node after it expired, 30s after it was created")))
+		recorder.NodeDrifted(node, "image changed")
+		Expect(fakeRecorder.Events).To(Receive(Equal("Normal Drifted Node drifted from its provisioner, image changed")))
+		recorder.NodeConsolidated(node, "its pods fit on other nodes")
+		Expect(fakeRecorder.Events).To(Receive(Equal("Normal Consolidated Consolidating node, its pods fit on other nodes")))
+	})
+})
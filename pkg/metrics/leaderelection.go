@@ -0,0 +1,76 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/client-go/tools/leaderelection"
+)
+
+const (
+	leaderElectionSubsystem = "leader_election"
+
+	LeaseLabel = "lease"
+)
+
+// Metrics of the leadership of this replica, so that failovers of HA
+// deployments can be observed.
+var (
+	Leader = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Subsystem: leaderElectionSubsystem,
+			Name:      "leader",
+			Help:      "Whether this replica leads, 1 if it holds the lease and 0 otherwise. Broken down by lease.",
+		},
+		[]string{LeaseLabel},
+	)
+	LeaderAcquiredTime = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Subsystem: leaderElectionSubsystem,
+			Name:      "acquired_timestamp_seconds",
+			Help:      "Unix time at which this replica acquired the lease. Broken down by lease.",
+		},
+		[]string{LeaseLabel},
+	)
+)
+
+// RegisterLeaderElectionMetrics registers the metrics of leadership and
+// observes them for leader election. It must be called before the manager is
+// created, since leader election only reads its metrics provider once.
+func RegisterLeaderElectionMetrics(registry prometheus.Registerer) {
+	registry.MustRegister(Leader, LeaderAcquiredTime)
+	leaderelection.SetProvider(leaderMetricsProvider{})
+}
+
+type leaderMetricsProvider struct{}
+
+func (leaderMetricsProvider) NewLeaderMetric() leaderelection.SwitchMetric {
+	return leaderMetric{}
+}
+
+type leaderMetric struct{}
+
+func (leaderMetric) On(lease string) {
+	Leader.WithLabelValues(lease).Set(1)
+	LeaderAcquiredTime.WithLabelValues(lease).Set(float64(time.Now().Unix()))
+}
+
+func (leaderMetric) Off(lease string) {
+	Leader.WithLabelValues(lease).Set(0)
+}
@@ -0,0 +1,93 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	InstanceTypeLabel = "instance_type"
+	CapacityTypeLabel = "capacity_type"
+
+	provisioningSubsystem = "provisioner"
+
+	// ErrorClassInsufficientCapacity is a launch that failed because none of
+	// the offerings had capacity
+	ErrorClassInsufficientCapacity = "insufficient_capacity"
+	// ErrorClassTimeout is a launch that was cancelled or timed out
+	ErrorClassTimeout = "timeout"
+	// ErrorClassUnknown is any other failed launch
+	ErrorClassUnknown = "unknown"
+)
+
+// Metrics of provisioning decisions. They're observed regardless, but only
+// served if RegisterProvisioningMetrics registers them, since the instance
+// type label multiplies their series.
+var (
+	PodBindLatency = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Subsystem: provisioningSubsystem,
+			Name:      "pod_bind_latency_seconds",
+			Help:      "Duration from when a pod failed to schedule until it was bound to a launched node in seconds. Broken down by provisioner.",
+			Buckets:   DurationBuckets(),
+		},
+		[]string{ProvisionerLabel},
+	)
+	NodesLaunched = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: provisioningSubsystem,
+			Name:      "nodes_launched_total",
+			Help:      "Number of nodes launched. Broken down by provisioner, instance type and capacity type.",
+		},
+		[]string{ProvisionerLabel, InstanceTypeLabel, CapacityTypeLabel},
+	)
+	LaunchFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: provisioningSubsystem,
+			Name:      "launch_failures_total",
+			Help:      "Number of failed attempts to launch nodes. Broken down by provisioner and error class, one of insufficient_capacity, timeout or unknown.",
+		},
+		[]string{ProvisionerLabel, ErrorLabel},
+	)
+	BatchSize = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Subsystem: provisioningSubsystem,
+			Name:      "batch_size",
+			Help:      "Number of pending pods in each batch that nodes are launched for. Broken down by provisioner.",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+		},
+		[]string{ProvisionerLabel},
+	)
+	SimulationDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Subsystem: "scheduling",
+			Name:      "simulation_duration_seconds",
+			Help:      "Duration of scheduling simulations, e.g. of consolidation, in seconds.",
+			Buckets:   DurationBuckets(),
+		},
+	)
+)
+
+// RegisterProvisioningMetrics registers the metrics of provisioning decisions,
+// so that they're served by the registry's metrics server
+func RegisterProvisioningMetrics(registry prometheus.Registerer) {
+	registry.MustRegister(PodBindLatency, NodesLaunched, LaunchFailures, BatchSize, SimulationDuration)
+}
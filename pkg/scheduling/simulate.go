@@ -0,0 +1,143 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package scheduling exposes Karpenter's placement logic so that it can be
+// embedded by other tools, e.g. to estimate the cost of a workload or to
+// enforce policies in CI, without running against a cluster.
+package scheduling
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/controllers/provisioning/binpacking"
+	"github.com/aws/karpenter/pkg/controllers/provisioning/scheduling"
+	"github.com/aws/karpenter/pkg/metrics"
+	"github.com/aws/karpenter/pkg/utils/functional"
+	v1 "k8s.io/api/core/v1"
+)
+
+// Node is a node that would be launched to run a set of pods.
+type Node struct {
+	// Provisioner is the name of the provisioner that would launch the node.
+	Provisioner string
+	// Constraints are the provisioner's constraints, tightened to the pods.
+	Constraints *v1alpha5.Constraints
+	// InstanceTypeOptions are the viable instance types, sorted by resources.
+	InstanceTypeOptions []cloudprovider.InstanceType
+	// Pods are the pods that would be bound to the node.
+	Pods []*v1.Pod
+}
+
+// Result is the outcome of a simulation.
+type Result struct {
+	// Nodes are the nodes that would be launched.
+	Nodes []*Node
+	// Unschedulable are the pods that no provisioner is able to run.
+	Unschedulable []*v1.Pod
+}
+
+// Simulate computes the nodes that Karpenter would launch for the pods, as if
+// they were all pending in an empty cluster at the same time. Provisioners are
+// selected for each pod in the same way as the selection controller, and the
+// instance types are those that the cloud provider would offer. The inputs are
+// not modified.
+func Simulate(ctx context.Context, pods []*v1.Pod, provisioners []*v1alpha5.Provisioner, instanceTypes []cloudprovider.InstanceType) (*Result, error) {
+	defer metrics.Measure(metrics.SimulationDuration)()
+	result := &Result{}
+	// Select a provisioner for each pod, in order of priority
+	provisioners = prepare(provisioners, instanceTypes)
+	selected := map[string][]*v1.Pod{}
+	for _, pod := range pods {
+		pod = pod.DeepCopy()
+		if provisioner := selectProvisioner(pod, provisioners); provisioner != nil {
+			selected[provisioner.Name] = append(selected[provisioner.Name], pod)
+		} else {
+			result.Unschedulable = append(result.Unschedulable, pod)
+		}
+	}
+	// Solve and pack the pods for each provisioner
+	scheduler := scheduling.NewScheduler(nil)
+	for _, provisioner := range provisioners {
+		if len(selected[provisioner.Name]) == 0 {
+			continue
+		}
+		schedules, err := scheduler.Solve(ctx, provisioner, instanceTypes, selected[provisioner.Name])
+		if err != nil {
+			return nil, fmt.Errorf("solving scheduling constraints for provisioner/%s, %w", provisioner.Name, err)
+		}
+		packed := map[*v1.Pod]bool{}
+		for _, schedule := range schedules {
+			packings, err := binpacking.Pack(ctx, schedule.Constraints, schedule.Pods, instanceTypes, nil, provisioner.Spec.Provisioning.PackingStrategy)
+			if err != nil {
+				return nil, fmt.Errorf("binpacking pods for provisioner/%s, %w", provisioner.Name, err)
+			}
+			for _, packing := range packings {
+				for _, nodePods := range packing.Pods {
+					for _, pod := range nodePods {
+						packed[pod] = true
+					}
+					result.Nodes = append(result.Nodes, &Node{
+						Provisioner:         provisioner.Name,
+						Constraints:         schedule.Constraints,
+						InstanceTypeOptions: packing.InstanceTypeOptions,
+						Pods:                nodePods,
+					})
+				}
+			}
+		}
+		for _, pod := range selected[provisioner.Name] {
+			if !packed[pod] {
+				result.Unschedulable = append(result.Unschedulable, pod)
+			}
+		}
+	}
+	return result, nil
+}
+
+// prepare returns copies of the provisioners sorted by priority, with the same
+// defaulted labels and requirements as the provisioning controller.
+func prepare(provisioners []*v1alpha5.Provisioner, instanceTypes []cloudprovider.InstanceType) []*v1alpha5.Provisioner {
+	prepared := []*v1alpha5.Provisioner{}
+	for _, provisioner := range provisioners {
+		provisioner = provisioner.DeepCopy()
+		provisioner.Spec.Labels = functional.UnionStringMaps(provisioner.Spec.Labels, map[string]string{v1alpha5.ProvisionerNameLabelKey: provisioner.Name})
+		provisioner.Spec.Requirements = provisioner.Spec.Requirements.
+			With(cloudprovider.Requirements(instanceTypes)).
+			With(v1alpha5.LabelRequirements(provisioner.Spec.Labels)).
+			Consolidate()
+		prepared = append(prepared, provisioner)
+	}
+	sort.Slice(prepared, func(i, j int) bool { return prepared[i].Name < prepared[j].Name })
+	return prepared
+}
+
+// selectProvisioner returns the first provisioner that is able to run the pod,
+// or nil if there are none. Pods that select a provisioner by name are only
+// considered for that provisioner.
+func selectProvisioner(pod *v1.Pod, provisioners []*v1alpha5.Provisioner) *v1alpha5.Provisioner {
+	name, named := pod.Labels[v1alpha5.ProvisionerNameLabelKey]
+	for _, provisioner := range provisioners {
+		if named && provisioner.Name != name {
+			continue
+		}
+		if err := scheduling.Filter(pod, provisioner.Spec.Constraints.DeepCopy()); err == nil {
+			return provisioner
+		}
+	}
+	return nil
+}
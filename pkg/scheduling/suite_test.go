@@ -0,0 +1,433 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduling_test
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
+	"github.com/aws/karpenter/pkg/cloudprovider"
+	"github.com/aws/karpenter/pkg/cloudprovider/fake"
+	provisionerscheduling "github.com/aws/karpenter/pkg/controllers/provisioning/scheduling"
+	"github.com/aws/karpenter/pkg/scheduling"
+	"github.com/aws/karpenter/pkg/test"
+	"github.com/aws/karpenter/pkg/utils/resources"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	. "knative.dev/pkg/logging/testing"
+	"knative.dev/pkg/ptr"
+)
+
+var ctx context.Context
+var provisioner *v1alpha5.Provisioner
+var instanceTypes []cloudprovider.InstanceType
+
+func TestScheduling(t *testing.T) {
+	ctx = TestContextWithLogger(t)
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Scheduling")
+}
+
+var _ = BeforeEach(func() {
+	provisioner = &v1alpha5.Provisioner{ObjectMeta: metav1.ObjectMeta{Name: "default"}}
+	instanceTypes = fake.InstanceTypes(4)
+})
+
+func pods(count int, cpu string) []*v1.Pod {
+	return test.Pods(count, test.PodOptions{
+		ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse(cpu)}},
+	})
+}
+
+func scheduledPods(result *scheduling.Result) (count int) {
+	for _, node := range result.Nodes {
+		count += len(node.Pods)
+	}
+	return count
+}
+
+// rejectLabel is a constraint plugin that rejects pods with the label
+type rejectLabel string
+
+func (r rejectLabel) Filter(pod *v1.Pod, _ *v1alpha5.Constraints) error {
+	if _, ok := pod.Labels[string(r)]; ok {
+		return fmt.Errorf("pod has label %s", string(r))
+	}
+	return nil
+}
+
+var _ = Describe("Simulate", func() {
+	It("should pack pods onto nodes", func() {
+		result, err := scheduling.Simulate(ctx, pods(10, "1"), []*v1alpha5.Provisioner{provisioner}, instanceTypes)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Unschedulable).To(BeEmpty())
+		Expect(scheduledPods(result)).To(Equal(10))
+		for _, node := range result.Nodes {
+			Expect(node.Provisioner).To(Equal(provisioner.Name))
+			Expect(node.InstanceTypeOptions).ToNot(BeEmpty())
+			Expect(node.Constraints.Labels).To(HaveKeyWithValue(v1alpha5.ProvisionerNameLabelKey, provisioner.Name))
+		}
+	})
+	It("should pack a batch onto a mix of instance types to minimize price", func() {
+		instanceTypes = []cloudprovider.InstanceType{
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "small", CPU: resource.MustParse("4"), Memory: resource.MustParse("4Gi")}),
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "large", CPU: resource.MustParse("64"), Memory: resource.MustParse("64Gi"), Pods: resource.MustParse("100")}),
+		}
+		shapes := func(result *scheduling.Result) map[string]int {
+			shapes := map[string]int{}
+			for _, node := range result.Nodes {
+				shapes[node.InstanceTypeOptions[0].Name()]++
+			}
+			return shapes
+		}
+		// Two small nodes are cheaper than one large node
+		result, err := scheduling.Simulate(ctx, pods(2, "3"), []*v1alpha5.Provisioner{provisioner}, instanceTypes)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(shapes(result)).To(Equal(map[string]int{"small": 2}))
+		// Pods that overflow a large node are packed onto a small node
+		result, err = scheduling.Simulate(ctx, pods(66, "1"), []*v1alpha5.Provisioner{provisioner}, instanceTypes)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(scheduledPods(result)).To(Equal(66))
+		Expect(shapes(result)).To(Equal(map[string]int{"small": 1, "large": 1}))
+	})
+	It("should pack a batch onto the instance types with the lowest offering prices", func() {
+		offerings := func(price float64) []cloudprovider.Offering {
+			return []cloudprovider.Offering{{CapacityType: "on-demand", Zone: "test-zone-1", Price: price}}
+		}
+		shapes := func(result *scheduling.Result) map[string]int {
+			shapes := map[string]int{}
+			for _, node := range result.Nodes {
+				shapes[node.InstanceTypeOptions[0].Name()]++
+			}
+			return shapes
+		}
+		// One large node is cheaper than two small nodes
+		instanceTypes = []cloudprovider.InstanceType{
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "small", CPU: resource.MustParse("4"), Memory: resource.MustParse("4Gi"), Offerings: offerings(1)}),
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "large", CPU: resource.MustParse("64"), Memory: resource.MustParse("64Gi"), Pods: resource.MustParse("100"), Offerings: offerings(1.5)}),
+		}
+		result, err := scheduling.Simulate(ctx, pods(2, "3"), []*v1alpha5.Provisioner{provisioner}, instanceTypes)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(shapes(result)).To(Equal(map[string]int{"large": 1}))
+		// Unpriced instance types don't change the prices of the others
+		instanceTypes = append(instanceTypes, fake.NewInstanceType(fake.InstanceTypeOptions{Name: "medium", CPU: resource.MustParse("16"), Memory: resource.MustParse("16Gi"), Offerings: offerings(0)}))
+		result, err = scheduling.Simulate(ctx, pods(2, "3"), []*v1alpha5.Provisioner{provisioner}, instanceTypes)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(shapes(result)).To(Equal(map[string]int{"large": 1}))
+		// Unpriced instance types are estimated from their resources, at the
+		// rate of the priced instance types
+		instanceTypes[1] = fake.NewInstanceType(fake.InstanceTypeOptions{Name: "large", CPU: resource.MustParse("64"), Memory: resource.MustParse("64Gi"), Pods: resource.MustParse("100"), Offerings: offerings(0)})
+		result, err = scheduling.Simulate(ctx, pods(2, "3"), []*v1alpha5.Provisioner{provisioner}, instanceTypes)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(shapes(result)).To(Equal(map[string]int{"small": 2}))
+	})
+	It("should schedule pods by the required node affinity term with the cheapest instance type", func() {
+		offerings := func(price float64) []cloudprovider.Offering {
+			return []cloudprovider.Offering{{CapacityType: "on-demand", Zone: "test-zone-1", Price: price}}
+		}
+		instanceTypes = []cloudprovider.InstanceType{
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "small", Offerings: offerings(1)}),
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "large", CPU: resource.MustParse("64"), Offerings: offerings(2)}),
+		}
+		pod := pods(1, "1")[0]
+		pod.Spec.Affinity = &v1.Affinity{NodeAffinity: &v1.NodeAffinity{RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{
+			NodeSelectorTerms: []v1.NodeSelectorTerm{
+				{MatchExpressions: []v1.NodeSelectorRequirement{{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpIn, Values: []string{"large"}}}},
+				{MatchExpressions: []v1.NodeSelectorRequirement{{Key: v1.LabelInstanceTypeStable, Operator: v1.NodeSelectorOpIn, Values: []string{"small"}}}},
+			},
+		}}}
+		result, err := scheduling.Simulate(ctx, []*v1.Pod{pod}, []*v1alpha5.Provisioner{provisioner}, instanceTypes)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Nodes).To(HaveLen(1))
+		Expect(result.Nodes[0].InstanceTypeOptions).To(HaveLen(1))
+		Expect(result.Nodes[0].InstanceTypeOptions[0].Name()).To(Equal("small"))
+	})
+	It("should pack a batch using the provisioner's packing strategy", func() {
+		instanceTypes = []cloudprovider.InstanceType{
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "small", CPU: resource.MustParse("4"), Memory: resource.MustParse("4Gi")}),
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "large", CPU: resource.MustParse("64"), Memory: resource.MustParse("64Gi"), Pods: resource.MustParse("100")}),
+		}
+		for strategy, expected := range map[v1alpha5.PackingStrategy]map[string]int{
+			"":                                 {"small": 2},
+			v1alpha5.PackingStrategyLowestCost: {"small": 2},
+			v1alpha5.PackingStrategyFirstFitDecreasing: {"large": 1},
+			v1alpha5.PackingStrategyBestFit:            {"large": 1},
+			v1alpha5.PackingStrategyMinimizeNodes:      {"large": 1},
+		} {
+			provisioner.Spec.Provisioning.PackingStrategy = strategy
+			result, err := scheduling.Simulate(ctx, pods(2, "3"), []*v1alpha5.Provisioner{provisioner}, instanceTypes)
+			Expect(err).ToNot(HaveOccurred())
+			shapes := map[string]int{}
+			for _, node := range result.Nodes {
+				shapes[node.InstanceTypeOptions[0].Name()]++
+			}
+			Expect(shapes).To(Equal(expected), "strategy %q", strategy)
+		}
+	})
+	It("should place pods on the open node that they fit best", func() {
+		instanceTypes = []cloudprovider.InstanceType{
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "default", CPU: resource.MustParse("10"), Memory: resource.MustParse("100Gi"), Pods: resource.MustParse("100")}),
+		}
+		// Nodes have 9.9 allocatable CPUs after overhead. The 6, 5, and 4 CPU
+		// pods fill two nodes to 6 and 9 CPUs, then first fit places the 500m
+		// pod on the first node, while best fit places it on the fullest node.
+		batch := append(append(pods(1, "6"), pods(1, "5")...), append(pods(1, "4"), pods(1, "500m")...)...)
+		nodeCPUs := func(result *scheduling.Result) []int64 {
+			cpus := []int64{}
+			for _, node := range result.Nodes {
+				requests := resources.RequestsForPods(node.Pods...)
+				cpus = append(cpus, requests.Cpu().MilliValue())
+			}
+			return cpus
+		}
+		provisioner.Spec.Provisioning.PackingStrategy = v1alpha5.PackingStrategyFirstFitDecreasing
+		result, err := scheduling.Simulate(ctx, batch, []*v1alpha5.Provisioner{provisioner}, instanceTypes)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(nodeCPUs(result)).To(ConsistOf(int64(6500), int64(9000)))
+		provisioner.Spec.Provisioning.PackingStrategy = v1alpha5.PackingStrategyBestFit
+		result, err = scheduling.Simulate(ctx, batch, []*v1alpha5.Provisioner{provisioner}, instanceTypes)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(nodeCPUs(result)).To(ConsistOf(int64(6000), int64(9500)))
+	})
+	It("should pack pods with extended resources onto instance types that advertise them", func() {
+		instanceTypes = []cloudprovider.InstanceType{
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "default"}),
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "foo", ExtendedResources: v1.ResourceList{"vendor.com/foo": resource.MustParse("2")}}),
+		}
+		foo := test.Pods(3, test.PodOptions{
+			ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{"vendor.com/foo": resource.MustParse("1")}},
+		})
+		bar := test.UnschedulablePod(test.PodOptions{
+			ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{"vendor.com/bar": resource.MustParse("1")}},
+		})
+		result, err := scheduling.Simulate(ctx, append(foo, bar), []*v1alpha5.Provisioner{provisioner}, instanceTypes)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Nodes).To(HaveLen(2))
+		for _, node := range result.Nodes {
+			Expect(node.InstanceTypeOptions).To(HaveLen(1))
+			Expect(node.InstanceTypeOptions[0].Name()).To(Equal("foo"))
+		}
+		Expect(scheduledPods(result)).To(Equal(3))
+		Expect(result.Unschedulable).To(HaveLen(1))
+		Expect(result.Unschedulable[0].Name).To(Equal(bar.Name))
+	})
+	It("should pack pods onto instance types with enough ephemeral storage", func() {
+		instanceTypes = []cloudprovider.InstanceType{
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "small-disk", CPU: resource.MustParse("8"), EphemeralStorage: resource.MustParse("20Gi")}),
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "large-disk", CPU: resource.MustParse("8"), EphemeralStorage: resource.MustParse("200Gi")}),
+		}
+		storage := test.Pods(3, test.PodOptions{
+			ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1"), v1.ResourceEphemeralStorage: resource.MustParse("50Gi")}},
+		})
+		tooLarge := test.UnschedulablePod(test.PodOptions{
+			ResourceRequirements: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceEphemeralStorage: resource.MustParse("500Gi")}},
+		})
+		result, err := scheduling.Simulate(ctx, append(storage, tooLarge), []*v1alpha5.Provisioner{provisioner}, instanceTypes)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Nodes).To(HaveLen(1))
+		Expect(result.Nodes[0].InstanceTypeOptions[0].Name()).To(Equal("large-disk"))
+		Expect(scheduledPods(result)).To(Equal(3))
+		Expect(result.Unschedulable).To(HaveLen(1))
+		Expect(result.Unschedulable[0].Name).To(Equal(tooLarge.Name))
+	})
+	It("should reserve capacity for the provisioner's kubelet configuration", func() {
+		instanceTypes = []cloudprovider.InstanceType{
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "default", CPU: resource.MustParse("4"), Pods: resource.MustParse("100")}),
+		}
+		result, err := scheduling.Simulate(ctx, pods(6, "1"), []*v1alpha5.Provisioner{provisioner}, instanceTypes)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Nodes).To(HaveLen(2))
+		// Reserved resources are added to the instance type's default system reserved overhead
+		provisioner.Spec.KubeletConfiguration.KubeReserved = v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")}
+		result, err = scheduling.Simulate(ctx, pods(6, "1"), []*v1alpha5.Provisioner{provisioner}, instanceTypes)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Nodes).To(HaveLen(3))
+		// Reserved resources replace the instance type's default overhead for the same component
+		provisioner.Spec.KubeletConfiguration.KubeReserved = v1.ResourceList{v1.ResourceCPU: resource.MustParse("900m")}
+		provisioner.Spec.KubeletConfiguration.SystemReserved = v1.ResourceList{v1.ResourceCPU: resource.MustParse("0")}
+		result, err = scheduling.Simulate(ctx, pods(6, "1"), []*v1alpha5.Provisioner{provisioner}, instanceTypes)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Nodes).To(HaveLen(2))
+		// Max pods limits the pods packed onto each node
+		provisioner.Spec.KubeletConfiguration = v1alpha5.KubeletConfiguration{MaxPods: ptr.Int32(1)}
+		result, err = scheduling.Simulate(ctx, pods(6, "1"), []*v1alpha5.Provisioner{provisioner}, instanceTypes)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Nodes).To(HaveLen(6))
+		Expect(scheduledPods(result)).To(Equal(6))
+	})
+	It("should respect provisioner requirements", func() {
+		provisioner.Spec.Requirements = v1alpha5.Requirements{{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: []string{"test-zone-3"}}}
+		result, err := scheduling.Simulate(ctx, pods(1, "1"), []*v1alpha5.Provisioner{provisioner}, instanceTypes)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Nodes).To(HaveLen(1))
+		Expect(result.Nodes[0].Constraints.Requirements.Zones().UnsortedList()).To(ConsistOf("test-zone-3"))
+	})
+	It("should launch pods that render different label templates on different nodes", func() {
+		provisioner.Spec.LabelTemplates = map[string]string{"example.com/team": "{{ .Pod.Labels.team }}"}
+		teamA := test.Pods(2, test.PodOptions{Labels: map[string]string{"team": "a"}})
+		teamB := test.Pods(2, test.PodOptions{Labels: map[string]string{"team": "b"}})
+		result, err := scheduling.Simulate(ctx, append(teamA, teamB...), []*v1alpha5.Provisioner{provisioner}, instanceTypes)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Nodes).To(HaveLen(2))
+		for _, node := range result.Nodes {
+			Expect(node.Pods).To(HaveLen(2))
+			Expect(node.Constraints.Labels).To(HaveKeyWithValue("example.com/team", node.Pods[0].Labels["team"]))
+			Expect(node.Pods[1].Labels["team"]).To(Equal(node.Pods[0].Labels["team"]))
+		}
+	})
+	It("should return pods that no provisioner matches", func() {
+		pod := test.UnschedulablePod(test.PodOptions{NodeSelector: map[string]string{"foo": "bar"}})
+		result, err := scheduling.Simulate(ctx, []*v1.Pod{pod}, []*v1alpha5.Provisioner{provisioner}, instanceTypes)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Nodes).To(BeEmpty())
+		Expect(result.Unschedulable).To(HaveLen(1))
+		Expect(result.Unschedulable[0].Name).To(Equal(pod.Name))
+	})
+	It("should return pods that request multiple accelerator types", func() {
+		instanceTypes = []cloudprovider.InstanceType{
+			fake.NewInstanceType(fake.InstanceTypeOptions{Name: "accelerated", NvidiaGPUs: resource.MustParse("1"), AMDGPUs: resource.MustParse("1")}),
+		}
+		pod := test.UnschedulablePod(test.PodOptions{
+			ResourceRequirements: v1.ResourceRequirements{Limits: v1.ResourceList{
+				resources.NvidiaGPU: resource.MustParse("1"),
+				resources.AMDGPU:    resource.MustParse("1"),
+			}},
+		})
+		result, err := scheduling.Simulate(ctx, []*v1.Pod{pod}, []*v1alpha5.Provisioner{provisioner}, instanceTypes)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Nodes).To(BeEmpty())
+		Expect(result.Unschedulable).To(HaveLen(1))
+	})
+	It("should return pods that a registered constraint plugin rejects", func() {
+		provisionerscheduling.RegisterConstraintPlugin(rejectLabel("test-reject"))
+		rejected := test.UnschedulablePod(test.PodOptions{Labels: map[string]string{"test-reject": "true"}})
+		result, err := scheduling.Simulate(ctx, append(pods(1, "1"), rejected), []*v1alpha5.Provisioner{provisioner}, instanceTypes)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(scheduledPods(result)).To(Equal(1))
+		Expect(result.Unschedulable).To(HaveLen(1))
+		Expect(result.Unschedulable[0].Name).To(Equal(rejected.Name))
+	})
+	It("should only schedule pods that don't require an operating system to linux nodes", func() {
+		windows := fake.NewInstanceType(fake.InstanceTypeOptions{Name: "windows", OperatingSystems: sets.NewString(v1alpha5.OperatingSystemWindows)})
+		linux := fake.NewInstanceType(fake.InstanceTypeOptions{Name: "linux", OperatingSystems: sets.NewString(v1alpha5.OperatingSystemLinux)})
+		windowsPod := test.UnschedulablePod(test.PodOptions{NodeSelector: map[string]string{v1.LabelOSStable: v1alpha5.OperatingSystemWindows}})
+		// A provisioner of Windows nodes only runs pods that require Windows
+		result, err := scheduling.Simulate(ctx, append(pods(1, "1"), windowsPod), []*v1alpha5.Provisioner{provisioner}, []cloudprovider.InstanceType{windows})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Nodes).To(HaveLen(1))
+		Expect(result.Nodes[0].Pods).To(ConsistOf(windowsPod))
+		Expect(result.Unschedulable).To(HaveLen(1))
+		// Otherwise, they're packed onto linux instance types
+		result, err = scheduling.Simulate(ctx, append(pods(1, "1"), windowsPod), []*v1alpha5.Provisioner{provisioner}, []cloudprovider.InstanceType{windows, linux})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Unschedulable).To(BeEmpty())
+		Expect(result.Nodes).To(HaveLen(2))
+		for _, node := range result.Nodes {
+			Expect(node.InstanceTypeOptions).To(HaveLen(1))
+			if node.Pods[0].Name == windowsPod.Name {
+				Expect(node.InstanceTypeOptions[0].Name()).To(Equal("windows"))
+			} else {
+				Expect(node.InstanceTypeOptions[0].Name()).To(Equal("linux"))
+			}
+		}
+	})
+	It("should return pods that do not fit any instance type", func() {
+		result, err := scheduling.Simulate(ctx, pods(1, "100"), []*v1alpha5.Provisioner{provisioner}, instanceTypes)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Nodes).To(BeEmpty())
+		Expect(result.Unschedulable).To(HaveLen(1))
+	})
+	It("should prioritize provisioners alphabetically if multiple match", func() {
+		provisioner2 := provisioner.DeepCopy()
+		provisioner2.Name = "aaaaaaaaa"
+		result, err := scheduling.Simulate(ctx, pods(1, "1"), []*v1alpha5.Provisioner{provisioner, provisioner2}, instanceTypes)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Nodes).To(HaveLen(1))
+		Expect(result.Nodes[0].Provisioner).To(Equal(provisioner2.Name))
+	})
+	It("should only consider the provisioner selected by the pod's label", func() {
+		provisioner2 := provisioner.DeepCopy()
+		provisioner2.Name = "aaaaaaaaa"
+		pod := test.UnschedulablePod(test.PodOptions{Labels: map[string]string{v1alpha5.ProvisionerNameLabelKey: provisioner.Name}})
+		result, err := scheduling.Simulate(ctx, []*v1.Pod{pod}, []*v1alpha5.Provisioner{provisioner, provisioner2}, instanceTypes)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Nodes).To(HaveLen(1))
+		Expect(result.Nodes[0].Provisioner).To(Equal(provisioner.Name))
+	})
+	It("should pack identical inputs identically", func() {
+		input := append(pods(10, "1"), pods(10, "500m")...)
+		input = append(input, test.Pods(4, test.PodOptions{
+			Labels: map[string]string{"app": "test"},
+			TopologySpreadConstraints: []v1.TopologySpreadConstraint{{
+				MaxSkew:           1,
+				TopologyKey:       v1.LabelHostname,
+				WhenUnsatisfiable: v1.DoNotSchedule,
+				LabelSelector:     &metav1.LabelSelector{MatchLabels: map[string]string{"app": "test"}},
+			}},
+		})...)
+		summarize := func(result *scheduling.Result) []string {
+			nodes := []string{}
+			for _, node := range result.Nodes {
+				names := []string{}
+				for _, pod := range node.Pods {
+					names = append(names, pod.Name)
+				}
+				nodes = append(nodes, fmt.Sprintf("%s %v %v", node.InstanceTypeOptions[0].Name(), node.Constraints.Requirements.Zones().List(), names))
+			}
+			return nodes
+		}
+		var expected []string
+		for i := 0; i < 10; i++ {
+			rand.Shuffle(len(input), func(a, b int) { input[a], input[b] = input[b], input[a] })
+			rand.Shuffle(len(instanceTypes), func(a, b int) { instanceTypes[a], instanceTypes[b] = instanceTypes[b], instanceTypes[a] })
+			result, err := scheduling.Simulate(ctx, input, []*v1alpha5.Provisioner{provisioner}, instanceTypes)
+			Expect(err).ToNot(HaveOccurred())
+			if expected == nil {
+				expected = summarize(result)
+				continue
+			}
+			Expect(summarize(result)).To(Equal(expected))
+		}
+	})
+	It("should spread pods across zones without modifying them", func() {
+		input := test.Pods(3, test.PodOptions{
+			Labels: map[string]string{"app": "test"},
+			TopologySpreadConstraints: []v1.TopologySpreadConstraint{{
+				MaxSkew:           1,
+				TopologyKey:       v1.LabelTopologyZone,
+				WhenUnsatisfiable: v1.DoNotSchedule,
+				LabelSelector:     &metav1.LabelSelector{MatchLabels: map[string]string{"app": "test"}},
+			}},
+		})
+		result, err := scheduling.Simulate(ctx, input, []*v1alpha5.Provisioner{provisioner}, instanceTypes)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Nodes).To(HaveLen(3))
+		zones := []string{}
+		for _, node := range result.Nodes {
+			zones = append(zones, node.Constraints.Requirements.Zones().UnsortedList()...)
+		}
+		Expect(zones).To(ConsistOf("test-zone-1", "test-zone-2", "test-zone-3"))
+		for _, pod := range input {
+			Expect(pod.Spec.NodeSelector).To(BeEmpty())
+		}
+	})
+})
@@ -35,6 +35,7 @@ import (
 	"github.com/aws/karpenter/pkg/apis/provisioning/v1alpha5"
 	"github.com/aws/karpenter/pkg/controllers/provisioning"
 	"github.com/aws/karpenter/pkg/controllers/selection"
+	"github.com/aws/karpenter/pkg/controllers/state"
 )
 
 const (
@@ -128,6 +129,12 @@ func ExpectCleanedUp(ctx context.Context, c client.Client) {
 		nodes.Items[i].SetFinalizers([]string{})
 		Expect(c.Update(ctx, &nodes.Items[i])).To(Succeed())
 	}
+	scheduledCapacities := &v1alpha5.ScheduledCapacityList{}
+	Expect(c.List(ctx, scheduledCapacities)).To(Succeed())
+	for i := range scheduledCapacities.Items {
+		scheduledCapacities.Items[i].SetFinalizers([]string{})
+		Expect(c.Update(ctx, &scheduledCapacities.Items[i])).To(Succeed())
+	}
 	for _, object := range []client.Object{
 		&v1.Pod{},
 		&v1.Node{},
@@ -135,6 +142,9 @@ func ExpectCleanedUp(ctx context.Context, c client.Client) {
 		&v1beta1.PodDisruptionBudget{},
 		&v1.PersistentVolumeClaim{},
 		&v1alpha5.Provisioner{},
+		&v1alpha5.KarpenterStatus{},
+		&v1alpha5.PlacementDecision{},
+		&v1alpha5.ScheduledCapacity{},
 	} {
 		for _, namespace := range namespaces.Items {
 			wg.Add(1)
@@ -187,3 +197,16 @@ func ExpectReconcileSucceeded(ctx context.Context, reconciler reconcile.Reconcil
 	_, err := reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: key})
 	Expect(err).ToNot(HaveOccurred())
 }
+
+func ExpectReconcileFailed(ctx context.Context, reconciler reconcile.Reconciler, key client.ObjectKey) {
+	_, err := reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: key})
+	Expect(err).To(HaveOccurred())
+}
+
+// ExpectClusterStateCleanedUp forgets the nodes tracked as in flight, since
+// tests don't run the state controller to remove them once they're deleted
+func ExpectClusterStateCleanedUp(cluster *state.Cluster) {
+	for _, n := range cluster.InFlight() {
+		cluster.Forget(n.Node.Name)
+	}
+}
@@ -35,6 +35,7 @@ type NodeOptions struct {
 	Taints        []v1.Taint
 	Allocatable   v1.ResourceList
 	Finalizers    []string
+	ProviderID    string
 }
 
 func Node(overrides ...NodeOptions) *v1.Node {
@@ -69,6 +70,7 @@ func Node(overrides ...NodeOptions) *v1.Node {
 		Spec: v1.NodeSpec{
 			Unschedulable: options.Unschedulable,
 			Taints:        options.Taints,
+			ProviderID:    options.ProviderID,
 		},
 		Status: v1.NodeStatus{
 			Allocatable: options.Allocatable,
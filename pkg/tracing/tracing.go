@@ -0,0 +1,55 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package tracing describes the spans of the provisioning pipeline, from
+// selecting a provisioner for a pod to the registration of the node that it's
+// bound to. Spans are recorded with OpenTelemetry, and exported over OTLP if
+// the controller is configured with the endpoint of a collector.
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ServiceName identifies Karpenter's spans to the collector
+const ServiceName = "karpenter"
+
+// Attributes of spans
+const (
+	ProvisionerAttribute   = "provisioner"
+	PodAttribute           = "pod"
+	PodsAttribute          = "pods"
+	NodeAttribute          = "node"
+	NodesAttribute         = "nodes"
+	InstanceTypesAttribute = "instance_type_options"
+)
+
+// Start starts a span of the provisioning pipeline from the tracer provider
+// that's registered globally, which doesn't record spans until it's set.
+func Start(ctx context.Context, name string, options ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(ServiceName).Start(ctx, name, options...)
+}
+
+// SetError marks the span as failed with the error, if there is one
+func SetError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
@@ -0,0 +1,160 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package cron parses standard five field cron expressions
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxSearch bounds how far ahead the next time of a schedule is searched, so
+// that expressions that never match, e.g. "0 0 31 2 *", don't loop forever
+const maxSearch = 5 * 366 * 24 * time.Hour
+
+// Schedule is a parsed cron expression, with the minutes, hours, days of the
+// month, months and days of the week that it matches
+type Schedule struct {
+	minutes     bits
+	hours       bits
+	daysOfMonth bits
+	months      bits
+	daysOfWeek  bits
+	// If either day field is *, a time has to match both of them, since one
+	// matches every day. Otherwise, it only has to match either of them.
+	anyDayOfMonth bool
+	anyDayOfWeek  bool
+}
+
+type bits uint64
+
+func (b bits) has(i int) bool { return b&(1<<uint(i)) != 0 }
+
+type field struct {
+	name     string
+	min, max int
+}
+
+var (
+	minutes     = field{"minute", 0, 59}
+	hours       = field{"hour", 0, 23}
+	daysOfMonth = field{"day of month", 1, 31}
+	months      = field{"month", 1, 12}
+	// Both 0 and 7 are Sunday
+	daysOfWeek = field{"day of week", 0, 7}
+)
+
+// Parse parses an expression of five space separated fields: minute, hour, day
+// of month, month and day of week. Each field is *, a value, a range of values
+// such as 1-5, or a comma separated list of them, and * or ranges may have a
+// step such as */15.
+func Parse(expression string) (*Schedule, error) {
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields, but found %d in \"%s\"", len(fields), expression)
+	}
+	s := &Schedule{anyDayOfMonth: fields[2] == "*", anyDayOfWeek: fields[4] == "*"}
+	for i, f := range []struct {
+		field
+		into *bits
+	}{{minutes, &s.minutes}, {hours, &s.hours}, {daysOfMonth, &s.daysOfMonth}, {months, &s.months}, {daysOfWeek, &s.daysOfWeek}} {
+		parsed, err := f.parse(fields[i])
+		if err != nil {
+			return nil, err
+		}
+		*f.into = parsed
+	}
+	if s.daysOfWeek.has(7) {
+		s.daysOfWeek |= 1
+	}
+	return s, nil
+}
+
+func (f field) parse(value string) (bits, error) {
+	var b bits
+	for _, part := range strings.Split(value, ",") {
+		rangePart, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			parsed, err := strconv.Atoi(part[i+1:])
+			if err != nil || parsed < 1 {
+				return 0, fmt.Errorf("invalid step in %s \"%s\"", f.name, part)
+			}
+			rangePart, step = part[:i], parsed
+		}
+		start, end := f.min, f.max
+		if rangePart != "*" {
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if start, err = f.value(bounds[0]); err != nil {
+				return 0, err
+			}
+			end = start
+			if len(bounds) == 2 {
+				if end, err = f.value(bounds[1]); err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				// A value with a step, e.g. 5/15, starts a range at the value
+				end = f.max
+			}
+			if end < start {
+				return 0, fmt.Errorf("invalid range in %s \"%s\"", f.name, part)
+			}
+		}
+		for i := start; i <= end; i += step {
+			b |= 1 << uint(i)
+		}
+	}
+	return b, nil
+}
+
+func (f field) value(value string) (int, error) {
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < f.min || parsed > f.max {
+		return 0, fmt.Errorf("%s must be between %d and %d, but found \"%s\"", f.name, f.min, f.max, value)
+	}
+	return parsed, nil
+}
+
+func (s *Schedule) matchesDay(t time.Time) bool {
+	dayOfMonth, dayOfWeek := s.daysOfMonth.has(t.Day()), s.daysOfWeek.has(int(t.Weekday()))
+	if s.anyDayOfMonth || s.anyDayOfWeek {
+		return dayOfMonth && dayOfWeek
+	}
+	return dayOfMonth || dayOfWeek
+}
+
+// Next returns the first minute after the time that matches the schedule, in
+// the time's location, or false if none does within five years
+func (s *Schedule) Next(t time.Time) (time.Time, bool) {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	for limit := t.Add(maxSearch); t.Before(limit); {
+		switch {
+		case !s.months.has(int(t.Month())):
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case !s.hours.has(t.Hour()):
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case !s.minutes.has(t.Minute()):
+			t = t.Add(time.Minute)
+		default:
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,81 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cron
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestCron(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Cron Suite")
+}
+
+var _ = Describe("Cron", func() {
+	// Monday
+	now := time.Date(2022, time.March, 7, 8, 30, 0, 0, time.UTC)
+	ExpectNext := func(expression string, expected time.Time) {
+		schedule, err := Parse(expression)
+		Expect(err).ToNot(HaveOccurred())
+		next, ok := schedule.Next(now)
+		Expect(ok).To(BeTrue())
+		Expect(next).To(Equal(expected), expression)
+	}
+
+	Context("Parse", func() {
+		It("should fail on malformed expressions", func() {
+			for _, expression := range []string{"", "* * * *", "* * * * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "5-1 * * * *", "*/0 * * * *", "a * * * *"} {
+				_, err := Parse(expression)
+				Expect(err).To(HaveOccurred(), expression)
+			}
+		})
+	})
+	Context("Next", func() {
+		It("should return the next minute for every minute", func() {
+			ExpectNext("* * * * *", now.Add(time.Minute))
+		})
+		It("should return the next matching time of the day", func() {
+			ExpectNext("0 9 * * *", time.Date(2022, time.March, 7, 9, 0, 0, 0, time.UTC))
+			ExpectNext("0 8 * * *", time.Date(2022, time.March, 8, 8, 0, 0, 0, time.UTC))
+		})
+		It("should support lists, ranges and steps", func() {
+			ExpectNext("15,45 * * * *", time.Date(2022, time.March, 7, 8, 45, 0, 0, time.UTC))
+			ExpectNext("*/20 10-12 * * *", time.Date(2022, time.March, 7, 10, 0, 0, 0, time.UTC))
+			ExpectNext("10/20 * * * *", time.Date(2022, time.March, 7, 8, 50, 0, 0, time.UTC))
+		})
+		It("should match days of the week", func() {
+			ExpectNext("0 9 * * 6", time.Date(2022, time.March, 12, 9, 0, 0, 0, time.UTC))
+			ExpectNext("0 9 * * 0", time.Date(2022, time.March, 13, 9, 0, 0, 0, time.UTC))
+			ExpectNext("0 9 * * 7", time.Date(2022, time.March, 13, 9, 0, 0, 0, time.UTC))
+		})
+		It("should match either day field if both are restricted", func() {
+			ExpectNext("0 0 1 * 3", time.Date(2022, time.March, 9, 0, 0, 0, 0, time.UTC))
+			ExpectNext("0 0 8 * 5", time.Date(2022, time.March, 8, 0, 0, 0, 0, time.UTC))
+		})
+		It("should match months", func() {
+			ExpectNext("0 0 1 1 *", time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC))
+		})
+		It("should not find times that never match", func() {
+			schedule, err := Parse("0 0 31 2 *")
+			Expect(err).ToNot(HaveOccurred())
+			_, ok := schedule.Next(now)
+			Expect(ok).To(BeFalse())
+		})
+	})
+})
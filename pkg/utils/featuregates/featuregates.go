@@ -0,0 +1,89 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package featuregates
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Gate is the name of a feature that may be enabled or disabled
+type Gate string
+
+const (
+	// Drift replaces nodes that no longer match their provisioner
+	Drift Gate = "Drift"
+	// SpotInterruption drains nodes ahead of their interruption
+	SpotInterruption Gate = "SpotInterruption"
+	// ConsolidationPolicy replaces underutilized nodes with cheaper capacity
+	ConsolidationPolicy Gate = "ConsolidationPolicy"
+)
+
+// Defaults are whether each feature is enabled if no gate is set for it.
+// Experimental features are disabled by default.
+var Defaults = FeatureGates{
+	Drift:               false,
+	SpotInterruption:    true,
+	ConsolidationPolicy: false,
+}
+
+// FeatureGates enable or disable features by gate
+type FeatureGates map[Gate]bool
+
+// Parse a comma separated list of gate=bool pairs, e.g. Drift=true
+func Parse(value string) (FeatureGates, error) {
+	featureGates := FeatureGates{}
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("feature gates must be a comma separated list of gate=bool pairs, but found \"%s\"", pair)
+		}
+		gate := Gate(strings.TrimSpace(parts[0]))
+		if _, ok := Defaults[gate]; !ok {
+			return nil, fmt.Errorf("unknown feature gate %s, must be one of %s", gate, strings.Join(known(), ", "))
+		}
+		enabled, err := strconv.ParseBool(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("feature gate %s must be true or false, but found \"%s\"", gate, parts[1])
+		}
+		featureGates[gate] = enabled
+	}
+	return featureGates, nil
+}
+
+// Enabled returns whether the feature is enabled by the last of the feature
+// gates that sets its gate, or else by default
+func Enabled(gate Gate, featureGates ...FeatureGates) bool {
+	for i := len(featureGates) - 1; i >= 0; i-- {
+		if enabled, ok := featureGates[i][gate]; ok {
+			return enabled
+		}
+	}
+	return Defaults[gate]
+}
+
+func known() []string {
+	gates := []string{}
+	for gate := range Defaults {
+		gates = append(gates, string(gate))
+	}
+	sort.Strings(gates)
+	return gates
+}
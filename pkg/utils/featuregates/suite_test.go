@@ -0,0 +1,57 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package featuregates
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestFeatureGates(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "FeatureGates Suite")
+}
+
+var _ = Describe("FeatureGates", func() {
+	Context("Parse", func() {
+		It("should parse gates", func() {
+			Expect(Parse("Drift=true, SpotInterruption=false,")).To(Equal(FeatureGates{Drift: true, SpotInterruption: false}))
+		})
+		It("should parse an empty list", func() {
+			Expect(Parse("")).To(BeEmpty())
+		})
+		It("should fail on malformed gates", func() {
+			for _, value := range []string{"Drift", "=true", "Drift=maybe", "Unknown=true"} {
+				_, err := Parse(value)
+				Expect(err).To(HaveOccurred(), value)
+			}
+		})
+	})
+	Context("Enabled", func() {
+		It("should default experimental features to disabled", func() {
+			Expect(Enabled(Drift)).To(BeFalse())
+			Expect(Enabled(ConsolidationPolicy)).To(BeFalse())
+			Expect(Enabled(SpotInterruption)).To(BeTrue())
+		})
+		It("should prefer the last feature gates that set the gate", func() {
+			Expect(Enabled(Drift, FeatureGates{Drift: true})).To(BeTrue())
+			Expect(Enabled(Drift, FeatureGates{Drift: true}, FeatureGates{Drift: false})).To(BeFalse())
+			Expect(Enabled(Drift, FeatureGates{Drift: true}, FeatureGates{SpotInterruption: false})).To(BeTrue())
+			Expect(Enabled(Drift, FeatureGates{Drift: true}, nil)).To(BeTrue())
+		})
+	})
+})
@@ -17,9 +17,13 @@ package injection
 import (
 	"context"
 
+	"github.com/aws/karpenter/pkg/utils/featuregates"
 	"github.com/aws/karpenter/pkg/utils/options"
+	"github.com/aws/karpenter/pkg/utils/settings"
+	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 type resourceKey struct{}
@@ -64,6 +68,23 @@ func GetConfig(ctx context.Context) *rest.Config {
 	return retval.(*rest.Config)
 }
 
+type kubeClientKey struct{}
+
+// WithKubeClient injects a client for validation that depends on other
+// resources, e.g. the nodes of a provisioner
+func WithKubeClient(ctx context.Context, kubeClient client.Client) context.Context {
+	return context.WithValue(ctx, kubeClientKey{}, kubeClient)
+}
+
+// GetKubeClient returns the injected client, or nil if there is none
+func GetKubeClient(ctx context.Context) client.Client {
+	retval := ctx.Value(kubeClientKey{})
+	if retval == nil {
+		return nil
+	}
+	return retval.(client.Client)
+}
+
 type controllerNameKeyType struct{}
 
 var controllerNameKey = controllerNameKeyType{}
@@ -79,3 +100,60 @@ func GetControllerName(ctx context.Context) string {
 	}
 	return name.(string)
 }
+
+type launchIDKey struct{}
+
+// WithLaunchID identifies an attempt to launch nodes, so that cloud providers
+// can make retried requests of the attempt idempotent.
+func WithLaunchID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, launchIDKey{}, id)
+}
+
+func GetLaunchID(ctx context.Context) string {
+	id := ctx.Value(launchIDKey{})
+	if id == nil {
+		return ""
+	}
+	return id.(string)
+}
+
+type requestsKey struct{}
+
+// WithRequests records the resources requested by the pods that nodes are
+// being launched for, so that cloud providers can configure the launch for
+// them, e.g. with network interfaces for vpc.amazonaws.com/efa.
+func WithRequests(ctx context.Context, requests v1.ResourceList) context.Context {
+	return context.WithValue(ctx, requestsKey{}, requests)
+}
+
+func GetRequests(ctx context.Context) v1.ResourceList {
+	requests := ctx.Value(requestsKey{})
+	if requests == nil {
+		return v1.ResourceList{}
+	}
+	return requests.(v1.ResourceList)
+}
+
+type settingsKey struct{}
+
+// WithSettings injects the store of the latest settings, so that changes to
+// them are visible through contexts that were derived before they changed.
+func WithSettings(ctx context.Context, store *settings.Store) context.Context {
+	return context.WithValue(ctx, settingsKey{}, store)
+}
+
+func GetSettings(ctx context.Context) settings.Settings {
+	store := ctx.Value(settingsKey{})
+	if store == nil {
+		return settings.Defaults
+	}
+	return store.(*settings.Store).Get()
+}
+
+// IsFeatureEnabled returns whether the feature is enabled by the feature gates
+// of the settings, or else of the options, or else by default
+func IsFeatureEnabled(ctx context.Context, gate featuregates.Gate) bool {
+	// Invalid options are rejected when they are parsed
+	optionGates, _ := GetOptions(ctx).GetFeatureGates()
+	return featuregates.Enabled(gate, optionGates, GetSettings(ctx).FeatureGates)
+}
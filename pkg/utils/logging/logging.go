@@ -0,0 +1,130 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/client-go/kubernetes"
+	"knative.dev/pkg/configmap"
+	"knative.dev/pkg/logging"
+	"knative.dev/pkg/system"
+)
+
+// ReconcileIDKey is the key of the ID that correlates the logs of a reconcile
+const ReconcileIDKey = "reconcileID"
+
+var (
+	// levels override the level of the component for the logs of controllers,
+	// configured by the `loglevel.<controller>` keys of `config-logging`
+	levels   = map[string]zapcore.Level{}
+	levelsMu sync.RWMutex
+)
+
+// WithController returns a context with a logger named for the controller,
+// which logs at the controller's level if it's configured, or else at the
+// level of the component.
+func WithController(ctx context.Context, controller string) context.Context {
+	logger := logging.FromContext(ctx).Desugar().WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return &controllerCore{Core: core, controller: controller}
+	}))
+	return logging.WithLogger(ctx, logger.Named(controller).Sugar())
+}
+
+// WithReconcile returns a context with a logger for a reconcile of the
+// controller, which correlates its logs by the kind and name of the reconciled
+// object and by an ID that is unique to the reconcile.
+func WithReconcile(ctx context.Context, controller string, kind string, name string) context.Context {
+	ctx = WithController(ctx, controller)
+	return logging.WithLogger(ctx, logging.FromContext(ctx).With(kind, name, ReconcileIDKey, rand.String(8)))
+}
+
+// SetLevels replaces the levels of controllers, keyed by controller name.
+// Levels of names that aren't controllers, e.g. of the component, are ignored.
+func SetLevels(overrides map[string]zapcore.Level) {
+	levelsMu.Lock()
+	defer levelsMu.Unlock()
+	levels = map[string]zapcore.Level{}
+	for name, level := range overrides {
+		levels[name] = level
+	}
+}
+
+// UpdateLevelsFromConfigMap returns a func that updates the levels of
+// controllers from the `config-logging` ConfigMap
+func UpdateLevelsFromConfigMap(logger *zap.SugaredLogger) func(*v1.ConfigMap) {
+	return func(configMap *v1.ConfigMap) {
+		config, err := logging.NewConfigFromConfigMap(configMap)
+		if err != nil {
+			logger.Errorf("Failed to parse the levels of controllers, %s", err.Error())
+			return
+		}
+		SetLevels(config.LoggingLevel)
+	}
+}
+
+// WatchLevelsOrDie watches the levels of controllers in the `config-logging`
+// ConfigMap, if it exists, so that they live update like the component's.
+func WatchLevelsOrDie(ctx context.Context, cmw configmap.Watcher, clientSet kubernetes.Interface, logger *zap.SugaredLogger) {
+	if _, err := clientSet.CoreV1().ConfigMaps(system.Namespace()).Get(ctx, logging.ConfigMapName(), metav1.GetOptions{}); err == nil {
+		cmw.Watch(logging.ConfigMapName(), UpdateLevelsFromConfigMap(logger))
+	} else if !errors.IsNotFound(err) {
+		panic(fmt.Sprintf("Failed to read ConfigMap %s, %s", logging.ConfigMapName(), err.Error()))
+	}
+}
+
+func levelOf(controller string) (zapcore.Level, bool) {
+	levelsMu.RLock()
+	defer levelsMu.RUnlock()
+	level, ok := levels[controller]
+	return level, ok
+}
+
+// controllerCore logs entries at the level of its controller if it's
+// configured, bypassing the level of the wrapped core, or else defers to it
+type controllerCore struct {
+	zapcore.Core
+	controller string
+}
+
+func (c *controllerCore) Enabled(level zapcore.Level) bool {
+	if override, ok := levelOf(c.controller); ok {
+		return override.Enabled(level)
+	}
+	return c.Core.Enabled(level)
+}
+
+func (c *controllerCore) With(fields []zapcore.Field) zapcore.Core {
+	return &controllerCore{Core: c.Core.With(fields), controller: c.controller}
+}
+
+func (c *controllerCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	override, ok := levelOf(c.controller)
+	if !ok {
+		return c.Core.Check(entry, checked)
+	}
+	if override.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
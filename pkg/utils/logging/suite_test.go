@@ -0,0 +1,109 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"knative.dev/pkg/logging"
+)
+
+var ctx context.Context
+var logs *observer.ObservedLogs
+
+func TestLogging(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Logging Suite")
+}
+
+var _ = BeforeEach(func() {
+	var core zapcore.Core
+	core, logs = observer.New(zapcore.InfoLevel)
+	ctx = logging.WithLogger(context.Background(), zap.New(core).Sugar())
+})
+
+var _ = AfterEach(func() {
+	SetLevels(nil)
+})
+
+var _ = Describe("Logging", func() {
+	Context("Levels", func() {
+		It("should log at the level of the component by default", func() {
+			logger := logging.FromContext(WithController(ctx, "provisioning"))
+			logger.Debug("debug")
+			logger.Info("info")
+			Expect(logs.All()).To(HaveLen(1))
+			Expect(logs.All()[0].Message).To(Equal("info"))
+			Expect(logs.All()[0].LoggerName).To(Equal("provisioning"))
+		})
+		It("should log at the level of the controller if it's configured", func() {
+			SetLevels(map[string]zapcore.Level{"provisioning": zapcore.DebugLevel, "termination": zapcore.ErrorLevel})
+			logging.FromContext(WithController(ctx, "provisioning")).Debug("debug")
+			logging.FromContext(WithController(ctx, "termination")).Info("info")
+			logging.FromContext(WithController(ctx, "node")).Debug("debug")
+			Expect(logs.All()).To(HaveLen(1))
+			Expect(logs.All()[0].LoggerName).To(Equal("provisioning"))
+		})
+		It("should apply changes to the levels to existing loggers", func() {
+			logger := logging.FromContext(WithReconcile(ctx, "provisioning", "provisioner", "default"))
+			logger.Debug("before")
+			SetLevels(map[string]zapcore.Level{"provisioning": zapcore.DebugLevel})
+			logger.Debug("after")
+			Expect(logs.All()).To(HaveLen(1))
+			Expect(logs.All()[0].Message).To(Equal("after"))
+		})
+		It("should update the levels from the ConfigMap", func() {
+			UpdateLevelsFromConfigMap(logging.FromContext(ctx))(&v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: logging.ConfigMapName()},
+				Data:       map[string]string{"loglevel.controller": "info", "loglevel.provisioning": "debug"},
+			})
+			logging.FromContext(WithController(ctx, "provisioning")).Debug("debug")
+			Expect(logs.All()).To(HaveLen(1))
+		})
+		It("should keep the levels if the ConfigMap is invalid", func() {
+			SetLevels(map[string]zapcore.Level{"provisioning": zapcore.DebugLevel})
+			UpdateLevelsFromConfigMap(logging.FromContext(ctx))(&v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: logging.ConfigMapName()},
+				Data:       map[string]string{"loglevel.provisioning": "verbose"},
+			})
+			logging.FromContext(WithController(ctx, "provisioning")).Debug("debug")
+			Expect(logs.FilterMessage("debug").Len()).To(Equal(1))
+		})
+	})
+	Context("Reconcile", func() {
+		It("should correlate the logs of a reconcile", func() {
+			first := logging.FromContext(WithReconcile(ctx, "node", "node", "node-a"))
+			first.Info("first")
+			first.Info("second")
+			logging.FromContext(WithReconcile(ctx, "node", "node", "node-a")).Info("third")
+			entries := logs.All()
+			Expect(entries).To(HaveLen(3))
+			for _, entry := range entries {
+				Expect(entry.ContextMap()).To(HaveKeyWithValue("node", "node-a"))
+				Expect(entry.ContextMap()).To(HaveKey(ReconcileIDKey))
+			}
+			Expect(entries[0].ContextMap()[ReconcileIDKey]).To(Equal(entries[1].ContextMap()[ReconcileIDKey]))
+			Expect(entries[0].ContextMap()[ReconcileIDKey]).ToNot(Equal(entries[2].ContextMap()[ReconcileIDKey]))
+		})
+	})
+})
@@ -18,21 +18,17 @@ import (
 	"flag"
 	"fmt"
 	"net/url"
+	"strings"
+	"time"
 
 	"github.com/aws/karpenter/pkg/utils/env"
+	"github.com/aws/karpenter/pkg/utils/featuregates"
 	"go.uber.org/multierr"
 )
 
 func MustParse() Options {
 	opts := Options{}
-	flag.StringVar(&opts.ClusterName, "cluster-name", env.WithDefaultString("CLUSTER_NAME", ""), "The kubernetes cluster name for resource discovery")
-	flag.StringVar(&opts.ClusterEndpoint, "cluster-endpoint", env.WithDefaultString("CLUSTER_ENDPOINT", ""), "The external kubernetes cluster endpoint for new nodes to connect with")
-	flag.IntVar(&opts.MetricsPort, "metrics-port", env.WithDefaultInt("METRICS_PORT", 8080), "The port the metric endpoint binds to for operating metrics about the controller itself")
-	flag.IntVar(&opts.HealthProbePort, "health-probe-port", env.WithDefaultInt("HEALTH_PROBE_PORT", 8081), "The port the health probe endpoint binds to for reporting controller health")
-	flag.IntVar(&opts.WebhookPort, "port", 8443, "The port the webhook endpoint binds to for validation and mutation of resources")
-	flag.IntVar(&opts.KubeClientQPS, "kube-client-qps", env.WithDefaultInt("KUBE_CLIENT_QPS", 200), "The smoothed rate of qps to kube-apiserver")
-	flag.IntVar(&opts.KubeClientBurst, "kube-client-burst", env.WithDefaultInt("KUBE_CLIENT_BURST", 300), "The maximum allowed burst of queries to the kube-apiserver")
-	flag.StringVar(&opts.AWSNodeNameConvention, "aws-node-name-convention", env.WithDefaultString("AWS_NODE_NAME_CONVENTION", "ip-name"), "The node naming convention used by the AWS cloud provider. DEPRECATION WARNING: this field may be deprecated at any time")
+	opts.AddFlags(flag.CommandLine)
 	flag.Parse()
 	if err := opts.Validate(); err != nil {
 		panic(err)
@@ -40,26 +36,120 @@ func MustParse() Options {
 	return opts
 }
 
+// AddFlags registers the options as flags, defaulted from the environment, so
+// that other binaries such as the kubectl plugin configure the cloud provider
+// like the controller does
+func (o *Options) AddFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.ClusterName, "cluster-name", env.WithDefaultString("CLUSTER_NAME", ""), "The kubernetes cluster name for resource discovery")
+	fs.StringVar(&o.ClusterEndpoint, "cluster-endpoint", env.WithDefaultString("CLUSTER_ENDPOINT", ""), "The external kubernetes cluster endpoint for new nodes to connect with")
+	fs.IntVar(&o.MetricsPort, "metrics-port", env.WithDefaultInt("METRICS_PORT", 8080), "The port the metric endpoint binds to for operating metrics about the controller itself")
+	fs.IntVar(&o.HealthProbePort, "health-probe-port", env.WithDefaultInt("HEALTH_PROBE_PORT", 8081), "The port the health probe endpoint binds to for reporting controller health")
+	fs.IntVar(&o.DebugPort, "debug-port", env.WithDefaultInt("DEBUG_PORT", 0), "The localhost port that serves profiles under /debug/pprof and the state of provisioning under /debug/provisioning, e.g. through kubectl port-forward. Debug endpoints aren't served if 0")
+	fs.StringVar(&o.LeaderElectionNamespace, "leader-election-namespace", env.WithDefaultString("LEADER_ELECTION_NAMESPACE", ""), "The namespace of the lease that replicas elect a leader with. Defaults to the namespace the controller runs in")
+	fs.DurationVar(&o.LeaderElectionLeaseDuration, "leader-election-lease-duration", env.WithDefaultDuration("LEADER_ELECTION_LEASE_DURATION", 15*time.Second), "The duration that standby replicas wait before taking over the lease of a leader that stopped renewing it without releasing it")
+	fs.DurationVar(&o.LeaderElectionRenewDeadline, "leader-election-renew-deadline", env.WithDefaultDuration("LEADER_ELECTION_RENEW_DEADLINE", 10*time.Second), "The duration that the leader retries renewing its lease before it gives up leadership. Must be less than the lease duration")
+	fs.DurationVar(&o.LeaderElectionRetryPeriod, "leader-election-retry-period", env.WithDefaultDuration("LEADER_ELECTION_RETRY_PERIOD", 2*time.Second), "The duration between attempts to acquire or renew the lease, which bounds how quickly a standby replica takes over a released lease")
+	fs.IntVar(&o.WebhookPort, "port", 8443, "The port the webhook endpoint binds to for validation and mutation of resources")
+	fs.IntVar(&o.KubeClientQPS, "kube-client-qps", env.WithDefaultInt("KUBE_CLIENT_QPS", 200), "The smoothed rate of qps to kube-apiserver")
+	fs.IntVar(&o.KubeClientBurst, "kube-client-burst", env.WithDefaultInt("KUBE_CLIENT_BURST", 300), "The maximum allowed burst of queries to the kube-apiserver")
+	fs.StringVar(&o.AWSNodeNameConvention, "aws-node-name-convention", env.WithDefaultString("AWS_NODE_NAME_CONVENTION", "ip-name"), "The node naming convention used by the AWS cloud provider. DEPRECATION WARNING: this field may be deprecated at any time")
+	fs.BoolVar(&o.AWSENILimitedPodDensity, "aws-eni-limited-pod-density", env.WithDefaultBool("AWS_ENI_LIMITED_POD_DENSITY", true), "Indicates whether new nodes should use ENI-based pod density. Disable this if the CNI assigns pod IPs without ENI limits, e.g. with prefix delegation")
+	fs.StringVar(&o.AWSDefaultInstanceProfile, "aws-default-instance-profile", env.WithDefaultString("AWS_DEFAULT_INSTANCE_PROFILE", ""), "The instance profile to use for nodes of provisioners that don't specify one or a launch template")
+	fs.StringVar(&o.AWSDefaultKMSKeyID, "aws-default-kms-key-id", env.WithDefaultString("AWS_DEFAULT_KMS_KEY_ID", ""), "The KMS key to encrypt the root volumes of nodes with for provisioners that don't specify one or a launch template")
+	fs.StringVar(&o.AWSEndpointOverrides, "aws-endpoint-overrides", env.WithDefaultString("AWS_ENDPOINT_OVERRIDES", ""), "A comma separated list of service=URL pairs of AWS API endpoints to use instead of the region's defaults, e.g. VPC endpoints without private DNS or endpoints in partitions that the AWS SDK doesn't know")
+	fs.StringVar(&o.AWSInterruptionQueueName, "aws-interruption-queue-name", env.WithDefaultString("AWS_INTERRUPTION_QUEUE_NAME", ""), "The name of the SQS queue that EventBridge sends spot interruption warnings and rebalance recommendations to. Nodes are drained ahead of their interruption if set")
+	fs.StringVar(&o.CloudProviderPluginAddress, "cloud-provider-plugin-address", env.WithDefaultString("CLOUD_PROVIDER_PLUGIN_ADDRESS", ""), "The gRPC address of an out-of-process cloud provider plugin to use instead of the built in cloud provider, e.g. unix:///var/run/karpenter/cloudprovider.sock")
+	fs.DurationVar(&o.PendingPodRequeueInterval, "pending-pod-requeue-interval", env.WithDefaultDuration("PENDING_POD_REQUEUE_INTERVAL", 2*time.Minute), "The maximum duration before a pending pod is re-examined for provisioning, even if it was previously skipped or failed")
+	fs.StringVar(&o.FeatureGates, "feature-gates", env.WithDefaultString("FEATURE_GATES", ""), "A comma separated list of gate=bool pairs that enable or disable features, e.g. Drift=true. Feature gates of the global settings take precedence")
+	fs.BoolVar(&o.ProvisioningMetrics, "provisioning-metrics", env.WithDefaultBool("PROVISIONING_METRICS", false), "Serves metrics of provisioning decisions, e.g. nodes launched by instance type and pod bind latency, on the metrics endpoint")
+	fs.StringVar(&o.TracingEndpoint, "tracing-endpoint", env.WithDefaultString("TRACING_ENDPOINT", ""), "The address of an OpenTelemetry Collector's OTLP gRPC receiver to export spans of the provisioning pipeline to, e.g. otel-collector.monitoring:4317. Spans aren't exported if unset")
+	fs.Float64Var(&o.TracingSampleRatio, "tracing-sample-ratio", env.WithDefaultFloat64("TRACING_SAMPLE_RATIO", 0.1), "The fraction of provisioning batches and pod selections that are traced, between 0 and 1")
+	fs.DurationVar(&o.PlacementDecisionTTL, "placement-decision-ttl", env.WithDefaultDuration("PLACEMENT_DECISION_TTL", 24*time.Hour), "The duration that a PlacementDecision is kept for each launched node before it is garbage collected. Set to 0 to disable recording placement decisions")
+	fs.StringVar(&o.AuditSink, "audit-sink", env.WithDefaultString("AUDIT_SINK", ""), "The URI of the sink that every decision to create or delete a node is recorded to, one of file:///path, s3://bucket/prefix?region=region or an http(s):// webhook. Decisions aren't audited if unset")
+	fs.DurationVar(&o.HealthWindow, "health-window", env.WithDefaultDuration("HEALTH_WINDOW", 5*time.Minute), "The duration over which each controller's reconcile error rate and latency are measured to report Karpenter's health on the KarpenterStatus")
+	fs.Float64Var(&o.HealthErrorRateThreshold, "health-error-rate-threshold", env.WithDefaultFloat64("HEALTH_ERROR_RATE_THRESHOLD", 0.25), "The fraction of a controller's reconciles in the health window that may fail before Karpenter is reported Degraded, between 0 and 1")
+	fs.DurationVar(&o.HealthLatencyThreshold, "health-latency-threshold", env.WithDefaultDuration("HEALTH_LATENCY_THRESHOLD", 30*time.Second), "The 99th percentile of a controller's reconcile latency in the health window above which Karpenter is reported Degraded")
+}
+
 // Options for running this binary
 type Options struct {
-	ClusterName           string
-	ClusterEndpoint       string
-	MetricsPort           int
-	HealthProbePort       int
-	WebhookPort           int
-	KubeClientQPS         int
-	KubeClientBurst       int
-	AWSNodeNameConvention string
+	ClusterName                 string
+	ClusterEndpoint             string
+	MetricsPort                 int
+	HealthProbePort             int
+	DebugPort                   int
+	LeaderElectionNamespace     string
+	LeaderElectionLeaseDuration time.Duration
+	LeaderElectionRenewDeadline time.Duration
+	LeaderElectionRetryPeriod   time.Duration
+	WebhookPort                 int
+	KubeClientQPS               int
+	KubeClientBurst             int
+	AWSNodeNameConvention       string
+	AWSENILimitedPodDensity     bool
+	AWSDefaultInstanceProfile   string
+	AWSDefaultKMSKeyID          string
+	AWSEndpointOverrides        string
+	AWSInterruptionQueueName    string
+	CloudProviderPluginAddress  string
+	PendingPodRequeueInterval   time.Duration
+	PlacementDecisionTTL        time.Duration
+	ProvisioningMetrics         bool
+	TracingEndpoint             string
+	TracingSampleRatio          float64
+	AuditSink                   string
+	HealthWindow                time.Duration
+	HealthErrorRateThreshold    float64
+	HealthLatencyThreshold      time.Duration
+	FeatureGates                string
 }
 
 func (o Options) Validate() (err error) {
 	err = multierr.Append(err, o.validateEndpoint())
+	err = multierr.Append(err, o.validateAuditSink())
+	if _, e := o.AWSEndpoints(); e != nil {
+		err = multierr.Append(err, e)
+	}
+	if _, e := o.GetFeatureGates(); e != nil {
+		err = multierr.Append(err, e)
+	}
 	if o.ClusterName == "" {
 		err = multierr.Append(err, fmt.Errorf("CLUSTER_NAME is required"))
 	}
 	if o.AWSNodeNameConvention != "ip-name" && o.AWSNodeNameConvention != "resource-name" {
 		err = multierr.Append(err, fmt.Errorf("aws-node-name-convention may only be either ip-name or resource-name"))
 	}
+	if o.PendingPodRequeueInterval <= 0 {
+		err = multierr.Append(err, fmt.Errorf("pending-pod-requeue-interval must be positive"))
+	}
+	if o.PlacementDecisionTTL < 0 {
+		err = multierr.Append(err, fmt.Errorf("placement-decision-ttl must not be negative"))
+	}
+	if o.LeaderElectionRetryPeriod <= 0 {
+		err = multierr.Append(err, fmt.Errorf("leader-election-retry-period must be positive"))
+	}
+	// Leader election jitters the retry period by up to 20%
+	if float64(o.LeaderElectionRenewDeadline) <= 1.2*float64(o.LeaderElectionRetryPeriod) {
+		err = multierr.Append(err, fmt.Errorf("leader-election-renew-deadline must be greater than 1.2 times leader-election-retry-period"))
+	}
+	if o.LeaderElectionLeaseDuration <= o.LeaderElectionRenewDeadline {
+		err = multierr.Append(err, fmt.Errorf("leader-election-lease-duration must be greater than leader-election-renew-deadline"))
+	}
+	if o.DebugPort < 0 {
+		err = multierr.Append(err, fmt.Errorf("debug-port must not be negative"))
+	}
+	if o.TracingSampleRatio < 0 || o.TracingSampleRatio > 1 {
+		err = multierr.Append(err, fmt.Errorf("tracing-sample-ratio must be between 0 and 1"))
+	}
+	if o.HealthWindow <= 0 {
+		err = multierr.Append(err, fmt.Errorf("health-window must be positive"))
+	}
+	if o.HealthErrorRateThreshold < 0 || o.HealthErrorRateThreshold > 1 {
+		err = multierr.Append(err, fmt.Errorf("health-error-rate-threshold must be between 0 and 1"))
+	}
+	if o.HealthLatencyThreshold <= 0 {
+		err = multierr.Append(err, fmt.Errorf("health-latency-threshold must be positive"))
+	}
 	return err
 }
 
@@ -72,3 +162,45 @@ func (o Options) validateEndpoint() error {
 	}
 	return nil
 }
+
+func (o Options) validateAuditSink() error {
+	if o.AuditSink == "" {
+		return nil
+	}
+	sink, err := url.Parse(o.AuditSink)
+	if err != nil {
+		return fmt.Errorf("\"%s\" not a valid audit-sink URI", o.AuditSink)
+	}
+	switch sink.Scheme {
+	case "file", "s3", "http", "https":
+		return nil
+	default:
+		return fmt.Errorf("audit-sink must be a file://, s3:// or http(s):// URI, but found \"%s\"", o.AuditSink)
+	}
+}
+
+// AWSEndpoints returns the overridden AWS API endpoints by the SDK's service
+// endpoint ID, e.g. ec2, ssm or api.pricing
+func (o Options) AWSEndpoints() (map[string]string, error) {
+	overrides := map[string]string{}
+	if o.AWSEndpointOverrides == "" {
+		return overrides, nil
+	}
+	for _, pair := range strings.Split(o.AWSEndpointOverrides, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("aws-endpoint-overrides must be a comma separated list of service=URL pairs, but found \"%s\"", pair)
+		}
+		endpoint, err := url.Parse(parts[1])
+		if err != nil || !endpoint.IsAbs() || endpoint.Hostname() == "" {
+			return nil, fmt.Errorf("\"%s\" not a valid URL for the %s endpoint", parts[1], parts[0])
+		}
+		overrides[parts[0]] = parts[1]
+	}
+	return overrides, nil
+}
+
+// GetFeatureGates returns the features that are enabled or disabled by gate
+func (o Options) GetFeatureGates() (featuregates.FeatureGates, error) {
+	return featuregates.Parse(o.FeatureGates)
+}
@@ -67,3 +67,43 @@ func IsOwnedBy(pod *v1.Pod, gvks []schema.GroupVersionKind) bool {
 	}
 	return false
 }
+
+// HostPorts returns the container ports of the pod that are bound to the host
+func HostPorts(pod *v1.Pod) []v1.ContainerPort {
+	ports := []v1.ContainerPort{}
+	for _, container := range pod.Spec.Containers {
+		for _, port := range container.Ports {
+			if port.HostPort != 0 {
+				ports = append(ports, port)
+			}
+		}
+	}
+	return ports
+}
+
+// HostPortsConflict returns true if the ports cannot be bound on the same
+// host, following the semantics of the kube scheduler's NodePorts plugin
+func HostPortsConflict(a v1.ContainerPort, b v1.ContainerPort) bool {
+	if a.HostPort != b.HostPort || protocolOrDefault(a.Protocol) != protocolOrDefault(b.Protocol) {
+		return false
+	}
+	return hostIPOrDefault(a.HostIP) == hostIPOrDefault(b.HostIP) ||
+		hostIPOrDefault(a.HostIP) == defaultHostIP ||
+		hostIPOrDefault(b.HostIP) == defaultHostIP
+}
+
+const defaultHostIP = "0.0.0.0"
+
+func protocolOrDefault(protocol v1.Protocol) v1.Protocol {
+	if protocol == "" {
+		return v1.ProtocolTCP
+	}
+	return protocol
+}
+
+func hostIPOrDefault(hostIP string) string {
+	if hostIP == "" {
+		return defaultHostIP
+	}
+	return hostIP
+}
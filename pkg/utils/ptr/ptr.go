@@ -40,3 +40,10 @@ func Int64Value(ptr *int64) int64 {
 	}
 	return *ptr
 }
+
+func Int32Value(ptr *int32) int32 {
+	if ptr == nil {
+		return 0
+	}
+	return *ptr
+}
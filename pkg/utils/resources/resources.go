@@ -15,15 +15,21 @@ limitations under the License.
 package resources
 
 import (
+	"fmt"
+	"sort"
+	"strings"
+
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 )
 
 const (
-	NvidiaGPU = "nvidia.com/gpu"
-	AMDGPU    = "amd.com/gpu"
-	AWSNeuron = "aws.amazon.com/neuron"
-	AWSPodENI = "vpc.amazonaws.com/pod-eni"
+	NvidiaGPU   = "nvidia.com/gpu"
+	AMDGPU      = "amd.com/gpu"
+	AWSNeuron   = "aws.amazon.com/neuron"
+	HabanaGaudi = "habana.ai/gaudi"
+	AWSPodENI   = "vpc.amazonaws.com/pod-eni"
+	AWSEFA      = "vpc.amazonaws.com/efa"
 )
 
 // RequestsForPods returns the total resources of a variadic list of podspecs.
@@ -54,13 +60,22 @@ func LimitsForPods(pods ...*v1.Pod) v1.ResourceList {
 func GPULimitsFor(pod *v1.Pod) v1.ResourceList {
 	resources := v1.ResourceList{}
 	for key, value := range LimitsForPods(pod) {
-		if key == AMDGPU || key == AWSNeuron || key == NvidiaGPU {
+		if key == AMDGPU || key == AWSNeuron || key == NvidiaGPU || key == HabanaGaudi {
 			resources[key] = value
 		}
 	}
 	return resources
 }
 
+// IsExtended returns true if the resource is an extended resource, i.e. one
+// that is advertised by a device plugin or an operator rather than natively by
+// the kubelet, such as nvidia.com/gpu or vendor.com/foo.
+func IsExtended(name v1.ResourceName) bool {
+	return strings.Contains(string(name), "/") &&
+		!strings.Contains(string(name), v1.ResourceDefaultNamespacePrefix) &&
+		!strings.HasPrefix(string(name), v1.DefaultResourceRequestsPrefix)
+}
+
 // Merge the resources from the variadic into a single v1.ResourceList
 func Merge(resources ...v1.ResourceList) v1.ResourceList {
 	result := v1.ResourceList{}
@@ -74,6 +89,17 @@ func Merge(resources ...v1.ResourceList) v1.ResourceList {
 	return result
 }
 
+// String formats the resources as a comma separated list sorted by name, e.g.
+// cpu=1,memory=1Gi
+func String(resources v1.ResourceList) string {
+	pairs := []string{}
+	for resourceName, quantity := range resources {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", resourceName, quantity.String()))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
 // Quantity parses the string value into a *Quantity
 func Quantity(value string) *resource.Quantity {
 	r := resource.MustParse(value)
@@ -0,0 +1,162 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package settings
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/karpenter/pkg/utils/featuregates"
+	"go.uber.org/multierr"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"knative.dev/pkg/configmap"
+	"knative.dev/pkg/configmap/informer"
+	"knative.dev/pkg/logging"
+	"knative.dev/pkg/system"
+)
+
+// ConfigMapName is the name of the ConfigMap in Karpenter's namespace that
+// settings are loaded from
+const ConfigMapName = "karpenter-global-settings"
+
+// Settings configure Karpenter's controllers. Unlike options, they may be
+// changed while Karpenter runs, by editing the ConfigMap.
+type Settings struct {
+	// BatchMaxDuration is the maximum duration that pending pods are batched
+	// for before nodes are launched for them
+	BatchMaxDuration time.Duration
+	// BatchIdleDuration ends a batch early if no pending pods arrive for the
+	// duration
+	BatchIdleDuration time.Duration
+	// DefaultInstanceFamilies restrict the instance types of provisioners
+	// that don't constrain their instance types, e.g. to m5 and c5. If empty,
+	// every instance type may be launched.
+	DefaultInstanceFamilies sets.String
+	// AWSInterruptionQueueName overrides the queue that interruption events
+	// are received from
+	AWSInterruptionQueueName string
+	// FeatureGates enable or disable features, overriding the feature gates
+	// of the options
+	FeatureGates featuregates.FeatureGates
+	// DisruptionBudget limits the number, or percentage, of Karpenter's nodes
+	// across all provisioners that may be terminating when a node is
+	// voluntarily disrupted. If nil, disruption is only limited by the budgets
+	// of provisioners.
+	DisruptionBudget *intstr.IntOrString
+}
+
+// Defaults are used if the ConfigMap doesn't exist, and for keys that
+// it doesn't specify
+var Defaults = Settings{
+	BatchMaxDuration:  10 * time.Second,
+	BatchIdleDuration: time.Second,
+}
+
+// NewSettingsFromConfigMap parses the settings of the ConfigMap. Feature gates
+// are a comma separated list of gate=bool pairs, e.g. Drift=true.
+func NewSettingsFromConfigMap(configMap *v1.ConfigMap) (Settings, error) {
+	settings := Defaults
+	featureGates := ""
+	disruptionBudget := ""
+	if err := configmap.Parse(configMap.Data,
+		configmap.AsDuration("batchMaxDuration", &settings.BatchMaxDuration),
+		configmap.AsDuration("batchIdleDuration", &settings.BatchIdleDuration),
+		configmap.AsStringSet("defaultInstanceFamilies", &settings.DefaultInstanceFamilies),
+		configmap.AsString("aws.interruptionQueueName", &settings.AWSInterruptionQueueName),
+		configmap.AsString("featureGates", &featureGates),
+		configmap.AsString("disruptionBudget", &disruptionBudget),
+	); err != nil {
+		return Settings{}, fmt.Errorf("parsing %s, %w", ConfigMapName, err)
+	}
+	settings.DefaultInstanceFamilies.Delete("")
+	gates, err := featuregates.Parse(featureGates)
+	if err != nil {
+		return Settings{}, fmt.Errorf("parsing %s, %w", ConfigMapName, err)
+	}
+	settings.FeatureGates = gates
+	if disruptionBudget != "" {
+		budget := intstr.Parse(disruptionBudget)
+		settings.DisruptionBudget = &budget
+	}
+	if err := settings.Validate(); err != nil {
+		return Settings{}, err
+	}
+	return settings, nil
+}
+
+// Validate returns an error if the settings are out of range
+func (s Settings) Validate() (err error) {
+	if s.BatchMaxDuration <= 0 {
+		err = multierr.Append(err, fmt.Errorf("batchMaxDuration must be positive"))
+	}
+	if s.BatchIdleDuration <= 0 {
+		err = multierr.Append(err, fmt.Errorf("batchIdleDuration must be positive"))
+	}
+	if s.BatchIdleDuration > s.BatchMaxDuration {
+		err = multierr.Append(err, fmt.Errorf("batchIdleDuration must not be longer than batchMaxDuration"))
+	}
+	if s.DisruptionBudget != nil {
+		// Scaling a percentage to 100 returns the percentage
+		if budget, scaleErr := intstr.GetScaledValueFromIntOrPercent(s.DisruptionBudget, 100, true); scaleErr != nil {
+			err = multierr.Append(err, fmt.Errorf("disruptionBudget must be a number or a percentage"))
+		} else if budget < 0 || (s.DisruptionBudget.Type == intstr.String && budget > 100) {
+			err = multierr.Append(err, fmt.Errorf("disruptionBudget must be between 0 and 100%%"))
+		}
+	}
+	return err
+}
+
+// Store holds the latest settings, which are replaced whenever the ConfigMap
+// changes. Controllers get them from the context on every use, so that
+// changes take effect without restarting.
+type Store struct {
+	value atomic.Value
+}
+
+// NewStore constructs a store holding the settings
+func NewStore(settings Settings) *Store {
+	store := &Store{}
+	store.value.Store(settings)
+	return store
+}
+
+// Get returns the latest settings
+func (s *Store) Get() Settings {
+	return s.value.Load().(Settings)
+}
+
+// Watch updates the store whenever the ConfigMap changes. The default
+// settings are used if the ConfigMap doesn't exist or is deleted. Invalid
+// settings are logged and ignored, keeping the previous ones.
+func (s *Store) Watch(ctx context.Context, cmw *informer.InformedWatcher) {
+	cmw.WatchWithDefault(v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: ConfigMapName, Namespace: system.Namespace()}}, func(configMap *v1.ConfigMap) {
+		s.update(ctx, configMap)
+	})
+}
+
+func (s *Store) update(ctx context.Context, configMap *v1.ConfigMap) {
+	settings, err := NewSettingsFromConfigMap(configMap)
+	if err != nil {
+		logging.FromContext(ctx).Errorf("Ignoring invalid settings, %s", err.Error())
+		return
+	}
+	s.value.Store(settings)
+	logging.FromContext(ctx).Infof("Updated settings from %s", ConfigMapName)
+}
@@ -0,0 +1,116 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package settings
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/karpenter/pkg/utils/featuregates"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+func TestSettings(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Settings Suite")
+}
+
+func configMap(data map[string]string) *v1.ConfigMap {
+	return &v1.ConfigMap{Data: data}
+}
+
+var _ = Describe("Settings", func() {
+	Context("Parsing", func() {
+		It("should default an empty config map", func() {
+			settings, err := NewSettingsFromConfigMap(configMap(nil))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(settings.BatchMaxDuration).To(Equal(Defaults.BatchMaxDuration))
+			Expect(settings.BatchIdleDuration).To(Equal(Defaults.BatchIdleDuration))
+			Expect(settings.DefaultInstanceFamilies).To(BeEmpty())
+			Expect(settings.AWSInterruptionQueueName).To(BeEmpty())
+			Expect(settings.FeatureGates).To(BeEmpty())
+			Expect(settings.DisruptionBudget).To(BeNil())
+		})
+		It("should parse every setting", func() {
+			settings, err := NewSettingsFromConfigMap(configMap(map[string]string{
+				"batchMaxDuration":          "20s",
+				"batchIdleDuration":         "2s",
+				"defaultInstanceFamilies":   "m5, c5",
+				"aws.interruptionQueueName": "test-queue",
+				"featureGates":              "Drift=true, SpotInterruption=false",
+				"disruptionBudget":          "10%",
+			}))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(settings.BatchMaxDuration).To(Equal(20 * time.Second))
+			Expect(settings.BatchIdleDuration).To(Equal(2 * time.Second))
+			Expect(settings.DefaultInstanceFamilies).To(Equal(sets.NewString("m5", "c5")))
+			Expect(settings.AWSInterruptionQueueName).To(Equal("test-queue"))
+			Expect(settings.FeatureGates).To(Equal(featuregates.FeatureGates{featuregates.Drift: true, featuregates.SpotInterruption: false}))
+			Expect(settings.DisruptionBudget).To(Equal(&intstr.IntOrString{Type: intstr.String, StrVal: "10%"}))
+		})
+		It("should parse disruption budgets of a number of nodes", func() {
+			settings, err := NewSettingsFromConfigMap(configMap(map[string]string{"disruptionBudget": "5"}))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(settings.DisruptionBudget).To(Equal(&intstr.IntOrString{Type: intstr.Int, IntVal: 5}))
+		})
+		It("should fail on malformed disruption budgets", func() {
+			for _, budget := range []string{"five", "-1", "150%", "10%%"} {
+				_, err := NewSettingsFromConfigMap(configMap(map[string]string{"disruptionBudget": budget}))
+				Expect(err).To(HaveOccurred(), budget)
+			}
+		})
+		It("should ignore empty instance families", func() {
+			settings, err := NewSettingsFromConfigMap(configMap(map[string]string{"defaultInstanceFamilies": ""}))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(settings.DefaultInstanceFamilies).To(BeEmpty())
+		})
+		It("should fail on malformed durations", func() {
+			_, err := NewSettingsFromConfigMap(configMap(map[string]string{"batchMaxDuration": "ten seconds"}))
+			Expect(err).To(HaveOccurred())
+		})
+		It("should fail on durations that aren't positive", func() {
+			_, err := NewSettingsFromConfigMap(configMap(map[string]string{"batchIdleDuration": "0s"}))
+			Expect(err).To(HaveOccurred())
+		})
+		It("should fail if the idle duration is longer than the max duration", func() {
+			_, err := NewSettingsFromConfigMap(configMap(map[string]string{"batchMaxDuration": "1s", "batchIdleDuration": "2s"}))
+			Expect(err).To(HaveOccurred())
+		})
+		It("should fail on malformed feature gates", func() {
+			for _, featureGates := range []string{"Drift", "=true", "Drift=maybe", "Unknown=true"} {
+				_, err := NewSettingsFromConfigMap(configMap(map[string]string{"featureGates": featureGates}))
+				Expect(err).To(HaveOccurred(), featureGates)
+			}
+		})
+	})
+	Context("Store", func() {
+		It("should update the settings", func() {
+			store := NewStore(Defaults)
+			store.update(context.Background(), configMap(map[string]string{"batchMaxDuration": "20s"}))
+			Expect(store.Get().BatchMaxDuration).To(Equal(20 * time.Second))
+		})
+		It("should keep the previous settings if the config map is invalid", func() {
+			store := NewStore(Defaults)
+			store.update(context.Background(), configMap(map[string]string{"batchMaxDuration": "20s"}))
+			store.update(context.Background(), configMap(map[string]string{"batchMaxDuration": "-1s"}))
+			Expect(store.Get().BatchMaxDuration).To(Equal(20 * time.Second))
+		})
+	})
+})